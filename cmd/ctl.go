@@ -155,7 +155,7 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 	defer stateStore.Close()
 
 	// Configure replication
-	replicationStop := configureReplication(cfg, stateStore)
+	replicator, replicationStop := configureReplication(cfg, stateStore)
 	defer replicationStop()
 
 	// Initialize network stack
@@ -165,14 +165,24 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 	}
 	defer netMgr.StopDHCPClientManager()
 
+	// Start VRRP once interfaces are configured
+	vrrpMgr, vrrpStop := configureVRRP(cfg, replicator)
+	defer vrrpStop()
+
 	// Apply static routes
 	if err := applyStaticRoutes(cfg, netMgr); err != nil {
 		logging.Error(err.Error())
 		os.Exit(1)
 	}
 
+	// Start dynamic routing once interfaces and static routes are in place
+	ospfRouter, ospfStop := configureOSPF(cfg)
+	defer ospfStop()
+	bgpSpeaker, bgpStop := configureBGP(cfg)
+	defer bgpStop()
+
 	// Initialize core services
-	services, err := initializeCoreServices(ctx, cfg, netMgr, stateStore)
+	services, err := initializeCoreServices(ctx, cfg, netMgr, stateStore, replicator)
 	if err != nil {
 		return err
 	}
@@ -190,6 +200,18 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 		os.Exit(1)
 	}
 	services.ctlServer.SetDisarmFunc(monitorsCancel)
+	if replicator != nil {
+		services.ctlServer.SetReplicator(replicator)
+	}
+	if vrrpMgr != nil {
+		services.ctlServer.SetVRRP(vrrpMgr)
+	}
+	if bgpSpeaker != nil {
+		services.ctlServer.SetBGP(bgpSpeaker)
+	}
+	if ospfRouter != nil {
+		services.ctlServer.SetOSPF(ospfRouter)
+	}
 
 	// Initialize additional services
 	initializeAdditionalServices(ctx, cfg, services)
@@ -197,6 +219,9 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 	// Initialize device management
 	initializeDeviceServices(ctx, cfg, services)
 
+	// Initialize DNS query log (needs device services for MAC lookup)
+	initializeDNSQueryLog(cfg, services)
+
 	// Initialize learning service
 	initializeLearningService(cfg, services)
 
@@ -205,6 +230,14 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 		go services.fwMgr.MonitorIntegrity(ctx, cfg)
 	}
 
+	// Issue Let's Encrypt certificates for the web UI, interfaces and DoH
+	acmeMgr, acmeStop := configureACME(monitorsCtx, cfg)
+	defer acmeStop()
+	var feed *proxyFeed
+	if acmeMgr != nil {
+		feed = newProxyFeed(acmeMgr, cfg)
+	}
+
 	// Normal run
 	var wg sync.WaitGroup
 
@@ -247,7 +280,7 @@ func runCtlOnce(configFile string, testMode bool, stateDir string, dryRun bool,
 		exe, err := os.Executable()
 		if err == nil {
 			wg.Add(1)
-			go spawnProxy(monitorsCtx, exe, cfg, &wg)
+			go spawnProxy(monitorsCtx, exe, cfg, feed, &wg)
 		}
 	}
 
@@ -445,8 +478,9 @@ func SaveClockAnchor() {
 	_ = os.WriteFile(clockAnchorFile, data, 0644)
 }
 
-// spawnProxy runs the TCP proxy as a child process and restarts it if it crashes
-func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.WaitGroup) {
+// spawnProxy runs the TCP proxy as a child process and restarts it if it crashes.
+// When feed is set, ACME certificates and challenges are streamed to it over a pipe.
+func spawnProxy(ctx context.Context, exe string, cfg *config.Config, feed *proxyFeed, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	// Backoff for restarts
@@ -512,6 +546,28 @@ func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.Wa
 			args = append(args, "-tls-cert", tlsCert, "-tls-key", tlsKey)
 		}
 
+		// Stream ACME state on fd 3, and answer HTTP-01 on the plain HTTP port
+		var acmeR, acmeW *os.File
+		if feed != nil && useTLS && tlsCert != "" && tlsKey != "" {
+			r, w, err := os.Pipe()
+			if err != nil {
+				logging.Error(fmt.Sprintf("Failed to create ACME pipe for Proxy: %v", err))
+			} else {
+				acmeR, acmeW = r, w
+				args = append(args, "-acme-fd", "3")
+				if feed.needsHTTP() {
+					httpListen := ":80"
+					if cfg.Web != nil && cfg.Web.Listen != "" {
+						httpListen = cfg.Web.Listen
+					}
+					args = append(args, "-http-listen", httpListen)
+					if cfg.Web != nil && cfg.Web.DisableRedirect {
+						args = append(args, "-no-redirect")
+					}
+				}
+			}
+		}
+
 		// Drop privileges to nobody (if root)
 		if os.Geteuid() == 0 {
 			args = append(args, "-user", "nobody")
@@ -524,6 +580,9 @@ func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.Wa
 		cmd := exec.Command(exe, args...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
+		if acmeR != nil {
+			cmd.ExtraFiles = []*os.File{acmeR}
+		}
 
 		// Environment cleanup (same as API)
 		newEnv := make([]string, 0, len(os.Environ()))
@@ -536,7 +595,16 @@ func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.Wa
 
 		logging.Info(fmt.Sprintf("Spawning Proxy: %v", args))
 
-		if err := cmd.Start(); err != nil {
+		err := cmd.Start()
+		if acmeR != nil {
+			acmeR.Close()
+			if err == nil {
+				feed.attach(acmeW)
+			} else {
+				acmeW.Close()
+			}
+		}
+		if err != nil {
 			logging.Error(fmt.Sprintf("Failed to start Proxy: %v", err))
 			failCount++
 			select {
@@ -557,6 +625,9 @@ func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.Wa
 				cmd.Process.Signal(syscall.SIGTERM)
 			}
 			<-done
+			if acmeW != nil {
+				feed.detach()
+			}
 			return
 		case err := <-done:
 			if err != nil {
@@ -565,6 +636,9 @@ func spawnProxy(ctx context.Context, exe string, cfg *config.Config, wg *sync.Wa
 				logging.Info("Proxy exited cleanly")
 			}
 		}
+		if acmeW != nil {
+			feed.detach()
+		}
 
 		time.Sleep(1 * time.Second)
 	}
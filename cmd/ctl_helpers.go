@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -16,30 +17,40 @@ import (
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 
+	"grimm.is/glacic/internal/acme"
 	"grimm.is/glacic/internal/brand"
 	"grimm.is/glacic/internal/config"
 	"grimm.is/glacic/internal/ctlplane"
 	"grimm.is/glacic/internal/device"
+	"grimm.is/glacic/internal/events"
 	fw "grimm.is/glacic/internal/firewall"
 	"grimm.is/glacic/internal/health"
+	"grimm.is/glacic/internal/ips"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/metrics"
 	"grimm.is/glacic/internal/network"
+	"grimm.is/glacic/internal/network/vrrp"
 	"grimm.is/glacic/internal/notification"
 	"grimm.is/glacic/internal/qos"
-	"grimm.is/glacic/internal/routing"
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
 	"grimm.is/glacic/internal/services/ddns"
 	"grimm.is/glacic/internal/services/dhcp"
 	"grimm.is/glacic/internal/services/discovery"
 	"grimm.is/glacic/internal/services/dns"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/hostmanager"
 	"grimm.is/glacic/internal/services/lldp"
 	"grimm.is/glacic/internal/services/mdns"
 	"grimm.is/glacic/internal/services/ntp"
+	"grimm.is/glacic/internal/services/portal"
 	"grimm.is/glacic/internal/services/ra"
+	"grimm.is/glacic/internal/services/snmp"
 	"grimm.is/glacic/internal/services/threatintel"
 	"grimm.is/glacic/internal/services/upnp"
 	"grimm.is/glacic/internal/state"
+	"grimm.is/glacic/internal/stats"
 	"grimm.is/glacic/internal/upgrade"
 	"grimm.is/glacic/internal/vpn"
 )
@@ -65,6 +76,7 @@ type ctlServices struct {
 	mdnsSvc         *mdns.Reflector
 	ntpSvc          *ntp.Service
 	dhcpSniffer     *dhcp.Sniffer
+	eventHub        *events.Hub
 
 	// Cleanup functions to call on shutdown
 	cleanupFuncs []func()
@@ -272,17 +284,18 @@ func initializeStateStore(rtCfg *CtlRuntimeConfig, cfg *config.Config) (state.St
 	return stateStore, nil
 }
 
-// configureReplication sets up state replication if configured.
-func configureReplication(cfg *config.Config, stateStore state.Store) func() {
+// configureReplication sets up state replication if configured. The
+// returned replicator is nil when replication is not running.
+func configureReplication(cfg *config.Config, stateStore state.Store) (*state.Replicator, func()) {
 	if cfg.Replication == nil {
-		return func() {}
+		return nil, func() {}
 	}
 
 	// Replication requires SQLiteStore
 	sqlStore, ok := stateStore.(*state.SQLiteStore)
 	if !ok {
 		logging.Warn("Replication requires SQLite store, skipping")
-		return func() {}
+		return nil, func() {}
 	}
 
 	logger := logging.WithComponent("replication")
@@ -290,6 +303,10 @@ func configureReplication(cfg *config.Config, stateStore state.Store) func() {
 	if cfg.Replication.Mode == "replica" {
 		mode = state.ModeReplica
 	}
+	if len(cfg.Replication.VRRP) > 0 {
+		// The role follows the VRRP election; see configureVRRP
+		mode = state.ModeStandby
+	}
 
 	repCfg := state.ReplicationConfig{
 		Mode:           mode,
@@ -297,20 +314,158 @@ func configureReplication(cfg *config.Config, stateStore state.Store) func() {
 		PrimaryAddr:    cfg.Replication.PrimaryAddr,
 		ReconnectDelay: 5 * time.Second,
 		SyncTimeout:    30 * time.Second,
+		SecretKey:      cfg.Replication.SecretKey,
+		TLSCertFile:    cfg.Replication.TLSCert,
+		TLSKeyFile:     cfg.Replication.TLSKey,
+		TLSCAFile:      cfg.Replication.TLSCA,
 	}
 
 	if repCfg.ListenAddr == "" {
-		repCfg.ListenAddr = ":9999"
+		repCfg.ListenAddr = defaultReplicationListenAddr
 	}
 
 	replicator := state.NewReplicator(sqlStore, repCfg, logger)
+	if cfg.Replication.ConntrackSync {
+		replicator.SetFlowSyncer(fw.NewConntrackSync(configuredAddrs(cfg), logging.WithComponent("conntrack-sync")))
+	}
 	if err := replicator.Start(); err != nil {
 		logging.Error(fmt.Sprintf("Failed to start replication: %v", err))
-		return func() {}
+		return nil, func() {}
 	}
 
 	logging.Info(fmt.Sprintf("Replication started in %s mode", mode))
-	return replicator.Stop
+	return replicator, replicator.Stop
+}
+
+// configuredAddrs returns the static interface addresses of this node.
+// Conntrack sync skips flows to and from them; virtual IPs are not
+// included since they move with the master.
+func configuredAddrs(cfg *config.Config) []netip.Addr {
+	var addrs []netip.Addr
+	add := func(cidrs []string) {
+		for _, s := range cidrs {
+			if p, err := netip.ParsePrefix(s); err == nil {
+				addrs = append(addrs, p.Addr())
+			}
+		}
+	}
+	for _, iface := range cfg.Interfaces {
+		add(iface.IPv4)
+		add(iface.IPv6)
+		for _, vlan := range iface.VLANs {
+			add(vlan.IPv4)
+			add(vlan.IPv6)
+		}
+	}
+	return addrs
+}
+
+// defaultReplicationListenAddr is used when replication.listen_addr is unset.
+const defaultReplicationListenAddr = ":9999"
+
+// configureVRRP starts the VRRP virtual routers. The master of the first
+// instance runs as replication primary; the other nodes replicate from
+// the master address learned from its advertisements.
+func configureVRRP(cfg *config.Config, replicator *state.Replicator) (*vrrp.Manager, func()) {
+	if cfg.Replication == nil || len(cfg.Replication.VRRP) == 0 {
+		return nil, func() {}
+	}
+
+	mgr, err := vrrp.NewManager(cfg.Replication.VRRP, logging.WithComponent("vrrp"))
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to configure VRRP: %v", err))
+		return nil, func() {}
+	}
+
+	if replicator != nil {
+		first := cfg.Replication.VRRP[0]
+		listenAddr := cfg.Replication.ListenAddr
+		if listenAddr == "" {
+			listenAddr = defaultReplicationListenAddr
+		}
+		_, port, _ := net.SplitHostPort(listenAddr)
+
+		mgr.OnTransition(func(t vrrp.Transition) {
+			if t.Interface != first.Interface || t.VRID != first.VRID {
+				return
+			}
+			var err error
+			switch {
+			case t.State == vrrp.StateMaster:
+				err = replicator.SwitchMode(state.ModePrimary, "")
+			case t.MasterIP.IsValid():
+				host := t.MasterIP.String()
+				if t.MasterIP.IsLinkLocalUnicast() {
+					host += "%" + t.Interface
+				}
+				err = replicator.SwitchMode(state.ModeReplica, net.JoinHostPort(host, port))
+			}
+			if err != nil {
+				logging.Error(fmt.Sprintf("Failed to switch replication role: %v", err))
+			}
+		})
+	}
+
+	if err := mgr.Start(); err != nil {
+		logging.Error(fmt.Sprintf("Failed to start VRRP: %v", err))
+		return nil, func() {}
+	}
+	return mgr, mgr.Stop
+}
+
+// configureOSPF starts the native OSPFv2/v3 router.
+func configureOSPF(cfg *config.Config) (*ospf.Router, func()) {
+	if cfg.FRR == nil || !cfg.FRR.Enabled || cfg.FRR.OSPF == nil {
+		return nil, func() {}
+	}
+
+	router, err := ospf.NewRouter(cfg.FRR.OSPF, logging.WithComponent("ospf"))
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to configure OSPF: %v", err))
+		return nil, func() {}
+	}
+	if err := router.Start(); err != nil {
+		logging.Error(fmt.Sprintf("Failed to start OSPF: %v", err))
+		return nil, func() {}
+	}
+	return router, router.Stop
+}
+
+// configureBGP starts the native BGP speaker.
+func configureBGP(cfg *config.Config) (*bgp.Speaker, func()) {
+	if cfg.FRR == nil || !cfg.FRR.Enabled || cfg.FRR.BGP == nil {
+		return nil, func() {}
+	}
+
+	speaker, err := bgp.NewSpeaker(cfg.FRR.BGP, logging.WithComponent("bgp"))
+	if err != nil {
+		logging.Error(fmt.Sprintf("Failed to configure BGP: %v", err))
+		return nil, func() {}
+	}
+	if err := speaker.Start(); err != nil {
+		logging.Error(fmt.Sprintf("Failed to start BGP: %v", err))
+		return nil, func() {}
+	}
+	return speaker, speaker.Stop
+}
+
+// acmeRenewInterval is how often ACME certificates are checked for renewal.
+const acmeRenewInterval = 12 * time.Hour
+
+// configureACME starts issuing and renewing the Let's Encrypt certificates
+// the configuration asks for.
+func configureACME(ctx context.Context, cfg *config.Config) (*acme.Manager, func()) {
+	certs := acme.CertificatesFor(cfg)
+	if len(certs) == 0 {
+		return nil, func() {}
+	}
+
+	mgr := acme.NewManager(acme.ConfigFor(cfg), logging.WithComponent("acme"))
+	mgr.SetCertificates(certs)
+	ctx, cancel := context.WithCancel(ctx)
+	mgr.StartAutoRenew(ctx, acmeRenewInterval)
+	logging.Info(fmt.Sprintf("ACME enabled for %d certificate(s)", len(certs)))
+	return mgr, cancel
 }
 
 // initializeNetworkStack sets up the network manager and applies interface config.
@@ -328,6 +483,10 @@ func initializeNetworkStack(cfg *config.Config) (*network.Manager, error) {
 
 	netMgr.InitializeDHCPClientManager(cfg.DHCP)
 
+	if err := netMgr.RemoveStaleInterfaces(cfg.Interfaces); err != nil {
+		logging.Warn(fmt.Sprintf("Warning: failed to remove stale interfaces: %v", err))
+	}
+
 	for _, iface := range cfg.Interfaces {
 		logging.Info(fmt.Sprintf(" applying configuration for interface: %s", iface.Name))
 		if err := netMgr.ApplyInterface(iface); err != nil {
@@ -372,10 +531,11 @@ func applyStaticRoutes(cfg *config.Config, netMgr *network.Manager) error {
 }
 
 // initializeCoreServices creates the core services (DNS, DHCP, Firewall, etc.)
-func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *network.Manager, stateStore state.Store) (*ctlServices, error) {
+func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *network.Manager, stateStore state.Store, replicator *state.Replicator) (*ctlServices, error) {
 	services := &ctlServices{
 		stateStore: stateStore,
 		netMgr:     netMgr,
+		eventHub:   events.NewHub(),
 	}
 
 	// Syslog Forwarding
@@ -405,6 +565,7 @@ func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *net
 	// DNS Service
 	dnsLogger := logging.WithComponent("dns")
 	services.dnsSvc = dns.NewService(cfg, dnsLogger)
+	services.dnsSvc.SetEventHub(services.eventHub)
 	netMgr.SetDNSUpdater(services.dnsSvc)
 
 	// Initialize if either legacy or new config is present
@@ -430,6 +591,9 @@ func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *net
 	// DHCP Service
 	services.dhcpSvc = dhcp.NewService(services.dnsSvc, stateStore)
 	services.dhcpSvc.SetUpgradeManager(services.upgradeMgr)
+	if replicator != nil {
+		services.dhcpSvc.SetFailoverPeer(replicator)
+	}
 	if cfg.DHCP != nil {
 		if _, err := services.dhcpSvc.Reload(cfg); err != nil {
 			logging.Error(fmt.Sprintf("Error initializing DHCP service: %v", err))
@@ -554,6 +718,15 @@ func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *net
 				// Set callback for integrity restore events
 				fwMgr.SetIntegrityRestoreCallback(services.dnsSvc.SyncFirewall)
 			}
+
+			// Keep delegated prefix sets in step with DHCPv6-PD
+			if services.netMgr != nil {
+				services.netMgr.AddPrefixListener(func(a network.PrefixAssignment) {
+					if err := fwMgr.SetDelegatedPrefix(a.Interface, a.Prefix); err != nil {
+						logging.Warn(fmt.Sprintf("Failed to update delegated prefix set for %s: %v", a.Interface, err))
+					}
+				})
+			}
 		}
 	}
 
@@ -561,6 +734,17 @@ func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *net
 	// Must be done AFTER firewall is initialized because it uses nftables chains
 	services.uplinkManager = network.NewUplinkManager()
 
+	// Uplinks on PPPoE links follow the session's address
+	if services.netMgr != nil {
+		services.netMgr.AddPPPoEListener(func(s network.PPPoESession) {
+			var localIP, peerIP string
+			if s.Up {
+				localIP, peerIP = s.LocalIP.String(), s.PeerIP.String()
+			}
+			services.uplinkManager.SetLinkState(s.Link, s.Up, localIP, peerIP)
+		})
+	}
+
 	// Ensure required nftables chains exist (UplinkManager fallback mode expects them)
 	// mark_prerouting: for connection marking
 	// nat_postrouting: for SNAT
@@ -622,13 +806,7 @@ func initializeCoreServices(ctx context.Context, cfg *config.Config, netMgr *net
 		logging.Error(fmt.Sprintf("Error initializing UplinkManager: %v", err))
 	} else {
 		// Set notification callback
-		services.uplinkManager.SetHealthCallback(func(uplink *network.Uplink, healthy bool) {
-			status := "UP"
-			if !healthy {
-				status = "DOWN"
-			}
-			logging.Info(fmt.Sprintf("[Uplink] %s is now %s", uplink.Name, status))
-		})
+		services.uplinkManager.SetHealthCallback(logUplinkHealth)
 
 		// Start health checking
 		// Use fast interval for responsiveness (critical for tests)
@@ -690,15 +868,6 @@ func initializeAdditionalServices(ctx context.Context, cfg *config.Config, servi
 		}
 	}
 
-	// FRR Dynamic Routing
-	if cfg.FRR != nil {
-		if err := routing.ConfigureFRR(cfg.FRR); err != nil {
-			logging.Error(fmt.Sprintf("Error configuring FRR: %v", err))
-		} else {
-			logging.Info("Dynamic Routing (FRR) configured.")
-		}
-	}
-
 	// 6to4 Tunnels
 	if cfg.VPN != nil && len(cfg.VPN.SixToFour) > 0 {
 		logging.Info("Configuring 6to4 tunnels...")
@@ -719,6 +888,12 @@ func initializeAdditionalServices(ctx context.Context, cfg *config.Config, servi
 		if iface.RA {
 			raSvc := ra.NewService(cfg)
 			raSvc.Start()
+			// Advertise prefixes delegated by the upstream ISP
+			if services.netMgr != nil {
+				services.netMgr.AddPrefixListener(func(a network.PrefixAssignment) {
+					raSvc.SetDelegatedPrefix(a.Interface, a.Prefix, a.Previous, a.PreferredLifetime, a.ValidLifetime)
+				})
+			}
 			logging.Info("IPv6 RA Service started.")
 			break
 		}
@@ -775,6 +950,22 @@ func initializeAdditionalServices(ctx context.Context, cfg *config.Config, servi
 		}
 	}
 
+	// Captive portal for guest zones
+	if len(config.CaptivePortalInterfaces(cfg.Zones, cfg.Interfaces)) > 0 && services.fwMgr != nil {
+		portalSvc := portal.NewService(cfg.CaptivePortal, services.fwMgr, filepath.Join(brand.GetStateDir(), "captive_portal.json"))
+		if err := portalSvc.Start(ctx); err != nil {
+			logging.Error(fmt.Sprintf("Error starting captive portal: %v", err))
+		} else {
+			logging.Info("Captive portal started.")
+			services.addCleanup(portalSvc.Stop)
+		}
+	}
+
+	// SNMP agent
+	if cfg.SNMP != nil && cfg.SNMP.Enabled {
+		initializeSNMPAgent(ctx, cfg, services)
+	}
+
 	// NTP Service
 	if cfg.NTP != nil && cfg.NTP.Enabled {
 		services.ntpSvc = ntp.NewService(logging.WithComponent("ntp"))
@@ -791,7 +982,126 @@ func initializeAdditionalServices(ctx context.Context, cfg *config.Config, servi
 	// Notification Dispatcher
 	if cfg.Notifications != nil {
 		services.dispatcher = notification.NewDispatcher(cfg.Notifications, logging.WithComponent("notification"))
+		if services.dhcpSvc != nil {
+			services.dhcpSvc.SetDispatcher(services.dispatcher)
+		}
+	}
+
+	// Inline IPS (after the dispatcher so alerts can notify)
+	if cfg.IPS != nil && cfg.IPS.Enabled {
+		initializeIPS(cfg, services)
+	}
+}
+
+// initializeSNMPAgent starts the SNMP agent together with the collectors
+// it reads from, and sends traps on uplink health changes and failovers.
+func initializeSNMPAgent(ctx context.Context, cfg *config.Config, services *ctlServices) {
+	metricsCollector := metrics.NewCollector(logging.WithComponent("metrics"), 30*time.Second)
+	statsCollector := stats.NewCollector(2*time.Second, stats.WithFetcher(&stats.NFTFetcher{}))
+
+	agent, err := snmp.NewAgent(cfg.SNMP, snmp.Sources{
+		Metrics: metricsCollector,
+		Stats:   statsCollector,
+		Uplinks: services.uplinkManager,
+	}, filepath.Join(brand.GetStateDir(), "snmp_engine.json"))
+	if err == nil {
+		err = agent.Start(ctx)
+	}
+	if err != nil {
+		logging.Error(fmt.Sprintf("Error starting SNMP agent: %v", err))
+		return
+	}
+
+	go metricsCollector.Start()
+	statsCollector.Start()
+	if services.dhcpSvc != nil {
+		go feedDHCPStats(ctx, metricsCollector, services.dhcpSvc)
+	}
+	if services.uplinkManager != nil {
+		services.uplinkManager.SetHealthCallback(func(uplink *network.Uplink, healthy bool) {
+			logUplinkHealth(uplink, healthy)
+			agent.UplinkHealthChanged(uplink.Name, uplink.Interface, healthy)
+		})
+		services.uplinkManager.SetSwitchCallback(func(group string, from, to *network.Uplink) {
+			var fromName, toName string
+			if from != nil {
+				fromName = from.Name
+			}
+			if to != nil {
+				toName = to.Name
+			}
+			agent.UplinkSwitched(group, fromName, toName)
+		})
+	}
+
+	logging.Info("SNMP agent started.")
+	services.addCleanup(func() {
+		agent.Stop()
+		statsCollector.Stop()
+		metricsCollector.Stop()
+	})
+}
+
+// initializeIPS loads the IPS signatures and binds the engine to the
+// queue that "inspect" policy rules send connections to.
+func initializeIPS(cfg *config.Config, services *ctlServices) {
+	engine := ips.NewEngine(cfg.IPS, logging.WithComponent("ips"))
+	if err := engine.Load(cfg.IPS); err != nil {
+		logging.Error(fmt.Sprintf("Error loading IPS rules: %v", err))
+		return
 	}
+	engine.SetEventHub(services.eventHub)
+	if services.dispatcher != nil {
+		engine.SetDispatcher(services.dispatcher)
+	}
+	services.ctlServer.SetIPS(engine)
+
+	reader := ctlplane.NewNFQueueReader(uint16(cfg.IPS.QueueNum()))
+	reader.SetQueueLength(uint32(cfg.IPS.QueueLen()))
+	reader.SetMaxPacketLen(65535) // Signatures need the whole payload
+	reader.SetFailOpen(cfg.IPS.FailOpen)
+	reader.SetPacketVerdictFunc(func(_ ctlplane.NFLogEntry, packet []byte) bool {
+		return engine.Inspect(packet)
+	})
+	if err := reader.Start(); err != nil {
+		logging.Error(fmt.Sprintf("Error starting IPS engine: %v", err))
+		return
+	}
+
+	logging.Info("IPS engine started.")
+	services.addCleanup(reader.Stop)
+}
+
+// feedDHCPStats periodically copies lease counts into the metrics collector.
+func feedDHCPStats(ctx context.Context, collector *metrics.Collector, svc *dhcp.Service) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		leases := svc.GetLeases()
+		active := 0
+		now := time.Now()
+		for _, l := range leases {
+			if l.Expiration.IsZero() || l.Expiration.After(now) {
+				active++
+			}
+		}
+		collector.UpdateDHCPStats(svc.IsRunning(), active, len(leases))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logUplinkHealth logs uplink health transitions.
+func logUplinkHealth(uplink *network.Uplink, healthy bool) {
+	status := "UP"
+	if !healthy {
+		status = "DOWN"
+	}
+	logging.Info(fmt.Sprintf("[Uplink] %s is now %s", uplink.Name, status))
 }
 
 // initializeDeviceServices sets up device management and discovery.
@@ -915,6 +1225,42 @@ func initializeDeviceServices(ctx context.Context, cfg *config.Config, services
 	}()
 }
 
+// initializeDNSQueryLog opens the DNS query log and wires it to the DNS
+// service and control plane. Recording only happens while a serve block
+// has query_logging set, so the store is opened whenever DNS is configured.
+func initializeDNSQueryLog(cfg *config.Config, services *ctlServices) {
+	if services.dnsSvc == nil || (cfg.DNS == nil && (cfg.DNSServer == nil || !cfg.DNSServer.Enabled)) {
+		return
+	}
+
+	// Client identity also drives filtering profile selection, so wire it
+	// up even if the query log cannot be opened.
+	var leases *state.DHCPBucket
+	if services.stateStore != nil {
+		if bucket, err := state.NewDHCPBucket(services.stateStore); err == nil {
+			leases = bucket
+		}
+	}
+	services.dnsSvc.SetDeviceLookup(device.NewUnifiedLookup(services.deviceMgr, services.deviceCollector, leases))
+
+	var retention time.Duration
+	if cfg.DNS != nil {
+		retention = time.Duration(cfg.DNS.QueryLogRetentionDays) * 24 * time.Hour
+	}
+
+	dbPath := filepath.Join(brand.GetStateDir(), "dns_queries.db")
+	store, err := querylog.NewStore(dbPath, retention)
+	if err != nil {
+		logging.Error(fmt.Sprintf("Error initializing DNS query log: %v", err))
+		return
+	}
+	store.Start()
+	services.addCleanup(store.Stop)
+
+	services.dnsSvc.SetQueryLog(store)
+	services.ctlServer.SetDNSQueryLog(store)
+}
+
 // initializeLearningService sets up the rule learning engine.
 func initializeLearningService(cfg *config.Config, services *ctlServices) {
 	if cfg.RuleLearning == nil || !cfg.RuleLearning.Enabled {
@@ -977,6 +1323,12 @@ func initializeLearningService(cfg *config.Config, services *ctlServices) {
 func startControlPlaneServer(cfg *config.Config, configFile string, netMgr *network.Manager, services *ctlServices, listeners map[string]interface{}) error {
 	services.ctlServer = ctlplane.NewServer(cfg, configFile, netMgr)
 	services.ctlServer.SetUpgradeManager(services.upgradeMgr)
+	services.ctlServer.SetDNSService(services.dnsSvc)
+
+	// Forward event bus messages to the API's WebSocket clients
+	bridge := events.NewWSBridge(services.eventHub, services.ctlServer.PublishEvent)
+	bridge.Start()
+	services.addCleanup(bridge.Stop)
 
 	if listeners != nil && listeners["ctl"] != nil {
 		logging.Info("Using injected control plane listener")
@@ -22,6 +22,9 @@ func RunProxy(args []string) {
 	noChroot := flags.Bool("no-chroot", false, "Skip chroot/sandbox setup")
 	tlsCert := flags.String("tls-cert", "", "TLS certificate file (enables HTTPS)")
 	tlsKey := flags.String("tls-key", "", "TLS private key file")
+	httpListen := flags.String("http-listen", "", "Plain HTTP address answering ACME HTTP-01 challenges")
+	noRedirect := flags.Bool("no-redirect", false, "Do not redirect plain HTTP requests to HTTPS")
+	acmeFD := flags.Int("acme-fd", 0, "File descriptor streaming ACME certificates and challenges")
 	flags.Parse(args)
 
 	// Logging
@@ -45,6 +48,17 @@ func RunProxy(args []string) {
 		logging.Info("TLS enabled for proxy", "cert", *tlsCert)
 	}
 
+	// ACME certificates are issued by the control plane and streamed in,
+	// since the jailed proxy cannot read the certificate store
+	var acmeUpdates *os.File
+	if *acmeFD > 0 {
+		acmeUpdates = os.NewFile(uintptr(*acmeFD), "acme-updates")
+		server.EnableACME()
+	}
+	if *httpListen != "" {
+		server.WithHTTP(*httpListen, !*noRedirect)
+	}
+
 	// Privilege Dropping
 	if *dropUser != "" {
 		if uid, gid, err := resolveDropUser(*dropUser); err == nil {
@@ -100,6 +114,14 @@ func RunProxy(args []string) {
 		os.Exit(1)
 	}
 
+	if acmeUpdates != nil {
+		go func() {
+			if err := server.ReadUpdates(acmeUpdates); err != nil {
+				logging.Error("ACME update stream failed: " + err.Error())
+			}
+		}()
+	}
+
 	// Wait for context
 	<-ctx.Done()
 	server.Wait()
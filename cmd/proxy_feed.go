@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"grimm.is/glacic/internal/acme"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/proxy"
+)
+
+// proxyFeed streams ACME certificates and challenge responses to the proxy
+// child process, which runs jailed and cannot read the certificate store.
+type proxyFeed struct {
+	mgr *acme.Manager
+	cfg *config.Config
+
+	mu sync.Mutex
+	w  *os.File
+}
+
+func newProxyFeed(mgr *acme.Manager, cfg *config.Config) *proxyFeed {
+	f := &proxyFeed{mgr: mgr, cfg: cfg}
+	mgr.SetOnChange(f.send)
+	return f
+}
+
+// needsHTTP reports whether any certificate is validated over HTTP-01,
+// which requires the proxy's plain HTTP listener.
+func (f *proxyFeed) needsHTTP() bool {
+	return slices.ContainsFunc(f.mgr.Certificates(), func(c acme.Certificate) bool {
+		return c.Challenge == "" || c.Challenge == acme.ChallengeHTTP01
+	})
+}
+
+// attach starts feeding a newly spawned proxy and sends it the current state.
+func (f *proxyFeed) attach(w *os.File) {
+	f.mu.Lock()
+	f.w = w
+	f.mu.Unlock()
+	f.send()
+}
+
+// detach closes the pipe to a proxy that has exited.
+func (f *proxyFeed) detach() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.w != nil {
+		f.w.Close()
+		f.w = nil
+	}
+}
+
+func (f *proxyFeed) send() {
+	update := f.update()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.w == nil {
+		return
+	}
+	if err := json.NewEncoder(f.w).Encode(update); err != nil {
+		logging.Warn(fmt.Sprintf("Failed to send ACME update to proxy: %v", err))
+	}
+}
+
+// update collects the issued certificates: the web certificate becomes the
+// proxy's default and interface certificates are served per interface.
+func (f *proxyFeed) update() proxy.Update {
+	u := proxy.Update{
+		Certificates: make(map[string]acme.KeyPair),
+		Challenges:   f.mgr.Challenges().Snapshot(),
+	}
+	names := map[string]string{acme.WebCertificate: ""}
+	for _, iface := range f.cfg.Interfaces {
+		names[acme.InterfaceCertificate(iface.Name)] = iface.Name
+	}
+	for _, c := range f.mgr.Certificates() {
+		iface, ok := names[c.Name]
+		if !ok {
+			continue
+		}
+		// Not issued yet
+		pair, err := f.mgr.KeyPair(c.Name)
+		if err != nil {
+			continue
+		}
+		u.Certificates[iface] = pair
+	}
+	return u
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"grimm.is/glacic/internal/ctlplane"
+	"grimm.is/glacic/internal/routing/ospf"
+)
+
+// RunShowOSPF prints the state of the running OSPF router. section is
+// "neighbors" (the default), "interfaces", "routes" or "database".
+func RunShowOSPF(section string) error {
+	client, err := ctlplane.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to control plane: %w", err)
+	}
+	defer client.Close()
+
+	if section == "database" {
+		lsas, err := client.GetOSPFDatabase()
+		if err != nil {
+			return fmt.Errorf("failed to get OSPF database: %w", err)
+		}
+		printOSPFDatabase(lsas)
+		return nil
+	}
+
+	status, enabled, err := client.GetOSPFStatus()
+	if err != nil {
+		return fmt.Errorf("failed to get OSPF status: %w", err)
+	}
+	if !enabled {
+		Printer.Println("OSPF is not running")
+		return nil
+	}
+
+	Printer.Printf("OSPF router ID %s\n\n", status.RouterID)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	switch section {
+	case "", "neighbors":
+		Printer.Fprintln(w, "NEIGHBOR\tVERSION\tSTATE\tROLE\tADDRESS\tINTERFACE\tAREA")
+		for _, n := range status.Neighbors {
+			Printer.Fprintf(w, "%s\tv%d\t%s\t%s\t%s\t%s\t%s\n", n.RouterID, n.Version, n.State, dash(n.Role), n.Address, n.Interface, n.Area)
+		}
+	case "interfaces":
+		Printer.Fprintln(w, "INTERFACE\tVERSION\tAREA\tTYPE\tSTATE\tCOST\tDR\tBDR\tNEIGHBORS")
+		for _, i := range status.Interfaces {
+			state := i.State
+			if i.Passive {
+				state += " (passive)"
+			}
+			Printer.Fprintf(w, "%s\tv%d\t%s\t%s\t%s\t%d\t%s\t%s\t%d\n", i.Name, i.Version, i.Area, i.Type, state, i.Cost, dash(i.DR), dash(i.BDR), i.Neighbors)
+		}
+	case "routes":
+		Printer.Fprintln(w, "PREFIX\tTYPE\tAREA\tCOST\tNEXT HOPS")
+		for _, r := range status.Routes {
+			var hops []string
+			for _, nh := range r.NextHops {
+				if nh.Address == "" {
+					hops = append(hops, "directly connected, "+nh.Interface)
+				} else {
+					hops = append(hops, nh.Address+" via "+nh.Interface)
+				}
+			}
+			Printer.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", r.Prefix, r.Type, dash(r.Area), r.Cost, strings.Join(hops, ", "))
+		}
+	default:
+		return fmt.Errorf("unknown section %q (want neighbors, interfaces, routes or database)", section)
+	}
+	return w.Flush()
+}
+
+func printOSPFDatabase(lsas []ospf.LSAStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	Printer.Fprintln(w, "VERSION\tAREA\tTYPE\tLINK STATE ID\tADV ROUTER\tAGE\tSEQUENCE\tCHECKSUM")
+	for _, l := range lsas {
+		scope := dash(l.Area)
+		if l.Interface != "" {
+			scope += " (" + l.Interface + ")"
+		}
+		Printer.Fprintf(w, "v%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", l.Version, scope, l.Type, l.LinkStateID, l.AdvRouter, l.Age, l.Sequence, l.Checksum)
+	}
+	w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -36,6 +36,36 @@ func RunStatus() {
 	Printer.Printf("Config:  %s\n", status.ConfigFile)
 	Printer.Println()
 
+	if rep := status.Replication; rep != nil {
+		Printer.Printf("Replication: %s (%s auth)\n", rep.Mode, rep.Auth)
+		if rep.Mode == "replica" {
+			link := "disconnected"
+			if rep.Connected {
+				link = "connected"
+			}
+			Printer.Printf("  Primary:      %s (%s)\n", rep.PrimaryAddr, link)
+			Printer.Printf("  Last applied: %d (lag %d)\n", rep.LastAppliedVersion, rep.Lag)
+			if rep.SyncedFlows > 0 {
+				Printer.Printf("  Synced flows: %d\n", rep.SyncedFlows)
+			}
+		} else {
+			Printer.Printf("  Version:      %d\n", rep.CurrentVersion)
+			for _, replica := range rep.Replicas {
+				Printer.Printf("  Replica:      %s at %d (lag %d)\n", replica.Addr, replica.Version, replica.Lag)
+			}
+		}
+		Printer.Println()
+	}
+
+	if len(status.VRRP) > 0 {
+		Printer.Println("VRRP:")
+		for _, inst := range status.VRRP {
+			Printer.Printf("  %-10s vrid %-3d %-7s prio %-3d %s (master %s)\n",
+				inst.Interface, inst.VRID, inst.State, inst.Priority, strings.Join(inst.VirtualIPs, ", "), inst.MasterIP)
+		}
+		Printer.Println()
+	}
+
 	// Get services
 	services, err := client.GetServices()
 	if err != nil {
@@ -1,7 +1,7 @@
 # Complex Routing Configuration (BGP, OSPF, Policy Routing)
 #
 # This example demonstrates advanced enterprise-grade features:
-# 1. Dynamic Routing via FRR (BGP & OSPF)
+# 1. Dynamic Routing (native OSPF & BGP)
 # 2. Custom Routing Tables
 # 3. Mark Rules for Traffic Classification
 # 4. Policy Routes based on Marks
@@ -35,28 +35,41 @@ interface "eth2" {
 }
 
 # -----------------------------------------------------------------------------
-# Dynamic Routing (FRR)
+# Dynamic Routing
 # -----------------------------------------------------------------------------
 
 frr {
   enabled = true
 
-  # OSPF for internal routing (Office LAN)
+  # OSPF for internal routing (built-in router, FRR not required)
   ospf {
     router_id = "10.255.0.2"
 
     area "0.0.0.0" {
       networks = ["10.10.0.0/24", "10.255.0.0/30"]
     }
+
+    # Advertise the Office LAN without sending hellos into it
+    interface "eth1" {
+      passive = true
+    }
   }
 
-  # BGP for external connectivity
+  # BGP for external connectivity (built-in speaker, FRR not required)
   bgp {
     asn       = 65001
     router_id = "10.255.0.2"
 
+    # Only accept a default route from the upstream
+    prefix_list "upstream-in" {
+      rule {
+        prefix = "0.0.0.0/0"
+      }
+    }
+
     neighbor "10.255.0.1" {
       remote_asn = 65000
+      import     = "upstream-in"
     }
 
     networks = ["10.20.0.0/24"] # Advertise Guest network via BGP
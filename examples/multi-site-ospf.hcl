@@ -0,0 +1,112 @@
+# Multi-Site Routing over WireGuard (OSPF)
+#
+# A branch office exchanging routes with headquarters over a WireGuard
+# tunnel. HQ runs the backbone (area 0) and is the area border router for
+# the branch areas, so this branch only sees HQ's summaries rather than
+# every other branch's networks.
+#
+# WireGuard notes:
+# - Use one WireGuard interface per site link, with a single peer. The
+#   peer's allowed_ips decide which tunnel traffic takes, so they must
+#   cover every network learned through it.
+# - OSPF packets are multicast: allowed_ips must include 224.0.0.5/32
+#   (OSPFv2) and ff02::5/128 (OSPFv3), plus the peer's tunnel and
+#   link-local addresses.
+# - WireGuard interfaces have no IPv6 link-local address of their own.
+#   Give each end one (fe80::x/64) for OSPFv3, which runs on the tunnel in
+#   the same area as its IPv4 address.
+
+schema_version = "1.0"
+ip_forwarding = true
+
+interface "eth0" {
+  description = "Internet"
+  zone        = "wan"
+  dhcp        = true
+}
+
+interface "eth1" {
+  description = "Branch LAN"
+  zone        = "lan"
+  ipv4        = ["10.1.1.1/24"]
+  ipv6        = ["2001:db8:1:1::1/64"]
+}
+
+vpn {
+  wireguard "hq" {
+    enabled          = true
+    interface        = "wg0"
+    zone             = "sites"
+    private_key_file = "/etc/glacic/wg0.key"
+    listen_port      = 51820
+    address          = ["10.1.255.2/30", "fe80::2/64"]
+
+    peer "hq" {
+      public_key           = "HQ_PUBLIC_KEY_BASE64="
+      endpoint             = "hq.example.com:51820"
+      persistent_keepalive = 25
+      allowed_ips = [
+        "10.1.255.1/32", "fe80::1/128",   # HQ's end of the tunnel
+        "224.0.0.5/32", "ff02::5/128",    # OSPF hellos and updates
+        "10.0.0.0/16", "2001:db8::/48",   # HQ networks
+      ]
+    }
+  }
+}
+
+# -----------------------------------------------------------------------------
+# Dynamic Routing
+# -----------------------------------------------------------------------------
+
+frr {
+  enabled = true
+
+  ospf {
+    router_id = "10.255.0.11"
+
+    # HQ's end of the tunnel is configured in the same area. A branch
+    # without its own internet access can be made a stub area instead
+    # (stub = true, plus no_summary = true for a default route only), as
+    # long as the WireGuard endpoint stays reachable outside the tunnel.
+    area "0.0.0.1" {
+      networks = ["10.1.0.0/16", "2001:db8:1::/48"]
+    }
+
+    interface "wg0" {
+      cost           = 100
+      hello_interval = 5 # Notice a dead tunnel within 20 seconds
+    }
+
+    interface "eth1" {
+      passive = true
+    }
+  }
+}
+
+# -----------------------------------------------------------------------------
+# Firewall Policies
+# -----------------------------------------------------------------------------
+
+policy "sites" "self" {
+  rule "allow-ospf" {
+    proto  = "ospf"
+    action = "accept"
+  }
+}
+
+policy "lan" "sites" {
+  action = "accept"
+}
+
+policy "sites" "lan" {
+  action = "accept"
+}
+
+policy "lan" "wan" {
+  action = "accept"
+}
+
+nat "outbound" {
+  type          = "masquerade"
+  out_interface = "eth0"
+}
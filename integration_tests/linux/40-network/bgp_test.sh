@@ -0,0 +1,168 @@
+#!/bin/sh
+set -x
+# Native BGP Integration Test
+# Two nodes peer over a veth pair without FRR. A imports B's routes into
+# table 100 through a prefix list and exports only part of its networks;
+# when B goes away, A withdraws what it learned.
+
+TEST_TIMEOUT=90
+. "$(dirname "$0")/../common.sh"
+export GLACIC_LOG_FILE=stdout
+
+plan 5
+
+A_DIR="/tmp/glacic_bgp_a"
+B_DIR="/tmp/glacic_bgp_b"
+rm -rf $A_DIR $B_DIR
+mkdir -p $A_DIR/state $B_DIR/state
+
+ip netns add ns_bgp_a
+ip netns add ns_bgp_b
+ip netns exec ns_bgp_a ip link set lo up
+ip netns exec ns_bgp_b ip link set lo up
+
+cleanup() {
+    pkill -F $A_DIR/pid 2>/dev/null
+    pkill -F $B_DIR/pid 2>/dev/null
+    ip netns del ns_bgp_a 2>/dev/null
+    ip netns del ns_bgp_b 2>/dev/null
+    rm -rf $A_DIR $B_DIR
+    rm -f /tmp/bgp_a.hcl /tmp/bgp_b.hcl
+}
+trap cleanup EXIT
+
+fail() {
+    echo "### TEST FAILED: $1"
+    echo "### NODE A LOG:"
+    cat $A_DIR/log
+    echo "### NODE B LOG:"
+    cat $B_DIR/log
+    exit 1
+}
+
+# Peering link: b-a (10.99.0.1, AS 65001) <---> b-b (10.99.0.2, AS 65002)
+ip link add b-a type veth peer name b-b
+ip link set b-a netns ns_bgp_a
+ip link set b-b netns ns_bgp_b
+ip netns exec ns_bgp_a ip addr add 10.99.0.1/24 dev b-a
+ip netns exec ns_bgp_a ip link set b-a up
+ip netns exec ns_bgp_b ip addr add 10.99.0.2/24 dev b-b
+ip netns exec ns_bgp_b ip link set b-b up
+
+# write_config <file> <iface> <addr> <bgp block>
+write_config() {
+    cat > "$1" <<EOF
+interface "lo" {
+    ipv4 = ["127.0.0.1/8"]
+}
+interface "$2" {
+    ipv4 = ["$3/24"]
+    zone = "peering"
+}
+zone "peering" {}
+policy "peering" "Firewall" {
+    name = "peering_to_firewall"
+    action = "accept"
+}
+frr {
+    enabled = true
+$4
+}
+EOF
+}
+
+write_config /tmp/bgp_a.hcl b-a 10.99.0.1 '
+    bgp {
+        asn = 65001
+        router_id = "10.99.0.1"
+        networks = ["10.1.0.0/16", "10.2.0.0/16"]
+        table = 100
+
+        prefix_list "from-b" {
+            rule {
+                prefix = "192.0.2.0/24"
+            }
+        }
+        prefix_list "to-b" {
+            rule {
+                prefix = "10.1.0.0/16"
+            }
+        }
+
+        neighbor "10.99.0.2" {
+            remote_asn = 65002
+            hold_time = 3
+            import = "from-b"
+            export = "to-b"
+        }
+    }'
+
+write_config /tmp/bgp_b.hcl b-b 10.99.0.2 '
+    bgp {
+        asn = 65002
+        router_id = "10.99.0.2"
+        networks = ["192.0.2.0/24", "198.51.100.0/24"]
+
+        neighbor "10.99.0.1" {
+            remote_asn = 65001
+            hold_time = 3
+        }
+    }'
+
+# has_route <netns> <table> <prefix>
+has_route() {
+    ip netns exec "$1" ip -4 route show table "$2" proto bgp 2>/dev/null | grep -q "^$3 "
+}
+
+# wait_for <timeout> <description> <command...>
+wait_for() {
+    timeout=$1
+    desc=$2
+    shift 2
+    diag "Waiting for $desc..."
+    count=0
+    while ! "$@"; do
+        sleep 1
+        count=$((count + 1))
+        if [ $count -ge $timeout ]; then
+            fail "Timeout waiting for $desc"
+        fi
+    done
+}
+
+diag "Starting node A (AS 65001)..."
+export GLACIC_CTL_SOCKET="$A_DIR/ctl.sock"
+ip netns exec ns_bgp_a $APP_BIN ctl --state-dir $A_DIR/state /tmp/bgp_a.hcl > $A_DIR/log 2>&1 &
+echo $! > $A_DIR/pid
+
+diag "Starting node B (AS 65002)..."
+export GLACIC_CTL_SOCKET="$B_DIR/ctl.sock"
+ip netns exec ns_bgp_b $APP_BIN ctl --state-dir $B_DIR/state /tmp/bgp_b.hcl > $B_DIR/log 2>&1 &
+echo $! > $B_DIR/pid
+PID_B=$!
+
+wait_for 60 "node A to learn 192.0.2.0/24" has_route ns_bgp_a 100 192.0.2.0/24
+ip netns exec ns_bgp_a ip -4 route show table 100 proto bgp | grep -q "^192.0.2.0/24 via 10.99.0.2 "
+ok $? "A installed B's route in table 100 via B"
+
+wait_for 30 "node B to learn 10.1.0.0/16" has_route ns_bgp_b main 10.1.0.0/16
+ok 0 "B installed A's exported network"
+
+if has_route ns_bgp_a 100 198.51.100.0/24; then
+    fail "A installed a route its import list denies"
+fi
+ok 0 "A's import prefix list filtered 198.51.100.0/24"
+
+if has_route ns_bgp_b main 10.2.0.0/16; then
+    fail "B learned a network A's export list denies"
+fi
+ok 0 "A's export prefix list withheld 10.2.0.0/16"
+
+diag "Stopping node B..."
+kill $PID_B
+wait $PID_B 2>/dev/null
+
+wait_for 15 "node A to withdraw B's routes" sh -c '! ip netns exec ns_bgp_a ip -4 route show table 100 proto bgp 2>/dev/null | grep -q .'
+ok 0 "A withdrew routes learned from B"
+
+exit 0
@@ -0,0 +1,219 @@
+#!/bin/sh
+set -x
+# Native OSPF Integration Test
+# Three nodes run OSPFv2 and OSPFv3 without FRR:
+#
+#   A --(broadcast, area 0)-- B --(point-to-point, stub area 1)-- C
+#
+# B is the area border router. A learns C's LAN as an inter-area route,
+# C gets a default route into its stub area, and when C shuts down A
+# withdraws its routes.
+
+TEST_TIMEOUT=120
+. "$(dirname "$0")/../common.sh"
+export GLACIC_LOG_FILE=stdout
+
+plan 6
+
+A_DIR="/tmp/glacic_ospf_a"
+B_DIR="/tmp/glacic_ospf_b"
+C_DIR="/tmp/glacic_ospf_c"
+rm -rf $A_DIR $B_DIR $C_DIR
+mkdir -p $A_DIR/state $B_DIR/state $C_DIR/state
+
+for ns in ns_ospf_a ns_ospf_b ns_ospf_c; do
+    ip netns add $ns
+    ip netns exec $ns ip link set lo up
+done
+
+cleanup() {
+    pkill -F $A_DIR/pid 2>/dev/null
+    pkill -F $B_DIR/pid 2>/dev/null
+    pkill -F $C_DIR/pid 2>/dev/null
+    ip netns del ns_ospf_a 2>/dev/null
+    ip netns del ns_ospf_b 2>/dev/null
+    ip netns del ns_ospf_c 2>/dev/null
+    rm -rf $A_DIR $B_DIR $C_DIR
+    rm -f /tmp/ospf_a.hcl /tmp/ospf_b.hcl /tmp/ospf_c.hcl
+}
+trap cleanup EXIT
+
+fail() {
+    echo "### TEST FAILED: $1"
+    for node in A B C; do
+        dir=$(eval echo \$${node}_DIR)
+        echo "### NODE $node LOG:"
+        cat $dir/log
+    done
+    exit 1
+}
+
+# Backbone: o-ab (10.98.0.1) <---> o-ba (10.98.0.2)
+ip link add o-ab type veth peer name o-ba
+ip link set o-ab netns ns_ospf_a
+ip link set o-ba netns ns_ospf_b
+# Branch link: o-bc (10.97.0.1) <---> o-cb (10.97.0.2)
+ip link add o-bc type veth peer name o-cb
+ip link set o-bc netns ns_ospf_b
+ip link set o-cb netns ns_ospf_c
+# LANs, with a peer end that stays in the namespace
+ip netns exec ns_ospf_a ip link add o-lan type veth peer name o-lan-host
+ip netns exec ns_ospf_c ip link add o-lan type veth peer name o-lan-host
+for link in ns_ospf_a:o-ab ns_ospf_a:o-lan ns_ospf_a:o-lan-host ns_ospf_b:o-ba ns_ospf_b:o-bc \
+    ns_ospf_c:o-cb ns_ospf_c:o-lan ns_ospf_c:o-lan-host; do
+    ip netns exec ${link%%:*} ip link set ${link#*:} up
+done
+
+# write_config <file> <interface blocks> <ospf block>
+write_config() {
+    cat > "$1" <<EOF
+interface "lo" {
+    ipv4 = ["127.0.0.1/8"]
+}
+$2
+zone "sites" {}
+policy "sites" "Firewall" {
+    name = "sites_to_firewall"
+    action = "accept"
+}
+frr {
+    enabled = true
+$3
+}
+EOF
+}
+
+write_config /tmp/ospf_a.hcl '
+interface "o-ab" {
+    ipv4 = ["10.98.0.1/24"]
+    ipv6 = ["2001:db8:ff::1/64"]
+    zone = "sites"
+}
+interface "o-lan" {
+    ipv4 = ["10.0.1.1/24"]
+    ipv6 = ["2001:db8:0:1::1/64"]
+    zone = "sites"
+}' '
+    ospf {
+        router_id = "10.255.0.1"
+        networks = ["10.0.0.0/16", "10.98.0.0/24", "2001:db8::/48", "2001:db8:ff::/64"]
+        interface "o-ab" {
+            hello_interval = 1
+        }
+        interface "o-lan" {
+            passive = true
+        }
+    }'
+
+write_config /tmp/ospf_b.hcl '
+interface "o-ba" {
+    ipv4 = ["10.98.0.2/24"]
+    ipv6 = ["2001:db8:ff::2/64"]
+    zone = "sites"
+}
+interface "o-bc" {
+    ipv4 = ["10.97.0.1/30"]
+    zone = "sites"
+}' '
+    ospf {
+        router_id = "10.255.0.2"
+        networks = ["10.98.0.0/24", "2001:db8:ff::/64"]
+        area "0.0.0.1" {
+            networks = ["10.97.0.0/30"]
+            stub = true
+        }
+        interface "o-ba" {
+            hello_interval = 1
+        }
+        interface "o-bc" {
+            type = "point-to-point"
+            hello_interval = 1
+        }
+    }'
+
+write_config /tmp/ospf_c.hcl '
+interface "o-cb" {
+    ipv4 = ["10.97.0.2/30"]
+    zone = "sites"
+}
+interface "o-lan" {
+    ipv4 = ["10.1.1.1/24"]
+    ipv6 = ["2001:db8:1:1::1/64"]
+    zone = "sites"
+}' '
+    ospf {
+        router_id = "10.255.0.3"
+        area "0.0.0.1" {
+            networks = ["10.97.0.0/30", "10.1.0.0/16", "2001:db8:1::/48"]
+            stub = true
+        }
+        interface "o-cb" {
+            type = "point-to-point"
+            hello_interval = 1
+        }
+        interface "o-lan" {
+            passive = true
+        }
+    }'
+
+# has_route <netns> <family> <prefix>
+has_route() {
+    ip netns exec "$1" ip "$2" route show proto ospf 2>/dev/null | grep -q "^$3 "
+}
+
+# wait_for <timeout> <description> <command...>
+wait_for() {
+    timeout=$1
+    desc=$2
+    shift 2
+    diag "Waiting for $desc..."
+    count=0
+    while ! "$@"; do
+        sleep 1
+        count=$((count + 1))
+        if [ $count -ge $timeout ]; then
+            fail "Timeout waiting for $desc"
+        fi
+    done
+}
+
+# start_node <netns> <dir> <config>
+start_node() {
+    GLACIC_RUN_DIR="$2" ip netns exec "$1" $APP_BIN ctl --state-dir $2/state "$3" > $2/log 2>&1 &
+    echo $! > $2/pid
+}
+
+diag "Starting nodes..."
+start_node ns_ospf_a $A_DIR /tmp/ospf_a.hcl
+start_node ns_ospf_b $B_DIR /tmp/ospf_b.hcl
+start_node ns_ospf_c $C_DIR /tmp/ospf_c.hcl
+PID_C=$(cat $C_DIR/pid)
+
+wait_for 60 "node A to learn C's LAN" has_route ns_ospf_a -4 10.1.1.0/24
+ip netns exec ns_ospf_a ip -4 route show proto ospf | grep -q "^10.1.1.0/24 via 10.98.0.2 "
+ok $? "A routes C's LAN via the border router"
+
+wait_for 60 "node A to learn C's IPv6 LAN" has_route ns_ospf_a -6 2001:db8:1:1::/64
+ip netns exec ns_ospf_a ip -6 route show proto ospf | grep -q "^2001:db8:1:1::/64 via fe80::"
+ok $? "A routes C's IPv6 LAN via a link-local next hop (OSPFv3)"
+
+wait_for 30 "node C to get a default route" has_route ns_ospf_c -4 default
+ip netns exec ns_ospf_c ip -4 route show proto ospf | grep -q "^default via 10.97.0.1 "
+ok $? "B injected a default route into the stub area"
+
+wait_for 30 "node C to learn A's LAN" has_route ns_ospf_c -4 10.0.1.0/24
+ok 0 "C learned A's LAN as an inter-area route"
+
+GLACIC_RUN_DIR="$A_DIR" $APP_BIN show ospf neighbors > $A_DIR/show 2>&1
+cat $A_DIR/show
+grep -q "^10.255.0.2 .* full " $A_DIR/show
+ok $? "show ospf lists B as a full neighbor"
+
+diag "Stopping node C..."
+kill $PID_C
+wait $PID_C 2>/dev/null
+
+wait_for 30 "node A to withdraw C's LAN" sh -c '! ip netns exec ns_ospf_a ip -4 route show proto ospf 2>/dev/null | grep -q "^10.1.1.0/24 "'
+ok 0 "A withdrew C's LAN after C shut down"
+
+exit 0
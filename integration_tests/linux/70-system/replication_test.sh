@@ -124,6 +124,7 @@ dhcp {
 replication {
     mode = "primary"
     listen_addr = "192.168.1.1:9001"
+    secret_key = "integration-test-replication-key"
 }
 state_dir = "/tmp/glacic_prim/state"
 EOF
@@ -157,6 +158,7 @@ api {
 replication {
     mode = "replica"
     primary_addr = "192.168.1.1:9001"
+    secret_key = "integration-test-replication-key"
 }
 state_dir = "/tmp/glacic_repl/state"
 
@@ -0,0 +1,144 @@
+#!/bin/sh
+set -x
+# VRRP Failover Integration Test
+# Two nodes share a virtual IP on a veth pair. The higher-priority node
+# becomes master and replication primary; when it dies, the backup claims
+# the VIP and takes over as primary.
+
+TEST_TIMEOUT=90
+. "$(dirname "$0")/../common.sh"
+export GLACIC_LOG_FILE=stdout
+
+plan 5
+
+A_DIR="/tmp/glacic_vrrp_a"
+B_DIR="/tmp/glacic_vrrp_b"
+VIP="192.168.50.254"
+rm -rf $A_DIR $B_DIR
+mkdir -p $A_DIR/state $B_DIR/state
+
+ip netns add ns_vrrp_a
+ip netns add ns_vrrp_b
+ip netns exec ns_vrrp_a ip link set lo up
+ip netns exec ns_vrrp_b ip link set lo up
+
+cleanup() {
+    pkill -F $A_DIR/pid 2>/dev/null
+    pkill -F $B_DIR/pid 2>/dev/null
+    ip netns del ns_vrrp_a 2>/dev/null
+    ip netns del ns_vrrp_b 2>/dev/null
+    rm -rf $A_DIR $B_DIR
+    rm -f /tmp/vrrp_a.hcl /tmp/vrrp_b.hcl
+}
+trap cleanup EXIT
+
+fail() {
+    echo "### TEST FAILED: $1"
+    echo "### NODE A LOG:"
+    cat $A_DIR/log
+    echo "### NODE B LOG:"
+    cat $B_DIR/log
+    exit 1
+}
+
+# Shared segment: v-a (192.168.50.1) <---> v-b (192.168.50.2)
+ip link add v-a type veth peer name v-b
+ip link set v-a netns ns_vrrp_a
+ip link set v-b netns ns_vrrp_b
+ip netns exec ns_vrrp_a ip addr add 192.168.50.1/24 dev v-a
+ip netns exec ns_vrrp_a ip link set v-a up
+ip netns exec ns_vrrp_b ip addr add 192.168.50.2/24 dev v-b
+ip netns exec ns_vrrp_b ip link set v-b up
+
+# write_config <file> <iface> <addr> <priority> <state_dir>
+write_config() {
+    cat > "$1" <<EOF
+interface "lo" {
+    ipv4 = ["127.0.0.1/8"]
+}
+interface "$2" {
+    ipv4 = ["$3/24"]
+    zone = "sync"
+}
+zone "sync" {
+    management {
+        api = true
+    }
+}
+policy "sync" "Firewall" {
+    name = "sync_to_firewall"
+    action = "accept"
+}
+replication {
+    listen_addr = ":9001"
+    secret_key = "integration-test-replication-key"
+
+    vrrp "$2" {
+        vrid = 51
+        priority = $4
+        virtual_ips = ["$VIP/24"]
+        advert_interval = "200ms"
+    }
+}
+state_dir = "$5"
+EOF
+}
+write_config /tmp/vrrp_a.hcl v-a 192.168.50.1 200 $A_DIR/state
+write_config /tmp/vrrp_b.hcl v-b 192.168.50.2 100 $B_DIR/state
+
+# has_vip <netns> <iface>
+has_vip() {
+    ip netns exec "$1" ip -4 addr show dev "$2" | grep -q "$VIP/"
+}
+
+# wait_for <timeout> <description> <command...>
+wait_for() {
+    timeout=$1
+    desc=$2
+    shift 2
+    diag "Waiting for $desc..."
+    count=0
+    while ! "$@"; do
+        sleep 1
+        count=$((count + 1))
+        if [ $count -ge $timeout ]; then
+            fail "Timeout waiting for $desc"
+        fi
+    done
+}
+
+diag "Starting node A (priority 200)..."
+export GLACIC_CTL_SOCKET="$A_DIR/ctl.sock"
+ip netns exec ns_vrrp_a $APP_BIN ctl --state-dir $A_DIR/state /tmp/vrrp_a.hcl > $A_DIR/log 2>&1 &
+echo $! > $A_DIR/pid
+PID_A=$!
+
+diag "Starting node B (priority 100)..."
+export GLACIC_CTL_SOCKET="$B_DIR/ctl.sock"
+ip netns exec ns_vrrp_b $APP_BIN ctl --state-dir $B_DIR/state /tmp/vrrp_b.hcl > $B_DIR/log 2>&1 &
+echo $! > $B_DIR/pid
+
+wait_for 30 "node A to hold the VIP" has_vip ns_vrrp_a v-a
+ok 0 "Higher priority node claimed the VIP"
+
+dilated_sleep 2
+if has_vip ns_vrrp_b v-b; then
+    fail "Backup also holds the VIP"
+fi
+ok 0 "Backup does not hold the VIP"
+
+wait_for 30 "node B to replicate from A" grep -q "Connected to primary" $B_DIR/log
+ok 0 "Backup replicates from the master"
+
+# Crash the master without a clean VRRP shutdown
+diag "Killing node A..."
+kill -9 $PID_A
+wait $PID_A 2>/dev/null
+
+wait_for 15 "node B to claim the VIP" has_vip ns_vrrp_b v-b
+ok 0 "Backup took over the VIP"
+
+wait_for 15 "node B to become primary" grep -q "Replication primary started" $B_DIR/log
+ok 0 "New master became replication primary"
+
+exit 0
@@ -0,0 +1,481 @@
+// Package acme obtains and renews certificates from an ACME CA (RFC 8555)
+// such as Let's Encrypt, answering http-01, tls-alpn-01 and dns-01
+// challenges.
+//
+// Certificates are stored under the state directory and renewed 30 days
+// before they expire. The responses to HTTP-01 and TLS-ALPN-01 challenges
+// are published through Challenges for the listeners facing the CA.
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	acmeapi "golang.org/x/crypto/acme"
+
+	"grimm.is/glacic/internal/brand"
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/logging"
+)
+
+// Directory URLs of the Let's Encrypt CA.
+const (
+	LetsEncryptURL = acmeapi.LetsEncryptURL
+	StagingURL     = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// renewBefore is how long before expiry a certificate is renewed.
+const renewBefore = 30 * 24 * time.Hour
+
+// Certificate describes a certificate the Manager keeps issued.
+type Certificate struct {
+	Name      string   // Storage name, unique per manager
+	Domains   []string // The first domain becomes the common name
+	Challenge string   // ChallengeHTTP01 (default), ChallengeTLSALPN01 or ChallengeDNS01
+}
+
+// Config configures a Manager.
+type Config struct {
+	Dir          string // Storage for the account key and certificates
+	DirectoryURL string // CA directory; Let's Encrypt when empty
+	Email        string // Account contact
+
+	// DNSProvider publishes dns-01 records. DNSPropagation is how long to
+	// wait after publishing before asking the CA to validate.
+	DNSProvider    DNSProvider
+	DNSPropagation time.Duration
+
+	HTTPClient *http.Client // Client for the CA; http.DefaultClient when nil
+}
+
+// Manager obtains and renews ACME certificates.
+type Manager struct {
+	cfg        Config
+	logger     *logging.Logger
+	challenges *Challenges
+
+	issueMu sync.Mutex // serialises issuance
+	client  *acmeapi.Client
+
+	mu       sync.Mutex
+	certs    []Certificate
+	onChange func()
+}
+
+// DefaultDir returns the default storage directory under the state dir.
+func DefaultDir() string {
+	return filepath.Join(brand.GetStateDir(), "acme")
+}
+
+// CertPaths returns where the certificate called name is stored in dir.
+func CertPaths(dir, name string) (certFile, keyFile string) {
+	return filepath.Join(dir, "certs", name, "cert.pem"), filepath.Join(dir, "certs", name, "key.pem")
+}
+
+// NewManager creates a manager storing its state in cfg.Dir.
+func NewManager(cfg Config, logger *logging.Logger) *Manager {
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir()
+	}
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = LetsEncryptURL
+	}
+	if logger == nil {
+		logger = logging.WithComponent("acme")
+	}
+	m := &Manager{
+		cfg:        cfg,
+		logger:     logger,
+		challenges: NewChallenges(),
+	}
+	m.challenges.SetOnChange(m.changed)
+	return m
+}
+
+// Challenges returns the responses to pending challenges, for the
+// listeners the CA validates against.
+func (m *Manager) Challenges() *Challenges {
+	return m.challenges
+}
+
+// SetOnChange registers a function called when a certificate is issued or
+// the pending challenges change.
+func (m *Manager) SetOnChange(fn func()) {
+	m.mu.Lock()
+	m.onChange = fn
+	m.mu.Unlock()
+}
+
+func (m *Manager) changed() {
+	m.mu.Lock()
+	fn := m.onChange
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// SetCertificates replaces the set of certificates kept issued.
+func (m *Manager) SetCertificates(certs []Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certs = slices.Clone(certs)
+}
+
+// Certificates returns the certificates kept issued.
+func (m *Manager) Certificates() []Certificate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.certs)
+}
+
+// CertPaths returns where the certificate called name is stored.
+func (m *Manager) CertPaths(name string) (certFile, keyFile string) {
+	return CertPaths(m.cfg.Dir, name)
+}
+
+// KeyPair reads the stored certificate called name.
+func (m *Manager) KeyPair(name string) (KeyPair, error) {
+	certFile, keyFile := m.CertPaths(name)
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Cert: cert, Key: key}, nil
+}
+
+// StartAutoRenew obtains missing certificates now and renews due ones
+// every interval until ctx is done.
+func (m *Manager) StartAutoRenew(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := m.RenewDue(ctx); err != nil {
+				m.logger.Error("ACME renewal failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RenewDue obtains every certificate that is missing, expires within 30
+// days or no longer covers its configured domains.
+func (m *Manager) RenewDue(ctx context.Context) error {
+	m.issueMu.Lock()
+	defer m.issueMu.Unlock()
+
+	var errs []error
+	for _, c := range m.Certificates() {
+		if !m.needsRenewal(c) {
+			continue
+		}
+		if err := m.obtain(ctx, c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Obtain issues the certificate now, regardless of the stored one.
+func (m *Manager) Obtain(ctx context.Context, c Certificate) error {
+	m.issueMu.Lock()
+	defer m.issueMu.Unlock()
+	return m.obtain(ctx, c)
+}
+
+// needsRenewal reports whether the stored certificate must be replaced.
+func (m *Manager) needsRenewal(c Certificate) bool {
+	certFile, _ := m.CertPaths(c.Name)
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	if clock.Now().Add(renewBefore).After(leaf.NotAfter) {
+		return true
+	}
+	have := slices.Clone(leaf.DNSNames)
+	want := normalizeDomains(c.Domains)
+	slices.Sort(have)
+	slices.Sort(want)
+	return !slices.Equal(have, want)
+}
+
+func (m *Manager) obtain(ctx context.Context, c Certificate) error {
+	domains := normalizeDomains(c.Domains)
+	if c.Name == "" || len(domains) == 0 {
+		return fmt.Errorf("certificate needs a name and at least one domain")
+	}
+	challenge := c.Challenge
+	if challenge == "" {
+		challenge = ChallengeHTTP01
+	}
+
+	client, err := m.account(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acmeapi.DomainIDs(domains...))
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, url, challenge); err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	pair, err := encodeKeyPair(chain, key)
+	if err != nil {
+		return err
+	}
+	if err := m.store(c.Name, pair); err != nil {
+		return err
+	}
+	m.logger.Info("ACME certificate issued", "name", c.Name, "domains", strings.Join(domains, ","))
+	m.changed()
+	return nil
+}
+
+// authorize completes one authorization of an order with the given
+// challenge type.
+func (m *Manager) authorize(ctx context.Context, client *acmeapi.Client, url, typ string) error {
+	z, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch authorization: %w", err)
+	}
+	if z.Status == acmeapi.StatusValid {
+		return nil
+	}
+
+	var chal *acmeapi.Challenge
+	for _, ch := range z.Challenges {
+		if ch.Type == typ {
+			chal = ch
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no %s challenge for %s", typ, z.Identifier.Value)
+	}
+
+	cleanup, err := m.present(ctx, client, z.Identifier.Value, chal)
+	if err != nil {
+		return fmt.Errorf("failed to present %s challenge for %s: %w", typ, z.Identifier.Value, err)
+	}
+	defer cleanup()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge for %s: %w", z.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("authorization for %s failed: %w", z.Identifier.Value, err)
+	}
+	return nil
+}
+
+// present publishes the response to a challenge and returns a function
+// that withdraws it.
+func (m *Manager) present(ctx context.Context, client *acmeapi.Client, domain string, chal *acmeapi.Challenge) (func(), error) {
+	switch chal.Type {
+	case ChallengeHTTP01:
+		keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		m.challenges.SetHTTPToken(chal.Token, keyAuth)
+		return func() { m.challenges.DeleteHTTPToken(chal.Token) }, nil
+
+	case ChallengeTLSALPN01:
+		cert, err := client.TLSALPN01ChallengeCert(chal.Token, domain)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := encodeKeyPair(cert.Certificate, cert.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.challenges.SetALPNCert(domain, pair); err != nil {
+			return nil, err
+		}
+		return func() { m.challenges.DeleteALPNCert(domain) }, nil
+
+	case ChallengeDNS01:
+		if m.cfg.DNSProvider == nil {
+			return nil, fmt.Errorf("no DNS provider configured")
+		}
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		name := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		if err := m.cfg.DNSProvider.SetTXT(ctx, name, value); err != nil {
+			return nil, err
+		}
+		if m.cfg.DNSPropagation > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(m.cfg.DNSPropagation):
+			}
+		}
+		return func() {
+			if err := m.cfg.DNSProvider.DeleteTXT(context.WithoutCancel(ctx), name, value); err != nil {
+				m.logger.Warn("Failed to remove dns-01 record", "name", name, "error", err)
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported challenge type %q", chal.Type)
+}
+
+// account returns a client registered with the CA, creating the account
+// key on first use.
+func (m *Manager) account(ctx context.Context) (*acmeapi.Client, error) {
+	if m.client != nil {
+		return m.client, nil
+	}
+
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acmeapi.Client{
+		Key:          key,
+		DirectoryURL: m.cfg.DirectoryURL,
+		HTTPClient:   m.cfg.HTTPClient,
+		UserAgent:    brand.LowerName,
+	}
+	acct := &acmeapi.Account{}
+	if m.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := client.Register(ctx, acct, acmeapi.AcceptTOS); err != nil && !errors.Is(err, acmeapi.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	m.client = client
+	return client, nil
+}
+
+// accountKey loads the account key, generating it if missing.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.Dir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate account key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("failed to save account key: %w", err)
+	}
+	return key, nil
+}
+
+// store writes a certificate. The key goes first so a reader watching the
+// certificate file never pairs a new certificate with the old key.
+func (m *Manager) store(name string, pair KeyPair) error {
+	certFile, keyFile := m.CertPaths(name)
+	if err := writeFileAtomic(keyFile, pair.Key); err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	if err := writeFileAtomic(certFile, pair.Cert); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// normalizeDomains lowercases domains and drops duplicates, keeping order.
+func normalizeDomains(domains []string) []string {
+	var out []string
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if d != "" && !slices.Contains(out, d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
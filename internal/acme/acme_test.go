@@ -0,0 +1,658 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acmeapi "golang.org/x/crypto/acme"
+)
+
+// testCA is a minimal Pebble-style ACME server. It validates challenges
+// synchronously against the responders registered by the test.
+type testCA struct {
+	t       *testing.T
+	srv     *httptest.Server
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	certDER []byte
+	dns     *fakeDNS // where dns-01 records are looked up
+
+	mu         sync.Mutex
+	httpAddr   string        // where http-01 tokens are fetched
+	tlsAddr    string        // where tls-alpn-01 handshakes go
+	validity   time.Duration // lifetime of issued certificates
+	nonce      int
+	accounts   map[string]bool // JWK thumbprints
+	thumb      string
+	accountPub *ecdsa.PublicKey
+	orders     []*testOrder
+	authzs     []*testAuthz
+	issued     int
+}
+
+type testOrder struct {
+	domains []string
+	authzs  []int
+	status  string
+	cert    []byte
+}
+
+type testAuthz struct {
+	domain string
+	status string
+	chals  []testChallenge
+}
+
+type testChallenge struct {
+	typ    string
+	token  string
+	status string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	ca := &testCA{
+		t:        t,
+		key:      key,
+		cert:     cert,
+		certDER:  der,
+		validity: 90 * 24 * time.Hour,
+		accounts: make(map[string]bool),
+	}
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serve))
+	t.Cleanup(ca.srv.Close)
+	return ca
+}
+
+func (ca *testCA) url(path string) string { return ca.srv.URL + path }
+
+// set changes the CA's state between requests.
+func (ca *testCA) set(fn func()) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	fn()
+}
+
+func (ca *testCA) counts() (issued, accounts int) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return ca.issued, len(ca.accounts)
+}
+
+func (ca *testCA) serve(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.url("/new-nonce"),
+			"newAccount": ca.url("/new-account"),
+			"newOrder":   ca.url("/new-order"),
+			"revokeCert": ca.url("/revoke"),
+			"keyChange":  ca.url("/key-change"),
+		})
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload, err := ca.verifyJWS(r)
+	if err != nil {
+		ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	var kind string
+	var id int
+	fmt.Sscanf(strings.ReplaceAll(r.URL.Path, "/", " "), "%s %d", &kind, &id)
+
+	switch kind {
+	case "new-account":
+		w.Header().Set("Location", ca.url("/account/1"))
+		if ca.accounts[ca.thumb] {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			ca.accounts[ca.thumb] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+
+	case "new-order":
+		var req struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		json.Unmarshal(payload, &req)
+		o := &testOrder{status: "pending"}
+		for _, ident := range req.Identifiers {
+			o.domains = append(o.domains, ident.Value)
+			o.authzs = append(o.authzs, len(ca.authzs))
+			ca.authzs = append(ca.authzs, &testAuthz{domain: ident.Value, status: "pending", chals: []testChallenge{
+				{typ: ChallengeHTTP01, token: fmt.Sprintf("http-%d", len(ca.authzs)), status: "pending"},
+				{typ: ChallengeTLSALPN01, token: fmt.Sprintf("alpn-%d", len(ca.authzs)), status: "pending"},
+				{typ: ChallengeDNS01, token: fmt.Sprintf("dns-%d", len(ca.authzs)), status: "pending"},
+			}})
+		}
+		ca.orders = append(ca.orders, o)
+		w.Header().Set("Location", ca.url(fmt.Sprintf("/order/%d", len(ca.orders)-1)))
+		w.WriteHeader(http.StatusCreated)
+		ca.writeOrder(w, len(ca.orders)-1)
+
+	case "order":
+		w.Header().Set("Location", ca.url(fmt.Sprintf("/order/%d", id)))
+		ca.writeOrder(w, id)
+
+	case "authz":
+		ca.writeAuthz(w, id)
+
+	case "chal":
+		z := ca.authzs[id/3]
+		ch := &z.chals[id%3]
+		if err := ca.validate(z.domain, ch); err != nil {
+			ch.status, z.status = "invalid", "invalid"
+		} else {
+			ch.status, z.status = "valid", "valid"
+		}
+		ca.writeChallenge(w, id)
+
+	case "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+			return
+		}
+		o := ca.orders[id]
+		if !slices.Equal(csr.DNSNames, o.domains) {
+			ca.problem(w, http.StatusForbidden, "badCSR", "CSR does not match order")
+			return
+		}
+		ca.issued++
+		leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(ca.issued + 1)),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(ca.validity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca.cert, csr.PublicKey, ca.key)
+		if err != nil {
+			ca.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+			return
+		}
+		o.cert = leaf
+		o.status = "valid"
+		w.Header().Set("Location", ca.url(fmt.Sprintf("/order/%d", id)))
+		ca.writeOrder(w, id)
+
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.orders[id].cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.certDER})
+
+	default:
+		ca.problem(w, http.StatusNotFound, "malformed", "unknown resource")
+	}
+}
+
+// verifyJWS checks the request signature against the account key and
+// returns the payload.
+func (ca *testCA) verifyJWS(r *http.Request) ([]byte, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	protectedJSON, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		JWK *struct{ Crv, X, Y string }
+		KID string
+		URL string
+	}
+	if err := json.Unmarshal(protectedJSON, &protected); err != nil {
+		return nil, err
+	}
+	if protected.URL != ca.url(r.URL.Path) {
+		return nil, fmt.Errorf("url mismatch: %s", protected.URL)
+	}
+
+	var pub *ecdsa.PublicKey
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK.Y)
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		thumb, err := acmeapi.JWKThumbprint(pub)
+		if err != nil {
+			return nil, err
+		}
+		ca.thumb = thumb
+		ca.accountPub = pub
+	} else if protected.KID == ca.url("/account/1") && ca.accountPub != nil {
+		pub = ca.accountPub
+	} else {
+		return nil, fmt.Errorf("unknown account %q", protected.KID)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if len(sig) != 64 {
+		return nil, fmt.Errorf("bad signature length")
+	}
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("bad signature")
+	}
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+// validate checks a challenge the way a CA would.
+func (ca *testCA) validate(domain string, ch *testChallenge) error {
+	keyAuth := ch.token + "." + ca.thumb
+	sum := sha256.Sum256([]byte(keyAuth))
+
+	switch ch.typ {
+	case ChallengeHTTP01:
+		req, _ := http.NewRequest("GET", "http://"+ca.httpAddr+httpChallengePrefix+ch.token, nil)
+		req.Host = domain
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != keyAuth {
+			return fmt.Errorf("http-01 response %q", body)
+		}
+
+	case ChallengeTLSALPN01:
+		conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != ALPNProto {
+			return fmt.Errorf("negotiated %q", state.NegotiatedProtocol)
+		}
+		leaf := state.PeerCertificates[0]
+		if !slices.Equal(leaf.DNSNames, []string{domain}) {
+			return fmt.Errorf("challenge certificate for %v", leaf.DNSNames)
+		}
+		for _, ext := range leaf.Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				var got []byte
+				asn1.Unmarshal(ext.Value, &got)
+				if string(got) == string(sum[:]) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("acmeIdentifier extension missing or wrong")
+
+	case ChallengeDNS01:
+		want := base64.RawURLEncoding.EncodeToString(sum[:])
+		if !slices.Contains(ca.dns.lookup("_acme-challenge."+domain+"."), want) {
+			return fmt.Errorf("dns-01 record missing")
+		}
+	}
+	return nil
+}
+
+func (ca *testCA) writeOrder(w http.ResponseWriter, id int) {
+	o := ca.orders[id]
+	status := o.status
+	if status == "pending" {
+		status = "ready"
+		for _, a := range o.authzs {
+			if ca.authzs[a].status != "valid" {
+				status = "pending"
+			}
+		}
+	}
+	var authzs, idents []any
+	for i, a := range o.authzs {
+		authzs = append(authzs, ca.url(fmt.Sprintf("/authz/%d", a)))
+		idents = append(idents, map[string]string{"type": "dns", "value": o.domains[i]})
+	}
+	resp := map[string]any{
+		"status":         status,
+		"identifiers":    idents,
+		"authorizations": authzs,
+		"finalize":       ca.url(fmt.Sprintf("/finalize/%d", id)),
+	}
+	if o.cert != nil {
+		resp["certificate"] = ca.url(fmt.Sprintf("/cert/%d", id))
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (ca *testCA) writeAuthz(w http.ResponseWriter, id int) {
+	z := ca.authzs[id]
+	var chals []any
+	for i, ch := range z.chals {
+		chals = append(chals, map[string]string{"type": ch.typ, "url": ca.url(fmt.Sprintf("/chal/%d", id*3+i)), "token": ch.token, "status": ch.status})
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     z.status,
+		"identifier": map[string]string{"type": "dns", "value": z.domain},
+		"challenges": chals,
+	})
+}
+
+func (ca *testCA) writeChallenge(w http.ResponseWriter, id int) {
+	ch := ca.authzs[id/3].chals[id%3]
+	json.NewEncoder(w).Encode(map[string]string{"type": ch.typ, "url": ca.url(fmt.Sprintf("/chal/%d", id)), "token": ch.token, "status": ch.status})
+}
+
+func (ca *testCA) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + typ, "detail": detail})
+}
+
+// fakeDNS records the TXT records published through it.
+type fakeDNS struct {
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func (d *fakeDNS) SetTXT(ctx context.Context, name, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[name] = append(d.records[name], value)
+	return nil
+}
+
+func (d *fakeDNS) DeleteTXT(ctx context.Context, name, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[name] = slices.DeleteFunc(d.records[name], func(v string) bool { return v == value })
+	if len(d.records[name]) == 0 {
+		delete(d.records, name)
+	}
+	return nil
+}
+
+func (d *fakeDNS) lookup(name string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.records[name])
+}
+
+// newTestManager wires a manager to a test CA, with HTTP-01 and TLS-ALPN-01
+// responders serving its challenges.
+func newTestManager(t *testing.T) (*Manager, *testCA) {
+	t.Helper()
+	ca := newTestCA(t)
+	ca.dns = &fakeDNS{records: make(map[string][]string)}
+
+	m := NewManager(Config{
+		Dir:          t.TempDir(),
+		DirectoryURL: ca.url("/dir"),
+		Email:        "admin@example.com",
+		DNSProvider:  ca.dns,
+	}, nil)
+
+	httpSrv := httptest.NewServer(m.Challenges().HTTPHandler(http.NotFoundHandler()))
+	t.Cleanup(httpSrv.Close)
+	ca.set(func() { ca.httpAddr = httpSrv.Listener.Addr().String() })
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		NextProtos: []string{ALPNProto},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if IsChallengeHello(hello) {
+				return m.Challenges().GetCertificate(hello)
+			}
+			return nil, fmt.Errorf("not a challenge")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	ca.set(func() { ca.tlsAddr = ln.Addr().String() })
+
+	return m, ca
+}
+
+func TestManagerObtainsWithEachChallenge(t *testing.T) {
+	for _, challenge := range []string{ChallengeHTTP01, ChallengeTLSALPN01, ChallengeDNS01} {
+		t.Run(challenge, func(t *testing.T) {
+			m, ca := newTestManager(t)
+			changes := 0
+			m.SetOnChange(func() { changes++ })
+
+			c := Certificate{Name: "web", Domains: []string{"router.example.com", "WWW.example.com."}, Challenge: challenge}
+			if err := m.Obtain(context.Background(), c); err != nil {
+				t.Fatalf("Obtain: %v", err)
+			}
+
+			certFile, keyFile := m.CertPaths("web")
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatalf("stored certificate: %v", err)
+			}
+			leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+			if !slices.Equal(leaf.DNSNames, []string{"router.example.com", "www.example.com"}) {
+				t.Errorf("DNSNames = %v", leaf.DNSNames)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "www.example.com"}); err != nil {
+				t.Errorf("issued certificate does not verify: %v", err)
+			}
+			if info, _ := os.Stat(keyFile); info.Mode().Perm() != 0600 {
+				t.Errorf("key mode = %v", info.Mode().Perm())
+			}
+
+			// Responses are withdrawn once the CA is done with them
+			if s := m.Challenges().Snapshot(); len(s.HTTP) != 0 || len(s.ALPN) != 0 {
+				t.Errorf("challenges left pending: %+v", s)
+			}
+			if records := ca.dns.lookup("_acme-challenge.router.example.com."); len(records) != 0 {
+				t.Errorf("dns records left behind: %v", records)
+			}
+			if changes == 0 {
+				t.Error("no change notification")
+			}
+		})
+	}
+}
+
+func TestManagerRenewDue(t *testing.T) {
+	m, ca := newTestManager(t)
+	ctx := context.Background()
+	m.SetCertificates([]Certificate{{Name: "web", Domains: []string{"router.example.com"}}})
+
+	// Missing certificates are obtained
+	if err := m.RenewDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if issued, _ := ca.counts(); issued != 1 {
+		t.Fatalf("issued = %d after first run", issued)
+	}
+
+	// A fresh certificate is left alone, and the account is reused
+	if err := m.RenewDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if issued, accounts := ca.counts(); issued != 1 || accounts != 1 {
+		t.Errorf("issued = %d, accounts = %d after second run", issued, accounts)
+	}
+
+	// Changing the domains forces a new certificate
+	m.SetCertificates([]Certificate{{Name: "web", Domains: []string{"router.example.com", "vpn.example.com"}}})
+	if err := m.RenewDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if issued, _ := ca.counts(); issued != 2 {
+		t.Errorf("certificate not reissued for new domains")
+	}
+
+	// Certificates within 30 days of expiry are renewed
+	ca.set(func() { ca.validity = 10 * 24 * time.Hour })
+	m.SetCertificates([]Certificate{{Name: "web", Domains: []string{"router.example.com"}}})
+	m.RenewDue(ctx)
+	if err := m.RenewDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if issued, _ := ca.counts(); issued != 4 {
+		t.Errorf("issued = %d, want short-lived certificate renewed", issued)
+	}
+
+	// A restarted manager picks up the stored account
+	again := NewManager(Config{Dir: m.cfg.Dir, DirectoryURL: ca.url("/dir")}, nil)
+	again.SetCertificates([]Certificate{{Name: "other", Domains: []string{"router.example.com"}}})
+	again.challenges = m.challenges
+	if err := again.RenewDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, accounts := ca.counts(); accounts != 1 {
+		t.Errorf("restart registered a new account")
+	}
+}
+
+func TestManagerObtainFailures(t *testing.T) {
+	m, ca := newTestManager(t)
+	ctx := context.Background()
+
+	if err := m.Obtain(ctx, Certificate{Name: "web"}); err == nil {
+		t.Error("certificate without domains accepted")
+	}
+
+	// The CA cannot reach a responder that does not answer
+	ca.set(func() { ca.httpAddr = "127.0.0.1:1" })
+	if err := m.Obtain(ctx, Certificate{Name: "web", Domains: []string{"router.example.com"}}); err == nil {
+		t.Error("failed validation reported success")
+	}
+	if _, err := m.KeyPair("web"); err == nil {
+		t.Error("certificate stored after failed validation")
+	}
+
+	noDNS := NewManager(Config{Dir: t.TempDir(), DirectoryURL: ca.url("/dir")}, nil)
+	if err := noDNS.Obtain(ctx, Certificate{Name: "web", Domains: []string{"router.example.com"}, Challenge: ChallengeDNS01}); err == nil {
+		t.Error("dns-01 without a provider accepted")
+	}
+}
+
+func TestChallengesSnapshotRestore(t *testing.T) {
+	src := NewChallenges()
+	src.SetHTTPToken("token", "token.thumb")
+	client := &acmeapi.Client{Key: mustKey(t)}
+	cert, err := client.TLSALPN01ChallengeCert("token", "router.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := encodeKeyPair(cert.Certificate, cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetALPNCert("Router.Example.com", pair); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mirror through JSON, as the proxy receives it
+	data, _ := json.Marshal(src.Snapshot())
+	var snap ChallengeSnapshot
+	json.Unmarshal(data, &snap)
+	dst := NewChallenges()
+	if err := dst.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	dst.HTTPHandler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", httpChallengePrefix+"token", nil))
+	if rec.Body.String() != "token.thumb" {
+		t.Errorf("http-01 response = %q", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	dst.HTTPHandler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", httpChallengePrefix+"other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token status = %d", rec.Code)
+	}
+
+	hello := &tls.ClientHelloInfo{ServerName: "router.example.com", SupportedProtos: []string{ALPNProto}}
+	if !IsChallengeHello(hello) {
+		t.Error("validation hello not recognised")
+	}
+	if IsChallengeHello(&tls.ClientHelloInfo{SupportedProtos: []string{"h2", ALPNProto}}) {
+		t.Error("browser hello treated as validation")
+	}
+	if _, err := dst.GetCertificate(hello); err != nil {
+		t.Errorf("restored tls-alpn-01 certificate: %v", err)
+	}
+
+	dst.DeleteALPNCert("router.example.com")
+	if _, err := dst.GetCertificate(hello); err == nil {
+		t.Error("withdrawn challenge certificate still served")
+	}
+}
+
+func mustKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
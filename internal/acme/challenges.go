@@ -0,0 +1,226 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	acmeapi "golang.org/x/crypto/acme"
+)
+
+// Challenge types.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+// ALPNProto is the ALPN protocol a CA offers when validating tls-alpn-01.
+// TLS listeners that answer challenges must include it in NextProtos.
+const ALPNProto = acmeapi.ALPNProto
+
+// httpChallengePrefix is the path HTTP-01 tokens are served under.
+const httpChallengePrefix = "/.well-known/acme-challenge/"
+
+// DNSProvider publishes the TXT records used by dns-01 challenges.
+type DNSProvider interface {
+	SetTXT(ctx context.Context, name, value string) error
+	DeleteTXT(ctx context.Context, name, value string) error
+}
+
+// KeyPair is a PEM encoded certificate chain and private key.
+type KeyPair struct {
+	Cert []byte `json:"cert"`
+	Key  []byte `json:"key"`
+}
+
+// X509KeyPair parses the key pair for serving.
+func (k KeyPair) X509KeyPair() (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(k.Cert, k.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ChallengeSnapshot is a copy of the pending challenge responses, used to
+// mirror them into a listener running in another process.
+type ChallengeSnapshot struct {
+	HTTP map[string]string  `json:"http,omitempty"` // token -> key authorization
+	ALPN map[string]KeyPair `json:"alpn,omitempty"` // domain -> challenge certificate
+}
+
+// Challenges holds the responses to pending HTTP-01 and TLS-ALPN-01
+// challenges so the listeners facing the CA can answer them.
+type Challenges struct {
+	mu       sync.RWMutex
+	tokens   map[string]string
+	certs    map[string]*tls.Certificate
+	pairs    map[string]KeyPair
+	onChange func()
+}
+
+// NewChallenges creates an empty challenge store.
+func NewChallenges() *Challenges {
+	return &Challenges{
+		tokens: make(map[string]string),
+		certs:  make(map[string]*tls.Certificate),
+		pairs:  make(map[string]KeyPair),
+	}
+}
+
+// SetOnChange registers a function called after every change.
+func (c *Challenges) SetOnChange(fn func()) {
+	c.mu.Lock()
+	c.onChange = fn
+	c.mu.Unlock()
+}
+
+func (c *Challenges) changed() {
+	c.mu.RLock()
+	fn := c.onChange
+	c.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// SetHTTPToken publishes the key authorization for an HTTP-01 token.
+func (c *Challenges) SetHTTPToken(token, keyAuth string) {
+	c.mu.Lock()
+	c.tokens[token] = keyAuth
+	c.mu.Unlock()
+	c.changed()
+}
+
+// DeleteHTTPToken withdraws an HTTP-01 token.
+func (c *Challenges) DeleteHTTPToken(token string) {
+	c.mu.Lock()
+	delete(c.tokens, token)
+	c.mu.Unlock()
+	c.changed()
+}
+
+// SetALPNCert publishes the TLS-ALPN-01 certificate for a domain.
+func (c *Challenges) SetALPNCert(domain string, pair KeyPair) error {
+	cert, err := pair.X509KeyPair()
+	if err != nil {
+		return fmt.Errorf("invalid challenge certificate: %w", err)
+	}
+	domain = strings.ToLower(domain)
+	c.mu.Lock()
+	c.certs[domain] = cert
+	c.pairs[domain] = pair
+	c.mu.Unlock()
+	c.changed()
+	return nil
+}
+
+// DeleteALPNCert withdraws the TLS-ALPN-01 certificate for a domain.
+func (c *Challenges) DeleteALPNCert(domain string) {
+	domain = strings.ToLower(domain)
+	c.mu.Lock()
+	delete(c.certs, domain)
+	delete(c.pairs, domain)
+	c.mu.Unlock()
+	c.changed()
+}
+
+// Snapshot returns a copy of the pending responses.
+func (c *Challenges) Snapshot() ChallengeSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := ChallengeSnapshot{
+		HTTP: make(map[string]string, len(c.tokens)),
+		ALPN: make(map[string]KeyPair, len(c.pairs)),
+	}
+	for token, keyAuth := range c.tokens {
+		s.HTTP[token] = keyAuth
+	}
+	for domain, pair := range c.pairs {
+		s.ALPN[domain] = pair
+	}
+	return s
+}
+
+// Restore replaces the pending responses with a snapshot.
+func (c *Challenges) Restore(s ChallengeSnapshot) error {
+	certs := make(map[string]*tls.Certificate, len(s.ALPN))
+	pairs := make(map[string]KeyPair, len(s.ALPN))
+	for domain, pair := range s.ALPN {
+		cert, err := pair.X509KeyPair()
+		if err != nil {
+			return fmt.Errorf("invalid challenge certificate for %s: %w", domain, err)
+		}
+		certs[strings.ToLower(domain)] = cert
+		pairs[strings.ToLower(domain)] = pair
+	}
+	tokens := make(map[string]string, len(s.HTTP))
+	for token, keyAuth := range s.HTTP {
+		tokens[token] = keyAuth
+	}
+
+	c.mu.Lock()
+	c.tokens, c.certs, c.pairs = tokens, certs, pairs
+	c.mu.Unlock()
+	c.changed()
+	return nil
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to
+// next.
+func (c *Challenges) HTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, httpChallengePrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.mu.RLock()
+		keyAuth, ok := c.tokens[strings.TrimPrefix(r.URL.Path, httpChallengePrefix)]
+		c.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+// IsChallengeHello reports whether a ClientHello comes from a CA validating
+// a tls-alpn-01 challenge.
+func IsChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ALPNProto
+}
+
+// GetCertificate returns the TLS-ALPN-01 certificate for a validation
+// handshake.
+func (c *Challenges) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	cert := c.certs[strings.ToLower(hello.ServerName)]
+	c.mu.RUnlock()
+	if cert == nil {
+		return nil, fmt.Errorf("no tls-alpn-01 challenge pending for %q", hello.ServerName)
+	}
+	return cert, nil
+}
+
+// encodeKeyPair PEM encodes a certificate chain and its private key.
+func encodeKeyPair(chain [][]byte, key any) (KeyPair, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	var pair KeyPair
+	for _, c := range chain {
+		pair.Cert = append(pair.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	pair.Key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return pair, nil
+}
@@ -0,0 +1,123 @@
+package acme
+
+import (
+	"strings"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/services/ddns"
+)
+
+// Names of the certificates requested by the configuration.
+const (
+	WebCertificate = "web" // web UI and API, served by the proxy by default
+	DoHCertificate = "doh" // DNS-over-HTTPS server
+)
+
+// dnsPropagation is how long to wait for dns-01 records to reach the
+// zone's authoritative servers.
+const dnsPropagation = 30 * time.Second
+
+// InterfaceCertificate returns the name of the certificate served to
+// clients connecting through an interface.
+func InterfaceCertificate(iface string) string {
+	return "iface-" + iface
+}
+
+// DirFor returns the storage directory for a configuration: the
+// letsencrypt cache_dir when set, otherwise the state dir.
+func DirFor(cfg *config.Config) string {
+	if cfg.API != nil && cfg.API.LetsEncrypt != nil && cfg.API.LetsEncrypt.CacheDir != "" {
+		return cfg.API.LetsEncrypt.CacheDir
+	}
+	return DefaultDir()
+}
+
+// CertificatesFor lists the certificates a configuration asks for.
+func CertificatesFor(cfg *config.Config) []Certificate {
+	var defaultChallenge string
+	var certs []Certificate
+
+	if le := cfg.API; le != nil && le.LetsEncrypt != nil && le.LetsEncrypt.Enabled {
+		defaultChallenge = le.LetsEncrypt.Challenge
+		if le.LetsEncrypt.Domain != "" {
+			certs = append(certs, Certificate{
+				Name:      WebCertificate,
+				Domains:   []string{le.LetsEncrypt.Domain},
+				Challenge: defaultChallenge,
+			})
+		}
+	}
+
+	for _, iface := range cfg.Interfaces {
+		if iface.TLS == nil || iface.TLS.Mode != "acme" || len(iface.TLS.Domains) == 0 {
+			continue
+		}
+		challenge := iface.TLS.Challenge
+		if challenge == "" {
+			challenge = defaultChallenge
+		}
+		certs = append(certs, Certificate{
+			Name:      InterfaceCertificate(iface.Name),
+			Domains:   iface.TLS.Domains,
+			Challenge: challenge,
+		})
+	}
+
+	// One certificate covers every DoH listener asking for Let's Encrypt.
+	var dohDomains []string
+	addDoH := func(doh *config.DoHServerConfig) {
+		if doh != nil && doh.Enabled && doh.UseLetsEncrypt && doh.Domain != "" {
+			dohDomains = append(dohDomains, doh.Domain)
+		}
+	}
+	if cfg.DNSServer != nil {
+		addDoH(cfg.DNSServer.DoHServer)
+	}
+	if cfg.DNS != nil {
+		for _, serve := range cfg.DNS.Serve {
+			addDoH(serve.DoHServer)
+		}
+	}
+	if len(dohDomains) > 0 {
+		certs = append(certs, Certificate{
+			Name:      DoHCertificate,
+			Domains:   normalizeDomains(dohDomains),
+			Challenge: defaultChallenge,
+		})
+	}
+
+	return certs
+}
+
+// ConfigFor returns the manager configuration for cfg: the account and CA
+// from api.letsencrypt (or the first interface asking for a certificate)
+// and the ddns block's Cloudflare zone for dns-01.
+func ConfigFor(cfg *config.Config) Config {
+	mc := Config{Dir: DirFor(cfg)}
+
+	if cfg.API != nil && cfg.API.LetsEncrypt != nil && cfg.API.LetsEncrypt.Enabled {
+		le := cfg.API.LetsEncrypt
+		mc.Email = le.Email
+		switch {
+		case le.DirectoryURL != "":
+			mc.DirectoryURL = le.DirectoryURL
+		case le.Staging:
+			mc.DirectoryURL = StagingURL
+		}
+	}
+	if mc.Email == "" {
+		for _, iface := range cfg.Interfaces {
+			if iface.TLS != nil && iface.TLS.Mode == "acme" && iface.TLS.Email != "" {
+				mc.Email = iface.TLS.Email
+				break
+			}
+		}
+	}
+
+	if cfg.DDNS != nil && strings.EqualFold(cfg.DDNS.Provider, "cloudflare") {
+		mc.DNSProvider = ddns.NewCloudflare(cfg.DDNS.Token, cfg.DDNS.ZoneID)
+		mc.DNSPropagation = dnsPropagation
+	}
+	return mc
+}
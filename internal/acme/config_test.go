@@ -0,0 +1,66 @@
+package acme
+
+import (
+	"reflect"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+)
+
+func TestCertificatesFor(t *testing.T) {
+	cfg := &config.Config{
+		API: &config.APIConfig{LetsEncrypt: &config.LetsEncryptConfig{
+			Enabled:   true,
+			Email:     "admin@example.com",
+			Domain:    "fw.example.com",
+			Challenge: ChallengeTLSALPN01,
+			Staging:   true,
+			CacheDir:  "/var/cache/acme",
+		}},
+		Interfaces: []config.Interface{
+			{Name: "wan", TLS: &config.TLSConfig{Mode: "acme", Domains: []string{"wan.example.com"}, Challenge: ChallengeDNS01}},
+			{Name: "lan", TLS: &config.TLSConfig{Mode: "acme", Domains: []string{"lan.example.com"}}},
+			{Name: "dmz", TLS: &config.TLSConfig{Mode: "self-signed"}},
+			{Name: "mgmt"},
+		},
+		DNSServer: &config.DNSServer{DoHServer: &config.DoHServerConfig{Enabled: true, UseLetsEncrypt: true, Domain: "dns.example.com"}},
+		DNS: &config.DNS{Serve: []config.DNSServe{
+			{Zone: "lan", DoHServer: &config.DoHServerConfig{Enabled: true, UseLetsEncrypt: true, Domain: "DoH.example.com."}},
+			{Zone: "guest", DoHServer: &config.DoHServerConfig{Enabled: true, Domain: "guest.example.com"}},
+		}},
+		DDNS: &config.DDNSConfig{Provider: "Cloudflare", Token: "t", ZoneID: "z"},
+	}
+
+	want := []Certificate{
+		{Name: WebCertificate, Domains: []string{"fw.example.com"}, Challenge: ChallengeTLSALPN01},
+		{Name: "iface-wan", Domains: []string{"wan.example.com"}, Challenge: ChallengeDNS01},
+		{Name: "iface-lan", Domains: []string{"lan.example.com"}, Challenge: ChallengeTLSALPN01},
+		{Name: DoHCertificate, Domains: []string{"dns.example.com", "doh.example.com"}, Challenge: ChallengeTLSALPN01},
+	}
+	if got := CertificatesFor(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("CertificatesFor = %+v, want %+v", got, want)
+	}
+
+	mc := ConfigFor(cfg)
+	if mc.Dir != "/var/cache/acme" || mc.Email != "admin@example.com" || mc.DirectoryURL != StagingURL {
+		t.Errorf("ConfigFor = %+v", mc)
+	}
+	if mc.DNSProvider == nil {
+		t.Error("Cloudflare ddns zone not used for dns-01")
+	}
+
+	// Without api.letsencrypt the account comes from the interface
+	cfg.API = nil
+	cfg.DDNS = nil
+	mc = ConfigFor(cfg)
+	if mc.Dir != DefaultDir() || mc.Email != "" || mc.DirectoryURL != "" || mc.DNSProvider != nil {
+		t.Errorf("ConfigFor without letsencrypt = %+v", mc)
+	}
+	cfg.Interfaces[1].TLS.Email = "lan@example.com"
+	if mc = ConfigFor(cfg); mc.Email != "lan@example.com" {
+		t.Errorf("interface email not used, got %q", mc.Email)
+	}
+	if got := CertificatesFor(&config.Config{}); len(got) != 0 {
+		t.Errorf("empty config asks for %v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grimm.is/glacic/internal/services/dns/querylog"
+)
+
+// maxDNSQueryLimit caps the page size of GET /api/dns/queries.
+const maxDNSQueryLimit = 1000
+
+// handleDNSQueries handles GET /api/dns/queries requests.
+// Query params: client_ip, client_mac, domain (substring), type, rcode,
+// blocked (true/false), since, until (RFC3339), limit, offset
+func (s *Server) handleDNSQueries(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		WriteErrorCtx(w, r, http.StatusServiceUnavailable, "Control plane not connected")
+		return
+	}
+
+	filter, err := parseDNSQueryFilter(r)
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := s.client.GetDNSQueries(filter)
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []querylog.Entry{}
+	}
+
+	WriteJSON(w, http.StatusOK, struct {
+		Queries []querylog.Entry `json:"queries"`
+		Total   int64            `json:"total"`
+		Limit   int              `json:"limit"`
+		Offset  int              `json:"offset"`
+	}{
+		Queries: entries,
+		Total:   total,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	})
+}
+
+// parseDNSQueryFilter builds a query log filter from URL parameters.
+func parseDNSQueryFilter(r *http.Request) (querylog.Filter, error) {
+	q := r.URL.Query()
+	f := querylog.Filter{
+		ClientIP:  q.Get("client_ip"),
+		ClientMAC: q.Get("client_mac"),
+		Domain:    q.Get("domain"),
+		Type:      q.Get("type"),
+		Rcode:     q.Get("rcode"),
+		Limit:     100,
+	}
+
+	if v := q.Get("blocked"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errBadParam("blocked")
+		}
+		f.Blocked = &b
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errBadParam("since")
+		}
+		f.Since = t
+	}
+	if v := q.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errBadParam("until")
+		}
+		f.Until = t
+	}
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return f, errBadParam("limit")
+		}
+		f.Limit = min(l, maxDNSQueryLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return f, errBadParam("offset")
+		}
+		f.Offset = o
+	}
+	return f, nil
+}
+
+// errBadParam reports an unparseable query parameter.
+func errBadParam(name string) error {
+	return fmt.Errorf("invalid %s parameter", name)
+}
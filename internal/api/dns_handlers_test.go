@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseDNSQueryFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/dns/queries?client_ip=192.168.1.50&domain=netflix&blocked=true&since=2026-01-02T15:04:05Z&limit=5000&offset=20", nil)
+	f, err := parseDNSQueryFilter(r)
+	if err != nil {
+		t.Fatalf("parseDNSQueryFilter failed: %v", err)
+	}
+	if f.ClientIP != "192.168.1.50" || f.Domain != "netflix" {
+		t.Errorf("unexpected string filters: %+v", f)
+	}
+	if f.Blocked == nil || !*f.Blocked {
+		t.Errorf("expected blocked=true, got %v", f.Blocked)
+	}
+	if f.Since.IsZero() || !f.Until.IsZero() {
+		t.Errorf("unexpected time range: %v - %v", f.Since, f.Until)
+	}
+	if f.Limit != maxDNSQueryLimit || f.Offset != 20 {
+		t.Errorf("expected limit %d offset 20, got %d/%d", maxDNSQueryLimit, f.Limit, f.Offset)
+	}
+
+	f, err = parseDNSQueryFilter(httptest.NewRequest("GET", "/api/dns/queries", nil))
+	if err != nil || f.Limit != 100 || f.Blocked != nil {
+		t.Errorf("unexpected defaults: %+v (err %v)", f, err)
+	}
+
+	for _, q := range []string{"blocked=maybe", "since=yesterday", "limit=-1", "offset=x"} {
+		if _, err := parseDNSQueryFilter(httptest.NewRequest("GET", "/api/dns/queries?"+q, nil)); err == nil {
+			t.Errorf("expected error for %q", q)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import "net/http"
+
+// handleIPSStatus returns the IPS engine's counters and recent alerts
+// GET /api/ips
+func (s *Server) handleIPSStatus(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		WriteErrorCtx(w, r, http.StatusServiceUnavailable, "Control plane not connected")
+		return
+	}
+
+	status, err := s.client.GetIPSStatus()
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusInternalServerError, "Failed to get IPS status: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": status.Enabled,
+		"stats":   status.Stats,
+		"alerts":  status.Alerts,
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grimm.is/glacic/internal/ctlplane"
+	"grimm.is/glacic/internal/ips"
+	"grimm.is/glacic/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleIPSStatus(t *testing.T) {
+	mockClient := new(ctlplane.MockControlPlaneClient)
+	mockClient.On("GetIPSStatus").Return(&ctlplane.GetIPSStatusReply{
+		Enabled: true,
+		Stats:   ips.Stats{Rules: 12, Alerts: 1, Dropped: 1},
+		Alerts:  []ips.Alert{{SID: 1000001, Msg: "Path traversal", Action: "drop", Dropped: true}},
+	}, nil)
+
+	server := &Server{
+		client: mockClient,
+		logger: logging.New(logging.DefaultConfig()),
+	}
+
+	req, _ := http.NewRequest("GET", "/api/ips", nil)
+	rr := httptest.NewRecorder()
+
+	server.handleIPSStatus(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result struct {
+		Enabled bool        `json:"enabled"`
+		Stats   ips.Stats   `json:"stats"`
+		Alerts  []ips.Alert `json:"alerts"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	assert.True(t, result.Enabled)
+	assert.Equal(t, 12, result.Stats.Rules)
+	assert.Len(t, result.Alerts, 1)
+	assert.Equal(t, 1000001, result.Alerts[0].SID)
+
+	mockClient.AssertExpectations(t)
+}
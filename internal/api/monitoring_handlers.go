@@ -91,6 +91,7 @@ func (s *Server) handleMonitoringServices(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	s.refreshDNSStats()
 	stats := s.collector.GetServiceStats()
 
 	WriteJSON(w, http.StatusOK, map[string]interface{}{
@@ -99,6 +100,35 @@ func (s *Server) handleMonitoringServices(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// metricsHandler refreshes counters owned by the control plane before
+// serving the Prometheus registry.
+func (s *Server) metricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.refreshDNSStats()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refreshDNSStats pulls DNS rate limiting counters from the control plane
+// into the metrics collector.
+func (s *Server) refreshDNSStats() {
+	if s.client == nil {
+		return
+	}
+	stats, err := s.client.GetDNSStats()
+	if err != nil {
+		return
+	}
+	rl := stats.RateLimit
+	s.collector.UpdateDNSRateLimitStats(rl.LimitedClients, map[string]uint64{
+		"refuse":   rl.Refused,
+		"drop":     rl.Dropped,
+		"truncate": rl.Truncated,
+		"rrl_drop": rl.RRLDropped,
+		"rrl_slip": rl.RRLSlipped,
+	})
+}
+
 // handleMonitoringSystem returns system statistics (CPU, memory, load).
 func (s *Server) handleMonitoringSystem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
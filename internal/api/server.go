@@ -34,6 +34,7 @@ import (
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/logging"
 	"grimm.is/glacic/internal/metrics"
+	"grimm.is/glacic/internal/network/vrrp"
 	"grimm.is/glacic/internal/ratelimit"
 	"grimm.is/glacic/internal/state"
 	"grimm.is/glacic/internal/stats"
@@ -381,12 +382,19 @@ func (s *Server) initRoutes() {
 	mux.Handle("GET /api/logs/stream", s.require(storage.PermReadLogs, http.HandlerFunc(s.handleLogStream)))
 	mux.Handle("GET /api/logs/stats", s.require(storage.PermReadLogs, http.HandlerFunc(s.handleLogStats)))
 
+	// DNS query log
+	mux.Handle("GET /api/dns/queries", s.require(storage.PermReadDNS, http.HandlerFunc(s.handleDNSQueries)))
+
 	// Audit log endpoint
 	mux.Handle("GET /api/audit", s.require(storage.PermReadAudit, http.HandlerFunc(s.handleAuditQuery)))
 
 	// Extended System Operations
 	mux.Handle("GET /api/system/stats", s.require(storage.PermAdminSystem, http.HandlerFunc(s.handleSystemStats)))
 	mux.Handle("GET /api/system/routes", s.require(storage.PermAdminSystem, http.HandlerFunc(s.handleSystemRoutes)))
+	mux.Handle("GET /api/routing/bgp/neighbors", s.require(storage.PermReadConfig, http.HandlerFunc(s.handleBGPNeighbors)))
+	mux.Handle("GET /api/routing/ospf", s.require(storage.PermReadConfig, http.HandlerFunc(s.handleOSPFStatus)))
+	mux.Handle("GET /api/routing/ospf/lsdb", s.require(storage.PermReadConfig, http.HandlerFunc(s.handleOSPFDatabase)))
+	mux.Handle("GET /api/ips", s.require(storage.PermReadFirewall, http.HandlerFunc(s.handleIPSStatus)))
 
 	// Import Wizard
 	mux.Handle("POST /api/import/upload", s.require(storage.PermAdminSystem, http.HandlerFunc(s.handleImportUpload)))
@@ -412,7 +420,7 @@ func (s *Server) initRoutes() {
 	mux.Handle("POST /api/config/discard", s.require(storage.PermWriteConfig, http.HandlerFunc(s.handleDiscardConfig)))
 	mux.Handle("GET /api/config/pending-status", s.require(storage.PermReadConfig, http.HandlerFunc(s.handlePendingStatus)))
 
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", s.metricsHandler(promhttp.Handler()))
 
 	// Serve SPA static files with fallback to index.html
 	if s.Assets != nil {
@@ -952,6 +960,8 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			info.Uptime = status.Uptime
 			info.FirewallActive = status.FirewallActive
+			info.Replication = status.Replication
+			info.VRRP = status.VRRP
 		}
 
 		// 2. System Stats (CPU/Mem)
@@ -1033,6 +1043,9 @@ type ServerInfo struct {
 	BlockedCount    int64   `json:"blocked_count"`              // Total blocked packets (last 24h/session)
 	CPULoad         float64 `json:"cpu_load"`                   // CPU Usage %
 	MemUsage        float64 `json:"mem_usage"`                  // Memory Usage %
+
+	Replication *state.ReplicationStatus `json:"replication,omitempty"` // HA replication health
+	VRRP        []vrrp.InstanceStatus    `json:"vrrp,omitempty"`        // Virtual router states
 }
 
 func (s *Server) handleLeases(w http.ResponseWriter, r *http.Request) {
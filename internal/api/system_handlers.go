@@ -209,6 +209,67 @@ func (s *Server) handleSystemRoutes(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleBGPNeighbors returns the session state of the BGP neighbors
+// GET /api/routing/bgp/neighbors
+func (s *Server) handleBGPNeighbors(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		WriteErrorCtx(w, r, http.StatusServiceUnavailable, "Control plane not connected")
+		return
+	}
+
+	neighbors, err := s.client.GetBGPNeighbors()
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusInternalServerError, "Failed to get BGP neighbors: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"neighbors": neighbors,
+	})
+}
+
+// handleOSPFStatus returns the OSPF interfaces, adjacencies and routes
+// GET /api/routing/ospf
+func (s *Server) handleOSPFStatus(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		WriteErrorCtx(w, r, http.StatusServiceUnavailable, "Control plane not connected")
+		return
+	}
+
+	status, enabled, err := s.client.GetOSPFStatus()
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusInternalServerError, "Failed to get OSPF status: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":    enabled,
+		"router_id":  status.RouterID,
+		"interfaces": status.Interfaces,
+		"neighbors":  status.Neighbors,
+		"routes":     status.Routes,
+	})
+}
+
+// handleOSPFDatabase returns the OSPF link state databases
+// GET /api/routing/ospf/lsdb
+func (s *Server) handleOSPFDatabase(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		WriteErrorCtx(w, r, http.StatusServiceUnavailable, "Control plane not connected")
+		return
+	}
+
+	lsas, err := s.client.GetOSPFDatabase()
+	if err != nil {
+		WriteErrorCtx(w, r, http.StatusInternalServerError, "Failed to get OSPF database: "+err.Error())
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"lsas": lsas,
+	})
+}
+
 // handleSafeModeStatus returns safe mode status
 // GET /api/system/safe-mode
 func (s *Server) handleSafeModeStatus(w http.ResponseWriter, r *http.Request) {
@@ -9,6 +9,7 @@ import (
 
 	"grimm.is/glacic/internal/ctlplane"
 	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/services/dns/querylog"
 
 	"github.com/gorilla/websocket"
 )
@@ -174,6 +175,10 @@ func (m *WSManager) statusLoop() {
 	var lastLogTime string
 	// Track last notification ID for cursoring
 	var lastNotifyID int64
+	// Track last DNS query log ID for cursoring
+	var lastDNSQueryID int64
+	// Track last control plane event ID for cursoring
+	var lastEventID int64
 	// Deduplication: track seen log message hashes (source + message)
 	seenLogs := make(map[string]struct{})
 	const maxSeenLogs = 500
@@ -255,6 +260,26 @@ func (m *WSManager) statusLoop() {
 				}
 			}
 
+			// Forward event bus messages (leases, dns, ips, ...) on their own topics
+			if m.client != nil && m.hasClients() {
+				feed, newLastID, err := m.client.GetEvents(lastEventID)
+				if err == nil {
+					for _, e := range feed {
+						m.Publish(e.Topic, e.Data)
+					}
+					lastEventID = newLastID
+				}
+			}
+
+			// Publish new DNS query log entries (only if subscribed)
+			if m.hasSubscribers("dns_queries") {
+				entries, _, err := m.client.GetDNSQueries(querylog.Filter{AfterID: lastDNSQueryID, Limit: 200})
+				if err == nil && len(entries) > 0 {
+					m.Publish("dns_queries", entries)
+					lastDNSQueryID = entries[0].ID // Newest first
+				}
+			}
+
 			// Publish topology (only if subscribed - expensive to compute graph)
 			if m.hasSubscribers("topology") {
 				topo, err := m.client.GetTopology()
@@ -279,6 +304,13 @@ func (m *WSManager) statusLoop() {
 	}
 }
 
+// hasClients checks if any client is connected
+func (m *WSManager) hasClients() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.clients) > 0
+}
+
 // hasSubscribers checks if any client is subscribed to the given topic
 func (m *WSManager) hasSubscribers(topic string) bool {
 	m.mutex.RLock()
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Captive portal defaults.
+const (
+	DefaultCaptivePortalPort    = 2050
+	DefaultCaptivePortalTLSPort = 2051
+)
+
+// CaptivePortalConfig configures the guest portal shown to clients of zones
+// with services { captive_portal = true }. Without this block those zones
+// get a click-through portal with the defaults below.
+type CaptivePortalConfig struct {
+	// Login method:
+	//   - "click" (default): accept the terms
+	//   - "voucher": enter one of the single-use vouchers
+	//   - "password": enter the shared password
+	Auth     string   `hcl:"auth,optional" json:"auth,omitempty"`
+	Password string   `hcl:"password,optional" json:"password,omitempty"`
+	Vouchers []string `hcl:"vouchers,optional" json:"vouchers,omitempty"`
+
+	// How long a login lasts, e.g. "4h" (default: 24h)
+	SessionTimeout string `hcl:"session_timeout,optional" json:"session_timeout,omitempty"`
+
+	// Page text
+	Title string `hcl:"title,optional" json:"title,omitempty"`
+	Terms string `hcl:"terms,optional" json:"terms,omitempty"`
+
+	// Port unauthenticated HTTP is redirected to (default: 2050)
+	Port int `hcl:"port,optional" json:"port,omitempty"`
+
+	// HTTPS listener for the RFC 8908 API, which clients only use over TLS.
+	// DHCP option 114 is only advertised when it is configured. The
+	// certificate must be valid for hostname.
+	TLSCert  string `hcl:"tls_cert,optional" json:"tls_cert,omitempty"`
+	TLSKey   string `hcl:"tls_key,optional" json:"tls_key,omitempty"`
+	TLSPort  int    `hcl:"tls_port,optional" json:"tls_port,omitempty"` // default: 2051
+	Hostname string `hcl:"hostname,optional" json:"hostname,omitempty"` // default: router address
+}
+
+// HTTPPort returns the portal's HTTP port.
+func (p *CaptivePortalConfig) HTTPPort() int {
+	if p == nil || p.Port == 0 {
+		return DefaultCaptivePortalPort
+	}
+	return p.Port
+}
+
+// HTTPSPort returns the port of the HTTPS listener, or 0 without one.
+func (p *CaptivePortalConfig) HTTPSPort() int {
+	if p == nil || p.TLSCert == "" || p.TLSKey == "" {
+		return 0
+	}
+	if p.TLSPort == 0 {
+		return DefaultCaptivePortalTLSPort
+	}
+	return p.TLSPort
+}
+
+// APIURL returns the RFC 8908 API URI advertised to clients reaching the
+// router at addr, as sent in DHCP option 114. The API must be served over
+// HTTPS, so it returns "" when the portal has no TLS listener.
+func (p *CaptivePortalConfig) APIURL(addr string) string {
+	port := p.HTTPSPort()
+	if port == 0 {
+		return ""
+	}
+	host := addr
+	if p.Hostname != "" {
+		host = p.Hostname
+	}
+	return fmt.Sprintf("https://%s/api/captive", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// CaptivePortalInterfaces returns the interfaces of zones with the captive
+// portal enabled.
+func CaptivePortalInterfaces(zones []Zone, interfaces []Interface) []string {
+	resolver := NewZoneResolver(zones)
+	var out []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	for _, zone := range zones {
+		if zone.Services == nil || !zone.Services.CaptivePortal {
+			continue
+		}
+		for _, iface := range resolver.GetZoneInterfaces(zone.Name) {
+			add(iface)
+		}
+		for _, iface := range interfaces {
+			if iface.Zone == zone.Name {
+				add(iface.Name)
+			}
+		}
+	}
+	return out
+}
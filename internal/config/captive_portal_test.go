@@ -0,0 +1,30 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCaptivePortalHelpers(t *testing.T) {
+	zones := []Zone{
+		{Name: "guest", Interfaces: []string{"eth2"}, Services: &ZoneServices{DNS: true, CaptivePortal: true}},
+		{Name: "lan", Interfaces: []string{"eth1"}, Services: &ZoneServices{DNS: true}},
+	}
+	ifaces := []Interface{{Name: "eth1", Zone: "lan"}, {Name: "wlan0", Zone: "guest"}}
+	if got := CaptivePortalInterfaces(zones, ifaces); !reflect.DeepEqual(got, []string{"eth2", "wlan0"}) {
+		t.Errorf("CaptivePortalInterfaces = %v", got)
+	}
+
+	var p *CaptivePortalConfig
+	if got := p.APIURL("192.168.50.1"); got != "" {
+		t.Errorf("default APIURL = %q, want none without TLS", got)
+	}
+	p = &CaptivePortalConfig{TLSCert: "c", TLSKey: "k"}
+	if got := p.APIURL("192.168.50.1"); got != "https://192.168.50.1:2051/api/captive" {
+		t.Errorf("TLS APIURL = %q", got)
+	}
+	p.Hostname = "portal.example.com"
+	if got := p.APIURL("192.168.50.1"); got != "https://portal.example.com:2051/api/captive" {
+		t.Errorf("TLS APIURL with hostname = %q", got)
+	}
+}
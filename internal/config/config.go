@@ -60,6 +60,15 @@ type Config struct {
 	// UPnP IGD configuration
 	UPnP *UPnPConfig `hcl:"upnp,block" json:"upnp,omitempty"`
 
+	// Guest portal for zones with services { captive_portal = true }
+	CaptivePortal *CaptivePortalConfig `hcl:"captive_portal,block" json:"captive_portal,omitempty"`
+
+	// Embedded SNMP agent
+	SNMP *SNMPConfig `hcl:"snmp,block" json:"snmp,omitempty"`
+
+	// Inline intrusion prevention for policy rules with action "inspect"
+	IPS *IPSConfig `hcl:"ips,block" json:"ips,omitempty"`
+
 	// NTP configuration
 	NTP *NTPConfig `hcl:"ntp,block" json:"ntp,omitempty"`
 
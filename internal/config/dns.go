@@ -25,18 +25,48 @@ type DNS struct {
 	Recursive *RecursiveConfig `hcl:"recursive,block" json:"recursive,omitempty"`
 
 	// DNSSEC validation for upstream queries
-	DNSSEC bool `hcl:"dnssec,optional" json:"dnssec,omitempty"`
+	DNSSEC          bool     `hcl:"dnssec,optional" json:"dnssec,omitempty"`
+	TrustAnchors    []string `hcl:"trust_anchors,optional" json:"trust_anchors,omitempty"`         // DS/DNSKEY records (default: IANA root KSKs)
+	TrustAnchorFile string   `hcl:"trust_anchor_file,optional" json:"trust_anchor_file,omitempty"` // Zone-format file of DS/DNSKEY records
+
+	// Query log retention (applies when any serve block sets query_logging)
+	QueryLogRetentionDays int `hcl:"query_log_retention_days,optional" json:"query_log_retention_days,omitempty"` // Default: 7
 
 	// Egress Filter (DNS Wall)
 	// If enabled, firewall blocks outbound traffic to IPs not recently resolved by this DNS server.
 	EgressFilter    bool `hcl:"egress_filter,optional" json:"egress_filter,omitempty"`
 	EgressFilterTTL int  `hcl:"egress_filter_ttl,optional" json:"egress_filter_ttl,omitempty"` // Seconds (default: matches record TTL)
 
+	// Filtering profiles, selected per client at query time
+	FilterProfiles []DNSFilterProfile `hcl:"filter_profile,block" json:"filter_profiles,omitempty"`
+
 	// Zone-based serving and inspection
 	Serve   []DNSServe   `hcl:"serve,block" json:"serve,omitempty"`
 	Inspect []DNSInspect `hcl:"inspect,block" json:"inspect,omitempty"`
 }
 
+// DNSFilterProfile is a named set of filtering rules (e.g. "kids", "iot").
+// A client assigned to a profile is filtered by the profile instead of the
+// serve block's blocklists; threat intel blocks always apply.
+//
+// Assignment, most specific first: a DHCP reservation's dns_profile, a
+// device tag from device identities, a source CIDR, then the serve
+// block's filter_profile for the client's zone.
+type DNSFilterProfile struct {
+	Name string `hcl:"name,label" json:"name"`
+
+	// Filtering
+	Blocklists     []DNSBlocklist `hcl:"blocklist,block" json:"blocklists,omitempty"`
+	Allowlist      []string       `hcl:"allowlist,optional" json:"allowlist,omitempty"`             // Domains (and subdomains) never blocked
+	SafeSearch     bool           `hcl:"safe_search,optional" json:"safe_search,omitempty"`         // Rewrite search engines and YouTube to their safe variants
+	BlockedAddress string         `hcl:"blocked_address,optional" json:"blocked_address,omitempty"` // Answer blocked A/AAAA queries with this IP (default: NXDOMAIN)
+	BlockedTTL     int            `hcl:"blocked_ttl,optional" json:"blocked_ttl,omitempty"`         // TTL for blocked answers (default: 60)
+
+	// Assignment
+	DeviceTags []string `hcl:"device_tags,optional" json:"device_tags,omitempty"` // Device identity tags (e.g. "kids")
+	Sources    []string `hcl:"sources,optional" json:"sources,omitempty"`         // Client CIDRs or IPs
+}
+
 // DNSServe configures DNS serving for a specific zone.
 // The zone label supports wildcards (e.g., "internal-*").
 type DNSServe struct {
@@ -57,6 +87,14 @@ type DNSServe struct {
 	QueryLogging     bool `hcl:"query_logging,optional" json:"query_logging,omitempty"`
 	RateLimitPerSec  int  `hcl:"rate_limit_per_sec,optional" json:"rate_limit_per_sec,omitempty"`
 
+	// Default filtering profile for clients in this zone
+	FilterProfile string `hcl:"filter_profile,optional" json:"filter_profile,omitempty"`
+
+	// Rate limiting (the strictest serve block applies to all listeners)
+	RateLimitAction   string `hcl:"rate_limit_action,optional" json:"rate_limit_action,omitempty"`     // "refuse" (default), "drop", "truncate"
+	ResponseRateLimit int    `hcl:"response_rate_limit,optional" json:"response_rate_limit,omitempty"` // Identical UDP responses/sec per client netblock (RRL)
+	RRLSlip           int    `hcl:"rrl_slip,optional" json:"rrl_slip,omitempty"`                       // Send every Nth limited response truncated (default: 2)
+
 	// Filtering
 	Blocklists     []DNSBlocklist `hcl:"blocklist,block" json:"blocklists,omitempty"`
 	Allowlist      []string       `hcl:"allowlist,optional" json:"allowlist,omitempty"`
@@ -381,6 +381,9 @@ dns_server {
   rebind_protection = true
   query_logging     = true
   rate_limit_per_sec = 100
+  rate_limit_action  = "drop"
+  response_rate_limit = 20
+  rrl_slip           = 3
 }
 `
 	var cfg Config
@@ -402,6 +405,9 @@ dns_server {
 	if dns.RateLimitPerSec != 100 {
 		t.Errorf("Expected rate_limit_per_sec 100, got %d", dns.RateLimitPerSec)
 	}
+	if dns.RateLimitAction != "drop" || dns.ResponseRateLimit != 20 || dns.RRLSlip != 3 {
+		t.Errorf("Unexpected rate limit settings: action=%q rrl=%d slip=%d", dns.RateLimitAction, dns.ResponseRateLimit, dns.RRLSlip)
+	}
 }
 
 func TestParseDNSCaching(t *testing.T) {
@@ -12,7 +12,10 @@ type GeoIPConfig struct {
 	// For DB-IP: /var/lib/glacic/geoip/dbip-country-lite.mmdb
 	DatabasePath string `hcl:"database_path,optional" json:"database_path,omitempty"`
 
-	// AutoUpdate enables automatic database updates (future feature).
+	// AutoUpdate checks the database file hourly and, when it has been
+	// replaced (e.g. by geoipupdate), reloads it and atomically swaps the
+	// contents of the firewall's country sets. Downloading is left to the
+	// update tool.
 	AutoUpdate bool `hcl:"auto_update,optional" json:"auto_update,omitempty"`
 
 	// LicenseKey for premium MaxMind database updates (future feature).
@@ -193,6 +193,12 @@ func (cf *ConfigFile) syncAPI() error {
 		if le.Staging {
 			leb.SetAttributeValue("staging", cty.BoolVal(le.Staging))
 		}
+		if le.Challenge != "" {
+			leb.SetAttributeValue("challenge", cty.StringVal(le.Challenge))
+		}
+		if le.DirectoryURL != "" {
+			leb.SetAttributeValue("directory_url", cty.StringVal(le.DirectoryURL))
+		}
 	}
 
 	return nil
@@ -424,7 +430,7 @@ func (cf *ConfigFile) syncServices() error {
 	// Clear existing blocks
 	for _, block := range body.Blocks() {
 		switch block.Type() {
-		case "dhcp", "dns", "dns_server", "mdns", "upnp", "ntp", "syslog", "ddns":
+		case "dhcp", "dns", "dns_server", "mdns", "upnp", "captive_portal", "snmp", "ips", "ntp", "syslog", "ddns":
 			body.RemoveBlock(block)
 		}
 	}
@@ -440,6 +446,12 @@ func (cf *ConfigFile) syncServices() error {
 		if dhcp.Mode != "" {
 			b.SetAttributeValue("mode", cty.StringVal(dhcp.Mode))
 		}
+		if dhcp.ConflictDetection != nil {
+			b.SetAttributeValue("conflict_detection", cty.BoolVal(*dhcp.ConflictDetection))
+		}
+		if dhcp.QuarantineTime != "" {
+			b.SetAttributeValue("quarantine_time", cty.StringVal(dhcp.QuarantineTime))
+		}
 		// Scopes
 		for _, scope := range dhcp.Scopes {
 			sb := b.AppendNewBlock("scope", []string{scope.Name})
@@ -454,14 +466,76 @@ func (cf *ConfigFile) syncServices() error {
 			if scope.LeaseTime != "" {
 				sbb.SetAttributeValue("lease_time", cty.StringVal(scope.LeaseTime))
 			}
+			if scope.RangeStartV6 != "" {
+				sbb.SetAttributeValue("range_start_v6", cty.StringVal(scope.RangeStartV6))
+				sbb.SetAttributeValue("range_end_v6", cty.StringVal(scope.RangeEndV6))
+			}
+			if len(scope.DNSServersV6) > 0 {
+				sbb.SetAttributeValue("dns_v6", toCtyStringList(scope.DNSServersV6))
+			}
+			// Option classes
+			for _, class := range scope.Classes {
+				cbb := sbb.AppendNewBlock("class", []string{class.Name}).Body()
+				if class.VendorClass != "" {
+					cbb.SetAttributeValue("vendor_class", cty.StringVal(class.VendorClass))
+				}
+				if class.UserClass != "" {
+					cbb.SetAttributeValue("user_class", cty.StringVal(class.UserClass))
+				}
+				if class.MACPrefix != "" {
+					cbb.SetAttributeValue("mac_prefix", cty.StringVal(class.MACPrefix))
+				}
+				if len(class.Architectures) > 0 {
+					cbb.SetAttributeValue("architectures", toCtyIntList(class.Architectures))
+				}
+				cbb.SetAttributeValue("options", toCtyStringMap(class.Options))
+			}
 			// Reservations
 			for _, res := range scope.Reservations {
 				rb := sbb.AppendNewBlock("reservation", []string{res.MAC})
 				rbb := rb.Body()
-				rbb.SetAttributeValue("ip", cty.StringVal(res.IP))
+				if res.IP != "" {
+					rbb.SetAttributeValue("ip", cty.StringVal(res.IP))
+				}
 				if res.Hostname != "" {
 					rbb.SetAttributeValue("hostname", cty.StringVal(res.Hostname))
 				}
+				if res.DUID != "" {
+					rbb.SetAttributeValue("duid", cty.StringVal(res.DUID))
+				}
+				if res.IPv6 != "" {
+					rbb.SetAttributeValue("ip_v6", cty.StringVal(res.IPv6))
+				}
+			}
+		}
+		// Relays
+		for _, relay := range dhcp.Relays {
+			rb := b.AppendNewBlock("relay", []string{relay.Interface})
+			rbb := rb.Body()
+			if len(relay.Servers) > 0 {
+				rbb.SetAttributeValue("servers", toCtyStringList(relay.Servers))
+			}
+			if len(relay.ServersV6) > 0 {
+				rbb.SetAttributeValue("servers_v6", toCtyStringList(relay.ServersV6))
+			}
+			if relay.CircuitID != "" {
+				rbb.SetAttributeValue("circuit_id", cty.StringVal(relay.CircuitID))
+			}
+			if relay.RemoteID != "" {
+				rbb.SetAttributeValue("remote_id", cty.StringVal(relay.RemoteID))
+			}
+		}
+		// Failover
+		if fo := dhcp.Failover; fo != nil {
+			fbb := b.AppendNewBlock("failover", nil).Body()
+			if fo.Mode != "" {
+				fbb.SetAttributeValue("mode", cty.StringVal(fo.Mode))
+			}
+			if fo.MCLT != "" {
+				fbb.SetAttributeValue("mclt", cty.StringVal(fo.MCLT))
+			}
+			if fo.Split != 0 {
+				fbb.SetAttributeValue("split", cty.NumberIntVal(int64(fo.Split)))
 			}
 		}
 	}
@@ -538,6 +612,109 @@ func (cf *ConfigFile) syncServices() error {
 		}
 	}
 
+	// Captive portal
+	if cf.Config.CaptivePortal != nil {
+		cp := cf.Config.CaptivePortal
+		block := body.AppendNewBlock("captive_portal", nil)
+		b := block.Body()
+		if cp.Auth != "" {
+			b.SetAttributeValue("auth", cty.StringVal(cp.Auth))
+		}
+		if cp.Password != "" {
+			b.SetAttributeValue("password", cty.StringVal(cp.Password))
+		}
+		if len(cp.Vouchers) > 0 {
+			b.SetAttributeValue("vouchers", toCtyStringList(cp.Vouchers))
+		}
+		if cp.SessionTimeout != "" {
+			b.SetAttributeValue("session_timeout", cty.StringVal(cp.SessionTimeout))
+		}
+		if cp.Title != "" {
+			b.SetAttributeValue("title", cty.StringVal(cp.Title))
+		}
+		if cp.Terms != "" {
+			b.SetAttributeValue("terms", cty.StringVal(cp.Terms))
+		}
+		if cp.Port > 0 {
+			b.SetAttributeValue("port", cty.NumberIntVal(int64(cp.Port)))
+		}
+		if cp.TLSCert != "" {
+			b.SetAttributeValue("tls_cert", cty.StringVal(cp.TLSCert))
+		}
+		if cp.TLSKey != "" {
+			b.SetAttributeValue("tls_key", cty.StringVal(cp.TLSKey))
+		}
+		if cp.TLSPort > 0 {
+			b.SetAttributeValue("tls_port", cty.NumberIntVal(int64(cp.TLSPort)))
+		}
+		if cp.Hostname != "" {
+			b.SetAttributeValue("hostname", cty.StringVal(cp.Hostname))
+		}
+	}
+
+	// SNMP
+	if cf.Config.SNMP != nil {
+		snmp := cf.Config.SNMP
+		block := body.AppendNewBlock("snmp", nil)
+		b := block.Body()
+		b.SetAttributeValue("enabled", cty.BoolVal(snmp.Enabled))
+		setIfNotEmpty := func(body *hclwrite.Body, name, value string) {
+			if value != "" {
+				body.SetAttributeValue(name, cty.StringVal(value))
+			}
+		}
+		setIfNotEmpty(b, "listen", snmp.Listen)
+		setIfNotEmpty(b, "community", snmp.Community)
+		setIfNotEmpty(b, "contact", snmp.Contact)
+		setIfNotEmpty(b, "location", snmp.Location)
+		setIfNotEmpty(b, "engine_id", snmp.EngineID)
+		setIfNotEmpty(b, "enterprise_oid", snmp.EnterpriseOID)
+		for _, u := range snmp.Users {
+			ub := b.AppendNewBlock("user", []string{u.Name}).Body()
+			setIfNotEmpty(ub, "auth_protocol", u.AuthProtocol)
+			setIfNotEmpty(ub, "auth_password", u.AuthPassword)
+			setIfNotEmpty(ub, "priv_protocol", u.PrivProtocol)
+			setIfNotEmpty(ub, "priv_password", u.PrivPassword)
+		}
+		for _, t := range snmp.Traps {
+			tb := b.AppendNewBlock("trap", []string{t.Address}).Body()
+			setIfNotEmpty(tb, "community", t.Community)
+			setIfNotEmpty(tb, "user", t.User)
+		}
+	}
+
+	// IPS
+	if cf.Config.IPS != nil {
+		ips := cf.Config.IPS
+		block := body.AppendNewBlock("ips", nil)
+		b := block.Body()
+		b.SetAttributeValue("enabled", cty.BoolVal(ips.Enabled))
+		if ips.Queue > 0 {
+			b.SetAttributeValue("queue", cty.NumberIntVal(int64(ips.Queue)))
+		}
+		if ips.QueueLength > 0 {
+			b.SetAttributeValue("queue_length", cty.NumberIntVal(int64(ips.QueueLength)))
+		}
+		if ips.FailOpen {
+			b.SetAttributeValue("fail_open", cty.True)
+		}
+		if ips.AlertOnly {
+			b.SetAttributeValue("alert_only", cty.True)
+		}
+		if len(ips.RuleFiles) > 0 {
+			b.SetAttributeValue("rule_files", toCtyStringList(ips.RuleFiles))
+		}
+		if ips.Rules != "" {
+			b.SetAttributeValue("rules", cty.StringVal(ips.Rules))
+		}
+		if len(ips.Vars) > 0 {
+			b.SetAttributeValue("vars", toCtyStringMap(ips.Vars))
+		}
+		if len(ips.DisableSIDs) > 0 {
+			b.SetAttributeValue("disable_sids", toCtyIntList(ips.DisableSIDs))
+		}
+	}
+
 	// NTP
 	if cf.Config.NTP != nil {
 		ntp := cf.Config.NTP
@@ -659,6 +836,32 @@ func (cf *ConfigFile) syncIntegrations() error {
 		if rep.SecretKey != "" {
 			b.SetAttributeValue("secret_key", cty.StringVal(rep.SecretKey))
 		}
+		if rep.TLSCert != "" {
+			b.SetAttributeValue("tls_cert", cty.StringVal(rep.TLSCert))
+		}
+		if rep.TLSKey != "" {
+			b.SetAttributeValue("tls_key", cty.StringVal(rep.TLSKey))
+		}
+		if rep.TLSCA != "" {
+			b.SetAttributeValue("tls_ca", cty.StringVal(rep.TLSCA))
+		}
+		if rep.ConntrackSync {
+			b.SetAttributeValue("conntrack_sync", cty.BoolVal(true))
+		}
+		for _, inst := range rep.VRRP {
+			vb := b.AppendNewBlock("vrrp", []string{inst.Interface}).Body()
+			vb.SetAttributeValue("vrid", cty.NumberIntVal(int64(inst.VRID)))
+			if inst.Priority != 0 {
+				vb.SetAttributeValue("priority", cty.NumberIntVal(int64(inst.Priority)))
+			}
+			vb.SetAttributeValue("virtual_ips", toCtyStringList(inst.VirtualIPs))
+			if inst.Interval != "" {
+				vb.SetAttributeValue("advert_interval", cty.StringVal(inst.Interval))
+			}
+			if inst.Preempt != nil {
+				vb.SetAttributeValue("preempt", cty.BoolVal(*inst.Preempt))
+			}
+		}
 	}
 
 	// MultiWAN
@@ -1019,12 +1222,130 @@ func (cf *ConfigFile) syncInterfaces() error {
 		if len(iface.IPv6) > 0 {
 			blockBody.SetAttributeValue("ipv6", toCtyStringList(iface.IPv6))
 		}
+		if iface.DHCPv6 {
+			blockBody.SetAttributeValue("dhcp_v6", cty.BoolVal(iface.DHCPv6))
+		}
+		if iface.RA {
+			blockBody.SetAttributeValue("ra", cty.BoolVal(iface.RA))
+		}
+		if iface.PrefixDelegation {
+			blockBody.SetAttributeValue("prefix_delegation", cty.BoolVal(iface.PrefixDelegation))
+		}
+		if iface.PDLength > 0 {
+			blockBody.SetAttributeValue("pd_length", cty.NumberIntVal(int64(iface.PDLength)))
+		}
+		if iface.PDFrom != "" {
+			blockBody.SetAttributeValue("pd_from", cty.StringVal(iface.PDFrom))
+			blockBody.SetAttributeValue("pd_subnet_id", cty.NumberIntVal(int64(iface.PDSubnetID)))
+		}
 		if iface.MTU > 0 {
 			blockBody.SetAttributeValue("mtu", cty.NumberIntVal(int64(iface.MTU)))
 		}
 		if iface.Zone != "" {
 			blockBody.SetAttributeValue("zone", cty.StringVal(iface.Zone))
 		}
+		if p := iface.PPPoE; p != nil {
+			pppBody := blockBody.AppendNewBlock("pppoe", nil).Body()
+			pppBody.SetAttributeValue("username", cty.StringVal(p.Username))
+			if p.Password != "" {
+				pppBody.SetAttributeValue("password", cty.StringVal(p.Password))
+			}
+			if p.VLAN > 0 {
+				pppBody.SetAttributeValue("vlan", cty.NumberIntVal(int64(p.VLAN)))
+			}
+			if p.ServiceName != "" {
+				pppBody.SetAttributeValue("service_name", cty.StringVal(p.ServiceName))
+			}
+			if p.ACName != "" {
+				pppBody.SetAttributeValue("ac_name", cty.StringVal(p.ACName))
+			}
+			if p.Link != "" {
+				pppBody.SetAttributeValue("link", cty.StringVal(p.Link))
+			}
+			if p.MTU > 0 {
+				pppBody.SetAttributeValue("mtu", cty.NumberIntVal(int64(p.MTU)))
+			}
+			if p.IPv6 {
+				pppBody.SetAttributeValue("ipv6", cty.BoolVal(p.IPv6))
+			}
+			if p.LCPEchoInterval > 0 {
+				pppBody.SetAttributeValue("lcp_echo_interval", cty.NumberIntVal(int64(p.LCPEchoInterval)))
+			}
+			if p.LCPEchoFailure > 0 {
+				pppBody.SetAttributeValue("lcp_echo_failure", cty.NumberIntVal(int64(p.LCPEchoFailure)))
+			}
+		}
+		if b := iface.Bridge; b != nil {
+			brBody := blockBody.AppendNewBlock("bridge", nil).Body()
+			if len(b.Interfaces) > 0 {
+				brBody.SetAttributeValue("interfaces", toCtyStringList(b.Interfaces))
+			}
+			if b.STP {
+				brBody.SetAttributeValue("stp", cty.BoolVal(b.STP))
+			}
+			if b.Priority != nil {
+				brBody.SetAttributeValue("priority", cty.NumberIntVal(int64(*b.Priority)))
+			}
+			if b.VLANFiltering {
+				brBody.SetAttributeValue("vlan_filtering", cty.BoolVal(b.VLANFiltering))
+			}
+			if b.DefaultPVID > 0 {
+				brBody.SetAttributeValue("default_pvid", cty.NumberIntVal(int64(b.DefaultPVID)))
+			}
+			if len(b.VLANs) > 0 {
+				brBody.SetAttributeValue("vlans", toCtyIntList(b.VLANs))
+			}
+			for _, port := range b.Ports {
+				portBody := brBody.AppendNewBlock("port", []string{port.Name}).Body()
+				if port.PVID > 0 {
+					portBody.SetAttributeValue("pvid", cty.NumberIntVal(int64(port.PVID)))
+				}
+				if len(port.VLANs) > 0 {
+					portBody.SetAttributeValue("vlans", toCtyIntList(port.VLANs))
+				}
+			}
+		}
+		if v := iface.VXLAN; v != nil {
+			vxBody := blockBody.AppendNewBlock("vxlan", nil).Body()
+			vxBody.SetAttributeValue("vni", cty.NumberIntVal(int64(v.VNI)))
+			if v.Remote != "" {
+				vxBody.SetAttributeValue("remote", cty.StringVal(v.Remote))
+			}
+			if v.Group != "" {
+				vxBody.SetAttributeValue("group", cty.StringVal(v.Group))
+			}
+			if v.Local != "" {
+				vxBody.SetAttributeValue("local", cty.StringVal(v.Local))
+			}
+			if v.Device != "" {
+				vxBody.SetAttributeValue("device", cty.StringVal(v.Device))
+			}
+			if v.Port > 0 {
+				vxBody.SetAttributeValue("port", cty.NumberIntVal(int64(v.Port)))
+			}
+			if v.TTL > 0 {
+				vxBody.SetAttributeValue("ttl", cty.NumberIntVal(int64(v.TTL)))
+			}
+			if v.Learning != nil {
+				vxBody.SetAttributeValue("learning", cty.BoolVal(*v.Learning))
+			}
+		}
+		if g := iface.GRE; g != nil {
+			greBody := blockBody.AppendNewBlock("gre", nil).Body()
+			greBody.SetAttributeValue("remote", cty.StringVal(g.Remote))
+			if g.Local != "" {
+				greBody.SetAttributeValue("local", cty.StringVal(g.Local))
+			}
+			if g.Tap {
+				greBody.SetAttributeValue("tap", cty.BoolVal(g.Tap))
+			}
+			if g.Key > 0 {
+				greBody.SetAttributeValue("key", cty.NumberIntVal(g.Key))
+			}
+			if g.TTL > 0 {
+				greBody.SetAttributeValue("ttl", cty.NumberIntVal(int64(g.TTL)))
+			}
+		}
 	}
 
 	return nil
@@ -1042,6 +1363,18 @@ func toCtyStringList(strs []string) cty.Value {
 	return cty.ListVal(vals)
 }
 
+// toCtyStringMap converts a map[string]string to cty.Value map
+func toCtyStringMap(m map[string]string) cty.Value {
+	if len(m) == 0 {
+		return cty.MapValEmpty(cty.String)
+	}
+	vals := make(map[string]cty.Value, len(m))
+	for k, v := range m {
+		vals[k] = cty.StringVal(v)
+	}
+	return cty.MapVal(vals)
+}
+
 // toCtyIntList converts a []int to cty.Value list
 func toCtyIntList(ints []int) cty.Value {
 	if len(ints) == 0 {
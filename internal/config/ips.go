@@ -0,0 +1,59 @@
+package config
+
+// IPS defaults.
+const (
+	DefaultIPSQueue       = 200
+	DefaultIPSQueueLength = 4096
+)
+
+// IPSConfig configures the inline intrusion prevention engine. Traffic is
+// steered into it by policy rules with action = "inspect"; every packet of
+// an inspected connection is queued to userspace and matched against the
+// signatures before a verdict is issued.
+type IPSConfig struct {
+	Enabled bool `hcl:"enabled,optional" json:"enabled"`
+
+	// NFQUEUE number (default: 200). Must differ from the inline learning
+	// queue.
+	Queue int `hcl:"queue,optional" json:"queue,omitempty"`
+
+	// Packets the kernel holds for the engine before the queue overflows
+	// (default: 4096)
+	QueueLength int `hcl:"queue_length,optional" json:"queue_length,omitempty"`
+
+	// Accept packets when the queue overflows or the engine is not running.
+	// By default they are dropped (fail closed).
+	FailOpen bool `hcl:"fail_open,optional" json:"fail_open,omitempty"`
+
+	// Only alert on drop signatures (IDS mode). Reject signatures are not
+	// supported and are skipped when loading.
+	AlertOnly bool `hcl:"alert_only,optional" json:"alert_only,omitempty"`
+
+	// Suricata/Snort rule files; glob patterns are expanded
+	RuleFiles []string `hcl:"rule_files,optional" json:"rule_files,omitempty"`
+
+	// Inline rules, one per line
+	Rules string `hcl:"rules,optional" json:"rules,omitempty"`
+
+	// Address and port variables such as HOME_NET, without the "$"
+	Vars map[string]string `hcl:"vars,optional" json:"vars,omitempty"`
+
+	// Signatures to skip, by sid
+	DisableSIDs []int `hcl:"disable_sids,optional" json:"disable_sids,omitempty"`
+}
+
+// QueueNum returns the NFQUEUE number used by the engine.
+func (c *IPSConfig) QueueNum() int {
+	if c.Queue == 0 {
+		return DefaultIPSQueue
+	}
+	return c.Queue
+}
+
+// QueueLen returns the kernel queue length.
+func (c *IPSConfig) QueueLen() int {
+	if c.QueueLength == 0 {
+		return DefaultIPSQueueLength
+	}
+	return c.QueueLength
+}
@@ -27,33 +27,39 @@ func migrateDNSServer(c *Config) error {
 	c.DNS.UpstreamDNSCrypt = c.DNSServer.UpstreamDNSCrypt
 	c.DNS.Recursive = c.DNSServer.Recursive
 	c.DNS.DNSSEC = c.DNSServer.DNSSEC
+	c.DNS.TrustAnchors = c.DNSServer.TrustAnchors
+	c.DNS.TrustAnchorFile = c.DNSServer.TrustAnchorFile
+	c.DNS.QueryLogRetentionDays = c.DNSServer.QueryLogRetentionDays
 
 	// Only create serve block if server was enabled
 	if c.DNSServer.Enabled {
 		serve := DNSServe{
-			Zone:             "*",
-			ListenPort:       c.DNSServer.ListenPort,
-			LocalDomain:      c.DNSServer.LocalDomain,
-			ExpandHosts:      c.DNSServer.ExpandHosts,
-			DHCPIntegration:  c.DNSServer.DHCPIntegration,
-			AuthoritativeFor: c.DNSServer.AuthoritativeFor,
-			RebindProtection: c.DNSServer.RebindProtection,
-			QueryLogging:     c.DNSServer.QueryLogging,
-			RateLimitPerSec:  c.DNSServer.RateLimitPerSec,
-			Blocklists:       c.DNSServer.Blocklists,
-			Allowlist:        c.DNSServer.Allowlist,
-			BlockedTTL:       c.DNSServer.BlockedTTL,
-			BlockedAddress:   c.DNSServer.BlockedAddress,
-			CacheEnabled:     c.DNSServer.CacheEnabled,
-			CacheSize:        c.DNSServer.CacheSize,
-			CacheMinTTL:      c.DNSServer.CacheMinTTL,
-			CacheMaxTTL:      c.DNSServer.CacheMaxTTL,
-			NegativeCacheTTL: c.DNSServer.NegativeCacheTTL,
-			DoHServer:        c.DNSServer.DoHServer,
-			DoTServer:        c.DNSServer.DoTServer,
-			DNSCryptServer:   c.DNSServer.DNSCryptServer,
-			Hosts:            c.DNSServer.Hosts,
-			Zones:            c.DNSServer.Zones,
+			Zone:              "*",
+			ListenPort:        c.DNSServer.ListenPort,
+			LocalDomain:       c.DNSServer.LocalDomain,
+			ExpandHosts:       c.DNSServer.ExpandHosts,
+			DHCPIntegration:   c.DNSServer.DHCPIntegration,
+			AuthoritativeFor:  c.DNSServer.AuthoritativeFor,
+			RebindProtection:  c.DNSServer.RebindProtection,
+			QueryLogging:      c.DNSServer.QueryLogging,
+			RateLimitPerSec:   c.DNSServer.RateLimitPerSec,
+			RateLimitAction:   c.DNSServer.RateLimitAction,
+			ResponseRateLimit: c.DNSServer.ResponseRateLimit,
+			RRLSlip:           c.DNSServer.RRLSlip,
+			Blocklists:        c.DNSServer.Blocklists,
+			Allowlist:         c.DNSServer.Allowlist,
+			BlockedTTL:        c.DNSServer.BlockedTTL,
+			BlockedAddress:    c.DNSServer.BlockedAddress,
+			CacheEnabled:      c.DNSServer.CacheEnabled,
+			CacheSize:         c.DNSServer.CacheSize,
+			CacheMinTTL:       c.DNSServer.CacheMinTTL,
+			CacheMaxTTL:       c.DNSServer.CacheMaxTTL,
+			NegativeCacheTTL:  c.DNSServer.NegativeCacheTTL,
+			DoHServer:         c.DNSServer.DoHServer,
+			DoTServer:         c.DNSServer.DoTServer,
+			DNSCryptServer:    c.DNSServer.DNSCryptServer,
+			Hosts:             c.DNSServer.Hosts,
+			Zones:             c.DNSServer.Zones,
 		}
 		c.DNS.Serve = append(c.DNS.Serve, serve)
 	}
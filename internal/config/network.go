@@ -1,5 +1,11 @@
 package config
 
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
 // Interface represents a physical interface configuration.
 type Interface struct {
 	Name        string   `hcl:"name,label" json:"name"`
@@ -12,6 +18,16 @@ type Interface struct {
 	DHCP        bool     `hcl:"dhcp,optional" json:"dhcp"`
 	DHCPv6      bool     `hcl:"dhcp_v6,optional" json:"dhcp_v6"` // Enable DHCPv6 Client (WAN)
 	RA          bool     `hcl:"ra,optional" json:"ra"`           // Enable Router Advertisements (Server)
+
+	// DHCPv6 Prefix Delegation (RFC 8415 IA_PD)
+	// On the WAN side, prefix_delegation requests a prefix alongside dhcp_v6.
+	// On the LAN side, pd_from names that WAN interface and pd_subnet_id picks
+	// which /64 of the delegated prefix is assigned here.
+	PrefixDelegation bool   `hcl:"prefix_delegation,optional" json:"prefix_delegation,omitempty"`
+	PDLength         int    `hcl:"pd_length,optional" json:"pd_length,omitempty"` // Requested prefix length hint (e.g. 56); 0 lets the ISP choose
+	PDFrom           string `hcl:"pd_from,optional" json:"pd_from,omitempty"`
+	PDSubnetID       int    `hcl:"pd_subnet_id,optional" json:"pd_subnet_id,omitempty"`
+
 	// DHCPClient specifies how DHCP client is managed:
 	//   - "builtin" (default): Use Glacic's built-in DHCP client
 	//   - "external": External DHCP client (udhcpc, dhclient, etc.) manages this interface
@@ -26,12 +42,23 @@ type Interface struct {
 	// - Locally initiated traffic marked for this table (e.g. via specific IP) uses the table.
 	Table int `hcl:"table,optional" json:"table,omitempty"`
 
+	// PPPoE runs a PPPoE session over this interface. The session gets a
+	// PPP link of its own, which joins the interface's zone and carries
+	// the default route.
+	PPPoE *PPPoE `hcl:"pppoe,block" json:"pppoe,omitempty"`
+
 	Gateway   string `hcl:"gateway,optional" json:"gateway,omitempty"`       // Default gateway for static config
 	GatewayV6 string `hcl:"gateway_v6,optional" json:"gateway_v6,omitempty"` // Default gateway for static IPv6
 	MTU       int    `hcl:"mtu,optional" json:"mtu,omitempty"`
 	Bond      *Bond  `hcl:"bond,block" json:"bond,omitempty"`
 	VLANs     []VLAN `hcl:"vlan,block" json:"vlans,omitempty"`
 
+	// Virtual interface types. Glacic creates the interface if it does not
+	// exist and deletes it once it is removed from the configuration.
+	Bridge *Bridge `hcl:"bridge,block" json:"bridge,omitempty"`
+	VXLAN  *VXLAN  `hcl:"vxlan,block" json:"vxlan,omitempty"`
+	GRE    *GRE    `hcl:"gre,block" json:"gre,omitempty"`
+
 	// Anti-Lockout protection (sandbox mode only)
 	// When true, implicit accept rules are created for this interface in the
 	// high-priority glacic-lockout table. This ensures control plane access
@@ -51,12 +78,93 @@ type Interface struct {
 	TLS *TLSConfig `hcl:"tls,block" json:"tls,omitempty"` // TLS/Certificate configuration for this interface
 }
 
+// DelegatedPrefixSetName returns the firewall IPSet holding the prefix an
+// interface receives from DHCPv6 prefix delegation (pd_from). Policy rules
+// can reference it like any other IPSet.
+func DelegatedPrefixSetName(iface string) string {
+	return "pd_" + iface
+}
+
+// PPPoE configures a PPPoE client session (RFC 2516), as used by most DSL
+// and many fibre ISPs.
+type PPPoE struct {
+	Username string `hcl:"username" json:"username"`
+	Password string `hcl:"password,optional" json:"password,omitempty"`
+	// VLAN tags the session, e.g. 7 on many fibre ISPs; 0 runs it untagged
+	VLAN int `hcl:"vlan,optional" json:"vlan,omitempty"`
+	// ServiceName and ACName select an access concentrator when several answer
+	ServiceName string `hcl:"service_name,optional" json:"service_name,omitempty"`
+	ACName      string `hcl:"ac_name,optional" json:"ac_name,omitempty"`
+	// Link names the PPP interface; defaults to ppp-<interface>
+	Link string `hcl:"link,optional" json:"link,omitempty"`
+	// MTU of the PPP link (default 1492). Up to 1500 is negotiated with
+	// RFC 4638, which needs an interface MTU 8 bytes larger.
+	MTU  int  `hcl:"mtu,optional" json:"mtu,omitempty"`
+	IPv6 bool `hcl:"ipv6,optional" json:"ipv6,omitempty"` // Negotiate IPv6CP and accept router advertisements
+
+	LCPEchoInterval int `hcl:"lcp_echo_interval,optional" json:"lcp_echo_interval,omitempty"` // Seconds between keepalives (default 10)
+	LCPEchoFailure  int `hcl:"lcp_echo_failure,optional" json:"lcp_echo_failure,omitempty"`   // Missed keepalives before redialling (default 3)
+}
+
+// LinkName returns the name of the PPP interface for a session on iface.
+func (p *PPPoE) LinkName(iface string) string {
+	if p.Link != "" {
+		return p.Link
+	}
+	return "ppp-" + iface
+}
+
 // Bond represents the configuration for a bonding interface.
 type Bond struct {
 	Mode       string   `hcl:"mode,optional" json:"mode,omitempty"`
 	Interfaces []string `hcl:"interfaces,optional" json:"interfaces,omitempty"`
 }
 
+// Bridge configures a Linux bridge. Members can be physical interfaces,
+// VLANs, bonds, VXLANs or gretap tunnels.
+type Bridge struct {
+	Interfaces []string `hcl:"interfaces,optional" json:"interfaces,omitempty"` // Member ports
+	STP        bool     `hcl:"stp,optional" json:"stp,omitempty"`
+	Priority   *int     `hcl:"priority,optional" json:"priority,omitempty"` // STP bridge priority (default 32768)
+
+	// VLANFiltering makes the bridge VLAN-aware. Ports then carry only the
+	// VLANs configured on them; untagged frames join the port's PVID.
+	VLANFiltering bool         `hcl:"vlan_filtering,optional" json:"vlan_filtering,omitempty"`
+	DefaultPVID   int          `hcl:"default_pvid,optional" json:"default_pvid,omitempty"` // PVID of ports without a port block (default 1)
+	VLANs         []int        `hcl:"vlans,optional" json:"vlans,omitempty"`               // Tagged VLANs of the bridge itself, e.g. for vlan blocks
+	Ports         []BridgePort `hcl:"port,block" json:"ports,omitempty"`
+}
+
+// BridgePort sets the VLANs of a bridge member when VLAN filtering is on.
+type BridgePort struct {
+	Name  string `hcl:"name,label" json:"name"`
+	PVID  int    `hcl:"pvid,optional" json:"pvid,omitempty"`   // Untagged VLAN; 0 drops untagged frames
+	VLANs []int  `hcl:"vlans,optional" json:"vlans,omitempty"` // Tagged VLANs
+}
+
+// VXLAN configures a VXLAN tunnel endpoint (RFC 7348). Frames go to
+// Remote, or to the multicast Group, which needs Device.
+type VXLAN struct {
+	VNI      int    `hcl:"vni" json:"vni"`
+	Remote   string `hcl:"remote,optional" json:"remote,omitempty"`
+	Group    string `hcl:"group,optional" json:"group,omitempty"`
+	Local    string `hcl:"local,optional" json:"local,omitempty"`
+	Device   string `hcl:"device,optional" json:"device,omitempty"` // Underlay interface
+	Port     int    `hcl:"port,optional" json:"port,omitempty"`     // UDP port (default 4789)
+	TTL      int    `hcl:"ttl,optional" json:"ttl,omitempty"`
+	Learning *bool  `hcl:"learning,optional" json:"learning,omitempty"` // Learn remote MACs (default true)
+}
+
+// GRE configures a GRE tunnel. With Tap it carries Ethernet frames
+// (gretap), so the tunnel can join a bridge.
+type GRE struct {
+	Remote string `hcl:"remote" json:"remote"`
+	Local  string `hcl:"local,optional" json:"local,omitempty"`
+	Tap    bool   `hcl:"tap,optional" json:"tap,omitempty"`
+	Key    int64  `hcl:"key,optional" json:"key,omitempty"`
+	TTL    int    `hcl:"ttl,optional" json:"ttl,omitempty"` // 0 inherits the inner TTL
+}
+
 // VLAN represents a VLAN configuration nested within an interface.
 type VLAN struct {
 	ID          string   `hcl:"id,label" json:"id"`
@@ -225,38 +333,97 @@ type UIDRouting struct {
 	Comment   string `hcl:"comment,optional" json:"comment,omitempty"`
 }
 
-// FRRConfig holds configuration for Free Range Routing (FRR).
+// FRRConfig holds dynamic routing configuration. The block keeps its
+// historical name, but OSPF and BGP are both run natively; FRR is not
+// involved.
 type FRRConfig struct {
 	Enabled bool  `hcl:"enabled,optional" json:"enabled,omitempty"`
 	OSPF    *OSPF `hcl:"ospf,block" json:"ospf,omitempty"`
 	BGP     *BGP  `hcl:"bgp,block" json:"bgp,omitempty"`
 }
 
-// OSPF configuration.
+// OSPF configuration. Interfaces with an address inside one of the
+// networks run OSPFv2 (IPv4 networks) or OSPFv3 (IPv6 networks) in the
+// matching area. When any IPv6 networks are configured, interfaces with
+// only an IPv4 and a link-local address (such as tunnels) run OSPFv3 in
+// the area of their IPv4 address.
 type OSPF struct {
-	RouterID string     `hcl:"router_id,optional" json:"router_id,omitempty"`
-	Networks []string   `hcl:"networks,optional" json:"networks,omitempty"` // List of CIDRs to advertise
-	Areas    []OSPFArea `hcl:"area,block" json:"areas,omitempty"`
+	RouterID   string          `hcl:"router_id,optional" json:"router_id,omitempty"` // Default: highest interface IPv4 address
+	Networks   []string        `hcl:"networks,optional" json:"networks,omitempty"`   // CIDRs in the backbone area
+	Areas      []OSPFArea      `hcl:"area,block" json:"areas,omitempty"`
+	Interfaces []OSPFInterface `hcl:"interface,block" json:"interfaces,omitempty"`
 }
 
 // OSPFArea configuration.
 type OSPFArea struct {
-	ID       string   `hcl:"id,label" json:"id"`
-	Networks []string `hcl:"networks,optional" json:"networks,omitempty"`
+	ID          string   `hcl:"id,label" json:"id"` // Dotted quad ("0.0.0.1") or number ("1")
+	Networks    []string `hcl:"networks,optional" json:"networks,omitempty"`
+	Stub        bool     `hcl:"stub,optional" json:"stub,omitempty"`                 // No AS-external routes; ABRs inject a default route
+	NoSummary   bool     `hcl:"no_summary,optional" json:"no_summary,omitempty"`     // Totally stubby: ABRs inject only the default route
+	DefaultCost int      `hcl:"default_cost,optional" json:"default_cost,omitempty"` // Cost of the injected default route (default 1)
+}
+
+// AreaID parses the area ID, written either as a dotted quad or as a
+// decimal number.
+func (a *OSPFArea) AreaID() (uint32, error) {
+	if addr, err := netip.ParseAddr(a.ID); err == nil && addr.Is4() {
+		b := addr.As4()
+		return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), nil
+	}
+	id, err := strconv.ParseUint(a.ID, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid OSPF area ID %q", a.ID)
+	}
+	return uint32(id), nil
 }
 
-// BGP configuration.
+// OSPFInterface overrides the OSPF parameters of an interface.
+type OSPFInterface struct {
+	Name          string `hcl:"name,label" json:"name"`
+	Type          string `hcl:"type,optional" json:"type,omitempty"`                     // "broadcast" or "point-to-point" (default: from the link type)
+	Cost          int    `hcl:"cost,optional" json:"cost,omitempty"`                     // Default: 10
+	HelloInterval int    `hcl:"hello_interval,optional" json:"hello_interval,omitempty"` // Seconds; default 10
+	DeadInterval  int    `hcl:"dead_interval,optional" json:"dead_interval,omitempty"`   // Seconds; default 4 * hello_interval
+	Priority      *int   `hcl:"priority,optional" json:"priority,omitempty"`             // DR election priority; 0 never becomes DR (default 1)
+	Passive       bool   `hcl:"passive,optional" json:"passive,omitempty"`               // Advertise the interface's networks without forming adjacencies
+}
+
+// BGP configuration. BGP is spoken natively; FRR is not involved.
 type BGP struct {
-	ASN       int        `hcl:"asn,optional" json:"asn,omitempty"`
-	RouterID  string     `hcl:"router_id,optional" json:"router_id,omitempty"`
-	Neighbors []Neighbor `hcl:"neighbor,block" json:"neighbors,omitempty"`
-	Networks  []string   `hcl:"networks,optional" json:"networks,omitempty"`
+	ASN         int          `hcl:"asn,optional" json:"asn,omitempty"`
+	RouterID    string       `hcl:"router_id,optional" json:"router_id,omitempty"` // Default: highest interface IPv4 address
+	Neighbors   []Neighbor   `hcl:"neighbor,block" json:"neighbors,omitempty"`
+	Networks    []string     `hcl:"networks,optional" json:"networks,omitempty"`
+	Table       int          `hcl:"table,optional" json:"table,omitempty"` // Routing table for learned routes (default: main)
+	PrefixLists []PrefixList `hcl:"prefix_list,block" json:"prefix_lists,omitempty"`
 }
 
 // Neighbor BGP peer configuration.
 type Neighbor struct {
-	IP        string `hcl:"ip,label" json:"ip"`
-	RemoteASN int    `hcl:"remote_asn,optional" json:"remote_asn,omitempty"`
+	IP          string `hcl:"ip,label" json:"ip"`
+	RemoteASN   int    `hcl:"remote_asn,optional" json:"remote_asn,omitempty"`
+	Description string `hcl:"description,optional" json:"description,omitempty"`
+	Port        int    `hcl:"port,optional" json:"port,omitempty"`           // Default: 179
+	Passive     bool   `hcl:"passive,optional" json:"passive,omitempty"`     // Wait for the peer to connect
+	HoldTime    int    `hcl:"hold_time,optional" json:"hold_time,omitempty"` // Seconds; default 90
+	Table       int    `hcl:"table,optional" json:"table,omitempty"`         // Overrides bgp.table for this peer's routes
+	Import      string `hcl:"import,optional" json:"import,omitempty"`       // Prefix list for received routes (default: accept all)
+	Export      string `hcl:"export,optional" json:"export,omitempty"`       // Prefix list for advertised networks (default: all)
+}
+
+// PrefixList is an ordered list of prefix rules. The first matching rule
+// decides; a prefix no rule matches is denied.
+type PrefixList struct {
+	Name  string           `hcl:"name,label" json:"name"`
+	Rules []PrefixListRule `hcl:"rule,block" json:"rules,omitempty"`
+}
+
+// PrefixListRule matches a prefix and, with ge/le, its more-specifics.
+type PrefixListRule struct {
+	Prefix string `hcl:"prefix" json:"prefix"`
+	Action string `hcl:"action,optional" json:"action,omitempty"` // "permit" (default) or "deny"
+	GE     int    `hcl:"ge,optional" json:"ge,omitempty"`         // Minimum prefix length
+	LE     int    `hcl:"le,optional" json:"le,omitempty"`         // Maximum prefix length
 }
 
 // QoSPolicy defines Quality of Service settings for an interface.
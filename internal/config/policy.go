@@ -138,7 +138,7 @@ type PolicyRule struct {
 	Days      []string `hcl:"days,optional" json:"days,omitempty"`             // Days of week: "Monday", "Tuesday", etc.
 
 	// Action
-	Action     string `hcl:"action" json:"action"`                              // accept, drop, reject, jump, return, log
+	Action     string `hcl:"action" json:"action"`                              // accept, drop, reject, inspect, jump, return, log
 	JumpTarget string `hcl:"jump_target,optional" json:"jump_target,omitempty"` // Target chain for jump action
 
 	// Logging & accounting
@@ -11,6 +11,57 @@ type DHCPServer struct {
 	Mode string `hcl:"mode,optional" json:"mode,omitempty"`
 	// ExternalLeaseFile is the path to external DHCP server's lease file (for import mode)
 	ExternalLeaseFile string `hcl:"external_lease_file,optional" json:"external_lease_file,omitempty"`
+	// Relays forward DHCP on an interface to central servers instead of
+	// serving a local scope (builtin mode only)
+	Relays []DHCPRelay `hcl:"relay,block" json:"relays,omitempty"`
+	// Failover shares the pools with the other node of an HA pair
+	Failover *DHCPFailover `hcl:"failover,block" json:"failover,omitempty"`
+	// ConflictDetection probes new addresses with ARP before offering
+	// them (default: true)
+	ConflictDetection *bool `hcl:"conflict_detection,optional" json:"conflict_detection,omitempty"`
+	// QuarantineTime is how long an address found in use, or declined by
+	// a client, is withheld from allocation (default: "24h")
+	QuarantineTime string `hcl:"quarantine_time,optional" json:"quarantine_time,omitempty"`
+}
+
+// DHCPFailover shares DHCPv4 pools between the nodes of an HA pair, in the
+// manner of ISC DHCP failover. The node running as replication primary is
+// the failover primary, and leases reach the secondary through replication.
+//
+// The free addresses of every pool are split between the nodes so neither
+// hands out an address the other may have leased. While the partner is
+// unreachable, lease times are capped at the MCLT; once it has been gone
+// for the MCLT, its share of the pools is used too. New addresses are
+// checked against the replicated leases and probed with ARP first.
+type DHCPFailover struct {
+	// Mode is "active_standby" (default), where only the primary answers
+	// until the secondary loses contact with it, or "split", where both
+	// answer from their share of the pools.
+	Mode string `hcl:"mode,optional" json:"mode,omitempty"`
+	// MCLT is the maximum client lead time (default: "1h")
+	MCLT string `hcl:"mclt,optional" json:"mclt,omitempty"`
+	// Split is the percentage of each pool allocated by the primary
+	// (default: 90 for active_standby, 50 for split)
+	Split int `hcl:"split,optional" json:"split,omitempty"`
+}
+
+// DHCPRelay relays DHCP between clients on an interface and central servers.
+//
+// Example:
+//
+//	relay "eth1.20" {
+//	  servers    = ["10.0.0.5"]
+//	  servers_v6 = ["2001:db8::5"]
+//	}
+type DHCPRelay struct {
+	Interface string   `hcl:"interface,label" json:"interface"`
+	Servers   []string `hcl:"servers,optional" json:"servers,omitempty"`       // DHCPv4 servers
+	ServersV6 []string `hcl:"servers_v6,optional" json:"servers_v6,omitempty"` // DHCPv6 servers (Relay-Forw)
+	// CircuitID is sent in option 82 and as the DHCPv6 Interface-ID.
+	// Default: the interface name.
+	CircuitID string `hcl:"circuit_id,optional" json:"circuit_id,omitempty"`
+	// RemoteID is sent in option 82. Default: the interface MAC address.
+	RemoteID string `hcl:"remote_id,optional" json:"remote_id,omitempty"`
 }
 
 // DHCPScope defines a DHCP pool.
@@ -29,6 +80,9 @@ type DHCPScope struct {
 	// Available prefixes: ip, str/text, hex, u8, u16, u32, bool
 	Options      map[string]string `hcl:"options,optional" json:"options,omitempty"`
 	Reservations []DHCPReservation `hcl:"reservation,block" json:"reservations,omitempty"`
+	// Classes add options for matching clients, e.g. per-architecture
+	// boot files for PXE
+	Classes []DHCPClass `hcl:"class,block" json:"classes,omitempty"`
 
 	// IPv6 Support (SLAAC/DHCPv6)
 	RangeStartV6 string   `hcl:"range_start_v6,optional" json:"range_start_v6,omitempty"` // For Stateful DHCPv6
@@ -36,15 +90,41 @@ type DHCPScope struct {
 	DNSServersV6 []string `hcl:"dns_v6,optional" json:"dns_v6,omitempty"`
 }
 
+// DHCPClass selects options for clients matching all of its conditions.
+// Class options override scope options, later classes override earlier
+// ones, and reservation options override both. For example, a BIOS/UEFI
+// netboot setup has one class per architecture plus a final "iPXE" user
+// class that hands the chainloaded iPXE its boot script.
+type DHCPClass struct {
+	Name string `hcl:"name,label" json:"name"`
+	// VendorClass matches the start of the vendor class identifier
+	// (option 60), e.g. "PXEClient" or "HTTPClient"
+	VendorClass string `hcl:"vendor_class,optional" json:"vendor_class,omitempty"`
+	// UserClass matches a user class (option 77), e.g. "iPXE"
+	UserClass string `hcl:"user_class,optional" json:"user_class,omitempty"`
+	// MACPrefix matches the start of the client MAC, e.g. "b8:27:eb"
+	MACPrefix string `hcl:"mac_prefix,optional" json:"mac_prefix,omitempty"`
+	// Architectures matches the client system architecture (option 93,
+	// RFC 4578): 0 BIOS, 6 EFI IA32, 7 and 9 EFI x64, 10 EFI ARM32,
+	// 11 EFI ARM64, 16 EFI x64 HTTP boot
+	Architectures []int `hcl:"architectures,optional" json:"architectures,omitempty"`
+	// Options use the same format as scope options
+	Options map[string]string `hcl:"options" json:"options"`
+}
+
 // DHCPReservation defines a static IP assignment for a MAC address.
 type DHCPReservation struct {
 	MAC         string            `hcl:"mac,label" json:"mac"`
-	IP          string            `hcl:"ip" json:"ip"`
+	IP          string            `hcl:"ip,optional" json:"ip"`
 	Hostname    string            `hcl:"hostname,optional" json:"hostname,omitempty"`
 	Description string            `hcl:"description,optional" json:"description,omitempty"`
 	Options     map[string]string `hcl:"options,optional" json:"options,omitempty"` // Per-host custom DHCP options (same format as scope options)
 	// DNS integration
-	RegisterDNS bool `hcl:"register_dns,optional" json:"register_dns"` // Auto-register in DNS
+	RegisterDNS bool   `hcl:"register_dns,optional" json:"register_dns"`         // Auto-register in DNS
+	DNSProfile  string `hcl:"dns_profile,optional" json:"dns_profile,omitempty"` // DNS filtering profile for this host
+	// DHCPv6: matched by DUID, or by the MAC embedded in a DUID-LL/LLT
+	DUID string `hcl:"duid,optional" json:"duid,omitempty"`   // Client DUID in hex, e.g. "00:01:00:01:..."
+	IPv6 string `hcl:"ip_v6,optional" json:"ip_v6,omitempty"` // Address assigned from the v6 range
 }
 
 // DNSServer configuration.
@@ -84,10 +164,16 @@ type DNSServer struct {
 	Recursive *RecursiveConfig `hcl:"recursive,block" json:"recursive,omitempty"`
 
 	// Security
-	DNSSEC           bool `hcl:"dnssec,optional" json:"dnssec"`                       // Validate DNSSEC
-	RebindProtection bool `hcl:"rebind_protection,optional" json:"rebind_protection"` // Block private IPs in public responses
-	QueryLogging     bool `hcl:"query_logging,optional" json:"query_logging"`
-	RateLimitPerSec  int  `hcl:"rate_limit_per_sec,optional" json:"rate_limit_per_sec"` // Per-client rate limit
+	DNSSEC                bool     `hcl:"dnssec,optional" json:"dnssec"`                                 // Validate DNSSEC
+	TrustAnchors          []string `hcl:"trust_anchors,optional" json:"trust_anchors,omitempty"`         // DS/DNSKEY records (default: IANA root KSKs)
+	TrustAnchorFile       string   `hcl:"trust_anchor_file,optional" json:"trust_anchor_file,omitempty"` // Zone-format file of DS/DNSKEY records
+	RebindProtection      bool     `hcl:"rebind_protection,optional" json:"rebind_protection"`           // Block private IPs in public responses
+	QueryLogging          bool     `hcl:"query_logging,optional" json:"query_logging"`
+	QueryLogRetentionDays int      `hcl:"query_log_retention_days,optional" json:"query_log_retention_days,omitempty"` // Default: 7
+	RateLimitPerSec       int      `hcl:"rate_limit_per_sec,optional" json:"rate_limit_per_sec"`                       // Per-client rate limit
+	RateLimitAction       string   `hcl:"rate_limit_action,optional" json:"rate_limit_action,omitempty"`               // "refuse" (default), "drop", "truncate"
+	ResponseRateLimit     int      `hcl:"response_rate_limit,optional" json:"response_rate_limit,omitempty"`           // Identical UDP responses/sec per client netblock (RRL)
+	RRLSlip               int      `hcl:"rrl_slip,optional" json:"rrl_slip,omitempty"`                                 // Send every Nth limited response truncated (default: 2)
 
 	// Filtering
 	Blocklists     []DNSBlocklist `hcl:"blocklist,block" json:"blocklists"`
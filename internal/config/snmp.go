@@ -0,0 +1,64 @@
+package config
+
+// SNMP defaults.
+const (
+	DefaultSNMPListen   = ":161"
+	DefaultSNMPTrapPort = 162
+)
+
+// SNMPConfig configures the embedded SNMP agent. Queries are only accepted
+// on interfaces whose zone or management block allows snmp.
+type SNMPConfig struct {
+	Enabled bool `hcl:"enabled,optional" json:"enabled"`
+
+	// Address to listen on (default: ":161")
+	Listen string `hcl:"listen,optional" json:"listen,omitempty"`
+
+	// Read-only SNMPv2c community. Leave empty to only allow SNMPv3.
+	Community string `hcl:"community,optional" json:"community,omitempty"`
+
+	// sysContact and sysLocation
+	Contact  string `hcl:"contact,optional" json:"contact,omitempty"`
+	Location string `hcl:"location,optional" json:"location,omitempty"`
+
+	// snmpEngineID as hex. Generated and kept in the state directory when
+	// empty.
+	EngineID string `hcl:"engine_id,optional" json:"engine_id,omitempty"`
+
+	// Root of the enterprise MIB. Set it to the arc of a registered Private
+	// Enterprise Number; the default is unregistered.
+	EnterpriseOID string `hcl:"enterprise_oid,optional" json:"enterprise_oid,omitempty"`
+
+	Users []SNMPUser       `hcl:"user,block" json:"users,omitempty"`
+	Traps []SNMPTrapTarget `hcl:"trap,block" json:"traps,omitempty"`
+}
+
+// SNMPUser is an SNMPv3 USM user with read-only access. Without an auth
+// password the user is noAuthNoPriv; without a priv password, authNoPriv.
+type SNMPUser struct {
+	Name string `hcl:"name,label" json:"name"`
+
+	AuthProtocol string `hcl:"auth_protocol,optional" json:"auth_protocol,omitempty"` // md5, sha (default), sha256
+	AuthPassword string `hcl:"auth_password,optional" json:"auth_password,omitempty"`
+	PrivProtocol string `hcl:"priv_protocol,optional" json:"priv_protocol,omitempty"` // des, aes (default)
+	PrivPassword string `hcl:"priv_password,optional" json:"priv_password,omitempty"`
+}
+
+// SNMPTrapTarget is a manager that receives notifications, such as uplink
+// failovers. Traps are sent as SNMPv2c with community, or as SNMPv3 with
+// the credentials of user.
+type SNMPTrapTarget struct {
+	// Host or host:port (default port: 162)
+	Address string `hcl:"address,label" json:"address"`
+
+	Community string `hcl:"community,optional" json:"community,omitempty"`
+	User      string `hcl:"user,optional" json:"user,omitempty"`
+}
+
+// ListenAddr returns the agent's listen address.
+func (s *SNMPConfig) ListenAddr() string {
+	if s.Listen == "" {
+		return DefaultSNMPListen
+	}
+	return s.Listen
+}
@@ -54,6 +54,12 @@ type LetsEncryptConfig struct {
 	Domain   string `hcl:"domain" json:"domain"`                          // Domain name for certificate
 	CacheDir string `hcl:"cache_dir,optional" json:"cache_dir,omitempty"` // Certificate cache directory
 	Staging  bool   `hcl:"staging,optional" json:"staging,omitempty"`     // Use staging server for testing
+
+	// Challenge: "http-01" (default, answered by the web proxy on port 80),
+	// "tls-alpn-01" (answered on tls_listen, which must be port 443) or
+	// "dns-01" (published through the ddns block's Cloudflare zone)
+	Challenge    string `hcl:"challenge,optional" json:"challenge,omitempty"`
+	DirectoryURL string `hcl:"directory_url,optional" json:"directory_url,omitempty"` // Alternative ACME CA directory
 }
 
 // TLSConfig defines TLS/certificate configuration for an interface.
@@ -62,9 +68,11 @@ type TLSConfig struct {
 	Mode     string `hcl:"mode,optional" json:"mode,omitempty"`         // "self-signed", "acme", "tailscale", "manual"
 	Hostname string `hcl:"hostname,optional" json:"hostname,omitempty"` // For Tailscale mode
 
-	// ACME (Let's Encrypt) settings
-	Email   string   `hcl:"email,optional" json:"email,omitempty"`
-	Domains []string `hcl:"domains,optional" json:"domains,omitempty"`
+	// ACME (Let's Encrypt) settings. The account and CA are shared with
+	// api.letsencrypt when it is configured.
+	Email     string   `hcl:"email,optional" json:"email,omitempty"`
+	Domains   []string `hcl:"domains,optional" json:"domains,omitempty"`
+	Challenge string   `hcl:"challenge,optional" json:"challenge,omitempty"` // "http-01", "tls-alpn-01" or "dns-01"
 
 	// Manual certificate (bring your own)
 	CertFile string `hcl:"cert_file,optional" json:"cert_file,omitempty"`
@@ -82,8 +90,35 @@ type ReplicationConfig struct {
 	// Address of the primary node (only for replica mode)
 	PrimaryAddr string `hcl:"primary_addr,optional" json:"primary_addr,omitempty"`
 
-	// Secret key for authentication (optional)
+	// Pre-shared secret both nodes derive their TLS identity from. Required
+	// unless tls_cert, tls_key and tls_ca are set.
 	SecretKey string `hcl:"secret_key,optional" json:"secret_key,omitempty"`
+
+	// Mutual TLS with certificates signed by a CA shared by both nodes
+	TLSCert string `hcl:"tls_cert,optional" json:"tls_cert,omitempty"`
+	TLSKey  string `hcl:"tls_key,optional" json:"tls_key,omitempty"`
+	TLSCA   string `hcl:"tls_ca,optional" json:"tls_ca,omitempty"`
+
+	// Stream conntrack entries to the standby so established and NATed
+	// sessions survive a failover (like conntrackd in FTFW mode)
+	ConntrackSync bool `hcl:"conntrack_sync,optional" json:"conntrack_sync,omitempty"`
+
+	// VRRPv3 virtual IP failover. The node that is master of the first
+	// instance runs as replication primary; the others replicate from it.
+	VRRP []VRRPInstance `hcl:"vrrp,block" json:"vrrp,omitempty"`
+}
+
+// VRRPInstance configures one VRRPv3 (RFC 5798) virtual router.
+type VRRPInstance struct {
+	Interface  string   `hcl:"interface,label" json:"interface"`
+	VRID       int      `hcl:"vrid" json:"vrid"`                                          // Virtual router ID (1-255), unique per link
+	Priority   int      `hcl:"priority,optional" json:"priority,omitempty"`               // 1-254, higher wins (default: 100)
+	VirtualIPs []string `hcl:"virtual_ips" json:"virtual_ips"`                            // IPv4 or IPv6 (CIDR or bare IP); one family per instance
+	Interval   string   `hcl:"advert_interval,optional" json:"advert_interval,omitempty"` // Advertisement interval (default: "1s")
+
+	// Preempt lets a higher-priority backup take over from a lower-priority
+	// master (default: true)
+	Preempt *bool `hcl:"preempt,optional" json:"preempt,omitempty"`
 }
 
 // SchedulerConfig defines scheduler settings.
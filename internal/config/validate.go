@@ -1,12 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // isWildcardZone checks if a zone name is a wildcard pattern.
@@ -84,6 +90,50 @@ func (c *Config) Validate() ValidationErrors {
 	// Validate routes
 	errs = append(errs, c.validateRoutes()...)
 
+	// Validate OSPF
+	errs = append(errs, c.validateOSPF()...)
+
+	// Validate BGP
+	errs = append(errs, c.validateBGP()...)
+
+	// Validate DNS filtering profiles
+	errs = append(errs, c.validateDNSProfiles()...)
+
+	// Validate DHCPv6 ranges and reservations
+	errs = append(errs, c.validateDHCPv6()...)
+
+	// Validate DHCPv6 prefix delegation
+	errs = append(errs, c.validatePrefixDelegation()...)
+
+	// Validate PPPoE sessions
+	errs = append(errs, c.validatePPPoE()...)
+
+	// Validate bridges and tunnels
+	errs = append(errs, c.validateVirtualInterfaces()...)
+
+	// Validate DHCP option classes
+	errs = append(errs, c.validateDHCPClasses()...)
+
+	// Validate DHCP relays
+	errs = append(errs, c.validateDHCPRelays()...)
+
+	// Validate DHCP failover
+	errs = append(errs, c.validateDHCPFailover()...)
+
+	// Validate DHCP conflict quarantine
+	errs = append(errs, c.validateDHCPQuarantine()...)
+
+	// Validate replication
+	errs = append(errs, c.validateReplication()...)
+
+	// Validate ACME certificates
+	errs = append(errs, c.validateACME()...)
+
+	// Validate captive portal
+	errs = append(errs, c.validateCaptivePortal()...)
+	errs = append(errs, c.validateSNMP()...)
+	errs = append(errs, c.validateIPS()...)
+
 	return errs
 }
 
@@ -424,12 +474,17 @@ func (c *Config) validatePolicies() ValidationErrors {
 			ruleField := fmt.Sprintf("%s.rules[%d]", field, j)
 
 			// Validate action
-			validActions := map[string]bool{"accept": true, "drop": true, "reject": true}
+			validActions := map[string]bool{"accept": true, "drop": true, "reject": true, "inspect": true}
 			if !validActions[strings.ToLower(rule.Action)] {
 				errs = append(errs, ValidationError{
 					Field:   ruleField + ".action",
 					Message: fmt.Sprintf("invalid action: %s", rule.Action),
 				})
+			} else if strings.EqualFold(rule.Action, "inspect") && (c.IPS == nil || !c.IPS.Enabled) {
+				errs = append(errs, ValidationError{
+					Field:   ruleField + ".action",
+					Message: "action inspect requires ips to be enabled",
+				})
 			}
 
 			// Validate protocol
@@ -596,128 +651,1280 @@ func (c *Config) validateRoutes() ValidationErrors {
 	return errs
 }
 
-// Helper functions
-
-func (c *Config) getDefinedZones() map[string]bool {
-	zones := make(map[string]bool)
+func (c *Config) validateOSPF() ValidationErrors {
+	var errs ValidationErrors
+	if c.FRR == nil || c.FRR.OSPF == nil {
+		return errs
+	}
+	ospf := c.FRR.OSPF
 
-	// 1. Explicit zone definitions
-	for _, zone := range c.Zones {
-		zones[zone.Name] = true
+	if ospf.RouterID != "" {
+		if ip := net.ParseIP(ospf.RouterID); ip == nil || ip.To4() == nil || ip.IsUnspecified() {
+			errs = append(errs, ValidationError{Field: "frr.ospf.router_id", Message: fmt.Sprintf("router ID must be a non-zero IPv4 address: %s", ospf.RouterID)})
+		}
+	}
+	for _, network := range ospf.Networks {
+		if !isValidCIDR(network) {
+			errs = append(errs, ValidationError{Field: "frr.ospf.networks", Message: fmt.Sprintf("invalid CIDR: %s", network)})
+		}
 	}
 
-	// 2. Interface-referenced zones (legacy style: interface has zone = "wan")
-	for _, iface := range c.Interfaces {
-		if iface.Zone != "" {
-			zones[iface.Zone] = true
+	areas := make(map[uint32]bool)
+	for i := range ospf.Areas {
+		area := &ospf.Areas[i]
+		field := fmt.Sprintf("frr.ospf.area[%s]", area.ID)
+		id, err := area.AreaID()
+		if err != nil {
+			errs = append(errs, ValidationError{Field: field, Message: err.Error()})
+		} else if areas[id] {
+			errs = append(errs, ValidationError{Field: field, Message: "duplicate area"})
+		} else {
+			areas[id] = true
 		}
-		for _, vlan := range iface.VLANs {
-			if vlan.Zone != "" {
-				zones[vlan.Zone] = true
+		for _, network := range area.Networks {
+			if !isValidCIDR(network) {
+				errs = append(errs, ValidationError{Field: field + ".networks", Message: fmt.Sprintf("invalid CIDR: %s", network)})
 			}
 		}
+		if area.Stub && err == nil && id == 0 {
+			errs = append(errs, ValidationError{Field: field + ".stub", Message: "the backbone area cannot be a stub area"})
+		}
+		if area.NoSummary && !area.Stub {
+			errs = append(errs, ValidationError{Field: field + ".no_summary", Message: "no_summary requires stub = true"})
+		}
+		if area.DefaultCost < 0 || area.DefaultCost > 0xfffffe {
+			errs = append(errs, ValidationError{Field: field + ".default_cost", Message: fmt.Sprintf("default cost must be between 1 and %d, got %d", 0xfffffe, area.DefaultCost)})
+		}
 	}
-	return zones
+
+	names := make(map[string]bool)
+	for _, iface := range ospf.Interfaces {
+		field := fmt.Sprintf("frr.ospf.interface[%s]", iface.Name)
+		if names[iface.Name] {
+			errs = append(errs, ValidationError{Field: field, Message: "duplicate interface"})
+		}
+		names[iface.Name] = true
+
+		if iface.Type != "" && iface.Type != "broadcast" && iface.Type != "point-to-point" {
+			errs = append(errs, ValidationError{Field: field + ".type", Message: fmt.Sprintf("type must be broadcast or point-to-point, got %q", iface.Type)})
+		}
+		if iface.Cost < 0 || iface.Cost > 65535 {
+			errs = append(errs, ValidationError{Field: field + ".cost", Message: fmt.Sprintf("cost must be between 1 and 65535, got %d", iface.Cost)})
+		}
+		if iface.HelloInterval < 0 || iface.HelloInterval > 65535 {
+			errs = append(errs, ValidationError{Field: field + ".hello_interval", Message: fmt.Sprintf("hello interval must be between 1 and 65535 seconds, got %d", iface.HelloInterval)})
+		}
+		if iface.DeadInterval < 0 || iface.DeadInterval > 65535 {
+			errs = append(errs, ValidationError{Field: field + ".dead_interval", Message: fmt.Sprintf("dead interval must be between 1 and 65535 seconds, got %d", iface.DeadInterval)})
+		} else if hello := cmp.Or(iface.HelloInterval, 10); iface.DeadInterval != 0 && iface.DeadInterval <= hello {
+			errs = append(errs, ValidationError{Field: field + ".dead_interval", Message: fmt.Sprintf("dead interval must be longer than the hello interval (%ds)", hello)})
+		}
+		if iface.Priority != nil && (*iface.Priority < 0 || *iface.Priority > 255) {
+			errs = append(errs, ValidationError{Field: field + ".priority", Message: fmt.Sprintf("priority must be between 0 and 255, got %d", *iface.Priority)})
+		}
+	}
+
+	return errs
 }
 
-// NormalizeZoneMappings unifies zone-interface mappings from both syntaxes:
-//   - Zone-centric: zone "wan" { interfaces = ["eth0"] }
-//   - Interface-centric (legacy): interface "eth0" { zone = "wan" }
-//
-// After normalization, all zone.Interfaces arrays are populated AND all iface.Zone fields are set.
-func (c *Config) NormalizeZoneMappings() {
-	// Build map of zone name -> zone INDEX (not pointer, to survive slice reallocation)
-	zoneIndex := make(map[string]int)
-	for i := range c.Zones {
-		zoneIndex[c.Zones[i].Name] = i
+func (c *Config) validateBGP() ValidationErrors {
+	var errs ValidationErrors
+	if c.FRR == nil || c.FRR.BGP == nil {
+		return errs
 	}
+	bgp := c.FRR.BGP
 
-	// Helper to get zone by name, returning nil if not found
-	getZone := func(name string) *Zone {
-		if idx, ok := zoneIndex[name]; ok {
-			return &c.Zones[idx]
+	if bgp.ASN < 1 || bgp.ASN > math.MaxUint32 {
+		errs = append(errs, ValidationError{Field: "frr.bgp.asn", Message: fmt.Sprintf("ASN must be between 1 and %d, got %d", uint32(math.MaxUint32), bgp.ASN)})
+	}
+	if bgp.RouterID != "" {
+		if ip := net.ParseIP(bgp.RouterID); ip == nil || ip.To4() == nil || ip.IsUnspecified() {
+			errs = append(errs, ValidationError{Field: "frr.bgp.router_id", Message: fmt.Sprintf("router ID must be a non-zero IPv4 address: %s", bgp.RouterID)})
 		}
-		return nil
+	}
+	for _, network := range bgp.Networks {
+		if !isValidCIDR(network) {
+			errs = append(errs, ValidationError{Field: "frr.bgp.networks", Message: fmt.Sprintf("invalid CIDR: %s", network)})
+		}
+	}
+	if bgp.Table < 0 {
+		errs = append(errs, ValidationError{Field: "frr.bgp.table", Message: fmt.Sprintf("invalid routing table: %d", bgp.Table)})
 	}
 
-	// Helper to add a new zone and update the index
-	addZone := func(z Zone) *Zone {
-		c.Zones = append(c.Zones, z)
-		idx := len(c.Zones) - 1
-		zoneIndex[z.Name] = idx
-		return &c.Zones[idx]
+	lists := make(map[string]bool)
+	for _, pl := range bgp.PrefixLists {
+		field := fmt.Sprintf("frr.bgp.prefix_list[%s]", pl.Name)
+		if lists[pl.Name] {
+			errs = append(errs, ValidationError{Field: field, Message: "duplicate prefix list name"})
+		}
+		lists[pl.Name] = true
+
+		for i, rule := range pl.Rules {
+			ruleField := fmt.Sprintf("%s.rule[%d]", field, i)
+			if rule.Action != "" && rule.Action != "permit" && rule.Action != "deny" {
+				errs = append(errs, ValidationError{Field: ruleField + ".action", Message: fmt.Sprintf("action must be permit or deny, got %q", rule.Action)})
+			}
+			_, prefix, err := net.ParseCIDR(rule.Prefix)
+			if err != nil {
+				errs = append(errs, ValidationError{Field: ruleField + ".prefix", Message: fmt.Sprintf("invalid CIDR: %s", rule.Prefix)})
+				continue
+			}
+			// len <= ge <= le <= address length
+			ones, bits := prefix.Mask.Size()
+			ge, le := rule.GE, rule.LE
+			if ge == 0 {
+				ge = ones
+			}
+			if le == 0 {
+				le = bits
+				if rule.GE == 0 {
+					le = ones
+				}
+			}
+			if ge < ones || le < ge || le > bits {
+				errs = append(errs, ValidationError{
+					Field:   ruleField,
+					Message: fmt.Sprintf("ge and le must satisfy %d <= ge <= le <= %d", ones, bits),
+				})
+			}
+		}
 	}
 
-	// 1. Process zone-centric config: zone "name" { interfaces = [...] }
-	// We need to populate iface.Zone for these interfaces if not already set
-	ifaceMap := make(map[string]*Interface)
-	for i := range c.Interfaces {
-		ifaceMap[c.Interfaces[i].Name] = &c.Interfaces[i]
+	neighbors := make(map[string]bool)
+	for _, n := range bgp.Neighbors {
+		field := fmt.Sprintf("frr.bgp.neighbor[%s]", n.IP)
+		ip := net.ParseIP(n.IP)
+		if ip == nil {
+			errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("invalid neighbor IP: %s", n.IP)})
+		} else if neighbors[ip.String()] {
+			errs = append(errs, ValidationError{Field: field, Message: "duplicate neighbor"})
+		} else {
+			neighbors[ip.String()] = true
+		}
+		if n.RemoteASN < 1 || n.RemoteASN > math.MaxUint32 {
+			errs = append(errs, ValidationError{Field: field + ".remote_asn", Message: fmt.Sprintf("ASN must be between 1 and %d, got %d", uint32(math.MaxUint32), n.RemoteASN)})
+		}
+		if n.Port < 0 || n.Port > 65535 {
+			errs = append(errs, ValidationError{Field: field + ".port", Message: fmt.Sprintf("port must be between 1 and 65535, got %d", n.Port)})
+		}
+		if n.HoldTime != 0 && (n.HoldTime < 3 || n.HoldTime > 65535) {
+			errs = append(errs, ValidationError{Field: field + ".hold_time", Message: fmt.Sprintf("hold time must be between 3 and 65535 seconds, got %d", n.HoldTime)})
+		}
+		if n.Table < 0 {
+			errs = append(errs, ValidationError{Field: field + ".table", Message: fmt.Sprintf("invalid routing table: %d", n.Table)})
+		}
+		if n.Import != "" && !lists[n.Import] {
+			errs = append(errs, ValidationError{Field: field + ".import", Message: fmt.Sprintf("unknown prefix list: %s", n.Import)})
+		}
+		if n.Export != "" && !lists[n.Export] {
+			errs = append(errs, ValidationError{Field: field + ".export", Message: fmt.Sprintf("unknown prefix list: %s", n.Export)})
+		}
 	}
 
-	for _, zone := range c.Zones {
-		for _, ifaceName := range zone.Interfaces {
-			if iface, exists := ifaceMap[ifaceName]; exists {
-				// Set interface zone if empty (prefer zone definition in new syntax)
-				if iface.Zone == "" {
-					iface.Zone = zone.Name
+	return errs
+}
+
+func (c *Config) validateDNSProfiles() ValidationErrors {
+	var errs ValidationErrors
+	if c.DNS == nil {
+		return errs
+	}
+
+	profiles := make(map[string]bool)
+	for _, p := range c.DNS.FilterProfiles {
+		field := fmt.Sprintf("dns.filter_profile[%s]", p.Name)
+		if profiles[p.Name] {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: "duplicate filter profile name",
+			})
+		}
+		profiles[p.Name] = true
+
+		for _, src := range p.Sources {
+			if !isValidIPOrCIDR(src) {
+				errs = append(errs, ValidationError{
+					Field:   field + ".sources",
+					Message: fmt.Sprintf("invalid source CIDR: %s", src),
+				})
+			}
+		}
+		if p.BlockedAddress != "" && net.ParseIP(p.BlockedAddress) == nil {
+			errs = append(errs, ValidationError{
+				Field:   field + ".blocked_address",
+				Message: fmt.Sprintf("invalid IP: %s", p.BlockedAddress),
+			})
+		}
+	}
+
+	for _, serve := range c.DNS.Serve {
+		if serve.FilterProfile != "" && !profiles[serve.FilterProfile] {
+			errs = append(errs, ValidationError{
+				Field:   fmt.Sprintf("dns.serve[%s].filter_profile", serve.Zone),
+				Message: fmt.Sprintf("unknown filter profile: %s", serve.FilterProfile),
+			})
+		}
+	}
+	if c.DHCP != nil {
+		for _, scope := range c.DHCP.Scopes {
+			for _, res := range scope.Reservations {
+				if res.DNSProfile != "" && !profiles[res.DNSProfile] {
+					errs = append(errs, ValidationError{
+						Field:   fmt.Sprintf("dhcp.scope[%s].reservation[%s].dns_profile", scope.Name, res.MAC),
+						Message: fmt.Sprintf("unknown filter profile: %s", res.DNSProfile),
+					})
 				}
 			}
 		}
 	}
 
-	// 2. Process interface-centric (legacy): interface "name" { zone = "..." }
-	// We need to add these interfaces to the zone's interface list
-	for _, iface := range c.Interfaces {
-		if iface.Zone != "" {
-			zone := getZone(iface.Zone)
-			if zone == nil {
-				// Create implicit zone for this interface-referenced zone
-				zone = addZone(Zone{
-					Name:       iface.Zone,
-					Interfaces: []string{iface.Name},
+	return errs
+}
+
+func (c *Config) validateDHCPv6() ValidationErrors {
+	var errs ValidationErrors
+	if c.DHCP == nil {
+		return errs
+	}
+
+	isIPv6 := func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() == nil
+	}
+
+	for _, scope := range c.DHCP.Scopes {
+		field := fmt.Sprintf("dhcp.scope[%s]", scope.Name)
+		if (scope.RangeStartV6 == "") != (scope.RangeEndV6 == "") {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: "range_start_v6 and range_end_v6 must be set together",
+			})
+		}
+		for _, f := range []struct{ name, value string }{
+			{"range_start_v6", scope.RangeStartV6},
+			{"range_end_v6", scope.RangeEndV6},
+		} {
+			if f.value != "" && !isIPv6(f.value) {
+				errs = append(errs, ValidationError{
+					Field:   field + "." + f.name,
+					Message: fmt.Sprintf("invalid IPv6 address: %s", f.value),
+				})
+			}
+		}
+		if isIPv6(scope.RangeStartV6) && isIPv6(scope.RangeEndV6) &&
+			bytes.Compare(net.ParseIP(scope.RangeStartV6), net.ParseIP(scope.RangeEndV6)) > 0 {
+			errs = append(errs, ValidationError{
+				Field:   field + ".range_start_v6",
+				Message: "range_start_v6 is after range_end_v6",
+			})
+		}
+		for _, dns := range scope.DNSServersV6 {
+			if !isIPv6(dns) {
+				errs = append(errs, ValidationError{
+					Field:   field + ".dns_v6",
+					Message: fmt.Sprintf("invalid IPv6 address: %s", dns),
 				})
-			} else {
-				// Add interface to existing zone if not already present
-				if !containsString(zone.Interfaces, iface.Name) {
-					zone.Interfaces = append(zone.Interfaces, iface.Name)
-				}
 			}
 		}
 
-		// Process VLAN zones
-		for _, vlan := range iface.VLANs {
-			if vlan.Zone != "" {
-				vlanIfaceName := fmt.Sprintf("%s.%s", iface.Name, vlan.ID)
-				zone := getZone(vlan.Zone)
-				if zone == nil {
-					addZone(Zone{
-						Name:       vlan.Zone,
-						Interfaces: []string{vlanIfaceName},
+		for _, res := range scope.Reservations {
+			resField := fmt.Sprintf("%s.reservation[%s]", field, res.MAC)
+			if res.IP == "" && res.IPv6 == "" {
+				errs = append(errs, ValidationError{
+					Field:   resField,
+					Message: "ip or ip_v6 is required",
+				})
+			}
+			if res.IPv6 != "" && !isIPv6(res.IPv6) {
+				errs = append(errs, ValidationError{
+					Field:   resField + ".ip_v6",
+					Message: fmt.Sprintf("invalid IPv6 address: %s", res.IPv6),
+				})
+			}
+			if res.DUID != "" {
+				if _, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(res.DUID)); err != nil {
+					errs = append(errs, ValidationError{
+						Field:   resField + ".duid",
+						Message: fmt.Sprintf("invalid DUID: %s", res.DUID),
 					})
-				} else {
-					if !containsString(zone.Interfaces, vlanIfaceName) {
-						zone.Interfaces = append(zone.Interfaces, vlanIfaceName)
-					}
 				}
 			}
 		}
 	}
+
+	return errs
 }
 
+func (c *Config) validateDHCPRelays() ValidationErrors {
+	var errs ValidationErrors
+	if c.DHCP == nil {
+		return errs
+	}
 
-func containsString(slice []string, s string) bool {
-	for _, item := range slice {
-		if item == s {
-			return true
+	scopes := make(map[string]string)
+	for _, scope := range c.DHCP.Scopes {
+		scopes[scope.Interface] = scope.Name
+	}
+
+	seen := make(map[string]bool)
+	for _, relay := range c.DHCP.Relays {
+		field := fmt.Sprintf("dhcp.relay[%s]", relay.Interface)
+		if seen[relay.Interface] {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: "duplicate relay for interface",
+			})
+		}
+		seen[relay.Interface] = true
+
+		if name, ok := scopes[relay.Interface]; ok {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("interface is already served by scope %s", name),
+			})
+		}
+		if len(relay.Servers) == 0 && len(relay.ServersV6) == 0 {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: "servers or servers_v6 is required",
+			})
+		}
+		for _, server := range relay.Servers {
+			if ip := net.ParseIP(server); ip == nil || ip.To4() == nil {
+				errs = append(errs, ValidationError{
+					Field:   field + ".servers",
+					Message: fmt.Sprintf("invalid IPv4 address: %s", server),
+				})
+			}
+		}
+		for _, server := range relay.ServersV6 {
+			if ip := net.ParseIP(server); ip == nil || ip.To4() != nil {
+				errs = append(errs, ValidationError{
+					Field:   field + ".servers_v6",
+					Message: fmt.Sprintf("invalid IPv6 address: %s", server),
+				})
+			}
+		}
+		// Sub-option and Interface-ID lengths are a single byte
+		for _, f := range []struct{ name, value string }{
+			{"circuit_id", relay.CircuitID},
+			{"remote_id", relay.RemoteID},
+		} {
+			if len(f.value) > 255 {
+				errs = append(errs, ValidationError{
+					Field:   field + "." + f.name,
+					Message: "must be at most 255 bytes",
+				})
+			}
 		}
 	}
-	return false
+
+	return errs
 }
 
-func (c *Config) hasIPSet(name string) bool {
-	for _, ipset := range c.IPSets {
-		if ipset.Name == name {
+func (c *Config) validateDHCPFailover() ValidationErrors {
+	var errs ValidationErrors
+	if c.DHCP == nil || c.DHCP.Failover == nil {
+		return errs
+	}
+	fo := c.DHCP.Failover
+
+	if c.Replication == nil {
+		errs = append(errs, ValidationError{
+			Field:   "dhcp.failover",
+			Message: "failover requires replication to be configured",
+		})
+	}
+	if fo.Mode != "" && fo.Mode != "active_standby" && fo.Mode != "split" {
+		errs = append(errs, ValidationError{
+			Field:   "dhcp.failover.mode",
+			Message: fmt.Sprintf("invalid mode: %s (must be active_standby or split)", fo.Mode),
+		})
+	}
+	if fo.MCLT != "" {
+		if d, err := time.ParseDuration(fo.MCLT); err != nil || d <= 0 {
+			errs = append(errs, ValidationError{
+				Field:   "dhcp.failover.mclt",
+				Message: fmt.Sprintf("invalid duration: %s", fo.MCLT),
+			})
+		}
+	}
+	if fo.Split < 0 || fo.Split >= 100 {
+		errs = append(errs, ValidationError{
+			Field:   "dhcp.failover.split",
+			Message: "must be between 1 and 99",
+		})
+	}
+
+	return errs
+}
+
+func (c *Config) validateDHCPClasses() ValidationErrors {
+	var errs ValidationErrors
+	if c.DHCP == nil {
+		return errs
+	}
+
+	for _, scope := range c.DHCP.Scopes {
+		seen := make(map[string]bool)
+		for _, class := range scope.Classes {
+			field := fmt.Sprintf("dhcp.scope.%s.class.%s", scope.Name, class.Name)
+			if seen[class.Name] {
+				errs = append(errs, ValidationError{Field: field, Message: "duplicate class"})
+			}
+			seen[class.Name] = true
+
+			if class.VendorClass == "" && class.UserClass == "" && class.MACPrefix == "" && len(class.Architectures) == 0 {
+				errs = append(errs, ValidationError{
+					Field:   field,
+					Message: "class needs at least one of vendor_class, user_class, mac_prefix or architectures",
+				})
+			}
+			if class.MACPrefix != "" && !validMACPrefix(class.MACPrefix) {
+				errs = append(errs, ValidationError{
+					Field:   field + ".mac_prefix",
+					Message: fmt.Sprintf("invalid MAC prefix: %s", class.MACPrefix),
+				})
+			}
+			for _, arch := range class.Architectures {
+				if arch < 0 || arch > 0xffff {
+					errs = append(errs, ValidationError{
+						Field:   field + ".architectures",
+						Message: fmt.Sprintf("invalid architecture type: %d", arch),
+					})
+				}
+			}
+			if len(class.Options) == 0 {
+				errs = append(errs, ValidationError{Field: field + ".options", Message: "class sets no options"})
+			}
+		}
+	}
+
+	return errs
+}
+
+// validMACPrefix reports whether s is one to six hex octets separated by
+// colons or dashes, e.g. "b8:27:eb".
+func validMACPrefix(s string) bool {
+	octets := strings.Split(strings.ReplaceAll(s, "-", ":"), ":")
+	if len(octets) > 6 {
+		return false
+	}
+	for _, o := range octets {
+		if _, err := strconv.ParseUint(o, 16, 8); err != nil || len(o) != 2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) validateDHCPQuarantine() ValidationErrors {
+	var errs ValidationErrors
+	if c.DHCP == nil || c.DHCP.QuarantineTime == "" {
+		return errs
+	}
+	if d, err := time.ParseDuration(c.DHCP.QuarantineTime); err != nil || d <= 0 {
+		errs = append(errs, ValidationError{
+			Field:   "dhcp.quarantine_time",
+			Message: fmt.Sprintf("invalid duration: %s", c.DHCP.QuarantineTime),
+		})
+	}
+	return errs
+}
+
+func (c *Config) validatePPPoE() ValidationErrors {
+	var errs ValidationErrors
+
+	names := make(map[string]bool)
+	for _, iface := range c.Interfaces {
+		names[iface.Name] = true
+	}
+
+	for _, iface := range c.Interfaces {
+		p := iface.PPPoE
+		if p == nil {
+			continue
+		}
+		field := fmt.Sprintf("interfaces[%s].pppoe", iface.Name)
+
+		if p.Username == "" {
+			errs = append(errs, ValidationError{Field: field + ".username", Message: "username is required"})
+		}
+		if iface.DHCP {
+			errs = append(errs, ValidationError{Field: field, Message: "pppoe cannot be combined with dhcp"})
+		}
+		if iface.DHCPv6 {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: "dhcp_v6 is not supported over pppoe; set ipv6 in the pppoe block for SLAAC",
+			})
+		}
+		if p.VLAN < 0 || p.VLAN > 4094 {
+			errs = append(errs, ValidationError{
+				Field:   field + ".vlan",
+				Message: fmt.Sprintf("VLAN ID must be between 1 and 4094, got %d", p.VLAN),
+			})
+		}
+
+		link := p.LinkName(iface.Name)
+		if !isValidInterfaceName(link) {
+			errs = append(errs, ValidationError{
+				Field:   field + ".link",
+				Message: fmt.Sprintf("invalid PPP link name %q; set link to at most 15 characters", link),
+			})
+		} else if names[link] {
+			errs = append(errs, ValidationError{
+				Field:   field + ".link",
+				Message: fmt.Sprintf("PPP link name %s is already used by an interface", link),
+			})
+		}
+		names[link] = true
+
+		if p.MTU != 0 {
+			if p.MTU < 576 || p.MTU > 1500 {
+				errs = append(errs, ValidationError{
+					Field:   field + ".mtu",
+					Message: fmt.Sprintf("MTU must be between 576 and 1500, got %d", p.MTU),
+				})
+			} else if p.MTU > 1492 && iface.MTU < p.MTU+8 {
+				errs = append(errs, ValidationError{
+					Field:   field + ".mtu",
+					Message: fmt.Sprintf("an MTU of %d needs an interface MTU of at least %d", p.MTU, p.MTU+8),
+				})
+			}
+		}
+		if p.LCPEchoInterval < 0 || p.LCPEchoFailure < 0 {
+			errs = append(errs, ValidationError{Field: field, Message: "lcp_echo_interval and lcp_echo_failure cannot be negative"})
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateVirtualInterfaces() ValidationErrors {
+	var errs ValidationErrors
+
+	bridgeOf := make(map[string]string)
+	for _, iface := range c.Interfaces {
+		field := fmt.Sprintf("interfaces[%s]", iface.Name)
+
+		kinds := 0
+		for _, set := range []bool{iface.Bond != nil, iface.Bridge != nil, iface.VXLAN != nil, iface.GRE != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds > 1 {
+			errs = append(errs, ValidationError{Field: field, Message: "only one of bond, bridge, vxlan and gre can be set"})
+		}
+
+		if b := iface.Bridge; b != nil {
+			errs = append(errs, validateBridge(field+".bridge", iface.Name, b, bridgeOf)...)
+		}
+		if v := iface.VXLAN; v != nil {
+			errs = append(errs, validateVXLAN(field+".vxlan", v)...)
+		}
+		if g := iface.GRE; g != nil {
+			errs = append(errs, validateGRE(field+".gre", g)...)
+		}
+	}
+
+	return errs
+}
+
+func validateBridge(field, name string, b *Bridge, bridgeOf map[string]string) ValidationErrors {
+	var errs ValidationErrors
+
+	members := make(map[string]bool)
+	for _, member := range b.Interfaces {
+		switch {
+		case member == name:
+			errs = append(errs, ValidationError{Field: field + ".interfaces", Message: "a bridge cannot be its own member"})
+		case bridgeOf[member] != "":
+			errs = append(errs, ValidationError{
+				Field:   field + ".interfaces",
+				Message: fmt.Sprintf("%s is already a member of bridge %s", member, bridgeOf[member]),
+			})
+		default:
+			bridgeOf[member] = name
+		}
+		members[member] = true
+	}
+
+	if b.Priority != nil && (*b.Priority < 0 || *b.Priority > 65535) {
+		errs = append(errs, ValidationError{
+			Field:   field + ".priority",
+			Message: fmt.Sprintf("STP priority must be between 0 and 65535, got %d", *b.Priority),
+		})
+	}
+
+	if !b.VLANFiltering {
+		if b.DefaultPVID != 0 || len(b.VLANs) > 0 || len(b.Ports) > 0 {
+			errs = append(errs, ValidationError{Field: field, Message: "default_pvid, vlans and port blocks require vlan_filtering"})
+		}
+		return errs
+	}
+
+	if b.DefaultPVID != 0 && !validVLANID(b.DefaultPVID) {
+		errs = append(errs, ValidationError{
+			Field:   field + ".default_pvid",
+			Message: fmt.Sprintf("VLAN ID must be between 1 and 4094, got %d", b.DefaultPVID),
+		})
+	}
+	errs = append(errs, validateVLANList(field+".vlans", b.VLANs)...)
+
+	seen := make(map[string]bool)
+	for _, port := range b.Ports {
+		portField := fmt.Sprintf("%s.port[%s]", field, port.Name)
+		if !members[port.Name] {
+			errs = append(errs, ValidationError{Field: portField, Message: fmt.Sprintf("%s is not a member of the bridge", port.Name)})
+		}
+		if seen[port.Name] {
+			errs = append(errs, ValidationError{Field: portField, Message: "duplicate port block"})
+		}
+		seen[port.Name] = true
+		if port.PVID != 0 && !validVLANID(port.PVID) {
+			errs = append(errs, ValidationError{
+				Field:   portField + ".pvid",
+				Message: fmt.Sprintf("VLAN ID must be between 1 and 4094, got %d", port.PVID),
+			})
+		}
+		errs = append(errs, validateVLANList(portField+".vlans", port.VLANs)...)
+	}
+
+	return errs
+}
+
+func validateVXLAN(field string, v *VXLAN) ValidationErrors {
+	var errs ValidationErrors
+
+	if v.VNI < 1 || v.VNI > 16777215 {
+		errs = append(errs, ValidationError{
+			Field:   field + ".vni",
+			Message: fmt.Sprintf("VNI must be between 1 and 16777215, got %d", v.VNI),
+		})
+	}
+
+	var local net.IP
+	if v.Local != "" {
+		if local = net.ParseIP(v.Local); local == nil {
+			errs = append(errs, ValidationError{Field: field + ".local", Message: fmt.Sprintf("invalid IP address: %s", v.Local)})
+		}
+	}
+	checkPeer := func(attr, addr string, multicast bool) {
+		ip := net.ParseIP(addr)
+		switch {
+		case ip == nil:
+			errs = append(errs, ValidationError{Field: field + "." + attr, Message: fmt.Sprintf("invalid IP address: %s", addr)})
+		case ip.IsMulticast() != multicast:
+			if multicast {
+				errs = append(errs, ValidationError{Field: field + ".group", Message: fmt.Sprintf("%s is not a multicast address", addr)})
+			} else {
+				errs = append(errs, ValidationError{Field: field + ".remote", Message: "use group for a multicast address"})
+			}
+		case local != nil && (ip.To4() == nil) != (local.To4() == nil):
+			errs = append(errs, ValidationError{Field: field + "." + attr, Message: "local and " + attr + " must be the same address family"})
+		}
+	}
+
+	switch {
+	case v.Remote != "" && v.Group != "":
+		errs = append(errs, ValidationError{Field: field, Message: "remote and group are mutually exclusive"})
+	case v.Remote != "":
+		checkPeer("remote", v.Remote, false)
+	case v.Group != "":
+		checkPeer("group", v.Group, true)
+		if v.Device == "" {
+			errs = append(errs, ValidationError{Field: field + ".device", Message: "a multicast group requires device"})
+		}
+	default:
+		errs = append(errs, ValidationError{Field: field, Message: "remote or group is required"})
+	}
+
+	if v.Device != "" && !isValidInterfaceName(v.Device) {
+		errs = append(errs, ValidationError{Field: field + ".device", Message: fmt.Sprintf("invalid interface name: %s", v.Device)})
+	}
+	if v.Port < 0 || v.Port > 65535 {
+		errs = append(errs, ValidationError{Field: field + ".port", Message: fmt.Sprintf("port must be between 1 and 65535, got %d", v.Port)})
+	}
+	if v.TTL < 0 || v.TTL > 255 {
+		errs = append(errs, ValidationError{Field: field + ".ttl", Message: fmt.Sprintf("TTL must be between 0 and 255, got %d", v.TTL)})
+	}
+
+	return errs
+}
+
+func validateGRE(field string, g *GRE) ValidationErrors {
+	var errs ValidationErrors
+
+	remote := net.ParseIP(g.Remote)
+	if remote == nil {
+		errs = append(errs, ValidationError{Field: field + ".remote", Message: fmt.Sprintf("invalid IP address: %s", g.Remote)})
+	}
+	if g.Local != "" {
+		local := net.ParseIP(g.Local)
+		if local == nil {
+			errs = append(errs, ValidationError{Field: field + ".local", Message: fmt.Sprintf("invalid IP address: %s", g.Local)})
+		} else if remote != nil && (remote.To4() == nil) != (local.To4() == nil) {
+			errs = append(errs, ValidationError{Field: field, Message: "local and remote must be the same address family"})
+		}
+	}
+	if g.Key < 0 || g.Key > math.MaxUint32 {
+		errs = append(errs, ValidationError{Field: field + ".key", Message: fmt.Sprintf("key must be between 0 and %d, got %d", uint32(math.MaxUint32), g.Key)})
+	}
+	if g.TTL < 0 || g.TTL > 255 {
+		errs = append(errs, ValidationError{Field: field + ".ttl", Message: fmt.Sprintf("TTL must be between 0 and 255, got %d", g.TTL)})
+	}
+
+	return errs
+}
+
+func validVLANID(id int) bool {
+	return id >= 1 && id <= 4094
+}
+
+func validateVLANList(field string, vlans []int) ValidationErrors {
+	var errs ValidationErrors
+	for _, id := range vlans {
+		if !validVLANID(id) {
+			errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("VLAN ID must be between 1 and 4094, got %d", id)})
+		}
+	}
+	return errs
+}
+
+func (c *Config) validatePrefixDelegation() ValidationErrors {
+	var errs ValidationErrors
+
+	upstreams := make(map[string]Interface)
+	for _, iface := range c.Interfaces {
+		field := fmt.Sprintf("interfaces[%s]", iface.Name)
+		if iface.PrefixDelegation {
+			if !iface.DHCPv6 {
+				errs = append(errs, ValidationError{
+					Field:   field + ".prefix_delegation",
+					Message: "prefix_delegation requires dhcp_v6",
+				})
+			}
+			upstreams[iface.Name] = iface
+		}
+		if iface.PDLength != 0 && (iface.PDLength < 1 || iface.PDLength > 64) {
+			errs = append(errs, ValidationError{
+				Field:   field + ".pd_length",
+				Message: fmt.Sprintf("pd_length must be between 1 and 64, got %d", iface.PDLength),
+			})
+		}
+	}
+
+	subnets := make(map[string]string) // "upstream/id" -> downstream interface
+	for _, iface := range c.Interfaces {
+		field := fmt.Sprintf("interfaces[%s]", iface.Name)
+		if iface.PDFrom == "" {
+			if iface.PDSubnetID != 0 {
+				errs = append(errs, ValidationError{
+					Field:   field + ".pd_subnet_id",
+					Message: "pd_subnet_id requires pd_from",
+				})
+			}
+			continue
+		}
+
+		upstream, ok := upstreams[iface.PDFrom]
+		if !ok {
+			errs = append(errs, ValidationError{
+				Field:   field + ".pd_from",
+				Message: fmt.Sprintf("interface %s does not have prefix_delegation enabled", iface.PDFrom),
+			})
+		}
+		if iface.PDSubnetID < 0 {
+			errs = append(errs, ValidationError{
+				Field:   field + ".pd_subnet_id",
+				Message: fmt.Sprintf("pd_subnet_id must not be negative, got %d", iface.PDSubnetID),
+			})
+			continue
+		}
+		if ok && upstream.PDLength > 0 && upstream.PDLength <= 64 && uint64(iface.PDSubnetID) >= uint64(1)<<(64-upstream.PDLength) {
+			errs = append(errs, ValidationError{
+				Field:   field + ".pd_subnet_id",
+				Message: fmt.Sprintf("pd_subnet_id %d does not fit in a /%d delegation", iface.PDSubnetID, upstream.PDLength),
+			})
+		}
+
+		key := fmt.Sprintf("%s/%d", iface.PDFrom, iface.PDSubnetID)
+		if other, dup := subnets[key]; dup {
+			errs = append(errs, ValidationError{
+				Field:   field + ".pd_subnet_id",
+				Message: fmt.Sprintf("pd_subnet_id %d is already used by %s", iface.PDSubnetID, other),
+			})
+		}
+		subnets[key] = iface.Name
+	}
+
+	return errs
+}
+
+func (c *Config) validateReplication() ValidationErrors {
+	var errs ValidationErrors
+	rep := c.Replication
+	if rep == nil {
+		return errs
+	}
+
+	// With VRRP the role follows the election, so mode is optional
+	if len(rep.VRRP) == 0 && rep.Mode != "primary" && rep.Mode != "replica" {
+		errs = append(errs, ValidationError{
+			Field:   "replication.mode",
+			Message: fmt.Sprintf("invalid mode: %s (must be primary or replica)", rep.Mode),
+		})
+	}
+	if len(rep.VRRP) == 0 && rep.Mode == "replica" && rep.PrimaryAddr == "" {
+		errs = append(errs, ValidationError{
+			Field:   "replication.primary_addr",
+			Message: "primary_addr is required in replica mode",
+		})
+	}
+
+	tlsSet := 0
+	for _, f := range []string{rep.TLSCert, rep.TLSKey, rep.TLSCA} {
+		if f != "" {
+			tlsSet++
+		}
+	}
+	switch {
+	case tlsSet > 0 && tlsSet < 3:
+		errs = append(errs, ValidationError{
+			Field:   "replication",
+			Message: "tls_cert, tls_key and tls_ca must be set together",
+		})
+	case tlsSet == 0 && rep.SecretKey == "":
+		errs = append(errs, ValidationError{
+			Field:   "replication",
+			Message: "secret_key or tls_cert, tls_key and tls_ca are required",
+		})
+	case tlsSet == 0 && len(rep.SecretKey) < 16:
+		errs = append(errs, ValidationError{
+			Field:   "replication.secret_key",
+			Message: "secret_key must be at least 16 characters",
+		})
+	}
+
+	seen := make(map[string]bool)
+	for _, inst := range rep.VRRP {
+		field := fmt.Sprintf("replication.vrrp[%s/%d]", inst.Interface, inst.VRID)
+		key := fmt.Sprintf("%s/%d", inst.Interface, inst.VRID)
+		if seen[key] {
+			errs = append(errs, ValidationError{Field: field, Message: "duplicate vrid on interface"})
+		}
+		seen[key] = true
+
+		if inst.VRID < 1 || inst.VRID > 255 {
+			errs = append(errs, ValidationError{Field: field + ".vrid", Message: "vrid must be between 1 and 255"})
+		}
+		if inst.Priority != 0 && (inst.Priority < 1 || inst.Priority > 254) {
+			errs = append(errs, ValidationError{Field: field + ".priority", Message: "priority must be between 1 and 254"})
+		}
+		if inst.Interval != "" {
+			d, err := time.ParseDuration(inst.Interval)
+			if err != nil || d < 10*time.Millisecond || d > 40950*time.Millisecond {
+				errs = append(errs, ValidationError{
+					Field:   field + ".advert_interval",
+					Message: fmt.Sprintf("invalid advert_interval %q (must be 10ms to 40.95s)", inst.Interval),
+				})
+			}
+		}
+
+		if len(inst.VirtualIPs) == 0 {
+			errs = append(errs, ValidationError{Field: field + ".virtual_ips", Message: "at least one virtual IP is required"})
+		}
+		var v4, v6 bool
+		for _, vip := range inst.VirtualIPs {
+			ip := net.ParseIP(vip)
+			if ip == nil {
+				ip, _, _ = net.ParseCIDR(vip)
+			}
+			if ip == nil {
+				errs = append(errs, ValidationError{Field: field + ".virtual_ips", Message: fmt.Sprintf("invalid virtual IP: %s", vip)})
+				continue
+			}
+			if ip.To4() != nil {
+				v4 = true
+			} else {
+				v6 = true
+			}
+		}
+		if v4 && v6 {
+			errs = append(errs, ValidationError{Field: field + ".virtual_ips", Message: "virtual IPs must all be IPv4 or all IPv6"})
+		}
+	}
+
+	return errs
+}
+
+// Helper functions
+
+// validateACME checks the certificates requested from an ACME CA by the web
+// UI (api.letsencrypt), interfaces (tls mode "acme") and the DoH server.
+func (c *Config) validateACME() ValidationErrors {
+	var errs ValidationErrors
+	usesDNS01 := ""
+
+	checkChallenge := func(field, challenge string) {
+		switch challenge {
+		case "", "http-01", "tls-alpn-01":
+		case "dns-01":
+			usesDNS01 = field
+		default:
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("unknown ACME challenge %q (use http-01, tls-alpn-01 or dns-01)", challenge),
+			})
+		}
+	}
+
+	var le *LetsEncryptConfig
+	if c.API != nil && c.API.LetsEncrypt != nil && c.API.LetsEncrypt.Enabled {
+		le = c.API.LetsEncrypt
+		if le.Domain == "" {
+			errs = append(errs, ValidationError{Field: "api.letsencrypt.domain", Message: "domain is required"})
+		}
+		checkChallenge("api.letsencrypt.challenge", le.Challenge)
+		if le.DirectoryURL != "" && !isValidURL(le.DirectoryURL) {
+			errs = append(errs, ValidationError{Field: "api.letsencrypt.directory_url", Message: "invalid URL"})
+		}
+	}
+
+	for _, iface := range c.Interfaces {
+		if iface.TLS == nil || iface.TLS.Mode != "acme" {
+			continue
+		}
+		field := fmt.Sprintf("interfaces[%s].tls", iface.Name)
+		if len(iface.TLS.Domains) == 0 {
+			errs = append(errs, ValidationError{Field: field + ".domains", Message: "acme mode needs at least one domain"})
+		}
+		challenge := iface.TLS.Challenge
+		if challenge == "" && le != nil {
+			challenge = le.Challenge
+		}
+		checkChallenge(field+".challenge", challenge)
+	}
+
+	dohServers := map[string]*DoHServerConfig{}
+	if c.DNSServer != nil {
+		dohServers["dns_server.doh_server"] = c.DNSServer.DoHServer
+	}
+	if c.DNS != nil {
+		for _, serve := range c.DNS.Serve {
+			dohServers[fmt.Sprintf("dns.serve[%s].doh_server", serve.Zone)] = serve.DoHServer
+		}
+	}
+	for field, doh := range dohServers {
+		if doh != nil && doh.UseLetsEncrypt && doh.Domain == "" {
+			errs = append(errs, ValidationError{Field: field + ".domain", Message: "domain is required for use_letsencrypt"})
+		}
+	}
+
+	if usesDNS01 != "" {
+		if c.DDNS == nil || !strings.EqualFold(c.DDNS.Provider, "cloudflare") || c.DDNS.Token == "" || c.DDNS.ZoneID == "" {
+			errs = append(errs, ValidationError{
+				Field:   usesDNS01,
+				Message: "dns-01 needs a ddns block with provider cloudflare, token and zone_id",
+			})
+		}
+	}
+
+	return errs
+}
+
+// validateCaptivePortal checks the guest portal's login settings.
+func (c *Config) validateCaptivePortal() ValidationErrors {
+	p := c.CaptivePortal
+	if p == nil {
+		return nil
+	}
+	var errs ValidationErrors
+
+	switch p.Auth {
+	case "", "click":
+	case "voucher":
+		if len(p.Vouchers) == 0 {
+			errs = append(errs, ValidationError{Field: "captive_portal.vouchers", Message: "voucher login needs at least one voucher"})
+		}
+	case "password":
+		if p.Password == "" {
+			errs = append(errs, ValidationError{Field: "captive_portal.password", Message: "password login needs a password"})
+		}
+	default:
+		errs = append(errs, ValidationError{
+			Field:   "captive_portal.auth",
+			Message: fmt.Sprintf("unknown login method %q (use click, voucher or password)", p.Auth),
+		})
+	}
+
+	if p.SessionTimeout != "" {
+		if d, err := time.ParseDuration(p.SessionTimeout); err != nil || d < time.Minute {
+			errs = append(errs, ValidationError{Field: "captive_portal.session_timeout", Message: "must be a duration of at least 1m"})
+		}
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		errs = append(errs, ValidationError{Field: "captive_portal.port", Message: "port must be between 1 and 65535"})
+	}
+	if p.TLSPort < 0 || p.TLSPort > 65535 {
+		errs = append(errs, ValidationError{Field: "captive_portal.tls_port", Message: "port must be between 1 and 65535"})
+	}
+	if (p.TLSCert == "") != (p.TLSKey == "") {
+		errs = append(errs, ValidationError{Field: "captive_portal.tls_cert", Message: "tls_cert and tls_key must be set together"})
+	}
+	return errs
+}
+
+// validateSNMP checks the SNMP agent's credentials and trap targets.
+func (c *Config) validateSNMP() ValidationErrors {
+	s := c.SNMP
+	if s == nil || !s.Enabled {
+		return nil
+	}
+	var errs ValidationErrors
+
+	if s.Community == "" && len(s.Users) == 0 {
+		errs = append(errs, ValidationError{Field: "snmp", Message: "needs a community or at least one user"})
+	}
+	if _, _, err := net.SplitHostPort(s.ListenAddr()); err != nil {
+		errs = append(errs, ValidationError{Field: "snmp.listen", Message: fmt.Sprintf("invalid address %q", s.Listen)})
+	}
+	if s.EngineID != "" {
+		if id, err := hex.DecodeString(s.EngineID); err != nil || len(id) < 5 || len(id) > 32 {
+			errs = append(errs, ValidationError{Field: "snmp.engine_id", Message: "must be 5 to 32 bytes of hex"})
+		}
+	}
+	if s.EnterpriseOID != "" && !isEnterpriseOID(s.EnterpriseOID) {
+		errs = append(errs, ValidationError{Field: "snmp.enterprise_oid", Message: "must be an OID under 1.3.6.1.4.1"})
+	}
+
+	users := make(map[string]bool)
+	for _, u := range s.Users {
+		field := fmt.Sprintf("snmp.user[%s]", u.Name)
+		if u.Name == "" || users[u.Name] {
+			errs = append(errs, ValidationError{Field: field, Message: "user names must be unique and not empty"})
+		}
+		users[u.Name] = true
+
+		switch u.AuthProtocol {
+		case "", "md5", "sha", "sha256":
+		default:
+			errs = append(errs, ValidationError{Field: field + ".auth_protocol", Message: fmt.Sprintf("unknown protocol %q (use md5, sha or sha256)", u.AuthProtocol)})
+		}
+		switch u.PrivProtocol {
+		case "", "des", "aes":
+		default:
+			errs = append(errs, ValidationError{Field: field + ".priv_protocol", Message: fmt.Sprintf("unknown protocol %q (use des or aes)", u.PrivProtocol)})
+		}
+		// RFC 3414 section 11.2
+		if u.AuthPassword != "" && len(u.AuthPassword) < 8 {
+			errs = append(errs, ValidationError{Field: field + ".auth_password", Message: "must be at least 8 characters"})
+		}
+		if u.PrivPassword != "" && len(u.PrivPassword) < 8 {
+			errs = append(errs, ValidationError{Field: field + ".priv_password", Message: "must be at least 8 characters"})
+		}
+		if u.PrivPassword != "" && u.AuthPassword == "" {
+			errs = append(errs, ValidationError{Field: field + ".priv_password", Message: "privacy requires authentication"})
+		}
+	}
+
+	for _, t := range s.Traps {
+		field := fmt.Sprintf("snmp.trap[%s]", t.Address)
+		if (t.Community == "") == (t.User == "") {
+			errs = append(errs, ValidationError{Field: field, Message: "set either community or user"})
+		} else if t.User != "" && !users[t.User] {
+			errs = append(errs, ValidationError{Field: field + ".user", Message: fmt.Sprintf("unknown user %q", t.User)})
+		}
+	}
+	return errs
+}
+
+// validateIPS checks the IPS queue settings, rule files and variables.
+func (c *Config) validateIPS() ValidationErrors {
+	ips := c.IPS
+	if ips == nil || !ips.Enabled {
+		return nil
+	}
+	var errs ValidationErrors
+
+	if ips.Queue < 0 || ips.Queue > 65535 {
+		errs = append(errs, ValidationError{Field: "ips.queue", Message: fmt.Sprintf("must be between 0 and 65535, got %d", ips.Queue)})
+	} else if rl := c.RuleLearning; rl != nil && rl.Enabled && rl.InlineMode {
+		learningQueue := rl.LogGroup
+		if learningQueue == 0 {
+			learningQueue = 100
+		}
+		if ips.QueueNum() == learningQueue {
+			errs = append(errs, ValidationError{Field: "ips.queue", Message: fmt.Sprintf("queue %d is used by inline rule learning", learningQueue)})
+		}
+	}
+	if ips.QueueLength < 0 {
+		errs = append(errs, ValidationError{Field: "ips.queue_length", Message: "must not be negative"})
+	}
+	for i, pattern := range ips.RuleFiles {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("ips.rule_files[%d]", i), Message: fmt.Sprintf("invalid pattern %q", pattern)})
+		}
+	}
+	if len(ips.RuleFiles) == 0 && strings.TrimSpace(ips.Rules) == "" {
+		errs = append(errs, ValidationError{Field: "ips", Message: "needs rule_files or rules"})
+	}
+	for name := range ips.Vars {
+		if matched, _ := regexp.MatchString(`^[A-Za-z_][A-Za-z0-9_]*$`, name); !matched {
+			errs = append(errs, ValidationError{Field: "ips.vars", Message: fmt.Sprintf("invalid variable name %q", name)})
+		}
+	}
+	return errs
+}
+
+// isEnterpriseOID reports whether oid is a dotted OID under enterprises.
+func isEnterpriseOID(oid string) bool {
+	rest, ok := strings.CutPrefix(oid, "1.3.6.1.4.1.")
+	if !ok {
+		return false
+	}
+	for _, arc := range strings.Split(rest, ".") {
+		if _, err := strconv.ParseUint(arc, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) getDefinedZones() map[string]bool {
+	zones := make(map[string]bool)
+
+	// 1. Explicit zone definitions
+	for _, zone := range c.Zones {
+		zones[zone.Name] = true
+	}
+
+	// 2. Interface-referenced zones (legacy style: interface has zone = "wan")
+	for _, iface := range c.Interfaces {
+		if iface.Zone != "" {
+			zones[iface.Zone] = true
+		}
+		for _, vlan := range iface.VLANs {
+			if vlan.Zone != "" {
+				zones[vlan.Zone] = true
+			}
+		}
+	}
+	return zones
+}
+
+// NormalizeZoneMappings unifies zone-interface mappings from both syntaxes:
+//   - Zone-centric: zone "wan" { interfaces = ["eth0"] }
+//   - Interface-centric (legacy): interface "eth0" { zone = "wan" }
+//
+// After normalization, all zone.Interfaces arrays are populated AND all iface.Zone fields are set.
+func (c *Config) NormalizeZoneMappings() {
+	// Build map of zone name -> zone INDEX (not pointer, to survive slice reallocation)
+	zoneIndex := make(map[string]int)
+	for i := range c.Zones {
+		zoneIndex[c.Zones[i].Name] = i
+	}
+
+	// Helper to get zone by name, returning nil if not found
+	getZone := func(name string) *Zone {
+		if idx, ok := zoneIndex[name]; ok {
+			return &c.Zones[idx]
+		}
+		return nil
+	}
+
+	// Helper to add a new zone and update the index
+	addZone := func(z Zone) *Zone {
+		c.Zones = append(c.Zones, z)
+		idx := len(c.Zones) - 1
+		zoneIndex[z.Name] = idx
+		return &c.Zones[idx]
+	}
+
+	// 1. Process zone-centric config: zone "name" { interfaces = [...] }
+	// We need to populate iface.Zone for these interfaces if not already set
+	ifaceMap := make(map[string]*Interface)
+	for i := range c.Interfaces {
+		ifaceMap[c.Interfaces[i].Name] = &c.Interfaces[i]
+	}
+
+	for _, zone := range c.Zones {
+		for _, ifaceName := range zone.Interfaces {
+			if iface, exists := ifaceMap[ifaceName]; exists {
+				// Set interface zone if empty (prefer zone definition in new syntax)
+				if iface.Zone == "" {
+					iface.Zone = zone.Name
+				}
+			}
+		}
+	}
+
+	// 2. Process interface-centric (legacy): interface "name" { zone = "..." }
+	// We need to add these interfaces to the zone's interface list
+	for _, iface := range c.Interfaces {
+		if iface.Zone != "" {
+			zone := getZone(iface.Zone)
+			if zone == nil {
+				// Create implicit zone for this interface-referenced zone
+				zone = addZone(Zone{
+					Name:       iface.Zone,
+					Interfaces: []string{iface.Name},
+				})
+			} else {
+				// Add interface to existing zone if not already present
+				if !containsString(zone.Interfaces, iface.Name) {
+					zone.Interfaces = append(zone.Interfaces, iface.Name)
+				}
+			}
+		}
+
+		// The PPP link carries the traffic of the interface's zone
+		if iface.PPPoE != nil && iface.Zone != "" {
+			if zone := getZone(iface.Zone); !containsString(zone.Interfaces, iface.PPPoE.LinkName(iface.Name)) {
+				zone.Interfaces = append(zone.Interfaces, iface.PPPoE.LinkName(iface.Name))
+			}
+		}
+
+		// Process VLAN zones
+		for _, vlan := range iface.VLANs {
+			if vlan.Zone != "" {
+				vlanIfaceName := fmt.Sprintf("%s.%s", iface.Name, vlan.ID)
+				zone := getZone(vlan.Zone)
+				if zone == nil {
+					addZone(Zone{
+						Name:       vlan.Zone,
+						Interfaces: []string{vlanIfaceName},
+					})
+				} else {
+					if !containsString(zone.Interfaces, vlanIfaceName) {
+						zone.Interfaces = append(zone.Interfaces, vlanIfaceName)
+					}
+				}
+			}
+		}
+	}
+}
+
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) hasIPSet(name string) bool {
+	for _, ipset := range c.IPSets {
+		if ipset.Name == name {
+			return true
+		}
+	}
+	for _, iface := range c.Interfaces {
+		if iface.PDFrom != "" && DelegatedPrefixSetName(iface.Name) == name {
 			return true
 		}
 	}
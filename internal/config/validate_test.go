@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -431,6 +432,550 @@ func TestValidateRoutes(t *testing.T) {
 	}
 }
 
+// TestValidateDNSProfiles tests DNS filtering profile validation
+func TestValidateDNSProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		dns      *DNS
+		dhcp     *DHCPServer
+		wantErrs int
+	}{
+		{
+			name: "valid profiles",
+			dns: &DNS{
+				FilterProfiles: []DNSFilterProfile{{Name: "kids", Sources: []string{"192.168.1.0/25", "192.168.1.200"}, BlockedAddress: "0.0.0.0"}},
+				Serve:          []DNSServe{{Zone: "lan", FilterProfile: "kids"}},
+			},
+			dhcp:     &DHCPServer{Scopes: []DHCPScope{{Name: "lan", Reservations: []DHCPReservation{{MAC: "aa:bb:cc:dd:ee:ff", DNSProfile: "kids"}}}}},
+			wantErrs: 0,
+		},
+		{
+			name:     "duplicate name",
+			dns:      &DNS{FilterProfiles: []DNSFilterProfile{{Name: "kids"}, {Name: "kids"}}},
+			wantErrs: 1,
+		},
+		{
+			name:     "invalid source and blocked address",
+			dns:      &DNS{FilterProfiles: []DNSFilterProfile{{Name: "iot", Sources: []string{"not-a-cidr"}, BlockedAddress: "nowhere"}}},
+			wantErrs: 2,
+		},
+		{
+			name:     "unknown profile references",
+			dns:      &DNS{Serve: []DNSServe{{Zone: "lan", FilterProfile: "kids"}}},
+			dhcp:     &DHCPServer{Scopes: []DHCPScope{{Name: "lan", Reservations: []DHCPReservation{{MAC: "aa:bb:cc:dd:ee:ff", DNSProfile: "iot"}}}}},
+			wantErrs: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DNS: tt.dns, DHCP: tt.dhcp}
+			errs := cfg.validateDNSProfiles()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+// TestValidateReplication tests replication authentication validation
+func TestValidateDHCPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    DHCPScope
+		wantErrs int
+	}{
+		{"v4 only", DHCPScope{Name: "lan", Reservations: []DHCPReservation{{MAC: "00:11:22:33:44:55", IP: "192.168.1.10"}}}, 0},
+		{"valid v6", DHCPScope{
+			Name:         "lan",
+			RangeStartV6: "2001:db8::100",
+			RangeEndV6:   "2001:db8::1ff",
+			DNSServersV6: []string{"2001:db8::1"},
+			Reservations: []DHCPReservation{
+				{MAC: "00:11:22:33:44:55", DUID: "00:01:00:01:2a:3b:4c:5d:00:11:22:33:44:55", IPv6: "2001:db8::50"},
+			},
+		}, 0},
+		{"half range", DHCPScope{Name: "lan", RangeStartV6: "2001:db8::100"}, 1},
+		{"v4 range", DHCPScope{Name: "lan", RangeStartV6: "192.168.1.100", RangeEndV6: "2001:db8::1ff"}, 1},
+		{"reversed range", DHCPScope{Name: "lan", RangeStartV6: "2001:db8::1ff", RangeEndV6: "2001:db8::100"}, 1},
+		{"v4 dns", DHCPScope{Name: "lan", DNSServersV6: []string{"192.168.1.1"}}, 1},
+		{"bad reservations", DHCPScope{Name: "lan", Reservations: []DHCPReservation{
+			{MAC: "00:11:22:33:44:55"},
+			{MAC: "00:11:22:33:44:66", DUID: "not-hex", IPv6: "192.168.1.10"},
+		}}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DHCP: &DHCPServer{Scopes: []DHCPScope{tt.scope}}}
+			errs := cfg.validateDHCPv6()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidatePrefixDelegation(t *testing.T) {
+	wan := Interface{Name: "eth0", DHCPv6: true, PrefixDelegation: true, PDLength: 60}
+	tests := []struct {
+		name       string
+		interfaces []Interface
+		wantErrs   int
+	}{
+		{"valid", []Interface{wan, {Name: "eth1", PDFrom: "eth0", PDSubnetID: 1}, {Name: "eth2", PDFrom: "eth0", PDSubnetID: 15}}, 0},
+		{"no length hint", []Interface{{Name: "eth0", DHCPv6: true, PrefixDelegation: true}, {Name: "eth1", PDFrom: "eth0", PDSubnetID: 4096}}, 0},
+		{"without dhcp_v6", []Interface{{Name: "eth0", PrefixDelegation: true}}, 1},
+		{"bad length", []Interface{{Name: "eth0", DHCPv6: true, PrefixDelegation: true, PDLength: 65}}, 1},
+		{"unknown upstream", []Interface{wan, {Name: "eth1", PDFrom: "eth3"}}, 1},
+		{"subnet id too large", []Interface{wan, {Name: "eth1", PDFrom: "eth0", PDSubnetID: 16}}, 1},
+		{"duplicate subnet id", []Interface{wan, {Name: "eth1", PDFrom: "eth0", PDSubnetID: 1}, {Name: "eth2", PDFrom: "eth0", PDSubnetID: 1}}, 1},
+		{"subnet id without pd_from", []Interface{wan, {Name: "eth1", PDSubnetID: 1}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: tt.interfaces}
+			errs := cfg.validatePrefixDelegation()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateDHCPRelays(t *testing.T) {
+	scope := DHCPScope{Name: "lan", Interface: "eth1"}
+	tests := []struct {
+		name     string
+		relays   []DHCPRelay
+		wantErrs int
+	}{
+		{"valid", []DHCPRelay{{Interface: "eth2", Servers: []string{"10.0.0.5"}, ServersV6: []string{"2001:db8::5"}, CircuitID: "vlan20"}}, 0},
+		{"v6 only", []DHCPRelay{{Interface: "eth2", ServersV6: []string{"2001:db8::5"}}}, 0},
+		{"no servers", []DHCPRelay{{Interface: "eth2"}}, 1},
+		{"interface has scope", []DHCPRelay{{Interface: "eth1", Servers: []string{"10.0.0.5"}}}, 1},
+		{"duplicate", []DHCPRelay{{Interface: "eth2", Servers: []string{"10.0.0.5"}}, {Interface: "eth2", Servers: []string{"10.0.0.6"}}}, 1},
+		{"v6 server in servers", []DHCPRelay{{Interface: "eth2", Servers: []string{"2001:db8::5"}}}, 1},
+		{"v4 server in servers_v6", []DHCPRelay{{Interface: "eth2", ServersV6: []string{"10.0.0.5"}}}, 1},
+		{"circuit id too long", []DHCPRelay{{Interface: "eth2", Servers: []string{"10.0.0.5"}, CircuitID: strings.Repeat("x", 256)}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DHCP: &DHCPServer{Scopes: []DHCPScope{scope}, Relays: tt.relays}}
+			errs := cfg.validateDHCPRelays()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateDHCPFailover(t *testing.T) {
+	rep := &ReplicationConfig{Mode: "primary", SecretKey: "0123456789abcdef"}
+	tests := []struct {
+		name     string
+		rep      *ReplicationConfig
+		failover *DHCPFailover
+		wantErrs int
+	}{
+		{"defaults", rep, &DHCPFailover{}, 0},
+		{"split", rep, &DHCPFailover{Mode: "split", MCLT: "30m", Split: 60}, 0},
+		{"no replication", nil, &DHCPFailover{}, 1},
+		{"invalid mode", rep, &DHCPFailover{Mode: "load_balance"}, 1},
+		{"invalid mclt", rep, &DHCPFailover{MCLT: "forever"}, 1},
+		{"split out of range", rep, &DHCPFailover{Mode: "split", Split: 100}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Replication: tt.rep, DHCP: &DHCPServer{Failover: tt.failover}}
+			errs := cfg.validateDHCPFailover()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateDHCPQuarantine(t *testing.T) {
+	for _, tt := range []struct {
+		quarantine string
+		wantErrs   int
+	}{{"", 0}, {"30m", 0}, {"soon", 1}, {"-1h", 1}} {
+		cfg := &Config{DHCP: &DHCPServer{QuarantineTime: tt.quarantine}}
+		if errs := cfg.validateDHCPQuarantine(); len(errs) != tt.wantErrs {
+			t.Errorf("quarantine_time %q: got %d errors, want %d: %v", tt.quarantine, len(errs), tt.wantErrs, errs)
+		}
+	}
+}
+
+func TestValidateDHCPClasses(t *testing.T) {
+	netboot := map[string]string{"bootfile_name": "ipxe.efi"}
+	tests := []struct {
+		name     string
+		classes  []DHCPClass
+		wantErrs int
+	}{
+		{"valid", []DHCPClass{
+			{Name: "uefi", VendorClass: "PXEClient", Architectures: []int{7, 9}, Options: netboot},
+			{Name: "ipxe", UserClass: "iPXE", Options: map[string]string{"bootfile_name": "http://10.0.0.1/boot.ipxe"}},
+			{Name: "pi", MACPrefix: "B8-27-EB", Options: netboot},
+		}, 0},
+		{"duplicate name", []DHCPClass{
+			{Name: "pxe", VendorClass: "PXEClient", Options: netboot},
+			{Name: "pxe", UserClass: "iPXE", Options: netboot},
+		}, 1},
+		{"no condition", []DHCPClass{{Name: "all", Options: netboot}}, 1},
+		{"bad mac prefix", []DHCPClass{
+			{Name: "a", MACPrefix: "b8:27:", Options: netboot},
+			{Name: "b", MACPrefix: "b827eb", Options: netboot},
+		}, 2},
+		{"bad architecture", []DHCPClass{{Name: "arch", Architectures: []int{7, 70000}, Options: netboot}}, 1},
+		{"no options", []DHCPClass{{Name: "empty", VendorClass: "PXEClient"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DHCP: &DHCPServer{Scopes: []DHCPScope{{Name: "lan", Classes: tt.classes}}}}
+			if errs := cfg.validateDHCPClasses(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidatePPPoE(t *testing.T) {
+	tests := []struct {
+		name     string
+		iface    Interface
+		wantErrs int
+	}{
+		{"valid", Interface{Name: "wan", PPPoE: &PPPoE{Username: "user@isp", Password: "secret", VLAN: 7, IPv6: true}}, 0},
+		{"baby jumbo", Interface{Name: "wan", MTU: 1508, PPPoE: &PPPoE{Username: "u", MTU: 1500}}, 0},
+		{"no username", Interface{Name: "wan", PPPoE: &PPPoE{}}, 1},
+		{"with dhcp", Interface{Name: "wan", DHCP: true, DHCPv6: true, PPPoE: &PPPoE{Username: "u"}}, 2},
+		{"bad vlan", Interface{Name: "wan", PPPoE: &PPPoE{Username: "u", VLAN: 4095}}, 1},
+		{"link clash", Interface{Name: "wan", PPPoE: &PPPoE{Username: "u", Link: "lan"}}, 1},
+		{"long link name", Interface{Name: "wan-fibre-main", PPPoE: &PPPoE{Username: "u"}}, 1},
+		{"mtu without jumbo frames", Interface{Name: "wan", PPPoE: &PPPoE{Username: "u", MTU: 1500}}, 1},
+		{"negative echo", Interface{Name: "wan", PPPoE: &PPPoE{Username: "u", LCPEchoFailure: -1}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: []Interface{tt.iface, {Name: "lan"}}}
+			if errs := cfg.validatePPPoE(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateVirtualInterfaces(t *testing.T) {
+	prio := 70000
+	tests := []struct {
+		name     string
+		iface    Interface
+		wantErrs int
+	}{
+		{"bridge", Interface{Name: "br0", Bridge: &Bridge{Interfaces: []string{"eth1", "eth2"}, STP: true}}, 0},
+		{"vlan aware bridge", Interface{Name: "br0", Bridge: &Bridge{
+			Interfaces: []string{"eth1", "eth2"}, VLANFiltering: true, DefaultPVID: 1, VLANs: []int{10, 20},
+			Ports: []BridgePort{{Name: "eth1", PVID: 10}, {Name: "eth2", VLANs: []int{10, 20}}},
+		}}, 0},
+		{"bridge own member", Interface{Name: "br0", Bridge: &Bridge{Interfaces: []string{"br0"}}}, 1},
+		{"bridge member of another bridge", Interface{Name: "br1", Bridge: &Bridge{Interfaces: []string{"lan"}}}, 1},
+		{"bridge bad priority", Interface{Name: "br0", Bridge: &Bridge{Priority: &prio}}, 1},
+		{"bridge vlans without filtering", Interface{Name: "br0", Bridge: &Bridge{VLANs: []int{10}}}, 1},
+		{"bridge bad ports", Interface{Name: "br0", Bridge: &Bridge{
+			Interfaces: []string{"eth1"}, VLANFiltering: true, VLANs: []int{4095},
+			Ports: []BridgePort{{Name: "eth1", PVID: 5000}, {Name: "eth1"}, {Name: "eth9"}},
+		}}, 4},
+		{"vxlan unicast", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Remote: "203.0.113.2", Local: "198.51.100.1"}}, 0},
+		{"vxlan multicast", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Group: "239.1.1.100", Device: "eth0"}}, 0},
+		{"vxlan no peer", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100}}, 1},
+		{"vxlan remote and group", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Remote: "203.0.113.2", Group: "239.1.1.1"}}, 1},
+		{"vxlan group without device", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Group: "239.1.1.100"}}, 1},
+		{"vxlan multicast remote", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Remote: "239.1.1.100"}}, 1},
+		{"vxlan mixed families", Interface{Name: "vx100", VXLAN: &VXLAN{VNI: 100, Remote: "2001:db8::2", Local: "198.51.100.1"}}, 1},
+		{"vxlan out of range", Interface{Name: "vx0", VXLAN: &VXLAN{VNI: 1 << 24, Remote: "203.0.113.2", Port: 70000, TTL: 256}}, 3},
+		{"gretap", Interface{Name: "gt0", GRE: &GRE{Remote: "203.0.113.2", Local: "198.51.100.1", Tap: true, Key: 42}}, 0},
+		{"gre bad remote", Interface{Name: "gre0", GRE: &GRE{Remote: "peer"}}, 1},
+		{"gre mixed families", Interface{Name: "gre0", GRE: &GRE{Remote: "2001:db8::2", Local: "198.51.100.1"}}, 1},
+		{"gre out of range", Interface{Name: "gre0", GRE: &GRE{Remote: "203.0.113.2", Key: 1 << 32, TTL: -1}}, 2},
+		{"bond and bridge", Interface{Name: "br0", Bond: &Bond{}, Bridge: &Bridge{}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Interfaces: []Interface{{Name: "br9", Bridge: &Bridge{Interfaces: []string{"lan"}}}, tt.iface}}
+			if errs := cfg.validateVirtualInterfaces(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateOSPF(t *testing.T) {
+	prio := func(p int) *int { return &p }
+	tests := []struct {
+		name     string
+		ospf     OSPF
+		wantErrs int
+	}{
+		{"valid", OSPF{RouterID: "10.255.0.1", Networks: []string{"10.0.0.0/16", "2001:db8::/48"},
+			Areas: []OSPFArea{{ID: "1", Networks: []string{"10.1.0.0/16"}, Stub: true, NoSummary: true, DefaultCost: 5}},
+			Interfaces: []OSPFInterface{
+				{Name: "wg0", Type: "point-to-point", Cost: 100, HelloInterval: 5, DeadInterval: 20},
+				{Name: "eth1", Priority: prio(0), Passive: true},
+			}}, 0},
+		{"bad router id and network", OSPF{RouterID: "0.0.0.0", Networks: []string{"10.0.0.1"}}, 2},
+		{"bad areas", OSPF{Areas: []OSPFArea{
+			{ID: "0.0.0.0", Stub: true},
+			{ID: "0"}, // Duplicate of the backbone
+			{ID: "area-1", Networks: []string{"bogus"}, NoSummary: true, DefaultCost: -1},
+		}}, 6},
+		{"bad interfaces", OSPF{Interfaces: []OSPFInterface{
+			{Name: "eth0", Type: "nbma", Cost: 70000, HelloInterval: -1, Priority: prio(256)},
+			{Name: "eth0", HelloInterval: 10, DeadInterval: 10}, // Duplicate
+		}}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{FRR: &FRRConfig{Enabled: true, OSPF: &tt.ospf}}
+			if errs := cfg.validateOSPF(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateBGP(t *testing.T) {
+	lists := []PrefixList{{Name: "upstream-in", Rules: []PrefixListRule{
+		{Prefix: "10.66.0.0/16", Action: "deny", GE: 16},
+		{Prefix: "0.0.0.0/0", LE: 24},
+	}}}
+	tests := []struct {
+		name     string
+		bgp      BGP
+		wantErrs int
+	}{
+		{"valid", BGP{ASN: 4200000001, RouterID: "10.255.0.1", Networks: []string{"10.1.0.0/16", "2001:db8::/48"}, PrefixLists: lists, Neighbors: []Neighbor{
+			{IP: "192.0.2.1", RemoteASN: 65001, HoldTime: 30, Table: 100, Import: "upstream-in"},
+			{IP: "2001:db8::1", RemoteASN: 65001, Passive: true},
+		}}, 0},
+		{"bad asn and router id", BGP{ASN: 0, RouterID: "2001:db8::1"}, 2},
+		{"bad network", BGP{ASN: 65000, Networks: []string{"10.1.0.0"}}, 1},
+		{"bad rules", BGP{ASN: 65000, PrefixLists: []PrefixList{{Name: "x", Rules: []PrefixListRule{
+			{Prefix: "10.0.0.0/8", Action: "accept"},
+			{Prefix: "10.0.0.0/8", GE: 4},
+			{Prefix: "10.0.0.0/8", GE: 24, LE: 16},
+			{Prefix: "2001:db8::/32", LE: 129},
+			{Prefix: "bogus"},
+		}}}}, 5},
+		{"duplicate prefix list", BGP{ASN: 65000, PrefixLists: append(lists, lists...)}, 1},
+		{"bad neighbors", BGP{ASN: 65000, Neighbors: []Neighbor{
+			{IP: "192.0.2.1", RemoteASN: 65001, HoldTime: 2, Port: 70000},
+			{IP: "192.0.2.1", RemoteASN: 65001}, // Duplicate
+			{IP: "peer", RemoteASN: 1 << 32, Table: -1, Import: "missing", Export: "missing"},
+		}}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{FRR: &FRRConfig{Enabled: true, BGP: &tt.bgp}}
+			if errs := cfg.validateBGP(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateReplication(t *testing.T) {
+	tests := []struct {
+		name     string
+		rep      *ReplicationConfig
+		wantErrs int
+	}{
+		{"secret key", &ReplicationConfig{Mode: "primary", SecretKey: "0123456789abcdef"}, 0},
+		{"mutual tls", &ReplicationConfig{Mode: "replica", PrimaryAddr: "10.0.0.1:9999", TLSCert: "c.pem", TLSKey: "k.pem", TLSCA: "ca.pem"}, 0},
+		{"no authentication", &ReplicationConfig{Mode: "primary"}, 1},
+		{"short secret key", &ReplicationConfig{Mode: "primary", SecretKey: "hunter2"}, 1},
+		{"partial tls", &ReplicationConfig{Mode: "primary", TLSCert: "c.pem"}, 1},
+		{"replica without primary", &ReplicationConfig{Mode: "replica", SecretKey: "0123456789abcdef"}, 1},
+		{"invalid mode", &ReplicationConfig{Mode: "leader", SecretKey: "0123456789abcdef"}, 1},
+		{"vrrp without mode", &ReplicationConfig{SecretKey: "0123456789abcdef", VRRP: []VRRPInstance{
+			{Interface: "eth1", VRID: 10, Priority: 200, VirtualIPs: []string{"192.168.1.254/24"}, Interval: "500ms"},
+			{Interface: "eth2", VRID: 10, VirtualIPs: []string{"2001:db8::1"}},
+		}}, 0},
+		{"vrrp duplicate vrid", &ReplicationConfig{SecretKey: "0123456789abcdef", VRRP: []VRRPInstance{
+			{Interface: "eth1", VRID: 10, VirtualIPs: []string{"192.168.1.254"}},
+			{Interface: "eth1", VRID: 10, VirtualIPs: []string{"192.168.1.253"}},
+		}}, 1},
+		{"vrrp out of range", &ReplicationConfig{SecretKey: "0123456789abcdef", VRRP: []VRRPInstance{
+			{Interface: "eth1", VRID: 256, Priority: 255, VirtualIPs: []string{"192.168.1.254"}, Interval: "1m"},
+		}}, 3},
+		{"vrrp bad virtual ips", &ReplicationConfig{SecretKey: "0123456789abcdef", VRRP: []VRRPInstance{
+			{Interface: "eth1", VRID: 1},
+			{Interface: "eth2", VRID: 1, VirtualIPs: []string{"192.168.1.254", "2001:db8::1", "nope"}},
+		}}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Replication: tt.rep}
+			errs := cfg.validateReplication()
+			if len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateACME(t *testing.T) {
+	cloudflare := &DDNSConfig{Provider: "cloudflare", Token: "t", ZoneID: "z"}
+	tests := []struct {
+		name     string
+		cfg      Config
+		wantErrs int
+	}{
+		{"web http-01", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com"}}}, 0},
+		{"disabled", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Challenge: "bogus"}}}, 0},
+		{"web without domain", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true}}}, 1},
+		{"unknown challenge", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", Challenge: "tls-sni-01"}}}, 1},
+		{"bad directory", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", DirectoryURL: "pebble"}}}, 1},
+		{"dns-01 with cloudflare", Config{
+			API:  &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", Challenge: "dns-01"}},
+			DDNS: cloudflare,
+		}, 0},
+		{"dns-01 without provider", Config{API: &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", Challenge: "dns-01"}}}, 1},
+		{"dns-01 with duckdns", Config{
+			API:  &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", Challenge: "dns-01"}},
+			DDNS: &DDNSConfig{Provider: "duckdns", Token: "t"},
+		}, 1},
+		{"interface acme", Config{Interfaces: []Interface{{Name: "wan", TLS: &TLSConfig{Mode: "acme", Domains: []string{"wan.example.com"}, Challenge: "tls-alpn-01"}}}}, 0},
+		{"interface without domains", Config{Interfaces: []Interface{{Name: "wan", TLS: &TLSConfig{Mode: "acme"}}}}, 1},
+		{"interface inherits dns-01", Config{
+			API:        &APIConfig{LetsEncrypt: &LetsEncryptConfig{Enabled: true, Domain: "fw.example.com", Challenge: "dns-01"}},
+			Interfaces: []Interface{{Name: "wan", TLS: &TLSConfig{Mode: "acme", Domains: []string{"wan.example.com"}}}},
+		}, 1},
+		{"interface self-signed", Config{Interfaces: []Interface{{Name: "wan", TLS: &TLSConfig{Mode: "self-signed"}}}}, 0},
+		{"doh without domain", Config{DNSServer: &DNSServer{DoHServer: &DoHServerConfig{Enabled: true, UseLetsEncrypt: true}}}, 1},
+		{"consolidated doh without domain", Config{DNS: &DNS{Serve: []DNSServe{{Zone: "lan", DoHServer: &DoHServerConfig{Enabled: true, UseLetsEncrypt: true}}}}}, 1},
+		{"doh with domain", Config{DNSServer: &DNSServer{DoHServer: &DoHServerConfig{Enabled: true, UseLetsEncrypt: true, Domain: "dns.example.com"}}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := tt.cfg.validateACME(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateCaptivePortal(t *testing.T) {
+	tests := []struct {
+		name     string
+		portal   *CaptivePortalConfig
+		wantErrs int
+	}{
+		{"defaults", &CaptivePortalConfig{}, 0},
+		{"click", &CaptivePortalConfig{Auth: "click", SessionTimeout: "2h"}, 0},
+		{"voucher", &CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABCD-1234"}}, 0},
+		{"voucher without vouchers", &CaptivePortalConfig{Auth: "voucher"}, 1},
+		{"password without password", &CaptivePortalConfig{Auth: "password"}, 1},
+		{"unknown auth", &CaptivePortalConfig{Auth: "radius"}, 1},
+		{"bad session timeout", &CaptivePortalConfig{SessionTimeout: "forever"}, 1},
+		{"short session timeout", &CaptivePortalConfig{SessionTimeout: "10s"}, 1},
+		{"bad port", &CaptivePortalConfig{Port: 70000}, 1},
+		{"cert without key", &CaptivePortalConfig{TLSCert: "/etc/portal.crt"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{CaptivePortal: tt.portal}
+			if errs := cfg.validateCaptivePortal(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateSNMP(t *testing.T) {
+	v3 := []SNMPUser{{Name: "nms", AuthPassword: "authpass1", PrivPassword: "privpass1"}}
+	tests := []struct {
+		name     string
+		snmp     *SNMPConfig
+		wantErrs int
+	}{
+		{"disabled", &SNMPConfig{}, 0},
+		{"community", &SNMPConfig{Enabled: true, Community: "public"}, 0},
+		{"v3 with traps", &SNMPConfig{Enabled: true, Users: v3, Traps: []SNMPTrapTarget{{Address: "10.0.0.5", User: "nms"}, {Address: "10.0.0.6:1162", Community: "traps"}}}, 0},
+		{"no credentials", &SNMPConfig{Enabled: true}, 1},
+		{"bad listen", &SNMPConfig{Enabled: true, Community: "public", Listen: "161"}, 1},
+		{"bad engine id", &SNMPConfig{Enabled: true, Community: "public", EngineID: "abcd"}, 1},
+		{"bad enterprise oid", &SNMPConfig{Enabled: true, Community: "public", EnterpriseOID: "1.3.6.1.2.1"}, 1},
+		{"duplicate user", &SNMPConfig{Enabled: true, Users: append(v3, v3...)}, 1},
+		{"unknown protocols", &SNMPConfig{Enabled: true, Users: []SNMPUser{{Name: "nms", AuthProtocol: "sha512", AuthPassword: "authpass1", PrivProtocol: "3des"}}}, 2},
+		{"short password", &SNMPConfig{Enabled: true, Users: []SNMPUser{{Name: "nms", AuthPassword: "short"}}}, 1},
+		{"priv without auth", &SNMPConfig{Enabled: true, Users: []SNMPUser{{Name: "nms", PrivPassword: "privpass1"}}}, 1},
+		{"trap without credentials", &SNMPConfig{Enabled: true, Community: "public", Traps: []SNMPTrapTarget{{Address: "10.0.0.5"}}}, 1},
+		{"trap with unknown user", &SNMPConfig{Enabled: true, Community: "public", Traps: []SNMPTrapTarget{{Address: "10.0.0.5", User: "nobody"}}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{SNMP: tt.snmp}
+			if errs := cfg.validateSNMP(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateIPS(t *testing.T) {
+	rules := `alert tcp any any -> any 80 (msg:"test"; content:"GET"; sid:1;)`
+	inline := &RuleLearningConfig{Enabled: true, InlineMode: true}
+	tests := []struct {
+		name     string
+		ips      *IPSConfig
+		learning *RuleLearningConfig
+		wantErrs int
+	}{
+		{"disabled", &IPSConfig{}, nil, 0},
+		{"inline rules", &IPSConfig{Enabled: true, Rules: rules, Vars: map[string]string{"HOME_NET": "192.168.0.0/16"}}, nil, 0},
+		{"rule files", &IPSConfig{Enabled: true, RuleFiles: []string{"/etc/glacic/ips/*.rules"}, FailOpen: true}, inline, 0},
+		{"no rules", &IPSConfig{Enabled: true}, nil, 1},
+		{"bad queue", &IPSConfig{Enabled: true, Rules: rules, Queue: 70000}, nil, 1},
+		{"learning queue", &IPSConfig{Enabled: true, Rules: rules, Queue: 100}, inline, 1},
+		{"negative queue length", &IPSConfig{Enabled: true, Rules: rules, QueueLength: -1}, nil, 1},
+		{"bad pattern", &IPSConfig{Enabled: true, RuleFiles: []string{"/etc/[ips"}}, nil, 1},
+		{"bad variable", &IPSConfig{Enabled: true, Rules: rules, Vars: map[string]string{"$HOME_NET": "any"}}, nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{IPS: tt.ips, RuleLearning: tt.learning}
+			if errs := cfg.validateIPS(); len(errs) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.wantErrs, errs)
+			}
+		})
+	}
+
+	t.Run("inspect requires ips", func(t *testing.T) {
+		cfg := Config{
+			Zones:    []Zone{{Name: "lan"}, {Name: "wan"}},
+			Policies: []Policy{{From: "lan", To: "wan", Rules: []PolicyRule{{Name: "web", Action: "inspect"}}}},
+		}
+		if errs := cfg.validatePolicies(); len(errs) != 1 {
+			t.Errorf("got %d errors, want 1: %v", len(errs), errs)
+		}
+		cfg.IPS = &IPSConfig{Enabled: true, Rules: rules}
+		if errs := cfg.validatePolicies(); len(errs) != 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+	})
+}
+
 // TestValidationHelpers tests helper functions
 func TestValidationHelpers(t *testing.T) {
 	// isValidInterfaceName
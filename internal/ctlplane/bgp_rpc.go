@@ -0,0 +1,13 @@
+package ctlplane
+
+import "grimm.is/glacic/internal/routing/bgp"
+
+// GetBGPNeighbors returns the state of the configured BGP neighbors
+func (s *Server) GetBGPNeighbors(_ *Empty, reply *GetBGPNeighborsReply) error {
+	if s.bgp == nil {
+		reply.Neighbors = []bgp.NeighborStatus{}
+		return nil
+	}
+	reply.Neighbors = s.bgp.Neighbors()
+	return nil
+}
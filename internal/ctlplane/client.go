@@ -13,6 +13,9 @@ import (
 	"grimm.is/glacic/internal/firewall"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/learning/flowdb"
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/scanner"
 )
 
@@ -586,6 +589,44 @@ func (c *Client) GetRoutes() ([]Route, error) {
 	return reply.Routes, nil
 }
 
+// GetBGPNeighbors returns the state of the configured BGP neighbors
+func (c *Client) GetBGPNeighbors() ([]bgp.NeighborStatus, error) {
+	var reply GetBGPNeighborsReply
+	if err := c.call("Server.GetBGPNeighbors", &Empty{}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Neighbors, nil
+}
+
+// GetOSPFStatus returns the OSPF interfaces, neighbors and routes. The
+// boolean is false when OSPF is not running.
+func (c *Client) GetOSPFStatus() (*ospf.Status, bool, error) {
+	var reply GetOSPFStatusReply
+	if err := c.call("Server.GetOSPFStatus", &Empty{}, &reply); err != nil {
+		return nil, false, err
+	}
+	return &reply.Status, reply.Enabled, nil
+}
+
+// GetOSPFDatabase returns the OSPF link state databases
+func (c *Client) GetOSPFDatabase() ([]ospf.LSAStatus, error) {
+	var reply GetOSPFDatabaseReply
+	if err := c.call("Server.GetOSPFDatabase", &Empty{}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.LSAs, nil
+}
+
+// GetIPSStatus returns the IPS engine's counters and recent alerts.
+// Enabled is false when the engine is not running.
+func (c *Client) GetIPSStatus() (*GetIPSStatusReply, error) {
+	var reply GetIPSStatusReply
+	if err := c.call("Server.GetIPSStatus", &Empty{}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
 // GetNotifications returns notifications since the given ID
 func (c *Client) GetNotifications(sinceID int64) ([]Notification, int64, error) {
 	var reply GetNotificationsReply
@@ -596,6 +637,16 @@ func (c *Client) GetNotifications(sinceID int64) ([]Notification, int64, error)
 	return reply.Notifications, reply.LastID, nil
 }
 
+// GetEvents returns WebSocket-bound events since the given ID
+func (c *Client) GetEvents(sinceID int64) ([]FeedEvent, int64, error) {
+	var reply GetEventsReply
+	err := c.call("Server.GetEvents", &GetEventsArgs{SinceID: sinceID}, &reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reply.Events, reply.LastID, nil
+}
+
 // --- Learning Firewall ---
 
 // GetLearningRules returns pending rules
@@ -791,6 +842,36 @@ func (c *Client) DeleteFlow(id int64) error {
 	return nil
 }
 
+// --- DNS Query Log ---
+
+// GetDNSQueries returns logged DNS queries matching filter and the total
+// number of matches
+func (c *Client) GetDNSQueries(filter querylog.Filter) ([]querylog.Entry, int64, error) {
+	args := &GetDNSQueriesArgs{Filter: filter}
+	var reply GetDNSQueriesReply
+	err := c.call("Server.GetDNSQueries", args, &reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reply.Error != "" {
+		return nil, 0, fmt.Errorf("%s", reply.Error)
+	}
+	return reply.Queries, reply.Total, nil
+}
+
+// GetDNSStats returns DNS service counters
+func (c *Client) GetDNSStats() (*GetDNSStatsReply, error) {
+	var reply GetDNSStatsReply
+	err := c.call("Server.GetDNSStats", &Empty{}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("%s", reply.Error)
+	}
+	return &reply, nil
+}
+
 // --- Network Scanner ---
 
 // StartScanNetwork starts a network scan asynchronously
@@ -6,6 +6,9 @@ import (
 	"grimm.is/glacic/internal/firewall"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/learning/flowdb"
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/scanner"
 )
 
@@ -81,7 +84,12 @@ type ControlPlaneClient interface {
 	SystemReboot(force bool) (string, error)
 	GetSystemStats() (*SystemStats, error)
 	GetRoutes() ([]Route, error)
+	GetBGPNeighbors() ([]bgp.NeighborStatus, error)
+	GetOSPFStatus() (*ospf.Status, bool, error)
+	GetOSPFDatabase() ([]ospf.LSAStatus, error)
+	GetIPSStatus() (*GetIPSStatusReply, error)
 	GetNotifications(sinceID int64) ([]Notification, int64, error)
+	GetEvents(sinceID int64) ([]FeedEvent, int64, error)
 
 	// --- Learning Firewall ---
 	GetLearningRules(status string) ([]*learning.PendingRule, error)
@@ -105,6 +113,10 @@ type ControlPlaneClient interface {
 	DenyFlow(id int64) error
 	DeleteFlow(id int64) error
 
+	// --- DNS Query Log ---
+	GetDNSQueries(filter querylog.Filter) ([]querylog.Entry, int64, error)
+	GetDNSStats() (*GetDNSStatsReply, error)
+
 	// --- Network Scanner ---
 	StartScanNetwork(cidr string, timeoutSeconds int) error
 	GetScanStatus() (bool, *scanner.ScanResult, error)
@@ -6,6 +6,9 @@ import (
 	"grimm.is/glacic/internal/firewall"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/learning/flowdb"
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/scanner"
 
 	"github.com/stretchr/testify/mock"
@@ -368,6 +371,38 @@ func (m *MockControlPlaneClient) GetRoutes() ([]Route, error) {
 	return callArgs.Get(0).([]Route), callArgs.Error(1)
 }
 
+func (m *MockControlPlaneClient) GetBGPNeighbors() ([]bgp.NeighborStatus, error) {
+	callArgs := m.Called()
+	if callArgs.Get(0) == nil {
+		return nil, callArgs.Error(1)
+	}
+	return callArgs.Get(0).([]bgp.NeighborStatus), callArgs.Error(1)
+}
+
+func (m *MockControlPlaneClient) GetOSPFStatus() (*ospf.Status, bool, error) {
+	callArgs := m.Called()
+	if callArgs.Get(0) == nil {
+		return nil, false, callArgs.Error(2)
+	}
+	return callArgs.Get(0).(*ospf.Status), callArgs.Bool(1), callArgs.Error(2)
+}
+
+func (m *MockControlPlaneClient) GetOSPFDatabase() ([]ospf.LSAStatus, error) {
+	callArgs := m.Called()
+	if callArgs.Get(0) == nil {
+		return nil, callArgs.Error(1)
+	}
+	return callArgs.Get(0).([]ospf.LSAStatus), callArgs.Error(1)
+}
+
+func (m *MockControlPlaneClient) GetIPSStatus() (*GetIPSStatusReply, error) {
+	callArgs := m.Called()
+	if callArgs.Get(0) == nil {
+		return nil, callArgs.Error(1)
+	}
+	return callArgs.Get(0).(*GetIPSStatusReply), callArgs.Error(1)
+}
+
 // --- Device Identity Management ---
 
 func (m *MockControlPlaneClient) UpdateDeviceIdentity(args *UpdateDeviceIdentityArgs) (*device.DeviceIdentity, error) {
@@ -392,6 +427,14 @@ func (m *MockControlPlaneClient) GetNotifications(sinceID int64) ([]Notification
 	return callArgs.Get(0).([]Notification), callArgs.Get(1).(int64), callArgs.Error(2)
 }
 
+func (m *MockControlPlaneClient) GetEvents(sinceID int64) ([]FeedEvent, int64, error) {
+	callArgs := m.Called(sinceID)
+	if callArgs.Get(0) == nil {
+		return nil, 0, callArgs.Error(2)
+	}
+	return callArgs.Get(0).([]FeedEvent), callArgs.Get(1).(int64), callArgs.Error(2)
+}
+
 func (m *MockControlPlaneClient) GetLearningRules(status string) ([]*learning.PendingRule, error) {
 	callArgs := m.Called(status)
 	if callArgs.Get(0) == nil {
@@ -489,6 +532,23 @@ func (m *MockControlPlaneClient) GetFlows(state string, limit, offset int) ([]fl
 	return flows, counts, callArgs.Error(2)
 }
 
+func (m *MockControlPlaneClient) GetDNSQueries(filter querylog.Filter) ([]querylog.Entry, int64, error) {
+	callArgs := m.Called(filter)
+	var entries []querylog.Entry
+	if callArgs.Get(0) != nil {
+		entries = callArgs.Get(0).([]querylog.Entry)
+	}
+	return entries, callArgs.Get(1).(int64), callArgs.Error(2)
+}
+
+func (m *MockControlPlaneClient) GetDNSStats() (*GetDNSStatsReply, error) {
+	callArgs := m.Called()
+	if callArgs.Get(0) == nil {
+		return nil, callArgs.Error(1)
+	}
+	return callArgs.Get(0).(*GetDNSStatsReply), callArgs.Error(1)
+}
+
 func (m *MockControlPlaneClient) ApproveFlow(id int64) error {
 	return m.Called(id).Error(0)
 }
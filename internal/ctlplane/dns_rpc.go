@@ -0,0 +1,30 @@
+package ctlplane
+
+// GetDNSQueries returns logged DNS queries matching the filter, newest first
+func (s *Server) GetDNSQueries(args *GetDNSQueriesArgs, reply *GetDNSQueriesReply) error {
+	if s.dnsQueryLog == nil {
+		reply.Error = "DNS query log not initialized"
+		return nil
+	}
+
+	entries, total, err := s.dnsQueryLog.Query(args.Filter)
+	if err != nil {
+		reply.Error = err.Error()
+		return nil
+	}
+
+	reply.Queries = entries
+	reply.Total = total
+	return nil
+}
+
+// GetDNSStats returns DNS service counters
+func (s *Server) GetDNSStats(args *Empty, reply *GetDNSStatsReply) error {
+	if s.dnsService == nil {
+		reply.Error = "DNS service not initialized"
+		return nil
+	}
+
+	reply.RateLimit = s.dnsService.RateLimitStats()
+	return nil
+}
@@ -0,0 +1,89 @@
+package ctlplane
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// FeedEvent is an event bus message queued for the API's WebSocket topics.
+// Data is pre-encoded so arbitrary payloads survive the RPC boundary.
+type FeedEvent struct {
+	ID    int64           `json:"id"`
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"`
+	Time  time.Time       `json:"time"`
+}
+
+// EventFeed is a ring buffer of WebSocket-bound events. The event hub
+// lives in the control plane while the WebSocket clients are attached to
+// the API process, which polls the feed by ID like notifications.
+type EventFeed struct {
+	mu      sync.RWMutex
+	events  []FeedEvent
+	nextID  int64
+	maxSize int
+}
+
+// NewEventFeed creates a new event feed with the given max size
+func NewEventFeed(maxSize int) *EventFeed {
+	if maxSize <= 0 {
+		maxSize = 256
+	}
+	return &EventFeed{
+		events:  make([]FeedEvent, 0, maxSize),
+		maxSize: maxSize,
+		nextID:  1,
+	}
+}
+
+// Publish queues data for the given topic. Its signature matches the
+// publisher expected by events.NewWSBridge.
+func (f *EventFeed) Publish(topic string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := FeedEvent{
+		ID:    f.nextID,
+		Topic: topic,
+		Data:  raw,
+		Time:  time.Now(),
+	}
+	f.nextID++
+
+	// Ring buffer: remove oldest if at capacity
+	if len(f.events) >= f.maxSize {
+		f.events = f.events[1:]
+	}
+	f.events = append(f.events, e)
+}
+
+// GetSince returns all events with ID greater than the given ID
+func (f *EventFeed) GetSince(sinceID int64) []FeedEvent {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var result []FeedEvent
+	for _, e := range f.events {
+		if e.ID > sinceID {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// LastID returns the ID of the most recent event (0 if none)
+func (f *EventFeed) LastID() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.events) == 0 {
+		return 0
+	}
+	return f.events[len(f.events)-1].ID
+}
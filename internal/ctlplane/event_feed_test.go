@@ -0,0 +1,35 @@
+package ctlplane
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventFeed_GetSince(t *testing.T) {
+	feed := NewEventFeed(2)
+
+	feed.Publish("dns", map[string]string{"domain": "first.example"})
+	feed.Publish("dns", map[string]string{"domain": "second.example"})
+	feed.Publish("ips", map[string]int{"sid": 1000001})
+
+	// Oldest entry was evicted
+	all := feed.GetSince(0)
+	if len(all) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(all))
+	}
+	if all[0].ID != 2 || all[1].ID != 3 {
+		t.Errorf("expected IDs 2,3, got %d,%d", all[0].ID, all[1].ID)
+	}
+	if all[1].Topic != "ips" {
+		t.Errorf("expected topic ips, got %s", all[1].Topic)
+	}
+
+	var data map[string]int
+	if err := json.Unmarshal(all[1].Data, &data); err != nil || data["sid"] != 1000001 {
+		t.Errorf("unexpected payload %s (%v)", all[1].Data, err)
+	}
+
+	if since := feed.GetSince(feed.LastID()); len(since) != 0 {
+		t.Errorf("expected no events after last ID, got %d", len(since))
+	}
+}
@@ -0,0 +1,22 @@
+package ctlplane
+
+import "grimm.is/glacic/internal/ips"
+
+// GetIPSStatusReply is the response for GetIPSStatus
+type GetIPSStatusReply struct {
+	Enabled bool        `json:"enabled"`
+	Stats   ips.Stats   `json:"stats"`
+	Alerts  []ips.Alert `json:"alerts"` // Oldest first
+}
+
+// GetIPSStatus returns the IPS engine's counters and most recent alerts
+func (s *Server) GetIPSStatus(_ *Empty, reply *GetIPSStatusReply) error {
+	if s.ips == nil {
+		reply.Alerts = []ips.Alert{}
+		return nil
+	}
+	reply.Enabled = true
+	reply.Stats = s.ips.Stats()
+	reply.Alerts = s.ips.Alerts()
+	return nil
+}
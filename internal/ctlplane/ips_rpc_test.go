@@ -0,0 +1,39 @@
+package ctlplane
+
+import (
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/ips"
+	"grimm.is/glacic/internal/logging"
+)
+
+func TestServer_GetIPSStatus(t *testing.T) {
+	s := &Server{}
+
+	var reply GetIPSStatusReply
+	if err := s.GetIPSStatus(&Empty{}, &reply); err != nil {
+		t.Fatalf("GetIPSStatus failed: %v", err)
+	}
+	if reply.Enabled || reply.Alerts == nil {
+		t.Errorf("expected disabled status with empty alerts, got %+v", reply)
+	}
+
+	cfg := &config.IPSConfig{
+		Enabled: true,
+		Rules:   `alert tcp any any -> any 80 (msg:"Test"; content:"x"; sid:1000001;)`,
+	}
+	engine := ips.NewEngine(cfg, logging.WithComponent("ips"))
+	if err := engine.Load(cfg); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	s.SetIPS(engine)
+
+	reply = GetIPSStatusReply{}
+	if err := s.GetIPSStatus(&Empty{}, &reply); err != nil {
+		t.Fatalf("GetIPSStatus failed: %v", err)
+	}
+	if !reply.Enabled || reply.Stats.Rules != 1 {
+		t.Errorf("expected enabled engine with 1 rule, got %+v", reply)
+	}
+}
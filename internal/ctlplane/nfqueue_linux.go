@@ -19,19 +19,25 @@ import (
 // Unlike NFLogReader (async), this holds packets until a verdict is returned,
 // enabling "first packet" acceptance in learning mode.
 type NFQueueReader struct {
-	queue       *nfqueue.Nfqueue
-	group       uint16
-	maxQueueLen uint32
-	running     bool
-	cancel      context.CancelFunc
-	ctx         context.Context
-	mu          sync.RWMutex
+	queue        *nfqueue.Nfqueue
+	group        uint16
+	maxQueueLen  uint32
+	maxPacketLen uint32
+	failOpen     bool
+	running      bool
+	cancel       context.CancelFunc
+	ctx          context.Context
+	mu           sync.RWMutex
 
 	// VerdictFunc is called for each packet to determine accept/drop.
 	// The function should return true for ACCEPT, false for DROP.
 	// This is called from the packet processing goroutine, so it must be fast.
 	VerdictFunc func(entry NFLogEntry) bool
 
+	// PacketVerdictFunc is like VerdictFunc but also receives the packet,
+	// starting at the IP header. It takes precedence over VerdictFunc.
+	PacketVerdictFunc func(entry NFLogEntry, packet []byte) bool
+
 	// Stats
 	packetsProcessed uint64
 	packetsAccepted  uint64
@@ -44,29 +50,57 @@ type NFQueueReader struct {
 // queueNum should match the nftables "queue num X" rule.
 func NewNFQueueReader(queueNum uint16) *NFQueueReader {
 	return &NFQueueReader{
-		group:       queueNum,
-		maxQueueLen: 1024, // Reasonable default
+		group:        queueNum,
+		maxQueueLen:  1024, // Reasonable default
+		maxPacketLen: 256,  // Only need headers for flow identification
 	}
 }
 
+// SetQueueLength sets how many packets the kernel holds for the reader.
+// This must be set before calling Start().
+func (r *NFQueueReader) SetQueueLength(n uint32) {
+	r.maxQueueLen = n
+}
+
+// SetMaxPacketLen sets how many bytes of each packet are copied to
+// userspace. This must be set before calling Start().
+func (r *NFQueueReader) SetMaxPacketLen(n uint32) {
+	r.maxPacketLen = n
+}
+
+// SetFailOpen makes the kernel accept packets when the queue is full
+// instead of dropping them. This must be set before calling Start().
+func (r *NFQueueReader) SetFailOpen(failOpen bool) {
+	r.failOpen = failOpen
+}
+
 // SetVerdictFunc sets the callback that determines packet fate.
 // This must be set before calling Start().
 func (r *NFQueueReader) SetVerdictFunc(fn func(entry NFLogEntry) bool) {
 	r.VerdictFunc = fn
 }
 
+// SetPacketVerdictFunc sets a callback that determines packet fate from
+// the packet contents. This must be set before calling Start().
+func (r *NFQueueReader) SetPacketVerdictFunc(fn func(entry NFLogEntry, packet []byte) bool) {
+	r.PacketVerdictFunc = fn
+}
+
 // Start begins listening for packets on the queue.
 func (r *NFQueueReader) Start() error {
-	if r.VerdictFunc == nil {
+	if r.VerdictFunc == nil && r.PacketVerdictFunc == nil {
 		return fmt.Errorf("VerdictFunc must be set before starting NFQueueReader")
 	}
 
 	config := nfqueue.Config{
 		NfQueue:      r.group,
-		MaxPacketLen: 256, // Only need headers for flow identification
+		MaxPacketLen: r.maxPacketLen,
 		MaxQueueLen:  r.maxQueueLen,
 		Copymode:     nfqueue.NfQnlCopyPacket,
 	}
+	if r.failOpen {
+		config.Flags = nfqueue.NfQaCfgFlagFailOpen
+	}
 
 	nf, err := nfqueue.Open(&config)
 	if err != nil {
@@ -111,7 +145,16 @@ func (r *NFQueueReader) handlePacket(attrs nfqueue.Attribute) {
 	entry := r.parseAttributes(attrs)
 
 	// Get verdict from callback
-	accept := r.VerdictFunc(entry)
+	var accept bool
+	if r.PacketVerdictFunc != nil {
+		var packet []byte
+		if attrs.Payload != nil {
+			packet = *attrs.Payload
+		}
+		accept = r.PacketVerdictFunc(entry, packet)
+	} else {
+		accept = r.VerdictFunc(entry)
+	}
 
 	// Set verdict
 	packetID := *attrs.PacketID
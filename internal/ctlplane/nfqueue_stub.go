@@ -6,7 +6,8 @@ import "fmt"
 
 // NFQueueReader is a stub for non-Linux systems.
 type NFQueueReader struct {
-	VerdictFunc func(entry NFLogEntry) bool
+	VerdictFunc       func(entry NFLogEntry) bool
+	PacketVerdictFunc func(entry NFLogEntry, packet []byte) bool
 }
 
 // NewNFQueueReader creates a stub reader.
@@ -19,6 +20,20 @@ func (r *NFQueueReader) SetVerdictFunc(fn func(entry NFLogEntry) bool) {
 	r.VerdictFunc = fn
 }
 
+// SetPacketVerdictFunc is a no-op on non-Linux.
+func (r *NFQueueReader) SetPacketVerdictFunc(fn func(entry NFLogEntry, packet []byte) bool) {
+	r.PacketVerdictFunc = fn
+}
+
+// SetQueueLength is a no-op on non-Linux.
+func (r *NFQueueReader) SetQueueLength(n uint32) {}
+
+// SetMaxPacketLen is a no-op on non-Linux.
+func (r *NFQueueReader) SetMaxPacketLen(n uint32) {}
+
+// SetFailOpen is a no-op on non-Linux.
+func (r *NFQueueReader) SetFailOpen(failOpen bool) {}
+
 // Start returns an error on non-Linux systems.
 func (r *NFQueueReader) Start() error {
 	return fmt.Errorf("nfqueue is only supported on Linux")
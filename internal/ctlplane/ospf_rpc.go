@@ -0,0 +1,28 @@
+package ctlplane
+
+import "grimm.is/glacic/internal/routing/ospf"
+
+// GetOSPFStatus returns the OSPF interfaces, neighbors and routes
+func (s *Server) GetOSPFStatus(_ *Empty, reply *GetOSPFStatusReply) error {
+	if s.ospf == nil {
+		reply.Status = ospf.Status{
+			Interfaces: []ospf.InterfaceStatus{},
+			Neighbors:  []ospf.NeighborStatus{},
+			Routes:     []ospf.RouteStatus{},
+		}
+		return nil
+	}
+	reply.Enabled = true
+	reply.Status = s.ospf.Status()
+	return nil
+}
+
+// GetOSPFDatabase returns the OSPF link state databases
+func (s *Server) GetOSPFDatabase(_ *Empty, reply *GetOSPFDatabaseReply) error {
+	if s.ospf == nil {
+		reply.LSAs = []ospf.LSAStatus{}
+		return nil
+	}
+	reply.LSAs = s.ospf.Database()
+	return nil
+}
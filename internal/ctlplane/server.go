@@ -24,13 +24,19 @@ import (
 	"grimm.is/glacic/internal/config"
 	"grimm.is/glacic/internal/device"
 	"grimm.is/glacic/internal/firewall"
+	"grimm.is/glacic/internal/ips"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/logging"
 	"grimm.is/glacic/internal/network"
+	"grimm.is/glacic/internal/network/vrrp"
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
 	"grimm.is/glacic/internal/scheduler"
 	"grimm.is/glacic/internal/services"
 	"grimm.is/glacic/internal/services/dhcp"
 	"grimm.is/glacic/internal/services/discovery"
+	"grimm.is/glacic/internal/services/dns"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/lldp"
 	"grimm.is/glacic/internal/services/scanner"
 	"grimm.is/glacic/internal/state"
@@ -52,6 +58,11 @@ type Server struct {
 	// Service references
 	stateStore state.Store
 	upgradeMgr *upgrade.Manager
+	replicator *state.Replicator
+	vrrp       *vrrp.Manager
+	bgp        *bgp.Speaker
+	ospf       *ospf.Router
+	ips        *ips.Engine
 
 	// Sub-managers
 	networkManager      *NetworkManager
@@ -69,11 +80,16 @@ type Server struct {
 	deviceManager       *device.Manager
 	scannerService      *scanner.Scanner
 	deviceCollector     *discovery.Collector
+	dnsService          *dns.Service
+	dnsQueryLog         *querylog.Store
 	netLib              network.NetworkManager // Injected network library
 
 	// Notification hub for broadcasting to all consumers
 	notifyHub *NotificationHub
 
+	// Event bus messages bound for the API's WebSocket topics
+	eventFeed *EventFeed
+
 	// Disarm hook to stop monitors (watchdog, auto-restart) in the main process
 	disarmFunc func()
 
@@ -135,6 +151,7 @@ func NewServer(cfg *config.Config, configFile string, netLib network.NetworkMana
 		uplinkManager:       network.NewUplinkManager(),
 		scannerService:      scanner.New(logging.WithComponent("scanner"), scanner.Config{Timeout: 5 * time.Second, Concurrency: 50}),
 		notifyHub:           NewNotificationHub(100),
+		eventFeed:           NewEventFeed(256),
 		scheduler:           scheduler.New(logging.WithComponent("scheduler")),
 	}
 
@@ -167,6 +184,31 @@ func (s *Server) SetStateStore(store state.Store) {
 	s.stateStore = store
 }
 
+// SetReplicator injects the HA state replicator
+func (s *Server) SetReplicator(r *state.Replicator) {
+	s.replicator = r
+}
+
+// SetVRRP injects the VRRP manager
+func (s *Server) SetVRRP(m *vrrp.Manager) {
+	s.vrrp = m
+}
+
+// SetBGP injects the native BGP speaker
+func (s *Server) SetBGP(sp *bgp.Speaker) {
+	s.bgp = sp
+}
+
+// SetOSPF injects the native OSPF router
+func (s *Server) SetOSPF(r *ospf.Router) {
+	s.ospf = r
+}
+
+// SetIPS injects the intrusion prevention engine
+func (s *Server) SetIPS(e *ips.Engine) {
+	s.ips = e
+}
+
 // SetUpgradeManager injects the upgrade manager
 func (s *Server) SetUpgradeManager(mgr *upgrade.Manager) {
 	s.upgradeMgr = mgr
@@ -338,6 +380,13 @@ func (s *Server) Notify(ntype NotificationType, title, message string) {
 	}
 }
 
+// PublishEvent queues an event for the API's WebSocket clients
+func (s *Server) PublishEvent(topic string, data any) {
+	if s.eventFeed != nil {
+		s.eventFeed.Publish(topic, data)
+	}
+}
+
 // SetDeviceManager injects the device manager
 func (s *Server) SetDeviceManager(mgr *device.Manager) {
 	s.deviceManager = mgr
@@ -348,6 +397,16 @@ func (s *Server) SetDeviceCollector(collector *discovery.Collector) {
 	s.deviceCollector = collector
 }
 
+// SetDNSService injects the DNS service
+func (s *Server) SetDNSService(svc *dns.Service) {
+	s.dnsService = svc
+}
+
+// SetDNSQueryLog injects the DNS query log store
+func (s *Server) SetDNSQueryLog(store *querylog.Store) {
+	s.dnsQueryLog = store
+}
+
 // GetNotifications returns notifications since a given ID (RPC method)
 func (s *Server) GetNotifications(args *GetNotificationsArgs, reply *GetNotificationsReply) error {
 	if s.notifyHub == nil {
@@ -360,6 +419,18 @@ func (s *Server) GetNotifications(args *GetNotificationsArgs, reply *GetNotifica
 	return nil
 }
 
+// GetEvents returns WebSocket-bound events since a given ID (RPC method)
+func (s *Server) GetEvents(args *GetEventsArgs, reply *GetEventsReply) error {
+	if s.eventFeed == nil {
+		reply.Events = []FeedEvent{}
+		return nil
+	}
+
+	reply.Events = s.eventFeed.GetSince(args.SinceID)
+	reply.LastID = s.eventFeed.LastID()
+	return nil
+}
+
 // GetConfigDiff returns the config diff
 func (s *Server) GetConfigDiff(args *Empty, reply *GetConfigDiffReply) error {
 	s.mu.RLock()
@@ -376,6 +447,13 @@ func (s *Server) GetConfigDiff(args *Empty, reply *GetConfigDiffReply) error {
 // GetStatus returns the current system status
 func (s *Server) GetStatus(args *Empty, reply *GetStatusReply) error {
 	reply.Status = s.systemManager.GetStatus()
+	if s.replicator != nil {
+		rs := s.replicator.Status()
+		reply.Status.Replication = &rs
+	}
+	if s.vrrp != nil {
+		reply.Status.VRRP = s.vrrp.Status()
+	}
 	return nil
 }
 
@@ -552,6 +630,7 @@ func (s *Server) GetDHCPLeases(args *Empty, reply *GetDHCPLeasesReply) error {
 			// ObtainedAt: lease.LeaseStart, // Need to add LeaseStart to Lease struct?
 			ExpiresAt: lease.Expiration,
 			Hostname:  lease.Hostname,
+			DUID:      lease.DUID,
 		})
 	}
 	return nil
@@ -976,7 +1055,10 @@ func (s *Server) reloadConfigInternal(newCfg *config.Config) error {
 	}
 	s.netLib.SetupLoopback()
 
-	// Re-apply interfaces
+	// Re-apply interfaces, deleting bridges and tunnels no longer configured
+	if err := s.netLib.RemoveStaleInterfaces(newCfg.Interfaces); err != nil {
+		log.Printf("[CTL] Error removing stale interfaces: %v", err)
+	}
 	for _, iface := range newCfg.Interfaces {
 		if err := s.netLib.ApplyInterface(iface); err != nil {
 			log.Printf("[CTL] Error applying interface %s: %v", iface.Name, err)
@@ -1886,16 +1968,11 @@ func (s *Server) GetListener() net.Listener {
 
 // startScheduler initializes the task scheduler and registers scheduled tasks.
 func (s *Server) startScheduler() error {
-	// Only start scheduler if enabled in config
 	s.mu.RLock()
 	schedulerConfig := s.config.Scheduler
+	geoipConfig := s.config.GeoIP
 	s.mu.RUnlock()
 
-	if schedulerConfig == nil || !schedulerConfig.Enabled {
-		log.Printf("[CTL] Scheduler disabled in configuration")
-		return nil
-	}
-
 	// Create task registry with function bindings
 	registry := &scheduler.TaskRegistry{
 		ConfigPath:  s.configFile,
@@ -1908,6 +1985,23 @@ func (s *Server) startScheduler() error {
 		RefreshDNS: func() error {
 			return s.refreshDNSBlocklists()
 		},
+		RefreshGeoIP: func() error {
+			return s.refreshGeoIP()
+		},
+	}
+
+	// GeoIP auto-update is requested by the geoip block itself, so it does
+	// not depend on the scheduler block
+	if geoipConfig != nil && geoipConfig.Enabled && geoipConfig.AutoUpdate {
+		task := scheduler.NewGeoIPUpdateTask(registry, geoipCheckInterval)
+		s.scheduler.AddTask(task)
+		log.Printf("[CTL] Registered GeoIP update task (interval: %v)", geoipCheckInterval)
+	}
+
+	// Only register maintenance tasks if the scheduler is enabled in config
+	if schedulerConfig == nil || !schedulerConfig.Enabled {
+		log.Printf("[CTL] Scheduler disabled in configuration")
+		return nil
 	}
 
 	// Register IPSet update task
@@ -1986,6 +2080,18 @@ func (s *Server) applyConfig(cfg *config.Config) error {
 	return nil
 }
 
+// geoipCheckInterval is how often the GeoIP database is checked for updates.
+// Checking is a stat; the database is only walked again when it changed.
+const geoipCheckInterval = time.Hour
+
+// refreshGeoIP reloads an updated GeoIP database into the firewall's country sets.
+func (s *Server) refreshGeoIP() error {
+	if s.firewallManager == nil {
+		return fmt.Errorf("firewall manager not initialized")
+	}
+	return s.firewallManager.RefreshGeoIP()
+}
+
 // refreshIPSets refreshes all IPSets from their configured sources.
 func (s *Server) refreshIPSets() error {
 	if s.ipsetService == nil {
@@ -71,6 +71,10 @@ func (s *Server) GetRoutes(_ *Empty, reply *GetRoutesReply) error {
 			proto = "boot"
 		case syscall.RTPROT_STATIC:
 			proto = "static"
+		case 186: // RTPROT_BGP, installed by the native BGP speaker
+			proto = "bgp"
+		case 188: // RTPROT_OSPF, installed by the native OSPF router
+			proto = "ospf"
 		case 11: // RTPROT_DHCP (commonly 11 or 16 depending on client, usually implicit)
 			// proto = "dhcp" (Not standard constant)
 		}
@@ -298,3 +298,6 @@ func (m *MockNetLib) WaitForLinkIP(ifaceName string, timeoutSeconds int) error {
 }
 func (m *MockNetLib) GetDHCPLeases() map[string]network.LeaseInfo     { return nil }
 func (m *MockNetLib) ApplyUIDRoutes(routes []config.UIDRouting) error { return nil }
+func (m *MockNetLib) RemoveStaleInterfaces(interfaces []config.Interface) error {
+	return nil
+}
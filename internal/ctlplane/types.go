@@ -6,7 +6,7 @@
 // This file contains RPC request/response types organized by domain:
 //
 // ## Status Types
-//   - [Status]: System status (running, uptime, safe mode)
+//   - [Status]: System status (running, uptime, safe mode, replication, VRRP)
 //   - [ServiceStatus]: Individual service status (DHCP, DNS)
 //   - [InterfaceStatus]: Full interface details with stats
 //   - [InterfaceState]: Interface state enum (up, down, no_carrier)
@@ -27,6 +27,8 @@
 //   - [DNSSettings]: DNS server configuration
 //   - [DNSRecord]: Local DNS records
 //   - [SplitHorizon*]: Split-horizon DNS types
+//   - [GetDNSQueriesArgs], [GetDNSQueriesReply]: Per-client query log
+//   - [GetDNSStatsReply]: Rate limiting counters
 //
 // ## Firewall
 //   - [ZoneInfo]: Zone configuration with interfaces
@@ -65,7 +67,11 @@ import (
 	"grimm.is/glacic/internal/firewall"
 	"grimm.is/glacic/internal/learning"
 	"grimm.is/glacic/internal/learning/flowdb"
+	"grimm.is/glacic/internal/network/vrrp"
+	"grimm.is/glacic/internal/services/dns"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/services/scanner"
+	"grimm.is/glacic/internal/state"
 )
 
 // GetSocketPath returns the path to the control plane socket.
@@ -86,6 +92,9 @@ type Status struct {
 	FirewallActive  bool   `json:"firewall_active"`            // Whether firewall rules are applied
 	FirewallApplied string `json:"firewall_applied,omitempty"` // Timestamp of last rule application
 	SafeMode        bool   `json:"safe_mode"`                  // True if system is in safe mode
+
+	Replication *state.ReplicationStatus `json:"replication,omitempty"` // HA replication health (nil when not configured)
+	VRRP        []vrrp.InstanceStatus    `json:"vrrp,omitempty"`        // Virtual router states
 }
 
 // InterfaceState represents the operational state of a network interface
@@ -234,6 +243,7 @@ type DHCPLease struct {
 	LeaseTime  string    `json:"lease_time"`
 	ObtainedAt time.Time `json:"obtained_at"`
 	ExpiresAt  time.Time `json:"expires_at"`
+	DUID       string    `json:"duid,omitempty"` // DHCPv6 client DUID
 
 	// Enriched Data
 	Hostname string   `json:"hostname,omitempty"`
@@ -834,6 +844,17 @@ type GetNotificationsReply struct {
 	LastID        int64          `json:"last_id"` // Latest notification ID for cursoring
 }
 
+// GetEventsArgs is the request for GetEvents
+type GetEventsArgs struct {
+	SinceID int64 `json:"since_id"` // Return events with ID > SinceID
+}
+
+// GetEventsReply is the response for GetEvents
+type GetEventsReply struct {
+	Events []FeedEvent `json:"events"`
+	LastID int64       `json:"last_id"` // Latest event ID for cursoring
+}
+
 // --- Learning Firewall ---
 
 // GetLearningRulesArgs is the request for GetLearningRules
@@ -968,6 +989,26 @@ type FlowActionReply struct {
 	Error   string `json:"error,omitempty"`
 }
 
+// --- DNS Query Log ---
+
+// GetDNSQueriesArgs is the request for GetDNSQueries
+type GetDNSQueriesArgs struct {
+	Filter querylog.Filter `json:"filter"`
+}
+
+// GetDNSQueriesReply is the response for GetDNSQueries
+type GetDNSQueriesReply struct {
+	Queries []querylog.Entry `json:"queries"`
+	Total   int64            `json:"total"` // Matches before limit/offset
+	Error   string           `json:"error,omitempty"`
+}
+
+// GetDNSStatsReply is the response for GetDNSStats
+type GetDNSStatsReply struct {
+	RateLimit dns.RateLimitStats `json:"rate_limit"`
+	Error     string             `json:"error,omitempty"`
+}
+
 // --- Topology / Discovery ---
 
 // TopologyNeighbor represents a discovered neighbor device
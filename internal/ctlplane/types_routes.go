@@ -1,5 +1,10 @@
 package ctlplane
 
+import (
+	"grimm.is/glacic/internal/routing/bgp"
+	"grimm.is/glacic/internal/routing/ospf"
+)
+
 // --- Routing ---
 
 // Route represents a kernel routing table entry
@@ -19,3 +24,19 @@ type GetRoutesReply struct {
 	Routes []Route `json:"routes"`
 	Error  string  `json:"error,omitempty"`
 }
+
+// GetBGPNeighborsReply is the response for GetBGPNeighbors
+type GetBGPNeighborsReply struct {
+	Neighbors []bgp.NeighborStatus `json:"neighbors"`
+}
+
+// GetOSPFStatusReply is the response for GetOSPFStatus
+type GetOSPFStatusReply struct {
+	Enabled bool `json:"enabled"`
+	ospf.Status
+}
+
+// GetOSPFDatabaseReply is the response for GetOSPFDatabase
+type GetOSPFDatabaseReply struct {
+	LSAs []ospf.LSAStatus `json:"lsas"`
+}
@@ -90,6 +90,22 @@ func (u *UnifiedLookup) FindByIP(ip string) (name string, matchType string, foun
 	return "", "", false
 }
 
+// LookupMAC returns the MAC address last seen with ip, or "" if unknown.
+func (u *UnifiedLookup) LookupMAC(ip string) string {
+	return u.lookupMAC(ip)
+}
+
+// LookupTags returns the tags of the identity linked to mac, if any.
+func (u *UnifiedLookup) LookupTags(mac string) []string {
+	if u.manager == nil || mac == "" {
+		return nil
+	}
+	if info := u.manager.GetDevice(mac); info.Device != nil {
+		return info.Device.Tags
+	}
+	return nil
+}
+
 // lookupMAC finds the MAC address for an IP by checking various sources.
 func (u *UnifiedLookup) lookupMAC(ip string) string {
 	// Check cache first (refreshed every 30s)
@@ -150,6 +150,8 @@ func (b *WSBridge) Start() {
 		EventNFTCounter,
 		EventFlowNew,
 		EventFlowApproved,
+		EventDNSSEC,
+		EventIPSAlert,
 	)
 
 	go func() {
@@ -184,6 +186,10 @@ func eventTypeToWSTopic(t EventType) string {
 		return "stats"
 	case EventFlowNew, EventFlowApproved:
 		return "learning"
+	case EventDNSSEC:
+		return "dns"
+	case EventIPSAlert:
+		return "ips"
 	default:
 		return ""
 	}
@@ -124,6 +124,8 @@ func TestWSBridge_TopicMapping(t *testing.T) {
 		{EventDeviceSeen, "devices"},
 		{EventNFTCounter, "stats"},
 		{EventFlowNew, "learning"},
+		{EventDNSSEC, "dns"},
+		{EventIPSAlert, "ips"},
 		{EventDNSQuery, ""}, // Not mapped
 	}
 
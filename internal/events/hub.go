@@ -138,6 +138,20 @@ func (h *Hub) EmitDHCPLease(mac, ip, hostname, vendor string) {
 	})
 }
 
+// EmitDNSSECFailure publishes a DNSSEC validation failure.
+func (h *Hub) EmitDNSSECFailure(domain, qtype, upstream, reason string) {
+	h.Publish(Event{
+		Type:   EventDNSSEC,
+		Source: "dns",
+		Data: DNSSECData{
+			Domain:   domain,
+			Type:     qtype,
+			Upstream: upstream,
+			Reason:   reason,
+		},
+	})
+}
+
 // EmitNFTCounter publishes a firewall counter update.
 func (h *Hub) EmitNFTCounter(ruleID string, packets, bytes uint64) {
 	h.Publish(Event{
@@ -166,3 +180,12 @@ func (h *Hub) EmitDeviceSeen(mac, ip, hostname, vendor, iface, method string) {
 		},
 	})
 }
+
+// EmitIPSAlert publishes an intrusion prevention alert.
+func (h *Hub) EmitIPSAlert(alert IPSAlertData) {
+	h.Publish(Event{
+		Type:   EventIPSAlert,
+		Source: "ips",
+		Data:   alert,
+	})
+}
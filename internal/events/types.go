@@ -16,6 +16,7 @@ const (
 	// DNS events
 	EventDNSQuery EventType = "dns.query"
 	EventDNSBlock EventType = "dns.block"
+	EventDNSSEC   EventType = "dns.dnssec" // Validation failure (bogus answer)
 
 	// NFT (firewall) events
 	EventNFTMatch   EventType = "nft.match"   // Log match (from nflog)
@@ -30,6 +31,9 @@ const (
 	EventFlowNew      EventType = "flow.new"
 	EventFlowApproved EventType = "flow.approved"
 	EventFlowBlocked  EventType = "flow.blocked"
+
+	// Intrusion prevention events
+	EventIPSAlert EventType = "ips.alert"
 )
 
 // Event is the core message passed through the event bus.
@@ -61,6 +65,14 @@ type DNSQueryData struct {
 	Reason   string `json:"reason,omitempty"` // Block reason if blocked
 }
 
+// DNSSECData is the payload for EventDNSSEC.
+type DNSSECData struct {
+	Domain   string `json:"domain"`
+	Type     string `json:"type"`
+	Upstream string `json:"upstream,omitempty"`
+	Reason   string `json:"reason"`
+}
+
 // NFTMatchData is the payload for EventNFTMatch (from nflog).
 type NFTMatchData struct {
 	RuleID   string `json:"rule_id"`
@@ -101,3 +113,19 @@ type FlowData struct {
 	SNI       string `json:"sni,omitempty"`       // TLS Server Name
 	Encrypted bool   `json:"encrypted,omitempty"` // Was TLS?
 }
+
+// IPSAlertData is the payload for EventIPSAlert.
+type IPSAlertData struct {
+	SID       int    `json:"sid"`
+	Rev       int    `json:"rev,omitempty"`
+	Msg       string `json:"msg"`
+	Classtype string `json:"classtype,omitempty"`
+	Priority  int    `json:"priority,omitempty"`
+	Action    string `json:"action"` // "alert", "drop"
+	Dropped   bool   `json:"dropped"`
+	SrcIP     string `json:"src_ip"`
+	DstIP     string `json:"dst_ip"`
+	SrcPort   uint16 `json:"src_port,omitempty"`
+	DstPort   uint16 `json:"dst_port,omitempty"`
+	Protocol  string `json:"protocol"`
+}
@@ -0,0 +1,90 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// Sets of clients that have logged in to the captive portal. Entries carry
+// the session timeout, so access ends without the portal's involvement.
+// They are never flushed, so sessions survive ruleset reloads.
+const (
+	portalMACSet = "portal_allowed_mac"
+	portalV4Set  = "portal_allowed_v4"
+	portalV6Set  = "portal_allowed_v6"
+)
+
+// addCaptivePortalRules confines unauthenticated clients of captive portal
+// zones: their HTTP goes to the portal, everything else they forward is
+// rejected. Clients are authenticated by MAC or by address.
+func addCaptivePortalRules(sb *ScriptBuilder, cfg *Config) {
+	ifaces := config.CaptivePortalInterfaces(cfg.Zones, cfg.Interfaces)
+	if len(ifaces) == 0 {
+		return
+	}
+	quoted := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		quoted[i] = forceQuote(iface)
+	}
+	ifaceSet := fmt.Sprintf("{ %s }", strings.Join(quoted, ", "))
+
+	sb.AddSet(portalMACSet, "ether_addr", "[portal] Authenticated clients", 65535, "timeout")
+	sb.AddSet(portalV4Set, "ipv4_addr", "[portal] Authenticated IPv4 clients", 65535, "timeout")
+	sb.AddSet(portalV6Set, "ipv6_addr", "[portal] Authenticated IPv6 clients", 65535, "timeout")
+
+	// The portal itself, including the RFC 8908 API
+	ports := []string{fmt.Sprint(cfg.CaptivePortal.HTTPPort())}
+	if port := cfg.CaptivePortal.HTTPSPort(); port != 0 {
+		ports = append(ports, fmt.Sprint(port))
+	}
+	sb.AddRule("input", fmt.Sprintf("iifname %s tcp dport { %s } accept", ifaceSet, strings.Join(ports, ", ")), "[portal] Login page")
+
+	// Unauthenticated HTTP is redirected to the portal. Runs just before
+	// the NAT table's DNAT so it takes precedence.
+	sb.AddChain("portal_redirect", "nat", "prerouting", -101, "accept", "[portal] Login redirect")
+	sb.AddRule("portal_redirect", fmt.Sprintf("iifname != %s return", ifaceSet))
+	sb.AddRule("portal_redirect", fmt.Sprintf("ether saddr @%s return", portalMACSet))
+	sb.AddRule("portal_redirect", fmt.Sprintf("ip saddr @%s return", portalV4Set))
+	sb.AddRule("portal_redirect", fmt.Sprintf("ip6 saddr @%s return", portalV6Set))
+	sb.AddRule("portal_redirect", fmt.Sprintf("tcp dport 80 redirect to :%d", cfg.CaptivePortal.HTTPPort()), "[portal] Send to login page")
+
+	// Everything else is held back until login
+	sb.AddChain("portal_forward", "", "", 0, "", "[portal] Unauthenticated clients")
+	sb.AddRule("portal_forward", fmt.Sprintf("ether saddr @%s return", portalMACSet))
+	sb.AddRule("portal_forward", fmt.Sprintf("ip saddr @%s return", portalV4Set))
+	sb.AddRule("portal_forward", fmt.Sprintf("ip6 saddr @%s return", portalV6Set))
+	sb.AddRule("portal_forward", "meta l4proto tcp reject with tcp reset")
+	sb.AddRule("portal_forward", "reject with icmpx type admin-prohibited")
+	sb.AddRule("forward", fmt.Sprintf("iifname %s jump portal_forward", ifaceSet), "[portal] Require login")
+}
+
+// portalAuthorizeScript returns the nft commands admitting a client for
+// ttl. Existing entries are replaced so a new login restarts the timeout.
+func portalAuthorizeScript(table string, mac net.HardwareAddr, ip net.IP, ttl time.Duration) string {
+	timeout := int(ttl.Seconds())
+	if timeout < 60 {
+		timeout = 60
+	}
+
+	var lines []string
+	add := func(set, elem string) {
+		lines = append(lines,
+			fmt.Sprintf("add element inet %s %s { %s }", table, set, elem),
+			fmt.Sprintf("delete element inet %s %s { %s }", table, set, elem),
+			fmt.Sprintf("add element inet %s %s { %s timeout %ds }", table, set, elem, timeout),
+		)
+	}
+	if len(mac) > 0 {
+		add(portalMACSet, mac.String())
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		add(portalV4Set, ip4.String())
+	} else if ip != nil {
+		add(portalV6Set, ip.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -1,6 +1,10 @@
 package firewall
 
-import "grimm.is/glacic/internal/config"
+import (
+	"net/netip"
+
+	"grimm.is/glacic/internal/config"
+)
 
 // Config represents the specific configuration required by the firewall.
 // It is a subset of the global configuration.
@@ -24,6 +28,18 @@ type Config struct {
 	RuleLearning      *config.RuleLearningConfig // For inline mode nfqueue support
 	NTP               *config.NTPConfig
 	UPnP              *config.UPnPConfig
+	CaptivePortal     *config.CaptivePortalConfig
+	GeoIP             *config.GeoIPConfig
+	IPS               *config.IPSConfig
+
+	// DelegatedPrefixes are the DHCPv6-PD prefixes currently assigned to
+	// interfaces with pd_from. Runtime state, filled in by the Manager.
+	DelegatedPrefixes map[string]string
+
+	// GeoIPNetworks are the networks of each country referenced by policy
+	// rules or geo blocking, keyed by ISO code. Runtime state, filled in by
+	// the Manager from the GeoIP database; nil when no database is loaded.
+	GeoIPNetworks map[string][]netip.Prefix
 }
 
 // FromGlobalConfig extracts the firewall configuration from the global config.
@@ -56,5 +72,8 @@ func FromGlobalConfig(g *config.Config) *Config {
 		RuleLearning:      g.RuleLearning,
 		NTP:               g.NTP,
 		UPnP:              g.UPnP,
+		CaptivePortal:     g.CaptivePortal,
+		GeoIP:             g.GeoIP,
+		IPS:               g.IPS,
 	}
 }
@@ -0,0 +1,400 @@
+//go:build linux
+// +build linux
+
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"golang.org/x/sys/unix"
+
+	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/state"
+)
+
+// ctnetlink message and attribute types (linux/netfilter/nfnetlink_conntrack.h)
+const (
+	ctNew = 0
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaStatus     = 3
+	ctaProtoInfo  = 4
+	ctaNatSrc     = 6
+	ctaTimeout    = 7
+	ctaMark       = 8
+	ctaNatDst     = 13
+	ctaZone       = 18
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaProtoInfoTCP           = 1
+	ctaProtoInfoTCPState      = 1
+	ctaProtoInfoTCPWScaleOrig = 2
+	ctaProtoInfoTCPWScaleRepl = 3
+	ctaProtoInfoTCPFlagsOrig  = 4
+	ctaProtoInfoTCPFlagsRepl  = 5
+
+	ctaNatV4MinIP = 1
+	ctaNatV4MaxIP = 2
+	ctaNatProto   = 3
+	ctaNatV6MinIP = 4
+	ctaNatV6MaxIP = 5
+
+	ctaProtoNatPortMin = 1
+	ctaProtoNatPortMax = 2
+)
+
+const (
+	// Status bits that may be set when creating an entry; the NAT bits
+	// are derived by the kernel from the NAT attributes.
+	ipsSeenReply = 1 << 1
+	ipsAssured   = 1 << 2
+	ipsConfirmed = 1 << 3
+
+	// tcpFlagBeLiberal disables window tracking for injected TCP flows,
+	// whose sequence state the standby never saw.
+	tcpFlagBeLiberal = 0x08
+
+	// minCommitTimeout keeps committed flows alive until their next
+	// packet refreshes the timeout.
+	minCommitTimeout = 60
+)
+
+// ConntrackSync implements state.FlowSyncer on the kernel connection
+// tracker. Only TCP and UDP flows are synced; flows to or from the
+// node's own addresses are skipped, as with conntrackd's IgnoreTrafficFor,
+// since they cannot survive a takeover anyway.
+type ConntrackSync struct {
+	ignore map[netip.Addr]bool
+	logger *logging.Logger
+}
+
+// NewConntrackSync creates a flow syncer that ignores the given local
+// addresses. Virtual IPs that move on failover must not be ignored.
+func NewConntrackSync(ignore []netip.Addr, logger *logging.Logger) *ConntrackSync {
+	cs := &ConntrackSync{
+		ignore: make(map[netip.Addr]bool, len(ignore)),
+		logger: logger,
+	}
+	for _, addr := range ignore {
+		cs.ignore[addr.Unmap()] = true
+	}
+	return cs
+}
+
+// DumpFlows returns the current conntrack table.
+func (cs *ConntrackSync) DumpFlows() ([]state.FlowEvent, error) {
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("conntrack dial failed: %w", err)
+	}
+	defer conn.Close()
+
+	flows, err := conn.Dump(nil)
+	if err != nil {
+		return nil, fmt.Errorf("conntrack dump failed: %w", err)
+	}
+
+	events := make([]state.FlowEvent, 0, len(flows))
+	for _, f := range flows {
+		if ev, ok := cs.flowEvent(state.FlowNew, f); ok {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// WatchFlows streams conntrack new, update and destroy events until ctx
+// is cancelled.
+func (cs *ConntrackSync) WatchFlows(ctx context.Context) (<-chan state.FlowEvent, error) {
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("conntrack dial failed: %w", err)
+	}
+	// Drop events rather than the socket when the kernel outpaces us; a
+	// replica reconnect resyncs from a full dump
+	if err := conn.SetOption(netlink.NoENOBUFS, true); err != nil {
+		cs.logger.Debug("Failed to set NoENOBUFS on conntrack socket", "error", err)
+	}
+
+	evCh := make(chan conntrack.Event, 1024)
+	errCh, err := conn.Listen(evCh, 1, []netfilter.NetlinkGroup{
+		netfilter.GroupCTNew,
+		netfilter.GroupCTUpdate,
+		netfilter.GroupCTDestroy,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("conntrack listen failed: %w", err)
+	}
+
+	out := make(chan state.FlowEvent, 1024)
+	go func() {
+		defer close(out)
+		defer closeListener(conn, evCh, errCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errCh:
+				cs.logger.Warn("Conntrack event listener failed", "error", err)
+				return
+			case ev := <-evCh:
+				var typ state.FlowEventType
+				switch ev.Type {
+				case conntrack.EventNew:
+					typ = state.FlowNew
+				case conntrack.EventUpdate:
+					typ = state.FlowUpdate
+				case conntrack.EventDestroy:
+					typ = state.FlowDestroy
+				default:
+					continue
+				}
+				if ev.Flow == nil {
+					continue
+				}
+				fe, ok := cs.flowEvent(typ, *ev.Flow)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- fe:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// closeListener closes a listening conntrack connection. Close waits for
+// the event worker, which may be blocked on a full channel, so drain until
+// it has exited.
+func closeListener(conn *conntrack.Conn, evCh <-chan conntrack.Event, errCh <-chan error) {
+	done := make(chan struct{})
+	go func() {
+		conn.Close()
+		close(done)
+	}()
+	for {
+		select {
+		case <-evCh:
+		case <-errCh:
+		case <-done:
+			return
+		}
+	}
+}
+
+// CommitFlows installs flows into the kernel, recreating their NAT
+// mappings. Flows that already exist are refreshed instead.
+func (cs *ConntrackSync) CommitFlows(flows []state.FlowEvent) error {
+	conn, err := netfilter.Dial(nil)
+	if err != nil {
+		return fmt.Errorf("netfilter dial failed: %w", err)
+	}
+	defer conn.Close()
+
+	var failed int
+	var firstErr error
+	for _, f := range flows {
+		err := commitFlow(conn, f, true)
+		if errors.Is(err, unix.EBUSY) && f.Status != 0 {
+			// The status must match the kernel's view of a new entry,
+			// which varies between versions; create without it
+			f.Status = 0
+			err = commitFlow(conn, f, true)
+		}
+		if errors.Is(err, unix.EEXIST) {
+			err = commitFlow(conn, f, false)
+		}
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d flows failed: %w", failed, len(flows), firstErr)
+	}
+	return nil
+}
+
+func commitFlow(conn *netfilter.Conn, f state.FlowEvent, create bool) error {
+	family := netfilter.ProtoIPv4
+	if f.Orig.Src.Is6() {
+		family = netfilter.ProtoIPv6
+	}
+	flags := netlink.Request | netlink.Acknowledge
+	if create {
+		flags |= netlink.Create | netlink.Excl
+	}
+
+	req, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlink,
+		MessageType: netfilter.MessageType(ctNew),
+		Family:      family,
+		Flags:       flags,
+	}, flowAttributes(f, create))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Query(req)
+	return err
+}
+
+// flowEvent converts a kernel flow, reporting false for flows that are
+// not synced.
+func (cs *ConntrackSync) flowEvent(typ state.FlowEventType, f conntrack.Flow) (state.FlowEvent, bool) {
+	proto := f.TupleOrig.Proto.Protocol
+	if proto != unix.IPPROTO_TCP && proto != unix.IPPROTO_UDP {
+		return state.FlowEvent{}, false
+	}
+
+	orig := state.FlowTuple{
+		Src:     f.TupleOrig.IP.SourceAddress.Unmap(),
+		Dst:     f.TupleOrig.IP.DestinationAddress.Unmap(),
+		SrcPort: f.TupleOrig.Proto.SourcePort,
+		DstPort: f.TupleOrig.Proto.DestinationPort,
+	}
+	reply := state.FlowTuple{
+		Src:     f.TupleReply.IP.SourceAddress.Unmap(),
+		Dst:     f.TupleReply.IP.DestinationAddress.Unmap(),
+		SrcPort: f.TupleReply.Proto.SourcePort,
+		DstPort: f.TupleReply.Proto.DestinationPort,
+	}
+	if !orig.Src.IsValid() || !orig.Dst.IsValid() {
+		return state.FlowEvent{}, false
+	}
+	for _, addr := range []netip.Addr{orig.Src, orig.Dst} {
+		if addr.IsLoopback() || cs.ignore[addr] {
+			return state.FlowEvent{}, false
+		}
+	}
+
+	ev := state.FlowEvent{
+		Type:     typ,
+		Protocol: proto,
+		Orig:     orig,
+		Reply:    reply,
+		Status:   uint32(f.Status),
+		Timeout:  f.Timeout,
+		Mark:     f.Mark,
+		Zone:     f.Zone,
+	}
+	if tcp := f.ProtoInfo.TCP; tcp != nil {
+		// Flags are a {flags, mask} pair decoded big-endian
+		ev.TCP = &state.FlowTCPInfo{
+			State:       tcp.State,
+			WScaleOrig:  tcp.OriginalWindowScale,
+			WScaleReply: tcp.ReplyWindowScale,
+			FlagsOrig:   uint8(tcp.OriginalFlags >> 8),
+			FlagsReply:  uint8(tcp.ReplyFlags >> 8),
+		}
+	}
+	return ev, true
+}
+
+// flowAttributes builds the ctnetlink attributes for a flow. On create,
+// the reply tuple is sent un-NATed along with explicit NAT ranges: the
+// kernel only marks a flow as NATed when NAT setup changes its reply
+// tuple, so passing the NATed reply tuple directly would leave packets
+// untranslated.
+func flowAttributes(f state.FlowEvent, create bool) []netfilter.Attribute {
+	attrs := []netfilter.Attribute{
+		tupleAttr(ctaTupleOrig, f.Protocol, f.Orig),
+	}
+
+	if create {
+		inverse := state.FlowTuple{Src: f.Orig.Dst, Dst: f.Orig.Src, SrcPort: f.Orig.DstPort, DstPort: f.Orig.SrcPort}
+		attrs = append(attrs, tupleAttr(ctaTupleReply, f.Protocol, inverse))
+		if f.Status != 0 {
+			// Entries created over ctnetlink are confirmed on insertion
+			status := f.Status&(ipsSeenReply|ipsAssured) | ipsConfirmed
+			attrs = append(attrs, netfilter.Attribute{Type: ctaStatus, Data: netfilter.Uint32Bytes(status)})
+		}
+		if f.Reply.Src.IsValid() && (f.Orig.Dst != f.Reply.Src || f.Orig.DstPort != f.Reply.SrcPort) {
+			attrs = append(attrs, natAttr(ctaNatDst, f.Reply.Src, f.Reply.SrcPort))
+		}
+		if f.Reply.Dst.IsValid() && (f.Orig.Src != f.Reply.Dst || f.Orig.SrcPort != f.Reply.DstPort) {
+			attrs = append(attrs, natAttr(ctaNatSrc, f.Reply.Dst, f.Reply.DstPort))
+		}
+	}
+
+	timeout := max(f.Timeout, minCommitTimeout)
+	attrs = append(attrs, netfilter.Attribute{Type: ctaTimeout, Data: netfilter.Uint32Bytes(timeout)})
+	if f.Mark != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: ctaMark, Data: netfilter.Uint32Bytes(f.Mark)})
+	}
+	if f.Zone != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: ctaZone, Data: netfilter.Uint16Bytes(f.Zone)})
+	}
+	if f.TCP != nil {
+		attrs = append(attrs, netfilter.Attribute{Type: ctaProtoInfo, Nested: true, Children: []netfilter.Attribute{{
+			Type:   ctaProtoInfoTCP,
+			Nested: true,
+			Children: []netfilter.Attribute{
+				{Type: ctaProtoInfoTCPState, Data: []byte{f.TCP.State}},
+				{Type: ctaProtoInfoTCPWScaleOrig, Data: []byte{f.TCP.WScaleOrig}},
+				{Type: ctaProtoInfoTCPWScaleRepl, Data: []byte{f.TCP.WScaleReply}},
+				{Type: ctaProtoInfoTCPFlagsOrig, Data: []byte{f.TCP.FlagsOrig | tcpFlagBeLiberal, 0xff}},
+				{Type: ctaProtoInfoTCPFlagsRepl, Data: []byte{f.TCP.FlagsReply | tcpFlagBeLiberal, 0xff}},
+			},
+		}}})
+	}
+	return attrs
+}
+
+func tupleAttr(typ uint16, proto uint8, t state.FlowTuple) netfilter.Attribute {
+	src, dst := uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if t.Src.Is6() {
+		src, dst = ctaIPv6Src, ctaIPv6Dst
+	}
+	return netfilter.Attribute{Type: typ, Nested: true, Children: []netfilter.Attribute{
+		{Type: ctaTupleIP, Nested: true, Children: []netfilter.Attribute{
+			{Type: src, Data: t.Src.AsSlice()},
+			{Type: dst, Data: t.Dst.AsSlice()},
+		}},
+		{Type: ctaTupleProto, Nested: true, Children: []netfilter.Attribute{
+			{Type: ctaProtoNum, Data: []byte{proto}},
+			{Type: ctaProtoSrcPort, Data: netfilter.Uint16Bytes(t.SrcPort)},
+			{Type: ctaProtoDstPort, Data: netfilter.Uint16Bytes(t.DstPort)},
+		}},
+	}}
+}
+
+// natAttr builds a NAT range mapping to exactly addr:port.
+func natAttr(typ uint16, addr netip.Addr, port uint16) netfilter.Attribute {
+	minIP, maxIP := uint16(ctaNatV4MinIP), uint16(ctaNatV4MaxIP)
+	if addr.Is6() {
+		minIP, maxIP = ctaNatV6MinIP, ctaNatV6MaxIP
+	}
+	return netfilter.Attribute{Type: typ, Nested: true, Children: []netfilter.Attribute{
+		{Type: minIP, Data: addr.AsSlice()},
+		{Type: maxIP, Data: addr.AsSlice()},
+		{Type: ctaNatProto, Nested: true, Children: []netfilter.Attribute{
+			{Type: ctaProtoNatPortMin, Data: netfilter.Uint16Bytes(port)},
+			{Type: ctaProtoNatPortMax, Data: netfilter.Uint16Bytes(port)},
+		}},
+	}}
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+// +build !linux
+
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/state"
+)
+
+// ConntrackSync is a stub for non-Linux platforms.
+type ConntrackSync struct{}
+
+// NewConntrackSync returns a stub flow syncer.
+func NewConntrackSync(ignore []netip.Addr, logger *logging.Logger) *ConntrackSync {
+	return &ConntrackSync{}
+}
+
+// DumpFlows is not supported on this platform.
+func (cs *ConntrackSync) DumpFlows() ([]state.FlowEvent, error) {
+	return nil, fmt.Errorf("conntrack not supported on this platform")
+}
+
+// WatchFlows is not supported on this platform.
+func (cs *ConntrackSync) WatchFlows(ctx context.Context) (<-chan state.FlowEvent, error) {
+	return nil, fmt.Errorf("conntrack not supported on this platform")
+}
+
+// CommitFlows is not supported on this platform.
+func (cs *ConntrackSync) CommitFlows(flows []state.FlowEvent) error {
+	return fmt.Errorf("conntrack not supported on this platform")
+}
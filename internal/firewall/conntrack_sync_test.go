@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package firewall
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+
+	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/state"
+)
+
+func testConntrackFlow(proto uint8, src, dst, replySrc, replyDst string) conntrack.Flow {
+	f := conntrack.NewFlow(proto, conntrack.StatusAssured|conntrack.StatusSeenReply,
+		netip.MustParseAddr(src), netip.MustParseAddr(dst), 40000, 443, 120, 0)
+	f.TupleReply.IP.SourceAddress = netip.MustParseAddr(replySrc)
+	f.TupleReply.IP.DestinationAddress = netip.MustParseAddr(replyDst)
+	f.ProtoInfo.TCP = &conntrack.ProtoInfoTCP{State: 3, OriginalFlags: 0x2300, ReplyFlags: 0x2300}
+	return f
+}
+
+func TestConntrackSync_FlowEvent(t *testing.T) {
+	cs := NewConntrackSync([]netip.Addr{netip.MustParseAddr("192.168.1.1")}, logging.New(logging.DefaultConfig()))
+
+	// Masqueraded LAN client
+	ev, ok := cs.flowEvent(state.FlowNew, testConntrackFlow(6, "192.168.1.10", "203.0.113.5", "203.0.113.5", "198.51.100.1"))
+	require.True(t, ok)
+	assert.Equal(t, uint8(6), ev.Protocol)
+	assert.Equal(t, netip.MustParseAddr("198.51.100.1"), ev.Reply.Dst)
+	assert.Equal(t, uint16(40000), ev.Reply.DstPort)
+	require.NotNil(t, ev.TCP)
+	assert.Equal(t, uint8(3), ev.TCP.State)
+	assert.Equal(t, uint8(0x23), ev.TCP.FlagsOrig)
+
+	// Traffic to the firewall itself is not synced
+	_, ok = cs.flowEvent(state.FlowNew, testConntrackFlow(6, "192.168.1.10", "192.168.1.1", "192.168.1.1", "192.168.1.10"))
+	assert.False(t, ok)
+	_, ok = cs.flowEvent(state.FlowNew, testConntrackFlow(6, "127.0.0.1", "127.0.0.1", "127.0.0.1", "127.0.0.1"))
+	assert.False(t, ok)
+
+	// Only TCP and UDP
+	_, ok = cs.flowEvent(state.FlowNew, testConntrackFlow(132, "192.168.1.10", "203.0.113.5", "203.0.113.5", "192.168.1.10"))
+	assert.False(t, ok)
+}
+
+func findAttr(attrs []netfilter.Attribute, typ uint16) *netfilter.Attribute {
+	for i := range attrs {
+		if attrs[i].Type == typ {
+			return &attrs[i]
+		}
+	}
+	return nil
+}
+
+func TestFlowAttributes_NAT(t *testing.T) {
+	orig := state.FlowTuple{
+		Src:     netip.MustParseAddr("192.168.1.10"),
+		Dst:     netip.MustParseAddr("203.0.113.5"),
+		SrcPort: 40000,
+		DstPort: 443,
+	}
+	snat := state.FlowEvent{
+		Protocol: 6,
+		Orig:     orig,
+		Reply: state.FlowTuple{
+			Src:     orig.Dst,
+			Dst:     netip.MustParseAddr("198.51.100.1"),
+			SrcPort: 443,
+			DstPort: 61000,
+		},
+		Status:  0x18e, // Includes NAT and confirmed bits
+		Timeout: 10,
+		TCP:     &state.FlowTCPInfo{State: 3},
+	}
+
+	attrs := flowAttributes(snat, true)
+
+	// The reply tuple is the inverse of the original; NAT is separate
+	reply := findAttr(attrs, ctaTupleReply)
+	require.NotNil(t, reply)
+	assert.Equal(t, orig.Dst.AsSlice(), reply.Children[0].Children[0].Data)
+	assert.Equal(t, orig.Src.AsSlice(), reply.Children[0].Children[1].Data)
+
+	nat := findAttr(attrs, ctaNatSrc)
+	require.NotNil(t, nat)
+	assert.Equal(t, []byte{198, 51, 100, 1}, nat.Children[0].Data)
+	assert.Equal(t, netfilter.Uint16Bytes(61000), nat.Children[2].Children[0].Data)
+	assert.Nil(t, findAttr(attrs, ctaNatDst))
+
+	assert.Equal(t, netfilter.Uint32Bytes(ipsSeenReply|ipsAssured|ipsConfirmed), findAttr(attrs, ctaStatus).Data)
+	assert.Equal(t, netfilter.Uint32Bytes(minCommitTimeout), findAttr(attrs, ctaTimeout).Data)
+
+	tcp := findAttr(attrs, ctaProtoInfo).Children[0]
+	assert.Equal(t, []byte{tcpFlagBeLiberal, 0xff}, findAttr(tcp.Children, ctaProtoInfoTCPFlagsOrig).Data)
+
+	// Port forward: the reply comes from the internal server
+	dnat := snat
+	dnat.Reply = state.FlowTuple{
+		Src:     netip.MustParseAddr("10.0.0.5"),
+		Dst:     orig.Src,
+		SrcPort: 8443,
+		DstPort: orig.SrcPort,
+	}
+	attrs = flowAttributes(dnat, true)
+	assert.Nil(t, findAttr(attrs, ctaNatSrc))
+	nat = findAttr(attrs, ctaNatDst)
+	require.NotNil(t, nat)
+	assert.Equal(t, []byte{10, 0, 0, 5}, nat.Children[0].Data)
+	assert.Equal(t, netfilter.Uint16Bytes(8443), nat.Children[2].Children[0].Data)
+
+	// Updates only refresh an existing entry
+	attrs = flowAttributes(snat, false)
+	assert.Nil(t, findAttr(attrs, ctaTupleReply))
+	assert.Nil(t, findAttr(attrs, ctaNatSrc))
+	assert.Nil(t, findAttr(attrs, ctaStatus))
+}
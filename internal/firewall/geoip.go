@@ -3,28 +3,38 @@ package firewall
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
 )
 
+// DefaultGeoIPDatabasePath is used when the geoip block names no database.
+const DefaultGeoIPDatabasePath = "/var/lib/glacic/geoip/GeoLite2-Country.mmdb"
+
 // GeoIPManager handles country lookups from MaxMind databases.
 type GeoIPManager struct {
-	mu     sync.RWMutex
-	reader *geoip2.Reader
-	path   string
+	mu      sync.RWMutex
+	reader  *geoip2.Reader
+	path    string
+	modTime time.Time // Modification time of the loaded file
 }
 
 // NewGeoIPManager creates a new GeoIP manager with the specified database path.
 // If path is empty, uses the default location.
 func NewGeoIPManager(dbPath string) (*GeoIPManager, error) {
 	if dbPath == "" {
-		dbPath = "/var/lib/glacic/geoip/GeoLite2-Country.mmdb"
+		dbPath = DefaultGeoIPDatabasePath
 	}
 
 	// Check if database exists
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+	info, err := os.Stat(dbPath)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("GeoIP database not found at %s", dbPath)
 	}
 
@@ -33,10 +43,14 @@ func NewGeoIPManager(dbPath string) (*GeoIPManager, error) {
 		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
 	}
 
-	return &GeoIPManager{
+	g := &GeoIPManager{
 		reader: reader,
 		path:   dbPath,
-	}, nil
+	}
+	if info != nil {
+		g.modTime = info.ModTime()
+	}
+	return g, nil
 }
 
 // LookupCountry returns the ISO 3166-1 alpha-2 country code for the given IP.
@@ -73,19 +87,124 @@ func (g *GeoIPManager) Reload() error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if g.reader != nil {
-		g.reader.Close()
-	}
-
+	// Open the new file first so a broken update keeps the old database
 	reader, err := geoip2.Open(g.path)
 	if err != nil {
 		return fmt.Errorf("failed to reload GeoIP database: %w", err)
 	}
 
+	if g.reader != nil {
+		g.reader.Close()
+	}
 	g.reader = reader
+	if info, err := os.Stat(g.path); err == nil {
+		g.modTime = info.ModTime()
+	}
 	return nil
 }
 
+// ReloadIfChanged reopens the database if the file was replaced since it
+// was loaded, as geoipupdate and similar tools do. It reports whether the
+// database was reloaded.
+func (g *GeoIPManager) ReloadIfChanged() (bool, error) {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to check GeoIP database: %w", err)
+	}
+	g.mu.RLock()
+	changed := !info.ModTime().Equal(g.modTime)
+	g.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, g.Reload()
+}
+
+// CountryNetworks walks the whole database and returns the IPv4 and IPv6
+// networks of each requested country, aggregated into as few prefixes as
+// possible. Every requested code gets an entry, even if the database has
+// no networks for it.
+func (g *GeoIPManager) CountryNetworks(codes []string) (map[string][]netip.Prefix, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	result := make(map[string][]netip.Prefix, len(codes))
+	for _, code := range codes {
+		result[strings.ToUpper(code)] = nil
+	}
+
+	// geoip2 only does point lookups, so walk the tree with the
+	// underlying MMDB reader
+	db, err := maxminddb.Open(g.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer db.Close()
+
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		record.Country.IsoCode = ""
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GeoIP record: %w", err)
+		}
+		list, ok := result[record.Country.IsoCode]
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(network.IP)
+		if !ok {
+			continue
+		}
+		bits, _ := network.Mask.Size()
+		result[record.Country.IsoCode] = append(list, netip.PrefixFrom(addr.Unmap(), bits).Masked())
+	}
+	if err := networks.Err(); err != nil {
+		return nil, fmt.Errorf("failed to walk GeoIP database: %w", err)
+	}
+
+	for code, list := range result {
+		result[code] = aggregatePrefixes(list)
+	}
+	return result, nil
+}
+
+// aggregatePrefixes merges sibling prefixes into their parent until no two
+// can be combined. The prefixes must not overlap, which holds for the
+// leaves of an MMDB search tree.
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+
+	out := prefixes[:0]
+	for _, p := range prefixes {
+		for len(out) > 0 && p.Bits() > 0 {
+			last := out[len(out)-1]
+			if last.Bits() != p.Bits() {
+				break
+			}
+			parent := netip.PrefixFrom(last.Addr(), last.Bits()-1).Masked()
+			// last must be the lower half and p the upper half of parent
+			if parent.Addr() != last.Addr() || !parent.Contains(p.Addr()) || p == last {
+				break
+			}
+			out = out[:len(out)-1]
+			p = parent
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
 // DatabasePath returns the path to the loaded database.
 func (g *GeoIPManager) DatabasePath() string {
 	return g.path
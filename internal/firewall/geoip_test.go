@@ -0,0 +1,221 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mmdbNode is a node of the binary search tree of a test MMDB. Each side
+// either leads to another node or ends in a record (data offset + 1).
+type mmdbNode struct {
+	child  [2]*mmdbNode
+	record [2]int
+}
+
+// writeTestMMDB writes a minimal GeoLite2-Country style database mapping
+// each prefix to its country, in the same IPv6 layout MaxMind uses (IPv4
+// networks live under ::/96).
+func writeTestMMDB(t *testing.T, path string, countries map[string][]string) {
+	t.Helper()
+
+	var data bytes.Buffer
+	root := &mmdbNode{}
+	for code, prefixes := range countries {
+		offset := data.Len()
+		mmdbMap(&data, 1)
+		mmdbString(&data, "country")
+		mmdbMap(&data, 1)
+		mmdbString(&data, "iso_code")
+		mmdbString(&data, code)
+
+		for _, s := range prefixes {
+			prefix := netip.MustParsePrefix(s)
+			addr, bits := prefix.Addr().As16(), prefix.Bits()
+			if prefix.Addr().Is4() {
+				addr, bits = netip.AddrFrom16(addr).As16(), bits+96
+				copy(addr[:12], make([]byte, 12))
+			}
+			node := root
+			for i := 0; i < bits-1; i++ {
+				bit := addr[i/8] >> (7 - i%8) & 1
+				if node.child[bit] == nil {
+					node.child[bit] = &mmdbNode{}
+				}
+				node = node.child[bit]
+			}
+			bit := addr[(bits-1)/8] >> (7 - (bits-1)%8) & 1
+			node.record[bit] = offset + 1
+		}
+	}
+
+	// Number the nodes depth first, then emit 24-bit records
+	var nodes []*mmdbNode
+	index := make(map[*mmdbNode]int)
+	var walk func(n *mmdbNode)
+	walk = func(n *mmdbNode) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for side := 0; side < 2; side++ {
+			value := count // no data
+			if c := n.child[side]; c != nil {
+				value = index[c]
+			} else if n.record[side] > 0 {
+				value = count + 16 + n.record[side] - 1
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	mmdbMap(&out, 6)
+	mmdbString(&out, "node_count")
+	mmdbUint32(&out, uint32(count))
+	mmdbString(&out, "record_size")
+	mmdbUint16(&out, 24)
+	mmdbString(&out, "ip_version")
+	mmdbUint16(&out, 6)
+	mmdbString(&out, "database_type")
+	mmdbString(&out, "GeoLite2-Country")
+	mmdbString(&out, "binary_format_major_version")
+	mmdbUint16(&out, 2)
+	mmdbString(&out, "build_epoch")
+	mmdbUint32(&out, uint32(time.Now().Unix()))
+
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mmdbString(b *bytes.Buffer, s string) {
+	b.WriteByte(2<<5 | byte(len(s)))
+	b.WriteString(s)
+}
+
+func mmdbMap(b *bytes.Buffer, pairs int) {
+	b.WriteByte(7<<5 | byte(pairs))
+}
+
+func mmdbUint16(b *bytes.Buffer, v uint16) {
+	b.WriteByte(5<<5 | 2)
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func mmdbUint32(b *bytes.Buffer, v uint32) {
+	b.WriteByte(6<<5 | 4)
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func TestCountryNetworks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, path, map[string][]string{
+		"US": {"192.0.2.0/25", "192.0.2.128/25", "2001:db8::/33", "2001:db8:8000::/33"},
+		"DE": {"198.51.100.0/24", "2001:db9::/48"},
+	})
+
+	g, err := NewGeoIPManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// Point lookups read the same database
+	if code, err := g.LookupCountry(net.ParseIP("192.0.2.200")); err != nil || code != "US" {
+		t.Errorf("LookupCountry = %q, %v", code, err)
+	}
+
+	got, err := g.CountryNetworks([]string{"us", "FR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]netip.Prefix{
+		"US": {netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::/32")},
+		"FR": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CountryNetworks = %v, want %v", got, want)
+	}
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"siblings", []string{"10.0.0.128/25", "10.0.0.0/25"}, []string{"10.0.0.0/24"}},
+		{"cascade", []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"}, []string{"10.0.0.0/24"}},
+		{"not siblings", []string{"10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/24"}},
+		{"different lengths", []string{"10.0.0.0/25", "10.0.0.128/26"}, []string{"10.0.0.0/25", "10.0.0.128/26"}},
+		{"families", []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}, []string{"0.0.0.0/0", "::/0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in []netip.Prefix
+			for _, s := range tt.in {
+				in = append(in, netip.MustParsePrefix(s))
+			}
+			var got []string
+			for _, p := range aggregatePrefixes(in) {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregatePrefixes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoIPReloadIfChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, path, map[string][]string{"US": {"192.0.2.0/24"}})
+
+	g, err := NewGeoIPManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	if changed, err := g.ReloadIfChanged(); err != nil || changed {
+		t.Fatalf("ReloadIfChanged on untouched file = %v, %v", changed, err)
+	}
+
+	// geoipupdate replaces the file; the new networks must be picked up
+	writeTestMMDB(t, path, map[string][]string{"US": {"198.51.100.0/24"}})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := g.ReloadIfChanged(); err != nil || !changed {
+		t.Fatalf("ReloadIfChanged on replaced file = %v, %v", changed, err)
+	}
+	got, err := g.CountryNetworks([]string{"US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}; !reflect.DeepEqual(got["US"], want) {
+		t.Errorf("networks after reload = %v, want %v", got["US"], want)
+	}
+	if code, _ := g.LookupCountry(net.ParseIP("198.51.100.1")); code != "US" {
+		t.Errorf("LookupCountry after reload = %q", code)
+	}
+}
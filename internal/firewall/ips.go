@@ -0,0 +1,55 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// Policy rules with action "inspect" jump to ipsChain, which labels the
+// connection and queues the packet to the IPS engine. The label sends the
+// rest of the connection to the engine too, ahead of the stateful accept.
+// A label is used rather than a ct mark bit because marks are overwritten
+// by policy routing.
+const (
+	ipsChain     = "ips_inspect"
+	ipsConnLabel = 127
+)
+
+// addIPSRules adds the inspection chain and queues established packets of
+// inspected connections. Without a running engine the queue either drops
+// packets or, with fail_open, lets them through (nft "bypass"); the same
+// setting decides what the kernel does when the queue overflows.
+func addIPSRules(sb *ScriptBuilder, cfg *Config) {
+	enabled := cfg.IPS != nil && cfg.IPS.Enabled
+	if !enabled && !hasInspectRules(cfg.Policies) {
+		return
+	}
+	sb.AddChain(ipsChain, "", "", 0, "", "[ips] Inline inspection")
+	if !enabled {
+		// Validation rejects inspect rules without ips; don't break the
+		// ruleset if one gets through anyway.
+		sb.AddRule(ipsChain, "accept", "[ips] Disabled")
+		return
+	}
+
+	queue := fmt.Sprintf("queue num %d", cfg.IPS.QueueNum())
+	if cfg.IPS.FailOpen {
+		queue += " bypass"
+	}
+	sb.AddRule(ipsChain, fmt.Sprintf("ct label set %d counter %s", ipsConnLabel, queue), "[ips] Queue to engine")
+	sb.AddRule("input", fmt.Sprintf("ct label %d jump %s", ipsConnLabel, ipsChain), "[ips] Inspected connections")
+	sb.AddRule("forward", fmt.Sprintf("ct label %d jump %s", ipsConnLabel, ipsChain), "[ips] Inspected connections")
+}
+
+func hasInspectRules(policies []config.Policy) bool {
+	for _, pol := range policies {
+		for _, rule := range pol.Rules {
+			if strings.EqualFold(rule.Action, "inspect") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -4,7 +4,10 @@ package firewall
 
 import (
 	"context"
+	"net/netip"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -386,3 +389,46 @@ func TestManager_RemoveDynamicNATRule_NoMatch(t *testing.T) {
 	}
 	mgr.mu.RUnlock()
 }
+
+func TestManager_LoadGeoIPNetworks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, path, map[string][]string{"CN": {"198.51.100.0/24"}, "RU": {"203.0.113.0/24"}})
+
+	mgr := NewManagerWithConn(NewMockNFTablesConn(), nil, "")
+	cfg := FromGlobalConfig(&config.Config{
+		GeoIP: &config.GeoIPConfig{Enabled: true, DatabasePath: path},
+		Policies: []config.Policy{{
+			From: "wan", To: "lan",
+			Rules: []config.PolicyRule{{SourceCountry: "CN", Action: "drop"}},
+		}},
+	})
+
+	networks := mgr.loadGeoIPNetworks(cfg)
+	if got := networks["CN"]; len(got) != 1 || got[0] != netip.MustParsePrefix("198.51.100.0/24") {
+		t.Fatalf("CN networks = %v", got)
+	}
+	if _, ok := networks["RU"]; ok {
+		t.Error("Unreferenced country walked")
+	}
+
+	// The same countries are served from the cache
+	if again := mgr.loadGeoIPNetworks(cfg); reflect.ValueOf(again).Pointer() != reflect.ValueOf(networks).Pointer() {
+		t.Error("Database walked again for unchanged countries")
+	}
+
+	// A newly referenced country triggers a new walk
+	cfg.Protections = []config.InterfaceProtection{{Interface: "eth0", GeoBlocking: true, BlockedCountries: []string{"RU"}}}
+	if got := mgr.loadGeoIPNetworks(cfg)["RU"]; len(got) != 1 {
+		t.Errorf("RU networks = %v", got)
+	}
+
+	// Disabled GeoIP or a missing database leaves the sets empty
+	cfg.GeoIP = &config.GeoIPConfig{Enabled: true, DatabasePath: filepath.Join(t.TempDir(), "missing.mmdb")}
+	if got := mgr.loadGeoIPNetworks(cfg); got != nil {
+		t.Errorf("networks without a database = %v", got)
+	}
+	cfg.GeoIP = nil
+	if got := mgr.loadGeoIPNetworks(cfg); got != nil {
+		t.Errorf("networks with GeoIP disabled = %v", got)
+	}
+}
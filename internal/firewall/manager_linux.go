@@ -6,6 +6,7 @@ package firewall
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"strings"
 	"sync"
@@ -31,6 +32,9 @@ type Manager struct {
 	currentConfig  *Config // Currently applied config (including dynamic)
 	dynamicRules   []config.NATRule
 	scheduledRules map[string]config.ScheduledRule // Active scheduled rules
+	pdPrefixes     map[string]string               // Delegated prefixes by interface
+	geoip          *GeoIPManager                   // Open GeoIP database, if any
+	geoipNetworks  map[string][]netip.Prefix       // Country networks walked from geoip
 	expectedGenID  uint32
 	monitorEnabled bool
 
@@ -121,6 +125,17 @@ func (m *Manager) ApplyConfig(cfg *Config) error {
 		}
 	}
 
+	// Merge delegated prefixes
+	if len(m.pdPrefixes) > 0 {
+		effectiveCfg.DelegatedPrefixes = make(map[string]string, len(m.pdPrefixes))
+		for iface, prefix := range m.pdPrefixes {
+			effectiveCfg.DelegatedPrefixes[iface] = prefix
+		}
+	}
+
+	// Merge GeoIP country networks
+	effectiveCfg.GeoIPNetworks = m.loadGeoIPNetworks(localCfg)
+
 	m.currentConfig = &effectiveCfg
 
 	if globalCfg.Features != nil {
@@ -319,6 +334,122 @@ func (m *Manager) ApplyScheduledRule(rule config.ScheduledRule, enabled bool) er
 	return m.ApplyConfig(base)
 }
 
+// SetDelegatedPrefix updates the prefix delegated to an interface and
+// reapplies the firewall so its pd_<iface> set follows ISP prefix rotations.
+// An invalid prefix empties the set.
+func (m *Manager) SetDelegatedPrefix(iface string, prefix netip.Prefix) error {
+	m.mu.Lock()
+	value := ""
+	if prefix.IsValid() {
+		value = prefix.Masked().String()
+	}
+	if m.pdPrefixes[iface] == value {
+		m.mu.Unlock()
+		return nil
+	}
+	if m.pdPrefixes == nil {
+		m.pdPrefixes = make(map[string]string)
+	}
+	if value == "" {
+		delete(m.pdPrefixes, iface)
+	} else {
+		m.pdPrefixes[iface] = value
+	}
+	m.logger.Info("Delegated prefix updated", "interface", iface, "prefix", value)
+
+	// Create a local copy of base parameters to release lock during ApplyConfig
+	if m.baseConfig == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("cannot apply delegated prefix: firewall not initialized")
+	}
+	base := m.baseConfig
+	m.mu.Unlock()
+
+	// Re-apply using base config (ApplyConfig will merge the delegated prefixes)
+	return m.ApplyConfig(base)
+}
+
+// RefreshGeoIP reloads the GeoIP database if the file has been updated and,
+// if so, reapplies the firewall. The country sets are flushed and refilled
+// in the same transaction as the rest of the ruleset, so they are swapped
+// atomically.
+func (m *Manager) RefreshGeoIP() error {
+	m.mu.Lock()
+	if m.baseConfig == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("cannot refresh GeoIP: firewall not initialized")
+	}
+	if m.geoip == nil {
+		// Nothing references the database, or it has yet to appear;
+		// ApplyConfig opens it once it does.
+		m.mu.Unlock()
+		return nil
+	}
+	changed, err := m.geoip.ReloadIfChanged()
+	if err != nil || !changed {
+		m.mu.Unlock()
+		return err
+	}
+	m.geoipNetworks = nil
+	m.logger.Info("GeoIP database updated", "path", m.geoip.DatabasePath())
+	base := m.baseConfig
+	m.mu.Unlock()
+
+	// Re-apply using base config (ApplyConfig will walk the new database)
+	return m.ApplyConfig(base)
+}
+
+// loadGeoIPNetworks returns the networks of every country cfg references,
+// walking the database only when it or the set of countries has changed.
+// Failures are logged rather than returned: the country sets stay empty and
+// allowlists are not enforced until a database is available.
+// Must be called with m.mu held.
+func (m *Manager) loadGeoIPNetworks(cfg *Config) map[string][]netip.Prefix {
+	codes, err := geoIPCountries(cfg)
+	if err != nil || len(codes) == 0 || cfg.GeoIP == nil || !cfg.GeoIP.Enabled {
+		// Invalid codes are reported by the script builder
+		return nil
+	}
+
+	path := cfg.GeoIP.DatabasePath
+	if path == "" {
+		path = DefaultGeoIPDatabasePath
+	}
+	if m.geoip == nil || m.geoip.DatabasePath() != path {
+		if m.geoip != nil {
+			m.geoip.Close()
+		}
+		m.geoip, m.geoipNetworks = nil, nil
+		geoip, err := NewGeoIPManager(path)
+		if err != nil {
+			m.logger.Warn("GeoIP database unavailable, country sets left empty", "error", err)
+			return nil
+		}
+		m.geoip = geoip
+	}
+
+	cached := m.geoipNetworks != nil && len(m.geoipNetworks) == len(codes)
+	for _, code := range codes {
+		if _, ok := m.geoipNetworks[code]; !ok {
+			cached = false
+		}
+	}
+	if cached {
+		return m.geoipNetworks
+	}
+
+	networks, err := m.geoip.CountryNetworks(codes)
+	if err != nil {
+		m.logger.Warn("Failed to read GeoIP networks", "path", path, "error", err)
+		return nil
+	}
+	for _, code := range codes {
+		m.logger.Info("Loaded GeoIP networks", "country", code, "prefixes", len(networks[code]))
+	}
+	m.geoipNetworks = networks
+	return networks
+}
+
 // validateConfig checks against injection attacks by enforcing strict naming
 func (m *Manager) validateConfig(cfg *Config) error {
 	// Validate Zone Names
@@ -619,6 +750,16 @@ func (m *Manager) AuthorizeIP(ip net.IP, ttl time.Duration) error {
 	return nil
 }
 
+// AuthorizePortalClient lets a captive portal client through for ttl. The
+// client is admitted by MAC when known and by address.
+func (m *Manager) AuthorizePortalClient(mac net.HardwareAddr, ip net.IP, ttl time.Duration) error {
+	script := portalAuthorizeScript(brand.LowerName, mac, ip, ttl)
+	if err := DefaultCommandRunner.RunInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to authorize portal client %s: %w", ip, err)
+	}
+	return nil
+}
+
 // IsInSafeMode returns whether safe mode is currently active.
 func (m *Manager) IsInSafeMode() bool {
 	m.mu.RLock()
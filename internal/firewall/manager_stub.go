@@ -6,8 +6,11 @@ package firewall
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/netip"
 	"runtime"
 	"strings"
+	"time"
 
 	"grimm.is/glacic/internal/config"
 	"grimm.is/glacic/internal/logging"
@@ -78,6 +81,21 @@ func (m *Manager) RemoveDynamicNATRule(match func(config.NATRule) bool) error {
 	return ErrNotSupported
 }
 
+// SetDelegatedPrefix updates a delegated prefix set (stub).
+func (m *Manager) SetDelegatedPrefix(iface string, prefix netip.Prefix) error {
+	return ErrNotSupported
+}
+
+// AuthorizePortalClient admits a captive portal client (stub).
+func (m *Manager) AuthorizePortalClient(mac net.HardwareAddr, ip net.IP, ttl time.Duration) error {
+	return ErrNotSupported
+}
+
+// RefreshGeoIP reloads the GeoIP database and its country sets (stub).
+func (m *Manager) RefreshGeoIP() error {
+	return ErrNotSupported
+}
+
 // GenerateRules generates the nftables ruleset script (Stub).
 func (m *Manager) GenerateRules(cfg *Config) (string, error) {
 	// 1. Build filter table script (no metadata on non-Linux)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"slices"
 	"strings"
 
 	"grimm.is/glacic/internal/config"
@@ -358,39 +359,41 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 		}
 	}
 
-	// Add Protection Chain (Raw Prerouting)
-	addProtectionRules(cfg, sb)
-
-	// Define GeoIP sets if referenced by any policy rule
-	// We scan all policies to find used identifiers like "US", "CN", etc.
-	usedCountries := make(map[string]bool)
-	for _, pol := range cfg.Policies {
-		if pol.Disabled {
-			continue
-		}
-		for _, rule := range pol.Rules {
-			if rule.Disabled {
-				continue
-			}
-			if rule.SourceCountry != "" {
-				usedCountries[strings.ToUpper(rule.SourceCountry)] = true
-			}
-			if rule.DestCountry != "" {
-				usedCountries[strings.ToUpper(rule.DestCountry)] = true
+	// Define GeoIP sets referenced by policy rules or geo blocking. Each
+	// country gets an IPv4 and an IPv6 set, refilled from the database in
+	// the same transaction so the contents are swapped atomically.
+	countries, err := geoIPCountries(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range countries {
+		v4Set, v6Set := geoIPSetNames(code)
+		sb.AddSet(v4Set, "ipv4_addr", fmt.Sprintf("[geoip] %s", code), 0, "interval")
+		sb.AddSet(v6Set, "ipv6_addr", fmt.Sprintf("[geoip] %s", code), 0, "interval")
+		sb.AddLine(fmt.Sprintf("flush set %s %s %s", sb.family, sb.tableName, quote(v4Set)))
+		sb.AddLine(fmt.Sprintf("flush set %s %s %s", sb.family, sb.tableName, quote(v6Set)))
+
+		var v4, v6 []string
+		for _, prefix := range cfg.GeoIPNetworks[code] {
+			if prefix.Addr().Is4() {
+				v4 = append(v4, prefix.String())
+			} else {
+				v6 = append(v6, prefix.String())
 			}
 		}
-	}
-
-	for code := range usedCountries {
-		if len(code) != 2 {
-			return nil, fmt.Errorf("invalid country code: %s", code)
+		// Prefixes come from netip and are added unquoted, as nft only
+		// parses prefix notation outside of strings
+		if len(v4) > 0 {
+			sb.AddLine(fmt.Sprintf("add element %s %s %s { %s }", sb.family, sb.tableName, quote(v4Set), strings.Join(v4, ", ")))
+		}
+		if len(v6) > 0 {
+			sb.AddLine(fmt.Sprintf("add element %s %s %s { %s }", sb.family, sb.tableName, quote(v6Set), strings.Join(v6, ", ")))
 		}
-		setName := fmt.Sprintf("geoip_country_%s", code)
-		// Add set definition: type ipv4_addr; flags interval;
-		// note: Currently only supporting IPv4 for GeoIP as per BuildRuleExpression
-		sb.AddSet(setName, "ipv4_addr", fmt.Sprintf("[geoip] %s", code), 0, "interval")
 	}
 
+	// Add Protection Chain (Raw Prerouting)
+	addProtectionRules(cfg, sb)
+
 	// CRITICAL: Define IPSets BEFORE rules that reference them
 	for _, ipset := range cfg.IPSets {
 		setType := ipset.Type
@@ -438,6 +441,23 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 		}
 	}
 
+	// Delegated prefix sets: one per interface numbered from a DHCPv6-PD
+	// delegation, refilled with its current /64 whenever the ISP rotates it.
+	for _, iface := range cfg.Interfaces {
+		if iface.PDFrom == "" {
+			continue
+		}
+		setName := config.DelegatedPrefixSetName(iface.Name)
+		if !isValidIdentifier(setName) {
+			return nil, fmt.Errorf("invalid delegated prefix set name: %s", setName)
+		}
+		sb.AddSet(setName, "ipv6_addr", fmt.Sprintf("[pd] %s", iface.Name), 0, "interval")
+		sb.AddLine(fmt.Sprintf("flush set %s %s %s", sb.family, sb.tableName, quote(setName)))
+		if prefix := cfg.DelegatedPrefixes[iface.Name]; prefix != "" {
+			sb.AddSetElements(setName, []string{prefix})
+		}
+	}
+
 	// Define DNS Egress Control Sets (Dynamic, Persistent)
 	// We handle v4 and v6 separately because nftables sets are typed.
 	if cfg.DNS != nil && cfg.DNS.EgressFilter {
@@ -457,6 +477,9 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 	// Priority -150 (mangle) ensures marks are set before routing decision
 	sb.AddChain("mark_prerouting", "filter", "prerouting", -150, "accept", "[base] Policy routing marks")
 
+	// Inline IPS: must precede the stateful accept
+	addIPSRules(sb, cfg)
+
 	// Add base rules (loopback, established/related)
 	sb.AddRule("input", "iifname \"lo\" accept", "[base] Loopback")
 	sb.AddRule("output", "oifname \"lo\" accept", "[base] Loopback")
@@ -628,6 +651,9 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 	// Web Access Control (New Config)
 	useNewWebRules := generateWebAccessRules(cfg, sb)
 
+	// Captive portal: hold guests at the login page until authenticated
+	addCaptivePortalRules(sb, cfg)
+
 	// DNS Egress Control (DNS Wall) - Forward Chain
 	// Block forwarding to IPs not in the allowed sets.
 	// Only affects Forward chain (LAN Clients). Router Output is unrestricted.
@@ -982,6 +1008,7 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 	// Initialize maps for O(1) dispatch
 	inputMap := make(map[string]string)   // iifname -> verdict
 	forwardMap := make(map[string]string) // iifname . oifname -> verdict
+	ipv6Sets := ipv6SetNames(cfg)
 
 	for _, pol := range cfg.Policies {
 		if pol.Disabled {
@@ -1001,7 +1028,7 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 			if rule.Disabled {
 				continue
 			}
-			ruleExpr, err := BuildRuleExpression(rule)
+			ruleExpr, err := buildRuleExpression(rule, ipv6Sets)
 			if err != nil {
 				return nil, err
 			}
@@ -1013,6 +1040,15 @@ func BuildFilterTableScript(cfg *Config, vpn *config.VPNConfig, tableName string
 					ruleComment = fmt.Sprintf("[policy:%s→%s] rule#%d", pol.From, pol.To, i+1)
 				}
 				sb.AddRule(chainName, ruleExpr, ruleComment)
+
+				// Country sets are typed, so a country match also needs an IPv6 twin
+				if geoIPv6Twin(rule, ipv6Sets) {
+					v6Expr, err := buildRuleExpressionFor(rule, ipv6Sets, true)
+					if err != nil {
+						return nil, err
+					}
+					sb.AddRule(chainName, v6Expr, ruleComment)
+				}
 			}
 		}
 
@@ -1153,8 +1189,129 @@ func buildZoneMapForScript(cfg *Config) map[string][]string {
 // BuildRuleExpression converts a PolicyRule to an nft rule expression string.
 // Exported for use by the API layer to show generated syntax.
 func BuildRuleExpression(rule config.PolicyRule) (string, error) {
+	return buildRuleExpression(rule, nil)
+}
+
+// ipv6SetNames returns the sets that hold IPv6 addresses, which rules must
+// match with ip6 rather than ip.
+func ipv6SetNames(cfg *Config) map[string]bool {
+	sets := make(map[string]bool)
+	for _, ipset := range cfg.IPSets {
+		if ipset.Type == "ipv6_addr" {
+			sets[ipset.Name] = true
+		}
+	}
+	for _, iface := range cfg.Interfaces {
+		if iface.PDFrom != "" {
+			sets[config.DelegatedPrefixSetName(iface.Name)] = true
+		}
+	}
+	return sets
+}
+
+// geoIPSetNames returns the IPv4 and IPv6 set names for a country code.
+func geoIPSetNames(code string) (v4Set, v6Set string) {
+	code = strings.ToUpper(code)
+	return "geoip_country_" + code, "geoip_country_" + code + "_v6"
+}
+
+// isCountryCode reports whether s is an ISO 3166-1 alpha-2 code in either case.
+func isCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range strings.ToUpper(s) {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// geoIPCountries returns the sorted, uppercased country codes referenced by
+// enabled policy rules and by geo blocking protections.
+func geoIPCountries(cfg *Config) ([]string, error) {
+	used := make(map[string]bool)
+	add := func(code string) error {
+		if !isCountryCode(code) {
+			return fmt.Errorf("invalid country code: %s", code)
+		}
+		used[strings.ToUpper(code)] = true
+		return nil
+	}
+
+	for _, pol := range cfg.Policies {
+		if pol.Disabled {
+			continue
+		}
+		for _, rule := range pol.Rules {
+			if rule.Disabled {
+				continue
+			}
+			for _, code := range []string{rule.SourceCountry, rule.DestCountry} {
+				if code == "" {
+					continue
+				}
+				if err := add(code); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for _, p := range cfg.Protections {
+		if !p.GeoBlocking {
+			continue
+		}
+		for _, code := range append(append([]string(nil), p.BlockedCountries...), p.AllowedCountries...) {
+			if err := add(code); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	codes := make([]string, 0, len(used))
+	for code := range used {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes, nil
+}
+
+// geoIPv6Twin reports whether a rule matching a country should also be
+// emitted against the IPv6 country sets. Rules that match IPv4 addresses
+// or IPv4 sets can never match IPv6 traffic, so they get no twin.
+func geoIPv6Twin(rule config.PolicyRule, ipv6Sets map[string]bool) bool {
+	if rule.SourceCountry == "" && rule.DestCountry == "" {
+		return false
+	}
+	if rule.SrcIP != "" || rule.DestIP != "" {
+		return false
+	}
+	if rule.SrcIPSet != "" && !ipv6Sets[rule.SrcIPSet] {
+		return false
+	}
+	if rule.DestIPSet != "" && !ipv6Sets[rule.DestIPSet] {
+		return false
+	}
+	return true
+}
+
+func buildRuleExpression(rule config.PolicyRule, ipv6Sets map[string]bool) (string, error) {
+	return buildRuleExpressionFor(rule, ipv6Sets, false)
+}
+
+// buildRuleExpressionFor builds the expression for a rule, matching any
+// country against the IPv6 country sets if geoIPv6 is set.
+func buildRuleExpressionFor(rule config.PolicyRule, ipv6Sets map[string]bool, geoIPv6 bool) (string, error) {
 	var parts []string
 
+	setFamily := func(name string) string {
+		if ipv6Sets[name] {
+			return "ip6"
+		}
+		return "ip"
+	}
+
 	// Protocol
 	if rule.Protocol != "" && rule.Protocol != "any" {
 		parts = append(parts, fmt.Sprintf("meta l4proto %s", rule.Protocol))
@@ -1170,7 +1327,7 @@ func BuildRuleExpression(rule config.PolicyRule) (string, error) {
 		if !isValidIdentifier(rule.SrcIPSet) {
 			return "", fmt.Errorf("invalid source ipset name: %s", rule.SrcIPSet)
 		}
-		parts = append(parts, fmt.Sprintf("ip saddr @%s", quote(rule.SrcIPSet)))
+		parts = append(parts, fmt.Sprintf("%s saddr @%s", setFamily(rule.SrcIPSet), quote(rule.SrcIPSet)))
 	}
 
 	// Destination IP
@@ -1183,7 +1340,7 @@ func BuildRuleExpression(rule config.PolicyRule) (string, error) {
 		if !isValidIdentifier(rule.DestIPSet) {
 			return "", fmt.Errorf("invalid dest ipset name: %s", rule.DestIPSet)
 		}
-		parts = append(parts, fmt.Sprintf("ip daddr @%s", quote(rule.DestIPSet)))
+		parts = append(parts, fmt.Sprintf("%s daddr @%s", setFamily(rule.DestIPSet), quote(rule.DestIPSet)))
 	}
 
 	// Connection state (ct state) - CRITICAL for stateful filtering!
@@ -1207,15 +1364,26 @@ func BuildRuleExpression(rule config.PolicyRule) (string, error) {
 	}
 
 	// GeoIP matching (uses country-specific sets like @geoip_country_CN)
-	// These sets must be populated by the GeoIPManager when configuration is applied
+	// These sets are populated from the GeoIP database when configuration is applied
+	countryMatch := func(dir, code string) string {
+		v4Set, v6Set := geoIPSetNames(code)
+		if geoIPv6 {
+			return fmt.Sprintf("ip6 %s @%s", dir, v6Set)
+		}
+		return fmt.Sprintf("ip %s @%s", dir, v4Set)
+	}
 	if rule.SourceCountry != "" {
 		// Country codes should be uppercase ISO 3166-1 alpha-2 (e.g., "US", "CN", "RU")
-		countryCode := strings.ToUpper(rule.SourceCountry)
-		parts = append(parts, fmt.Sprintf("ip saddr @geoip_country_%s", countryCode))
+		if !isCountryCode(rule.SourceCountry) {
+			return "", fmt.Errorf("invalid source country code: %s", rule.SourceCountry)
+		}
+		parts = append(parts, countryMatch("saddr", rule.SourceCountry))
 	}
 	if rule.DestCountry != "" {
-		countryCode := strings.ToUpper(rule.DestCountry)
-		parts = append(parts, fmt.Sprintf("ip daddr @geoip_country_%s", countryCode))
+		if !isCountryCode(rule.DestCountry) {
+			return "", fmt.Errorf("invalid dest country code: %s", rule.DestCountry)
+		}
+		parts = append(parts, countryMatch("daddr", rule.DestCountry))
 	}
 
 	// Time-of-day matching (meta hour/day, requires kernel 5.4+)
@@ -1262,10 +1430,12 @@ func BuildRuleExpression(rule config.PolicyRule) (string, error) {
 		action = "drop"
 	case "reject":
 		action = "reject"
+	case "inspect":
+		action = "jump " + ipsChain
 	}
 
 	// Add logging if action is drop/reject
-	if action != "accept" {
+	if action == "drop" || action == "reject" {
 		// Log rule
 		parts = append(parts, "limit rate 10/minute log group 0 prefix \"DROP_RULE: \"")
 	}
@@ -1360,6 +1530,61 @@ func addProtectionRules(cfg *Config, sb *ScriptBuilder) {
 			sb.AddRule("protection", fmt.Sprintf("%smeta l4proto icmp limit rate 10/minute log group 0 prefix \"DROP_ICMPFLOOD: \" counter drop", ifaceMatch))
 		}
 	}
+
+	addGeoBlockingRules(cfg, sb)
+}
+
+// geoIPExemptSources carry no country, so allowlists must not drop them:
+// link-local, private and CGNAT sources used by the upstream network itself.
+var geoIPExemptSources = []string{
+	"ip saddr { 10.0.0.0/8, 100.64.0.0/10, 169.254.0.0/16, 172.16.0.0/12, 192.168.0.0/16 }",
+	"ip6 saddr fe80::/10",
+}
+
+// addGeoBlockingRules drops new connections from blocked countries, or from
+// all but the allowed countries, on interfaces with geo blocking. Its chain
+// runs after conntrack (priority -150) so replies to connections opened from
+// inside are never dropped.
+func addGeoBlockingRules(cfg *Config, sb *ScriptBuilder) {
+	chainAdded := false
+	for i, p := range cfg.Protections {
+		if !p.GeoBlocking || (len(p.BlockedCountries) == 0 && len(p.AllowedCountries) == 0) {
+			continue
+		}
+		if !chainAdded {
+			sb.AddChain("protection_geoip", "filter", "prerouting", -150, "accept", "[protection] GeoIP")
+			chainAdded = true
+		}
+
+		ifaceMatch := ""
+		if p.Interface != "" && p.Interface != "*" {
+			ifaceMatch = fmt.Sprintf("iifname %q ", p.Interface)
+		}
+
+		for _, code := range p.BlockedCountries {
+			v4Set, v6Set := geoIPSetNames(code)
+			sb.AddRule("protection_geoip", fmt.Sprintf("%sct state new ip saddr @%s counter drop", ifaceMatch, v4Set))
+			sb.AddRule("protection_geoip", fmt.Sprintf("%sct state new ip6 saddr @%s counter drop", ifaceMatch, v6Set))
+		}
+
+		// An allowlist over empty sets would cut the interface off, so it
+		// only takes effect once the database has been loaded
+		if len(p.AllowedCountries) == 0 || cfg.GeoIPNetworks == nil {
+			continue
+		}
+		allowChain := fmt.Sprintf("protection_geoip_allow_%d", i)
+		sb.AddChain(allowChain, "", "", 0, "", fmt.Sprintf("[protection] GeoIP allowlist %s", p.Name))
+		for _, code := range p.AllowedCountries {
+			v4Set, v6Set := geoIPSetNames(code)
+			sb.AddRule(allowChain, fmt.Sprintf("ip saddr @%s return", v4Set))
+			sb.AddRule(allowChain, fmt.Sprintf("ip6 saddr @%s return", v6Set))
+		}
+		for _, src := range geoIPExemptSources {
+			sb.AddRule(allowChain, src+" return")
+		}
+		sb.AddRule(allowChain, "counter drop")
+		sb.AddRule("protection_geoip", fmt.Sprintf("%sct state new jump %s", ifaceMatch, allowChain))
+	}
 }
 
 // BuildNATTableScript builds the NAT table script from config.
@@ -1,8 +1,11 @@
 package firewall
 
 import (
+	"net"
+	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
 	"grimm.is/glacic/internal/config"
 )
@@ -59,7 +62,31 @@ func TestRuleExpressionGeneration(t *testing.T) {
 			},
 			want: "meta l4proto udp ip saddr 10.0.0.0/24 ct state new udp dport 53 counter accept",
 		},
+		{
+			name: "Source Country",
+			rule: config.PolicyRule{
+				SourceCountry: "cn",
+				Action:        "accept",
+			},
+			want: "ip saddr @geoip_country_CN counter accept",
+		},
+		{
+			name: "Inspect",
+			rule: config.PolicyRule{
+				Protocol: "tcp",
+				DestPort: 80,
+				Action:   "inspect",
+			},
+			want: "meta l4proto tcp tcp dport 80 counter jump ips_inspect",
+		},
 		// Sad Paths
+		{
+			name: "Invalid Country Code",
+			rule: config.PolicyRule{
+				DestCountry: "C;",
+			},
+			wantErr: true,
+		},
 		{
 			name: "Invalid IPSet Name",
 			rule: config.PolicyRule{
@@ -385,3 +412,290 @@ func TestDNSSetFilterGeneration(t *testing.T) {
 		t.Errorf("Missing or incorrect yahoo_dns set definition (optimization check):\n%s", script)
 	}
 }
+
+func TestDelegatedPrefixSetGeneration(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", Zone: "WAN", DHCPv6: true, PrefixDelegation: true},
+			{Name: "eth1", Zone: "LAN", PDFrom: "eth0", PDSubnetID: 1},
+		},
+		Zones: []config.Zone{
+			{Name: "LAN", Interfaces: []string{"eth1"}},
+			{Name: "WAN", Interfaces: []string{"eth0"}},
+		},
+		IPSets: []config.IPSet{
+			{Name: "blocked", Type: "ipv4_addr", Entries: []string{"198.51.100.1"}},
+		},
+		Policies: []config.Policy{
+			{
+				From: "LAN", To: "WAN", Action: "drop",
+				Rules: []config.PolicyRule{
+					{SrcIPSet: config.DelegatedPrefixSetName("eth1"), Action: "accept"},
+					{SrcIPSet: "blocked", Action: "drop"},
+				},
+			},
+		},
+	}
+
+	fwCfg := FromGlobalConfig(cfg)
+	fwCfg.DelegatedPrefixes = map[string]string{"eth1": "2001:db8:0:11::/64"}
+
+	sb, err := BuildFilterTableScript(fwCfg, nil, "test_table", "")
+	if err != nil {
+		t.Fatalf("BuildFilterTableScript() error = %v", err)
+	}
+	script := sb.Build()
+
+	if !strings.Contains(script, `add set inet test_table pd_eth1 { type ipv6_addr; flags interval; comment "[pd] eth1"; }`) {
+		t.Errorf("Missing delegated prefix set definition:\n%s", script)
+	}
+	if !strings.Contains(script, "flush set inet test_table pd_eth1") {
+		t.Error("Delegated prefix set is not flushed before refilling")
+	}
+	if !strings.Contains(script, `add element inet test_table pd_eth1 { "2001:db8:0:11::/64" }`) {
+		t.Errorf("Missing delegated prefix element:\n%s", script)
+	}
+
+	// IPv6 sets must be matched with ip6, IPv4 sets with ip
+	if !strings.Contains(script, "ip6 saddr @pd_eth1") {
+		t.Error("Delegated prefix set not matched as IPv6")
+	}
+	if !strings.Contains(script, "ip saddr @blocked") {
+		t.Error("IPv4 set not matched as IPv4")
+	}
+}
+
+func TestGeoIPSetGeneration(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", Zone: "WAN"},
+			{Name: "eth1", Zone: "LAN"},
+		},
+		Zones: []config.Zone{
+			{Name: "LAN", Interfaces: []string{"eth1"}},
+			{Name: "WAN", Interfaces: []string{"eth0"}},
+		},
+		Policies: []config.Policy{
+			{
+				From: "WAN", To: "LAN", Action: "drop",
+				Rules: []config.PolicyRule{
+					{Name: "from-cn", SourceCountry: "cn", Action: "drop"},
+					{Name: "cn-host", SourceCountry: "CN", SrcIP: "192.0.2.1", Action: "accept"},
+				},
+			},
+		},
+		Protections: []config.InterfaceProtection{
+			{Name: "wan", Interface: "eth0", GeoBlocking: true, BlockedCountries: []string{"RU"}, AllowedCountries: []string{"us"}},
+			{Name: "lan", Interface: "eth1", BlockedCountries: []string{"FR"}},
+		},
+	}
+
+	fwCfg := FromGlobalConfig(cfg)
+	fwCfg.GeoIPNetworks = map[string][]netip.Prefix{
+		"CN": {netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("2001:db8:c::/48")},
+		"RU": {netip.MustParsePrefix("203.0.113.0/24")},
+		"US": nil,
+	}
+
+	sb, err := BuildFilterTableScript(fwCfg, nil, "test_table", "")
+	if err != nil {
+		t.Fatalf("BuildFilterTableScript() error = %v", err)
+	}
+	script := sb.Build()
+
+	for _, want := range []string{
+		`add set inet test_table geoip_country_CN { type ipv4_addr; flags interval; comment "[geoip] CN"; }`,
+		`add set inet test_table geoip_country_CN_v6 { type ipv6_addr; flags interval; comment "[geoip] CN"; }`,
+		"flush set inet test_table geoip_country_CN",
+		"flush set inet test_table geoip_country_CN_v6",
+		`add element inet test_table geoip_country_CN { 198.51.100.0/24 }`,
+		`add element inet test_table geoip_country_CN_v6 { 2001:db8:c::/48 }`,
+		"add set inet test_table geoip_country_RU_v6",
+		"add set inet test_table geoip_country_US ",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("missing %q in:\n%s", want, script)
+		}
+	}
+	// Protections without geo_blocking do not pull in their countries
+	if strings.Contains(script, "geoip_country_FR") {
+		t.Error("Country set created for protection without geo_blocking")
+	}
+
+	// A country rule is emitted for both families, unless it also matches IPv4
+	if !strings.Contains(script, "ip saddr @geoip_country_CN limit rate") || !strings.Contains(script, "ip6 saddr @geoip_country_CN_v6 limit rate") {
+		t.Errorf("Country rule not emitted for both families:\n%s", script)
+	}
+	if strings.Count(script, "@geoip_country_CN_v6") != 1 {
+		t.Error("IPv6 twin emitted for a rule matching an IPv4 address")
+	}
+
+	// Geo blocking only drops new connections, after conntrack
+	for _, want := range []string{
+		"add chain inet test_table protection_geoip { type filter hook prerouting priority -150; policy accept;",
+		`add rule inet test_table protection_geoip iifname "eth0" ct state new ip saddr @geoip_country_RU counter drop`,
+		`add rule inet test_table protection_geoip iifname "eth0" ct state new ip6 saddr @geoip_country_RU_v6 counter drop`,
+		`add rule inet test_table protection_geoip_allow_0 ip saddr @geoip_country_US return`,
+		`add rule inet test_table protection_geoip_allow_0 ip6 saddr fe80::/10 return`,
+		`add rule inet test_table protection_geoip_allow_0 counter drop`,
+		`add rule inet test_table protection_geoip iifname "eth0" ct state new jump protection_geoip_allow_0`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("missing %q in:\n%s", want, script)
+		}
+	}
+	// Sets must be declared before the chains that reference them
+	if strings.Index(script, "add set inet test_table geoip_country_RU ") > strings.Index(script, "protection_geoip iifname") {
+		t.Error("GeoIP sets declared after the rules using them")
+	}
+
+	// Without a database the allowlist is not enforced, the blocklist stays
+	fwCfg.GeoIPNetworks = nil
+	sb, err = BuildFilterTableScript(fwCfg, nil, "test_table", "")
+	if err != nil {
+		t.Fatalf("BuildFilterTableScript() error = %v", err)
+	}
+	script = sb.Build()
+	if strings.Contains(script, "protection_geoip_allow_0") {
+		t.Error("Allowlist enforced without a GeoIP database")
+	}
+	if !strings.Contains(script, "ct state new ip saddr @geoip_country_RU counter drop") {
+		t.Error("Blocklist dropped without a GeoIP database")
+	}
+
+	cfg.Protections[0].BlockedCountries = []string{"R;"}
+	if _, err := BuildFilterTableScript(FromGlobalConfig(cfg), nil, "test_table", ""); err == nil {
+		t.Error("Invalid blocked country accepted")
+	}
+}
+
+func TestCaptivePortalGeneration(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", Zone: "WAN"},
+			{Name: "eth1", Zone: "LAN"},
+			{Name: "eth2", Zone: "guest"},
+		},
+		Zones: []config.Zone{
+			{Name: "LAN", Interfaces: []string{"eth1"}},
+			{Name: "WAN", Interfaces: []string{"eth0"}},
+			{Name: "guest", Interfaces: []string{"eth2"}, Services: &config.ZoneServices{DNS: true, CaptivePortal: true}},
+		},
+		CaptivePortal: &config.CaptivePortalConfig{Port: 8880, TLSCert: "c", TLSKey: "k"},
+	}
+
+	sb, err := BuildFilterTableScript(FromGlobalConfig(cfg), nil, "test_table", "")
+	if err != nil {
+		t.Fatalf("BuildFilterTableScript() error = %v", err)
+	}
+	script := sb.Build()
+
+	for _, want := range []string{
+		`add set inet test_table portal_allowed_mac { type ether_addr; flags timeout; size 65535; comment "[portal] Authenticated clients"; }`,
+		`add set inet test_table portal_allowed_v4 { type ipv4_addr; flags timeout;`,
+		`add rule inet test_table input iifname { "eth2" } tcp dport { 8880, 2051 } accept`,
+		`add chain inet test_table portal_redirect { type nat hook prerouting priority -101;`,
+		`add rule inet test_table portal_redirect ether saddr @portal_allowed_mac return`,
+		`add rule inet test_table portal_redirect tcp dport 80 redirect to :8880`,
+		`add rule inet test_table forward iifname { "eth2" } jump portal_forward`,
+		`add rule inet test_table portal_forward ip6 saddr @portal_allowed_v6 return`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("missing %q in:\n%s", want, script)
+		}
+	}
+	// Sessions outlive reloads
+	if strings.Contains(script, "flush set inet test_table portal_allowed") {
+		t.Error("portal sets are flushed on reload")
+	}
+
+	// No portal zone, no portal rules
+	cfg.Zones[2].Services.CaptivePortal = false
+	sb, err = BuildFilterTableScript(FromGlobalConfig(cfg), nil, "test_table", "")
+	if err != nil {
+		t.Fatalf("BuildFilterTableScript() error = %v", err)
+	}
+	if strings.Contains(sb.Build(), "portal_") {
+		t.Error("portal rules generated without a captive portal zone")
+	}
+}
+
+func TestIPSGeneration(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", Zone: "WAN"},
+			{Name: "eth1", Zone: "LAN"},
+		},
+		Zones: []config.Zone{
+			{Name: "LAN", Interfaces: []string{"eth1"}},
+			{Name: "WAN", Interfaces: []string{"eth0"}},
+		},
+		Policies: []config.Policy{{
+			From:  "LAN",
+			To:    "WAN",
+			Rules: []config.PolicyRule{{Name: "web", Protocol: "tcp", DestPort: 80, Action: "inspect"}},
+		}},
+		IPS: &config.IPSConfig{Enabled: true, Queue: 210},
+	}
+
+	build := func() string {
+		sb, err := BuildFilterTableScript(FromGlobalConfig(cfg), nil, "test_table", "")
+		if err != nil {
+			t.Fatalf("BuildFilterTableScript() error = %v", err)
+		}
+		return sb.Build()
+	}
+
+	script := build()
+	for _, want := range []string{
+		`add rule inet test_table ips_inspect ct label set 127 counter queue num 210 comment`,
+		`add rule inet test_table forward ct label 127 jump ips_inspect`,
+		`add rule inet test_table policy_LAN_WAN meta l4proto tcp tcp dport 80 counter jump ips_inspect`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("missing %q in:\n%s", want, script)
+		}
+	}
+	// Inspected connections must not be accepted by the stateful rule first
+	if strings.Index(script, "forward ct label 127") > strings.Index(script, "forward ct state established,related accept") {
+		t.Error("IPS rule after the stateful accept")
+	}
+
+	cfg.IPS.FailOpen = true
+	if script := build(); !strings.Contains(script, "queue num 210 bypass") {
+		t.Error("fail_open does not bypass the queue")
+	}
+
+	cfg.IPS = nil
+	script = build()
+	if !strings.Contains(script, "add rule inet test_table ips_inspect accept") || strings.Contains(script, "queue num 210") {
+		t.Error("inspect rules without IPS should accept")
+	}
+
+	cfg.Policies = nil
+	if strings.Contains(build(), "ips_inspect") {
+		t.Error("IPS chain generated without IPS")
+	}
+}
+
+func TestPortalAuthorizeScript(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	script := portalAuthorizeScript("glacic", mac, net.ParseIP("192.168.50.23"), 2*time.Hour)
+	want := strings.Join([]string{
+		"add element inet glacic portal_allowed_mac { 02:00:00:00:00:01 }",
+		"delete element inet glacic portal_allowed_mac { 02:00:00:00:00:01 }",
+		"add element inet glacic portal_allowed_mac { 02:00:00:00:00:01 timeout 7200s }",
+		"add element inet glacic portal_allowed_v4 { 192.168.50.23 }",
+		"delete element inet glacic portal_allowed_v4 { 192.168.50.23 }",
+		"add element inet glacic portal_allowed_v4 { 192.168.50.23 timeout 7200s }",
+	}, "\n") + "\n"
+	if script != want {
+		t.Errorf("got:\n%s\nwant:\n%s", script, want)
+	}
+
+	// Clients without a known MAC are admitted by address only
+	script = portalAuthorizeScript("glacic", nil, net.ParseIP("2001:db8::23"), time.Second)
+	if strings.Contains(script, "portal_allowed_mac") || !strings.Contains(script, "portal_allowed_v6 { 2001:db8::23 timeout 60s }") {
+		t.Errorf("unexpected script:\n%s", script)
+	}
+}
@@ -0,0 +1,426 @@
+// Package ips implements the inline intrusion prevention engine behind the
+// "inspect" policy action. Packets of inspected connections arrive through
+// NFQUEUE and are matched against Suricata/Snort-compatible signatures;
+// drop signatures end the connection, and every match raises an alert on
+// the event hub and through notifications.
+package ips
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/events"
+	"grimm.is/glacic/internal/logging"
+)
+
+// NotificationDispatcher abstracts the notification system
+type NotificationDispatcher interface {
+	SendSimple(title, message, level string)
+}
+
+// defaultVars are the variables signature sets commonly expect. The
+// config's vars override them.
+var defaultVars = map[string]string{
+	"HOME_NET":        "[10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7]",
+	"EXTERNAL_NET":    "!$HOME_NET",
+	"HTTP_SERVERS":    "$HOME_NET",
+	"SMTP_SERVERS":    "$HOME_NET",
+	"SQL_SERVERS":     "$HOME_NET",
+	"DNS_SERVERS":     "$HOME_NET",
+	"TELNET_SERVERS":  "$HOME_NET",
+	"SSH_SERVERS":     "$HOME_NET",
+	"HTTP_PORTS":      "80",
+	"SHELLCODE_PORTS": "!80",
+	"ORACLE_PORTS":    "1521",
+	"SSH_PORTS":       "22",
+	"FTP_PORTS":       "21",
+	"FILE_DATA_PORTS": "[$HTTP_PORTS,110,143]",
+}
+
+const (
+	// maxRecentAlerts is how many alerts Alerts returns
+	maxRecentAlerts = 100
+	// notifyInterval limits notifications and log lines per signature
+	notifyInterval = 5 * time.Minute
+)
+
+// Alert is a signature match.
+type Alert struct {
+	Time      time.Time `json:"time"`
+	SID       int       `json:"sid"`
+	Rev       int       `json:"rev,omitempty"`
+	Msg       string    `json:"msg"`
+	Classtype string    `json:"classtype,omitempty"`
+	Priority  int       `json:"priority,omitempty"`
+	Action    string    `json:"action"`
+	Dropped   bool      `json:"dropped"`
+	Src       string    `json:"src"`
+	Dst       string    `json:"dst"`
+	Protocol  string    `json:"protocol"`
+}
+
+// Stats are the engine's counters.
+type Stats struct {
+	Rules   int    `json:"rules"`
+	Skipped int    `json:"skipped"`
+	Flows   int    `json:"flows"`
+	Packets uint64 `json:"packets"`
+	Alerts  uint64 `json:"alerts"`
+	Dropped uint64 `json:"dropped"`
+	Errors  uint64 `json:"errors"`
+}
+
+// Engine matches packets against the loaded signatures.
+type Engine struct {
+	mu        sync.RWMutex
+	rules     []*Rule
+	skipped   int
+	alertOnly bool
+	failOpen  bool
+
+	flows  *flowTable
+	logger *logging.Logger
+
+	hub        *events.Hub
+	dispatcher NotificationDispatcher
+
+	alertMu    sync.Mutex
+	recent     []Alert
+	lastNotify map[int]time.Time
+
+	packets atomic.Uint64
+	alerts  atomic.Uint64
+	dropped atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// NewEngine creates an engine without signatures; call Load to add them.
+func NewEngine(cfg *config.IPSConfig, logger *logging.Logger) *Engine {
+	if logger == nil {
+		logger = logging.WithComponent("ips")
+	}
+	return &Engine{
+		alertOnly:  cfg.AlertOnly,
+		failOpen:   cfg.FailOpen,
+		flows:      newFlowTable(),
+		logger:     logger,
+		lastNotify: make(map[int]time.Time),
+	}
+}
+
+// SetEventHub sets the event hub alerts are published to
+func (e *Engine) SetEventHub(hub *events.Hub) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hub = hub
+}
+
+// SetDispatcher sets the notification dispatcher
+func (e *Engine) SetDispatcher(d NotificationDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = d
+}
+
+// Load reads the configured rule files and inline rules. Signatures that
+// don't parse or use unsupported keywords are skipped and logged; only
+// unreadable files are an error.
+func (e *Engine) Load(cfg *config.IPSConfig) error {
+	vars := make(map[string]string, len(defaultVars)+len(cfg.Vars))
+	for name, value := range defaultVars {
+		vars[name] = value
+	}
+	for name, value := range cfg.Vars {
+		vars[name] = value
+	}
+
+	var rules []*Rule
+	var errs []error
+	for _, pattern := range cfg.RuleFiles {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("rule files %q: %w", pattern, err)
+		}
+		if len(paths) == 0 {
+			e.logger.Warn("no rule files match", "pattern", pattern)
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			rs, perrs := ParseRules(f, vars)
+			f.Close()
+			rules = append(rules, rs...)
+			for _, err := range perrs {
+				errs = append(errs, fmt.Errorf("%s:%w", path, err))
+			}
+		}
+	}
+	rs, perrs := ParseRules(strings.NewReader(cfg.Rules), vars)
+	rules = append(rules, rs...)
+	for _, err := range perrs {
+		errs = append(errs, fmt.Errorf("rules:%w", err))
+	}
+
+	// Drop disabled and duplicate signatures, keeping the first of a sid
+	seen := make(map[int]bool)
+	for _, sid := range cfg.DisableSIDs {
+		seen[sid] = true
+	}
+	kept := rules[:0]
+	for _, r := range rules {
+		if seen[r.SID] {
+			continue
+		}
+		seen[r.SID] = true
+		kept = append(kept, r)
+	}
+
+	rejects := 0
+	for _, err := range errs {
+		e.logger.Debug("skipped signature", "error", err)
+		if errors.Is(err, errReject) {
+			rejects++
+		}
+	}
+	if rejects > 0 {
+		e.logger.Warn("reject signatures are not supported and were skipped; use drop", "count", rejects)
+	}
+	if len(errs) > 0 {
+		e.logger.Warn("some signatures were skipped", "count", len(errs), "first", errs[0].Error())
+	}
+	e.logger.Info("signatures loaded", "count", len(kept))
+
+	e.SetRules(kept)
+	e.mu.Lock()
+	e.skipped = len(errs)
+	e.mu.Unlock()
+	return nil
+}
+
+// SetRules replaces the signatures.
+func (e *Engine) SetRules(rules []*Rule) {
+	sorted := slices.Clone(rules)
+	slices.SortStableFunc(sorted, func(a, b *Rule) int { return int(a.Action) - int(b.Action) })
+	e.mu.Lock()
+	e.rules = sorted
+	e.mu.Unlock()
+}
+
+// ParseRules reads signatures, one per line. Lines ending in a backslash
+// continue on the next line. Signatures that fail to parse are returned as
+// errors prefixed with their line number; the rest are still returned.
+func ParseRules(r io.Reader, vars map[string]string) ([]*Rule, []error) {
+	var rules []*Rule
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var pending strings.Builder
+	lineNo, startLine := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if pending.Len() == 0 {
+			startLine = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			pending.WriteString(strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		pending.WriteString(line)
+		text := strings.TrimSpace(pending.String())
+		pending.Reset()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := ParseRule(text, vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", startLine, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("%d: %w", lineNo+1, err))
+	}
+	return rules, errs
+}
+
+// Inspect returns the verdict for a packet, starting at its IP header:
+// true to accept it. Packets that can't be decoded are accepted, as no
+// signature could match them; an internal failure follows fail_open.
+func (e *Engine) Inspect(b []byte) (accept bool) {
+	e.packets.Add(1)
+	defer func() {
+		if r := recover(); r != nil {
+			e.errors.Add(1)
+			e.logger.Error("inspection failed", "error", r)
+			accept = e.failOpen
+		}
+	}()
+
+	p, ok := decodePacket(b)
+	if !ok {
+		return true
+	}
+	now := clock.Now()
+	st := e.flows.track(p, now)
+	if st.dropped {
+		e.dropped.Add(1)
+		return false
+	}
+
+	e.mu.RLock()
+	rules, alertOnly := e.rules, e.alertOnly
+	e.mu.RUnlock()
+
+	for _, r := range rules {
+		if !r.match(p, st) {
+			continue
+		}
+		if r.Action == ActionPass {
+			return true
+		}
+		block := r.Action == ActionDrop && !alertOnly
+		e.alert(r, p, block, now)
+		if block {
+			e.flows.drop(st.key)
+			e.dropped.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether a signature matches a packet.
+func (r *Rule) match(p *packet, st flowState) bool {
+	switch r.proto {
+	case protoAny:
+	case protoICMP:
+		if p.proto != protoICMP && p.proto != protoICMPv6 {
+			return false
+		}
+	default:
+		if p.proto != r.proto {
+			return false
+		}
+	}
+	if !r.matchEndpoints(p.src, p.sport, p.dst, p.dport) &&
+		!(r.bidirectional && r.matchEndpoints(p.dst, p.dport, p.src, p.sport)) {
+		return false
+	}
+	if (r.flow.toServer && !st.toServer) || (r.flow.toClient && st.toServer) {
+		return false
+	}
+	if (r.flow.established && !st.established) || (r.flow.notEstablished && st.established) {
+		return false
+	}
+	if r.dsize != nil && !r.dsize.match(len(p.payload)) {
+		return false
+	}
+	return matchPayload(r.matches, p.payload)
+}
+
+func (r *Rule) matchEndpoints(src netip.Addr, sport uint16, dst netip.Addr, dport uint16) bool {
+	return r.src.match(src) && r.dst.match(dst) && r.sport.match(sport) && r.dport.match(dport)
+}
+
+// alert records a match and reports it.
+func (e *Engine) alert(r *Rule, p *packet, dropped bool, now time.Time) {
+	e.alerts.Add(1)
+	a := Alert{
+		Time:      now,
+		SID:       r.SID,
+		Rev:       r.Rev,
+		Msg:       r.Msg,
+		Classtype: r.Classtype,
+		Priority:  r.Priority,
+		Action:    r.Action.String(),
+		Dropped:   dropped,
+		Src:       netip.AddrPortFrom(p.src, p.sport).String(),
+		Dst:       netip.AddrPortFrom(p.dst, p.dport).String(),
+		Protocol:  protoName(p.proto),
+	}
+
+	e.alertMu.Lock()
+	e.recent = append(e.recent, a)
+	if len(e.recent) > maxRecentAlerts {
+		e.recent = e.recent[len(e.recent)-maxRecentAlerts:]
+	}
+	notify := now.Sub(e.lastNotify[r.SID]) >= notifyInterval
+	if notify {
+		e.lastNotify[r.SID] = now
+	}
+	e.alertMu.Unlock()
+
+	e.mu.RLock()
+	hub, dispatcher := e.hub, e.dispatcher
+	e.mu.RUnlock()
+
+	if hub != nil {
+		hub.EmitIPSAlert(events.IPSAlertData{
+			SID:       a.SID,
+			Rev:       a.Rev,
+			Msg:       a.Msg,
+			Classtype: a.Classtype,
+			Priority:  a.Priority,
+			Action:    a.Action,
+			Dropped:   a.Dropped,
+			SrcIP:     p.src.String(),
+			DstIP:     p.dst.String(),
+			SrcPort:   p.sport,
+			DstPort:   p.dport,
+			Protocol:  a.Protocol,
+		})
+	}
+	if !notify {
+		return
+	}
+
+	msg := fmt.Sprintf("[%d:%d] %s (%s %s -> %s)", a.SID, a.Rev, a.Msg, a.Protocol, a.Src, a.Dst)
+	e.logger.Warn("signature matched", "sid", a.SID, "msg", a.Msg, "src", a.Src, "dst", a.Dst, "dropped", dropped)
+	if dispatcher != nil {
+		title, level := "Intrusion Detected", "warning"
+		if dropped {
+			title, level = "Intrusion Blocked", "critical"
+		}
+		go dispatcher.SendSimple(title, msg, level)
+	}
+}
+
+// Alerts returns the most recent alerts, oldest first.
+func (e *Engine) Alerts() []Alert {
+	e.alertMu.Lock()
+	defer e.alertMu.Unlock()
+	return slices.Clone(e.recent)
+}
+
+// Stats returns the engine's counters.
+func (e *Engine) Stats() Stats {
+	e.mu.RLock()
+	rules, skipped := len(e.rules), e.skipped
+	e.mu.RUnlock()
+	return Stats{
+		Rules:   rules,
+		Skipped: skipped,
+		Flows:   e.flows.len(),
+		Packets: e.packets.Load(),
+		Alerts:  e.alerts.Load(),
+		Dropped: e.dropped.Load(),
+		Errors:  e.errors.Load(),
+	}
+}
@@ -0,0 +1,268 @@
+package ips
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/events"
+	"grimm.is/glacic/internal/logging"
+)
+
+type notification struct {
+	title, message, level string
+}
+
+type fakeDispatcher chan notification
+
+func (d fakeDispatcher) SendSimple(title, message, level string) {
+	d <- notification{title, message, level}
+}
+
+// l4Header builds a TCP or UDP header followed by the payload.
+func l4Header(proto uint8, sport, dport uint16, flags uint8, payload string) []byte {
+	var h []byte
+	if proto == protoTCP {
+		h = make([]byte, 20)
+		h[12] = 5 << 4
+		h[13] = flags
+	} else {
+		h = make([]byte, 8)
+		binary.BigEndian.PutUint16(h[4:6], uint16(8+len(payload)))
+	}
+	binary.BigEndian.PutUint16(h[0:2], sport)
+	binary.BigEndian.PutUint16(h[2:4], dport)
+	return append(h, payload...)
+}
+
+func ipv4Packet(proto uint8, src, dst string, sport, dport uint16, flags uint8, payload string) []byte {
+	l4 := l4Header(proto, sport, dport, flags, payload)
+	b := make([]byte, 20, 20+len(l4))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(20+len(l4)))
+	b[8] = 64
+	b[9] = proto
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:16], s[:])
+	copy(b[16:20], d[:])
+	return append(b, l4...)
+}
+
+func ipv6Packet(proto uint8, src, dst string, sport, dport uint16, flags uint8, payload string) []byte {
+	l4 := l4Header(proto, sport, dport, flags, payload)
+	b := make([]byte, 40, 40+len(l4))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(l4)))
+	b[6] = proto
+	b[7] = 64
+	s, d := netip.MustParseAddr(src).As16(), netip.MustParseAddr(dst).As16()
+	copy(b[8:24], s[:])
+	copy(b[24:40], d[:])
+	return append(b, l4...)
+}
+
+func newTestEngine(t *testing.T, cfg *config.IPSConfig) *Engine {
+	t.Helper()
+	e := NewEngine(cfg, logging.WithComponent("ips"))
+	if err := e.Load(cfg); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return e
+}
+
+func TestEngine_DropsFlow(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled: true,
+		Rules:   `drop tcp $EXTERNAL_NET any -> $HOME_NET 80 (msg:"Path traversal"; flow:established,to_server; content:"../"; classtype:web-application-attack; sid:1000001; rev:1;)`,
+	})
+	hub := events.NewHub()
+	alerts := hub.Subscribe(10, events.EventIPSAlert)
+	e.SetEventHub(hub)
+	notifications := make(fakeDispatcher, 10)
+	e.SetDispatcher(notifications)
+
+	const client, server = "203.0.113.5", "192.168.1.10"
+	steps := []struct {
+		name   string
+		packet []byte
+		accept bool
+	}{
+		{"syn", ipv4Packet(protoTCP, client, server, 40000, 80, tcpSYN, ""), true},
+		{"syn-ack", ipv4Packet(protoTCP, server, client, 80, 40000, tcpSYN|tcpACK, ""), true},
+		{"ack", ipv4Packet(protoTCP, client, server, 40000, 80, tcpACK, ""), true},
+		{"benign request", ipv4Packet(protoTCP, client, server, 40000, 80, tcpACK, "GET /index.html HTTP/1.1\r\n"), true},
+		{"attack", ipv4Packet(protoTCP, client, server, 40000, 80, tcpACK, "GET /../../etc/passwd HTTP/1.1\r\n"), false},
+		{"rest of flow", ipv4Packet(protoTCP, server, client, 80, 40000, tcpACK, "HTTP/1.1 200 OK\r\n"), false},
+	}
+	for _, step := range steps {
+		if got := e.Inspect(step.packet); got != step.accept {
+			t.Errorf("%s: Inspect() = %v, want %v", step.name, got, step.accept)
+		}
+	}
+
+	stats := e.Stats()
+	if stats.Packets != 6 || stats.Alerts != 1 || stats.Dropped != 2 {
+		t.Errorf("Stats() = %+v, want 6 packets, 1 alert, 2 dropped", stats)
+	}
+
+	select {
+	case ev := <-alerts:
+		data, ok := ev.Data.(events.IPSAlertData)
+		if !ok {
+			t.Fatalf("event data is %T", ev.Data)
+		}
+		if data.SID != 1000001 || !data.Dropped || data.SrcIP != client || data.DstPort != 80 {
+			t.Errorf("unexpected alert %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert event")
+	}
+
+	select {
+	case n := <-notifications:
+		if n.level != "critical" {
+			t.Errorf("notification level = %q, want critical", n.level)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+}
+
+func TestEngine_AlertOnly(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled:   true,
+		AlertOnly: true,
+		Rules:     `drop tcp any any -> any any (msg:"Evil"; content:"evil"; sid:1;)`,
+	})
+
+	if !e.Inspect(ipv4Packet(protoTCP, "10.0.0.1", "10.0.0.2", 40000, 80, tcpACK, "evil")) {
+		t.Error("alert_only engine dropped a packet")
+	}
+	alerts := e.Alerts()
+	if len(alerts) != 1 || alerts[0].Dropped || alerts[0].Action != "drop" {
+		t.Errorf("Alerts() = %+v, want one undropped drop alert", alerts)
+	}
+}
+
+func TestEngine_PassRule(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled: true,
+		Rules: `
+drop tcp any any -> any any (msg:"Evil"; content:"evil"; sid:1;)
+pass tcp 10.0.0.5 any -> any any (msg:"Scanner"; sid:2;)
+`,
+	})
+
+	if !e.Inspect(ipv4Packet(protoTCP, "10.0.0.5", "10.0.0.2", 40000, 80, tcpACK, "evil")) {
+		t.Error("pass rule didn't take precedence over drop")
+	}
+	if e.Inspect(ipv4Packet(protoTCP, "10.0.0.6", "10.0.0.2", 40000, 80, tcpACK, "evil")) {
+		t.Error("drop rule didn't match")
+	}
+	if got := e.Stats().Alerts; got != 1 {
+		t.Errorf("alerts = %d, want 1", got)
+	}
+}
+
+func TestEngine_RejectNotDrop(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled: true,
+		Rules: `
+drop tcp any any -> any 80 (msg:"Dropped"; content:"evil"; sid:1;)
+reject tcp any any -> any 81 (msg:"Rejected"; content:"evil"; sid:2;)
+`,
+	})
+
+	// The reject signature is skipped at load rather than silently dropping
+	if stats := e.Stats(); stats.Rules != 1 || stats.Skipped != 1 {
+		t.Errorf("Stats() = %+v, want 1 rule and 1 skipped", stats)
+	}
+	if e.Inspect(ipv4Packet(protoTCP, "10.0.0.1", "10.0.0.2", 40000, 80, tcpACK, "evil")) {
+		t.Error("drop rule didn't drop")
+	}
+	if !e.Inspect(ipv4Packet(protoTCP, "10.0.0.1", "10.0.0.2", 40001, 81, tcpACK, "evil")) {
+		t.Error("reject rule dropped a packet")
+	}
+	if alerts := e.Alerts(); len(alerts) != 1 || alerts[0].SID != 1 {
+		t.Errorf("Alerts() = %+v, want only the drop alert", alerts)
+	}
+}
+
+func TestEngine_IPv6(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled: true,
+		Rules: `
+alert udp any any -> any 53 (msg:"Suspicious DNS"; content:"|03|bad|07|example"; nocase; sid:10;)
+alert udp any any <> any any (msg:"Either way"; content:"ping"; sid:11;)
+`,
+	})
+
+	if !e.Inspect(ipv6Packet(protoUDP, "fd00::2", "2001:db8::53", 5353, 53, 0, "\x12\x34\x01\x00\x03BAD\x07example\x03com")) {
+		t.Error("alert rule dropped a packet")
+	}
+	e.Inspect(ipv6Packet(protoUDP, "2001:db8::53", "fd00::2", 7, 9999, 0, "ping"))
+
+	alerts := e.Alerts()
+	if len(alerts) != 2 || alerts[0].SID != 10 || alerts[1].SID != 11 {
+		t.Fatalf("Alerts() = %+v, want sids 10 and 11", alerts)
+	}
+	if alerts[0].Src != "[fd00::2]:5353" || alerts[0].Protocol != "UDP" {
+		t.Errorf("unexpected alert %+v", alerts[0])
+	}
+}
+
+func TestEngine_UndecodablePacket(t *testing.T) {
+	e := newTestEngine(t, &config.IPSConfig{
+		Enabled: true,
+		Rules:   `drop ip any any -> any any (msg:"All"; sid:1;)`,
+	})
+
+	for _, b := range [][]byte{nil, {0x45, 0x00}, {0x70, 0x00, 0x00}} {
+		if !e.Inspect(b) {
+			t.Errorf("Inspect(%x) dropped an undecodable packet", b)
+		}
+	}
+}
+
+func TestEngine_Load(t *testing.T) {
+	dir := t.TempDir()
+	rules := `# Example signatures
+alert tcp any any -> any 80 (msg:"One"; \
+    content:"one"; sid:1;)
+alert tcp any any -> any 80 (msg:"Unsupported"; http.uri; content:"/a"; sid:2;)
+alert tcp any any -> any 80 (msg:"Disabled"; content:"three"; sid:3;)
+alert tcp any any -> any 80 (msg:"Duplicate"; content:"four"; sid:1;)
+`
+	if err := os.WriteFile(filepath.Join(dir, "local.rules"), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.IPSConfig{
+		Enabled:     true,
+		RuleFiles:   []string{filepath.Join(dir, "*.rules")},
+		Rules:       `alert tcp $LAN any -> any any (msg:"Inline"; content:"five"; sid:5;)`,
+		Vars:        map[string]string{"LAN": "10.1.0.0/16"},
+		DisableSIDs: []int{3},
+	}
+	e := newTestEngine(t, cfg)
+
+	stats := e.Stats()
+	if stats.Rules != 2 || stats.Skipped != 1 {
+		t.Errorf("Stats() = %+v, want 2 rules and 1 skipped", stats)
+	}
+
+	e.Inspect(ipv4Packet(protoTCP, "10.1.2.3", "10.0.0.2", 40000, 80, tcpACK, "one four five"))
+	alerts := e.Alerts()
+	if len(alerts) != 2 || alerts[0].Msg != "One" || alerts[1].Msg != "Inline" {
+		t.Errorf("Alerts() = %+v, want One and Inline", alerts)
+	}
+
+	cfg.RuleFiles = []string{filepath.Join(dir, "missing", "[")}
+	if err := NewEngine(cfg, nil).Load(cfg); err == nil {
+		t.Error("Load() accepted a malformed pattern")
+	}
+}
@@ -0,0 +1,125 @@
+package ips
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Flow table limits. Flows beyond maxFlows are inspected statelessly.
+const (
+	flowTimeout    = 10 * time.Minute
+	flowPurgeEvery = 30 * time.Second
+	maxFlows       = 65536
+)
+
+type flowKey struct {
+	a, b  netip.AddrPort
+	proto uint8
+}
+
+// flow is the state the flow keyword and verdicts depend on.
+type flow struct {
+	client       netip.AddrPort
+	seenToServer bool
+	seenToClient bool
+	dropped      bool
+	lastSeen     time.Time
+}
+
+type flowTable struct {
+	mu        sync.Mutex
+	flows     map[flowKey]*flow
+	lastPurge time.Time
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{flows: make(map[flowKey]*flow)}
+}
+
+// flowState is a packet's place within its flow.
+type flowState struct {
+	key         flowKey
+	tracked     bool
+	toServer    bool
+	established bool
+	dropped     bool
+}
+
+// track records a packet and returns its flow state. Flows that don't fit
+// in the table are untracked.
+func (t *flowTable) track(p *packet, now time.Time) flowState {
+	src := netip.AddrPortFrom(p.src, p.sport)
+	dst := netip.AddrPortFrom(p.dst, p.dport)
+	key := flowKey{a: src, b: dst, proto: p.proto}
+	if src.Compare(dst) > 0 {
+		key.a, key.b = dst, src
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastPurge) > flowPurgeEvery {
+		t.purge(now)
+	}
+
+	f := t.flows[key]
+	if f == nil {
+		if len(t.flows) >= maxFlows {
+			return flowState{key: key, toServer: guessClient(p, src, dst) == src}
+		}
+		f = &flow{client: guessClient(p, src, dst)}
+		t.flows[key] = f
+	}
+	f.lastSeen = now
+
+	st := flowState{key: key, tracked: true, toServer: src == f.client, dropped: f.dropped}
+	if st.toServer {
+		f.seenToServer = true
+	} else {
+		f.seenToClient = true
+	}
+	// Like Suricata: established once both sides have spoken, except for
+	// the TCP handshake itself
+	st.established = f.seenToServer && f.seenToClient && p.tcpFlags&tcpSYN == 0
+	return st
+}
+
+// guessClient picks the initiator of a flow from its first packet: the
+// sender of a SYN, else the side with the higher (ephemeral) port.
+func guessClient(p *packet, src, dst netip.AddrPort) netip.AddrPort {
+	if p.proto == protoTCP && p.tcpFlags&tcpSYN != 0 {
+		if p.tcpFlags&tcpACK != 0 {
+			return dst
+		}
+		return src
+	}
+	if p.proto == protoTCP && dst.Port() > src.Port() {
+		return dst
+	}
+	return src
+}
+
+// drop makes the rest of a flow be dropped without inspection.
+func (t *flowTable) drop(key flowKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f := t.flows[key]; f != nil {
+		f.dropped = true
+	}
+}
+
+func (t *flowTable) purge(now time.Time) {
+	for key, f := range t.flows {
+		if now.Sub(f.lastSeen) > flowTimeout {
+			delete(t.flows, key)
+		}
+	}
+	t.lastPurge = now
+}
+
+func (t *flowTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
@@ -0,0 +1,174 @@
+package ips
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// matcher is a payload keyword: content or pcre. Each returns where it
+// matched so later keywords can be relative to it.
+type matcher interface {
+	// window returns the part of a payload of length n the keyword may
+	// match in, given the end of the previous match.
+	window(prevEnd, n int) (start, end int)
+	// find returns the first match at or after from that ends by end.
+	find(payload []byte, from, end int) (mstart, mend int, ok bool)
+	negated() bool
+}
+
+type contentMatch struct {
+	pattern []byte
+	negate  bool
+	nocase  bool
+
+	offset, depth, distance, within             int
+	hasOffset, hasDepth, hasDistance, hasWithin bool
+}
+
+func (c *contentMatch) negated() bool { return c.negate }
+
+func (c *contentMatch) relative() bool { return c.hasDistance || c.hasWithin }
+
+func (c *contentMatch) window(prevEnd, n int) (int, int) {
+	start, end := c.offset, n
+	if c.relative() {
+		start = prevEnd + c.distance
+		if c.hasWithin {
+			end = min(end, prevEnd+c.distance+c.within)
+		}
+	} else if c.hasDepth {
+		end = min(end, c.offset+c.depth)
+	}
+	return max(start, 0), end
+}
+
+func (c *contentMatch) find(payload []byte, from, end int) (int, int, bool) {
+	if from+len(c.pattern) > end {
+		return 0, 0, false
+	}
+	var i int
+	if c.nocase {
+		i = indexFold(payload[from:end], c.pattern)
+	} else {
+		i = bytes.Index(payload[from:end], c.pattern)
+	}
+	if i < 0 {
+		return 0, 0, false
+	}
+	return from + i, from + i + len(c.pattern), true
+}
+
+// indexFold is bytes.Index with ASCII case folding; pattern is lower case.
+func indexFold(s, pattern []byte) int {
+	for i := 0; i+len(pattern) <= len(s); i++ {
+		j := 0
+		for j < len(pattern) && lowerASCII(s[i+j]) == pattern[j] {
+			j++
+		}
+		if j == len(pattern) {
+			return i
+		}
+	}
+	return -1
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func toLowerASCII(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[i] = lowerASCII(c)
+	}
+	return out
+}
+
+type pcreMatch struct {
+	re       *regexp.Regexp
+	negate   bool
+	relative bool
+	anchored bool
+}
+
+func (p *pcreMatch) negated() bool { return p.negate }
+
+func (p *pcreMatch) window(prevEnd, n int) (int, int) {
+	if p.relative {
+		return prevEnd, n
+	}
+	return 0, n
+}
+
+func (p *pcreMatch) find(payload []byte, from, end int) (int, int, bool) {
+	if from > end {
+		return 0, 0, false
+	}
+	loc := p.re.FindReaderIndex(&byteRuneReader{b: payload[from:end]})
+	if loc == nil || (p.anchored && loc[0] != 0) {
+		return 0, 0, false
+	}
+	return from + loc[0], from + loc[1], true
+}
+
+// byteRuneReader presents each byte as a rune of width one, so patterns
+// such as \x90 match raw bytes and match offsets are byte offsets.
+type byteRuneReader struct {
+	b []byte
+	i int
+}
+
+func (r *byteRuneReader) ReadRune() (rune, int, error) {
+	if r.i >= len(r.b) {
+		return 0, 0, io.EOF
+	}
+	c := r.b[r.i]
+	r.i++
+	return rune(c), 1, nil
+}
+
+// maxMatchSteps bounds backtracking over repeated pattern occurrences.
+const maxMatchSteps = 3000
+
+// matchPayload reports whether the payload keywords match, retrying
+// earlier keywords at later occurrences when a relative one fails.
+func matchPayload(ms []matcher, payload []byte) bool {
+	steps := 0
+	return matchFrom(ms, payload, 0, &steps)
+}
+
+func matchFrom(ms []matcher, payload []byte, prevEnd int, steps *int) bool {
+	if len(ms) == 0 {
+		return true
+	}
+	*steps++
+	if *steps > maxMatchSteps {
+		return false
+	}
+
+	m := ms[0]
+	start, end := m.window(prevEnd, len(payload))
+	if m.negated() {
+		if _, _, ok := m.find(payload, start, end); ok {
+			return false
+		}
+		return matchFrom(ms[1:], payload, prevEnd, steps)
+	}
+	for from := start; ; {
+		mstart, mend, ok := m.find(payload, from, end)
+		if !ok {
+			return false
+		}
+		if matchFrom(ms[1:], payload, mend, steps) {
+			return true
+		}
+		if p, ok := m.(*pcreMatch); ok && p.anchored {
+			return false
+		}
+		from = mstart + 1
+	}
+}
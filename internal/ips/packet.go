@@ -0,0 +1,128 @@
+package ips
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// TCP flags
+const (
+	tcpSYN = 0x02
+	tcpACK = 0x10
+)
+
+// packet is the part of a queued packet the signatures look at.
+type packet struct {
+	src, dst     netip.Addr
+	sport, dport uint16
+	proto        uint8
+	tcpFlags     uint8
+	payload      []byte
+}
+
+// decodePacket parses an IPv4 or IPv6 packet. Non-first fragments and
+// truncated headers aren't decoded; conntrack has already reassembled
+// fragments before the packet was queued.
+func decodePacket(b []byte) (*packet, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+	p := &packet{}
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		if ihl < 20 || total < ihl || len(b) < ihl {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return nil, false
+		}
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		l4 = b[ihl:min(total, len(b))]
+	case 6:
+		if len(b) < 40 {
+			return nil, false
+		}
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		next, off := b[6], 40
+		end := min(40+int(binary.BigEndian.Uint16(b[4:6])), len(b))
+	headers:
+		for {
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if off+8 > end {
+					return nil, false
+				}
+				next, off = b[off], off+8*(int(b[off+1])+1)
+				continue
+			case 44: // fragment
+				if off+8 > end || binary.BigEndian.Uint16(b[off+2:off+4])&0xfff8 != 0 {
+					return nil, false
+				}
+				next, off = b[off], off+8
+				continue
+			}
+			break headers
+		}
+		if off > end {
+			return nil, false
+		}
+		p.proto = next
+		l4 = b[off:end]
+	default:
+		return nil, false
+	}
+
+	switch p.proto {
+	case protoTCP:
+		if len(l4) < 20 {
+			return nil, false
+		}
+		p.sport = binary.BigEndian.Uint16(l4[0:2])
+		p.dport = binary.BigEndian.Uint16(l4[2:4])
+		p.tcpFlags = l4[13]
+		doff := int(l4[12]>>4) * 4
+		if doff < 20 || doff > len(l4) {
+			return nil, false
+		}
+		p.payload = l4[doff:]
+	case protoUDP:
+		if len(l4) < 8 {
+			return nil, false
+		}
+		p.sport = binary.BigEndian.Uint16(l4[0:2])
+		p.dport = binary.BigEndian.Uint16(l4[2:4])
+		p.payload = l4[8:]
+	case protoICMP, protoICMPv6:
+		if len(l4) < 8 {
+			return nil, false
+		}
+		p.payload = l4[8:]
+	default:
+		p.payload = l4
+	}
+	return p, true
+}
+
+// protoName returns the protocol name used in alerts.
+func protoName(proto uint8) string {
+	switch proto {
+	case protoTCP:
+		return "TCP"
+	case protoUDP:
+		return "UDP"
+	case protoICMP:
+		return "ICMP"
+	case protoICMPv6:
+		return "ICMPv6"
+	}
+	return "IP"
+}
@@ -0,0 +1,684 @@
+package ips
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Action is what a signature does when it matches.
+type Action int
+
+// Actions in the order signatures are evaluated: a matching pass
+// signature stops inspection, drop ends the connection.
+const (
+	ActionPass Action = iota
+	ActionDrop
+	ActionAlert
+)
+
+var actionNames = map[string]Action{
+	"pass":  ActionPass,
+	"drop":  ActionDrop,
+	"alert": ActionAlert,
+}
+
+func (a Action) String() string {
+	switch a {
+	case ActionPass:
+		return "pass"
+	case ActionDrop:
+		return "drop"
+	case ActionAlert:
+		return "alert"
+	}
+	return "unknown"
+}
+
+// IP protocol numbers
+const (
+	protoAny    = 0
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// Rule is a parsed Suricata/Snort signature. The supported subset is the
+// packet header, flow, dsize, content with its modifiers and pcre, which
+// are matched against each packet's payload; streams are not reassembled.
+type Rule struct {
+	Action    Action
+	SID       int
+	Rev       int
+	Msg       string
+	Classtype string
+	Priority  int
+
+	proto         uint8
+	src, dst      *addrList
+	sport, dport  *portList
+	bidirectional bool
+	flow          flowOptions
+	dsize         *dsize
+	matches       []matcher
+
+	raw string
+}
+
+// flowOptions is the flow keyword. Zero means no constraint.
+type flowOptions struct {
+	toServer       bool
+	toClient       bool
+	established    bool
+	notEstablished bool
+}
+
+type dsize struct {
+	op     string // "=", "<", ">", "<>"
+	lo, hi int
+}
+
+func (d *dsize) match(n int) bool {
+	switch d.op {
+	case "<":
+		return n < d.lo
+	case ">":
+		return n > d.lo
+	case "<>":
+		return n > d.lo && n < d.hi
+	}
+	return n == d.lo
+}
+
+// errUnsupported marks signatures that use keywords outside the subset.
+var errUnsupported = errors.New("unsupported")
+
+// errReject marks reject signatures. Queued packets can only be accepted
+// or dropped; no reset or unreachable is sent, so don't pretend to reject.
+var errReject = fmt.Errorf("%w action \"reject\": the engine cannot send resets, use drop", errUnsupported)
+
+// Options that don't affect matching
+var ignoredOptions = map[string]bool{
+	"metadata":     true,
+	"reference":    true,
+	"gid":          true,
+	"fast_pattern": true,
+	"rawbytes":     true,
+	"pkt_data":     true,
+	"target":       true,
+}
+
+// ParseRule parses a signature. vars holds address and port variables
+// without the "$".
+func ParseRule(line string, vars map[string]string) (*Rule, error) {
+	line = strings.TrimSpace(line)
+	open := strings.IndexByte(line, '(')
+	if open < 0 || !strings.HasSuffix(line, ")") {
+		return nil, fmt.Errorf("missing options")
+	}
+
+	header := splitHeader(line[:open])
+	if len(header) != 7 {
+		return nil, fmt.Errorf("header needs 7 fields, got %d", len(header))
+	}
+
+	r := &Rule{raw: line}
+	var ok bool
+	if header[0] == "reject" {
+		return nil, errReject
+	}
+	if r.Action, ok = actionNames[header[0]]; !ok {
+		return nil, fmt.Errorf("%w action %q", errUnsupported, header[0])
+	}
+	switch header[1] {
+	case "ip":
+		r.proto = protoAny
+	case "tcp":
+		r.proto = protoTCP
+	case "udp":
+		r.proto = protoUDP
+	case "icmp":
+		r.proto = protoICMP
+	default:
+		return nil, fmt.Errorf("%w protocol %q", errUnsupported, header[1])
+	}
+
+	var err error
+	if r.src, err = parseAddrList(header[2], vars, 0); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	if r.sport, err = parsePortList(header[3], vars, 0); err != nil {
+		return nil, fmt.Errorf("source port: %w", err)
+	}
+	switch header[4] {
+	case "->":
+	case "<>":
+		r.bidirectional = true
+	default:
+		return nil, fmt.Errorf("invalid direction %q", header[4])
+	}
+	if r.dst, err = parseAddrList(header[5], vars, 0); err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+	if r.dport, err = parsePortList(header[6], vars, 0); err != nil {
+		return nil, fmt.Errorf("destination port: %w", err)
+	}
+
+	if err := r.parseOptions(line[open+1 : len(line)-1]); err != nil {
+		return nil, err
+	}
+	if r.SID == 0 {
+		return nil, fmt.Errorf("missing sid")
+	}
+	return r, nil
+}
+
+// splitHeader splits the rule header on whitespace outside brackets.
+func splitHeader(s string) []string {
+	var fields []string
+	depth, start := 0, -1
+	for i, c := range s {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case (c == ' ' || c == '\t') && depth == 0:
+			if start >= 0 {
+				fields = append(fields, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		fields = append(fields, s[start:])
+	}
+	for i, f := range fields {
+		fields[i] = strings.NewReplacer(" ", "", "\t", "").Replace(f)
+	}
+	return fields
+}
+
+// splitOptions splits the option list on semicolons outside quotes.
+func splitOptions(s string) []string {
+	var opts []string
+	var cur strings.Builder
+	inQuote, escaped := false, false
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+		case c == ';' && !inQuote:
+			if opt := strings.TrimSpace(cur.String()); opt != "" {
+				opts = append(opts, opt)
+			}
+			cur.Reset()
+			continue
+		}
+		cur.WriteRune(c)
+	}
+	if opt := strings.TrimSpace(cur.String()); opt != "" {
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+func (r *Rule) parseOptions(s string) error {
+	var last *contentMatch
+	for _, opt := range splitOptions(s) {
+		name, value, _ := strings.Cut(opt, ":")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch name {
+		case "msg":
+			r.Msg = unquote(value)
+		case "sid":
+			r.SID, err = strconv.Atoi(value)
+		case "rev":
+			r.Rev, err = strconv.Atoi(value)
+		case "classtype":
+			r.Classtype = value
+		case "priority":
+			r.Priority, err = strconv.Atoi(value)
+		case "flow":
+			err = r.flow.parse(value)
+		case "dsize":
+			r.dsize, err = parseDsize(value)
+		case "content":
+			last, err = parseContent(value)
+			if err == nil {
+				r.matches = append(r.matches, last)
+			}
+		case "nocase", "offset", "depth", "distance", "within":
+			if last == nil {
+				return fmt.Errorf("%s without content", name)
+			}
+			err = last.modify(name, value)
+		case "pcre":
+			var m *pcreMatch
+			m, err = parsePCRE(value)
+			if err == nil {
+				r.matches = append(r.matches, m)
+				last = nil
+			}
+		default:
+			if !ignoredOptions[name] {
+				return fmt.Errorf("%w keyword %q", errUnsupported, name)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for _, m := range r.matches {
+		if c, ok := m.(*contentMatch); ok && c.relative() && (c.hasOffset || c.hasDepth) {
+			return fmt.Errorf("content %q mixes offset/depth with distance/within", c.pattern)
+		}
+	}
+	return nil
+}
+
+func (f *flowOptions) parse(value string) error {
+	for _, opt := range strings.Split(value, ",") {
+		switch strings.TrimSpace(opt) {
+		case "to_server", "from_client":
+			f.toServer = true
+		case "to_client", "from_server":
+			f.toClient = true
+		case "established":
+			f.established = true
+		case "not_established":
+			f.notEstablished = true
+		case "stateless", "no_stream", "only_stream":
+		default:
+			return fmt.Errorf("%w option %q", errUnsupported, opt)
+		}
+	}
+	if f.toServer && f.toClient {
+		return fmt.Errorf("to_server and to_client")
+	}
+	return nil
+}
+
+func parseDsize(value string) (*dsize, error) {
+	d := &dsize{op: "="}
+	var err error
+	switch {
+	case strings.Contains(value, "<>"):
+		lo, hi, _ := strings.Cut(value, "<>")
+		d.op = "<>"
+		if d.lo, err = strconv.Atoi(strings.TrimSpace(lo)); err == nil {
+			d.hi, err = strconv.Atoi(strings.TrimSpace(hi))
+		}
+	case strings.HasPrefix(value, "<"), strings.HasPrefix(value, ">"):
+		d.op = value[:1]
+		d.lo, err = strconv.Atoi(strings.TrimSpace(value[1:]))
+	default:
+		d.lo, err = strconv.Atoi(value)
+	}
+	return d, err
+}
+
+// unquote strips quotes and rule escapes from an option value.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	var b strings.Builder
+	escaped := false
+	for _, c := range s {
+		if !escaped && c == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// negated splits a leading "!" off an option value.
+func negated(value string) (string, bool) {
+	if strings.HasPrefix(value, "!") {
+		return strings.TrimSpace(value[1:]), true
+	}
+	return value, false
+}
+
+// parseContentBytes decodes a content string with |hex| sections.
+func parseContentBytes(s string) ([]byte, error) {
+	var out []byte
+	inHex, escaped := false, false
+	var hex strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			out = append(out, c)
+			escaped = false
+		case c == '|':
+			if inHex {
+				digits := strings.Join(strings.Fields(hex.String()), "")
+				if len(digits)%2 != 0 {
+					return nil, fmt.Errorf("odd number of hex digits")
+				}
+				for j := 0; j < len(digits); j += 2 {
+					v, err := strconv.ParseUint(digits[j:j+2], 16, 8)
+					if err != nil {
+						return nil, fmt.Errorf("invalid hex %q", digits[j:j+2])
+					}
+					out = append(out, byte(v))
+				}
+				hex.Reset()
+			}
+			inHex = !inHex
+		case inHex:
+			hex.WriteByte(c)
+		case c == '\\':
+			escaped = true
+		default:
+			out = append(out, c)
+		}
+	}
+	if inHex {
+		return nil, fmt.Errorf("unterminated hex")
+	}
+	return out, nil
+}
+
+func parseContent(value string) (*contentMatch, error) {
+	value, neg := negated(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return nil, fmt.Errorf("must be quoted")
+	}
+	pattern, err := parseContentBytes(value[1 : len(value)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+	return &contentMatch{pattern: pattern, negate: neg}, nil
+}
+
+func (c *contentMatch) modify(name, value string) error {
+	if name == "nocase" {
+		c.nocase = true
+		c.pattern = toLowerASCII(c.pattern)
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n < 0 && name != "distance" {
+		return fmt.Errorf("must not be negative")
+	}
+	switch name {
+	case "offset":
+		c.offset, c.hasOffset = n, true
+	case "depth":
+		c.depth, c.hasDepth = n, true
+	case "distance":
+		c.distance, c.hasDistance = n, true
+	case "within":
+		c.within, c.hasWithin = n, true
+	}
+	return nil
+}
+
+// parsePCRE translates a pcre option to Go's RE2 syntax. Patterns that
+// need backtracking features RE2 lacks are rejected.
+func parsePCRE(value string) (*pcreMatch, error) {
+	value, neg := negated(value)
+	value = unquotePCRE(value)
+	end := strings.LastIndexByte(value, '/')
+	if !strings.HasPrefix(value, "/") || end < 1 {
+		return nil, fmt.Errorf("must be /pattern/flags")
+	}
+	m := &pcreMatch{negate: neg}
+	var flags string
+	for _, f := range value[end+1:] {
+		switch f {
+		case 'i', 's', 'm':
+			flags += string(f)
+		case 'G':
+			flags += "U"
+		case 'R':
+			m.relative = true
+		case 'A':
+			m.anchored = true
+		case 'E', 'B', 'O':
+		default:
+			return nil, fmt.Errorf("%w flag %q", errUnsupported, f)
+		}
+	}
+	expr := value[1:end]
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	m.re = re
+	return m, nil
+}
+
+// unquotePCRE strips quotes but keeps backslashes, which belong to the
+// pattern.
+func unquotePCRE(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	return strings.NewReplacer(`\"`, `"`, `\;`, `;`).Replace(s)
+}
+
+// addrList is an address specification such as any, 10.0.0.0/8 or
+// [$HOME_NET,!192.168.1.1].
+type addrList struct {
+	items []addrItem
+}
+
+type addrItem struct {
+	negate bool
+	any    bool
+	prefix netip.Prefix
+	list   *addrList
+}
+
+func (l *addrList) match(ip netip.Addr) bool {
+	matched, positives := false, false
+	for _, it := range l.items {
+		m := it.any || (it.list != nil && it.list.match(ip)) || (it.prefix.IsValid() && it.prefix.Contains(ip))
+		if it.negate {
+			if m {
+				return false
+			}
+			continue
+		}
+		positives = true
+		matched = matched || m
+	}
+	return matched || !positives
+}
+
+// maxVarDepth bounds variable expansion, which may be recursive.
+const maxVarDepth = 8
+
+func parseAddrList(s string, vars map[string]string, depth int) (*addrList, error) {
+	if depth > maxVarDepth {
+		return nil, fmt.Errorf("variables nested too deeply")
+	}
+	l := &addrList{}
+	for _, tok := range splitList(s) {
+		it := addrItem{}
+		tok, it.negate = negated(tok)
+		switch {
+		case tok == "any":
+			it.any = true
+		case strings.HasPrefix(tok, "["):
+			sub, err := parseAddrList(tok, vars, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			it.list = sub
+		case strings.HasPrefix(tok, "$"):
+			value, ok := vars[tok[1:]]
+			if !ok {
+				return nil, fmt.Errorf("undefined variable %s", tok)
+			}
+			sub, err := parseAddrList(value, vars, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			it.list = sub
+		default:
+			prefix, err := netip.ParsePrefix(tok)
+			if err != nil {
+				addr, aerr := netip.ParseAddr(tok)
+				if aerr != nil {
+					return nil, fmt.Errorf("invalid address %q", tok)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			it.prefix = prefix.Masked()
+		}
+		l.items = append(l.items, it)
+	}
+	if len(l.items) == 0 {
+		return nil, fmt.Errorf("empty address")
+	}
+	return l, nil
+}
+
+// portList is a port specification such as any, 80, 1024: or [80,443].
+type portList struct {
+	items []portItem
+}
+
+type portItem struct {
+	negate bool
+	lo, hi uint16
+	list   *portList
+}
+
+func (l *portList) match(port uint16) bool {
+	matched, positives := false, false
+	for _, it := range l.items {
+		m := (it.list != nil && it.list.match(port)) || (it.list == nil && port >= it.lo && port <= it.hi)
+		if it.negate {
+			if m {
+				return false
+			}
+			continue
+		}
+		positives = true
+		matched = matched || m
+	}
+	return matched || !positives
+}
+
+func parsePortList(s string, vars map[string]string, depth int) (*portList, error) {
+	if depth > maxVarDepth {
+		return nil, fmt.Errorf("variables nested too deeply")
+	}
+	l := &portList{}
+	for _, tok := range splitList(s) {
+		it := portItem{}
+		tok, it.negate = negated(tok)
+		switch {
+		case tok == "any":
+			it.lo, it.hi = 0, 65535
+		case strings.HasPrefix(tok, "["):
+			sub, err := parsePortList(tok, vars, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			it.list = sub
+		case strings.HasPrefix(tok, "$"):
+			value, ok := vars[tok[1:]]
+			if !ok {
+				return nil, fmt.Errorf("undefined variable %s", tok)
+			}
+			sub, err := parsePortList(value, vars, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			it.list = sub
+		default:
+			lo, hi, isRange := strings.Cut(tok, ":")
+			var err error
+			if it.lo, err = parsePort(lo, 0); err != nil {
+				return nil, err
+			}
+			it.hi = it.lo
+			if isRange {
+				if it.hi, err = parsePort(hi, 65535); err != nil {
+					return nil, err
+				}
+			}
+			if it.lo > it.hi {
+				return nil, fmt.Errorf("invalid port range %q", tok)
+			}
+		}
+		l.items = append(l.items, it)
+	}
+	if len(l.items) == 0 {
+		return nil, fmt.Errorf("empty port")
+	}
+	return l, nil
+}
+
+func parsePort(s string, def uint16) (uint16, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(n), nil
+}
+
+// splitList returns the elements of "[a,b,[c]]", or s itself if it isn't
+// a list.
+func splitList(s string) []string {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return []string{s}
+	}
+	s = s[1 : len(s)-1]
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if tail := strings.TrimSpace(s[start:]); tail != "" {
+		items = append(items, tail)
+	}
+	return items
+}
@@ -0,0 +1,205 @@
+package ips
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	vars := map[string]string{
+		"HOME_NET":     "[10.0.0.0/8,fd00::/8]",
+		"EXTERNAL_NET": "!$HOME_NET",
+		"HTTP_PORTS":   "[80,8000:8100]",
+		"LOOP":         "$LOOP",
+	}
+
+	tests := []struct {
+		name        string
+		rule        string
+		wantErr     bool
+		unsupported bool
+	}{
+		{
+			name: "basic content",
+			rule: `alert tcp any any -> any 80 (msg:"test"; content:"GET"; sid:1; rev:2;)`,
+		},
+		{
+			name: "variables and flow",
+			rule: `drop tcp $EXTERNAL_NET any -> $HOME_NET $HTTP_PORTS (msg:"x"; flow:established,to_server; content:"|2e 2e|/"; sid:2;)`,
+		},
+		{
+			name: "pcre with modifiers",
+			rule: `alert tcp any any <> any any (msg:"x"; content:"User-Agent|3a|"; nocase; pcre:"/^curl\/[0-9]/Ri"; sid:3;)`,
+		},
+		{
+			name: "classification and ignored options",
+			rule: `alert udp any any -> any 53 (msg:"x"; dsize:>100; content:"|00 01|"; offset:2; depth:4; classtype:bad-unknown; priority:2; metadata:foo bar; sid:4;)`,
+		},
+		{
+			name:    "missing sid",
+			rule:    `alert tcp any any -> any any (msg:"x"; content:"a";)`,
+			wantErr: true,
+		},
+		{
+			name:    "bad action",
+			rule:    `log tcp any any -> any any (msg:"x"; sid:5;)`,
+			wantErr: true,
+		},
+		{
+			name:    "bad direction",
+			rule:    `alert tcp any any <- any any (msg:"x"; sid:6;)`,
+			wantErr: true,
+		},
+		{
+			name:    "undefined variable",
+			rule:    `alert tcp $NOPE any -> any any (msg:"x"; sid:7;)`,
+			wantErr: true,
+		},
+		{
+			name:    "recursive variable",
+			rule:    `alert tcp $LOOP any -> any any (msg:"x"; sid:8;)`,
+			wantErr: true,
+		},
+		{
+			name:    "mixed relative and absolute",
+			rule:    `alert tcp any any -> any any (msg:"x"; content:"a"; content:"b"; offset:1; distance:0; sid:9;)`,
+			wantErr: true,
+		},
+		{
+			name:        "unsupported keyword",
+			rule:        `alert http any any -> any any (msg:"x"; http.uri; content:"/a"; sid:10;)`,
+			wantErr:     true,
+			unsupported: true,
+		},
+		{
+			name:        "reject action",
+			rule:        `reject tcp any any -> any 80 (msg:"x"; content:"a"; sid:12;)`,
+			wantErr:     true,
+			unsupported: true,
+		},
+		{
+			name:        "unsupported option",
+			rule:        `alert tcp any any -> any any (msg:"x"; byte_test:1,>,2,0; sid:11;)`,
+			wantErr:     true,
+			unsupported: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRule(tt.rule, vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.unsupported && !errors.Is(err, errUnsupported) {
+				t.Errorf("error = %v, want unsupported", err)
+			}
+		})
+	}
+}
+
+func TestRuleHeader(t *testing.T) {
+	vars := map[string]string{
+		"HOME_NET":     "[10.0.0.0/8,fd00::/8]",
+		"EXTERNAL_NET": "!$HOME_NET",
+	}
+	r, err := ParseRule(`alert tcp $EXTERNAL_NET any -> $HOME_NET [22,8000:8100,!8080] (msg:"x"; sid:1;)`, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		src  bool
+		dst  bool
+	}{
+		{"10.1.2.3", false, true},
+		{"203.0.113.9", true, false},
+		{"fd00::1", false, true},
+		{"2001:db8::1", true, false},
+	}
+	for _, tt := range tests {
+		ip := netip.MustParseAddr(tt.addr)
+		if got := r.src.match(ip); got != tt.src {
+			t.Errorf("src.match(%s) = %v, want %v", tt.addr, got, tt.src)
+		}
+		if got := r.dst.match(ip); got != tt.dst {
+			t.Errorf("dst.match(%s) = %v, want %v", tt.addr, got, tt.dst)
+		}
+	}
+
+	ports := map[uint16]bool{22: true, 23: false, 8000: true, 8080: false, 8100: true, 8101: false}
+	for port, want := range ports {
+		if got := r.dport.match(port); got != want {
+			t.Errorf("dport.match(%d) = %v, want %v", port, got, want)
+		}
+	}
+}
+
+func TestMatchPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		payload string
+		want    bool
+	}{
+		{"content", `content:"evil";`, "some evil here", true},
+		{"content missing", `content:"evil";`, "all good", false},
+		{"hex content", `content:"|de ad|beef";`, "x\xde\xadbeef", true},
+		{"nocase", `content:"EVIL"; nocase;`, "EvIl", true},
+		{"case sensitive", `content:"EVIL";`, "evil", false},
+		{"negated", `content:"GET"; content:!"admin";`, "GET /index", true},
+		{"negated present", `content:"GET"; content:!"admin";`, "GET /admin", false},
+		{"offset and depth", `content:"GET"; offset:0; depth:3;`, "GET /", true},
+		{"outside depth", `content:"GET"; depth:3;`, " GET /", false},
+		{"offset", `content:"b"; offset:2;`, "bab", true},
+		{"before offset", `content:"a"; offset:2;`, "abb", false},
+		{"distance", `content:"a"; content:"c"; distance:1;`, "abc", true},
+		{"within", `content:"a"; content:"c"; within:2;`, "abbc", false},
+		{"backtracking", `content:"a"; content:"c"; within:2;`, "abbcac", true},
+		{"pcre", `pcre:"/user=[a-z]+;/";`, "x user=root; y", true},
+		{"pcre nocase", `pcre:"/USER=/i";`, "user=", true},
+		{"pcre relative", `content:"Host|3a|"; pcre:"/^\s*evil\.example/R";`, "Host: evil.example", true},
+		{"pcre relative mismatch", `content:"Host|3a|"; pcre:"/^\s*evil\.example/R";`, "Host: good.example evil.example", false},
+		{"pcre bytes", `pcre:"/\x90{4}/";`, "\x01\x90\x90\x90\x90", true},
+		{"negated pcre", `pcre:!"/^GET/";`, "POST /", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRule(`alert tcp any any -> any any (msg:"x"; `+tt.options+` sid:1;)`, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchPayload(r.matches, []byte(tt.payload)); got != tt.want {
+				t.Errorf("matchPayload(%q) = %v, want %v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDsize(t *testing.T) {
+	tests := []struct {
+		value string
+		n     int
+		want  bool
+	}{
+		{"10", 10, true},
+		{"10", 11, false},
+		{">10", 11, true},
+		{">10", 10, false},
+		{"<10", 9, true},
+		{"5<>10", 7, true},
+		{"5<>10", 10, false},
+	}
+	for _, tt := range tests {
+		d, err := parseDsize(tt.value)
+		if err != nil {
+			t.Fatalf("parseDsize(%q): %v", tt.value, err)
+		}
+		if got := d.match(tt.n); got != tt.want {
+			t.Errorf("dsize %q match(%d) = %v, want %v", tt.value, tt.n, got, tt.want)
+		}
+	}
+}
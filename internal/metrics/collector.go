@@ -31,6 +31,9 @@ type Collector struct {
 	systemStats    *SystemStats
 	conntrackStats *ConntrackStats
 
+	// Last DNS rate limit totals seen, for converting to counter deltas
+	dnsRateLimited map[string]uint64
+
 	// Reload counters for testing
 	reloadSuccess int64
 	reloadFailure int64
@@ -95,6 +98,9 @@ type DNSStats struct {
 	Blocked     uint64 `json:"blocked"`
 	Forwarded   uint64 `json:"forwarded"`
 	CacheSize   int    `json:"cache_size"`
+
+	RateLimited    uint64 `json:"rate_limited"`    // Queries and responses suppressed
+	LimitedClients int    `json:"limited_clients"` // Clients limited within the last minute
 }
 
 // SystemStats holds system-level statistics.
@@ -718,6 +724,9 @@ func (c *Collector) GetServiceStats() *ServiceStats {
 			Blocked:     c.serviceStats.DNS.Blocked,
 			Forwarded:   c.serviceStats.DNS.Forwarded,
 			CacheSize:   c.serviceStats.DNS.CacheSize,
+
+			RateLimited:    c.serviceStats.DNS.RateLimited,
+			LimitedClients: c.serviceStats.DNS.LimitedClients,
 		},
 	}
 }
@@ -780,3 +789,35 @@ func (c *Collector) UpdateDNSStats(enabled bool, queries, cacheHits, cacheMisses
 	c.serviceStats.DNS.CacheMisses = cacheMisses
 	c.serviceStats.DNS.Blocked = blocked
 }
+
+// UpdateDNSRateLimitStats records DNS rate limiting totals reported by the
+// control plane. suppressed maps an action ("refuse", "drop", "truncate",
+// "rrl_drop", "rrl_slip") to its cumulative count; the Prometheus counters
+// advance by the difference since the last update.
+func (c *Collector) UpdateDNSRateLimitStats(limitedClients int, suppressed map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dnsRateLimited == nil {
+		c.dnsRateLimited = make(map[string]uint64)
+	}
+
+	var total uint64
+	for action, n := range suppressed {
+		total += n
+		prev := c.dnsRateLimited[action]
+		if n < prev {
+			prev = 0 // Control plane restarted
+		}
+		if c.registry != nil && n > prev {
+			c.registry.DNSRateLimited.WithLabelValues(action).Add(float64(n - prev))
+		}
+		c.dnsRateLimited[action] = n
+	}
+	if c.registry != nil {
+		c.registry.DNSLimitedClients.Set(float64(limitedClients))
+	}
+
+	c.serviceStats.DNS.RateLimited = total
+	c.serviceStats.DNS.LimitedClients = limitedClients
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"grimm.is/glacic/internal/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestCollector_StateAccess(t *testing.T) {
@@ -127,3 +129,40 @@ func TestIncrementConfigReload(t *testing.T) {
 		t.Errorf("Expected final counts (1, 1), got (%d, %d)", success, failure)
 	}
 }
+
+// counterValue reads a counter through a registry of its own.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil || len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("unexpected counter families %v: %v", families, err)
+	}
+	return families[0].GetMetric()[0].GetCounter().GetValue()
+}
+
+func TestCollector_DNSRateLimitStats(t *testing.T) {
+	c := NewCollector(logging.New(logging.DefaultConfig()), time.Minute)
+	counter := c.registry.DNSRateLimited.WithLabelValues("refuse")
+	before := counterValue(t, counter)
+
+	c.UpdateDNSRateLimitStats(3, map[string]uint64{"refuse": 10, "rrl_drop": 5})
+	c.UpdateDNSRateLimitStats(2, map[string]uint64{"refuse": 12, "rrl_drop": 5})
+
+	if got := counterValue(t, counter) - before; got != 12 {
+		t.Errorf("expected refuse counter to advance by 12, got %v", got)
+	}
+	svc := c.GetServiceStats()
+	if svc.DNS.RateLimited != 17 || svc.DNS.LimitedClients != 2 {
+		t.Errorf("unexpected DNS rate limit stats: %+v", svc.DNS)
+	}
+
+	// A control plane restart resets its totals; the counter keeps going
+	c.UpdateDNSRateLimitStats(0, map[string]uint64{"refuse": 4})
+	if got := counterValue(t, counter) - before; got != 16 {
+		t.Errorf("expected refuse counter to advance by 16 after reset, got %v", got)
+	}
+}
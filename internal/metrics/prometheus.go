@@ -53,10 +53,12 @@ type Registry struct {
 	DHCPNaks     *prometheus.CounterVec
 
 	// DNS metrics
-	DNSQueries     *prometheus.CounterVec
-	DNSCacheHits   prometheus.Counter
-	DNSCacheMisses prometheus.Counter
-	DNSBlocked     prometheus.Counter
+	DNSQueries        *prometheus.CounterVec
+	DNSCacheHits      prometheus.Counter
+	DNSCacheMisses    prometheus.Counter
+	DNSBlocked        prometheus.Counter
+	DNSRateLimited    *prometheus.CounterVec
+	DNSLimitedClients prometheus.Gauge
 
 	// System metrics
 	Uptime       prometheus.Gauge
@@ -228,6 +230,16 @@ func newRegistry() *Registry {
 		Help: "Total DNS queries blocked",
 	})
 
+	r.DNSRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "firewall_dns_rate_limited_total",
+		Help: "Total DNS queries and responses suppressed by rate limiting",
+	}, []string{"action"})
+
+	r.DNSLimitedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "firewall_dns_rate_limited_clients",
+		Help: "DNS clients rate limited within the last minute",
+	})
+
 	// System metrics
 	r.Uptime = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "firewall_uptime_seconds",
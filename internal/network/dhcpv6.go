@@ -6,8 +6,8 @@ import (
 	"os/exec"
 )
 
-// StartDHCPv6Client starts a DHCPv6 client on the interface.
-// Currently wraps 'dhclient -6'.
+// StartDHCPv6Client starts the system DHCPv6 client ('dhclient -6') on the
+// interface. Used as a fallback when the native client cannot start.
 func StartDHCPv6Client(iface string) error {
 	// Check if already running
 	if isProcessRunning(fmt.Sprintf("dhclient -6 -P %s", iface)) {
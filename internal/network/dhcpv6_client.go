@@ -0,0 +1,439 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"grimm.is/glacic/internal/brand"
+	"grimm.is/glacic/internal/config"
+)
+
+// dhcpv6RetryInterval is how long to wait between failed Renew/Rebind attempts.
+const dhcpv6RetryInterval = 30 * time.Second
+
+// errDHCPv6Refused is returned when the server replied without usable bindings.
+var errDHCPv6Refused = errors.New("DHCPv6 server refused bindings")
+
+// StartNativeDHCPv6Client starts a native DHCPv6 client on a WAN interface.
+// It requests an address (IA_NA) and, with prefix_delegation, a prefix (IA_PD)
+// that is carved into /64s for the interfaces naming it in pd_from.
+func (m *Manager) StartNativeDHCPv6Client(ifaceCfg config.Interface) error {
+	log.Printf("[network] Starting native DHCPv6 client on %s", ifaceCfg.Name)
+
+	ifi, err := net.InterfaceByName(ifaceCfg.Name)
+	if err != nil {
+		return fmt.Errorf("failed to create DHCPv6 client for %s: %w", ifaceCfg.Name, err)
+	}
+	if len(ifi.HardwareAddr) < 4 {
+		return fmt.Errorf("interface %s has no hardware address to derive a DUID from", ifaceCfg.Name)
+	}
+
+	go m.runDHCPv6ClientLoop(ifaceCfg)
+
+	return nil
+}
+
+// SavedLeaseV6 represents a serialized DHCPv6 lease
+type SavedLeaseV6 struct {
+	ReplyPacket []byte    `json:"reply_packet"`
+	ObtainedAt  time.Time `json:"obtained_at"`
+}
+
+// dhcpv6Lease holds the bindings from a DHCPv6 Reply.
+type dhcpv6Lease struct {
+	reply      *dhcpv6.Message
+	obtainedAt time.Time
+	address    *dhcpv6.OptIAAddress // IA_NA address, if assigned
+	prefix     *dhcpv6.OptIAPrefix  // IA_PD prefix, if delegated
+	t1, t2     time.Duration        // Renew and Rebind times
+	valid      time.Duration        // Shortest valid lifetime of the bindings
+}
+
+// newDHCPv6Lease extracts the bindings from a Reply.
+func newDHCPv6Lease(reply *dhcpv6.Message, obtainedAt time.Time) (*dhcpv6Lease, error) {
+	if status := reply.Options.Status(); status != nil && status.StatusCode != iana.StatusSuccess {
+		return nil, fmt.Errorf("server returned %s: %s", status.StatusCode, status.StatusMessage)
+	}
+
+	l := &dhcpv6Lease{reply: reply, obtainedAt: obtainedAt}
+	var preferred time.Duration
+	bind := func(t1, t2, pref, valid time.Duration) {
+		if l.valid == 0 || valid < l.valid {
+			l.valid = valid
+			preferred = pref
+		}
+		if t1 > 0 && (l.t1 == 0 || t1 < l.t1) {
+			l.t1 = t1
+		}
+		if t2 > 0 && (l.t2 == 0 || t2 < l.t2) {
+			l.t2 = t2
+		}
+	}
+
+	if ia := reply.Options.OneIANA(); ia != nil {
+		if addr := ia.Options.OneAddress(); addr != nil && addr.ValidLifetime > 0 {
+			l.address = addr
+			bind(ia.T1, ia.T2, addr.PreferredLifetime, addr.ValidLifetime)
+		}
+	}
+	if pd := reply.Options.OneIAPD(); pd != nil {
+		for _, p := range pd.Options.Prefixes() {
+			if p.Prefix != nil && p.ValidLifetime > 0 {
+				l.prefix = p
+				bind(pd.T1, pd.T2, p.PreferredLifetime, p.ValidLifetime)
+				break
+			}
+		}
+	}
+	if l.address == nil && l.prefix == nil {
+		return nil, fmt.Errorf("reply carries no address or prefix")
+	}
+
+	// T1/T2 of zero leave the timing to the client (RFC 8415 section 21.4)
+	if l.t1 == 0 {
+		l.t1 = preferred / 2
+	}
+	if l.t2 == 0 || l.t2 < l.t1 {
+		l.t2 = preferred * 4 / 5
+	}
+	if l.t2 < l.t1 {
+		l.t2 = l.t1
+	}
+	return l, nil
+}
+
+// delegation returns the delegated prefix with its remaining lifetimes.
+func (l *dhcpv6Lease) delegation() delegatedPrefix {
+	if l == nil || l.prefix == nil {
+		return delegatedPrefix{}
+	}
+	addr, ok := netip.AddrFromSlice(l.prefix.Prefix.IP.To16())
+	if !ok {
+		return delegatedPrefix{}
+	}
+	bits, _ := l.prefix.Prefix.Mask.Size()
+	elapsed := time.Since(l.obtainedAt)
+	remaining := func(d time.Duration) time.Duration {
+		if d <= elapsed {
+			return 0
+		}
+		return d - elapsed
+	}
+	return delegatedPrefix{
+		prefix:    netip.PrefixFrom(addr, bits).Masked(),
+		preferred: remaining(l.prefix.PreferredLifetime),
+		valid:     remaining(l.prefix.ValidLifetime),
+	}
+}
+
+// dhcpv6ClientID returns a stable DUID-LL so the ISP keeps handing us the
+// same delegation across restarts.
+func dhcpv6ClientID(hwaddr net.HardwareAddr) dhcpv6.DUID {
+	return &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: hwaddr}
+}
+
+// newDHCPv6Solicit builds the Solicit for an interface, adding an IA_PD
+// (with the pd_length hint) when prefix delegation is enabled.
+func newDHCPv6Solicit(hwaddr net.HardwareAddr, ifaceCfg config.Interface) (*dhcpv6.Message, error) {
+	mods := []dhcpv6.Modifier{dhcpv6.WithClientID(dhcpv6ClientID(hwaddr))}
+	if ifaceCfg.PrefixDelegation {
+		var iaid [4]byte
+		copy(iaid[:], hwaddr[len(hwaddr)-4:])
+
+		var hints []*dhcpv6.OptIAPrefix
+		if ifaceCfg.PDLength > 0 {
+			hints = append(hints, &dhcpv6.OptIAPrefix{
+				Prefix: &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(ifaceCfg.PDLength, 128)},
+			})
+		}
+		mods = append(mods, dhcpv6.WithIAPD(iaid, hints...))
+	}
+	return dhcpv6.NewSolicit(hwaddr, mods...)
+}
+
+// newDHCPv6Message builds a Request, Renew or Rebind for the bindings in a
+// previous Advertise or Reply.
+func newDHCPv6Message(typ dhcpv6.MessageType, from *dhcpv6.Message) (*dhcpv6.Message, error) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = typ
+
+	cid := from.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil, fmt.Errorf("%s has no client ID", from.Type())
+	}
+	msg.AddOption(cid)
+	// Rebind goes to any server, so it carries no server ID
+	if typ != dhcpv6.MessageTypeRebind {
+		sid := from.GetOneOption(dhcpv6.OptionServerID)
+		if sid == nil {
+			return nil, fmt.Errorf("%s has no server ID", from.Type())
+		}
+		msg.AddOption(sid)
+	}
+	msg.AddOption(dhcpv6.OptElapsedTime(0))
+	for _, ia := range from.Options.IANA() {
+		msg.AddOption(ia)
+	}
+	for _, pd := range from.Options.IAPD() {
+		msg.AddOption(pd)
+	}
+	msg.AddOption(dhcpv6.OptRequestedOption(
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+	))
+	return msg, nil
+}
+
+// exchangeDHCPv6 sends a Request, Renew or Rebind and waits for the Reply.
+func exchangeDHCPv6(client *nclient6.Client, typ dhcpv6.MessageType, from *dhcpv6.Message) (*dhcpv6Lease, error) {
+	msg, err := newDHCPv6Message(typ, from)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := client.SendAndRead(context.Background(), client.RemoteAddr(), msg, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+	if err != nil {
+		return nil, err
+	}
+	lease, err := newDHCPv6Lease(reply, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDHCPv6Refused, err)
+	}
+	return lease, nil
+}
+
+// acquireDHCPv6Lease runs the Solicit/Advertise/Request/Reply exchange.
+func acquireDHCPv6Lease(client *nclient6.Client, ifaceCfg config.Interface) (*dhcpv6Lease, error) {
+	sol, err := newDHCPv6Solicit(client.InterfaceAddr(), ifaceCfg)
+	if err != nil {
+		return nil, err
+	}
+	adv, err := client.SendAndRead(context.Background(), client.RemoteAddr(), sol, nclient6.IsMessageType(dhcpv6.MessageTypeAdvertise))
+	if err != nil {
+		return nil, err
+	}
+	return exchangeDHCPv6(client, dhcpv6.MessageTypeRequest, adv)
+}
+
+// extendDHCPv6Lease renews the lease at T1 and rebinds it at T2. It returns
+// the extended lease, or nil if it could not be extended before it expired or
+// the server refused it.
+func extendDHCPv6Lease(client *nclient6.Client, ifaceName string, lease *dhcpv6Lease) *dhcpv6Lease {
+	sleepUntil(lease.obtainedAt.Add(lease.t1))
+
+	phases := []struct {
+		typ   dhcpv6.MessageType
+		until time.Time
+	}{
+		{dhcpv6.MessageTypeRenew, lease.obtainedAt.Add(lease.t2)},
+		{dhcpv6.MessageTypeRebind, lease.obtainedAt.Add(lease.valid)},
+	}
+	for _, phase := range phases {
+		for time.Now().Before(phase.until) {
+			log.Printf("[network] Sending DHCPv6 %s on %s...", phase.typ, ifaceName)
+			newLease, err := exchangeDHCPv6(client, phase.typ, lease.reply)
+			if err == nil {
+				return newLease
+			}
+			if errors.Is(err, errDHCPv6Refused) {
+				log.Printf("[network] DHCPv6 %s refused on %s: %v", phase.typ, ifaceName, err)
+				return nil
+			}
+			log.Printf("[network] DHCPv6 %s failed on %s: %v. Retrying...", phase.typ, ifaceName, err)
+			sleepUntil(minTime(time.Now().Add(dhcpv6RetryInterval), phase.until))
+		}
+	}
+
+	log.Printf("[network] DHCPv6 lease on %s expired", ifaceName)
+	return nil
+}
+
+// runDHCPv6ClientLoop handles the initial acquisition and subsequent renewals.
+func (m *Manager) runDHCPv6ClientLoop(ifaceCfg config.Interface) {
+	name := ifaceCfg.Name
+
+	// The socket binds to the link-local address, which stays tentative
+	// until DAD completes after the link comes up.
+	var client *nclient6.Client
+	for backoff := 2 * time.Second; ; {
+		c, err := nclient6.New(name)
+		if err == nil {
+			client = c
+			break
+		}
+		log.Printf("[network] DHCPv6 client on %s not ready: %v. Retrying...", name, err)
+		time.Sleep(backoff)
+		if backoff < 60*time.Second {
+			backoff *= 2
+		}
+	}
+
+	// Reuse a saved lease so the delegated prefix is in place at boot
+	var applied *dhcpv6Lease
+	lease := m.loadDHCPv6Lease(ifaceCfg)
+	if lease != nil {
+		log.Printf("[network] Loaded saved DHCPv6 lease for %s", name)
+	}
+
+	backoff := 2 * time.Second
+	for {
+		if lease == nil {
+			var err error
+			lease, err = acquireDHCPv6Lease(client, ifaceCfg)
+			if err != nil {
+				log.Printf("[network] DHCPv6 exchange failed on %s: %v", name, err)
+				if applied != nil && time.Now().After(applied.obtainedAt.Add(applied.valid)) {
+					m.applyDHCPv6Lease(name, applied, nil)
+					applied = nil
+				}
+				time.Sleep(backoff)
+				if backoff < 60*time.Second {
+					backoff *= 2
+				}
+				continue
+			}
+			backoff = 2 * time.Second
+			m.saveDHCPv6Lease(name, lease)
+		}
+
+		m.applyDHCPv6Lease(name, applied, lease)
+		applied = lease
+
+		log.Printf("[network] DHCPv6 lease active on %s. Renewing in %s", name, time.Until(lease.obtainedAt.Add(lease.t1)).Round(time.Second))
+		lease = extendDHCPv6Lease(client, name, lease)
+		if lease != nil {
+			m.saveDHCPv6Lease(name, lease)
+		}
+	}
+}
+
+// applyDHCPv6Lease moves the interface from the bindings of prev to those of
+// next. A nil next withdraws everything.
+func (m *Manager) applyDHCPv6Lease(ifaceName string, prev, next *dhcpv6Lease) {
+	link, err := m.nl.LinkByName(ifaceName)
+	if err != nil {
+		log.Printf("[network] Interface %s not found: %v", ifaceName, err)
+		return
+	}
+
+	// 1. Address (IA_NA) as a /128; the on-link prefix and default route come from RAs
+	addrOf := func(l *dhcpv6Lease) net.IP {
+		if l == nil || l.address == nil {
+			return nil
+		}
+		return l.address.IPv6Addr
+	}
+	oldAddr, newAddr := addrOf(prev), addrOf(next)
+	if oldAddr != nil && !oldAddr.Equal(newAddr) {
+		m.nl.AddrDel(link, &netlink.Addr{IPNet: &net.IPNet{IP: oldAddr, Mask: net.CIDRMask(128, 128)}})
+	}
+	if newAddr != nil {
+		log.Printf("[network] Assigning DHCPv6 address %s to %s", newAddr, ifaceName)
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: newAddr, Mask: net.CIDRMask(128, 128)}}
+		if err := m.nl.AddrAdd(link, addr); err != nil && !strings.Contains(err.Error(), "file exists") {
+			log.Printf("[network] Failed to add DHCPv6 address on %s: %v", ifaceName, err)
+		}
+	}
+
+	// 2. Delegated prefix (IA_PD): unreachable route for the parts not
+	// assigned downstream (RFC 7084 WPD-5), then carve the /64s.
+	oldPD, newPD := prev.delegation(), next.delegation()
+	if oldPD.prefix.IsValid() && oldPD.prefix != newPD.prefix {
+		m.nl.RouteDel(unreachableRoute(oldPD.prefix))
+	}
+	if newPD.prefix.IsValid() {
+		if err := m.nl.RouteAdd(unreachableRoute(newPD.prefix)); err != nil && !strings.Contains(err.Error(), "file exists") {
+			log.Printf("[network] Failed to add unreachable route for %s: %v", newPD.prefix, err)
+		}
+	}
+	if oldPD.prefix.IsValid() || newPD.prefix.IsValid() {
+		m.updateDelegation(ifaceName, newPD)
+	}
+}
+
+func unreachableRoute(prefix netip.Prefix) *netlink.Route {
+	return &netlink.Route{
+		Dst: &net.IPNet{
+			IP:   net.IP(prefix.Addr().AsSlice()),
+			Mask: net.CIDRMask(prefix.Bits(), 128),
+		},
+		Type: unix.RTN_UNREACHABLE,
+	}
+}
+
+func (m *Manager) saveDHCPv6Lease(ifaceName string, lease *dhcpv6Lease) {
+	data, err := json.Marshal(SavedLeaseV6{
+		ReplyPacket: lease.reply.ToBytes(),
+		ObtainedAt:  lease.obtainedAt,
+	})
+	if err != nil {
+		log.Printf("[network] Failed to marshal DHCPv6 lease: %v", err)
+		return
+	}
+
+	path := filepath.Join(brand.GetStateDir(), fmt.Sprintf("dhcpv6_client_%s.json", ifaceName))
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("[network] Failed to save DHCPv6 lease to disk: %v", err)
+	}
+}
+
+// loadDHCPv6Lease returns the saved lease if it is still valid and matches
+// what the interface asks for.
+func (m *Manager) loadDHCPv6Lease(ifaceCfg config.Interface) *dhcpv6Lease {
+	path := filepath.Join(brand.GetStateDir(), fmt.Sprintf("dhcpv6_client_%s.json", ifaceCfg.Name))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var sl SavedLeaseV6
+	if err := json.Unmarshal(data, &sl); err != nil {
+		return nil
+	}
+	reply, err := dhcpv6.MessageFromBytes(sl.ReplyPacket)
+	if err != nil {
+		return nil
+	}
+	lease, err := newDHCPv6Lease(reply, sl.ObtainedAt)
+	if err != nil || time.Since(sl.ObtainedAt) >= lease.valid {
+		return nil
+	}
+	if ifaceCfg.PrefixDelegation && lease.prefix == nil {
+		return nil
+	}
+	return lease
+}
+
+func sleepUntil(t time.Time) {
+	if d := time.Until(t); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"log"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// StartNativeDHCPv6Client is a stub for non-Linux systems.
+func (m *Manager) StartNativeDHCPv6Client(ifaceCfg config.Interface) error {
+	log.Printf("[network] [DRY RUN] Starting DHCPv6 Client on interface '%s' (PD: %v). (Not supported on non-Linux, simulation only)", ifaceCfg.Name, ifaceCfg.PrefixDelegation)
+	return nil
+}
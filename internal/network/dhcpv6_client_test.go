@@ -0,0 +1,178 @@
+//go:build linux
+
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDHCPv6Server answers on loopback with an IA_NA address and an IA_PD prefix.
+type fakeDHCPv6Server struct {
+	conn     net.PacketConn
+	mu       sync.Mutex
+	prefix   string
+	refuse   bool
+	received []*dhcpv6.Message
+}
+
+func newFakeDHCPv6Server(t *testing.T, prefix string) *fakeDHCPv6Server {
+	t.Helper()
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	s := &fakeDHCPv6Server{conn: conn, prefix: prefix}
+	go s.serve()
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *fakeDHCPv6Server) serve() {
+	serverID := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, err := dhcpv6.MessageFromBytes(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.received = append(s.received, msg)
+		prefix, refuse := s.prefix, s.refuse
+		s.mu.Unlock()
+
+		reply := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply, TransactionID: msg.TransactionID}
+		if msg.MessageType == dhcpv6.MessageTypeSolicit {
+			reply.MessageType = dhcpv6.MessageTypeAdvertise
+		}
+		reply.AddOption(msg.GetOneOption(dhcpv6.OptionClientID))
+		reply.AddOption(dhcpv6.OptServerID(serverID))
+
+		if refuse {
+			reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoBinding})
+		} else {
+			if ia := msg.Options.OneIANA(); ia != nil {
+				na := &dhcpv6.OptIANA{IaId: ia.IaId, T1: 1800 * time.Second, T2: 2880 * time.Second}
+				na.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8:ffff::10"), PreferredLifetime: time.Hour, ValidLifetime: 2 * time.Hour})
+				reply.AddOption(na)
+			}
+			if pd := msg.Options.OneIAPD(); pd != nil {
+				_, ipnet, _ := net.ParseCIDR(prefix)
+				opt := &dhcpv6.OptIAPD{IaId: pd.IaId, T1: 900 * time.Second, T2: 1440 * time.Second}
+				opt.Options.Add(&dhcpv6.OptIAPrefix{Prefix: ipnet, PreferredLifetime: time.Hour, ValidLifetime: 2 * time.Hour})
+				reply.AddOption(opt)
+			}
+		}
+		s.conn.WriteTo(reply.ToBytes(), peer)
+	}
+}
+
+func (s *fakeDHCPv6Server) first() *dhcpv6.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received[0]
+}
+
+func (s *fakeDHCPv6Server) last() *dhcpv6.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received[len(s.received)-1]
+}
+
+func newLoopbackDHCPv6Client(t *testing.T, server *fakeDHCPv6Server) *nclient6.Client {
+	t.Helper()
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	require.NoError(t, err)
+	hwaddr := net.HardwareAddr{0x02, 0x11, 0x22, 0x33, 0x44, 0x55}
+	client, err := nclient6.NewWithConn(conn, hwaddr,
+		nclient6.WithBroadcastAddr(server.conn.LocalAddr().(*net.UDPAddr)),
+		nclient6.WithTimeout(time.Second), nclient6.WithRetry(1))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDHCPv6Client_PrefixDelegation(t *testing.T) {
+	server := newFakeDHCPv6Server(t, "2001:db8:0:10::/60")
+	client := newLoopbackDHCPv6Client(t, server)
+	wan := config.Interface{Name: "eth0", DHCPv6: true, PrefixDelegation: true, PDLength: 60}
+
+	lease, err := acquireDHCPv6Lease(client, wan)
+	require.NoError(t, err)
+
+	// The Solicit asked for a /60 under a stable DUID-LL
+	sol := server.first()
+	require.NotNil(t, sol.Options.OneIAPD())
+	hints := sol.Options.OneIAPD().Options.Prefixes()
+	if assert.Len(t, hints, 1) {
+		bits, _ := hints[0].Prefix.Mask.Size()
+		assert.Equal(t, 60, bits)
+	}
+	assert.IsType(t, &dhcpv6.DUIDLL{}, sol.Options.ClientID())
+
+	assert.True(t, lease.address.IPv6Addr.Equal(net.ParseIP("2001:db8:ffff::10")))
+	assert.Equal(t, "2001:db8:0:10::/60", lease.delegation().prefix.String())
+	assert.Equal(t, 900*time.Second, lease.t1, "earliest T1 across IAs")
+	assert.Equal(t, 1440*time.Second, lease.t2)
+	assert.Equal(t, time.Hour*2, lease.valid)
+
+	// Renew goes to the server that delegated the prefix; the ISP rotates it
+	server.mu.Lock()
+	server.prefix = "2001:db8:0:20::/60"
+	server.mu.Unlock()
+	renewed, err := exchangeDHCPv6(client, dhcpv6.MessageTypeRenew, lease.reply)
+	require.NoError(t, err)
+	renew := server.last()
+	assert.Equal(t, dhcpv6.MessageTypeRenew, renew.MessageType)
+	assert.NotNil(t, renew.Options.ServerID())
+	assert.NotNil(t, renew.Options.OneIAPD())
+	assert.Equal(t, "2001:db8:0:20::/60", renewed.delegation().prefix.String())
+
+	// Rebind is sent to any server
+	_, err = exchangeDHCPv6(client, dhcpv6.MessageTypeRebind, renewed.reply)
+	require.NoError(t, err)
+	assert.Nil(t, server.last().Options.ServerID())
+
+	// A server that lost the binding refuses it
+	server.mu.Lock()
+	server.refuse = true
+	server.mu.Unlock()
+	_, err = exchangeDHCPv6(client, dhcpv6.MessageTypeRenew, renewed.reply)
+	assert.True(t, errors.Is(err, errDHCPv6Refused), "got %v", err)
+}
+
+func TestNewDHCPv6Lease_DefaultTimers(t *testing.T) {
+	reply, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	reply.MessageType = dhcpv6.MessageTypeReply
+	_, ipnet, _ := net.ParseCIDR("2001:db8:ab00::/56")
+	pd := &dhcpv6.OptIAPD{}
+	pd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: ipnet, PreferredLifetime: 1000 * time.Second, ValidLifetime: 2000 * time.Second})
+	reply.AddOption(pd)
+
+	lease, err := newDHCPv6Lease(reply, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, lease.address)
+	assert.Equal(t, 500*time.Second, lease.t1)
+	assert.Equal(t, 800*time.Second, lease.t2)
+
+	// Nothing bound
+	empty, _ := dhcpv6.NewMessage()
+	_, err = newDHCPv6Lease(empty, time.Now())
+	assert.Error(t, err)
+}
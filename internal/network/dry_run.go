@@ -124,6 +124,32 @@ func (n *DryRunNetlinker) RuleDel(rule *netlink.Rule) error {
 	n.log(fmt.Sprintf("rule del %s", rule.String()))
 	return nil
 }
+func (n *DryRunNetlinker) BridgeVlanAdd(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	n.logBridgeVlan("add", link, vid, pvid, untagged, self)
+	return nil
+}
+func (n *DryRunNetlinker) BridgeVlanDel(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	n.logBridgeVlan("del", link, vid, pvid, untagged, self)
+	return nil
+}
+func (n *DryRunNetlinker) BridgeVlanList(link netlink.Link) ([]BridgeVLAN, error) {
+	return nil, nil
+}
+func (n *DryRunNetlinker) logBridgeVlan(op string, link netlink.Link, vid uint16, pvid, untagged, self bool) {
+	cmd := fmt.Sprintf("bridge vlan %s vid %d dev %s", op, vid, link.Attrs().Name)
+	if pvid {
+		cmd += " pvid"
+	}
+	if untagged {
+		cmd += " untagged"
+	}
+	if self {
+		cmd += " self"
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.Ops = append(n.Ops, cmd)
+}
 func (n *DryRunNetlinker) ParseAddr(s string) (*netlink.Addr, error) {
 	return netlink.ParseAddr(s)
 }
@@ -31,6 +31,9 @@ type Manager struct {
 	cmd             CommandExecutor
 	dns             DNSUpdater
 	uidRulePriority int
+	pd              pdState               // DHCPv6 prefix delegation
+	ppp             pppoeState            // PPPoE clients
+	bridgePorts     map[string]bridgePort // Bridge members, by interface
 }
 
 // DNSUpdater is an interface for updating the DNS service dynamically.
@@ -90,7 +93,14 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 	var err error
 
 	// Try to find the interface
-	link, err = m.nl.LinkByName(ifaceCfg.Name)
+	if createsLink(ifaceCfg) {
+		link, err = m.ensureVirtualLink(ifaceCfg)
+		if err != nil {
+			return err
+		}
+	} else {
+		link, err = m.nl.LinkByName(ifaceCfg.Name)
+	}
 
 	if err != nil {
 		// If it's a bond interface config and it doesn't exist, we attempt to create it.
@@ -145,6 +155,11 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 		addrs, err := m.nl.AddrList(link, unix.AF_UNSPEC) // AF_UNSPEC for both IPv4 and IPv6
 		if err == nil {
 			for _, addr := range addrs {
+				// IPv6 link-local addresses belong to the kernel, and router
+				// advertisements and OSPFv3 need them
+				if addr.IP.To4() == nil && addr.IP.IsLinkLocalUnicast() {
+					continue
+				}
 				if err := m.nl.AddrDel(link, &addr); err != nil {
 					log.Printf("Warning: failed to delete IP address %s from %s: %v", addr.IPNet.String(), ifaceCfg.Name, err)
 				}
@@ -190,6 +205,14 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 		}
 	}
 
+	// PPPoE runs over the interface (or a VLAN on it); addresses are set on
+	// the PPP link once the session is up.
+	if ifaceCfg.PPPoE != nil && manageIPs {
+		if err := m.StartPPPoEClient(ifaceCfg); err != nil {
+			log.Printf("Error starting PPPoE client on %s: %v", ifaceCfg.Name, err)
+		}
+	}
+
 	// IPv6 Configuration
 	// Only apply if we manage IPs. If external, we assume they handle RA/DHCPv6 too,
 	// or user explicitly configured v6 settings here which conflicts with "external".
@@ -206,8 +229,11 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 			// Client Mode
 			_ = m.sys.WriteSysctl(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/accept_ra", ifaceCfg.Name), "2")
 
-			if err := StartDHCPv6Client(ifaceCfg.Name); err != nil {
-				log.Printf("Error starting DHCPv6 client on %s: %v", ifaceCfg.Name, err)
+			if err := m.StartNativeDHCPv6Client(ifaceCfg); err != nil {
+				log.Printf("Error starting native DHCPv6 client on %s: %v. Falling back to system client.", ifaceCfg.Name, err)
+				if err := StartDHCPv6Client(ifaceCfg.Name); err != nil {
+					log.Printf("Error starting DHCPv6 client on %s: %v", ifaceCfg.Name, err)
+				}
 			}
 		}
 
@@ -222,6 +248,11 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 				log.Printf("Warning: failed to add IPv6 address %s to %s: %v", ipStr, ifaceCfg.Name, err)
 			}
 		}
+
+		// 3. Addresses from a delegated prefix
+		if ifaceCfg.PDFrom != "" {
+			m.registerPDDownstream(ifaceCfg)
+		}
 	}
 
 	// Interface is already brought up above.
@@ -233,6 +264,12 @@ func (m *Manager) ApplyInterface(ifaceCfg config.Interface) error {
 		}
 	}
 
+	// Bridge options and member ports
+	if ifaceCfg.Bridge != nil {
+		m.applyBridge(link, ifaceCfg)
+	}
+	m.joinPendingBridge(link)
+
 	// If this is a bond master interface, add its members
 	if ifaceCfg.Bond != nil {
 		for _, memberName := range ifaceCfg.Bond.Interfaces {
@@ -70,7 +70,11 @@ func TestApplyInterfaceIPv6(t *testing.T) {
 	mockNetlink.On("LinkByName", "eth0").Return(eth0Link, nil).Once()
 	mockNetlink.On("LinkSetDown", eth0Link).Return(nil).Once()
 	mockNetlink.On("LinkSetMTU", eth0Link, 1500).Return(nil).Once()
-	mockNetlink.On("AddrList", eth0Link, unix.AF_UNSPEC).Return([]netlink.Addr{}, nil).Once()
+	// Stale addresses are removed, but the kernel's link-local address stays
+	staleAddr, _ := netlink.ParseAddr("192.168.1.99/24")
+	linkLocal, _ := netlink.ParseAddr("fe80::1/64")
+	mockNetlink.On("AddrList", eth0Link, unix.AF_UNSPEC).Return([]netlink.Addr{*staleAddr, *linkLocal}, nil).Once()
+	mockNetlink.On("AddrDel", eth0Link, staleAddr).Return(nil).Once()
 
 	// IPv4 Expectations
 	expectedAddr4, _ := netlink.ParseAddr("192.168.1.10/24")
@@ -85,6 +85,21 @@ func (m *MockNetlinker) RuleDel(rule *netlink.Rule) error {
 	args := m.Called(rule)
 	return args.Error(0)
 }
+func (m *MockNetlinker) BridgeVlanAdd(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	args := m.Called(link, vid, pvid, untagged, self, master)
+	return args.Error(0)
+}
+func (m *MockNetlinker) BridgeVlanDel(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	args := m.Called(link, vid, pvid, untagged, self, master)
+	return args.Error(0)
+}
+func (m *MockNetlinker) BridgeVlanList(link netlink.Link) ([]BridgeVLAN, error) {
+	args := m.Called(link)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]BridgeVLAN), args.Error(1)
+}
 func (m *MockNetlinker) ParseAddr(s string) (*netlink.Addr, error) {
 	args := m.Called(s)
 	return args.Get(0).(*netlink.Addr), args.Error(1)
@@ -84,6 +84,18 @@ func (r *RealNetlinker) RuleDel(rule *netlink.Rule) error {
 	return nil
 }
 
+func (r *RealNetlinker) BridgeVlanAdd(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	return nil
+}
+
+func (r *RealNetlinker) BridgeVlanDel(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	return nil
+}
+
+func (r *RealNetlinker) BridgeVlanList(link netlink.Link) ([]BridgeVLAN, error) {
+	return nil, nil
+}
+
 func (r *RealNetlinker) ParseAddr(s string) (*netlink.Addr, error) {
 	return netlink.ParseAddr(s)
 }
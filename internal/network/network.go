@@ -15,6 +15,7 @@ type NetworkManager interface {
 	InitializeDHCPClientManager(cfg *config.DHCPServer)
 	StopDHCPClientManager()
 	ApplyInterface(ifaceCfg config.Interface) error
+	RemoveStaleInterfaces(interfaces []config.Interface) error
 	ApplyStaticRoutes(routes []config.Route) error
 	WaitForLinkIP(ifaceName string, timeoutSeconds int) error
 	GetDHCPLeases() map[string]LeaseInfo
@@ -63,10 +64,21 @@ type Netlinker interface {
 	RuleAdd(rule *netlink.Rule) error
 	RuleDel(rule *netlink.Rule) error
 
+	BridgeVlanAdd(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error
+	BridgeVlanDel(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error
+	BridgeVlanList(link netlink.Link) ([]BridgeVLAN, error)
+
 	ParseAddr(s string) (*netlink.Addr, error)
 	ParseIPNet(s string) (*net.IPNet, error)
 }
 
+// BridgeVLAN is a VLAN configured on a bridge port.
+type BridgeVLAN struct {
+	ID       uint16
+	PVID     bool
+	Untagged bool
+}
+
 // SystemController is an interface that abstracts system-level operations like sysctl.
 type SystemController interface {
 	ReadSysctl(path string) (string, error)
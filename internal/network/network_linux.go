@@ -102,6 +102,29 @@ func (r *RealNetlinker) RuleList(family int) ([]netlink.Rule, error) {
 	return netlink.RuleList(family)
 }
 
+// BridgeVlanAdd adds a VLAN to a bridge port.
+func (r *RealNetlinker) BridgeVlanAdd(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	return netlink.BridgeVlanAdd(link, vid, pvid, untagged, self, master)
+}
+
+// BridgeVlanDel removes a VLAN from a bridge port.
+func (r *RealNetlinker) BridgeVlanDel(link netlink.Link, vid uint16, pvid, untagged, self, master bool) error {
+	return netlink.BridgeVlanDel(link, vid, pvid, untagged, self, master)
+}
+
+// BridgeVlanList lists the VLANs of a bridge port.
+func (r *RealNetlinker) BridgeVlanList(link netlink.Link) ([]BridgeVLAN, error) {
+	all, err := netlink.BridgeVlanList()
+	if err != nil {
+		return nil, err
+	}
+	var vlans []BridgeVLAN
+	for _, info := range all[int32(link.Attrs().Index)] {
+		vlans = append(vlans, BridgeVLAN{ID: info.Vid, PVID: info.PortVID(), Untagged: info.EngressUntag()})
+	}
+	return vlans, nil
+}
+
 // ParseAddr parses an IP address string.
 func (r *RealNetlinker) ParseAddr(s string) (*netlink.Addr, error) {
 	return netlink.ParseAddr(s)
@@ -0,0 +1,856 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// PPP protocol numbers (RFC 1661, 1332, 5072, 1334, 1994).
+const (
+	pppProtoIPv4   = 0x0021
+	pppProtoIPv6   = 0x0057
+	pppProtoIPCP   = 0x8021
+	pppProtoIPv6CP = 0x8057
+	pppProtoLCP    = 0xc021
+	pppProtoPAP    = 0xc023
+	pppProtoCHAP   = 0xc223
+)
+
+// Control protocol codes. Codes above Code-Reject are LCP only.
+const (
+	cpConfigureRequest = 1
+	cpConfigureAck     = 2
+	cpConfigureNak     = 3
+	cpConfigureReject  = 4
+	cpTerminateRequest = 5
+	cpTerminateAck     = 6
+	cpCodeReject       = 7
+	cpProtocolReject   = 8
+	cpEchoRequest      = 9
+	cpEchoReply        = 10
+	cpDiscardRequest   = 11
+)
+
+const (
+	lcpOptMRU   = 1
+	lcpOptACCM  = 2
+	lcpOptAuth  = 3
+	lcpOptMagic = 5
+
+	ipcpOptAddress      = 3
+	ipcpOptPrimaryDNS   = 129
+	ipcpOptSecondaryDNS = 131
+
+	ipv6cpOptInterfaceID = 1
+
+	chapMD5 = 5
+
+	pppDefaultMRU = 1500
+)
+
+// pppNoPeerAddress stands in for the peer of a point-to-point link when the
+// access concentrator does not announce its address, as pppd does.
+var pppNoPeerAddress = net.IPv4(10, 64, 64, 64).To4()
+
+// pppFrame is a PPP frame without address and control fields.
+type pppFrame struct {
+	proto uint16
+	data  []byte
+}
+
+// cpPacket is an LCP, IPCP or IPv6CP packet.
+type cpPacket struct {
+	code uint8
+	id   uint8
+	data []byte
+}
+
+func parseCPPacket(b []byte) (cpPacket, error) {
+	if len(b) < 4 {
+		return cpPacket{}, fmt.Errorf("packet too short: %d bytes", len(b))
+	}
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if n < 4 || n > len(b) {
+		return cpPacket{}, fmt.Errorf("invalid length %d", n)
+	}
+	return cpPacket{code: b[0], id: b[1], data: b[4:n]}, nil
+}
+
+func (p cpPacket) marshal() []byte {
+	b := make([]byte, 4+len(p.data))
+	b[0], b[1] = p.code, p.id
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	copy(b[4:], p.data)
+	return b
+}
+
+// cpOption is a configuration option of a control protocol.
+type cpOption struct {
+	typ  uint8
+	data []byte
+}
+
+func parseCPOptions(b []byte) ([]cpOption, error) {
+	var opts []cpOption
+	for len(b) > 0 {
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return nil, fmt.Errorf("malformed option")
+		}
+		opts = append(opts, cpOption{typ: b[0], data: b[2:b[1]]})
+		b = b[b[1]:]
+	}
+	return opts, nil
+}
+
+func marshalCPOptions(opts []cpOption) []byte {
+	var b []byte
+	for _, o := range opts {
+		b = append(b, o.typ, uint8(2+len(o.data)))
+		b = append(b, o.data...)
+	}
+	return b
+}
+
+// cpNegotiator holds the options of one control protocol.
+type cpNegotiator interface {
+	// request returns the options for our next Configure-Request.
+	request() []cpOption
+	// nak adopts the values the peer suggested for our options.
+	nak(opts []cpOption)
+	// reject stops requesting options the peer does not recognise.
+	reject(opts []cpOption)
+	// peerRequest answers the peer's Configure-Request with an Ack, Nak
+	// or Reject and the options to send back.
+	peerRequest(opts []cpOption) (uint8, []cpOption)
+}
+
+// controlProtocol runs the option negotiation of RFC 1661 for one protocol.
+type controlProtocol struct {
+	proto   uint16
+	name    string
+	n       cpNegotiator
+	started bool
+	reqID   uint8 // Identifier of our outstanding Configure-Request
+	sent    time.Time
+	tries   int
+	ackRcvd bool
+	ackSent bool
+}
+
+func (c *controlProtocol) opened() bool {
+	return c.ackRcvd && c.ackSent
+}
+
+// pppConfig holds the settings of a PPP session.
+type pppConfig struct {
+	username     string
+	password     string
+	mru          int
+	ipv6         bool
+	echoInterval time.Duration
+	echoFailure  int
+	restart      time.Duration // Configure-Request retransmission interval
+	maxConfigure int
+}
+
+// pppLinkInfo is what the session negotiated for the link.
+type pppLinkInfo struct {
+	mtu      int
+	localIP  net.IP
+	peerIP   net.IP
+	dns      []net.IP
+	localIID []byte // IPv6 interface identifiers, once IPv6CP is open
+	peerIID  []byte
+}
+
+// pppSession runs LCP, authentication and the IPCP/IPv6CP network phase
+// over a PPP channel. Frames are passed in by the caller and written with
+// send, so the same engine drives a kernel channel or a test peer.
+type pppSession struct {
+	cfg  pppConfig
+	send func(proto uint16, data []byte) error
+	onUp func(pppLinkInfo)
+
+	lcp    *controlProtocol
+	ipcp   *controlProtocol
+	ipv6cp *controlProtocol
+
+	lcpOpts  *lcpNegotiator
+	ipcpOpts *ipcpNegotiator
+	ipv6Opts *ipv6cpNegotiator
+
+	nextID   uint8
+	network  bool // Authentication done, NCPs started
+	ipUp     bool
+	ipv6Up   bool
+	papSent  time.Time
+	papTries int
+	papID    uint8
+
+	lastEcho    time.Time
+	echoPending bool
+	echoMissed  int
+}
+
+func newPPPSession(cfg pppConfig, send func(uint16, []byte) error, onUp func(pppLinkInfo)) *pppSession {
+	if cfg.mru == 0 {
+		cfg.mru = pppoeDefaultMTU
+	}
+	if cfg.restart == 0 {
+		cfg.restart = 3 * time.Second
+	}
+	if cfg.maxConfigure == 0 {
+		cfg.maxConfigure = 10
+	}
+	s := &pppSession{cfg: cfg, send: send, onUp: onUp}
+	s.lcpOpts = &lcpNegotiator{mru: cfg.mru, sendMRU: true, magic: randomMagic(), sendMagic: true, peerMRU: pppDefaultMRU}
+	s.ipcpOpts = &ipcpNegotiator{addr: net.IPv4zero, dns: [2]net.IP{net.IPv4zero, net.IPv4zero}, sendDNS: [2]bool{true, true}}
+	s.ipv6Opts = &ipv6cpNegotiator{local: randomInterfaceID()}
+	s.lcp = &controlProtocol{proto: pppProtoLCP, name: "LCP", n: s.lcpOpts}
+	s.ipcp = &controlProtocol{proto: pppProtoIPCP, name: "IPCP", n: s.ipcpOpts}
+	s.ipv6cp = &controlProtocol{proto: pppProtoIPv6CP, name: "IPv6CP", n: s.ipv6Opts}
+	return s
+}
+
+// run negotiates the link and keeps it alive until ctx is cancelled, the
+// peer terminates it or it stops answering keepalives.
+func (s *pppSession) run(ctx context.Context, frames <-chan pppFrame) error {
+	if err := s.open(s.lcp); err != nil {
+		return err
+	}
+
+	tick := time.NewTicker(s.tickInterval())
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.sendCP(pppProtoLCP, cpPacket{code: cpTerminateRequest, id: s.id()})
+			return nil
+		case f, ok := <-frames:
+			if !ok {
+				return errors.New("PPP channel closed")
+			}
+			if err := s.handle(f); err != nil {
+				return err
+			}
+		case now := <-tick.C:
+			if err := s.timeout(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *pppSession) tickInterval() time.Duration {
+	d := s.cfg.restart
+	if s.cfg.echoInterval > 0 && s.cfg.echoInterval < d {
+		d = s.cfg.echoInterval
+	}
+	return d
+}
+
+func (s *pppSession) id() uint8 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *pppSession) sendCP(proto uint16, p cpPacket) error {
+	return s.send(proto, p.marshal())
+}
+
+// open starts negotiating a control protocol.
+func (s *pppSession) open(c *controlProtocol) error {
+	c.started = true
+	c.tries = 0
+	c.ackRcvd = false
+	return s.sendRequest(c)
+}
+
+func (s *pppSession) sendRequest(c *controlProtocol) error {
+	c.reqID = s.id()
+	c.sent = time.Now()
+	c.tries++
+	return s.sendCP(c.proto, cpPacket{code: cpConfigureRequest, id: c.reqID, data: marshalCPOptions(c.n.request())})
+}
+
+// timeout retransmits unanswered requests and sends keepalives.
+func (s *pppSession) timeout(now time.Time) error {
+	for _, c := range []*controlProtocol{s.lcp, s.ipcp, s.ipv6cp} {
+		if !c.started || c.ackRcvd || now.Sub(c.sent) < s.cfg.restart {
+			continue
+		}
+		if c.tries >= s.cfg.maxConfigure {
+			if c == s.ipv6cp {
+				log.Printf("[network] PPP: peer does not answer IPv6CP, continuing without IPv6")
+				c.started = false
+				continue
+			}
+			return fmt.Errorf("%s negotiation timed out", c.name)
+		}
+		if err := s.sendRequest(c); err != nil {
+			return err
+		}
+	}
+
+	if s.papTries > 0 && !s.network && now.Sub(s.papSent) >= s.cfg.restart {
+		if s.papTries >= s.cfg.maxConfigure {
+			return errors.New("PAP authentication timed out")
+		}
+		s.sendPAP()
+	}
+
+	if s.lcp.opened() && s.cfg.echoInterval > 0 && now.Sub(s.lastEcho) >= s.cfg.echoInterval {
+		if s.echoPending {
+			s.echoMissed++
+			if s.cfg.echoFailure > 0 && s.echoMissed >= s.cfg.echoFailure {
+				return fmt.Errorf("peer missed %d LCP echo requests", s.echoMissed)
+			}
+		}
+		s.lastEcho = now
+		s.echoPending = true
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, s.lcpOpts.magic)
+		return s.sendCP(pppProtoLCP, cpPacket{code: cpEchoRequest, id: s.id(), data: data})
+	}
+	return nil
+}
+
+func (s *pppSession) handle(f pppFrame) error {
+	switch f.proto {
+	case pppProtoLCP:
+		return s.handleCP(s.lcp, f.data)
+	case pppProtoPAP:
+		return s.handlePAP(f.data)
+	case pppProtoCHAP:
+		return s.handleCHAP(f.data)
+	case pppProtoIPCP, pppProtoIPv6CP:
+		// NCP packets before the network phase are silently discarded
+		if !s.network {
+			return nil
+		}
+		if f.proto == pppProtoIPv6CP && !s.cfg.ipv6 {
+			return s.protocolReject(f)
+		}
+		c := s.ipcp
+		if f.proto == pppProtoIPv6CP {
+			c = s.ipv6cp
+		}
+		return s.handleCP(c, f.data)
+	case pppProtoIPv4, pppProtoIPv6:
+		return nil // Data is handled by the kernel
+	default:
+		if s.lcp.opened() {
+			return s.protocolReject(f)
+		}
+		return nil
+	}
+}
+
+func (s *pppSession) protocolReject(f pppFrame) error {
+	data := make([]byte, 2, 2+len(f.data))
+	binary.BigEndian.PutUint16(data, f.proto)
+	data = append(data, f.data...)
+	if max := s.lcpOpts.peerMRU - 4; len(data) > max {
+		data = data[:max]
+	}
+	return s.sendCP(pppProtoLCP, cpPacket{code: cpProtocolReject, id: s.id(), data: data})
+}
+
+func (s *pppSession) handleCP(c *controlProtocol, b []byte) error {
+	p, err := parseCPPacket(b)
+	if err != nil {
+		log.Printf("[network] PPP: dropping malformed %s packet: %v", c.name, err)
+		return nil
+	}
+
+	switch p.code {
+	case cpConfigureRequest:
+		opts, err := parseCPOptions(p.data)
+		if err != nil {
+			log.Printf("[network] PPP: dropping %s Configure-Request: %v", c.name, err)
+			return nil
+		}
+		if c.opened() {
+			// The peer renegotiates; LCP restarts the whole link
+			if c == s.lcp {
+				return errors.New("peer restarted LCP negotiation")
+			}
+			c.ackRcvd = false
+			if err := s.sendRequest(c); err != nil {
+				return err
+			}
+		}
+		code, reply := c.n.peerRequest(opts)
+		c.ackSent = code == cpConfigureAck
+		if err := s.sendCP(c.proto, cpPacket{code: code, id: p.id, data: marshalCPOptions(reply)}); err != nil {
+			return err
+		}
+
+	case cpConfigureAck:
+		if p.id == c.reqID && c.started {
+			c.ackRcvd = true
+		}
+
+	case cpConfigureNak, cpConfigureReject:
+		if p.id != c.reqID || !c.started || c.ackRcvd {
+			return nil
+		}
+		opts, err := parseCPOptions(p.data)
+		if err != nil {
+			return nil
+		}
+		if p.code == cpConfigureNak {
+			c.n.nak(opts)
+		} else {
+			c.n.reject(opts)
+		}
+		if err := s.sendRequest(c); err != nil {
+			return err
+		}
+
+	case cpTerminateRequest:
+		s.sendCP(c.proto, cpPacket{code: cpTerminateAck, id: p.id})
+		if c != s.ipv6cp {
+			return fmt.Errorf("peer terminated %s", c.name)
+		}
+		log.Printf("[network] PPP: peer closed IPv6CP")
+		c.ackRcvd, c.ackSent = false, false
+
+	case cpTerminateAck, cpDiscardRequest:
+
+	case cpCodeReject:
+		log.Printf("[network] PPP: peer rejected a %s code", c.name)
+
+	case cpProtocolReject:
+		if c != s.lcp || len(p.data) < 2 {
+			return s.codeReject(c, b)
+		}
+		switch binary.BigEndian.Uint16(p.data) {
+		case pppProtoIPCP:
+			return errors.New("peer rejected IPCP")
+		case pppProtoIPv6CP:
+			log.Printf("[network] PPP: peer does not support IPv6CP, continuing without IPv6")
+			s.ipv6cp.started = false
+		}
+
+	case cpEchoRequest:
+		if c != s.lcp {
+			return s.codeReject(c, b)
+		}
+		if s.lcp.opened() && len(p.data) >= 4 {
+			data := append([]byte(nil), p.data...)
+			binary.BigEndian.PutUint32(data, s.lcpOpts.magic)
+			return s.sendCP(pppProtoLCP, cpPacket{code: cpEchoReply, id: p.id, data: data})
+		}
+
+	case cpEchoReply:
+		if c != s.lcp {
+			return s.codeReject(c, b)
+		}
+		s.echoPending = false
+		s.echoMissed = 0
+
+	default:
+		return s.codeReject(c, b)
+	}
+
+	return s.advance()
+}
+
+func (s *pppSession) codeReject(c *controlProtocol, b []byte) error {
+	if max := s.lcpOpts.peerMRU - 4; len(b) > max {
+		b = b[:max]
+	}
+	return s.sendCP(c.proto, cpPacket{code: cpCodeReject, id: s.id(), data: b})
+}
+
+// advance moves through the link phases as protocols open.
+func (s *pppSession) advance() error {
+	if !s.lcp.opened() {
+		return nil
+	}
+	if !s.network && s.papTries == 0 {
+		switch s.lcpOpts.auth {
+		case 0:
+			return s.startNetwork()
+		case pppProtoPAP:
+			return s.sendPAP()
+		}
+		// CHAP waits for the peer's challenge
+	}
+
+	if s.ipcp.opened() && !s.ipUp {
+		s.ipUp = true
+		s.report()
+	}
+	if s.ipv6cp.opened() && !s.ipv6Up {
+		s.ipv6Up = true
+		s.report()
+	}
+	return nil
+}
+
+func (s *pppSession) startNetwork() error {
+	if s.network {
+		return nil
+	}
+	s.network = true
+	if err := s.open(s.ipcp); err != nil {
+		return err
+	}
+	if s.cfg.ipv6 {
+		return s.open(s.ipv6cp)
+	}
+	return nil
+}
+
+// report passes the negotiated link to onUp.
+func (s *pppSession) report() {
+	if !s.ipUp || s.onUp == nil {
+		return
+	}
+	mtu := s.cfg.mru
+	if s.lcpOpts.peerMRU < mtu {
+		mtu = s.lcpOpts.peerMRU
+	}
+	info := pppLinkInfo{
+		mtu:     mtu,
+		localIP: s.ipcpOpts.addr,
+		peerIP:  s.ipcpOpts.peer,
+	}
+	if info.peerIP == nil || info.peerIP.IsUnspecified() {
+		info.peerIP = pppNoPeerAddress
+	}
+	for _, dns := range s.ipcpOpts.dns {
+		if dns != nil && !dns.IsUnspecified() {
+			info.dns = append(info.dns, dns)
+		}
+	}
+	if s.ipv6Up {
+		info.localIID = s.ipv6Opts.local[:]
+		info.peerIID = s.ipv6Opts.peer
+	}
+	s.onUp(info)
+}
+
+// sendPAP sends a PAP Authenticate-Request (RFC 1334).
+func (s *pppSession) sendPAP() error {
+	user, pass := []byte(s.cfg.username), []byte(s.cfg.password)
+	data := make([]byte, 0, 2+len(user)+len(pass))
+	data = append(data, uint8(len(user)))
+	data = append(data, user...)
+	data = append(data, uint8(len(pass)))
+	data = append(data, pass...)
+
+	if s.papTries == 0 {
+		s.papID = s.id()
+	}
+	s.papTries++
+	s.papSent = time.Now()
+	return s.sendCP(pppProtoPAP, cpPacket{code: 1, id: s.papID, data: data})
+}
+
+func (s *pppSession) handlePAP(b []byte) error {
+	p, err := parseCPPacket(b)
+	if err != nil || p.id != s.papID || s.papTries == 0 {
+		return nil
+	}
+	switch p.code {
+	case 2: // Authenticate-Ack
+		if err := s.startNetwork(); err != nil {
+			return err
+		}
+		return s.advance()
+	case 3: // Authenticate-Nak
+		return fmt.Errorf("PAP authentication failed: %s", authMessage(p.data))
+	}
+	return nil
+}
+
+// handleCHAP answers CHAP challenges (RFC 1994). The peer may challenge
+// again at any time during the session.
+func (s *pppSession) handleCHAP(b []byte) error {
+	p, err := parseCPPacket(b)
+	if err != nil || s.lcpOpts.auth != pppProtoCHAP {
+		return nil
+	}
+	switch p.code {
+	case 1: // Challenge
+		if len(p.data) < 1 || int(p.data[0])+1 > len(p.data) {
+			return nil
+		}
+		challenge := p.data[1 : 1+p.data[0]]
+		resp := chapMD5Response(p.id, s.cfg.password, challenge)
+		data := append([]byte{uint8(len(resp))}, resp...)
+		data = append(data, s.cfg.username...)
+		return s.sendCP(pppProtoCHAP, cpPacket{code: 2, id: p.id, data: data})
+	case 3: // Success
+		if err := s.startNetwork(); err != nil {
+			return err
+		}
+		return s.advance()
+	case 4: // Failure
+		return fmt.Errorf("CHAP authentication failed: %s", bytes.TrimSpace(p.data))
+	}
+	return nil
+}
+
+// chapMD5Response computes the CHAP-MD5 response to a challenge.
+func chapMD5Response(id uint8, secret string, challenge []byte) []byte {
+	h := md5.New()
+	h.Write([]byte{id})
+	h.Write([]byte(secret))
+	h.Write(challenge)
+	return h.Sum(nil)
+}
+
+// authMessage extracts the message of a PAP Ack or Nak.
+func authMessage(data []byte) string {
+	if len(data) < 1 || int(data[0])+1 > len(data) {
+		return "no reason given"
+	}
+	return string(data[1 : 1+data[0]])
+}
+
+// lcpNegotiator negotiates the MRU, magic number and authentication.
+type lcpNegotiator struct {
+	mru       int
+	sendMRU   bool
+	magic     uint32
+	sendMagic bool
+
+	peerMRU int
+	auth    uint16 // Authentication protocol the peer requires
+}
+
+func (l *lcpNegotiator) request() []cpOption {
+	var opts []cpOption
+	if l.sendMRU {
+		data := make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(l.mru))
+		opts = append(opts, cpOption{typ: lcpOptMRU, data: data})
+	}
+	if l.sendMagic {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, l.magic)
+		opts = append(opts, cpOption{typ: lcpOptMagic, data: data})
+	}
+	return opts
+}
+
+func (l *lcpNegotiator) nak(opts []cpOption) {
+	for _, o := range opts {
+		switch o.typ {
+		case lcpOptMRU:
+			if len(o.data) == 2 {
+				if mru := int(binary.BigEndian.Uint16(o.data)); mru < l.mru {
+					l.mru = mru
+				}
+			}
+		case lcpOptMagic:
+			l.magic = randomMagic()
+		}
+	}
+}
+
+func (l *lcpNegotiator) reject(opts []cpOption) {
+	for _, o := range opts {
+		switch o.typ {
+		case lcpOptMRU:
+			l.sendMRU = false
+		case lcpOptMagic:
+			l.sendMagic = false
+			l.magic = 0
+		}
+	}
+}
+
+func (l *lcpNegotiator) peerRequest(opts []cpOption) (uint8, []cpOption) {
+	var naks, rejects []cpOption
+	mru, auth := pppDefaultMRU, uint16(0)
+	for _, o := range opts {
+		switch o.typ {
+		case lcpOptMRU:
+			if len(o.data) != 2 {
+				rejects = append(rejects, o)
+				continue
+			}
+			mru = int(binary.BigEndian.Uint16(o.data))
+		case lcpOptACCM:
+			// Meaningless on PPPoE, but harmless to accept
+		case lcpOptAuth:
+			if len(o.data) < 2 {
+				rejects = append(rejects, o)
+				continue
+			}
+			switch proto := binary.BigEndian.Uint16(o.data); {
+			case proto == pppProtoPAP:
+				auth = proto
+			case proto == pppProtoCHAP && len(o.data) == 3 && o.data[2] == chapMD5:
+				auth = proto
+			default:
+				// Only CHAP-MD5 and PAP are implemented
+				naks = append(naks, cpOption{typ: lcpOptAuth, data: []byte{0xc2, 0x23, chapMD5}})
+			}
+		case lcpOptMagic:
+			if len(o.data) != 4 {
+				rejects = append(rejects, o)
+				continue
+			}
+			if l.sendMagic && binary.BigEndian.Uint32(o.data) == l.magic {
+				// Looped back link, or the peer picked the same number
+				data := make([]byte, 4)
+				binary.BigEndian.PutUint32(data, randomMagic())
+				naks = append(naks, cpOption{typ: lcpOptMagic, data: data})
+			}
+		default:
+			rejects = append(rejects, o)
+		}
+	}
+
+	if len(rejects) > 0 {
+		return cpConfigureReject, rejects
+	}
+	if len(naks) > 0 {
+		return cpConfigureNak, naks
+	}
+	l.peerMRU, l.auth = mru, auth
+	return cpConfigureAck, opts
+}
+
+// ipcpNegotiator obtains an IPv4 address and DNS servers (RFC 1332, 1877).
+type ipcpNegotiator struct {
+	addr    net.IP
+	dns     [2]net.IP
+	sendDNS [2]bool
+	peer    net.IP
+}
+
+var ipcpDNSOptions = [2]uint8{ipcpOptPrimaryDNS, ipcpOptSecondaryDNS}
+
+func (n *ipcpNegotiator) request() []cpOption {
+	opts := []cpOption{{typ: ipcpOptAddress, data: n.addr.To4()}}
+	for i, typ := range ipcpDNSOptions {
+		if n.sendDNS[i] {
+			opts = append(opts, cpOption{typ: typ, data: n.dns[i].To4()})
+		}
+	}
+	return opts
+}
+
+func (n *ipcpNegotiator) nak(opts []cpOption) {
+	for _, o := range opts {
+		if len(o.data) != 4 {
+			continue
+		}
+		ip := net.IP(append([]byte(nil), o.data...))
+		switch o.typ {
+		case ipcpOptAddress:
+			n.addr = ip
+		case ipcpOptPrimaryDNS:
+			n.dns[0] = ip
+		case ipcpOptSecondaryDNS:
+			n.dns[1] = ip
+		}
+	}
+}
+
+func (n *ipcpNegotiator) reject(opts []cpOption) {
+	for _, o := range opts {
+		switch o.typ {
+		case ipcpOptPrimaryDNS:
+			n.sendDNS[0] = false
+		case ipcpOptSecondaryDNS:
+			n.sendDNS[1] = false
+		}
+	}
+}
+
+func (n *ipcpNegotiator) peerRequest(opts []cpOption) (uint8, []cpOption) {
+	var rejects []cpOption
+	var peer net.IP
+	for _, o := range opts {
+		if o.typ == ipcpOptAddress && len(o.data) == 4 {
+			peer = net.IP(append([]byte(nil), o.data...))
+			continue
+		}
+		rejects = append(rejects, o)
+	}
+	if len(rejects) > 0 {
+		return cpConfigureReject, rejects
+	}
+	n.peer = peer
+	return cpConfigureAck, opts
+}
+
+// ipv6cpNegotiator agrees on interface identifiers for the link-local
+// addresses (RFC 5072).
+type ipv6cpNegotiator struct {
+	local [8]byte
+	peer  []byte
+}
+
+func (n *ipv6cpNegotiator) request() []cpOption {
+	return []cpOption{{typ: ipv6cpOptInterfaceID, data: n.local[:]}}
+}
+
+func (n *ipv6cpNegotiator) nak(opts []cpOption) {
+	for _, o := range opts {
+		if o.typ == ipv6cpOptInterfaceID && len(o.data) == 8 {
+			copy(n.local[:], o.data)
+		}
+	}
+}
+
+func (n *ipv6cpNegotiator) reject(opts []cpOption) {}
+
+func (n *ipv6cpNegotiator) peerRequest(opts []cpOption) (uint8, []cpOption) {
+	var naks, rejects []cpOption
+	var peer []byte
+	for _, o := range opts {
+		if o.typ != ipv6cpOptInterfaceID || len(o.data) != 8 {
+			rejects = append(rejects, o)
+			continue
+		}
+		if bytes.Equal(o.data, make([]byte, 8)) || bytes.Equal(o.data, n.local[:]) {
+			id := randomInterfaceID()
+			naks = append(naks, cpOption{typ: ipv6cpOptInterfaceID, data: id[:]})
+			continue
+		}
+		peer = append([]byte(nil), o.data...)
+	}
+	if len(rejects) > 0 {
+		return cpConfigureReject, rejects
+	}
+	if len(naks) > 0 {
+		return cpConfigureNak, naks
+	}
+	n.peer = peer
+	return cpConfigureAck, opts
+}
+
+func randomMagic() uint32 {
+	var b [4]byte
+	for {
+		rand.Read(b[:])
+		if m := binary.BigEndian.Uint32(b[:]); m != 0 {
+			return m
+		}
+	}
+}
+
+func randomInterfaceID() [8]byte {
+	var id [8]byte
+	for id == [8]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,205 @@
+package network
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pppPeer records the frames a session sends, standing in for the access
+// concentrator.
+type pppPeer struct {
+	t    *testing.T
+	sent []pppFrame
+}
+
+func (p *pppPeer) send(proto uint16, data []byte) error {
+	p.sent = append(p.sent, pppFrame{proto: proto, data: append([]byte(nil), data...)})
+	return nil
+}
+
+// last returns the last packet sent for proto.
+func (p *pppPeer) last(proto uint16) cpPacket {
+	p.t.Helper()
+	for i := len(p.sent) - 1; i >= 0; i-- {
+		if p.sent[i].proto == proto {
+			pkt, err := parseCPPacket(p.sent[i].data)
+			if err != nil {
+				p.t.Fatalf("session sent malformed packet: %v", err)
+			}
+			return pkt
+		}
+	}
+	p.t.Fatalf("no packet sent for protocol 0x%04x", proto)
+	return cpPacket{}
+}
+
+func (p *pppPeer) deliver(s *pppSession, proto uint16, pkt cpPacket) error {
+	return s.handle(pppFrame{proto: proto, data: pkt.marshal()})
+}
+
+func ipOption(typ uint8, ip string) cpOption {
+	return cpOption{typ: typ, data: net.ParseIP(ip).To4()}
+}
+
+// negotiateLCP opens LCP with the peer requiring authentication auth.
+func negotiateLCP(t *testing.T, s *pppSession, peer *pppPeer, auth []byte) {
+	t.Helper()
+	if err := s.open(s.lcp); err != nil {
+		t.Fatal(err)
+	}
+	req := peer.last(pppProtoLCP)
+	opts, _ := parseCPOptions(req.data)
+	if len(opts) != 2 || opts[0].typ != lcpOptMRU || binary.BigEndian.Uint16(opts[0].data) != 1492 {
+		t.Fatalf("LCP request options = %v", opts)
+	}
+
+	peerOpts := []cpOption{
+		{typ: lcpOptMRU, data: []byte{0x05, 0xd4}},
+		{typ: lcpOptMagic, data: []byte{1, 2, 3, 4}},
+	}
+	if auth != nil {
+		peerOpts = append(peerOpts, cpOption{typ: lcpOptAuth, data: auth})
+	}
+	peer.deliver(s, pppProtoLCP, cpPacket{code: cpConfigureRequest, id: 1, data: marshalCPOptions(peerOpts)})
+	if ack := peer.last(pppProtoLCP); ack.code != cpConfigureAck || ack.id != 1 {
+		t.Fatalf("LCP reply = %+v, want Configure-Ack", ack)
+	}
+	peer.deliver(s, pppProtoLCP, cpPacket{code: cpConfigureAck, id: req.id, data: req.data})
+	if !s.lcp.opened() {
+		t.Fatal("LCP not opened")
+	}
+}
+
+func TestPPPSession_CHAPAndIPCP(t *testing.T) {
+	peer := &pppPeer{t: t}
+	var up []pppLinkInfo
+	s := newPPPSession(pppConfig{username: "user@isp", password: "secret"}, peer.send, func(info pppLinkInfo) {
+		up = append(up, info)
+	})
+
+	// The AC asks for MS-CHAPv2 first; we only do CHAP-MD5
+	peer.deliver(s, pppProtoLCP, cpPacket{code: cpConfigureRequest, id: 9, data: marshalCPOptions([]cpOption{
+		{typ: lcpOptAuth, data: []byte{0xc2, 0x23, 0x81}},
+	})})
+	nak := peer.last(pppProtoLCP)
+	opts, _ := parseCPOptions(nak.data)
+	if nak.code != cpConfigureNak || len(opts) != 1 || !bytes.Equal(opts[0].data, []byte{0xc2, 0x23, chapMD5}) {
+		t.Fatalf("reply to MS-CHAPv2 = %+v, want Nak suggesting CHAP-MD5", nak)
+	}
+
+	negotiateLCP(t, s, peer, []byte{0xc2, 0x23, chapMD5})
+	if s.network {
+		t.Fatal("network phase started before authentication")
+	}
+
+	// CHAP challenge and response
+	challenge := []byte("0123456789abcdef")
+	peer.deliver(s, pppProtoCHAP, cpPacket{code: 1, id: 42, data: append([]byte{byte(len(challenge))}, challenge...)})
+	resp := peer.last(pppProtoCHAP)
+	want := md5.Sum(append(append([]byte{42}, "secret"...), challenge...))
+	if resp.code != 2 || resp.id != 42 || resp.data[0] != 16 || !bytes.Equal(resp.data[1:17], want[:]) {
+		t.Fatalf("CHAP response = %+v", resp)
+	}
+	if name := string(resp.data[17:]); name != "user@isp" {
+		t.Errorf("CHAP name = %q", name)
+	}
+	peer.deliver(s, pppProtoCHAP, cpPacket{code: 3, id: 42})
+
+	// IPCP: the AC naks our address and primary DNS, and rejects the secondary
+	req := peer.last(pppProtoIPCP)
+	if opts, _ := parseCPOptions(req.data); len(opts) != 3 {
+		t.Fatalf("IPCP request options = %v", opts)
+	}
+	peer.deliver(s, pppProtoIPCP, cpPacket{code: cpConfigureRequest, id: 1, data: marshalCPOptions([]cpOption{
+		ipOption(ipcpOptAddress, "192.0.2.1"),
+	})})
+	peer.deliver(s, pppProtoIPCP, cpPacket{code: cpConfigureNak, id: req.id, data: marshalCPOptions([]cpOption{
+		ipOption(ipcpOptAddress, "198.51.100.7"),
+		ipOption(ipcpOptPrimaryDNS, "192.0.2.53"),
+	})})
+	req = peer.last(pppProtoIPCP)
+	peer.deliver(s, pppProtoIPCP, cpPacket{code: cpConfigureReject, id: req.id, data: marshalCPOptions([]cpOption{
+		ipOption(ipcpOptSecondaryDNS, "0.0.0.0"),
+	})})
+	req = peer.last(pppProtoIPCP)
+	if opts, _ := parseCPOptions(req.data); len(opts) != 2 {
+		t.Fatalf("IPCP request after reject = %v", opts)
+	}
+	if len(up) != 0 {
+		t.Fatal("link reported up before IPCP opened")
+	}
+	peer.deliver(s, pppProtoIPCP, cpPacket{code: cpConfigureAck, id: req.id, data: req.data})
+
+	if len(up) != 1 {
+		t.Fatalf("onUp called %d times", len(up))
+	}
+	info := up[0]
+	if !info.localIP.Equal(net.ParseIP("198.51.100.7")) || !info.peerIP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("addresses = %s peer %s", info.localIP, info.peerIP)
+	}
+	if len(info.dns) != 1 || !info.dns[0].Equal(net.ParseIP("192.0.2.53")) {
+		t.Errorf("DNS = %v", info.dns)
+	}
+	if info.mtu != 1492 {
+		t.Errorf("MTU = %d", info.mtu)
+	}
+
+	// IPv6CP is rejected when IPv6 is not configured
+	peer.deliver(s, pppProtoIPv6CP, cpPacket{code: cpConfigureRequest, id: 1})
+	if rej := peer.last(pppProtoLCP); rej.code != cpProtocolReject || binary.BigEndian.Uint16(rej.data) != pppProtoIPv6CP {
+		t.Errorf("reply to IPv6CP = %+v, want Protocol-Reject", rej)
+	}
+
+	// A Terminate-Request ends the session
+	if err := peer.deliver(s, pppProtoLCP, cpPacket{code: cpTerminateRequest, id: 5}); err == nil {
+		t.Error("Terminate-Request did not end the session")
+	}
+}
+
+func TestPPPSession_PAPFailure(t *testing.T) {
+	peer := &pppPeer{t: t}
+	s := newPPPSession(pppConfig{username: "user", password: "wrong"}, peer.send, nil)
+	negotiateLCP(t, s, peer, []byte{0xc0, 0x23})
+
+	req := peer.last(pppProtoPAP)
+	if req.code != 1 || !bytes.Equal(req.data, []byte("\x04user\x05wrong")) {
+		t.Fatalf("PAP request = %+v", req)
+	}
+
+	msg := "Authentication failure"
+	err := peer.deliver(s, pppProtoPAP, cpPacket{code: 3, id: req.id, data: append([]byte{byte(len(msg))}, msg...)})
+	if err == nil || !strings.Contains(err.Error(), msg) {
+		t.Errorf("err = %v, want the AC's message", err)
+	}
+}
+
+func TestPPPSession_EchoTimeout(t *testing.T) {
+	peer := &pppPeer{t: t}
+	s := newPPPSession(pppConfig{echoInterval: 10 * time.Second, echoFailure: 2}, peer.send, nil)
+	negotiateLCP(t, s, peer, nil)
+
+	now := time.Now()
+	if err := s.timeout(now); err != nil {
+		t.Fatal(err)
+	}
+	echo := peer.last(pppProtoLCP)
+	if echo.code != cpEchoRequest || binary.BigEndian.Uint32(echo.data) != s.lcpOpts.magic {
+		t.Fatalf("keepalive = %+v", echo)
+	}
+
+	// A reply resets the count
+	peer.deliver(s, pppProtoLCP, cpPacket{code: cpEchoReply, id: echo.id, data: []byte{1, 2, 3, 4}})
+	for i := 1; i <= 2; i++ {
+		if err := s.timeout(now.Add(time.Duration(i) * 10 * time.Second)); err != nil {
+			t.Fatalf("echo %d: %v", i, err)
+		}
+	}
+	if err := s.timeout(now.Add(30 * time.Second)); err == nil {
+		t.Error("session survived two missed echoes")
+	}
+}
@@ -0,0 +1,365 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// PPPoE discovery (RFC 2516, RFC 4638)
+const (
+	etherTypePPPoEDiscovery = 0x8863
+
+	pppoeCodePADI = 0x09
+	pppoeCodePADO = 0x07
+	pppoeCodePADR = 0x19
+	pppoeCodePADS = 0x65
+	pppoeCodePADT = 0xa7
+
+	pppoeTagServiceName      = 0x0101
+	pppoeTagACName           = 0x0102
+	pppoeTagHostUniq         = 0x0103
+	pppoeTagACCookie         = 0x0104
+	pppoeTagRelaySessionID   = 0x0110
+	pppoeTagMaxPayload       = 0x0120
+	pppoeTagServiceNameError = 0x0201
+	pppoeTagACSystemError    = 0x0202
+	pppoeTagGenericError     = 0x0203
+
+	pppoeDefaultMTU = 1492
+
+	// pppoeDiscoveryTimeout is the initial wait for a PADO or PADS; it
+	// doubles with each of pppoeDiscoveryAttempts attempts.
+	pppoeDiscoveryTimeout  = 2 * time.Second
+	pppoeDiscoveryAttempts = 4
+)
+
+// PPPoESession describes a PPPoE session coming up or going down.
+type PPPoESession struct {
+	Interface string // Interface carrying the session
+	Link      string // PPP link of the session
+	Up        bool
+	SessionID uint16
+	ACName    string
+	LocalIP   net.IP
+	PeerIP    net.IP
+	DNS       []net.IP
+	MTU       int
+}
+
+// PPPoEListener is called whenever a PPPoE session comes up or goes down.
+type PPPoEListener func(PPPoESession)
+
+// pppoeState tracks the PPPoE clients and their sessions.
+type pppoeState struct {
+	mu        sync.Mutex
+	clients   map[string]context.CancelFunc // interface -> running client
+	sessions  map[string]PPPoESession       // interface -> established session
+	listeners []PPPoEListener
+}
+
+// AddPPPoEListener registers fn for PPPoE session changes. Established
+// sessions are replayed so services started after the session came up
+// still see it.
+func (m *Manager) AddPPPoEListener(fn PPPoEListener) {
+	m.ppp.mu.Lock()
+	defer m.ppp.mu.Unlock()
+
+	m.ppp.listeners = append(m.ppp.listeners, fn)
+	for _, name := range sortedKeys(m.ppp.sessions) {
+		fn(m.ppp.sessions[name])
+	}
+}
+
+// PPPoESessions returns the established PPPoE sessions.
+func (m *Manager) PPPoESessions() []PPPoESession {
+	m.ppp.mu.Lock()
+	defer m.ppp.mu.Unlock()
+
+	var ret []PPPoESession
+	for _, name := range sortedKeys(m.ppp.sessions) {
+		ret = append(ret, m.ppp.sessions[name])
+	}
+	return ret
+}
+
+// startPPPoEClient registers a client for an interface, stopping the one
+// it replaces, and returns its context.
+func (m *Manager) startPPPoEClient(iface string) context.Context {
+	m.ppp.mu.Lock()
+	defer m.ppp.mu.Unlock()
+
+	if cancel, ok := m.ppp.clients[iface]; ok {
+		cancel()
+	}
+	if m.ppp.clients == nil {
+		m.ppp.clients = make(map[string]context.CancelFunc)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.ppp.clients[iface] = cancel
+	return ctx
+}
+
+// setPPPoESession records a session change and notifies listeners.
+func (m *Manager) setPPPoESession(s PPPoESession) {
+	m.ppp.mu.Lock()
+	defer m.ppp.mu.Unlock()
+
+	if m.ppp.sessions == nil {
+		m.ppp.sessions = make(map[string]PPPoESession)
+	}
+	if s.Up {
+		m.ppp.sessions[s.Interface] = s
+	} else {
+		delete(m.ppp.sessions, s.Interface)
+	}
+	for _, fn := range m.ppp.listeners {
+		fn(s)
+	}
+}
+
+// pppConfigFor returns the PPP settings of a pppoe block with defaults.
+func pppConfigFor(cfg *config.PPPoE) pppConfig {
+	c := pppConfig{
+		username:     cfg.Username,
+		password:     cfg.Password,
+		mru:          cfg.MTU,
+		ipv6:         cfg.IPv6,
+		echoInterval: 10 * time.Second,
+		echoFailure:  3,
+	}
+	if c.mru == 0 {
+		c.mru = pppoeDefaultMTU
+	}
+	if cfg.LCPEchoInterval > 0 {
+		c.echoInterval = time.Duration(cfg.LCPEchoInterval) * time.Second
+	}
+	if cfg.LCPEchoFailure > 0 {
+		c.echoFailure = cfg.LCPEchoFailure
+	}
+	return c
+}
+
+// pppoeTag is a TLV in a discovery packet.
+type pppoeTag struct {
+	typ   uint16
+	value []byte
+}
+
+// pppoePacket is a PPPoE discovery packet, without the Ethernet header.
+type pppoePacket struct {
+	code    uint8
+	session uint16
+	tags    []pppoeTag
+}
+
+func (p *pppoePacket) marshal() []byte {
+	n := 0
+	for _, t := range p.tags {
+		n += 4 + len(t.value)
+	}
+	b := make([]byte, 6, 6+n)
+	b[0] = 0x11 // Version 1, type 1
+	b[1] = p.code
+	binary.BigEndian.PutUint16(b[2:4], p.session)
+	binary.BigEndian.PutUint16(b[4:6], uint16(n))
+	for _, t := range p.tags {
+		b = binary.BigEndian.AppendUint16(b, t.typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.value)))
+		b = append(b, t.value...)
+	}
+	return b
+}
+
+func parsePPPoEPacket(b []byte) (*pppoePacket, error) {
+	if len(b) < 6 {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(b))
+	}
+	if b[0] != 0x11 {
+		return nil, fmt.Errorf("unsupported version/type 0x%02x", b[0])
+	}
+	n := int(binary.BigEndian.Uint16(b[4:6]))
+	if 6+n > len(b) {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	p := &pppoePacket{code: b[1], session: binary.BigEndian.Uint16(b[2:4])}
+	for tags := b[6 : 6+n]; len(tags) > 0; {
+		if len(tags) < 4 {
+			return nil, fmt.Errorf("truncated tag")
+		}
+		typ, l := binary.BigEndian.Uint16(tags[0:2]), int(binary.BigEndian.Uint16(tags[2:4]))
+		if 4+l > len(tags) {
+			return nil, fmt.Errorf("truncated tag 0x%04x", typ)
+		}
+		if typ == 0 {
+			break // End-Of-List
+		}
+		p.tags = append(p.tags, pppoeTag{typ: typ, value: tags[4 : 4+l]})
+		tags = tags[4+l:]
+	}
+	return p, nil
+}
+
+func (p *pppoePacket) tag(typ uint16) ([]byte, bool) {
+	for _, t := range p.tags {
+		if t.typ == typ {
+			return t.value, true
+		}
+	}
+	return nil, false
+}
+
+// err returns the error an access concentrator reported in p, if any.
+func (p *pppoePacket) err() error {
+	for _, typ := range []uint16{pppoeTagServiceNameError, pppoeTagACSystemError, pppoeTagGenericError} {
+		if v, ok := p.tag(typ); ok {
+			if len(v) == 0 {
+				v = []byte("no reason given")
+			}
+			return fmt.Errorf("access concentrator error 0x%04x: %s", typ, v)
+		}
+	}
+	return nil
+}
+
+// discoveryConn carries discovery packets to and from access concentrators.
+type discoveryConn interface {
+	send(dst net.HardwareAddr, p *pppoePacket) error
+	// recv returns the next discovery packet, or an error once deadline
+	// has passed.
+	recv(deadline time.Time) (net.HardwareAddr, *pppoePacket, error)
+}
+
+var errPPPoETimeout = errors.New("timed out")
+
+// pppoeOffer identifies a session: the access concentrator and, once
+// confirmed by a PADS, the session ID.
+type pppoeOffer struct {
+	ac      net.HardwareAddr
+	acName  string
+	session uint16
+	cookie  []byte
+	relayID []byte
+}
+
+var broadcastHW = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// discoverPPPoE runs the PADI/PADO/PADR/PADS exchange and returns the
+// established session.
+func discoverPPPoE(ctx context.Context, conn discoveryConn, cfg *config.PPPoE, hostUniq []byte) (*pppoeOffer, error) {
+	tags := []pppoeTag{
+		{typ: pppoeTagServiceName, value: []byte(cfg.ServiceName)},
+		{typ: pppoeTagHostUniq, value: hostUniq},
+	}
+	if cfg.MTU > pppoeDefaultMTU {
+		tags = append(tags, pppoeTag{typ: pppoeTagMaxPayload, value: binary.BigEndian.AppendUint16(nil, uint16(cfg.MTU))})
+	}
+
+	offer, err := pppoeExchange(ctx, conn, broadcastHW, &pppoePacket{code: pppoeCodePADI, tags: tags},
+		func(src net.HardwareAddr, p *pppoePacket) (*pppoeOffer, error) {
+			if p.code != pppoeCodePADO || !matchesHostUniq(p, hostUniq) {
+				return nil, nil
+			}
+			name, _ := p.tag(pppoeTagACName)
+			if err := p.err(); err != nil {
+				log.Printf("[network] PPPoE: ignoring offer from %s (%s): %v", src, name, err)
+				return nil, nil
+			}
+			if cfg.ACName != "" && string(name) != cfg.ACName {
+				return nil, nil
+			}
+			if svc, ok := p.tag(pppoeTagServiceName); !ok || (cfg.ServiceName != "" && string(svc) != cfg.ServiceName) {
+				return nil, nil
+			}
+			o := &pppoeOffer{ac: src, acName: string(name)}
+			o.cookie, _ = p.tag(pppoeTagACCookie)
+			o.relayID, _ = p.tag(pppoeTagRelaySessionID)
+			return o, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("no PADO received: %w", err)
+	}
+
+	// The request echoes the cookie and relay ID of the offer
+	if offer.cookie != nil {
+		tags = append(tags, pppoeTag{typ: pppoeTagACCookie, value: offer.cookie})
+	}
+	if offer.relayID != nil {
+		tags = append(tags, pppoeTag{typ: pppoeTagRelaySessionID, value: offer.relayID})
+	}
+	confirmed, err := pppoeExchange(ctx, conn, offer.ac, &pppoePacket{code: pppoeCodePADR, tags: tags},
+		func(src net.HardwareAddr, p *pppoePacket) (*pppoeOffer, error) {
+			if p.code != pppoeCodePADS || !bytes.Equal(src, offer.ac) || !matchesHostUniq(p, hostUniq) {
+				return nil, nil
+			}
+			if err := p.err(); err != nil {
+				return nil, err
+			}
+			if p.session == 0 {
+				return nil, errors.New("access concentrator refused the session")
+			}
+			o := *offer
+			o.session = p.session
+			return &o, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("no PADS received from %s (%s): %w", offer.ac, offer.acName, err)
+	}
+	return confirmed, nil
+}
+
+// pppoeExchange sends req until accept returns a result or an error,
+// doubling the timeout after each attempt.
+func pppoeExchange(ctx context.Context, conn discoveryConn, dst net.HardwareAddr, req *pppoePacket,
+	accept func(net.HardwareAddr, *pppoePacket) (*pppoeOffer, error)) (*pppoeOffer, error) {
+	timeout := pppoeDiscoveryTimeout
+	for attempt := 0; attempt < pppoeDiscoveryAttempts; attempt++ {
+		if err := conn.send(dst, req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			// Wake up regularly to notice cancellation
+			wait := deadline
+			if d := time.Now().Add(time.Second); d.Before(wait) {
+				wait = d
+			}
+			src, p, err := conn.recv(wait)
+			if errors.Is(err, errPPPoETimeout) {
+				if time.Now().Before(deadline) {
+					continue
+				}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if o, err := accept(src, p); o != nil || err != nil {
+				return o, err
+			}
+		}
+		timeout *= 2
+	}
+	return nil, errPPPoETimeout
+}
+
+func matchesHostUniq(p *pppoePacket, hostUniq []byte) bool {
+	v, ok := p.tag(pppoeTagHostUniq)
+	return ok && bytes.Equal(v, hostUniq)
+}
+
+// pppoePADT builds the packet that terminates a session.
+func pppoePADT(session uint16) *pppoePacket {
+	return &pppoePacket{code: pppoeCodePADT, session: session}
+}
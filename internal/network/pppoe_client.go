@@ -0,0 +1,420 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/mdlayher/packet"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"grimm.is/glacic/internal/config"
+)
+
+// pxProtoOE selects PPPoE in an AF_PPPOX socket (linux/if_pppox.h).
+const pxProtoOE = 0
+
+// StartPPPoEClient starts a PPPoE client on an interface. Discovery, LCP,
+// authentication and IPCP/IPv6CP run in-process; the kernel carries the
+// session data over a PPP link created with rtnetlink. The client redials
+// with backoff whenever the session drops.
+func (m *Manager) StartPPPoEClient(ifaceCfg config.Interface) error {
+	cfg := ifaceCfg.PPPoE
+	lower := ifaceCfg.Name
+	if cfg.VLAN > 0 {
+		parent, err := m.nl.LinkByName(ifaceCfg.Name)
+		if err != nil {
+			return fmt.Errorf("interface %s not found: %w", ifaceCfg.Name, err)
+		}
+		if err := m.applyVLAN(parent, config.VLAN{ID: strconv.Itoa(cfg.VLAN)}); err != nil {
+			return err
+		}
+		lower = fmt.Sprintf("%s.%d", ifaceCfg.Name, cfg.VLAN)
+	}
+	if _, err := net.InterfaceByName(lower); err != nil {
+		return fmt.Errorf("failed to create PPPoE client for %s: %w", lower, err)
+	}
+
+	log.Printf("[network] Starting PPPoE client on %s (link %s)", lower, cfg.LinkName(ifaceCfg.Name))
+	ctx := m.startPPPoEClient(ifaceCfg.Name)
+	go m.runPPPoEClientLoop(ctx, ifaceCfg, lower)
+	return nil
+}
+
+// runPPPoEClientLoop keeps a session up until ctx is cancelled.
+func (m *Manager) runPPPoEClientLoop(ctx context.Context, ifaceCfg config.Interface, lower string) {
+	backoff := 2 * time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := m.runPPPoESession(ctx, ifaceCfg, lower)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[network] PPPoE session on %s ended: %v", lower, err)
+
+		// A session that was up for a while redials quickly
+		if time.Since(start) > 5*time.Minute {
+			backoff = 2 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 60*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// runPPPoESession establishes one session and runs it until it ends.
+func (m *Manager) runPPPoESession(ctx context.Context, ifaceCfg config.Interface, lower string) error {
+	ifi, err := net.InterfaceByName(lower)
+	if err != nil {
+		return err
+	}
+	conn, err := packet.Listen(ifi, packet.Raw, etherTypePPPoEDiscovery, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+	dc := &packetDiscoveryConn{conn: conn, hwaddr: ifi.HardwareAddr}
+
+	hostUniq := make([]byte, 8)
+	rand.Read(hostUniq)
+	offer, err := discoverPPPoE(ctx, dc, ifaceCfg.PPPoE, hostUniq)
+	if err != nil {
+		return err
+	}
+	log.Printf("[network] PPPoE session %d established with %s (%s) on %s", offer.session, offer.ac, offer.acName, lower)
+	defer dc.send(offer.ac, pppoePADT(offer.session))
+
+	linkName := ifaceCfg.PPPoE.LinkName(ifaceCfg.Name)
+	ch, err := openPPPChannel(lower, linkName, offer)
+	if err != nil {
+		return err
+	}
+	defer ch.close()
+	defer m.deletePPPLink(linkName)
+
+	cfg := pppConfigFor(ifaceCfg.PPPoE)
+	if err := unix.IoctlSetPointerInt(int(ch.unit.Fd()), unix.PPPIOCSMRU, cfg.mru); err != nil {
+		log.Printf("[network] Failed to set MRU on %s: %v", linkName, err)
+	}
+
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go watchPADT(sessCtx, dc, offer, cancel)
+
+	var up bool
+	session := newPPPSession(cfg, ch.write, func(info pppLinkInfo) {
+		m.applyPPPLink(ifaceCfg, linkName, info)
+		up = true
+		m.setPPPoESession(PPPoESession{
+			Interface: ifaceCfg.Name,
+			Link:      linkName,
+			Up:        true,
+			SessionID: offer.session,
+			ACName:    offer.acName,
+			LocalIP:   info.localIP,
+			PeerIP:    info.peerIP,
+			DNS:       info.dns,
+			MTU:       info.mtu,
+		})
+	})
+	err = session.run(sessCtx, ch.frames)
+	if up {
+		m.setPPPoESession(PPPoESession{Interface: ifaceCfg.Name, Link: linkName, SessionID: offer.session, ACName: offer.acName})
+	}
+	if err == nil && ctx.Err() == nil {
+		err = errors.New("access concentrator terminated the session")
+	}
+	return err
+}
+
+// watchPADT ends the session when the access concentrator terminates it.
+func watchPADT(ctx context.Context, dc *packetDiscoveryConn, offer *pppoeOffer, cancel context.CancelFunc) {
+	for ctx.Err() == nil {
+		src, p, err := dc.recv(time.Now().Add(time.Second))
+		if errors.Is(err, errPPPoETimeout) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if p.code == pppoeCodePADT && p.session == offer.session && src.String() == offer.ac.String() {
+			log.Printf("[network] PPPoE session %d terminated by %s", offer.session, offer.ac)
+			cancel()
+			return
+		}
+	}
+}
+
+// applyPPPLink configures the PPP link once IPCP (and IPv6CP) are open.
+func (m *Manager) applyPPPLink(ifaceCfg config.Interface, linkName string, info pppLinkInfo) {
+	link, err := m.nl.LinkByName(linkName)
+	if err != nil {
+		log.Printf("[network] PPP link %s not found: %v", linkName, err)
+		return
+	}
+	if err := m.nl.LinkSetMTU(link, info.mtu); err != nil {
+		log.Printf("[network] Failed to set MTU %d on %s: %v", info.mtu, linkName, err)
+	}
+	if err := m.nl.LinkSetUp(link); err != nil {
+		log.Printf("[network] Failed to bring up %s: %v", linkName, err)
+		return
+	}
+
+	// 1. Point-to-point address and default route
+	log.Printf("[network] PPPoE on %s: local %s, peer %s", linkName, info.localIP, info.peerIP)
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{IP: info.localIP, Mask: net.CIDRMask(32, 32)},
+		Peer:  &net.IPNet{IP: info.peerIP, Mask: net.CIDRMask(32, 32)},
+	}
+	if err := m.nl.AddrAdd(link, addr); err != nil && !strings.Contains(err.Error(), "file exists") {
+		log.Printf("[network] Failed to add address to %s: %v", linkName, err)
+	}
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Table:     ifaceCfg.Table,
+	}
+	if err := m.nl.RouteAdd(route); err != nil && !strings.Contains(err.Error(), "file exists") {
+		log.Printf("[network] Failed to add default route via %s: %v", linkName, err)
+	}
+
+	// 2. DNS, as for a DHCP lease
+	if len(info.dns) > 0 {
+		log.Printf("[network] PPPoE DNS Servers: %v", info.dns)
+		if m.dns != nil {
+			var forwarders []string
+			for _, ip := range info.dns {
+				forwarders = append(forwarders, ip.String())
+			}
+			m.dns.UpdateForwarders(forwarders)
+		} else {
+			writeResolvConf(info.dns)
+		}
+	}
+
+	// 3. IPv6: the kernel does not derive a link-local address on PPP links,
+	// so it is built from the negotiated interface identifier; addresses
+	// and the default route then come from router advertisements.
+	if len(info.localIID) == 8 {
+		ll := make(net.IP, net.IPv6len)
+		ll[0], ll[1] = 0xfe, 0x80
+		copy(ll[8:], info.localIID)
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: ll, Mask: net.CIDRMask(64, 128)}, Flags: unix.IFA_F_NODAD}
+		if err := m.nl.AddrAdd(link, addr); err != nil && !strings.Contains(err.Error(), "file exists") {
+			log.Printf("[network] Failed to add link-local address to %s: %v", linkName, err)
+		}
+		_ = m.sys.WriteSysctl(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/accept_ra", linkName), "2")
+		_ = m.sys.WriteSysctl(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/autoconf", linkName), "1")
+	}
+}
+
+func (m *Manager) deletePPPLink(name string) {
+	if link, err := m.nl.LinkByName(name); err == nil {
+		m.nl.LinkDel(link)
+	}
+}
+
+// packetDiscoveryConn sends and receives discovery packets on a raw socket.
+type packetDiscoveryConn struct {
+	conn   *packet.Conn
+	hwaddr net.HardwareAddr
+}
+
+func (c *packetDiscoveryConn) send(dst net.HardwareAddr, p *pppoePacket) error {
+	payload := p.marshal()
+	frame := make([]byte, 14, 14+len(payload))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], c.hwaddr)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypePPPoEDiscovery)
+	frame = append(frame, payload...)
+	_, err := c.conn.WriteTo(frame, &packet.Addr{HardwareAddr: dst})
+	return err
+}
+
+func (c *packetDiscoveryConn) recv(deadline time.Time) (net.HardwareAddr, *pppoePacket, error) {
+	c.conn.SetReadDeadline(deadline)
+	buf := make([]byte, 1514)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil, errPPPoETimeout
+			}
+			return nil, nil, err
+		}
+		if n < 14 || binary.BigEndian.Uint16(buf[12:14]) != etherTypePPPoEDiscovery {
+			continue
+		}
+		p, err := parsePPPoEPacket(buf[14:n])
+		if err != nil {
+			continue
+		}
+		return net.HardwareAddr(append([]byte(nil), buf[6:12]...)), p, nil
+	}
+}
+
+// pppChannel is a kernel PPPoE session: the AF_PPPOX socket, the PPP
+// channel carrying LCP and authentication, and the PPP unit (the network
+// interface) carrying IPCP and IPv6CP.
+type pppChannel struct {
+	pppox  int
+	ch     *os.File
+	unit   *os.File
+	frames chan pppFrame
+	done   chan struct{} // Closed by close, so readers stop handing over frames
+}
+
+// openPPPChannel connects the kernel to the session and creates the PPP
+// link linkName for it.
+func openPPPChannel(lower, linkName string, offer *pppoeOffer) (_ *pppChannel, err error) {
+	c := &pppChannel{pppox: -1, frames: make(chan pppFrame, 16), done: make(chan struct{})}
+	defer func() {
+		if err != nil {
+			c.close()
+		}
+	}()
+
+	c.pppox, err = unix.Socket(unix.AF_PPPOX, unix.SOCK_STREAM, pxProtoOE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PPPoE socket (is the pppoe module loaded?): %w", err)
+	}
+	if err := connectPPPoE(c.pppox, lower, offer); err != nil {
+		return nil, fmt.Errorf("failed to connect PPPoE session: %w", err)
+	}
+	chindex, err := unix.IoctlGetUint32(c.pppox, unix.PPPIOCGCHAN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PPP channel: %w", err)
+	}
+
+	if c.ch, err = openPPPDevice(); err != nil {
+		return nil, err
+	}
+	if err := unix.IoctlSetPointerInt(int(c.ch.Fd()), unix.PPPIOCATTCHAN, int(chindex)); err != nil {
+		return nil, fmt.Errorf("failed to attach PPP channel: %w", err)
+	}
+
+	if c.unit, err = openPPPDevice(); err != nil {
+		return nil, err
+	}
+	if err := createPPPLink(linkName, int(c.unit.Fd())); err != nil {
+		return nil, fmt.Errorf("failed to create PPP link %s: %w", linkName, err)
+	}
+	unit, err := unix.IoctlGetUint32(int(c.unit.Fd()), unix.PPPIOCGUNIT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PPP unit: %w", err)
+	}
+	if err := unix.IoctlSetPointerInt(int(c.ch.Fd()), unix.PPPIOCCONNECT, int(unit)); err != nil {
+		return nil, fmt.Errorf("failed to connect PPP channel to %s: %w", linkName, err)
+	}
+
+	go c.read(c.ch)
+	go c.read(c.unit)
+	return c, nil
+}
+
+// openPPPDevice opens /dev/ppp for use with the runtime poller, so that
+// closing it unblocks readers.
+func openPPPDevice() (*os.File, error) {
+	fd, err := unix.Open("/dev/ppp", unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/ppp: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "/dev/ppp"), nil
+}
+
+// connectPPPoE binds a PPPoE socket to a session. struct sockaddr_pppox is
+// packed: family, protocol, then the session ID, peer MAC and device name.
+func connectPPPoE(fd int, lower string, offer *pppoeOffer) error {
+	var sa [30]byte
+	binary.NativeEndian.PutUint16(sa[0:2], unix.AF_PPPOX)
+	binary.NativeEndian.PutUint32(sa[2:6], pxProtoOE)
+	binary.BigEndian.PutUint16(sa[6:8], offer.session)
+	copy(sa[8:14], offer.ac)
+	copy(sa[14:29], lower)
+	_, _, errno := unix.Syscall(unix.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&sa[0])), uintptr(len(sa)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// createPPPLink creates the PPP interface for the unit opened as fd with
+// RTM_NEWLINK, so it carries the configured name from the start.
+func createPPPLink(name string, fd int) error {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(name)))
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("ppp"))
+	data := linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	data.AddRtAttr(unix.IFLA_PPP_DEV_FD, nl.Uint32Attr(uint32(fd)))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// read passes the frames arriving on f to the session. Frames start with
+// the PPP protocol number; address and control fields are not used.
+func (c *pppChannel) read(f *os.File) {
+	buf := make([]byte, 2048)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < 2 {
+			continue
+		}
+		frame := pppFrame{proto: binary.BigEndian.Uint16(buf[0:2]), data: append([]byte(nil), buf[2:n]...)}
+		select {
+		case c.frames <- frame:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write sends a frame on the channel (LCP and authentication) or the unit
+// (network control protocols), as the kernel expects.
+func (c *pppChannel) write(proto uint16, data []byte) error {
+	f := c.unit
+	if proto >= 0xc000 {
+		f = c.ch
+	}
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(data)), proto)
+	_, err := f.Write(append(frame, data...))
+	return err
+}
+
+func (c *pppChannel) close() {
+	close(c.done)
+	if c.unit != nil {
+		c.unit.Close()
+	}
+	if c.ch != nil {
+		c.ch.Close()
+	}
+	if c.pppox >= 0 {
+		unix.Close(c.pppox)
+	}
+}
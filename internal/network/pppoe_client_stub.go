@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"log"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// StartPPPoEClient is a stub for non-Linux systems.
+func (m *Manager) StartPPPoEClient(ifaceCfg config.Interface) error {
+	log.Printf("[network] [DRY RUN] Starting PPPoE client on interface '%s' (Link: %s). (Not supported on non-Linux, simulation only)", ifaceCfg.Name, ifaceCfg.PPPoE.LinkName(ifaceCfg.Name))
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPPPChannel_ReadStopsOnClose(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Nobody receives frames, as after the session has ended
+	c := &pppChannel{pppox: -1, frames: make(chan pppFrame), done: make(chan struct{})}
+	stopped := make(chan struct{})
+	go func() {
+		c.read(r)
+		close(stopped)
+	}()
+	if _, err := w.Write([]byte{0xc0, 0x21, 0x09, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("reader still blocked handing over a frame after close")
+	}
+}
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// fakeAC answers discovery packets with the packets reply returns.
+type fakeAC struct {
+	sent    []*pppoePacket
+	pending []acReply
+	reply   func(p *pppoePacket) []acReply
+}
+
+type acReply struct {
+	src net.HardwareAddr
+	p   *pppoePacket
+}
+
+func (c *fakeAC) send(dst net.HardwareAddr, p *pppoePacket) error {
+	// Round-trip through the wire format
+	p, err := parsePPPoEPacket(p.marshal())
+	if err != nil {
+		return err
+	}
+	c.sent = append(c.sent, p)
+	c.pending = append(c.pending, c.reply(p)...)
+	return nil
+}
+
+func (c *fakeAC) recv(deadline time.Time) (net.HardwareAddr, *pppoePacket, error) {
+	if len(c.pending) == 0 {
+		return nil, nil, errPPPoETimeout
+	}
+	r := c.pending[0]
+	c.pending = c.pending[1:]
+	return r.src, r.p, nil
+}
+
+func TestDiscoverPPPoE(t *testing.T) {
+	hostUniq := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	acA := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xa}
+	acB := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xb}
+
+	ac := &fakeAC{}
+	ac.reply = func(p *pppoePacket) []acReply {
+		uniq, _ := p.tag(pppoeTagHostUniq)
+		offer := func(name string) *pppoePacket {
+			return &pppoePacket{code: pppoeCodePADO, tags: []pppoeTag{
+				{typ: pppoeTagACName, value: []byte(name)},
+				{typ: pppoeTagServiceName},
+				{typ: pppoeTagHostUniq, value: uniq},
+				{typ: pppoeTagACCookie, value: []byte("cookie-" + name)},
+			}}
+		}
+		switch p.code {
+		case pppoeCodePADI:
+			return []acReply{{acA, offer("bras-a")}, {acB, offer("bras-b")}}
+		case pppoeCodePADR:
+			return []acReply{{acB, &pppoePacket{code: pppoeCodePADS, session: 0x1234, tags: []pppoeTag{
+				{typ: pppoeTagHostUniq, value: uniq},
+			}}}}
+		}
+		return nil
+	}
+
+	offer, err := discoverPPPoE(context.Background(), ac, &config.PPPoE{ACName: "bras-b", MTU: 1500}, hostUniq)
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if offer.session != 0x1234 || offer.ac.String() != acB.String() || offer.acName != "bras-b" {
+		t.Errorf("offer = %+v", offer)
+	}
+
+	if len(ac.sent) != 2 {
+		t.Fatalf("sent %d packets", len(ac.sent))
+	}
+	padi, padr := ac.sent[0], ac.sent[1]
+	if v, ok := padi.tag(pppoeTagMaxPayload); !ok || !bytes.Equal(v, []byte{0x05, 0xdc}) {
+		t.Errorf("PADI PPP-Max-Payload = %v, want 1500 (RFC 4638)", v)
+	}
+	if v, _ := padr.tag(pppoeTagACCookie); string(v) != "cookie-bras-b" {
+		t.Errorf("PADR cookie = %q, want the chosen AC's cookie echoed", v)
+	}
+	if !matchesHostUniq(padr, hostUniq) {
+		t.Error("PADR without Host-Uniq")
+	}
+}
+
+func TestDiscoverPPPoE_PADSError(t *testing.T) {
+	hostUniq := []byte{9, 9}
+	src := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xa}
+	ac := &fakeAC{}
+	ac.reply = func(p *pppoePacket) []acReply {
+		tags := []pppoeTag{{typ: pppoeTagServiceName, value: []byte("internet")}, {typ: pppoeTagHostUniq, value: hostUniq}}
+		if p.code == pppoeCodePADI {
+			return []acReply{{src, &pppoePacket{code: pppoeCodePADO, tags: tags}}}
+		}
+		tags = append(tags, pppoeTag{typ: pppoeTagServiceNameError, value: []byte("quota exceeded")})
+		return []acReply{{src, &pppoePacket{code: pppoeCodePADS, tags: tags}}}
+	}
+
+	_, err := discoverPPPoE(context.Background(), ac, &config.PPPoE{ServiceName: "internet"}, hostUniq)
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("err = %v, want the AC's error", err)
+	}
+}
+
+func TestManager_PPPoEListener(t *testing.T) {
+	m := NewManager()
+	m.setPPPoESession(PPPoESession{Interface: "wan", Link: "ppp-wan", Up: true, LocalIP: net.ParseIP("198.51.100.7")})
+
+	// Listeners see established sessions when they register
+	var got []PPPoESession
+	m.AddPPPoEListener(func(s PPPoESession) { got = append(got, s) })
+	if len(got) != 1 || got[0].Link != "ppp-wan" {
+		t.Fatalf("replayed sessions = %+v", got)
+	}
+
+	m.setPPPoESession(PPPoESession{Interface: "wan", Link: "ppp-wan"})
+	if len(got) != 2 || got[1].Up {
+		t.Errorf("sessions = %+v, want the session reported down", got)
+	}
+	if len(m.PPPoESessions()) != 0 {
+		t.Error("session kept after going down")
+	}
+}
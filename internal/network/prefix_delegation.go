@@ -0,0 +1,211 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/vishvananda/netlink"
+)
+
+// PrefixAssignment is a /64 carved from a DHCPv6-PD delegation and assigned
+// to a downstream interface.
+type PrefixAssignment struct {
+	Interface         string       // Downstream (LAN) interface
+	Upstream          string       // WAN interface holding the delegation
+	Prefix            netip.Prefix // Assigned /64; invalid when the delegation was lost
+	Previous          netip.Prefix // Prefix it replaces after the ISP rotated the delegation
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+}
+
+// PrefixListener is called whenever a downstream prefix is assigned, renewed,
+// rotated or withdrawn.
+type PrefixListener func(PrefixAssignment)
+
+// delegatedPrefix is the prefix currently delegated on an upstream interface.
+type delegatedPrefix struct {
+	prefix    netip.Prefix
+	preferred time.Duration
+	valid     time.Duration
+}
+
+// pdState tracks delegations and the downstream interfaces carved from them.
+type pdState struct {
+	mu          sync.Mutex
+	downstreams map[string]map[string]int   // upstream -> downstream interface -> subnet ID
+	delegations map[string]delegatedPrefix  // upstream -> current delegation
+	assigned    map[string]PrefixAssignment // downstream -> current assignment
+	listeners   []PrefixListener
+}
+
+// SubnetPrefix returns the /64 numbered id within a delegated prefix,
+// e.g. subnet 2 of 2001:db8:0:10::/60 is 2001:db8:0:12::/64.
+func SubnetPrefix(delegated netip.Prefix, id int) (netip.Prefix, error) {
+	bits := delegated.Bits()
+	if !delegated.Addr().Is6() || bits < 1 || bits > 64 {
+		return netip.Prefix{}, fmt.Errorf("cannot carve /64 subnets from %s", delegated)
+	}
+	if id < 0 || uint64(id) >= uint64(1)<<(64-bits) {
+		return netip.Prefix{}, fmt.Errorf("subnet ID %d does not fit in %s", id, delegated)
+	}
+
+	a := delegated.Masked().Addr().As16()
+	binary.BigEndian.PutUint64(a[:8], binary.BigEndian.Uint64(a[:8])|uint64(id))
+	return netip.PrefixFrom(netip.AddrFrom16(a), 64), nil
+}
+
+// AddPrefixListener registers fn for downstream prefix changes. Current
+// assignments are replayed so services started after the delegation was
+// obtained still see it.
+func (m *Manager) AddPrefixListener(fn PrefixListener) {
+	m.pd.mu.Lock()
+	defer m.pd.mu.Unlock()
+
+	m.pd.listeners = append(m.pd.listeners, fn)
+	for _, name := range sortedKeys(m.pd.assigned) {
+		fn(m.pd.assigned[name])
+	}
+}
+
+// registerPDDownstream records that an interface takes a /64 from the prefix
+// delegated on its pd_from interface, assigning it at once if a delegation is
+// already held.
+func (m *Manager) registerPDDownstream(ifaceCfg config.Interface) {
+	m.pd.mu.Lock()
+	defer m.pd.mu.Unlock()
+
+	if m.pd.downstreams == nil {
+		m.pd.downstreams = make(map[string]map[string]int)
+	}
+	if m.pd.downstreams[ifaceCfg.PDFrom] == nil {
+		m.pd.downstreams[ifaceCfg.PDFrom] = make(map[string]int)
+	}
+	m.pd.downstreams[ifaceCfg.PDFrom][ifaceCfg.Name] = ifaceCfg.PDSubnetID
+
+	if d, ok := m.pd.delegations[ifaceCfg.PDFrom]; ok {
+		m.assignPDPrefix(ifaceCfg.Name, ifaceCfg.PDFrom, ifaceCfg.PDSubnetID, d)
+	}
+}
+
+// updateDelegation records the prefix delegated on an upstream interface and
+// re-carves its downstream interfaces. An invalid prefix withdraws it.
+func (m *Manager) updateDelegation(upstream string, d delegatedPrefix) {
+	m.pd.mu.Lock()
+	defer m.pd.mu.Unlock()
+
+	if m.pd.delegations == nil {
+		m.pd.delegations = make(map[string]delegatedPrefix)
+	}
+	old := m.pd.delegations[upstream]
+	if d.prefix.IsValid() {
+		m.pd.delegations[upstream] = d
+	} else {
+		delete(m.pd.delegations, upstream)
+	}
+	switch {
+	case old.prefix == d.prefix:
+	case !d.prefix.IsValid():
+		log.Printf("[network] Delegated prefix %s on %s withdrawn", old.prefix, upstream)
+	case !old.prefix.IsValid():
+		log.Printf("[network] Obtained delegated prefix %s on %s", d.prefix, upstream)
+	default:
+		log.Printf("[network] Delegated prefix on %s changed: %s -> %s", upstream, old.prefix, d.prefix)
+	}
+
+	downstreams := m.pd.downstreams[upstream]
+	for _, name := range sortedKeys(downstreams) {
+		m.assignPDPrefix(name, upstream, downstreams[name], d)
+	}
+}
+
+// assignPDPrefix moves a downstream interface onto its /64 of d and notifies
+// listeners. Must be called with pd.mu held.
+func (m *Manager) assignPDPrefix(iface, upstream string, subnetID int, d delegatedPrefix) {
+	if m.pd.assigned == nil {
+		m.pd.assigned = make(map[string]PrefixAssignment)
+	}
+
+	a := PrefixAssignment{
+		Interface:         iface,
+		Upstream:          upstream,
+		PreferredLifetime: d.preferred,
+		ValidLifetime:     d.valid,
+	}
+	if d.prefix.IsValid() {
+		prefix, err := SubnetPrefix(d.prefix, subnetID)
+		if err != nil {
+			log.Printf("[network] Cannot assign delegated prefix to %s: %v", iface, err)
+		} else {
+			a.Prefix = prefix
+		}
+	}
+
+	prev := m.pd.assigned[iface]
+	if prev.Prefix.IsValid() && prev.Prefix != a.Prefix {
+		a.Previous = prev.Prefix
+		if err := m.setPrefixAddr(iface, prev.Prefix, false); err != nil {
+			log.Printf("[network] Failed to remove %s from %s: %v", prev.Prefix, iface, err)
+		}
+	}
+
+	if a.Prefix.IsValid() {
+		// Re-added on every update: ApplyInterface flushes addresses on reload.
+		if err := m.setPrefixAddr(iface, a.Prefix, true); err != nil {
+			log.Printf("[network] Failed to assign %s to %s: %v", a.Prefix, iface, err)
+		}
+		m.pd.assigned[iface] = a
+	} else {
+		delete(m.pd.assigned, iface)
+		if !a.Previous.IsValid() {
+			return
+		}
+	}
+
+	if !a.Prefix.IsValid() {
+		log.Printf("[network] Removed delegated prefix %s from %s", a.Previous, iface)
+	} else if a.Prefix != prev.Prefix {
+		log.Printf("[network] Assigned delegated prefix %s to %s (from %s)", a.Prefix, iface, upstream)
+	}
+	for _, fn := range m.pd.listeners {
+		fn(a)
+	}
+}
+
+// setPrefixAddr adds or removes the router's ::1 address in a /64.
+func (m *Manager) setPrefixAddr(iface string, prefix netip.Prefix, add bool) error {
+	link, err := m.nl.LinkByName(iface)
+	if err != nil {
+		return fmt.Errorf("interface %s not found: %w", iface, err)
+	}
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{
+			IP:   net.IP(prefix.Addr().Next().AsSlice()),
+			Mask: net.CIDRMask(prefix.Bits(), 128),
+		},
+	}
+	if !add {
+		return m.nl.AddrDel(link, addr)
+	}
+	if err := m.nl.AddrAdd(link, addr); err != nil && !strings.Contains(err.Error(), "file exists") {
+		return err
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
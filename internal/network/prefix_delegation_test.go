@@ -0,0 +1,118 @@
+package network
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSubnetPrefix(t *testing.T) {
+	tests := []struct {
+		delegated string
+		id        int
+		want      string
+		wantErr   bool
+	}{
+		{"2001:db8:0:10::/60", 0, "2001:db8:0:10::/64", false},
+		{"2001:db8:0:10::/60", 2, "2001:db8:0:12::/64", false},
+		{"2001:db8:0:10::/60", 15, "2001:db8:0:1f::/64", false},
+		{"2001:db8:0:10::/60", 16, "", true},
+		{"2001:db8:ab00::/56", 255, "2001:db8:ab00:ff::/64", false},
+		{"2001:db8:1:2::/64", 0, "2001:db8:1:2::/64", false},
+		{"2001:db8:1:2::/64", 1, "", true},
+		{"2001:db8:0:10::/60", -1, "", true},
+		{"2001:db8::/96", 0, "", true},
+		{"192.168.0.0/16", 0, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := SubnetPrefix(netip.MustParsePrefix(tt.delegated), tt.id)
+		if tt.wantErr {
+			assert.Error(t, err, "%s #%d", tt.delegated, tt.id)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got.String(), "%s #%d", tt.delegated, tt.id)
+	}
+}
+
+func TestPrefixDelegation_Downstream(t *testing.T) {
+	mockNetlink := new(MockNetlinker)
+	m := NewManagerWithDeps(mockNetlink, nil, nil)
+
+	lan := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}}
+	mockNetlink.On("LinkByName", "eth1").Return(lan, nil)
+	hostAddr := func(cidr string) interface{} {
+		return mock.MatchedBy(func(a *netlink.Addr) bool { return a.IPNet.String() == cidr })
+	}
+
+	var got []PrefixAssignment
+	m.AddPrefixListener(func(a PrefixAssignment) { got = append(got, a) })
+
+	// Registered before the delegation arrives: nothing to assign yet
+	m.registerPDDownstream(config.Interface{Name: "eth1", PDFrom: "eth0", PDSubnetID: 1})
+	assert.Empty(t, got)
+
+	// Delegation arrives
+	mockNetlink.On("AddrAdd", lan, hostAddr("2001:db8:0:11::1/64")).Return(nil).Twice()
+	m.updateDelegation("eth0", delegatedPrefix{prefix: netip.MustParsePrefix("2001:db8:0:10::/60"), preferred: time.Hour, valid: 2 * time.Hour})
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "eth1", got[0].Interface)
+		assert.Equal(t, "eth0", got[0].Upstream)
+		assert.Equal(t, "2001:db8:0:11::/64", got[0].Prefix.String())
+		assert.False(t, got[0].Previous.IsValid())
+		assert.Equal(t, time.Hour, got[0].PreferredLifetime)
+	}
+
+	// Late listeners see the current assignment
+	var replayed []PrefixAssignment
+	m.AddPrefixListener(func(a PrefixAssignment) { replayed = append(replayed, a) })
+	assert.Len(t, replayed, 1)
+
+	// Renewal keeps the prefix
+	m.updateDelegation("eth0", delegatedPrefix{prefix: netip.MustParsePrefix("2001:db8:0:10::/60"), preferred: time.Hour, valid: 2 * time.Hour})
+	if assert.Len(t, got, 2) {
+		assert.False(t, got[1].Previous.IsValid())
+	}
+
+	// ISP rotates the prefix: old address removed, new one added
+	mockNetlink.On("AddrDel", lan, hostAddr("2001:db8:0:11::1/64")).Return(nil).Once()
+	mockNetlink.On("AddrAdd", lan, hostAddr("2001:db8:0:21::1/64")).Return(nil).Once()
+	m.updateDelegation("eth0", delegatedPrefix{prefix: netip.MustParsePrefix("2001:db8:0:20::/60"), preferred: time.Hour, valid: 2 * time.Hour})
+	if assert.Len(t, got, 3) {
+		assert.Equal(t, "2001:db8:0:21::/64", got[2].Prefix.String())
+		assert.Equal(t, "2001:db8:0:11::/64", got[2].Previous.String())
+	}
+
+	// Delegation lost
+	mockNetlink.On("AddrDel", lan, hostAddr("2001:db8:0:21::1/64")).Return(nil).Once()
+	m.updateDelegation("eth0", delegatedPrefix{})
+	if assert.Len(t, got, 4) {
+		assert.False(t, got[3].Prefix.IsValid())
+		assert.Equal(t, "2001:db8:0:21::/64", got[3].Previous.String())
+	}
+
+	mockNetlink.AssertExpectations(t)
+}
+
+func TestPrefixDelegation_RegisterAfterDelegation(t *testing.T) {
+	mockNetlink := new(MockNetlinker)
+	m := NewManagerWithDeps(mockNetlink, nil, nil)
+
+	lan := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 4}}
+	mockNetlink.On("LinkByName", "eth2").Return(lan, nil)
+	mockNetlink.On("AddrAdd", lan, mock.MatchedBy(func(a *netlink.Addr) bool {
+		return a.IPNet.String() == "2001:db8:ab00:ff::1/64"
+	})).Return(nil).Once()
+
+	m.updateDelegation("eth0", delegatedPrefix{prefix: netip.MustParsePrefix("2001:db8:ab00::/56"), valid: time.Hour})
+	m.registerPDDownstream(config.Interface{Name: "eth2", PDFrom: "eth0", PDSubnetID: 255})
+
+	mockNetlink.AssertExpectations(t)
+}
@@ -42,6 +42,10 @@ type Uplink struct {
 
 	Enabled bool
 
+	// Dynamic uplinks take LocalIP and Gateway from the link (e.g. a PPPoE
+	// session) and are marked down while it is down. See SetLinkState.
+	Dynamic bool
+
 	// Health status
 	Healthy      bool
 	LastCheck    time.Time
@@ -605,15 +609,7 @@ func (g *UplinkGroup) Teardown() error {
 
 		// Remove SNAT
 		if uplink.LocalIP != "" {
-			// nft delete rule ... (Need handle? Or match?)
-			// Deleting by match is hard in nft without handle.
-			// Ideally we use comments to finding handle, then delete.
-			// For now, simpler approach: Flush chain? No.
-			// We can try to delete by exact match.
-			DefaultCommandExecutor.RunCommand("nft", "delete", "rule", "inet", "glacic", "nat_postrouting",
-				"meta", "mark", fmt.Sprintf("0x%x", uplink.Mark),
-				"oifname", uplink.Interface,
-				"snat", "to", uplink.LocalIP)
+			g.removeSNAT(uplink)
 		}
 
 		// Remove ip rule
@@ -872,10 +868,51 @@ func (g *UplinkGroup) setupSNAT(uplink *Uplink) error {
 	_, err := g.executor.RunCommand("nft", "add", "rule", "inet", "glacic", "nat_postrouting",
 		"meta", "mark", fmt.Sprintf("0x%x", uplink.Mark),
 		"oifname", uplink.Interface,
-		"snat", "to", uplink.LocalIP)
+		"snat", "to", uplink.LocalIP,
+		"comment", fmt.Sprintf(`"%s"`, snatComment(uplink)))
 	return err
 }
 
+// snatComment tags an uplink's SNAT rule; it matches the native manager's.
+func snatComment(uplink *Uplink) string {
+	return fmt.Sprintf("snat_%s_%x", uplink.Interface, uplink.Mark)
+}
+
+// removeSNAT deletes the uplink's SNAT rule. nft deletes rules only by
+// handle, so the shell fallback looks the handle up by comment.
+func (g *UplinkGroup) removeSNAT(uplink *Uplink) {
+	comment := snatComment(uplink)
+	if g.nftMgr != nil {
+		if err := g.nftMgr.DeleteRulesByComment("nat_postrouting", comment); err == nil {
+			g.nftMgr.Flush()
+		}
+		return
+	}
+
+	out, err := g.executor.RunCommand("nft", "-a", "list", "chain", "inet", "glacic", "nat_postrouting")
+	if err != nil {
+		return
+	}
+	for _, handle := range ruleHandles(out, comment) {
+		g.executor.RunCommand("nft", "delete", "rule", "inet", "glacic", "nat_postrouting", "handle", handle)
+	}
+}
+
+// ruleHandles returns the handles of the rules in `nft -a list` output
+// whose comment is exactly comment.
+func ruleHandles(listing, comment string) []string {
+	var handles []string
+	tag := fmt.Sprintf(`comment "%s"`, comment)
+	for _, line := range strings.Split(listing, "\n") {
+		i := strings.LastIndex(line, "# handle ")
+		if i < 0 || !strings.Contains(line[:i], tag) {
+			continue
+		}
+		handles = append(handles, strings.TrimSpace(line[i+len("# handle "):]))
+	}
+	return handles
+}
+
 func (g *UplinkGroup) setupRoutingTable(uplink *Uplink) error {
 	_, err := g.executor.RunCommand("ip", "route", "add", "default",
 		"via", uplink.Gateway,
@@ -884,6 +921,74 @@ func (g *UplinkGroup) setupRoutingTable(uplink *Uplink) error {
 	return err
 }
 
+// removeAddressing removes the SNAT rule and default route set up for the
+// uplink's current LocalIP and Gateway.
+func (g *UplinkGroup) removeAddressing(uplink *Uplink) {
+	if uplink.Gateway != "" {
+		g.executor.RunCommand("ip", "route", "del", "default",
+			"via", uplink.Gateway,
+			"dev", uplink.Interface,
+			"table", strconv.Itoa(int(uplink.Table)))
+	}
+	if uplink.LocalIP != "" {
+		g.removeSNAT(uplink)
+	}
+}
+
+// setLinkState applies the state of a dynamically addressed link to the
+// group's dynamic uplinks on it.
+func (g *UplinkGroup) setLinkState(iface string, state linkState) {
+	g.mu.Lock()
+	var readdressed, down []*Uplink
+	for _, u := range g.Uplinks {
+		if !u.Dynamic || u.Interface != iface {
+			continue
+		}
+		if !state.up {
+			if u.Healthy {
+				u.Healthy = false
+				u.SuccessCount = 0
+				u.LastCheck = clock.Now()
+				down = append(down, u)
+			}
+			continue
+		}
+		if u.LocalIP != state.localIP || u.Gateway != state.gateway {
+			g.removeAddressing(u)
+			u.LocalIP = state.localIP
+			u.Gateway = state.gateway
+			readdressed = append(readdressed, u)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, u := range readdressed {
+		g.logger.Info("uplink readdressed", "uplink", u.Name, "local_ip", u.LocalIP, "gateway", u.Gateway)
+		if u.LocalIP != "" {
+			if err := g.setupSNAT(u); err != nil {
+				g.logger.Warn("failed to setup SNAT", "uplink", u.Name, "error", err)
+			}
+		}
+		if u.Gateway != "" {
+			if err := g.setupRoutingTable(u); err != nil {
+				g.logger.Warn("failed to setup routing table", "uplink", u.Name, "error", err)
+			}
+		}
+	}
+
+	// Don't wait for the health checker to notice the link is gone
+	for _, u := range down {
+		if g.OnHealthChange != nil {
+			g.OnHealthChange(u, false)
+		}
+	}
+	if len(down) > 0 {
+		if err := g.SwitchToBest(); err != nil {
+			g.logger.Warn("failed to switch uplink", "group", g.Name, "error", err)
+		}
+	}
+}
+
 // UplinkManager manages multiple uplink groups.
 type UplinkManager struct {
 	groups    map[string]*UplinkGroup
@@ -895,8 +1000,19 @@ type UplinkManager struct {
 	// Global health checker
 	healthChecker *UplinkHealthChecker
 
-	// Global callback for all groups
+	// Global callbacks for all groups
 	globalHealthCallback func(uplink *Uplink, healthy bool)
+	globalSwitchCallback func(group string, from, to *Uplink)
+
+	// Last known state of dynamically addressed links, by interface
+	links map[string]linkState
+}
+
+// linkState is the state of a dynamically addressed link.
+type linkState struct {
+	up      bool
+	localIP string
+	gateway string
 }
 
 // SetHealthCallback sets a global callback for health changes.
@@ -910,12 +1026,53 @@ func (m *UplinkManager) SetHealthCallback(cb func(uplink *Uplink, healthy bool))
 	}
 }
 
+// SetSwitchCallback sets a global callback for failovers between uplinks.
+// It is called with the group locked and must not block.
+func (m *UplinkManager) SetSwitchCallback(cb func(group string, from, to *Uplink)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.globalSwitchCallback = cb
+	// Update existing groups
+	for _, g := range m.groups {
+		g.OnSwitch = m.switchCallback(g.Name)
+	}
+}
+
+// switchCallback binds the global switch callback to a group.
+func (m *UplinkManager) switchCallback(group string) func(from, to *Uplink) {
+	cb := m.globalSwitchCallback
+	if cb == nil {
+		return nil
+	}
+	return func(from, to *Uplink) { cb(group, from, to) }
+}
+
 // NewUplinkManager creates a new uplink manager.
 func NewUplinkManager() *UplinkManager {
 	return &UplinkManager{
 		groups:    make(map[string]*UplinkGroup),
 		executor:  DefaultCommandExecutor,
 		netlinker: &RealNetlinker{},
+		links:     make(map[string]linkState),
+	}
+}
+
+// SetLinkState updates the dynamic uplinks on iface when a dynamically
+// addressed link, such as a PPPoE session, comes up with an address or goes
+// down. The state is kept so that uplinks added on reload start with it.
+func (m *UplinkManager) SetLinkState(iface string, up bool, localIP, gateway string) {
+	state := linkState{up: up, localIP: localIP, gateway: gateway}
+
+	m.mu.Lock()
+	m.links[iface] = state
+	groups := make([]*UplinkGroup, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	m.mu.Unlock()
+
+	for _, g := range groups {
+		g.setLinkState(iface, state)
 	}
 }
 
@@ -989,7 +1146,10 @@ func (m *UplinkManager) Reload(configGroups []config.UplinkGroup) error {
 
 		g := m.CreateGroup(cfgGroup.Name)
 		g.HealthCheck = cfgGroup.HealthCheck
-		g.OnHealthChange = m.globalHealthCallback // Inherit callback
+		g.OnHealthChange = m.globalHealthCallback // Inherit callbacks
+		m.mu.RLock()
+		g.OnSwitch = m.switchCallback(g.Name)
+		m.mu.RUnlock()
 
 		// Configure logic modes
 		// g.FailoverMode = FailoverMode(cfgGroup.FailoverMode) // Need validation/conversion
@@ -1025,6 +1185,18 @@ func (m *UplinkManager) Reload(configGroups []config.UplinkGroup) error {
 				Enabled:   true,
 				Healthy:   true, // Optimistic start
 			}
+			// Without a fixed address, follow the link (e.g. PPPoE)
+			if u.Gateway == "" && u.LocalIP == "" {
+				u.Dynamic = true
+				m.mu.RLock()
+				state, ok := m.links[u.Interface]
+				m.mu.RUnlock()
+				if ok {
+					u.Healthy = state.up
+					u.LocalIP = state.localIP
+					u.Gateway = state.gateway
+				}
+			}
 			// Type inference or explicit
 			if cfgUplink.Type != "" {
 				u.Type = UplinkType(cfgUplink.Type)
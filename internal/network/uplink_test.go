@@ -1,6 +1,9 @@
 package network
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -392,3 +395,76 @@ func TestUplinkHealthChecker_AdaptiveWeights(t *testing.T) {
 	// Weight = 10000 / 3 = 3333.
 	assert.Equal(t, 3333, u1.DynamicWeight)
 }
+
+// commandRecorder records commands as strings and replies with canned
+// output.
+type commandRecorder struct {
+	commands []string
+	outputs  map[string]string
+}
+
+func (r *commandRecorder) RunCommand(name string, arg ...string) (string, error) {
+	cmd := strings.Join(append([]string{name}, arg...), " ")
+	r.commands = append(r.commands, cmd)
+	return r.outputs[cmd], nil
+}
+
+func TestUplinkManager_SetLinkState(t *testing.T) {
+	rec := &commandRecorder{}
+	m := NewUplinkManager()
+	m.executor = rec
+
+	g := m.CreateGroup("wan")
+	ppp := &Uplink{Name: "dsl", Interface: "ppp-wan", Dynamic: true, Enabled: true, Healthy: true, Weight: 100}
+	backup := &Uplink{Name: "lte", Interface: "wwan0", Gateway: "10.0.0.1", Tier: 1, Enabled: true, Healthy: true, Weight: 100}
+	g.AddUplink(ppp)
+	g.AddUplink(backup)
+	table := strconv.Itoa(int(ppp.Table))
+
+	m.SetLinkState("ppp-wan", true, "198.51.100.7", "192.0.2.1")
+	assert.Equal(t, "198.51.100.7", ppp.LocalIP)
+	assert.Contains(t, rec.commands, "ip route add default via 192.0.2.1 dev ppp-wan table "+table)
+	assert.Equal(t, ppp.Mark, g.GetCurrentMark())
+
+	// The session drops: fail over without waiting for health checks
+	var changes []string
+	g.OnHealthChange = func(u *Uplink, healthy bool) { changes = append(changes, u.Name) }
+	m.SetLinkState("ppp-wan", false, "", "")
+	assert.False(t, ppp.Healthy)
+	assert.Equal(t, []string{"dsl"}, changes)
+	assert.Equal(t, backup.Mark, g.GetCurrentMark())
+
+	// It comes back with a new address: the old SNAT and route go
+	comment := fmt.Sprintf("snat_ppp-wan_%x", ppp.Mark)
+	assert.Contains(t, rec.commands, fmt.Sprintf(`nft add rule inet glacic nat_postrouting meta mark 0x%x oifname ppp-wan snat to 198.51.100.7 comment "%s"`, ppp.Mark, comment))
+	rec.commands = nil
+	rec.outputs = map[string]string{
+		"nft -a list chain inet glacic nat_postrouting": fmt.Sprintf(`table inet glacic {
+	chain nat_postrouting { # handle 3
+		type nat hook postrouting priority srcnat; policy accept;
+		oifname "eth0" masquerade # handle 4
+		meta mark 0x%08x oifname "ppp-wan" snat ip to 198.51.100.7 comment "%s" # handle 9
+		meta mark 0x%08x oifname "ppp-wan" snat ip to 198.51.100.7 comment "%s0" # handle 10
+	}
+}
+`, ppp.Mark, comment, ppp.Mark, comment),
+	}
+	m.SetLinkState("ppp-wan", true, "198.51.100.8", "192.0.2.1")
+	assert.Contains(t, rec.commands, "ip route del default via 192.0.2.1 dev ppp-wan table "+table)
+	assert.Contains(t, rec.commands, "nft delete rule inet glacic nat_postrouting handle 9")
+	assert.NotContains(t, rec.commands, "nft delete rule inet glacic nat_postrouting handle 10")
+	assert.Contains(t, rec.commands, fmt.Sprintf(`nft add rule inet glacic nat_postrouting meta mark 0x%x oifname ppp-wan snat to 198.51.100.8 comment "%s"`, ppp.Mark, comment))
+
+	// The native manager deletes by comment
+	nft := new(MockNFTManager)
+	nft.On("DeleteRulesByComment", "nat_postrouting", comment).Return(nil)
+	nft.On("AddSNAT", "nat_postrouting", uint32(ppp.Mark), "ppp-wan", "198.51.100.9").Return(nil)
+	nft.On("Flush").Return(nil)
+	m.SetNFTManager(nft)
+	m.SetLinkState("ppp-wan", true, "198.51.100.9", "192.0.2.1")
+	nft.AssertExpectations(t)
+
+	// Static uplinks ignore link state
+	m.SetLinkState("wwan0", false, "", "")
+	assert.True(t, backup.Healthy)
+}
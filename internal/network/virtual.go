@@ -0,0 +1,317 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"grimm.is/glacic/internal/config"
+)
+
+// managedLinkAlias marks the bridges and tunnels glacic creates, so that
+// they can be deleted once they are removed from the configuration.
+const managedLinkAlias = "glacic-managed"
+
+// vxlanDefaultPort is the IANA port; the kernel otherwise uses 8472.
+const vxlanDefaultPort = 4789
+
+// createsLink reports whether ifaceCfg describes a bridge or tunnel that
+// glacic creates.
+func createsLink(ifaceCfg config.Interface) bool {
+	return ifaceCfg.Bridge != nil || ifaceCfg.VXLAN != nil || ifaceCfg.GRE != nil
+}
+
+// ensureVirtualLink creates the bridge or tunnel of ifaceCfg, recreating it
+// if its settings changed.
+func (m *Manager) ensureVirtualLink(ifaceCfg config.Interface) (netlink.Link, error) {
+	want, err := m.virtualLink(ifaceCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := m.nl.LinkByName(ifaceCfg.Name); err == nil {
+		if existing.Type() == want.Type() && virtualLinkMatches(existing, want) {
+			return existing, nil
+		}
+		if existing.Attrs().Alias != managedLinkAlias {
+			return nil, fmt.Errorf("interface %s already exists as %s", ifaceCfg.Name, existing.Type())
+		}
+		log.Printf("[network] Recreating %s %s with new settings", want.Type(), ifaceCfg.Name)
+		if err := m.nl.LinkDel(existing); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", ifaceCfg.Name, err)
+		}
+	}
+
+	log.Printf("[network] Creating %s %s", want.Type(), ifaceCfg.Name)
+	if err := m.nl.LinkAdd(want); err != nil {
+		return nil, fmt.Errorf("failed to add %s %s: %w", want.Type(), ifaceCfg.Name, err)
+	}
+	link, err := m.nl.LinkByName(ifaceCfg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get newly created link %s: %w", ifaceCfg.Name, err)
+	}
+	return link, nil
+}
+
+// virtualLink builds the link to create for ifaceCfg.
+func (m *Manager) virtualLink(ifaceCfg config.Interface) (netlink.Link, error) {
+	attrs := netlink.LinkAttrs{Name: ifaceCfg.Name, Alias: managedLinkAlias}
+	if ifaceCfg.MTU > 0 {
+		attrs.MTU = ifaceCfg.MTU
+	}
+
+	switch {
+	case ifaceCfg.Bridge != nil:
+		return &netlink.Bridge{LinkAttrs: attrs}, nil
+
+	case ifaceCfg.VXLAN != nil:
+		cfg := ifaceCfg.VXLAN
+		vxlan := &netlink.Vxlan{
+			LinkAttrs: attrs,
+			VxlanId:   cfg.VNI,
+			SrcAddr:   net.ParseIP(cfg.Local),
+			TTL:       cfg.TTL,
+			Port:      cfg.Port,
+			Learning:  cfg.Learning == nil || *cfg.Learning,
+		}
+		// The kernel keeps the unicast remote and the multicast group in
+		// the same attribute
+		vxlan.Group = net.ParseIP(cfg.Remote)
+		if cfg.Group != "" {
+			vxlan.Group = net.ParseIP(cfg.Group)
+		}
+		if vxlan.Port == 0 {
+			vxlan.Port = vxlanDefaultPort
+		}
+		if cfg.Device != "" {
+			dev, err := m.nl.LinkByName(cfg.Device)
+			if err != nil {
+				return nil, fmt.Errorf("VXLAN device %s not found: %w", cfg.Device, err)
+			}
+			vxlan.VtepDevIndex = dev.Attrs().Index
+		}
+		return vxlan, nil
+
+	case ifaceCfg.GRE != nil:
+		cfg := ifaceCfg.GRE
+		local, remote := net.ParseIP(cfg.Local), net.ParseIP(cfg.Remote)
+		if local == nil {
+			// The local address picks the tunnel family (gre or ip6gre)
+			local = net.IPv4zero
+			if remote.To4() == nil {
+				local = net.IPv6zero
+			}
+		}
+		key := uint32(cfg.Key)
+		if cfg.Tap {
+			return &netlink.Gretap{LinkAttrs: attrs, Local: local, Remote: remote, IKey: key, OKey: key, Ttl: uint8(cfg.TTL), PMtuDisc: 1}, nil
+		}
+		return &netlink.Gretun{LinkAttrs: attrs, Local: local, Remote: remote, IKey: key, OKey: key, Ttl: uint8(cfg.TTL), PMtuDisc: 1}, nil
+	}
+
+	return nil, fmt.Errorf("interface %s is not a virtual interface", ifaceCfg.Name)
+}
+
+// virtualLinkMatches reports whether an existing link of the same type
+// has the settings of want. Settings that can be changed in place, like
+// the bridge options, are not compared.
+func virtualLinkMatches(existing, want netlink.Link) bool {
+	switch w := want.(type) {
+	case *netlink.Vxlan:
+		e, ok := existing.(*netlink.Vxlan)
+		return ok && e.VxlanId == w.VxlanId && sameIP(e.Group, w.Group) && sameIP(e.SrcAddr, w.SrcAddr) &&
+			e.Port == w.Port && e.VtepDevIndex == w.VtepDevIndex && e.TTL == w.TTL && e.Learning == w.Learning
+	case *netlink.Gretap:
+		e, ok := existing.(*netlink.Gretap)
+		return ok && sameIP(e.Local, w.Local) && sameIP(e.Remote, w.Remote) && e.IKey == w.IKey && e.Ttl == w.Ttl
+	case *netlink.Gretun:
+		e, ok := existing.(*netlink.Gretun)
+		return ok && sameIP(e.Local, w.Local) && sameIP(e.Remote, w.Remote) && e.IKey == w.IKey && e.Ttl == w.Ttl
+	}
+	return true
+}
+
+// sameIP compares addresses, treating unset and unspecified as equal.
+func sameIP(a, b net.IP) bool {
+	if len(a) == 0 || a.IsUnspecified() {
+		return len(b) == 0 || b.IsUnspecified()
+	}
+	return a.Equal(b)
+}
+
+// applyBridge sets the bridge options and adds its member ports. Members
+// that do not exist yet join when their own interface is applied.
+func (m *Manager) applyBridge(link netlink.Link, ifaceCfg config.Interface) {
+	cfg := ifaceCfg.Bridge
+	sysfs := func(attr, value string) {
+		path := fmt.Sprintf("/sys/class/net/%s/bridge/%s", ifaceCfg.Name, attr)
+		if err := m.sys.WriteSysctl(path, value); err != nil {
+			log.Printf("Warning: failed to set %s on bridge %s: %v", attr, ifaceCfg.Name, err)
+		}
+	}
+
+	sysfs("stp_state", boolSysfs(cfg.STP))
+	if cfg.Priority != nil {
+		sysfs("priority", fmt.Sprint(*cfg.Priority))
+	}
+	sysfs("default_pvid", fmt.Sprint(bridgeDefaultPVID(cfg)))
+	sysfs("vlan_filtering", boolSysfs(cfg.VLANFiltering))
+
+	// The bridge's own VLANs let the router take part in them, e.g. via
+	// vlan blocks on the bridge
+	if cfg.VLANFiltering {
+		m.reconcileBridgeVLANs(link, config.BridgePort{Name: ifaceCfg.Name, PVID: bridgeDefaultPVID(cfg), VLANs: cfg.VLANs}, true)
+	}
+
+	if m.bridgePorts == nil {
+		m.bridgePorts = make(map[string]bridgePort)
+	}
+	for _, member := range cfg.Interfaces {
+		port := bridgePort{bridge: ifaceCfg.Name, cfg: bridgePortConfig(cfg, member)}
+		m.bridgePorts[member] = port
+		memberLink, err := m.nl.LinkByName(member)
+		if err != nil {
+			log.Printf("[network] Bridge member %s of %s not found yet", member, ifaceCfg.Name)
+			continue
+		}
+		m.joinBridge(memberLink, link, port)
+	}
+}
+
+// bridgePort is the bridge a member belongs to, with its VLAN settings
+// when the bridge filters VLANs.
+type bridgePort struct {
+	bridge string
+	cfg    *config.BridgePort
+}
+
+// joinPendingBridge adds an interface to the bridge that lists it.
+func (m *Manager) joinPendingBridge(link netlink.Link) {
+	port, ok := m.bridgePorts[link.Attrs().Name]
+	if !ok {
+		return
+	}
+	bridge, err := m.nl.LinkByName(port.bridge)
+	if err != nil {
+		return
+	}
+	m.joinBridge(link, bridge, port)
+}
+
+func (m *Manager) joinBridge(member, bridge netlink.Link, port bridgePort) {
+	name := member.Attrs().Name
+	if member.Attrs().MasterIndex != bridge.Attrs().Index {
+		if err := m.nl.LinkSetMaster(member, bridge); err != nil {
+			log.Printf("Error adding %s to bridge %s: %v", name, port.bridge, err)
+			return
+		}
+	}
+	if err := m.nl.LinkSetUp(member); err != nil {
+		log.Printf("Warning: failed to bring up bridge member %s: %v", name, err)
+	}
+	if port.cfg != nil {
+		m.reconcileBridgeVLANs(member, *port.cfg, false)
+	}
+}
+
+// reconcileBridgeVLANs makes the VLANs of a bridge port (or, with self, of
+// the bridge itself) match port.
+func (m *Manager) reconcileBridgeVLANs(link netlink.Link, port config.BridgePort, self bool) {
+	want := make(map[uint16]BridgeVLAN)
+	for _, vid := range port.VLANs {
+		want[uint16(vid)] = BridgeVLAN{ID: uint16(vid)}
+	}
+	if port.PVID > 0 {
+		want[uint16(port.PVID)] = BridgeVLAN{ID: uint16(port.PVID), PVID: true, Untagged: true}
+	}
+
+	current, err := m.nl.BridgeVlanList(link)
+	if err != nil {
+		log.Printf("Warning: failed to list VLANs of %s: %v", port.Name, err)
+	}
+	have := make(map[uint16]BridgeVLAN)
+	for _, v := range current {
+		have[v.ID] = v
+		if _, ok := want[v.ID]; !ok {
+			if err := m.nl.BridgeVlanDel(link, v.ID, v.PVID, v.Untagged, self, !self); err != nil {
+				log.Printf("Warning: failed to remove VLAN %d from %s: %v", v.ID, port.Name, err)
+			}
+		}
+	}
+	for _, vid := range sortedVLANs(want) {
+		v := want[vid]
+		if have[vid] == v {
+			continue
+		}
+		if err := m.nl.BridgeVlanAdd(link, v.ID, v.PVID, v.Untagged, self, !self); err != nil {
+			log.Printf("Warning: failed to add VLAN %d to %s: %v", v.ID, port.Name, err)
+		}
+	}
+}
+
+// RemoveStaleInterfaces deletes the bridges and tunnels glacic created
+// that are no longer configured.
+func (m *Manager) RemoveStaleInterfaces(interfaces []config.Interface) error {
+	configured := make(map[string]bool)
+	for _, iface := range interfaces {
+		if createsLink(iface) {
+			configured[iface.Name] = true
+		}
+	}
+	m.bridgePorts = nil
+
+	links, err := m.nl.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.Alias != managedLinkAlias || configured[attrs.Name] {
+			continue
+		}
+		log.Printf("[network] Deleting %s %s, no longer configured", link.Type(), attrs.Name)
+		if err := m.nl.LinkDel(link); err != nil {
+			log.Printf("Warning: failed to delete %s: %v", attrs.Name, err)
+		}
+	}
+	return nil
+}
+
+func bridgeDefaultPVID(cfg *config.Bridge) int {
+	if cfg.DefaultPVID > 0 {
+		return cfg.DefaultPVID
+	}
+	return 1
+}
+
+// bridgePortConfig returns the VLAN settings of a bridge member, or nil
+// if the bridge does not filter VLANs.
+func bridgePortConfig(cfg *config.Bridge, member string) *config.BridgePort {
+	if !cfg.VLANFiltering {
+		return nil
+	}
+	for i := range cfg.Ports {
+		if cfg.Ports[i].Name == member {
+			return &cfg.Ports[i]
+		}
+	}
+	return &config.BridgePort{Name: member, PVID: bridgeDefaultPVID(cfg)}
+}
+
+func sortedVLANs(m map[uint16]BridgeVLAN) []uint16 {
+	ids := make([]uint16, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func boolSysfs(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
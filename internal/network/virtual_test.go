@@ -0,0 +1,153 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEnsureVirtualLink_Create(t *testing.T) {
+	mockNetlink := new(MockNetlinker)
+	m := NewManagerWithDeps(mockNetlink, nil, nil)
+
+	underlay := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	created := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: "vx100", Index: 9}}
+	mockNetlink.On("LinkByName", "eth0").Return(underlay, nil).Once()
+	mockNetlink.On("LinkByName", "vx100").Return(nil, errors.New("link not found")).Once()
+	mockNetlink.On("LinkAdd", mock.MatchedBy(func(l netlink.Link) bool {
+		v, ok := l.(*netlink.Vxlan)
+		return ok && v.VxlanId == 100 && v.Group.Equal(net.ParseIP("239.1.1.100")) && v.VtepDevIndex == 2 &&
+			v.Port == vxlanDefaultPort && v.Learning && v.Alias == managedLinkAlias
+	})).Return(nil).Once()
+	mockNetlink.On("LinkByName", "vx100").Return(created, nil).Once()
+
+	link, err := m.ensureVirtualLink(config.Interface{
+		Name:  "vx100",
+		VXLAN: &config.VXLAN{VNI: 100, Group: "239.1.1.100", Device: "eth0"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, created, link)
+	mockNetlink.AssertExpectations(t)
+}
+
+func TestEnsureVirtualLink_Existing(t *testing.T) {
+	gre := config.Interface{Name: "gt0", GRE: &config.GRE{Remote: "203.0.113.2", Local: "198.51.100.1", Tap: true, Key: 42}}
+
+	// Unchanged tunnels are kept
+	mockNetlink := new(MockNetlinker)
+	m := NewManagerWithDeps(mockNetlink, nil, nil)
+	existing := &netlink.Gretap{
+		LinkAttrs: netlink.LinkAttrs{Name: "gt0", Alias: managedLinkAlias},
+		Local:     net.ParseIP("198.51.100.1"), Remote: net.ParseIP("203.0.113.2"), IKey: 42, OKey: 42,
+	}
+	mockNetlink.On("LinkByName", "gt0").Return(existing, nil).Once()
+	link, err := m.ensureVirtualLink(gre)
+	assert.NoError(t, err)
+	assert.Equal(t, existing, link)
+	mockNetlink.AssertExpectations(t)
+
+	// A changed key recreates the tunnel
+	mockNetlink = new(MockNetlinker)
+	m = NewManagerWithDeps(mockNetlink, nil, nil)
+	gre.GRE.Key = 43
+	mockNetlink.On("LinkByName", "gt0").Return(existing, nil).Once()
+	mockNetlink.On("LinkDel", existing).Return(nil).Once()
+	mockNetlink.On("LinkAdd", mock.MatchedBy(func(l netlink.Link) bool {
+		g, ok := l.(*netlink.Gretap)
+		return ok && g.IKey == 43 && g.OKey == 43
+	})).Return(nil).Once()
+	mockNetlink.On("LinkByName", "gt0").Return(existing, nil).Once()
+	_, err = m.ensureVirtualLink(gre)
+	assert.NoError(t, err)
+	mockNetlink.AssertExpectations(t)
+
+	// Interfaces glacic did not create are never replaced
+	mockNetlink = new(MockNetlinker)
+	m = NewManagerWithDeps(mockNetlink, nil, nil)
+	nic := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1"}}
+	mockNetlink.On("LinkByName", "eth1").Return(nic, nil).Once()
+	_, err = m.ensureVirtualLink(config.Interface{Name: "eth1", Bridge: &config.Bridge{}})
+	assert.ErrorContains(t, err, "already exists as device")
+	mockNetlink.AssertExpectations(t)
+}
+
+func TestApplyBridge_VLANFiltering(t *testing.T) {
+	mockNetlink := new(MockNetlinker)
+	mockSys := new(MockSystemController)
+	m := NewManagerWithDeps(mockNetlink, mockSys, nil)
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0", Index: 10}}
+	eth1 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}}
+	eth2 := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 4, MasterIndex: 10}}
+	ifaceCfg := config.Interface{Name: "br0", Bridge: &config.Bridge{
+		Interfaces:    []string{"eth1", "eth2", "gt0"},
+		STP:           true,
+		VLANFiltering: true,
+		VLANs:         []int{10, 20},
+		Ports:         []config.BridgePort{{Name: "eth1", PVID: 10, VLANs: []int{20}}},
+	}}
+	defaultVLAN := []BridgeVLAN{{ID: 1, PVID: true, Untagged: true}}
+
+	mockSys.On("WriteSysctl", "/sys/class/net/br0/bridge/stp_state", "1").Return(nil).Once()
+	mockSys.On("WriteSysctl", "/sys/class/net/br0/bridge/default_pvid", "1").Return(nil).Once()
+	mockSys.On("WriteSysctl", "/sys/class/net/br0/bridge/vlan_filtering", "1").Return(nil).Once()
+
+	// The bridge itself keeps VLAN 1 untagged and joins 10 and 20
+	mockNetlink.On("BridgeVlanList", br).Return(defaultVLAN, nil).Once()
+	mockNetlink.On("BridgeVlanAdd", br, uint16(10), false, false, true, false).Return(nil).Once()
+	mockNetlink.On("BridgeVlanAdd", br, uint16(20), false, false, true, false).Return(nil).Once()
+
+	// eth1 moves to VLAN 10 untagged, 20 tagged
+	mockNetlink.On("LinkByName", "eth1").Return(eth1, nil).Once()
+	mockNetlink.On("LinkSetMaster", eth1, br).Return(nil).Once()
+	mockNetlink.On("LinkSetUp", eth1).Return(nil).Once()
+	mockNetlink.On("BridgeVlanList", eth1).Return(defaultVLAN, nil).Once()
+	mockNetlink.On("BridgeVlanDel", eth1, uint16(1), true, true, false, true).Return(nil).Once()
+	mockNetlink.On("BridgeVlanAdd", eth1, uint16(10), true, true, false, true).Return(nil).Once()
+	mockNetlink.On("BridgeVlanAdd", eth1, uint16(20), false, false, false, true).Return(nil).Once()
+
+	// eth2 is already a member with the default PVID
+	mockNetlink.On("LinkByName", "eth2").Return(eth2, nil).Once()
+	mockNetlink.On("LinkSetUp", eth2).Return(nil).Once()
+	mockNetlink.On("BridgeVlanList", eth2).Return(defaultVLAN, nil).Once()
+
+	// gt0 is not created yet
+	mockNetlink.On("LinkByName", "gt0").Return(nil, errors.New("link not found")).Once()
+
+	m.applyBridge(br, ifaceCfg)
+	mockNetlink.AssertExpectations(t)
+	mockSys.AssertExpectations(t)
+
+	// It joins once its own interface is applied
+	gt0 := &netlink.Gretap{LinkAttrs: netlink.LinkAttrs{Name: "gt0", Index: 11}}
+	mockNetlink.On("LinkByName", "br0").Return(br, nil).Once()
+	mockNetlink.On("LinkSetMaster", gt0, br).Return(nil).Once()
+	mockNetlink.On("LinkSetUp", gt0).Return(nil).Once()
+	mockNetlink.On("BridgeVlanList", gt0).Return(nil, nil).Once()
+	mockNetlink.On("BridgeVlanAdd", gt0, uint16(1), true, true, false, true).Return(nil).Once()
+	m.joinPendingBridge(gt0)
+	mockNetlink.AssertExpectations(t)
+}
+
+func TestRemoveStaleInterfaces(t *testing.T) {
+	mockNetlink := new(MockNetlinker)
+	m := NewManagerWithDeps(mockNetlink, nil, nil)
+
+	stale := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: "vx-old", Alias: managedLinkAlias}}
+	mockNetlink.On("LinkList").Return([]netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}},
+		&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0", Alias: managedLinkAlias}},
+		stale,
+	}, nil).Once()
+	mockNetlink.On("LinkDel", stale).Return(nil).Once()
+
+	err := m.RemoveStaleInterfaces([]config.Interface{{Name: "eth0"}, {Name: "br0", Bridge: &config.Bridge{}}})
+	assert.NoError(t, err)
+	mockNetlink.AssertExpectations(t)
+}
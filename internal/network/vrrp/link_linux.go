@@ -0,0 +1,355 @@
+//go:build linux
+// +build linux
+
+package vrrp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"grimm.is/glacic/internal/logging"
+
+	"github.com/mdlayher/ndp"
+	"github.com/mdlayher/packet"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const etherTypeARP = 0x0806
+
+// rawLink sends and receives advertisements on a raw IP socket and
+// manages the virtual IPs with netlink.
+type rawLink struct {
+	ifi     *net.Interface
+	vrid    uint8
+	ipv6    bool
+	primary netip.Addr
+	group   netip.Addr
+	logger  *logging.Logger
+
+	conn4 *ipv4.PacketConn
+	conn6 *ipv6.PacketConn
+	raw   net.PacketConn
+
+	rx        chan received
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// openLink joins the VRRP multicast group on iface. Addresses in vips are
+// never used as the advertisement source.
+func openLink(iface string, vrid uint8, vips []netip.Prefix, logger *logging.Logger) (link, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &rawLink{
+		ifi:    ifi,
+		vrid:   vrid,
+		ipv6:   vips[0].Addr().Is6(),
+		logger: logger,
+		rx:     make(chan received, 16),
+		done:   make(chan struct{}),
+	}
+	if l.primary, err = primaryAddr(ifi, l.ipv6, vips); err != nil {
+		return nil, err
+	}
+
+	group := &net.IPAddr{IP: GroupIPv4.AsSlice()}
+	if l.ipv6 {
+		l.group = GroupIPv6
+		group.IP = GroupIPv6.AsSlice()
+		if l.raw, err = net.ListenPacket(fmt.Sprintf("ip6:%d", ProtocolNumber), "::"); err != nil {
+			return nil, fmt.Errorf("failed to open VRRP socket: %w", err)
+		}
+		p := ipv6.NewPacketConn(l.raw)
+		err = errors.Join(
+			p.JoinGroup(ifi, group),
+			p.SetMulticastInterface(ifi),
+			p.SetMulticastHopLimit(255),
+			p.SetMulticastLoopback(false),
+			p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagDst|ipv6.FlagInterface, true),
+		)
+		l.conn6 = p
+	} else {
+		l.group = GroupIPv4
+		if l.raw, err = net.ListenPacket(fmt.Sprintf("ip4:%d", ProtocolNumber), "0.0.0.0"); err != nil {
+			return nil, fmt.Errorf("failed to open VRRP socket: %w", err)
+		}
+		p := ipv4.NewPacketConn(l.raw)
+		err = errors.Join(
+			p.JoinGroup(ifi, group),
+			p.SetMulticastInterface(ifi),
+			p.SetMulticastTTL(255),
+			p.SetMulticastLoopback(false),
+			p.SetControlMessage(ipv4.FlagTTL|ipv4.FlagDst|ipv4.FlagInterface, true),
+		)
+		l.conn4 = p
+	}
+	if err != nil {
+		l.raw.Close()
+		return nil, fmt.Errorf("failed to join VRRP group on %s: %w", iface, err)
+	}
+
+	go l.readLoop()
+	return l, nil
+}
+
+// primaryAddr picks the interface address advertisements are sent from:
+// the first IPv4 address, or the IPv6 link-local address.
+func primaryAddr(ifi *net.Interface, v6 bool, vips []netip.Prefix) (netip.Addr, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipnet.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if isVIP(addr, vips) {
+			continue
+		}
+		if v6 && addr.Is6() && addr.IsLinkLocalUnicast() {
+			return addr, nil
+		}
+		if !v6 && addr.Is4() {
+			return addr, nil
+		}
+	}
+	if v6 {
+		return netip.Addr{}, fmt.Errorf("no IPv6 link-local address on %s", ifi.Name)
+	}
+	return netip.Addr{}, fmt.Errorf("no IPv4 address on %s", ifi.Name)
+}
+
+func isVIP(addr netip.Addr, vips []netip.Prefix) bool {
+	for _, p := range vips {
+		if p.Addr() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// readLoop delivers valid advertisements for this VRID received on the
+// interface. RFC 5798 section 7.1 requires a TTL/hop limit of 255.
+func (l *rawLink) readLoop() {
+	buf := make([]byte, 1500)
+	for {
+		var (
+			n       int
+			src     net.Addr
+			dst     net.IP
+			ttl     int
+			ifIndex int
+			err     error
+		)
+		if l.ipv6 {
+			var cm *ipv6.ControlMessage
+			n, cm, src, err = l.conn6.ReadFrom(buf)
+			if cm != nil {
+				dst, ttl, ifIndex = cm.Dst, cm.HopLimit, cm.IfIndex
+			}
+		} else {
+			var cm *ipv4.ControlMessage
+			n, cm, src, err = l.conn4.ReadFrom(buf)
+			if cm != nil {
+				dst, ttl, ifIndex = cm.Dst, cm.TTL, cm.IfIndex
+			}
+		}
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			l.logger.Warn("VRRP socket read failed", "interface", l.ifi.Name, "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if ifIndex != l.ifi.Index || ttl != 255 {
+			continue
+		}
+
+		ipAddr, ok := src.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		srcAddr, _ := netip.AddrFromSlice(ipAddr.IP)
+		dstAddr, _ := netip.AddrFromSlice(dst)
+		srcAddr, dstAddr = srcAddr.Unmap(), dstAddr.Unmap()
+		if srcAddr == l.primary {
+			continue
+		}
+
+		adv, err := ParseAdvertisement(buf[:n], srcAddr, dstAddr)
+		if err != nil {
+			l.logger.Debug("Dropped VRRP packet", "interface", l.ifi.Name, "src", srcAddr, "error", err)
+			continue
+		}
+		if adv.VRID != l.vrid {
+			continue
+		}
+
+		select {
+		case l.rx <- received{adv: adv, src: srcAddr}:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *rawLink) Send(adv *Advertisement) error {
+	b, err := adv.Marshal(l.primary, l.group)
+	if err != nil {
+		return err
+	}
+	dst := &net.IPAddr{IP: l.group.AsSlice()}
+	if l.ipv6 {
+		dst.Zone = l.ifi.Name
+		_, err = l.conn6.WriteTo(b, &ipv6.ControlMessage{Src: l.primary.AsSlice(), IfIndex: l.ifi.Index}, dst)
+	} else {
+		_, err = l.conn4.WriteTo(b, &ipv4.ControlMessage{Src: l.primary.AsSlice(), IfIndex: l.ifi.Index}, dst)
+	}
+	return err
+}
+
+func (l *rawLink) Receive() <-chan received {
+	return l.rx
+}
+
+func (l *rawLink) AddVIPs(vips []netip.Prefix) error {
+	nl, err := netlink.LinkByIndex(l.ifi.Index)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range vips {
+		addr := vipAddr(p)
+		if err := netlink.AddrAdd(nl, addr); err != nil && !errors.Is(err, syscall.EEXIST) {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *rawLink) RemoveVIPs(vips []netip.Prefix) error {
+	nl, err := netlink.LinkByIndex(l.ifi.Index)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range vips {
+		if err := netlink.AddrDel(nl, vipAddr(p)); err != nil && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// vipAddr converts a virtual IP to a netlink address. IPv6 VIPs skip DAD
+// so they are usable immediately after takeover.
+func vipAddr(p netip.Prefix) *netlink.Addr {
+	addr := &netlink.Addr{IPNet: &net.IPNet{
+		IP:   p.Addr().AsSlice(),
+		Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}}
+	if p.Addr().Is6() {
+		addr.Flags = unix.IFA_F_NODAD
+	}
+	return addr
+}
+
+// Announce sends a gratuitous ARP request (IPv4) or an unsolicited
+// neighbor advertisement with the override flag (IPv6) for each virtual
+// IP, so hosts and switches learn the new master's MAC address.
+func (l *rawLink) Announce(vips []netip.Prefix) error {
+	if len(l.ifi.HardwareAddr) != 6 {
+		return nil
+	}
+	if l.ipv6 {
+		conn, _, err := ndp.Listen(l.ifi, ndp.LinkLocal)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		var errs []error
+		for _, p := range vips {
+			na := &ndp.NeighborAdvertisement{
+				Override:      true,
+				TargetAddress: p.Addr(),
+				Options: []ndp.Option{&ndp.LinkLayerAddress{
+					Direction: ndp.Target,
+					Addr:      l.ifi.HardwareAddr,
+				}},
+			}
+			errs = append(errs, conn.WriteTo(na, nil, netip.IPv6LinkLocalAllNodes()))
+		}
+		return errors.Join(errs...)
+	}
+
+	conn, err := packet.Listen(l.ifi, packet.Raw, etherTypeARP, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	var errs []error
+	for _, p := range vips {
+		frame := gratuitousARP(l.ifi.HardwareAddr, p.Addr())
+		_, err := conn.WriteTo(frame, &packet.Addr{HardwareAddr: broadcast})
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// gratuitousARP builds a broadcast ARP request for ip from mac.
+func gratuitousARP(mac net.HardwareAddr, ip netip.Addr) []byte {
+	b := make([]byte, 14+28)
+	copy(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], etherTypeARP)
+
+	arp := b[14:]
+	binary.BigEndian.PutUint16(arp[0:2], 1)      // Ethernet
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800) // IPv4
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:8], 1) // Request
+	copy(arp[8:14], mac)
+	ip4 := ip.As4()
+	copy(arp[14:18], ip4[:])
+	// Target hardware address stays zero
+	copy(arp[24:28], ip4[:])
+	return b
+}
+
+func (l *rawLink) PrimaryIP() netip.Addr {
+	return l.primary
+}
+
+func (l *rawLink) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.raw.Close()
+	})
+	return err
+}
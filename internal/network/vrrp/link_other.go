@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package vrrp
+
+import (
+	"errors"
+	"net/netip"
+
+	"grimm.is/glacic/internal/logging"
+)
+
+// openLink is not supported on non-Linux platforms.
+func openLink(iface string, vrid uint8, vips []netip.Prefix, logger *logging.Logger) (link, error) {
+	return nil, errors.New("VRRP is only supported on Linux")
+}
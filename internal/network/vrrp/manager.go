@@ -0,0 +1,125 @@
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// Manager runs the configured VRRP instances.
+type Manager struct {
+	instances []*Instance
+	logger    *logging.Logger
+	openLink  func(iface string, vrid uint8, vips []netip.Prefix, logger *logging.Logger) (link, error)
+
+	events       chan Transition
+	onTransition func(Transition)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewManager validates the instance configs. Call Start to join the
+// virtual routers.
+func NewManager(cfgs []config.VRRPInstance, logger *logging.Logger) (*Manager, error) {
+	m := &Manager{
+		logger:   logger,
+		openLink: openLink,
+		events:   make(chan Transition, 16),
+	}
+	for _, cfg := range cfgs {
+		inst, err := newInstance(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		m.instances = append(m.instances, inst)
+	}
+	return m, nil
+}
+
+// OnTransition registers a callback for state and master changes. The
+// callback runs on a single goroutine, in order, and may block without
+// delaying advertisements. It must be set before Start.
+func (m *Manager) OnTransition(fn func(Transition)) {
+	m.onTransition = fn
+}
+
+// Start opens the instance links and starts the state machines. All
+// instances start as backup and become master only if no better router
+// advertises within Master_Down_Interval.
+func (m *Manager) Start() error {
+	for _, inst := range m.instances {
+		l, err := m.openLink(inst.iface, inst.vrid, inst.vips, m.logger)
+		if err != nil {
+			for _, opened := range m.instances {
+				if opened.link != nil {
+					opened.link.Close()
+					opened.link = nil
+				}
+			}
+			return fmt.Errorf("vrrp %s/%d: %w", inst.iface, inst.vrid, err)
+		}
+		inst.link = l
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-m.events:
+				if m.onTransition != nil {
+					m.onTransition(t)
+				}
+			}
+		}
+	}()
+
+	for _, inst := range m.instances {
+		inst.onTransition = func(t Transition) {
+			select {
+			case m.events <- t:
+			case <-ctx.Done():
+			}
+		}
+		m.wg.Add(1)
+		go func(inst *Instance) {
+			defer m.wg.Done()
+			inst.run(ctx)
+		}(inst)
+		m.logger.Info("VRRP instance started", "interface", inst.iface, "vrid", inst.vrid, "priority", inst.priority)
+	}
+	return nil
+}
+
+// Stop resigns mastership, releases the virtual IPs and closes the links.
+func (m *Manager) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	m.wg.Wait()
+	for _, inst := range m.instances {
+		if inst.link != nil {
+			inst.link.Close()
+		}
+	}
+}
+
+// Status returns the state of every instance in config order.
+func (m *Manager) Status() []InstanceStatus {
+	status := make([]InstanceStatus, 0, len(m.instances))
+	for _, inst := range m.instances {
+		status = append(status, inst.Status())
+	}
+	return status
+}
@@ -0,0 +1,131 @@
+package vrrp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+const (
+	// ProtocolNumber is the IP protocol number assigned to VRRP.
+	ProtocolNumber = 112
+
+	vrrpVersion       = 3
+	typeAdvertisement = 1
+	headerLen         = 8
+
+	// maxInterval is the largest advertisement interval the 12-bit
+	// centisecond field can carry.
+	maxInterval = 4095 * 10 * time.Millisecond
+)
+
+// Multicast groups advertisements are sent to (RFC 5798 section 5.1.1.2).
+var (
+	GroupIPv4 = netip.MustParseAddr("224.0.0.18")
+	GroupIPv6 = netip.MustParseAddr("ff02::12")
+)
+
+// Advertisement is a VRRPv3 advertisement (RFC 5798 section 5.2).
+type Advertisement struct {
+	VRID      uint8
+	Priority  uint8         // 0 means the master is stepping down
+	Interval  time.Duration // Max advertisement interval, centisecond resolution
+	Addresses []netip.Addr  // Virtual IPs, all of one family
+}
+
+// Marshal encodes the advertisement. src and dst are the IP header
+// addresses, used for the checksum pseudo-header.
+func (a *Advertisement) Marshal(src, dst netip.Addr) ([]byte, error) {
+	if len(a.Addresses) == 0 || len(a.Addresses) > 255 {
+		return nil, fmt.Errorf("advertisement needs 1-255 addresses, got %d", len(a.Addresses))
+	}
+	if a.Interval <= 0 || a.Interval > maxInterval {
+		return nil, fmt.Errorf("advertisement interval %s out of range", a.Interval)
+	}
+
+	n := addrLen(a.Addresses[0])
+	b := make([]byte, headerLen, headerLen+len(a.Addresses)*n)
+	b[0] = vrrpVersion<<4 | typeAdvertisement
+	b[1] = a.VRID
+	b[2] = a.Priority
+	b[3] = uint8(len(a.Addresses))
+	binary.BigEndian.PutUint16(b[4:6], uint16(a.Interval/(10*time.Millisecond))&0x0fff)
+	for _, addr := range a.Addresses {
+		if addrLen(addr) != n {
+			return nil, errors.New("advertisement mixes IPv4 and IPv6 addresses")
+		}
+		b = append(b, addr.AsSlice()...)
+	}
+
+	binary.BigEndian.PutUint16(b[6:8], checksum(b, src, dst))
+	return b, nil
+}
+
+// ParseAdvertisement decodes and verifies an advertisement received from
+// src on dst. The address family of src decides the address length.
+func ParseAdvertisement(b []byte, src, dst netip.Addr) (*Advertisement, error) {
+	if len(b) < headerLen {
+		return nil, errors.New("advertisement too short")
+	}
+	if v := b[0] >> 4; v != vrrpVersion {
+		return nil, fmt.Errorf("unsupported VRRP version %d", v)
+	}
+	if t := b[0] & 0x0f; t != typeAdvertisement {
+		return nil, fmt.Errorf("unknown VRRP packet type %d", t)
+	}
+
+	count := int(b[3])
+	n := addrLen(src)
+	if len(b) < headerLen+count*n {
+		return nil, errors.New("advertisement truncated")
+	}
+	b = b[:headerLen+count*n]
+	if checksum(b, src, dst) != 0 {
+		return nil, errors.New("bad advertisement checksum")
+	}
+
+	adv := &Advertisement{
+		VRID:     b[1],
+		Priority: b[2],
+		Interval: time.Duration(binary.BigEndian.Uint16(b[4:6])&0x0fff) * 10 * time.Millisecond,
+	}
+	for off := headerLen; off < len(b); off += n {
+		addr, _ := netip.AddrFromSlice(b[off : off+n])
+		adv.Addresses = append(adv.Addresses, addr)
+	}
+	return adv, nil
+}
+
+func addrLen(addr netip.Addr) int {
+	if addr.Is4() {
+		return 4
+	}
+	return 16
+}
+
+// checksum computes the Internet checksum of b over the IPv4 or IPv6
+// pseudo-header. Computing it over a packet with a valid checksum
+// yields zero.
+func checksum(b []byte, src, dst netip.Addr) uint16 {
+	var sum uint32
+	add := func(p []byte) {
+		for i := 0; i+1 < len(p); i += 2 {
+			sum += uint32(p[i])<<8 | uint32(p[i+1])
+		}
+		if len(p)%2 == 1 {
+			sum += uint32(p[len(p)-1]) << 8
+		}
+	}
+
+	add(src.AsSlice())
+	add(dst.AsSlice())
+	sum += ProtocolNumber + uint32(len(b))
+	add(b)
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,93 @@
+package vrrp
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAdvertisementRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst netip.Addr
+		addrs    []netip.Addr
+	}{
+		{
+			name:  "ipv4",
+			src:   netip.MustParseAddr("192.168.1.2"),
+			dst:   GroupIPv4,
+			addrs: []netip.Addr{netip.MustParseAddr("192.168.1.254"), netip.MustParseAddr("192.168.1.253")},
+		},
+		{
+			name:  "ipv6",
+			src:   netip.MustParseAddr("fe80::1"),
+			dst:   GroupIPv6,
+			addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adv := &Advertisement{VRID: 42, Priority: 150, Interval: 1500 * time.Millisecond, Addresses: tt.addrs}
+			b, err := adv.Marshal(tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ParseAdvertisement(b, tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.VRID != 42 || got.Priority != 150 || got.Interval != 1500*time.Millisecond || len(got.Addresses) != len(tt.addrs) {
+				t.Fatalf("unexpected advertisement: %+v", got)
+			}
+			for i := range tt.addrs {
+				if got.Addresses[i] != tt.addrs[i] {
+					t.Errorf("address %d: got %s, want %s", i, got.Addresses[i], tt.addrs[i])
+				}
+			}
+
+			// The pseudo-header covers the source address
+			if _, err := ParseAdvertisement(b, tt.dst, tt.dst); err == nil {
+				t.Error("expected checksum failure for a spoofed source")
+			}
+		})
+	}
+}
+
+func TestParseAdvertisement_Invalid(t *testing.T) {
+	src := netip.MustParseAddr("10.0.0.1")
+	adv := &Advertisement{VRID: 1, Priority: 100, Interval: time.Second, Addresses: []netip.Addr{netip.MustParseAddr("10.0.0.254")}}
+	b, err := adv.Marshal(src, GroupIPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := append([]byte(nil), b...)
+	v2[0] = 2<<4 | typeAdvertisement
+
+	tests := map[string][]byte{
+		"short":     b[:4],
+		"truncated": b[:len(b)-1],
+		"version 2": v2,
+	}
+	for name, pkt := range tests {
+		if _, err := ParseAdvertisement(pkt, src, GroupIPv4); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestAdvertisementMarshal_Invalid(t *testing.T) {
+	src := netip.MustParseAddr("10.0.0.1")
+	tests := map[string]*Advertisement{
+		"no addresses":   {VRID: 1, Interval: time.Second},
+		"mixed families": {VRID: 1, Interval: time.Second, Addresses: []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("::1")}},
+		"long interval":  {VRID: 1, Interval: time.Minute, Addresses: []netip.Addr{netip.MustParseAddr("10.0.0.2")}},
+	}
+	for name, adv := range tests {
+		if _, err := adv.Marshal(src, GroupIPv4); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package vrrp implements VRRPv3 (RFC 5798) virtual IP failover.
+//
+// Each [Instance] runs the backup/master state machine for one virtual
+// router on one interface. The master holds the virtual IPs, sends
+// periodic advertisements and announces takeover with gratuitous ARP or
+// unsolicited neighbor advertisements. The [Manager] runs the configured
+// instances and reports role changes so replication can follow the master.
+package vrrp
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// State is the state of a virtual router.
+type State string
+
+const (
+	StateInit   State = "init"
+	StateBackup State = "backup"
+	StateMaster State = "master"
+)
+
+const (
+	defaultPriority = 100
+	defaultInterval = time.Second
+)
+
+// Transition reports a state or master change of an instance.
+type Transition struct {
+	Interface string
+	VRID      int
+	State     State
+	MasterIP  netip.Addr // Current master; invalid while unknown
+}
+
+// InstanceStatus describes a virtual router for status output.
+type InstanceStatus struct {
+	Interface      string    `json:"interface"`
+	VRID           int       `json:"vrid"`
+	State          State     `json:"state"`
+	Priority       int       `json:"priority"`
+	VirtualIPs     []string  `json:"virtual_ips"`
+	MasterIP       string    `json:"master_ip,omitempty"`
+	Transitions    int       `json:"transitions"`
+	LastTransition time.Time `json:"last_transition,omitempty"`
+}
+
+// received is an advertisement read from the link.
+type received struct {
+	adv *Advertisement
+	src netip.Addr
+}
+
+// link is the network side of an instance. The Linux implementation uses
+// raw IP sockets and netlink; tests substitute an in-memory bus.
+type link interface {
+	// Send multicasts an advertisement.
+	Send(adv *Advertisement) error
+	// Receive delivers advertisements for this VRID from other routers.
+	Receive() <-chan received
+	// AddVIPs and RemoveVIPs assign the virtual IPs to the interface.
+	AddVIPs(vips []netip.Prefix) error
+	RemoveVIPs(vips []netip.Prefix) error
+	// Announce sends gratuitous ARP or unsolicited NA for the virtual IPs.
+	Announce(vips []netip.Prefix) error
+	// PrimaryIP is the interface address advertisements are sent from.
+	PrimaryIP() netip.Addr
+	Close() error
+}
+
+// Instance runs one virtual router.
+type Instance struct {
+	iface    string
+	vrid     uint8
+	priority uint8
+	interval time.Duration
+	preempt  bool
+	vips     []netip.Prefix
+	ipv6     bool
+
+	link         link
+	logger       *logging.Logger
+	onTransition func(Transition)
+
+	mu             sync.RWMutex
+	state          State
+	masterIP       netip.Addr
+	masterInterval time.Duration // Master_Adver_Interval learned from the master
+	transitions    int
+	lastTransition time.Time
+}
+
+// newInstance validates and converts an instance config.
+func newInstance(cfg config.VRRPInstance, logger *logging.Logger) (*Instance, error) {
+	if cfg.VRID < 1 || cfg.VRID > 255 {
+		return nil, fmt.Errorf("vrrp %s: vrid %d out of range", cfg.Interface, cfg.VRID)
+	}
+	inst := &Instance{
+		iface:    cfg.Interface,
+		vrid:     uint8(cfg.VRID),
+		priority: defaultPriority,
+		interval: defaultInterval,
+		preempt:  true,
+		logger:   logger,
+		state:    StateInit,
+	}
+	if cfg.Priority != 0 {
+		if cfg.Priority < 1 || cfg.Priority > 254 {
+			return nil, fmt.Errorf("vrrp %s/%d: priority %d out of range", cfg.Interface, cfg.VRID, cfg.Priority)
+		}
+		inst.priority = uint8(cfg.Priority)
+	}
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil || d < 10*time.Millisecond || d > maxInterval {
+			return nil, fmt.Errorf("vrrp %s/%d: invalid advert_interval %q", cfg.Interface, cfg.VRID, cfg.Interval)
+		}
+		inst.interval = d
+	}
+	if cfg.Preempt != nil {
+		inst.preempt = *cfg.Preempt
+	}
+
+	for _, s := range cfg.VirtualIPs {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("vrrp %s/%d: invalid virtual IP %q", cfg.Interface, cfg.VRID, s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		inst.vips = append(inst.vips, prefix)
+	}
+	if len(inst.vips) == 0 {
+		return nil, fmt.Errorf("vrrp %s/%d: no virtual IPs", cfg.Interface, cfg.VRID)
+	}
+	inst.ipv6 = inst.vips[0].Addr().Is6()
+	for _, p := range inst.vips {
+		if p.Addr().Is6() != inst.ipv6 {
+			return nil, fmt.Errorf("vrrp %s/%d: virtual IPs mix IPv4 and IPv6", cfg.Interface, cfg.VRID)
+		}
+	}
+	inst.masterInterval = inst.interval
+	return inst, nil
+}
+
+// skewTime is Skew_Time from RFC 5798 section 6.1.
+func (i *Instance) skewTime() time.Duration {
+	return time.Duration(256-int(i.priority)) * i.masterInterval / 256
+}
+
+// masterDownInterval is Master_Down_Interval from RFC 5798 section 6.1.
+func (i *Instance) masterDownInterval() time.Duration {
+	return 3*i.masterInterval + i.skewTime()
+}
+
+func (i *Instance) advertisement(priority uint8) *Advertisement {
+	adv := &Advertisement{VRID: i.vrid, Priority: priority, Interval: i.interval}
+	for _, p := range i.vips {
+		adv.Addresses = append(adv.Addresses, p.Addr())
+	}
+	return adv
+}
+
+// run executes the state machine until ctx is cancelled. A master steps
+// down on exit by sending a priority 0 advertisement and releasing the
+// virtual IPs, so a backup takes over after Skew_Time.
+func (i *Instance) run(ctx context.Context) {
+	i.transition(StateBackup, netip.Addr{})
+	timer := time.NewTimer(i.masterDownInterval())
+	defer timer.Stop()
+
+	reset := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if i.State() == StateMaster {
+				if err := i.link.Send(i.advertisement(0)); err != nil {
+					i.logger.Warn("Failed to send shutdown advertisement", "interface", i.iface, "vrid", i.vrid, "error", err)
+				}
+				i.releaseVIPs()
+			}
+			return
+
+		case <-timer.C:
+			if i.State() == StateBackup {
+				i.becomeMaster()
+			} else {
+				// Re-assert the VIPs in case an interface reapply
+				// flushed them; existing addresses are left alone
+				if err := i.link.AddVIPs(i.vips); err != nil {
+					i.logger.Warn("Failed to add virtual IPs", "interface", i.iface, "vrid", i.vrid, "error", err)
+				}
+				i.send()
+			}
+			reset(i.interval)
+
+		case rx := <-i.link.Receive():
+			if rx.adv.VRID != i.vrid {
+				continue
+			}
+			switch i.State() {
+			case StateBackup:
+				if rx.adv.Priority == 0 {
+					// Master is stepping down; take over after Skew_Time
+					reset(i.skewTime())
+					continue
+				}
+				if !i.preempt || rx.adv.Priority >= i.priority {
+					i.mu.Lock()
+					if rx.adv.Interval > 0 {
+						i.masterInterval = rx.adv.Interval
+					}
+					i.mu.Unlock()
+					reset(i.masterDownInterval())
+					if rx.src != i.MasterIP() {
+						i.transition(StateBackup, rx.src)
+					}
+				}
+				// Lower priority with preemption: ignore and let the
+				// Master_Down timer fire
+
+			case StateMaster:
+				if rx.adv.Priority == 0 {
+					// Another master left; assert ourselves immediately
+					i.send()
+					reset(i.interval)
+					continue
+				}
+				if rx.adv.Priority > i.priority ||
+					(rx.adv.Priority == i.priority && rx.src.Compare(i.link.PrimaryIP()) > 0) {
+					i.mu.Lock()
+					if rx.adv.Interval > 0 {
+						i.masterInterval = rx.adv.Interval
+					}
+					i.mu.Unlock()
+					i.releaseVIPs()
+					i.transition(StateBackup, rx.src)
+					reset(i.masterDownInterval())
+				}
+			}
+		}
+	}
+}
+
+// becomeMaster claims the virtual IPs and announces them.
+func (i *Instance) becomeMaster() {
+	if err := i.link.AddVIPs(i.vips); err != nil {
+		i.logger.Warn("Failed to add virtual IPs", "interface", i.iface, "vrid", i.vrid, "error", err)
+	}
+	i.send()
+	if err := i.link.Announce(i.vips); err != nil {
+		i.logger.Warn("Failed to announce virtual IPs", "interface", i.iface, "vrid", i.vrid, "error", err)
+	}
+	i.transition(StateMaster, i.link.PrimaryIP())
+}
+
+func (i *Instance) releaseVIPs() {
+	if err := i.link.RemoveVIPs(i.vips); err != nil {
+		i.logger.Warn("Failed to remove virtual IPs", "interface", i.iface, "vrid", i.vrid, "error", err)
+	}
+}
+
+func (i *Instance) send() {
+	if err := i.link.Send(i.advertisement(i.priority)); err != nil {
+		i.logger.Warn("Failed to send advertisement", "interface", i.iface, "vrid", i.vrid, "error", err)
+	}
+}
+
+// transition records a state or master change and notifies the callback.
+func (i *Instance) transition(state State, master netip.Addr) {
+	i.mu.Lock()
+	changed := i.state != state
+	i.state = state
+	i.masterIP = master
+	if changed {
+		i.transitions++
+		i.lastTransition = clock.Now()
+	}
+	i.mu.Unlock()
+
+	if changed {
+		args := []any{"interface", i.iface, "vrid", i.vrid, "state", state}
+		if master.IsValid() {
+			args = append(args, "master", master)
+		}
+		i.logger.Info("VRRP state change", args...)
+	}
+	if i.onTransition != nil {
+		i.onTransition(Transition{Interface: i.iface, VRID: int(i.vrid), State: state, MasterIP: master})
+	}
+}
+
+// State returns the current state.
+func (i *Instance) State() State {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.state
+}
+
+// MasterIP returns the address of the current master, if known.
+func (i *Instance) MasterIP() netip.Addr {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.masterIP
+}
+
+// Status returns a snapshot of the instance.
+func (i *Instance) Status() InstanceStatus {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	st := InstanceStatus{
+		Interface:      i.iface,
+		VRID:           int(i.vrid),
+		State:          i.state,
+		Priority:       int(i.priority),
+		Transitions:    i.transitions,
+		LastTransition: i.lastTransition,
+	}
+	if i.masterIP.IsValid() {
+		st.MasterIP = i.masterIP.String()
+	}
+	for _, p := range i.vips {
+		st.VirtualIPs = append(st.VirtualIPs, p.String())
+	}
+	return st
+}
@@ -0,0 +1,290 @@
+package vrrp
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// fakeBus relays advertisements between fake links like a shared segment.
+type fakeBus struct {
+	mu    sync.Mutex
+	links []*fakeLink
+}
+
+type fakeLink struct {
+	bus  *fakeBus
+	ip   netip.Addr
+	rx   chan received
+	done chan struct{}
+
+	mu        sync.Mutex
+	down      bool // Simulates a crashed node: nothing in or out
+	vips      map[netip.Prefix]bool
+	announced int
+}
+
+func (b *fakeBus) opener(ip string) func(string, uint8, []netip.Prefix, *logging.Logger) (link, error) {
+	return func(string, uint8, []netip.Prefix, *logging.Logger) (link, error) {
+		l := &fakeLink{
+			bus:  b,
+			ip:   netip.MustParseAddr(ip),
+			rx:   make(chan received, 16),
+			done: make(chan struct{}),
+			vips: make(map[netip.Prefix]bool),
+		}
+		b.mu.Lock()
+		b.links = append(b.links, l)
+		b.mu.Unlock()
+		return l, nil
+	}
+}
+
+func (l *fakeLink) isDown() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.down
+}
+
+func (l *fakeLink) setDown(down bool) {
+	l.mu.Lock()
+	l.down = down
+	l.mu.Unlock()
+}
+
+func (l *fakeLink) Send(adv *Advertisement) error {
+	if l.isDown() {
+		return nil
+	}
+	l.bus.mu.Lock()
+	peers := append([]*fakeLink(nil), l.bus.links...)
+	l.bus.mu.Unlock()
+	for _, peer := range peers {
+		if peer == l || peer.isDown() {
+			continue
+		}
+		select {
+		case peer.rx <- received{adv: adv, src: l.ip}:
+		case <-peer.done:
+		}
+	}
+	return nil
+}
+
+func (l *fakeLink) Receive() <-chan received { return l.rx }
+
+func (l *fakeLink) AddVIPs(vips []netip.Prefix) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range vips {
+		l.vips[p] = true
+	}
+	return nil
+}
+
+func (l *fakeLink) RemoveVIPs(vips []netip.Prefix) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range vips {
+		delete(l.vips, p)
+	}
+	return nil
+}
+
+func (l *fakeLink) Announce(vips []netip.Prefix) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.announced++
+	return nil
+}
+
+func (l *fakeLink) holdsVIP() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.vips) > 0
+}
+
+func (l *fakeLink) announcements() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.announced
+}
+
+func (l *fakeLink) PrimaryIP() netip.Addr { return l.ip }
+
+func (l *fakeLink) Close() error {
+	l.bus.mu.Lock()
+	defer l.bus.mu.Unlock()
+	for i, peer := range l.bus.links {
+		if peer == l {
+			l.bus.links = append(l.bus.links[:i], l.bus.links[i+1:]...)
+			close(l.done)
+			break
+		}
+	}
+	return nil
+}
+
+type testNode struct {
+	mgr         *Manager
+	link        *fakeLink
+	mu          sync.Mutex
+	transitions []Transition
+}
+
+func startNode(t *testing.T, bus *fakeBus, ip string, priority int, preempt bool) *testNode {
+	t.Helper()
+	mgr, err := NewManager([]config.VRRPInstance{{
+		Interface:  "eth0",
+		VRID:       7,
+		Priority:   priority,
+		VirtualIPs: []string{"10.0.0.254/24"},
+		Interval:   "20ms",
+		Preempt:    &preempt,
+	}}, logging.New(logging.DefaultConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.openLink = bus.opener(ip)
+
+	n := &testNode{mgr: mgr}
+	mgr.OnTransition(func(tr Transition) {
+		n.mu.Lock()
+		n.transitions = append(n.transitions, tr)
+		n.mu.Unlock()
+	})
+	if err := mgr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	n.link = mgr.instances[0].link.(*fakeLink)
+	t.Cleanup(mgr.Stop)
+	return n
+}
+
+func (n *testNode) state() State {
+	return n.mgr.Status()[0].State
+}
+
+func (n *testNode) lastTransition() Transition {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.transitions) == 0 {
+		return Transition{}
+	}
+	return n.transitions[len(n.transitions)-1]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElection_HighestPriorityWins(t *testing.T) {
+	bus := &fakeBus{}
+	a := startNode(t, bus, "10.0.0.1", 200, true)
+	b := startNode(t, bus, "10.0.0.2", 100, true)
+
+	waitFor(t, "a to become master", func() bool { return a.state() == StateMaster })
+	waitFor(t, "b to learn the master", func() bool { return b.lastTransition().MasterIP == a.link.ip })
+
+	if b.state() != StateBackup {
+		t.Errorf("b: expected backup, got %s", b.state())
+	}
+	if !a.link.holdsVIP() || b.link.holdsVIP() {
+		t.Error("only the master should hold the virtual IP")
+	}
+	if a.link.announcements() == 0 {
+		t.Error("master did not announce the virtual IP")
+	}
+	if tr := a.lastTransition(); tr.State != StateMaster || tr.VRID != 7 || tr.Interface != "eth0" {
+		t.Errorf("unexpected transition: %+v", tr)
+	}
+}
+
+func TestElection_EqualPriorityHigherAddressWins(t *testing.T) {
+	bus := &fakeBus{}
+	a := startNode(t, bus, "10.0.0.1", 100, true)
+	b := startNode(t, bus, "10.0.0.2", 100, true)
+
+	waitFor(t, "b to become master", func() bool { return b.state() == StateMaster })
+	waitFor(t, "a to back off", func() bool { return a.state() == StateBackup && a.lastTransition().MasterIP == b.link.ip })
+}
+
+func TestFailover_GracefulShutdown(t *testing.T) {
+	bus := &fakeBus{}
+	a := startNode(t, bus, "10.0.0.1", 200, true)
+	b := startNode(t, bus, "10.0.0.2", 100, true)
+	waitFor(t, "a to become master", func() bool { return a.state() == StateMaster })
+
+	a.mgr.Stop()
+	if a.link.holdsVIP() {
+		t.Error("stopped master kept the virtual IP")
+	}
+	waitFor(t, "b to take over", func() bool { return b.state() == StateMaster })
+	if !b.link.holdsVIP() || b.link.announcements() == 0 {
+		t.Error("new master did not claim and announce the virtual IP")
+	}
+}
+
+func TestFailover_CrashAndPreempt(t *testing.T) {
+	bus := &fakeBus{}
+	a := startNode(t, bus, "10.0.0.1", 200, true)
+	b := startNode(t, bus, "10.0.0.2", 100, true)
+	waitFor(t, "a to become master", func() bool { return a.state() == StateMaster })
+
+	// Master stops advertising without stepping down
+	a.link.setDown(true)
+	waitFor(t, "b to take over", func() bool { return b.state() == StateMaster })
+
+	// Higher priority router returns and preempts
+	a.link.setDown(false)
+	waitFor(t, "b to yield", func() bool { return b.state() == StateBackup })
+	if a.state() != StateMaster {
+		t.Errorf("a: expected master, got %s", a.state())
+	}
+	if b.link.holdsVIP() {
+		t.Error("backup kept the virtual IP")
+	}
+	if got := b.mgr.Status()[0].Transitions; got < 3 {
+		t.Errorf("b: expected at least 3 transitions, got %d", got)
+	}
+}
+
+func TestPreemptDisabled(t *testing.T) {
+	bus := &fakeBus{}
+	b := startNode(t, bus, "10.0.0.2", 100, true)
+	waitFor(t, "b to become master", func() bool { return b.state() == StateMaster })
+
+	a := startNode(t, bus, "10.0.0.1", 200, false)
+	waitFor(t, "a to learn the master", func() bool { return a.lastTransition().MasterIP == b.link.ip })
+	time.Sleep(200 * time.Millisecond)
+	if a.state() != StateBackup || b.state() != StateMaster {
+		t.Errorf("expected the existing master to stay, got a=%s b=%s", a.state(), b.state())
+	}
+}
+
+func TestNewManager_InvalidConfig(t *testing.T) {
+	tests := map[string]config.VRRPInstance{
+		"vrid":     {Interface: "eth0", VRID: 0, VirtualIPs: []string{"10.0.0.1"}},
+		"priority": {Interface: "eth0", VRID: 1, Priority: 255, VirtualIPs: []string{"10.0.0.1"}},
+		"interval": {Interface: "eth0", VRID: 1, Interval: "1m", VirtualIPs: []string{"10.0.0.1"}},
+		"no vips":  {Interface: "eth0", VRID: 1},
+		"bad vip":  {Interface: "eth0", VRID: 1, VirtualIPs: []string{"nope"}},
+		"mixed":    {Interface: "eth0", VRID: 1, VirtualIPs: []string{"10.0.0.1", "2001:db8::1"}},
+	}
+	for name, cfg := range tests {
+		if _, err := NewManager([]config.VRRPInstance{cfg}, logging.New(logging.DefaultConfig())); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
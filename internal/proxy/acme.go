@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"grimm.is/glacic/internal/acme"
+)
+
+// handshakeTimeout bounds the TLS handshake done before proxying when ACME
+// is enabled.
+const handshakeTimeout = 10 * time.Second
+
+// Update carries ACME state from the control plane into the proxy, which
+// runs unprivileged and cannot read the certificate store itself.
+type Update struct {
+	// Certificates by interface name; "" replaces the default certificate.
+	Certificates map[string]acme.KeyPair `json:"certificates,omitempty"`
+	// Challenges are the pending HTTP-01 and TLS-ALPN-01 responses.
+	Challenges acme.ChallengeSnapshot `json:"challenges"`
+}
+
+// EnableACME makes the proxy answer ACME challenges and accept updates.
+// It must be called before Start.
+func (s *Server) EnableACME() {
+	s.challenges = acme.NewChallenges()
+	if s.tlsConfig != nil {
+		s.tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	}
+}
+
+// WithHTTP adds a plain HTTP listener answering HTTP-01 challenges. Other
+// requests are redirected to HTTPS when redirect is set.
+func (s *Server) WithHTTP(addr string, redirect bool) {
+	s.httpAddr = addr
+	s.redirect = redirect
+}
+
+// ApplyUpdate swaps in the certificates and challenge responses of an
+// update. Certificates missing from the update revert to the file
+// certificate.
+func (s *Server) ApplyUpdate(u Update) error {
+	if s.challenges == nil {
+		return errors.New("ACME is not enabled")
+	}
+
+	var errs []error
+	seen := make(map[string]bool, len(u.Certificates))
+	for name, pair := range u.Certificates {
+		cert, err := pair.X509KeyPair()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("certificate %q: %w", name, err))
+			continue
+		}
+		if name == "" {
+			s.certs.SetDefaultCertificate(cert)
+		} else {
+			s.certs.SetCertificate(name, cert)
+		}
+		seen[name] = true
+	}
+	for name := range s.acmeCerts {
+		if seen[name] {
+			continue
+		}
+		if name == "" {
+			s.certs.SetDefaultCertificate(s.fileCert)
+		} else {
+			s.certs.SetCertificate(name, nil)
+		}
+	}
+	s.acmeCerts = seen
+
+	if err := s.challenges.Restore(u.Challenges); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ReadUpdates applies a stream of JSON encoded updates until r is closed.
+func (s *Server) ReadUpdates(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var u Update
+		if err := dec.Decode(&u); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode ACME update: %w", err)
+		}
+		if err := s.ApplyUpdate(u); err != nil {
+			s.logger.Error("Failed to apply ACME update", "error", err)
+			continue
+		}
+		s.logger.Info("Applied ACME update", "certificates", len(u.Certificates))
+	}
+}
+
+// startHTTP binds the HTTP-01 listener.
+func (s *Server) startHTTP(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.httpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to bind HTTP listener: %w", err)
+	}
+
+	var next http.Handler = http.NotFoundHandler()
+	if s.redirect && s.tlsConfig != nil {
+		next = s.redirectHandler()
+	}
+	if s.challenges != nil {
+		next = s.challenges.HTTPHandler(next)
+	}
+	s.httpServer = &http.Server{
+		Handler:           next,
+		ReadHeaderTimeout: handshakeTimeout,
+	}
+	s.logger.Info("HTTP listener started", "addr", s.httpAddr, "redirect", s.redirect)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP listener failed", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		s.httpServer.Close()
+	}()
+	return nil
+}
+
+// redirectHandler sends clients to the same host on the TLS listener.
+func (s *Server) redirectHandler() http.Handler {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grimm.is/glacic/internal/acme"
+	gtls "grimm.is/glacic/internal/tls"
+)
+
+func newKeyPair(t *testing.T, dir, name string) acme.KeyPair {
+	t.Helper()
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := gtls.GenerateSelfSigned(certFile, keyFile, 1); err != nil {
+		t.Fatalf("GenerateSelfSigned failed: %v", err)
+	}
+	cert, _ := os.ReadFile(certFile)
+	key, _ := os.ReadFile(keyFile)
+	return acme.KeyPair{Cert: cert, Key: key}
+}
+
+func leaf(t *testing.T, pair acme.KeyPair) []byte {
+	t.Helper()
+	cert, err := pair.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestProxyACME(t *testing.T) {
+	dir := t.TempDir()
+
+	// Upstream API on a Unix socket
+	sock := filepath.Join(dir, "api.sock")
+	upstream, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "api")
+	})}
+	go api.Serve(upstream)
+	t.Cleanup(func() { api.Close() })
+
+	filePair := newKeyPair(t, dir, "file")
+	acmePair := newKeyPair(t, dir, "acme")
+	challengePair := newKeyPair(t, dir, "challenge")
+
+	s := NewServer("127.0.0.1:0", sock)
+	if err := s.WithTLS(filepath.Join(dir, "file.crt"), filepath.Join(dir, "file.key")); err != nil {
+		t.Fatalf("WithTLS failed: %v", err)
+	}
+	s.EnableACME()
+	s.WithHTTP("127.0.0.1:0", true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx, nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		s.Wait()
+	})
+	addr := s.listener.Addr().String()
+
+	served := func(t *testing.T) []byte {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "GET / HTTP/1.0\r\n\r\n")
+		body, _ := io.ReadAll(conn)
+		if !bytes.HasSuffix(body, []byte("api")) {
+			t.Errorf("request not proxied: %q", body)
+		}
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+
+	if !bytes.Equal(served(t), leaf(t, filePair)) {
+		t.Error("file certificate not served before the first update")
+	}
+
+	// Issued certificates are swapped in without a restart
+	update := Update{
+		Certificates: map[string]acme.KeyPair{"": acmePair},
+		Challenges: acme.ChallengeSnapshot{
+			HTTP: map[string]string{"token": "token.thumbprint"},
+			ALPN: map[string]acme.KeyPair{"fw.example.com": challengePair},
+		},
+	}
+	if err := s.ApplyUpdate(update); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if !bytes.Equal(served(t), leaf(t, acmePair)) {
+		t.Error("ACME certificate not served after update")
+	}
+
+	t.Run("TLSALPN01", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "fw.example.com",
+			NextProtos:         []string{acme.ALPNProto},
+		})
+		if err != nil {
+			t.Fatalf("validation handshake failed: %v", err)
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto {
+			t.Errorf("negotiated %q", state.NegotiatedProtocol)
+		}
+		if !bytes.Equal(state.PeerCertificates[0].Raw, leaf(t, challengePair)) {
+			t.Error("challenge certificate not served")
+		}
+		if n, _ := conn.Read(make([]byte, 1)); n != 0 {
+			t.Error("validation connection was proxied")
+		}
+	})
+
+	t.Run("HTTP01", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://fw.example.com/.well-known/acme-challenge/token", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "token.thumbprint" {
+			t.Errorf("challenge response %d %q", rec.Code, rec.Body.String())
+		}
+
+		_, port, _ := net.SplitHostPort(addr)
+		rec = httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://fw.example.com/login?next=%2F", nil))
+		want := "https://fw.example.com:" + port + "/login?next=%2F"
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != want {
+			t.Errorf("redirect %d %q, want %q", rec.Code, rec.Header().Get("Location"), want)
+		}
+	})
+
+	// Dropping the certificate reverts to the file one
+	if err := s.ApplyUpdate(Update{}); err != nil {
+		t.Fatalf("ApplyUpdate failed: %v", err)
+	}
+	if !bytes.Equal(served(t), leaf(t, filePair)) {
+		t.Error("file certificate not restored")
+	}
+
+	if err := s.ApplyUpdate(Update{Certificates: map[string]acme.KeyPair{"": {Cert: []byte("bad")}}}); err == nil {
+		t.Error("invalid certificate accepted")
+	}
+}
+
+func TestReadUpdates(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "")
+	s.EnableACME()
+
+	stream := `{"challenges":{"http":{"a":"a.1"}}}` + "\n" + `{"challenges":{"http":{"b":"b.1"}}}` + "\n"
+	if err := s.ReadUpdates(bytes.NewBufferString(stream)); err != nil {
+		t.Fatalf("ReadUpdates failed: %v", err)
+	}
+	if got := s.challenges.Snapshot().HTTP; len(got) != 1 || got["b"] != "b.1" {
+		t.Errorf("last update not applied: %v", got)
+	}
+	if err := s.ReadUpdates(bytes.NewBufferString("{")); err == nil {
+		t.Error("truncated stream not reported")
+	}
+}
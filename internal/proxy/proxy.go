@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"grimm.is/glacic/internal/acme"
 	"grimm.is/glacic/internal/logging"
+	gtls "grimm.is/glacic/internal/tls"
 )
 
 // Server handles TCP to Unix socket proxying with optional TLS termination
@@ -21,6 +24,19 @@ type Server struct {
 	logger     *logging.Logger
 	wg         sync.WaitGroup
 	tlsConfig  *tls.Config
+
+	// Certificates served over TLS; ACME updates replace the file
+	// certificate and add per-interface ones
+	certs     *gtls.CertificateManager
+	fileCert  *tls.Certificate
+	acmeCerts map[string]bool
+
+	// ACME challenge responses and the plain HTTP listener answering
+	// HTTP-01 (see acme.go)
+	challenges *acme.Challenges
+	httpAddr   string
+	redirect   bool
+	httpServer *http.Server
 }
 
 // NewServer creates a new proxy server
@@ -29,6 +45,8 @@ func NewServer(listenAddr, targetSock string) *Server {
 		listenAddr: listenAddr,
 		targetSock: targetSock,
 		logger:     logging.WithComponent("proxy"),
+		certs:      gtls.NewCertificateManager(),
+		acmeCerts:  make(map[string]bool),
 	}
 }
 
@@ -38,13 +56,25 @@ func (s *Server) WithTLS(certFile, keyFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load TLS certificate: %w", err)
 	}
+	s.fileCert = &cert
+	s.certs.SetDefaultCertificate(&cert)
 	s.tlsConfig = &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
+		GetCertificate: s.getCertificate,
+		MinVersion:     tls.VersionTLS12,
 	}
 	return nil
 }
 
+// getCertificate answers tls-alpn-01 validation handshakes with the
+// challenge certificate and everything else with the interface's
+// certificate.
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.challenges != nil && acme.IsChallengeHello(hello) {
+		return s.challenges.GetCertificate(hello)
+	}
+	return s.certs.GetCertificate(hello)
+}
+
 // SetTargetSock updates the target socket path (useful after chroot updates path)
 func (s *Server) SetTargetSock(targetSock string) {
 	s.targetSock = targetSock
@@ -72,6 +102,13 @@ func (s *Server) Start(ctx context.Context, inheritedListener net.Listener) erro
 		}
 	}
 
+	if s.httpAddr != "" {
+		if err := s.startHTTP(ctx); err != nil {
+			s.listener.Close()
+			return err
+		}
+	}
+
 	s.wg.Add(1)
 	go s.run(ctx)
 	return nil
@@ -115,6 +152,18 @@ func (s *Server) handle(conn net.Conn) {
 	defer s.wg.Done()
 	defer conn.Close()
 
+	// tls-alpn-01 validation ends with the handshake
+	if tlsConn, ok := conn.(*tls.Conn); ok && s.challenges != nil {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol == acme.ALPNProto {
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
 	// Connect to Unix socket
 	upstream, err := net.DialTimeout("unix", s.targetSock, 1*time.Second)
 	if err != nil {
@@ -0,0 +1,421 @@
+// Package bgp implements a BGP-4 speaker (RFC 4271) for edge routers.
+//
+// The [Speaker] peers with the configured neighbors, advertises the
+// configured networks and installs the best received route for each
+// prefix into the neighbor's routing table. Learned routes are never
+// re-advertised, so the router cannot become transit between its peers.
+// IPv6 unicast (RFC 4760) and 4-octet AS numbers (RFC 6793) are
+// supported.
+package bgp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// State is the state of a BGP session (RFC 4271 section 8.2.2).
+type State string
+
+const (
+	StateIdle        State = "idle"
+	StateConnect     State = "connect"
+	StateActive      State = "active"
+	StateOpenSent    State = "opensent"
+	StateOpenConfirm State = "openconfirm"
+	StateEstablished State = "established"
+)
+
+const (
+	defaultHoldTime     = 90 * time.Second
+	defaultLocalPref    = 100
+	defaultConnectRetry = 30 * time.Second
+	connectTimeout      = 10 * time.Second
+
+	// openHoldTime bounds the wait for the peer's OPEN (RFC 4271 section 8).
+	openHoldTime = 4 * time.Minute
+
+	// mainTable is the kernel's main routing table.
+	mainTable = 254
+)
+
+// NeighborStatus describes a BGP neighbor for status output.
+type NeighborStatus struct {
+	Address            string    `json:"address"`
+	RemoteAS           uint32    `json:"remote_as"`
+	Description        string    `json:"description,omitempty"`
+	State              State     `json:"state"`
+	RouterID           string    `json:"router_id,omitempty"`
+	EstablishedAt      time.Time `json:"established_at,omitempty"`
+	Table              int       `json:"table"`
+	PrefixesReceived   int       `json:"prefixes_received"`
+	PrefixesAccepted   int       `json:"prefixes_accepted"`
+	PrefixesAdvertised int       `json:"prefixes_advertised"`
+	LastError          string    `json:"last_error,omitempty"`
+}
+
+// route is a path received from a peer.
+type route struct {
+	prefix   netip.Prefix
+	nextHop  netip.Addr
+	attrs    pathAttrs
+	peerID   netip.Addr // BGP identifier of the advertising peer
+	accepted bool       // Passed the import prefix list and loop checks
+}
+
+// routeInstaller programs the kernel routing tables. The Linux
+// implementation uses netlink; tests substitute a recorder.
+type routeInstaller interface {
+	Replace(table int, prefix netip.Prefix, nextHop netip.Addr) error
+	Delete(table int, prefix netip.Prefix) error
+	// Flush removes BGP routes left in the tables by a previous run.
+	Flush(tables []int) error
+}
+
+type ribKey struct {
+	table  int
+	prefix netip.Prefix
+}
+
+// Speaker runs the BGP sessions and the routing information base.
+type Speaker struct {
+	localAS      uint32
+	routerID     netip.Addr
+	networks     []netip.Prefix
+	peers        []*Peer
+	listenAddr   string
+	connectRetry time.Duration
+	routes       routeInstaller
+	logger       *logging.Logger
+
+	mu        sync.Mutex
+	adjIn     map[*Peer]map[netip.Prefix]*route
+	installed map[ribKey]netip.Addr // Next hop of the installed best route
+
+	ln     net.Listener
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSpeaker validates and converts the BGP config. Call Start to open
+// the sessions.
+func NewSpeaker(cfg *config.BGP, logger *logging.Logger) (*Speaker, error) {
+	if cfg.ASN < 1 || cfg.ASN > math.MaxUint32 {
+		return nil, fmt.Errorf("bgp: asn %d out of range", cfg.ASN)
+	}
+	s := &Speaker{
+		localAS:      uint32(cfg.ASN),
+		listenAddr:   fmt.Sprintf(":%d", Port),
+		connectRetry: defaultConnectRetry,
+		routes:       newRouteInstaller(),
+		logger:       logger,
+		adjIn:        make(map[*Peer]map[netip.Prefix]*route),
+		installed:    make(map[ribKey]netip.Addr),
+	}
+
+	if cfg.RouterID != "" {
+		id, err := netip.ParseAddr(cfg.RouterID)
+		if err != nil || !id.Is4() || id.IsUnspecified() {
+			return nil, fmt.Errorf("bgp: invalid router ID %q", cfg.RouterID)
+		}
+		s.routerID = id
+	} else {
+		id, err := defaultRouterID()
+		if err != nil {
+			return nil, err
+		}
+		s.routerID = id
+	}
+
+	for _, n := range cfg.Networks {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("bgp: network %q: %w", n, err)
+		}
+		s.networks = append(s.networks, prefix.Masked())
+	}
+
+	lists := make(map[string]*prefixList)
+	for _, plCfg := range cfg.PrefixLists {
+		pl, err := compilePrefixList(plCfg)
+		if err != nil {
+			return nil, fmt.Errorf("bgp: %w", err)
+		}
+		lists[pl.name] = pl
+	}
+
+	defaultTable := mainTable
+	if cfg.Table != 0 {
+		defaultTable = cfg.Table
+	}
+	for _, n := range cfg.Neighbors {
+		p, err := newPeer(s, n, defaultTable, lists)
+		if err != nil {
+			return nil, err
+		}
+		s.peers = append(s.peers, p)
+	}
+	return s, nil
+}
+
+// defaultRouterID picks the highest IPv4 interface address, as routing
+// daemons conventionally do.
+func defaultRouterID() (netip.Addr, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("bgp: router ID: %w", err)
+	}
+	var id netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		if addr = addr.Unmap(); !addr.Is4() || addr.IsLoopback() {
+			continue
+		}
+		if !id.IsValid() || id.Less(addr) {
+			id = addr
+		}
+	}
+	if !id.IsValid() {
+		return netip.Addr{}, errors.New("bgp: no IPv4 address for the router ID; set router_id")
+	}
+	return id, nil
+}
+
+// tables lists the routing tables the speaker installs routes into.
+func (s *Speaker) tables() []int {
+	var tables []int
+	seen := make(map[int]bool)
+	for _, p := range s.peers {
+		if !seen[p.table] {
+			seen[p.table] = true
+			tables = append(tables, p.table)
+		}
+	}
+	return tables
+}
+
+// Start listens for connections and starts the neighbor sessions.
+func (s *Speaker) Start() error {
+	if err := s.routes.Flush(s.tables()); err != nil {
+		s.logger.Warn("Failed to remove stale BGP routes", "error", err)
+	}
+
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return fmt.Errorf("bgp: %w", err)
+	}
+	s.ln = ln
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.acceptLoop()
+	}()
+	for _, p := range s.peers {
+		s.wg.Add(1)
+		go func(p *Peer) {
+			defer s.wg.Done()
+			p.run(ctx)
+		}(p)
+	}
+	s.logger.Info("BGP speaker started", "as", s.localAS, "router_id", s.routerID, "neighbors", len(s.peers))
+	return nil
+}
+
+// Stop closes the sessions and removes the installed routes.
+func (s *Speaker) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.ln.Close()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.installed {
+		if err := s.routes.Delete(key.table, key.prefix); err != nil {
+			s.logger.Warn("Failed to remove BGP route", "prefix", key.prefix, "table", key.table, "error", err)
+		}
+	}
+	clear(s.installed)
+	clear(s.adjIn)
+}
+
+func (s *Speaker) acceptLoop() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Warn("BGP accept failed", "error", err)
+			continue
+		}
+		remote := conn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr().Unmap()
+		p := s.peer(remote)
+		if p == nil {
+			s.logger.Debug("Rejected BGP connection from unknown peer", "remote", remote)
+			conn.Close()
+			continue
+		}
+		p.accept(conn)
+	}
+}
+
+func (s *Speaker) peer(addr netip.Addr) *Peer {
+	for _, p := range s.peers {
+		if p.addr == addr {
+			return p
+		}
+	}
+	return nil
+}
+
+// update applies an UPDATE from p to the RIB.
+func (s *Speaker) update(p *Peer, withdrawn []netip.Prefix, routes []*route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in := s.adjIn[p]
+	if in == nil {
+		in = make(map[netip.Prefix]*route)
+		s.adjIn[p] = in
+	}
+	for _, prefix := range withdrawn {
+		if _, ok := in[prefix]; ok {
+			delete(in, prefix)
+			s.reselect(ribKey{table: p.table, prefix: prefix})
+		}
+	}
+	for _, r := range routes {
+		in[r.prefix] = r
+		s.reselect(ribKey{table: p.table, prefix: r.prefix})
+	}
+}
+
+// peerDown withdraws every route learned from p.
+func (s *Speaker) peerDown(p *Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in := s.adjIn[p]
+	delete(s.adjIn, p)
+	for prefix := range in {
+		s.reselect(ribKey{table: p.table, prefix: prefix})
+	}
+}
+
+// reselect runs best path selection for key and updates the kernel.
+// Called with s.mu held.
+func (s *Speaker) reselect(key ribKey) {
+	var best *route
+	var bestPeer *Peer
+	for _, p := range s.peers {
+		if p.table != key.table {
+			continue
+		}
+		r := s.adjIn[p][key.prefix]
+		if r == nil || !r.accepted {
+			continue
+		}
+		if best == nil || s.better(r, p, best, bestPeer) {
+			best, bestPeer = r, p
+		}
+	}
+
+	current, installed := s.installed[key]
+	switch {
+	case best == nil && installed:
+		delete(s.installed, key)
+		if err := s.routes.Delete(key.table, key.prefix); err != nil {
+			s.logger.Warn("Failed to remove BGP route", "prefix", key.prefix, "table", key.table, "error", err)
+		}
+	case best != nil && (!installed || current != best.nextHop):
+		if err := s.routes.Replace(key.table, key.prefix, best.nextHop); err != nil {
+			s.logger.Warn("Failed to install BGP route", "prefix", key.prefix, "via", best.nextHop, "table", key.table, "error", err)
+			return
+		}
+		s.installed[key] = best.nextHop
+	}
+}
+
+// better reports whether a from pa is preferred over b from pb (RFC 4271
+// section 9.1.2.2).
+func (s *Speaker) better(a *route, pa *Peer, b *route, pb *Peer) bool {
+	if prefA, prefB := pa.localPref(a), pb.localPref(b); prefA != prefB {
+		return prefA > prefB
+	}
+	if lenA, lenB := a.attrs.pathLength(), b.attrs.pathLength(); lenA != lenB {
+		return lenA < lenB
+	}
+	if a.attrs.origin != b.attrs.origin {
+		return a.attrs.origin < b.attrs.origin
+	}
+	// MEDs are only comparable between routes from the same neighboring AS
+	if s.neighborAS(a) == s.neighborAS(b) && a.attrs.med != b.attrs.med {
+		return a.attrs.med < b.attrs.med
+	}
+	if pa.ebgp() != pb.ebgp() {
+		return pa.ebgp()
+	}
+	if a.peerID != b.peerID {
+		return a.peerID.Less(b.peerID)
+	}
+	return pa.addr.Less(pb.addr)
+}
+
+func (s *Speaker) neighborAS(r *route) uint32 {
+	if as := r.attrs.firstAS(); as != 0 {
+		return as
+	}
+	return s.localAS
+}
+
+// exports returns the networks advertised to p over a session of family f.
+func (s *Speaker) exports(p *Peer, f family) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, n := range s.networks {
+		if familyOf(n.Addr()) == f && p.exportList.permits(n) {
+			prefixes = append(prefixes, n)
+		}
+	}
+	return prefixes
+}
+
+// Neighbors returns the state of every neighbor in config order.
+func (s *Speaker) Neighbors() []NeighborStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make([]NeighborStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		st := p.status()
+		for _, r := range s.adjIn[p] {
+			st.PrefixesReceived++
+			if r.accepted {
+				st.PrefixesAccepted++
+			}
+		}
+		status = append(status, st)
+	}
+	return status
+}
@@ -0,0 +1,303 @@
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// fakeRoutes records the routing tables instead of programming the kernel.
+type fakeRoutes struct {
+	mu     sync.Mutex
+	tables map[int]map[netip.Prefix]netip.Addr
+}
+
+func newFakeRoutes() *fakeRoutes {
+	return &fakeRoutes{tables: make(map[int]map[netip.Prefix]netip.Addr)}
+}
+
+func (f *fakeRoutes) Replace(table int, prefix netip.Prefix, nextHop netip.Addr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tables[table] == nil {
+		f.tables[table] = make(map[netip.Prefix]netip.Addr)
+	}
+	f.tables[table][prefix] = nextHop
+	return nil
+}
+
+func (f *fakeRoutes) Delete(table int, prefix netip.Prefix) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tables[table], prefix)
+	return nil
+}
+
+func (f *fakeRoutes) Flush(tables []int) error {
+	return nil
+}
+
+// get returns the next hop for prefix in table, if installed.
+func (f *fakeRoutes) get(table int, prefix string) (netip.Addr, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	nh, ok := f.tables[table][netip.MustParsePrefix(prefix)]
+	return nh, ok
+}
+
+func (f *fakeRoutes) count(table int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tables[table])
+}
+
+func newTestSpeaker(t *testing.T, cfg *config.BGP) (*Speaker, *fakeRoutes) {
+	t.Helper()
+	s, err := NewSpeaker(cfg, logging.New(logging.DefaultConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := newFakeRoutes()
+	s.routes = routes
+	s.connectRetry = 100 * time.Millisecond
+	return s, routes
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPrefixList(t *testing.T) {
+	pl, err := compilePrefixList(config.PrefixList{Name: "in", Rules: []config.PrefixListRule{
+		{Prefix: "10.66.0.0/16", Action: "deny", GE: 16},
+		{Prefix: "10.0.0.0/8", LE: 24},
+		{Prefix: "0.0.0.0/0"},
+		{Prefix: "2001:db8::/32", GE: 48, LE: 64},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"10.0.0.0/8":        true,
+		"10.1.2.0/24":       true,
+		"10.1.2.128/25":     false, // Longer than le
+		"10.66.1.0/24":      false, // Denied before the /8 rule
+		"0.0.0.0/0":         true,
+		"192.0.2.0/24":      false, // Exact match only
+		"2001:db8:1::/48":   true,
+		"2001:db8::/32":     false, // Shorter than ge
+		"2001:db9:1::/48":   false,
+		"::/0":              false, // The IPv4 default does not match IPv6
+		"2001:db8:1:2::/64": true,
+	}
+	for prefix, want := range tests {
+		if got := pl.permits(netip.MustParsePrefix(prefix)); got != want {
+			t.Errorf("permits(%s) = %v, want %v", prefix, got, want)
+		}
+	}
+	if !(*prefixList)(nil).permits(netip.MustParsePrefix("192.0.2.0/24")) {
+		t.Error("no prefix list must permit everything")
+	}
+}
+
+func TestBestPathSelection(t *testing.T) {
+	s, routes := newTestSpeaker(t, &config.BGP{
+		ASN:      65000,
+		RouterID: "192.0.2.254",
+		Neighbors: []config.Neighbor{
+			{IP: "192.0.2.1", RemoteASN: 65001},
+			{IP: "192.0.2.2", RemoteASN: 65002},
+			{IP: "192.0.2.3", RemoteASN: 65000},             // iBGP
+			{IP: "192.0.2.4", RemoteASN: 65004, Table: 100}, // Separate table
+		},
+	})
+	a, b, ibgp, other := s.peers[0], s.peers[1], s.peers[2], s.peers[3]
+	prefix := netip.MustParsePrefix("203.0.113.0/24")
+	path := func(asns ...uint32) pathAttrs {
+		return pathAttrs{asPath: []asSegment{{typ: segmentSequence, asns: asns}}}
+	}
+	announce := func(p *Peer, attrs pathAttrs) {
+		s.update(p, nil, []*route{{prefix: prefix, nextHop: p.addr, attrs: attrs, peerID: p.addr, accepted: true}})
+	}
+	installed := func() string {
+		nh, ok := routes.get(mainTable, prefix.String())
+		if !ok {
+			return "none"
+		}
+		return nh.String()
+	}
+
+	announce(a, path(65001, 65100, 65200))
+	announce(b, path(65002, 65200))
+	if got := installed(); got != "192.0.2.2" {
+		t.Errorf("shorter AS path: installed via %s", got)
+	}
+
+	// iBGP LOCAL_PREF beats path length
+	attrs := pathAttrs{localPref: 200, hasLocalPref: true}
+	attrs.asPath = path(65100, 65200, 65300).asPath
+	announce(ibgp, attrs)
+	if got := installed(); got != "192.0.2.3" {
+		t.Errorf("higher local preference: installed via %s", got)
+	}
+
+	// Withdrawal falls back to the next best path
+	s.update(ibgp, []netip.Prefix{prefix}, nil)
+	if got := installed(); got != "192.0.2.2" {
+		t.Errorf("after withdrawal: installed via %s", got)
+	}
+
+	// Lower origin wins between equal paths; eBGP LOCAL_PREF is ignored
+	announce(a, pathAttrs{asPath: path(65001, 65200).asPath, localPref: 500, hasLocalPref: true, origin: originIGP})
+	announce(b, pathAttrs{asPath: path(65002, 65200).asPath, origin: originIncomplete})
+	if got := installed(); got != "192.0.2.1" {
+		t.Errorf("lower origin: installed via %s", got)
+	}
+
+	// Other tables are selected independently
+	announce(other, path(65004))
+	if nh, ok := routes.get(100, prefix.String()); !ok || nh.String() != "192.0.2.4" {
+		t.Errorf("table 100: %s %v", nh, ok)
+	}
+
+	s.peerDown(a)
+	s.peerDown(b)
+	if got := installed(); got != "none" {
+		t.Errorf("after peers went down: installed via %s", got)
+	}
+}
+
+// TestSpeakers_Peering runs two speakers against each other over
+// loopback: 127.0.0.1 in AS 65001 and 127.0.0.2 in AS 65002.
+func TestSpeakers_Peering(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 not available: %v", err)
+	}
+	ln.Close()
+
+	b, routesB := newTestSpeaker(t, &config.BGP{
+		ASN:      65002,
+		RouterID: "10.255.0.2",
+		Networks: []string{"192.0.2.0/24", "198.51.100.0/24", "2001:db8:2::/48"},
+		Neighbors: []config.Neighbor{
+			{IP: "127.0.0.1", RemoteASN: 65001, Passive: true, HoldTime: 3},
+		},
+	})
+	b.listenAddr = "127.0.0.2:0"
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Stop()
+	portB := b.ln.Addr().(*net.TCPAddr).Port
+
+	a, routesA := newTestSpeaker(t, &config.BGP{
+		ASN:      65001,
+		RouterID: "10.255.0.1",
+		Networks: []string{"10.1.0.0/16", "10.2.0.0/16"},
+		Table:    100,
+		PrefixLists: []config.PrefixList{
+			{Name: "from-b", Rules: []config.PrefixListRule{{Prefix: "192.0.2.0/24"}}},
+			{Name: "to-b", Rules: []config.PrefixListRule{{Prefix: "10.0.0.0/8", LE: 16}}},
+		},
+		Neighbors: []config.Neighbor{
+			{IP: "127.0.0.2", RemoteASN: 65002, Port: portB, HoldTime: 3, Import: "from-b", Export: "to-b", Description: "upstream"},
+		},
+	})
+	a.listenAddr = "127.0.0.1:0"
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	waitFor(t, "A to install B's route", func() bool {
+		_, ok := routesA.get(100, "192.0.2.0/24")
+		return ok
+	})
+	waitFor(t, "B to install A's routes", func() bool { return routesB.count(mainTable) == 2 })
+
+	if nh, _ := routesA.get(100, "192.0.2.0/24"); nh.String() != "127.0.0.2" {
+		t.Errorf("A: 192.0.2.0/24 via %s", nh)
+	}
+	if _, ok := routesA.get(100, "198.51.100.0/24"); ok {
+		t.Error("A installed a prefix its import list denies")
+	}
+	if nh, _ := routesB.get(mainTable, "10.1.0.0/16"); nh.String() != "127.0.0.1" {
+		t.Errorf("B: 10.1.0.0/16 via %s", nh)
+	}
+
+	st := a.Neighbors()[0]
+	want := NeighborStatus{
+		Address: "127.0.0.2", RemoteAS: 65002, Description: "upstream", State: StateEstablished, RouterID: "10.255.0.2",
+		Table: 100, PrefixesReceived: 2, PrefixesAccepted: 1, PrefixesAdvertised: 2, EstablishedAt: st.EstablishedAt,
+	}
+	if st != want || st.EstablishedAt.IsZero() {
+		t.Errorf("A's neighbor status = %+v", st)
+	}
+
+	// Keepalives hold the session past the 3s hold time
+	time.Sleep(3500 * time.Millisecond)
+	if st := b.Neighbors()[0]; st.State != StateEstablished {
+		t.Fatalf("B's session dropped: %+v", st)
+	}
+
+	// Shutting B down withdraws everything A learned from it
+	b.Stop()
+	waitFor(t, "A to withdraw B's routes", func() bool { return routesA.count(100) == 0 })
+	if st := a.Neighbors()[0]; st.State == StateEstablished || st.LastError == "" {
+		t.Errorf("A's neighbor after B stopped = %+v", st)
+	}
+	if routesB.count(mainTable) != 0 {
+		t.Errorf("B left routes installed after Stop: %d", routesB.count(mainTable))
+	}
+}
+
+func TestSpeaker_RejectsWrongAS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 not available: %v", err)
+	}
+	ln.Close()
+
+	b, _ := newTestSpeaker(t, &config.BGP{
+		ASN:       65002,
+		RouterID:  "10.255.0.2",
+		Neighbors: []config.Neighbor{{IP: "127.0.0.1", RemoteASN: 65099, Passive: true}},
+	})
+	b.listenAddr = "127.0.0.2:0"
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Stop()
+
+	a, _ := newTestSpeaker(t, &config.BGP{
+		ASN:      65001,
+		RouterID: "10.255.0.1",
+		Neighbors: []config.Neighbor{
+			{IP: "127.0.0.2", RemoteASN: 65002, Port: b.ln.Addr().(*net.TCPAddr).Port},
+		},
+	})
+	a.listenAddr = "127.0.0.1:0"
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	waitFor(t, "A to see the OPEN error", func() bool { return a.Neighbors()[0].LastError != "" })
+	if st := a.Neighbors()[0]; st.LastError != fmt.Sprintf("peer sent notification: OPEN message error (subcode %d)", subBadPeerAS) {
+		t.Errorf("A's last error = %q", st.LastError)
+	}
+}
@@ -0,0 +1,580 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+const (
+	// Port is the TCP port BGP speakers listen on.
+	Port = 179
+
+	bgpVersion    = 4
+	headerLen     = 19
+	maxMessageLen = 4096
+
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	// asTrans stands in for a 4-octet AS in 2-octet fields (RFC 6793).
+	asTrans = 23456
+)
+
+// Path attributes (RFC 4271 section 5, RFC 4760).
+const (
+	attrOrigin    = 1
+	attrASPath    = 2
+	attrNextHop   = 3
+	attrMED       = 4
+	attrLocalPref = 5
+	attrMPReach   = 14
+	attrMPUnreach = 15
+
+	flagOptional       = 0x80
+	flagTransitive     = 0x40
+	flagExtendedLength = 0x10
+
+	originIGP        = 0
+	originIncomplete = 2
+
+	segmentSet      = 1
+	segmentSequence = 2
+)
+
+// Capabilities (RFC 5492).
+const (
+	paramCapabilities = 2
+	capMultiprotocol  = 1
+	capFourOctetAS    = 65
+)
+
+// NOTIFICATION error codes and subcodes (RFC 4271 section 4.5).
+const (
+	errHeader      = 1
+	errOpen        = 2
+	errUpdate      = 3
+	errHoldTimer   = 4
+	errFSM         = 5
+	errCease       = 6
+	subNotSync     = 1
+	subBadLength   = 2
+	subBadType     = 3
+	subBadVersion  = 1
+	subBadPeerAS   = 2
+	subBadBGPID    = 3
+	subBadHoldTime = 6
+	subMalformed   = 1
+	subMissingAttr = 3
+	subAttrLength  = 5
+	subBadOrigin   = 6
+	subBadNetwork  = 10
+	subBadASPath   = 11
+	subShutdown    = 2
+	subDeconfigure = 3
+	subCollision   = 7
+)
+
+// family is an address family / subsequent address family pair.
+type family struct {
+	afi  uint16
+	safi uint8
+}
+
+var (
+	familyIPv4 = family{afi: 1, safi: 1}
+	familyIPv6 = family{afi: 2, safi: 1}
+)
+
+func familyOf(addr netip.Addr) family {
+	if addr.Is4() {
+		return familyIPv4
+	}
+	return familyIPv6
+}
+
+// notification is a NOTIFICATION message. As an error it closes the
+// session; the peer is told why unless it sent the notification itself.
+type notification struct {
+	code     uint8
+	subcode  uint8
+	data     []byte
+	received bool
+}
+
+func (n *notification) Error() string {
+	var reason string
+	switch n.code {
+	case errHeader:
+		reason = "message header error"
+	case errOpen:
+		reason = "OPEN message error"
+	case errUpdate:
+		reason = "UPDATE message error"
+	case errHoldTimer:
+		reason = "hold timer expired"
+	case errFSM:
+		reason = "finite state machine error"
+	case errCease:
+		reason = "cease"
+	default:
+		reason = fmt.Sprintf("error code %d", n.code)
+	}
+	if n.subcode != 0 {
+		reason = fmt.Sprintf("%s (subcode %d)", reason, n.subcode)
+	}
+	if n.received {
+		return "peer sent notification: " + reason
+	}
+	return reason
+}
+
+func newMessage(typ uint8) []byte {
+	b := make([]byte, headerLen, 64)
+	for i := 0; i < 16; i++ {
+		b[i] = 0xff
+	}
+	b[18] = typ
+	return b
+}
+
+// finish fills in the length field.
+func finish(b []byte) []byte {
+	binary.BigEndian.PutUint16(b[16:18], uint16(len(b)))
+	return b
+}
+
+func marshalKeepalive() []byte {
+	return finish(newMessage(msgKeepalive))
+}
+
+func (n *notification) marshal() []byte {
+	b := append(newMessage(msgNotification), n.code, n.subcode)
+	return finish(append(b, n.data...))
+}
+
+// readMessage reads one message and checks its header.
+func readMessage(r io.Reader) (uint8, []byte, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	for _, m := range hdr[:16] {
+		if m != 0xff {
+			return 0, nil, &notification{code: errHeader, subcode: subNotSync}
+		}
+	}
+	length := binary.BigEndian.Uint16(hdr[16:18])
+	typ := hdr[18]
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, &notification{code: errHeader, subcode: subBadLength, data: hdr[16:18]}
+	}
+	if typ < msgOpen || typ > msgKeepalive {
+		return 0, nil, &notification{code: errHeader, subcode: subBadType, data: []byte{typ}}
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+func parseNotification(body []byte) *notification {
+	n := &notification{received: true}
+	if len(body) >= 2 {
+		n.code, n.subcode, n.data = body[0], body[1], body[2:]
+	}
+	return n
+}
+
+// open is an OPEN message.
+type open struct {
+	as       uint32 // From the 4-octet AS capability when present
+	holdTime uint16
+	id       netip.Addr
+	as4      bool
+	families []family
+}
+
+func (o *open) marshal() []byte {
+	b := append(newMessage(msgOpen), bgpVersion)
+	myAS := uint16(asTrans)
+	if o.as <= 0xffff {
+		myAS = uint16(o.as)
+	}
+	b = binary.BigEndian.AppendUint16(b, myAS)
+	b = binary.BigEndian.AppendUint16(b, o.holdTime)
+	b = append(b, o.id.AsSlice()...)
+
+	var caps []byte
+	for _, f := range o.families {
+		caps = append(caps, capMultiprotocol, 4)
+		caps = binary.BigEndian.AppendUint16(caps, f.afi)
+		caps = append(caps, 0, f.safi)
+	}
+	caps = append(caps, capFourOctetAS, 4)
+	caps = binary.BigEndian.AppendUint32(caps, o.as)
+
+	b = append(b, byte(len(caps)+2), paramCapabilities, byte(len(caps)))
+	return finish(append(b, caps...))
+}
+
+func parseOpen(body []byte) (*open, error) {
+	if len(body) < 10 {
+		return nil, &notification{code: errHeader, subcode: subBadLength}
+	}
+	if body[0] != bgpVersion {
+		return nil, &notification{code: errOpen, subcode: subBadVersion, data: []byte{0, bgpVersion}}
+	}
+	o := &open{
+		as:       uint32(binary.BigEndian.Uint16(body[1:3])),
+		holdTime: binary.BigEndian.Uint16(body[3:5]),
+		id:       netip.AddrFrom4([4]byte(body[5:9])),
+	}
+	params := body[10:]
+	if int(body[9]) != len(params) {
+		return nil, &notification{code: errOpen, subcode: subMalformed}
+	}
+	for len(params) > 0 {
+		if len(params) < 2 || len(params) < 2+int(params[1]) {
+			return nil, &notification{code: errOpen, subcode: subMalformed}
+		}
+		typ, value := params[0], params[2:2+params[1]]
+		params = params[2+params[1]:]
+		if typ != paramCapabilities {
+			continue
+		}
+		for len(value) > 0 {
+			if len(value) < 2 || len(value) < 2+int(value[1]) {
+				return nil, &notification{code: errOpen, subcode: subMalformed}
+			}
+			code, data := value[0], value[2:2+value[1]]
+			value = value[2+value[1]:]
+			switch {
+			case code == capMultiprotocol && len(data) == 4:
+				o.families = append(o.families, family{afi: binary.BigEndian.Uint16(data[0:2]), safi: data[3]})
+			case code == capFourOctetAS && len(data) == 4:
+				o.as4 = true
+				o.as = binary.BigEndian.Uint32(data)
+			}
+		}
+	}
+	return o, nil
+}
+
+// supports reports whether the OPEN negotiates f. A speaker without
+// multiprotocol capabilities supports IPv4 unicast only.
+func (o *open) supports(f family) bool {
+	if len(o.families) == 0 {
+		return f == familyIPv4
+	}
+	for _, have := range o.families {
+		if have == f {
+			return true
+		}
+	}
+	return false
+}
+
+// asSegment is an AS_PATH segment.
+type asSegment struct {
+	typ  uint8
+	asns []uint32
+}
+
+// pathAttrs are the path attributes of an UPDATE.
+type pathAttrs struct {
+	origin       uint8
+	asPath       []asSegment
+	nextHop      netip.Addr
+	med          uint32
+	hasMED       bool
+	localPref    uint32
+	hasLocalPref bool
+	hasOrigin    bool
+	hasASPath    bool
+}
+
+// pathLength counts an AS_SET as one hop (RFC 4271 section 9.1.2.2).
+func (a *pathAttrs) pathLength() int {
+	n := 0
+	for _, seg := range a.asPath {
+		if seg.typ == segmentSet {
+			n++
+		} else {
+			n += len(seg.asns)
+		}
+	}
+	return n
+}
+
+// firstAS is the neighboring AS the route was learned from.
+func (a *pathAttrs) firstAS() uint32 {
+	if len(a.asPath) == 0 || a.asPath[0].typ != segmentSequence || len(a.asPath[0].asns) == 0 {
+		return 0
+	}
+	return a.asPath[0].asns[0]
+}
+
+func (a *pathAttrs) contains(as uint32) bool {
+	for _, seg := range a.asPath {
+		for _, asn := range seg.asns {
+			if asn == as {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// update is an UPDATE message. IPv6 reachability travels in the
+// multiprotocol attributes and is flattened into the same fields.
+type update struct {
+	withdrawn []netip.Prefix
+	attrs     pathAttrs
+	nlri      []netip.Prefix
+	nextHop6  netip.Addr
+	reach6    []netip.Prefix
+}
+
+func appendAttr(b []byte, flags, typ uint8, value []byte) []byte {
+	if len(value) > 255 {
+		b = append(b, flags|flagExtendedLength, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	} else {
+		b = append(b, flags, typ, byte(len(value)))
+	}
+	return append(b, value...)
+}
+
+func appendPrefixes(b []byte, prefixes []netip.Prefix) []byte {
+	for _, p := range prefixes {
+		bits := p.Bits()
+		b = append(b, byte(bits))
+		b = append(b, p.Addr().AsSlice()[:(bits+7)/8]...)
+	}
+	return b
+}
+
+// marshal encodes the update. as4 selects 4-octet AS_PATH encoding.
+func (u *update) marshal(as4 bool) []byte {
+	b := newMessage(msgUpdate)
+	withdrawn := appendPrefixes(nil, u.withdrawn)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(withdrawn)))
+	b = append(b, withdrawn...)
+
+	var attrs []byte
+	if len(u.nlri) > 0 || len(u.reach6) > 0 {
+		attrs = appendAttr(attrs, flagTransitive, attrOrigin, []byte{u.attrs.origin})
+		var path []byte
+		for _, seg := range u.attrs.asPath {
+			path = append(path, seg.typ, byte(len(seg.asns)))
+			for _, asn := range seg.asns {
+				if as4 {
+					path = binary.BigEndian.AppendUint32(path, asn)
+				} else if asn > 0xffff {
+					path = binary.BigEndian.AppendUint16(path, asTrans)
+				} else {
+					path = binary.BigEndian.AppendUint16(path, uint16(asn))
+				}
+			}
+		}
+		attrs = appendAttr(attrs, flagTransitive, attrASPath, path)
+		if len(u.nlri) > 0 {
+			attrs = appendAttr(attrs, flagTransitive, attrNextHop, u.attrs.nextHop.AsSlice())
+		}
+		if u.attrs.hasMED {
+			attrs = appendAttr(attrs, flagOptional, attrMED, binary.BigEndian.AppendUint32(nil, u.attrs.med))
+		}
+		if u.attrs.hasLocalPref {
+			attrs = appendAttr(attrs, flagTransitive, attrLocalPref, binary.BigEndian.AppendUint32(nil, u.attrs.localPref))
+		}
+		if len(u.reach6) > 0 {
+			reach := binary.BigEndian.AppendUint16(nil, familyIPv6.afi)
+			reach = append(reach, familyIPv6.safi, 16)
+			reach = append(reach, u.nextHop6.AsSlice()...)
+			reach = appendPrefixes(append(reach, 0), u.reach6)
+			attrs = appendAttr(attrs, flagOptional, attrMPReach, reach)
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attrs)))
+	b = append(b, attrs...)
+	return finish(appendPrefixes(b, u.nlri))
+}
+
+func parsePrefixes(b []byte, f family) ([]netip.Prefix, error) {
+	maxBits := 32
+	if f == familyIPv6 {
+		maxBits = 128
+	}
+	var prefixes []netip.Prefix
+	for len(b) > 0 {
+		bits := int(b[0])
+		n := (bits + 7) / 8
+		if bits > maxBits || len(b) < 1+n {
+			return nil, &notification{code: errUpdate, subcode: subBadNetwork}
+		}
+		var raw [16]byte
+		copy(raw[:], b[1:1+n])
+		addr := netip.AddrFrom16(raw)
+		if maxBits == 32 {
+			addr = netip.AddrFrom4([4]byte(raw[:4]))
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, bits).Masked())
+		b = b[1+n:]
+	}
+	return prefixes, nil
+}
+
+// parseUpdate decodes an UPDATE. as4 selects 4-octet AS_PATH decoding.
+// Families other than IPv4 and IPv6 unicast are ignored.
+func parseUpdate(body []byte, as4 bool) (*update, error) {
+	malformed := &notification{code: errUpdate, subcode: subMalformed}
+	if len(body) < 4 {
+		return nil, malformed
+	}
+	u := &update{}
+	wlen := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 4+wlen {
+		return nil, malformed
+	}
+	var err error
+	if u.withdrawn, err = parsePrefixes(body[2:2+wlen], familyIPv4); err != nil {
+		return nil, err
+	}
+	body = body[2+wlen:]
+	alen := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 2+alen {
+		return nil, malformed
+	}
+	attrs := body[2 : 2+alen]
+	if u.nlri, err = parsePrefixes(body[2+alen:], familyIPv4); err != nil {
+		return nil, err
+	}
+
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return nil, malformed
+		}
+		flags, typ := attrs[0], attrs[1]
+		var value []byte
+		if flags&flagExtendedLength != 0 {
+			if len(attrs) < 4 || len(attrs) < 4+int(binary.BigEndian.Uint16(attrs[2:4])) {
+				return nil, malformed
+			}
+			n := int(binary.BigEndian.Uint16(attrs[2:4]))
+			value, attrs = attrs[4:4+n], attrs[4+n:]
+		} else {
+			if len(attrs) < 3+int(attrs[2]) {
+				return nil, malformed
+			}
+			n := int(attrs[2])
+			value, attrs = attrs[3:3+n], attrs[3+n:]
+		}
+		if err := u.parseAttr(typ, value, as4); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(u.nlri) > 0 || len(u.reach6) > 0 {
+		for typ, ok := range map[uint8]bool{attrOrigin: u.attrs.hasOrigin, attrASPath: u.attrs.hasASPath} {
+			if !ok {
+				return nil, &notification{code: errUpdate, subcode: subMissingAttr, data: []byte{typ}}
+			}
+		}
+	}
+	if len(u.nlri) > 0 && !u.attrs.nextHop.IsValid() {
+		return nil, &notification{code: errUpdate, subcode: subMissingAttr, data: []byte{attrNextHop}}
+	}
+	return u, nil
+}
+
+func (u *update) parseAttr(typ uint8, value []byte, as4 bool) error {
+	badLength := &notification{code: errUpdate, subcode: subAttrLength, data: []byte{typ}}
+	switch typ {
+	case attrOrigin:
+		if len(value) != 1 {
+			return badLength
+		}
+		if value[0] > originIncomplete {
+			return &notification{code: errUpdate, subcode: subBadOrigin}
+		}
+		u.attrs.origin, u.attrs.hasOrigin = value[0], true
+	case attrASPath:
+		size := 2
+		if as4 {
+			size = 4
+		}
+		for len(value) > 0 {
+			if len(value) < 2 || len(value) < 2+int(value[1])*size {
+				return &notification{code: errUpdate, subcode: subBadASPath}
+			}
+			seg := asSegment{typ: value[0]}
+			if seg.typ != segmentSet && seg.typ != segmentSequence {
+				return &notification{code: errUpdate, subcode: subBadASPath}
+			}
+			for i := 0; i < int(value[1]); i++ {
+				off := 2 + i*size
+				if as4 {
+					seg.asns = append(seg.asns, binary.BigEndian.Uint32(value[off:]))
+				} else {
+					seg.asns = append(seg.asns, uint32(binary.BigEndian.Uint16(value[off:])))
+				}
+			}
+			u.attrs.asPath = append(u.attrs.asPath, seg)
+			value = value[2+int(value[1])*size:]
+		}
+		u.attrs.hasASPath = true
+	case attrNextHop:
+		if len(value) != 4 {
+			return badLength
+		}
+		u.attrs.nextHop = netip.AddrFrom4([4]byte(value))
+	case attrMED:
+		if len(value) != 4 {
+			return badLength
+		}
+		u.attrs.med, u.attrs.hasMED = binary.BigEndian.Uint32(value), true
+	case attrLocalPref:
+		if len(value) != 4 {
+			return badLength
+		}
+		u.attrs.localPref, u.attrs.hasLocalPref = binary.BigEndian.Uint32(value), true
+	case attrMPReach:
+		if len(value) < 5 || len(value) < 5+int(value[3]) {
+			return badLength
+		}
+		f := family{afi: binary.BigEndian.Uint16(value[0:2]), safi: value[2]}
+		if f != familyIPv6 {
+			return nil
+		}
+		nhLen := int(value[3])
+		if nhLen != 16 && nhLen != 32 {
+			return &notification{code: errUpdate, subcode: subMalformed}
+		}
+		// A second next hop is the link-local address; keep the global one
+		u.nextHop6 = netip.AddrFrom16([16]byte(value[4:20]))
+		reach, err := parsePrefixes(value[5+nhLen:], f)
+		if err != nil {
+			return err
+		}
+		u.reach6 = reach
+	case attrMPUnreach:
+		if len(value) < 3 {
+			return badLength
+		}
+		f := family{afi: binary.BigEndian.Uint16(value[0:2]), safi: value[2]}
+		if f != familyIPv6 {
+			return nil
+		}
+		withdrawn, err := parsePrefixes(value[3:], f)
+		if err != nil {
+			return err
+		}
+		u.withdrawn = append(u.withdrawn, withdrawn...)
+	}
+	return nil
+}
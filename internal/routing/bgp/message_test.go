@@ -0,0 +1,138 @@
+package bgp
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// body strips the header after checking it.
+func body(t *testing.T, b []byte, wantType uint8) []byte {
+	t.Helper()
+	typ, body, err := readMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != wantType {
+		t.Fatalf("message type %d, want %d", typ, wantType)
+	}
+	return body
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	o := &open{as: 4200000001, holdTime: 90, id: netip.MustParseAddr("192.0.2.1"), families: []family{familyIPv4, familyIPv6}}
+	b := o.marshal()
+
+	got, err := parseOpen(body(t, b, msgOpen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.as != 4200000001 || !got.as4 || got.holdTime != 90 || got.id != o.id {
+		t.Errorf("open = %+v", got)
+	}
+	if !got.supports(familyIPv6) || !got.supports(familyIPv4) {
+		t.Errorf("families = %v", got.families)
+	}
+	// A 4-octet AS is sent as AS_TRANS in the fixed field
+	if b[headerLen+1] != asTrans>>8 || b[headerLen+2] != asTrans&0xff {
+		t.Errorf("My Autonomous System = %x, want AS_TRANS", b[headerLen+1:headerLen+3])
+	}
+
+	// A speaker without capabilities does IPv4 unicast with 2-octet ASNs
+	legacy := append([]byte{bgpVersion, 0xfd, 0xe9, 0, 180, 10, 0, 0, 1}, 0)
+	got, err = parseOpen(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.as != 65001 || got.as4 || !got.supports(familyIPv4) || got.supports(familyIPv6) {
+		t.Errorf("legacy open = %+v", got)
+	}
+
+	var n *notification
+	if _, err := parseOpen(append([]byte{3}, legacy[1:]...)); !errors.As(err, &n) || n.code != errOpen || n.subcode != subBadVersion {
+		t.Errorf("BGP-3 OPEN: err = %v", err)
+	}
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	u := &update{
+		withdrawn: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+		attrs: pathAttrs{
+			origin:  originIGP,
+			asPath:  []asSegment{{typ: segmentSequence, asns: []uint32{65001, 4200000001}}},
+			nextHop: netip.MustParseAddr("192.0.2.1"),
+			med:     50, hasMED: true,
+		},
+		nlri:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("203.0.113.128/25"), netip.MustParsePrefix("0.0.0.0/0")},
+		nextHop6: netip.MustParseAddr("2001:db8::1"),
+		reach6:   []netip.Prefix{netip.MustParsePrefix("2001:db8:100::/40")},
+	}
+
+	got, err := parseUpdate(body(t, u.marshal(true), msgUpdate), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.withdrawn) != 1 || got.withdrawn[0] != u.withdrawn[0] {
+		t.Errorf("withdrawn = %v", got.withdrawn)
+	}
+	if len(got.nlri) != 3 || got.nlri[1] != u.nlri[1] || got.nlri[2] != u.nlri[2] {
+		t.Errorf("nlri = %v", got.nlri)
+	}
+	if got.attrs.nextHop != u.attrs.nextHop || got.attrs.med != 50 || got.attrs.pathLength() != 2 || got.attrs.firstAS() != 65001 {
+		t.Errorf("attrs = %+v", got.attrs)
+	}
+	if !got.attrs.contains(4200000001) {
+		t.Error("4-octet AS lost from AS_PATH")
+	}
+	if got.nextHop6 != u.nextHop6 || len(got.reach6) != 1 || got.reach6[0] != u.reach6[0] {
+		t.Errorf("IPv6 reach = %v via %s", got.reach6, got.nextHop6)
+	}
+
+	// 2-octet speakers see AS_TRANS
+	got, err = parseUpdate(body(t, u.marshal(false), msgUpdate), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := got.attrs.asPath[0].asns; len(p) != 2 || p[1] != asTrans {
+		t.Errorf("2-octet AS_PATH = %v", p)
+	}
+}
+
+func TestParseUpdate_Malformed(t *testing.T) {
+	prefix := []byte{8, 10}
+	tests := []struct {
+		name    string
+		body    []byte
+		subcode uint8
+	}{
+		{"truncated", []byte{0, 5, 24}, subMalformed},
+		{"prefix too long", append([]byte{0, 0, 0, 0}, 33, 10, 0, 0, 0, 0), subBadNetwork},
+		{"missing attributes", append([]byte{0, 0, 0, 0}, prefix...), subMissingAttr},
+		{"bad origin", append([]byte{0, 0, 0, 4, flagTransitive, attrOrigin, 1, 7}, prefix...), subBadOrigin},
+		{"bad next hop length", append([]byte{0, 0, 0, 5, flagTransitive, attrNextHop, 2, 10, 0}, prefix...), subAttrLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n *notification
+			_, err := parseUpdate(tt.body, true)
+			if !errors.As(err, &n) || n.code != errUpdate || n.subcode != tt.subcode {
+				t.Errorf("err = %v, want UPDATE error subcode %d", err, tt.subcode)
+			}
+		})
+	}
+}
+
+func TestReadMessage_BadHeader(t *testing.T) {
+	var n *notification
+	b := marshalKeepalive()
+	b[3] = 0
+	if _, _, err := readMessage(bytes.NewReader(b)); !errors.As(err, &n) || n.code != errHeader || n.subcode != subNotSync {
+		t.Errorf("bad marker: err = %v", err)
+	}
+	b = marshalKeepalive()
+	b[18] = 9
+	if _, _, err := readMessage(bytes.NewReader(b)); !errors.As(err, &n) || n.subcode != subBadType {
+		t.Errorf("bad type: err = %v", err)
+	}
+}
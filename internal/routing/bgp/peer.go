@@ -0,0 +1,452 @@
+package bgp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+)
+
+// maxPrefixesPerUpdate keeps advertisements under the 4096-byte message
+// limit for either family.
+const maxPrefixesPerUpdate = 200
+
+// Peer is a configured BGP neighbor.
+type Peer struct {
+	addr        netip.Addr
+	port        int
+	remoteAS    uint32
+	description string
+	holdTime    time.Duration
+	passive     bool
+	table       int
+	importList  *prefixList
+	exportList  *prefixList
+
+	speaker  *Speaker
+	incoming chan net.Conn
+
+	mu            sync.Mutex
+	state         State
+	remoteID      netip.Addr
+	establishedAt time.Time
+	advertised    int
+	lastError     string
+}
+
+func newPeer(s *Speaker, cfg config.Neighbor, defaultTable int, lists map[string]*prefixList) (*Peer, error) {
+	addr, err := netip.ParseAddr(cfg.IP)
+	if err != nil {
+		return nil, fmt.Errorf("bgp: neighbor %q: %w", cfg.IP, err)
+	}
+	if cfg.RemoteASN < 1 || cfg.RemoteASN > math.MaxUint32 {
+		return nil, fmt.Errorf("bgp: neighbor %s: remote_asn %d out of range", cfg.IP, cfg.RemoteASN)
+	}
+	p := &Peer{
+		addr:        addr.Unmap(),
+		port:        Port,
+		remoteAS:    uint32(cfg.RemoteASN),
+		description: cfg.Description,
+		holdTime:    defaultHoldTime,
+		passive:     cfg.Passive,
+		table:       defaultTable,
+		speaker:     s,
+		incoming:    make(chan net.Conn, 1),
+		state:       StateIdle,
+	}
+	if cfg.Port != 0 {
+		p.port = cfg.Port
+	}
+	if cfg.HoldTime != 0 {
+		p.holdTime = time.Duration(cfg.HoldTime) * time.Second
+	}
+	if cfg.Table != 0 {
+		p.table = cfg.Table
+	}
+	for _, ref := range []struct {
+		name string
+		list **prefixList
+	}{{cfg.Import, &p.importList}, {cfg.Export, &p.exportList}} {
+		if ref.name == "" {
+			continue
+		}
+		if *ref.list = lists[ref.name]; *ref.list == nil {
+			return nil, fmt.Errorf("bgp: neighbor %s: unknown prefix list %q", cfg.IP, ref.name)
+		}
+	}
+	return p, nil
+}
+
+func (p *Peer) ebgp() bool {
+	return p.remoteAS != p.speaker.localAS
+}
+
+// localPref is the LOCAL_PREF of r. It is only meaningful within an AS.
+func (p *Peer) localPref(r *route) uint32 {
+	if !p.ebgp() && r.attrs.hasLocalPref {
+		return r.attrs.localPref
+	}
+	return defaultLocalPref
+}
+
+func (p *Peer) setState(state State) {
+	p.mu.Lock()
+	p.state = state
+	p.mu.Unlock()
+}
+
+func (p *Peer) status() NeighborStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := NeighborStatus{
+		Address:            p.addr.String(),
+		RemoteAS:           p.remoteAS,
+		Description:        p.description,
+		State:              p.state,
+		EstablishedAt:      p.establishedAt,
+		Table:              p.table,
+		PrefixesAdvertised: p.advertised,
+		LastError:          p.lastError,
+	}
+	if p.remoteID.IsValid() {
+		st.RouterID = p.remoteID.String()
+	}
+	return st
+}
+
+// accept hands an incoming connection to the session loop. An existing
+// session is kept (RFC 4271 section 6.8) and the new connection refused.
+func (p *Peer) accept(conn net.Conn) {
+	p.mu.Lock()
+	busy := p.state == StateOpenSent || p.state == StateOpenConfirm || p.state == StateEstablished
+	p.mu.Unlock()
+	if !busy {
+		select {
+		case p.incoming <- conn:
+			return
+		default:
+		}
+	}
+	conn.Write((&notification{code: errCease, subcode: subCollision}).marshal())
+	conn.Close()
+}
+
+// run connects and runs sessions until ctx is done.
+func (p *Peer) run(ctx context.Context) {
+	var conn net.Conn
+	for {
+		var err error
+		if conn == nil {
+			conn, err = p.connect(ctx)
+		}
+		if conn != nil {
+			err = p.session(ctx, conn)
+			conn = nil
+		}
+		if ctx.Err() != nil {
+			p.setState(StateIdle)
+			return
+		}
+		p.down(err)
+
+		// ConnectRetry with jitter, so simultaneous opens do not repeat
+		retry := time.NewTimer(p.speaker.connectRetry * time.Duration(75+rand.IntN(26)) / 100)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return
+		case <-retry.C:
+		case conn = <-p.incoming:
+			retry.Stop()
+		}
+	}
+}
+
+// connect waits for the peer to connect and, unless passive, dials it.
+func (p *Peer) connect(ctx context.Context) (net.Conn, error) {
+	if p.passive {
+		p.setState(StateActive)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case conn := <-p.incoming:
+			return conn, nil
+		}
+	}
+
+	p.setState(StateConnect)
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	dialed := make(chan result, 1)
+	go func() {
+		var d net.Dialer
+		conn, err := d.DialContext(dialCtx, "tcp", netip.AddrPortFrom(p.addr, uint16(p.port)).String())
+		dialed <- result{conn, err}
+	}()
+
+	select {
+	case r := <-dialed:
+		return r.conn, r.err
+	case conn := <-p.incoming:
+		cancel()
+		if r := <-dialed; r.conn != nil {
+			r.conn.Close()
+		}
+		return conn, nil
+	case <-ctx.Done():
+		cancel()
+		if r := <-dialed; r.conn != nil {
+			r.conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// down records the end of a session and withdraws its routes.
+func (p *Peer) down(err error) {
+	p.speaker.peerDown(p)
+
+	p.mu.Lock()
+	wasEstablished := p.state == StateEstablished
+	p.state = StateIdle
+	p.establishedAt = time.Time{}
+	p.advertised = 0
+	if err != nil {
+		p.lastError = err.Error()
+	}
+	p.mu.Unlock()
+
+	if wasEstablished {
+		p.speaker.logger.Warn("BGP session down", "neighbor", p.addr, "error", err)
+	} else {
+		p.speaker.logger.Debug("BGP connection failed", "neighbor", p.addr, "error", err)
+	}
+}
+
+type message struct {
+	typ  uint8
+	body []byte
+}
+
+// session runs the OPEN exchange and the established session on conn.
+func (p *Peer) session(ctx context.Context, conn net.Conn) (err error) {
+	done := make(chan struct{})
+	defer func() {
+		var n *notification
+		if ctx.Err() != nil {
+			n = &notification{code: errCease, subcode: subShutdown}
+		} else if !errors.As(err, &n) || n.received {
+			n = nil
+		}
+		if n != nil {
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			conn.Write(n.marshal())
+		}
+		conn.Close()
+		close(done)
+	}()
+
+	msgs := make(chan message)
+	readErr := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			typ, body, err := readMessage(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- message{typ, body}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s := p.speaker
+	local := conn.LocalAddr().(*net.TCPAddr).AddrPort().Addr().Unmap()
+	p.setState(StateOpenSent)
+	ours := &open{
+		as:       s.localAS,
+		holdTime: uint16(p.holdTime / time.Second),
+		id:       s.routerID,
+		families: []family{familyIPv4, familyIPv6},
+	}
+	if _, err := conn.Write(ours.marshal()); err != nil {
+		return err
+	}
+
+	var (
+		theirs    *open
+		hold      time.Duration
+		keepalive <-chan time.Time
+	)
+	holdTimer := time.NewTimer(openHoldTime)
+	defer holdTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case <-holdTimer.C:
+			return &notification{code: errHoldTimer}
+		case <-keepalive:
+			if _, err := conn.Write(marshalKeepalive()); err != nil {
+				return err
+			}
+		case m := <-msgs:
+			if hold > 0 {
+				holdTimer.Reset(hold)
+			}
+			if m.typ == msgNotification {
+				return parseNotification(m.body)
+			}
+
+			p.mu.Lock()
+			state := p.state
+			p.mu.Unlock()
+
+			switch {
+			case state == StateOpenSent && m.typ == msgOpen:
+				if theirs, err = parseOpen(m.body); err != nil {
+					return err
+				}
+				if err := p.checkOpen(theirs); err != nil {
+					return err
+				}
+				// The smaller hold time wins; zero disables keepalives
+				hold = min(p.holdTime, time.Duration(theirs.holdTime)*time.Second)
+				if hold > 0 {
+					holdTimer.Reset(hold)
+					ticker := time.NewTicker(hold / 3)
+					defer ticker.Stop()
+					keepalive = ticker.C
+				} else {
+					holdTimer.Stop()
+				}
+				if _, err := conn.Write(marshalKeepalive()); err != nil {
+					return err
+				}
+				p.mu.Lock()
+				p.state = StateOpenConfirm
+				p.remoteID = theirs.id
+				p.mu.Unlock()
+
+			case state == StateOpenConfirm && m.typ == msgKeepalive:
+				if err := p.established(conn, local, theirs); err != nil {
+					return err
+				}
+
+			case state == StateEstablished && m.typ == msgKeepalive:
+
+			case state == StateEstablished && m.typ == msgUpdate:
+				if err := p.handleUpdate(m.body, theirs); err != nil {
+					return err
+				}
+
+			default:
+				return &notification{code: errFSM}
+			}
+		}
+	}
+}
+
+// checkOpen validates the peer's OPEN (RFC 4271 section 6.2).
+func (p *Peer) checkOpen(o *open) error {
+	if o.as != p.remoteAS {
+		return &notification{code: errOpen, subcode: subBadPeerAS}
+	}
+	if o.id.IsUnspecified() || o.id == p.speaker.routerID {
+		return &notification{code: errOpen, subcode: subBadBGPID}
+	}
+	if o.holdTime == 1 || o.holdTime == 2 {
+		return &notification{code: errOpen, subcode: subBadHoldTime}
+	}
+	return nil
+}
+
+// established moves the session to Established and advertises the
+// exported networks of the session's address family.
+func (p *Peer) established(conn net.Conn, local netip.Addr, theirs *open) error {
+	s := p.speaker
+	f := familyOf(local)
+	var prefixes []netip.Prefix
+	if theirs.supports(f) {
+		prefixes = s.exports(p, f)
+	}
+
+	attrs := pathAttrs{origin: originIGP}
+	if p.ebgp() {
+		attrs.asPath = []asSegment{{typ: segmentSequence, asns: []uint32{s.localAS}}}
+	} else {
+		attrs.localPref, attrs.hasLocalPref = defaultLocalPref, true
+	}
+	for i := 0; i < len(prefixes); i += maxPrefixesPerUpdate {
+		chunk := prefixes[i:min(i+maxPrefixesPerUpdate, len(prefixes))]
+		u := &update{attrs: attrs}
+		if f == familyIPv4 {
+			u.nlri, u.attrs.nextHop = chunk, local
+		} else {
+			u.reach6, u.nextHop6 = chunk, local
+		}
+		if _, err := conn.Write(u.marshal(theirs.as4)); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	p.state = StateEstablished
+	p.establishedAt = clock.Now()
+	p.advertised = len(prefixes)
+	p.lastError = ""
+	p.mu.Unlock()
+	s.logger.Info("BGP session established", "neighbor", p.addr, "as", p.remoteAS, "router_id", theirs.id, "advertised", len(prefixes))
+	return nil
+}
+
+// handleUpdate applies a received UPDATE to the RIB.
+func (p *Peer) handleUpdate(body []byte, theirs *open) error {
+	u, err := parseUpdate(body, theirs.as4)
+	if err != nil {
+		return err
+	}
+	s := p.speaker
+	if (len(u.nlri) > 0 || len(u.reach6) > 0) && p.ebgp() && u.attrs.firstAS() != p.remoteAS {
+		return &notification{code: errUpdate, subcode: subBadASPath}
+	}
+
+	// Routes carrying our own AS looped back to us (RFC 4271 section 9.1.2)
+	loop := u.attrs.contains(s.localAS)
+	var routes []*route
+	add := func(prefixes []netip.Prefix, nextHop netip.Addr) {
+		for _, prefix := range prefixes {
+			routes = append(routes, &route{
+				prefix:   prefix,
+				nextHop:  nextHop,
+				attrs:    u.attrs,
+				peerID:   theirs.id,
+				accepted: !loop && nextHop.IsValid() && !nextHop.IsLinkLocalUnicast() && p.importList.permits(prefix),
+			})
+		}
+	}
+	add(u.nlri, u.attrs.nextHop)
+	add(u.reach6, u.nextHop6)
+	s.update(p, u.withdrawn, routes)
+	return nil
+}
@@ -0,0 +1,57 @@
+package bgp
+
+import (
+	"fmt"
+	"net/netip"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// prefixList is a compiled [config.PrefixList].
+type prefixList struct {
+	name  string
+	rules []prefixRule
+}
+
+type prefixRule struct {
+	prefix netip.Prefix
+	permit bool
+	ge, le int
+}
+
+func compilePrefixList(cfg config.PrefixList) (*prefixList, error) {
+	pl := &prefixList{name: cfg.Name}
+	for i, r := range cfg.Rules {
+		prefix, err := netip.ParsePrefix(r.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("prefix list %s rule %d: %w", cfg.Name, i, err)
+		}
+		rule := prefixRule{prefix: prefix.Masked(), permit: r.Action != "deny", ge: r.GE, le: r.LE}
+		// Without ge/le a rule matches the prefix exactly
+		if rule.ge == 0 {
+			rule.ge = prefix.Bits()
+		}
+		if rule.le == 0 {
+			rule.le = prefix.Addr().BitLen()
+			if r.GE == 0 {
+				rule.le = prefix.Bits()
+			}
+		}
+		pl.rules = append(pl.rules, rule)
+	}
+	return pl, nil
+}
+
+// permits applies the first matching rule. A nil list permits everything.
+func (pl *prefixList) permits(p netip.Prefix) bool {
+	if pl == nil {
+		return true
+	}
+	for _, r := range pl.rules {
+		if r.prefix.Addr().Is4() == p.Addr().Is4() && r.prefix.Contains(p.Addr()) &&
+			p.Bits() >= r.ge && p.Bits() <= r.le {
+			return r.permit
+		}
+	}
+	return false
+}
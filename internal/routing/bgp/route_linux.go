@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package bgp
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkRoutes installs routes tagged "proto bgp", so they can be told
+// apart from static and kernel routes.
+type netlinkRoutes struct{}
+
+func newRouteInstaller() routeInstaller {
+	return netlinkRoutes{}
+}
+
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
+
+func (netlinkRoutes) Replace(table int, prefix netip.Prefix, nextHop netip.Addr) error {
+	return netlink.RouteReplace(&netlink.Route{
+		Dst:      ipNet(prefix),
+		Gw:       nextHop.AsSlice(),
+		Table:    table,
+		Protocol: unix.RTPROT_BGP,
+	})
+}
+
+func (netlinkRoutes) Delete(table int, prefix netip.Prefix) error {
+	return netlink.RouteDel(&netlink.Route{
+		Dst:      ipNet(prefix),
+		Table:    table,
+		Protocol: unix.RTPROT_BGP,
+	})
+}
+
+func (netlinkRoutes) Flush(tables []int) error {
+	for _, table := range tables {
+		filter := &netlink.Route{Table: table, Protocol: unix.RTPROT_BGP}
+		routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, filter, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+		if err != nil {
+			return err
+		}
+		for i := range routes {
+			if err := netlink.RouteDel(&routes[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package bgp
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errUnsupported = errors.New("BGP route installation is only supported on Linux")
+
+// unsupportedRoutes is used on non-Linux platforms. Sessions still come
+// up, but learned routes are not installed.
+type unsupportedRoutes struct{}
+
+func newRouteInstaller() routeInstaller {
+	return unsupportedRoutes{}
+}
+
+func (unsupportedRoutes) Replace(table int, prefix netip.Prefix, nextHop netip.Addr) error {
+	return errUnsupported
+}
+
+func (unsupportedRoutes) Delete(table int, prefix netip.Prefix) error {
+	return errUnsupported
+}
+
+func (unsupportedRoutes) Flush(tables []int) error {
+	return nil
+}
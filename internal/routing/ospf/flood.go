@@ -0,0 +1,283 @@
+package ospf
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"slices"
+)
+
+// lsdb is a link state database of one flooding scope.
+type lsdb struct {
+	lsas map[lsaKey]*lsa
+}
+
+func newLSDB() *lsdb {
+	return &lsdb{lsas: make(map[lsaKey]*lsa)}
+}
+
+// sorted returns the LSAs ordered by type, link state ID and advertising
+// router.
+func (db *lsdb) sorted() []*lsa {
+	out := make([]*lsa, 0, len(db.lsas))
+	for _, l := range db.lsas {
+		out = append(out, l)
+	}
+	slices.SortFunc(out, func(a, b *lsa) int { return compareKeys(a.key(), b.key()) })
+	return out
+}
+
+func compareKeys(a, b lsaKey) int {
+	return cmp.Or(cmp.Compare(a.typ, b.typ), cmp.Compare(a.id, b.id), cmp.Compare(a.adv, b.adv))
+}
+
+// maxAged returns a copy of the LSA aged to MaxAge, which flushes it
+// from the routing domain (RFC 2328 section 14.1).
+func (l *lsa) maxAged() *lsa {
+	c := *l
+	c.age = maxAge
+	c.raw = bytes.Clone(l.raw)
+	binary.BigEndian.PutUint16(c.raw[0:2], maxAge)
+	return &c
+}
+
+// dbFor returns the database holding LSAs of type typ received on i.
+func (inst *instance) dbFor(i *iface, typ uint16) *lsdb {
+	switch scopeOf(inst.version, typ) {
+	case scopeLink:
+		return i.db
+	case scopeArea:
+		return i.area.db
+	case scopeAS:
+		return inst.asdb
+	}
+	return nil
+}
+
+func (inst *instance) lookup(i *iface, key lsaKey) *lsa {
+	if db := inst.dbFor(i, key.typ); db != nil {
+		return db.lsas[key]
+	}
+	return nil
+}
+
+// summaryList lists the headers of the LSAs described to a neighbor on i
+// during database exchange.
+func (inst *instance) summaryList(i *iface) []lsaHeader {
+	now := inst.r.now
+	var out []lsaHeader
+	add := func(db *lsdb) {
+		for _, l := range db.sorted() {
+			out = append(out, l.headerAt(now))
+		}
+	}
+	add(i.db)
+	add(i.area.db)
+	if !i.area.stub {
+		add(inst.asdb)
+	}
+	return out
+}
+
+// exchanging reports whether any neighbor is exchanging databases, which
+// keeps MaxAge LSAs in the database (RFC 2328 section 14).
+func (inst *instance) exchanging() bool {
+	for _, i := range inst.ifaces {
+		for _, n := range i.nbrs {
+			if n.state == nbrExchange || n.state == nbrLoading {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (inst *instance) onRetrans(l *lsa) bool {
+	for _, i := range inst.ifaces {
+		for _, n := range i.nbrs {
+			if n.retrans[l.key()] == l {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// install replaces old, which may be nil, with l (RFC 2328 section 13.2).
+func (inst *instance) install(db *lsdb, l, old *lsa) {
+	now := inst.r.now
+	l.rcvd = now
+	db.lsas[l.key()] = l
+	if old == nil {
+		inst.spfPending = true
+		return
+	}
+	for _, i := range inst.ifaces {
+		for _, n := range i.nbrs {
+			if n.retrans[old.key()] == old {
+				delete(n.retrans, old.key())
+			}
+		}
+	}
+	if old.options != l.options || old.ageAt(now) == maxAge || l.age == maxAge || !bytes.Equal(old.raw[lsaHeaderLen:], l.raw[lsaHeaderLen:]) {
+		inst.spfPending = true
+	}
+}
+
+// recvLSU processes a Link State Update (RFC 2328 section 13).
+func (inst *instance) recvLSU(n *neighbor, raws [][]byte) {
+	if n.state < nbrExchange {
+		return
+	}
+	i := n.i
+	now := inst.r.now
+	for _, raw := range raws {
+		l, err := parseLSA(raw, inst.version)
+		if err != nil {
+			inst.r.logger.Debug("Invalid LSA", "interface", i.name, "neighbor", idString(n.id), "error", err)
+			continue
+		}
+		sc := scopeOf(inst.version, l.typ)
+		if sc == scopeNone || sc == scopeAS && i.area.stub {
+			continue
+		}
+		db := inst.dbFor(i, l.typ)
+		key := l.key()
+		cur := db.lsas[key]
+
+		if l.age == maxAge && cur == nil && !inst.exchanging() {
+			i.sendAck(n, l.lsaHeader)
+			continue
+		}
+		c := 1
+		if cur != nil {
+			h := cur.headerAt(now)
+			c = compareLSA(&l.lsaHeader, &h)
+		}
+		switch {
+		case c > 0:
+			if cur != nil && cur.adv != inst.r.routerID && now-cur.rcvd < minLSArrival {
+				continue
+			}
+			inst.install(db, l, cur)
+			var a *area
+			var link *iface
+			switch sc {
+			case scopeLink:
+				link = i
+			case scopeArea:
+				a = i.area
+			}
+			floodedBack := inst.flood(l, a, link, n)
+			n.removeRequest(key)
+			if !floodedBack && (i.state != ifBackup || n.ident() == i.dr) {
+				i.delayAck(l.lsaHeader)
+			}
+			// Our own LSAs from a previous run are superseded or
+			// flushed by the next origination pass.
+
+		case n.request(key) != nil:
+			n.mismatch("bad link state request")
+			return
+
+		case c == 0:
+			if n.retrans[key] != nil {
+				// Implied acknowledgement
+				delete(n.retrans, key)
+				if i.state == ifBackup && n.ident() == i.dr {
+					i.delayAck(l.lsaHeader)
+				}
+			} else {
+				i.sendAck(n, l.lsaHeader)
+			}
+
+		default:
+			if cur.ageAt(now) == maxAge && cur.seq == maxSeq {
+				continue
+			}
+			i.sendUpdate(n.dst(), [][]byte{cur.encodeAt(now)})
+		}
+	}
+}
+
+// flood sends l out of the interfaces in its flooding scope: the area a,
+// the interface link, or the whole AS when both are nil. from is the
+// neighbor it was received from, nil for self-originated LSAs. It reports
+// whether the LSA went back out the receiving interface (RFC 2328 section
+// 13.3).
+func (inst *instance) flood(l *lsa, a *area, link *iface, from *neighbor) (floodedBack bool) {
+	var out []*iface
+	switch {
+	case link != nil:
+		out = []*iface{link}
+	case a != nil:
+		out = a.ifaces
+	default:
+		for _, i := range inst.ifaces {
+			if !i.area.stub {
+				out = append(out, i)
+			}
+		}
+	}
+
+	now := inst.r.now
+	for _, i := range out {
+		added := false
+		for _, nb := range i.sortedNeighbors() {
+			if nb.state < nbrExchange {
+				continue
+			}
+			if nb.state != nbrFull {
+				if req := nb.request(l.key()); req != nil {
+					c := compareLSA(&l.lsaHeader, req)
+					if c < 0 {
+						continue
+					}
+					nb.removeRequest(l.key())
+					if c == 0 {
+						continue
+					}
+				}
+			}
+			if nb == from {
+				continue
+			}
+			nb.addRetrans(l)
+			added = true
+		}
+		if !added {
+			continue
+		}
+		if from != nil && i == from.i {
+			if ident := from.ident(); ident == i.dr || ident == i.bdr || i.state == ifBackup {
+				continue
+			}
+			floodedBack = true
+		}
+		i.sendUpdate(i.floodDst(), [][]byte{l.encodeAt(now)})
+	}
+	return floodedBack
+}
+
+// age floods LSAs that reached MaxAge and removes them once every
+// neighbor has acknowledged them (RFC 2328 section 14).
+func (inst *instance) age() {
+	now := inst.r.now
+	exchanging := inst.exchanging()
+	inst.databases(func(db *lsdb, a *area, link *iface) {
+		for _, l := range db.sorted() {
+			if l.ageAt(now) < maxAge {
+				continue
+			}
+			if l.age < maxAge {
+				flushed := l.maxAged()
+				inst.install(db, flushed, l)
+				inst.flood(flushed, a, link, nil)
+				continue
+			}
+			if !exchanging && !inst.onRetrans(l) {
+				delete(db.lsas, l.key())
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package ospf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// Before the native router, OSPF ran in FRR's ospfd. Upgraded systems
+// still have it enabled, and left running it would compete with the
+// router for protocol 89 and the routing table.
+
+// frrDaemonsFile is FRR's list of enabled daemons.
+const frrDaemonsFile = "/etc/frr/daemons"
+
+var ospfdEnabled = regexp.MustCompile(`(?m)^ospfd=yes$`)
+
+func runCommand(name string, args ...string) error {
+	return exec.Command(name, args...).Run()
+}
+
+// retireFRR stops FRR, removes it from the boot runlevel and disables
+// ospfd in its daemons file. It only acts while the file enables ospfd,
+// so it runs once per upgraded system. It reports whether FRR was stopped.
+func retireFRR(path string, run func(name string, args ...string) error) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !ospfdEnabled.Match(data) {
+		return false, nil
+	}
+
+	// FRR ran nothing else for us; BGP is native too
+	if err := run("rc-service", "frr", "stop"); err != nil {
+		return false, fmt.Errorf("failed to stop frr: %w", err)
+	}
+	// Best effort: FRR may not have been in the runlevel
+	_ = run("rc-update", "del", "frr")
+
+	if err := os.WriteFile(path, ospfdEnabled.ReplaceAll(data, []byte("ospfd=no")), 0644); err != nil {
+		return true, fmt.Errorf("failed to disable ospfd: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,553 @@
+package ospf
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+type ifType uint8
+
+const (
+	ifBroadcast ifType = iota
+	ifPointToPoint
+)
+
+func (t ifType) String() string {
+	if t == ifPointToPoint {
+		return "point-to-point"
+	}
+	return "broadcast"
+}
+
+// ifState is the state of an interface (RFC 2328 section 9.1).
+type ifState uint8
+
+const (
+	ifDown ifState = iota
+	ifLoopback
+	ifWaiting
+	ifP2P
+	ifDROther
+	ifBackup
+	ifDR
+)
+
+var ifStateNames = [...]string{"down", "loopback", "waiting", "point-to-point", "dr-other", "backup", "dr"}
+
+func (s ifState) String() string {
+	return ifStateNames[s]
+}
+
+// ifaceSpec is the configuration an interface runs with. A change
+// restarts the interface.
+type ifaceSpec struct {
+	sys      sysIface
+	area     *area
+	typ      ifType
+	addr     netip.Addr     // OSPFv2: primary address; OSPFv3: link-local address
+	prefixes []netip.Prefix // Addresses in the area's networks
+	cost     uint16
+	helloInt uint32
+	deadInt  uint32
+	priority uint8
+	passive  bool
+}
+
+func (s *ifaceSpec) signature() string {
+	return fmt.Sprint(s.sys.index, s.sys.mtu, s.sys.loopback, s.area.id, s.typ, s.addr, s.prefixes, s.cost, s.helloInt, s.deadInt, s.priority, s.passive)
+}
+
+// iface is an interface running one protocol version.
+type iface struct {
+	ifaceSpec
+	inst     *instance
+	name     string
+	index    int
+	mtu      int
+	loopback bool
+	sig      string
+	conn     endpoint // Nil for passive interfaces
+
+	state     ifState
+	dr, bdr   uint32 // Identifiers: addresses in OSPFv2, router IDs in OSPFv3
+	nbrs      map[uint32]*neighbor
+	nextHello uint64
+	waitUntil uint64
+	db        *lsdb       // Link-scoped LSAs (OSPFv3)
+	acks      []lsaHeader // Delayed acknowledgements
+}
+
+func newIface(inst *instance, spec ifaceSpec) *iface {
+	return &iface{
+		ifaceSpec: spec,
+		inst:      inst,
+		name:      spec.sys.name,
+		index:     spec.sys.index,
+		mtu:       spec.sys.mtu,
+		loopback:  spec.sys.loopback,
+		sig:       spec.signature(),
+		nbrs:      make(map[uint32]*neighbor),
+		db:        newLSDB(),
+	}
+}
+
+// ifID is the OSPFv3 Interface ID.
+func (i *iface) ifID() uint32 {
+	return uint32(i.index)
+}
+
+// self is the router's identifier on the interface for DR election.
+func (i *iface) self() uint32 {
+	if i.inst.is4() {
+		return u32(i.addr)
+	}
+	return i.inst.r.routerID
+}
+
+func (i *iface) allSPF() netip.Addr {
+	if i.inst.is4() {
+		return allSPFRouters4
+	}
+	return allSPFRouters6
+}
+
+func (i *iface) allDR() netip.Addr {
+	if i.inst.is4() {
+		return allDRouters4
+	}
+	return allDRouters6
+}
+
+// floodDst is the destination of multicast updates and acknowledgements
+// (RFC 2328 section 13.3).
+func (i *iface) floodDst() netip.Addr {
+	if i.typ == ifPointToPoint || i.state == ifDR || i.state == ifBackup {
+		return i.allSPF()
+	}
+	return i.allDR()
+}
+
+// maxPayload is the largest OSPF packet body that fits the MTU.
+func (i *iface) maxPayload() int {
+	ipHeader := 20
+	if !i.inst.is4() {
+		ipHeader = 40
+	}
+	return max(i.mtu, 576) - ipHeader - headerLen(i.inst.version)
+}
+
+func (i *iface) up() {
+	now := i.inst.r.now
+	switch {
+	case i.loopback:
+		i.state = ifLoopback
+	case i.typ == ifPointToPoint:
+		i.state = ifP2P
+	case i.passive:
+		// The only router on the network
+		i.state, i.dr = ifDR, i.self()
+	case i.priority == 0:
+		i.state = ifDROther
+	default:
+		i.state = ifWaiting
+		i.waitUntil = now + uint64(i.deadInt)
+	}
+	i.nextHello = now
+}
+
+func (i *iface) down() {
+	for _, n := range i.sortedNeighbors() {
+		n.kill()
+	}
+	if i.conn != nil {
+		i.conn.Close()
+		i.conn = nil
+	}
+	i.state = ifDown
+}
+
+// goodbye sends a hello without neighbors, so they drop the adjacency
+// without waiting for the dead interval.
+func (i *iface) goodbye() {
+	if i.conn == nil {
+		return
+	}
+	h := i.hello()
+	h.neighbors, h.dr, h.bdr = nil, 0, 0
+	i.send(pktHello, h.marshal(i.inst.version), i.allSPF())
+}
+
+func (i *iface) send(typ uint8, body []byte, dst netip.Addr) {
+	if i.conn == nil {
+		return
+	}
+	pkt := marshalPacket(i.inst.version, header{typ: typ, routerID: i.inst.r.routerID, areaID: i.area.id}, body)
+	if err := i.conn.Send(pkt, dst); err != nil {
+		i.inst.r.logger.Debug("OSPF send failed", "interface", i.name, "dst", dst, "error", err)
+	}
+}
+
+func (i *iface) hello() *hello {
+	h := &hello{
+		ifID:     i.ifID(),
+		interval: uint16(i.helloInt),
+		options:  i.inst.options(i.area),
+		priority: i.priority,
+		dead:     i.deadInt,
+		dr:       i.dr,
+		bdr:      i.bdr,
+	}
+	if i.inst.is4() {
+		h.mask = maskOf(i.prefixes[0].Bits())
+	}
+	for _, n := range i.sortedNeighbors() {
+		if n.state >= nbrInit {
+			h.neighbors = append(h.neighbors, n.id)
+		}
+	}
+	return h
+}
+
+func (i *iface) tick() {
+	now := i.inst.r.now
+	if i.conn == nil || i.state == ifDown {
+		return
+	}
+	if now >= i.nextHello {
+		i.send(pktHello, i.hello().marshal(i.inst.version), i.allSPF())
+		i.nextHello = now + uint64(i.helloInt)
+	}
+	if i.state == ifWaiting && now >= i.waitUntil {
+		i.elect()
+	}
+	for _, n := range i.sortedNeighbors() {
+		if now-n.lastHello >= uint64(i.deadInt) {
+			i.inst.r.logger.Info("OSPF neighbor dead", "interface", i.name, "neighbor", idString(n.id))
+			n.kill()
+			i.neighborChange()
+			continue
+		}
+		n.tick()
+	}
+	i.flushAcks()
+}
+
+// receive handles a packet from the interface's endpoint.
+func (i *iface) receive(pkt []byte, src, dst netip.Addr) {
+	inst := i.inst
+	h, body, err := parsePacket(pkt, inst.version)
+	if err != nil {
+		inst.r.logger.Debug("Invalid OSPF packet", "interface", i.name, "src", src, "error", err)
+		return
+	}
+	if h.areaID != i.area.id || h.instance != 0 || h.routerID == inst.r.routerID {
+		return
+	}
+	if inst.is4() && i.typ == ifBroadcast && !i.prefixes[0].Contains(src) {
+		return
+	}
+	if dst == i.allDR() && i.state != ifDR && i.state != ifBackup {
+		return
+	}
+	if h.typ == pktHello {
+		hl, err := parseHello(body, inst.version)
+		if err != nil {
+			return
+		}
+		i.recvHello(h.routerID, src, hl)
+		return
+	}
+
+	n := i.nbrs[h.routerID]
+	if n == nil {
+		return
+	}
+	switch h.typ {
+	case pktDBD:
+		if d, err := parseDBD(body, inst.version); err == nil {
+			n.recvDBD(d)
+		}
+	case pktLSR:
+		if keys, err := parseLSR(body); err == nil {
+			n.recvLSR(keys)
+		}
+	case pktLSU:
+		if lsas, err := parseLSU(body); err == nil {
+			inst.recvLSU(n, lsas)
+		}
+	case pktAck:
+		if headers, err := parseAck(body, inst.version); err == nil {
+			n.recvAck(headers)
+		}
+	}
+}
+
+// recvHello processes a Hello (RFC 2328 section 10.5).
+func (i *iface) recvHello(id uint32, src netip.Addr, h *hello) {
+	inst := i.inst
+	if uint32(h.interval) != i.helloInt || h.dead != i.deadInt {
+		inst.r.logger.Debug("OSPF hello with mismatched timers", "interface", i.name, "neighbor", idString(id))
+		return
+	}
+	if inst.is4() && i.typ == ifBroadcast && h.mask != maskOf(i.prefixes[0].Bits()) {
+		return
+	}
+	if h.options&optE != inst.options(i.area)&optE {
+		inst.r.logger.Debug("OSPF hello with mismatched stub area flag", "interface", i.name, "neighbor", idString(id))
+		return
+	}
+
+	n := i.nbrs[id]
+	if n == nil {
+		n = newNeighbor(i, id)
+		i.nbrs[id] = n
+	}
+	n.addr, n.ifID = src, h.ifID
+	n.lastHello = inst.r.now
+	ident := n.ident()
+	oldPriority := n.priority
+	wasDR, wasBDR := n.dr == ident, n.bdr == ident
+	n.priority, n.dr, n.bdr = h.priority, h.dr, h.bdr
+	if n.state == nbrDown {
+		n.setState(nbrInit)
+	}
+
+	if !slices.Contains(h.neighbors, inst.r.routerID) {
+		if n.state >= nbrTwoWay {
+			n.reset(nbrInit)
+			i.neighborChange()
+		}
+		return
+	}
+	changed := false
+	if n.state == nbrInit {
+		n.twoWay()
+		changed = true
+	}
+	if i.typ != ifBroadcast {
+		return
+	}
+
+	isDR, isBDR := h.dr == ident, h.bdr == ident
+	if i.state == ifWaiting && (isBDR || isDR && h.bdr == 0) {
+		// BackupSeen
+		i.elect()
+		return
+	}
+	if changed || n.priority != oldPriority || isDR != wasDR || isBDR != wasBDR {
+		i.neighborChange()
+	}
+}
+
+func (i *iface) neighborChange() {
+	if i.typ == ifBroadcast && i.state >= ifDROther {
+		i.elect()
+	}
+}
+
+// elect runs the Designated Router election (RFC 2328 section 9.4).
+func (i *iface) elect() {
+	type candidate struct {
+		id, ident uint32
+		priority  uint8
+		dr, bdr   uint32
+	}
+	var others []candidate
+	for _, n := range i.sortedNeighbors() {
+		if n.state >= nbrTwoWay && n.priority > 0 {
+			others = append(others, candidate{n.id, n.ident(), n.priority, n.dr, n.bdr})
+		}
+	}
+	me := i.self()
+	candidates := func() []candidate {
+		if i.priority == 0 {
+			return others
+		}
+		return append(slices.Clone(others), candidate{i.inst.r.routerID, me, i.priority, i.dr, i.bdr})
+	}
+	better := func(a, b *candidate) bool {
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.id > b.id
+	}
+	calc := func(cs []candidate) (dr, bdr uint32) {
+		var best *candidate
+		declared := false
+		for k := range cs {
+			c := &cs[k]
+			if c.dr == c.ident {
+				continue
+			}
+			decl := c.bdr == c.ident
+			if best == nil || decl && !declared || decl == declared && better(c, best) {
+				best, declared = c, decl
+			}
+		}
+		if best != nil {
+			bdr = best.ident
+		}
+		best = nil
+		for k := range cs {
+			c := &cs[k]
+			if c.dr == c.ident && (best == nil || better(c, best)) {
+				best = c
+			}
+		}
+		if best != nil {
+			return best.ident, bdr
+		}
+		return bdr, 0
+	}
+
+	oldDR, oldBDR, oldState := i.dr, i.bdr, i.state
+	dr, bdr := calc(candidates())
+	if (dr == me) != (oldDR == me) || (bdr == me) != (oldBDR == me) {
+		i.dr, i.bdr = dr, bdr
+		dr, bdr = calc(candidates())
+	}
+	i.dr, i.bdr = dr, bdr
+	switch {
+	case dr == me:
+		i.state = ifDR
+	case bdr == me:
+		i.state = ifBackup
+	default:
+		i.state = ifDROther
+	}
+	if i.state != oldState || dr != oldDR || bdr != oldBDR {
+		i.inst.r.logger.Info("OSPF DR election", "interface", i.name, "state", i.state, "dr", idString(dr), "bdr", idString(bdr))
+		i.adjOK()
+	}
+}
+
+// adjacent reports whether an adjacency should form with n (RFC 2328
+// section 10.4).
+func (i *iface) adjacent(n *neighbor) bool {
+	if i.typ == ifPointToPoint || i.state == ifDR || i.state == ifBackup {
+		return true
+	}
+	ident := n.ident()
+	return ident == i.dr || ident == i.bdr
+}
+
+// adjOK brings adjacencies up or down after an election.
+func (i *iface) adjOK() {
+	for _, n := range i.sortedNeighbors() {
+		switch {
+		case n.state == nbrTwoWay && i.adjacent(n):
+			n.startExchange()
+		case n.state >= nbrExStart && !i.adjacent(n):
+			n.reset(nbrTwoWay)
+		}
+	}
+}
+
+func (i *iface) sortedNeighbors() []*neighbor {
+	out := make([]*neighbor, 0, len(i.nbrs))
+	for _, n := range i.nbrs {
+		out = append(out, n)
+	}
+	slices.SortFunc(out, func(a, b *neighbor) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return out
+}
+
+// neighborByIdent finds a neighbor by its DR election identifier.
+func (i *iface) neighborByIdent(ident uint32) *neighbor {
+	for _, n := range i.nbrs {
+		if n.ident() == ident {
+			return n
+		}
+	}
+	return nil
+}
+
+// fullNeighbors lists the fully adjacent neighbors.
+func (i *iface) fullNeighbors() []*neighbor {
+	var out []*neighbor
+	for _, n := range i.sortedNeighbors() {
+		if n.state == nbrFull {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// transit reports whether a broadcast interface is a transit network:
+// the router is fully adjacent to the DR, or is the DR with at least one
+// full neighbor (RFC 2328 section 12.4.1.2).
+func (i *iface) transit() bool {
+	if i.typ != ifBroadcast || i.passive || i.state < ifDROther || i.dr == 0 {
+		return false
+	}
+	if i.state == ifDR {
+		return len(i.fullNeighbors()) > 0
+	}
+	n := i.neighborByIdent(i.dr)
+	return n != nil && n.state == nbrFull
+}
+
+func (i *iface) sendAck(n *neighbor, h lsaHeader) {
+	i.send(pktAck, marshalAck([]lsaHeader{h}, i.inst.version), n.dst())
+}
+
+func (i *iface) delayAck(h lsaHeader) {
+	i.acks = append(i.acks, h)
+}
+
+func (i *iface) flushAcks() {
+	per := i.maxPayload() / lsaHeaderLen
+	for len(i.acks) > 0 {
+		n := min(per, len(i.acks))
+		i.send(pktAck, marshalAck(i.acks[:n], i.inst.version), i.floodDst())
+		i.acks = i.acks[n:]
+	}
+	i.acks = nil
+}
+
+// sendUpdate sends encoded LSAs in as few Link State Updates as fit.
+func (i *iface) sendUpdate(dst netip.Addr, lsas [][]byte) {
+	budget := i.maxPayload() - 4
+	var batch [][]byte
+	size := 0
+	for _, l := range lsas {
+		if len(batch) > 0 && size+len(l) > budget {
+			i.send(pktLSU, marshalLSU(batch), dst)
+			batch, size = nil, 0
+		}
+		batch = append(batch, l)
+		size += len(l)
+	}
+	if len(batch) > 0 {
+		i.send(pktLSU, marshalLSU(batch), dst)
+	}
+}
+
+func (i *iface) status() InterfaceStatus {
+	st := InterfaceStatus{
+		Name:      i.name,
+		Version:   i.inst.version,
+		Area:      idString(i.area.id),
+		Type:      i.typ.String(),
+		State:     i.state.String(),
+		Cost:      int(i.cost),
+		Neighbors: len(i.nbrs),
+		Passive:   i.passive,
+	}
+	if i.addr.IsValid() {
+		st.Address = i.addr.String()
+	}
+	if i.typ == ifBroadcast && !i.passive {
+		if i.dr != 0 {
+			st.DR = idString(i.dr)
+		}
+		if i.bdr != 0 {
+			st.BDR = idString(i.bdr)
+		}
+	}
+	return st
+}
@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package ospf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// tosInternetworkControl is the IP precedence OSPF packets are sent with
+// (RFC 2328 A.1).
+const tosInternetworkControl = 0xc0
+
+// rawEndpoint is a raw IP socket bound to one interface.
+type rawEndpoint struct {
+	ifc  sysIface
+	conn net.PacketConn
+	p4   *ipv4.PacketConn
+	p6   *ipv6.PacketConn
+}
+
+// openEndpoint opens a raw OSPF socket on ifc and joins AllSPFRouters
+// and AllDRouters.
+func openEndpoint(ifc sysIface, version int) (endpoint, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifc.name)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}}
+	netIf := &net.Interface{Index: ifc.index, Name: ifc.name}
+
+	if version == 2 {
+		conn, err := lc.ListenPacket(context.Background(), fmt.Sprintf("ip4:%d", Protocol), "0.0.0.0")
+		if err != nil {
+			return nil, err
+		}
+		p := ipv4.NewPacketConn(conn)
+		for _, err := range []error{
+			p.JoinGroup(netIf, &net.IPAddr{IP: allSPFRouters4.AsSlice()}),
+			p.JoinGroup(netIf, &net.IPAddr{IP: allDRouters4.AsSlice()}),
+			p.SetMulticastInterface(netIf),
+			p.SetMulticastTTL(1),
+			p.SetMulticastLoopback(false),
+			p.SetTTL(1),
+			p.SetTOS(tosInternetworkControl),
+			p.SetControlMessage(ipv4.FlagDst, true),
+		} {
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return &rawEndpoint{ifc: ifc, conn: conn, p4: p}, nil
+	}
+
+	conn, err := lc.ListenPacket(context.Background(), fmt.Sprintf("ip6:%d", Protocol), "::")
+	if err != nil {
+		return nil, err
+	}
+	p := ipv6.NewPacketConn(conn)
+	for _, err := range []error{
+		p.JoinGroup(netIf, &net.IPAddr{IP: allSPFRouters6.AsSlice()}),
+		p.JoinGroup(netIf, &net.IPAddr{IP: allDRouters6.AsSlice()}),
+		p.SetMulticastInterface(netIf),
+		p.SetMulticastHopLimit(1),
+		p.SetMulticastLoopback(false),
+		p.SetHopLimit(1),
+		p.SetTrafficClass(tosInternetworkControl),
+		// The kernel computes and verifies the OSPFv3 checksum
+		p.SetChecksum(true, 12),
+		p.SetControlMessage(ipv6.FlagDst, true),
+	} {
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &rawEndpoint{ifc: ifc, conn: conn, p6: p}, nil
+}
+
+func (e *rawEndpoint) Send(pkt []byte, dst netip.Addr) error {
+	if e.p4 != nil {
+		_, err := e.p4.WriteTo(pkt, &ipv4.ControlMessage{IfIndex: e.ifc.index}, &net.IPAddr{IP: dst.AsSlice()})
+		return err
+	}
+	_, err := e.p6.WriteTo(pkt, &ipv6.ControlMessage{IfIndex: e.ifc.index}, &net.IPAddr{IP: dst.AsSlice(), Zone: e.ifc.name})
+	return err
+}
+
+func (e *rawEndpoint) Recv() ([]byte, netip.Addr, netip.Addr, error) {
+	buf := make([]byte, 65536)
+	var n int
+	var src net.Addr
+	var dst net.IP
+	var err error
+	if e.p4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, src, err = e.p4.ReadFrom(buf)
+		if cm != nil {
+			dst = cm.Dst
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, src, err = e.p6.ReadFrom(buf)
+		if cm != nil {
+			dst = cm.Dst
+		}
+	}
+	if err != nil {
+		return nil, netip.Addr{}, netip.Addr{}, err
+	}
+	var srcAddr, dstAddr netip.Addr
+	if ipAddr, ok := src.(*net.IPAddr); ok {
+		srcAddr, _ = netip.AddrFromSlice(ipAddr.IP)
+	}
+	dstAddr, _ = netip.AddrFromSlice(dst)
+	return buf[:n], srcAddr.Unmap(), dstAddr.Unmap(), nil
+}
+
+func (e *rawEndpoint) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package ospf
+
+import "errors"
+
+var errUnsupportedLink = errors.New("OSPF is only supported on Linux")
+
+func openEndpoint(ifc sysIface, version int) (endpoint, error) {
+	return nil, errUnsupportedLink
+}
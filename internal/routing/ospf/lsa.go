@@ -0,0 +1,775 @@
+package ospf
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+)
+
+// Architectural constants (RFC 2328 appendix B). Times are in seconds.
+const (
+	maxAge        = 3600
+	maxAgeDiff    = 900
+	lsRefreshTime = 1800
+	minLSInterval = 5
+	minLSArrival  = 1
+	infTransDelay = 1
+	lsInfinity    = 0xffffff
+
+	initialSeq int32 = -0x7fffffff // 0x80000001
+	maxSeq     int32 = 0x7fffffff
+)
+
+// lsaKind is the function of an LSA, independent of the OSPF version.
+type lsaKind uint8
+
+const (
+	kindUnknown     lsaKind = iota
+	kindRouter              // Type 1 / 0x2001
+	kindNetwork             // Type 2 / 0x2002
+	kindSummaryNet          // Type 3 / inter-area-prefix 0x2003
+	kindSummaryASBR         // Type 4 / inter-area-router 0x2004
+	kindExternal            // Type 5 / 0x4005
+	kindLink                // OSPFv3 link-LSA 0x0008
+	kindIntraPrefix         // OSPFv3 intra-area-prefix-LSA 0x2009
+)
+
+var (
+	lsTypesV2 = map[lsaKind]uint16{kindRouter: 1, kindNetwork: 2, kindSummaryNet: 3, kindSummaryASBR: 4, kindExternal: 5}
+	lsTypesV3 = map[lsaKind]uint16{
+		kindRouter: 0x2001, kindNetwork: 0x2002, kindSummaryNet: 0x2003, kindSummaryASBR: 0x2004,
+		kindExternal: 0x4005, kindLink: 0x0008, kindIntraPrefix: 0x2009,
+	}
+	kindNamesV2 = map[lsaKind]string{kindRouter: "router", kindNetwork: "network", kindSummaryNet: "summary", kindSummaryASBR: "asbr-summary", kindExternal: "external"}
+	kindNamesV3 = map[lsaKind]string{
+		kindRouter: "router", kindNetwork: "network", kindSummaryNet: "inter-area-prefix", kindSummaryASBR: "inter-area-router",
+		kindExternal: "external", kindLink: "link", kindIntraPrefix: "intra-area-prefix",
+	}
+)
+
+func lsType(v int, k lsaKind) uint16 {
+	if v == 3 {
+		return lsTypesV3[k]
+	}
+	return lsTypesV2[k]
+}
+
+func kindOf(v int, typ uint16) lsaKind {
+	types := lsTypesV2
+	if v == 3 {
+		types = lsTypesV3
+	}
+	for k, t := range types {
+		if t == typ {
+			return k
+		}
+	}
+	return kindUnknown
+}
+
+func typeName(v int, typ uint16) string {
+	names := kindNamesV2
+	if v == 3 {
+		names = kindNamesV3
+	}
+	if name, ok := names[kindOf(v, typ)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", typ)
+}
+
+// scope is the flooding scope of an LSA.
+type scope uint8
+
+const (
+	scopeNone scope = iota // Not stored or flooded
+	scopeLink
+	scopeArea
+	scopeAS
+)
+
+// scopeOf returns the flooding scope. OSPFv2 LSAs of unknown types are
+// dropped. OSPFv3 encodes the scope in the S1/S2 bits; unknown types
+// without the U bit are flooded on the receiving link only (RFC 5340
+// section 4.5.1).
+func scopeOf(v int, typ uint16) scope {
+	if v == 2 {
+		switch kindOf(v, typ) {
+		case kindExternal:
+			return scopeAS
+		case kindUnknown:
+			return scopeNone
+		default:
+			return scopeArea
+		}
+	}
+	if kindOf(v, typ) == kindUnknown && typ&0x8000 == 0 {
+		return scopeLink
+	}
+	switch typ & 0x6000 {
+	case 0x0000:
+		return scopeLink
+	case 0x2000:
+		return scopeArea
+	case 0x4000:
+		return scopeAS
+	}
+	return scopeNone
+}
+
+// lsaKey identifies an LSA in a link state database.
+type lsaKey struct {
+	typ uint16
+	id  uint32
+	adv uint32
+}
+
+// lsaHeader is the LSA header (RFC 2328 A.4.1, RFC 5340 A.4.2).
+type lsaHeader struct {
+	age      uint16
+	options  uint8 // OSPFv2 only
+	typ      uint16
+	id       uint32
+	adv      uint32
+	seq      int32
+	checksum uint16
+	length   uint16
+}
+
+func (h *lsaHeader) key() lsaKey {
+	return lsaKey{typ: h.typ, id: h.id, adv: h.adv}
+}
+
+func parseLSAHeader(b []byte, v int) lsaHeader {
+	h := lsaHeader{
+		age:      binary.BigEndian.Uint16(b[0:2]),
+		id:       binary.BigEndian.Uint32(b[4:8]),
+		adv:      binary.BigEndian.Uint32(b[8:12]),
+		seq:      int32(binary.BigEndian.Uint32(b[12:16])),
+		checksum: binary.BigEndian.Uint16(b[16:18]),
+		length:   binary.BigEndian.Uint16(b[18:20]),
+	}
+	if v == 2 {
+		h.options, h.typ = b[2], uint16(b[3])
+	} else {
+		h.typ = binary.BigEndian.Uint16(b[2:4])
+	}
+	return h
+}
+
+func (h *lsaHeader) append(b []byte, v int) []byte {
+	b = binary.BigEndian.AppendUint16(b, h.age)
+	if v == 2 {
+		b = append(b, h.options, byte(h.typ))
+	} else {
+		b = binary.BigEndian.AppendUint16(b, h.typ)
+	}
+	b = binary.BigEndian.AppendUint32(b, h.id)
+	b = binary.BigEndian.AppendUint32(b, h.adv)
+	b = binary.BigEndian.AppendUint32(b, uint32(h.seq))
+	b = binary.BigEndian.AppendUint16(b, h.checksum)
+	return binary.BigEndian.AppendUint16(b, h.length)
+}
+
+// compareLSA reports whether a is newer (>0), older (<0) or the same
+// instance (0) as b (RFC 2328 section 13.1). Ages must be current.
+func compareLSA(a, b *lsaHeader) int {
+	switch {
+	case a.seq != b.seq:
+		return cmp.Compare(a.seq, b.seq)
+	case a.checksum != b.checksum:
+		return cmp.Compare(a.checksum, b.checksum)
+	case (a.age == maxAge) != (b.age == maxAge):
+		if a.age == maxAge {
+			return 1
+		}
+		return -1
+	case int(a.age)-int(b.age) > maxAgeDiff:
+		return -1
+	case int(b.age)-int(a.age) > maxAgeDiff:
+		return 1
+	}
+	return 0
+}
+
+// lsa is an LSA as stored in a link state database.
+type lsa struct {
+	lsaHeader
+	raw  []byte // Encoded LSA; the age field is rewritten when sent
+	body any    // Parsed body; nil for unknown OSPFv3 types
+	rcvd uint64 // Clock tick the LSA was installed at, the base for aging
+}
+
+// ageAt returns the age at clock tick now.
+func (l *lsa) ageAt(now uint64) uint16 {
+	if l.age >= maxAge {
+		return maxAge
+	}
+	age := uint64(l.age) + now - l.rcvd
+	if age >= maxAge {
+		return maxAge
+	}
+	return uint16(age)
+}
+
+// headerAt returns the header with the age at clock tick now.
+func (l *lsa) headerAt(now uint64) lsaHeader {
+	h := l.lsaHeader
+	h.age = l.ageAt(now)
+	return h
+}
+
+// encodeAt returns the encoded LSA as sent at clock tick now, aged by
+// InfTransDelay.
+func (l *lsa) encodeAt(now uint64) []byte {
+	b := append([]byte(nil), l.raw...)
+	age := min(l.ageAt(now)+infTransDelay, maxAge)
+	binary.BigEndian.PutUint16(b[0:2], age)
+	return b
+}
+
+// newLSA encodes an LSA with a fresh checksum. The body is decoded back,
+// so self-originated LSAs look exactly like received ones.
+func newLSA(v int, h lsaHeader, body []byte) *lsa {
+	h.length = uint16(lsaHeaderLen + len(body))
+	h.checksum = 0
+	raw := h.append(make([]byte, 0, h.length), v)
+	raw = append(raw, body...)
+	h.checksum = lsaChecksum(raw)
+	binary.BigEndian.PutUint16(raw[16:18], h.checksum)
+	decoded, _ := parseBody(v, &h, body)
+	return &lsa{lsaHeader: h, raw: raw, body: decoded}
+}
+
+// parseLSA decodes an encoded LSA after verifying its checksum.
+func parseLSA(raw []byte, v int) (*lsa, error) {
+	if len(raw) < lsaHeaderLen {
+		return nil, errShortPacket
+	}
+	h := parseLSAHeader(raw, v)
+	if int(h.length) != len(raw) {
+		return nil, fmt.Errorf("bad LSA length %d", h.length)
+	}
+	if !validLSAChecksum(raw) {
+		return nil, errors.New("bad LSA checksum")
+	}
+	body, err := parseBody(v, &h, raw[lsaHeaderLen:])
+	if err != nil {
+		return nil, fmt.Errorf("%s LSA %s: %w", typeName(v, h.typ), idString(h.id), err)
+	}
+	return &lsa{lsaHeader: h, raw: append([]byte(nil), raw...), body: body}, nil
+}
+
+// lsaChecksum computes the Fletcher checksum of an encoded LSA, which
+// covers everything but the age field (RFC 2328 section 12.1.7).
+func lsaChecksum(raw []byte) uint16 {
+	const off = 14 // Checksum offset after the age field
+	b := raw[2:]
+	var c0, c1 int
+	for i, x := range b {
+		if i == off || i == off+1 {
+			x = 0
+		}
+		c0 = (c0 + int(x)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	x := ((len(b)-off-1)*c0 - c1) % 255
+	if x <= 0 {
+		x += 255
+	}
+	y := 510 - c0 - x
+	if y > 255 {
+		y -= 255
+	}
+	return uint16(x)<<8 | uint16(y)
+}
+
+func validLSAChecksum(raw []byte) bool {
+	var c0, c1 int
+	for _, x := range raw[2:] {
+		c0 = (c0 + int(x)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	return c0 == 0 && c1 == 0
+}
+
+// Router-LSA link types and flags.
+const (
+	linkPointToPoint = 1
+	linkTransit      = 2
+	linkStub         = 3 // OSPFv2 only
+	linkVirtual      = 4
+
+	flagB = 0x01 // Area border router
+	flagE = 0x02 // AS boundary router
+	flagV = 0x04 // Virtual link endpoint
+)
+
+// routerLSA lists a router's links. OSPFv2 stub links carry the attached
+// networks; OSPFv3 announces them in intra-area-prefix-LSAs instead.
+type routerLSA struct {
+	flags   uint8
+	options uint32 // OSPFv3
+	links   []routerLink
+}
+
+type routerLink struct {
+	typ    uint8
+	metric uint16
+	id     uint32 // OSPFv2 Link ID; OSPFv3 Neighbor Router ID
+	data   uint32 // OSPFv2 Link Data; OSPFv3 Interface ID
+	nbrIf  uint32 // OSPFv3 Neighbor Interface ID
+}
+
+func (r *routerLSA) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		b = append(b, r.flags, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(r.links)))
+		for _, l := range r.links {
+			b = binary.BigEndian.AppendUint32(b, l.id)
+			b = binary.BigEndian.AppendUint32(b, l.data)
+			b = append(b, l.typ, 0)
+			b = binary.BigEndian.AppendUint16(b, l.metric)
+		}
+		return b
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(r.flags)<<24|r.options&0xffffff)
+	for _, l := range r.links {
+		b = append(b, l.typ, 0)
+		b = binary.BigEndian.AppendUint16(b, l.metric)
+		b = binary.BigEndian.AppendUint32(b, l.data)
+		b = binary.BigEndian.AppendUint32(b, l.nbrIf)
+		b = binary.BigEndian.AppendUint32(b, l.id)
+	}
+	return b
+}
+
+func parseRouterLSA(b []byte, v int) (*routerLSA, error) {
+	r := &routerLSA{}
+	if v == 2 {
+		if len(b) < 4 {
+			return nil, errShortPacket
+		}
+		r.flags = b[0]
+		n := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		for i := 0; i < n; i++ {
+			if len(b) < 12 {
+				return nil, errShortPacket
+			}
+			l := routerLink{
+				id:     binary.BigEndian.Uint32(b[0:4]),
+				data:   binary.BigEndian.Uint32(b[4:8]),
+				typ:    b[8],
+				metric: binary.BigEndian.Uint16(b[10:12]),
+			}
+			// Skip TOS metrics
+			skip := 12 + 4*int(b[9])
+			if len(b) < skip {
+				return nil, errShortPacket
+			}
+			b = b[skip:]
+			r.links = append(r.links, l)
+		}
+		return r, nil
+	}
+	if len(b) < 4 || (len(b)-4)%16 != 0 {
+		return nil, errShortPacket
+	}
+	r.flags = b[0]
+	r.options = binary.BigEndian.Uint32(b[0:4]) & 0xffffff
+	for b = b[4:]; len(b) > 0; b = b[16:] {
+		r.links = append(r.links, routerLink{
+			typ:    b[0],
+			metric: binary.BigEndian.Uint16(b[2:4]),
+			data:   binary.BigEndian.Uint32(b[4:8]),
+			nbrIf:  binary.BigEndian.Uint32(b[8:12]),
+			id:     binary.BigEndian.Uint32(b[12:16]),
+		})
+	}
+	return r, nil
+}
+
+// networkLSA lists the routers attached to a transit network.
+type networkLSA struct {
+	mask    uint32 // OSPFv2
+	options uint32 // OSPFv3
+	routers []uint32
+}
+
+func (n *networkLSA) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		b = binary.BigEndian.AppendUint32(b, n.mask)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, n.options&0xffffff)
+	}
+	for _, r := range n.routers {
+		b = binary.BigEndian.AppendUint32(b, r)
+	}
+	return b
+}
+
+func parseNetworkLSA(b []byte, v int) (*networkLSA, error) {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return nil, errShortPacket
+	}
+	n := &networkLSA{}
+	if v == 2 {
+		n.mask = binary.BigEndian.Uint32(b[0:4])
+	} else {
+		n.options = binary.BigEndian.Uint32(b[0:4]) & 0xffffff
+	}
+	for i := 4; i < len(b); i += 4 {
+		n.routers = append(n.routers, binary.BigEndian.Uint32(b[i:i+4]))
+	}
+	return n, nil
+}
+
+// summaryLSA advertises an inter-area route to a network or, with asbr
+// set, to an AS boundary router.
+type summaryLSA struct {
+	asbr    bool
+	prefix  netip.Prefix // Network summaries
+	router  uint32       // ASBR summaries
+	options uint32       // OSPFv3 inter-area-router
+	metric  uint32
+}
+
+func (s *summaryLSA) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		if s.asbr {
+			b = binary.BigEndian.AppendUint32(b, 0)
+		} else {
+			b = binary.BigEndian.AppendUint32(b, maskOf(s.prefix.Bits()))
+		}
+		return binary.BigEndian.AppendUint32(b, s.metric&lsInfinity)
+	}
+	if s.asbr {
+		b = binary.BigEndian.AppendUint32(b, s.options&0xffffff)
+		b = binary.BigEndian.AppendUint32(b, s.metric&lsInfinity)
+		return binary.BigEndian.AppendUint32(b, s.router)
+	}
+	b = binary.BigEndian.AppendUint32(b, s.metric&lsInfinity)
+	return appendPrefix6(b, s.prefix, 0, 0)
+}
+
+func parseSummaryLSA(b []byte, v int, h *lsaHeader, asbr bool) (*summaryLSA, error) {
+	s := &summaryLSA{asbr: asbr}
+	if v == 2 {
+		if len(b) < 8 {
+			return nil, errShortPacket
+		}
+		s.metric = binary.BigEndian.Uint32(b[4:8]) & lsInfinity
+		if asbr {
+			s.router = h.id
+			return s, nil
+		}
+		p, err := prefixFromMask(h.id, binary.BigEndian.Uint32(b[0:4]))
+		if err != nil {
+			return nil, err
+		}
+		s.prefix = p
+		return s, nil
+	}
+	if asbr {
+		if len(b) < 12 {
+			return nil, errShortPacket
+		}
+		s.options = binary.BigEndian.Uint32(b[0:4]) & 0xffffff
+		s.metric = binary.BigEndian.Uint32(b[4:8]) & lsInfinity
+		s.router = binary.BigEndian.Uint32(b[8:12])
+		return s, nil
+	}
+	if len(b) < 4 {
+		return nil, errShortPacket
+	}
+	s.metric = binary.BigEndian.Uint32(b[0:4]) & lsInfinity
+	p, _, _, _, err := parsePrefix6(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	s.prefix = p
+	return s, nil
+}
+
+// externalLSA advertises a route learned from outside the AS.
+type externalLSA struct {
+	prefix  netip.Prefix
+	type2   bool
+	metric  uint32
+	forward netip.Addr // Invalid or unspecified: route via the ASBR
+	tag     uint32
+}
+
+const (
+	extE = 0x04 // OSPFv3 flags
+	extF = 0x02
+	extT = 0x01
+)
+
+func (e *externalLSA) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		b = binary.BigEndian.AppendUint32(b, maskOf(e.prefix.Bits()))
+		word := e.metric & lsInfinity
+		if e.type2 {
+			word |= 0x80000000
+		}
+		b = binary.BigEndian.AppendUint32(b, word)
+		fwd := uint32(0)
+		if e.forward.Is4() {
+			fwd = u32(e.forward)
+		}
+		b = binary.BigEndian.AppendUint32(b, fwd)
+		return binary.BigEndian.AppendUint32(b, e.tag)
+	}
+	var flags uint32
+	if e.type2 {
+		flags |= extE
+	}
+	hasFwd := e.forward.Is6() && !e.forward.IsUnspecified()
+	if hasFwd {
+		flags |= extF
+	}
+	if e.tag != 0 {
+		flags |= extT
+	}
+	b = binary.BigEndian.AppendUint32(b, flags<<24|e.metric&lsInfinity)
+	b = appendPrefix6(b, e.prefix, 0, 0)
+	if hasFwd {
+		a := e.forward.As16()
+		b = append(b, a[:]...)
+	}
+	if e.tag != 0 {
+		b = binary.BigEndian.AppendUint32(b, e.tag)
+	}
+	return b
+}
+
+func parseExternalLSA(b []byte, v int, h *lsaHeader) (*externalLSA, error) {
+	e := &externalLSA{}
+	if v == 2 {
+		if len(b) < 16 {
+			return nil, errShortPacket
+		}
+		p, err := prefixFromMask(h.id, binary.BigEndian.Uint32(b[0:4]))
+		if err != nil {
+			return nil, err
+		}
+		word := binary.BigEndian.Uint32(b[4:8])
+		e.prefix = p
+		e.type2 = word&0x80000000 != 0
+		e.metric = word & lsInfinity
+		if fwd := binary.BigEndian.Uint32(b[8:12]); fwd != 0 {
+			e.forward = addr4(fwd)
+		}
+		e.tag = binary.BigEndian.Uint32(b[12:16])
+		return e, nil
+	}
+	if len(b) < 4 {
+		return nil, errShortPacket
+	}
+	word := binary.BigEndian.Uint32(b[0:4])
+	flags := word >> 24
+	e.type2 = flags&extE != 0
+	e.metric = word & lsInfinity
+	p, _, _, rest, err := parsePrefix6(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	e.prefix = p
+	if flags&extF != 0 {
+		if len(rest) < 16 {
+			return nil, errShortPacket
+		}
+		e.forward = netip.AddrFrom16([16]byte(rest[:16]))
+		rest = rest[16:]
+	}
+	if flags&extT != 0 {
+		if len(rest) < 4 {
+			return nil, errShortPacket
+		}
+		e.tag = binary.BigEndian.Uint32(rest[:4])
+	}
+	return e, nil
+}
+
+// OSPFv3 prefix options (RFC 5340 A.4.1.1).
+const (
+	prefixNU = 0x01 // Exclude from unicast routing
+	prefixLA = 0x02 // Local address
+)
+
+type lsaPrefix struct {
+	prefix  netip.Prefix
+	options uint8
+	metric  uint16 // Intra-area-prefix-LSAs only
+}
+
+// linkLSA carries a router's link-local address and the prefixes of a
+// link (OSPFv3).
+type linkLSA struct {
+	priority  uint8
+	options   uint32
+	linkLocal netip.Addr
+	prefixes  []lsaPrefix
+}
+
+func (l *linkLSA) marshal() []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(l.priority)<<24|l.options&0xffffff)
+	a := l.linkLocal.As16()
+	b = append(b, a[:]...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(l.prefixes)))
+	for _, p := range l.prefixes {
+		b = appendPrefix6(b, p.prefix, p.options, 0)
+	}
+	return b
+}
+
+func parseLinkLSA(b []byte) (*linkLSA, error) {
+	if len(b) < 24 {
+		return nil, errShortPacket
+	}
+	l := &linkLSA{
+		priority:  b[0],
+		options:   binary.BigEndian.Uint32(b[0:4]) & 0xffffff,
+		linkLocal: netip.AddrFrom16([16]byte(b[4:20])),
+	}
+	n := binary.BigEndian.Uint32(b[20:24])
+	b = b[24:]
+	for i := uint32(0); i < n; i++ {
+		p, opts, _, rest, err := parsePrefix6(b)
+		if err != nil {
+			return nil, err
+		}
+		l.prefixes = append(l.prefixes, lsaPrefix{prefix: p, options: opts})
+		b = rest
+	}
+	return l, nil
+}
+
+// intraPrefixLSA associates prefixes with a router- or network-LSA
+// (OSPFv3).
+type intraPrefixLSA struct {
+	refType  uint16
+	refID    uint32
+	refAdv   uint32
+	prefixes []lsaPrefix
+}
+
+func (p *intraPrefixLSA) marshal() []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(p.prefixes)))
+	b = binary.BigEndian.AppendUint16(b, p.refType)
+	b = binary.BigEndian.AppendUint32(b, p.refID)
+	b = binary.BigEndian.AppendUint32(b, p.refAdv)
+	for _, pfx := range p.prefixes {
+		b = appendPrefix6(b, pfx.prefix, pfx.options, pfx.metric)
+	}
+	return b
+}
+
+func parseIntraPrefixLSA(b []byte) (*intraPrefixLSA, error) {
+	if len(b) < 12 {
+		return nil, errShortPacket
+	}
+	p := &intraPrefixLSA{
+		refType: binary.BigEndian.Uint16(b[2:4]),
+		refID:   binary.BigEndian.Uint32(b[4:8]),
+		refAdv:  binary.BigEndian.Uint32(b[8:12]),
+	}
+	n := int(binary.BigEndian.Uint16(b[0:2]))
+	b = b[12:]
+	for i := 0; i < n; i++ {
+		pfx, opts, metric, rest, err := parsePrefix6(b)
+		if err != nil {
+			return nil, err
+		}
+		p.prefixes = append(p.prefixes, lsaPrefix{prefix: pfx, options: opts, metric: metric})
+		b = rest
+	}
+	return p, nil
+}
+
+func parseBody(v int, h *lsaHeader, b []byte) (any, error) {
+	switch kindOf(v, h.typ) {
+	case kindRouter:
+		return parseRouterLSA(b, v)
+	case kindNetwork:
+		return parseNetworkLSA(b, v)
+	case kindSummaryNet:
+		return parseSummaryLSA(b, v, h, false)
+	case kindSummaryASBR:
+		return parseSummaryLSA(b, v, h, true)
+	case kindExternal:
+		return parseExternalLSA(b, v, h)
+	case kindLink:
+		return parseLinkLSA(b)
+	case kindIntraPrefix:
+		return parseIntraPrefixLSA(b)
+	}
+	return nil, nil
+}
+
+// appendPrefix6 encodes an OSPFv3 address prefix: length, options, a
+// 16-bit field whose meaning depends on the LSA, and the prefix padded
+// to 32-bit words (RFC 5340 A.4.1).
+func appendPrefix6(b []byte, p netip.Prefix, opts uint8, field uint16) []byte {
+	b = append(b, byte(p.Bits()), opts)
+	b = binary.BigEndian.AppendUint16(b, field)
+	a := p.Masked().Addr().As16()
+	return append(b, a[:(p.Bits()+31)/32*4]...)
+}
+
+func parsePrefix6(b []byte) (p netip.Prefix, opts uint8, field uint16, rest []byte, err error) {
+	if len(b) < 4 {
+		return p, 0, 0, nil, errShortPacket
+	}
+	bits := int(b[0])
+	if bits > 128 {
+		return p, 0, 0, nil, fmt.Errorf("bad prefix length %d", bits)
+	}
+	n := (bits + 31) / 32 * 4
+	if len(b) < 4+n {
+		return p, 0, 0, nil, errShortPacket
+	}
+	var a [16]byte
+	copy(a[:], b[4:4+n])
+	p = netip.PrefixFrom(netip.AddrFrom16(a), bits).Masked()
+	return p, b[1], binary.BigEndian.Uint16(b[2:4]), b[4+n:], nil
+}
+
+// prefixFromMask builds an OSPFv2 prefix from a link state ID and mask.
+func prefixFromMask(id, mask uint32) (netip.Prefix, error) {
+	ones := bits.LeadingZeros32(^mask)
+	if maskOf(ones) != mask {
+		return netip.Prefix{}, fmt.Errorf("non-contiguous mask %s", idString(mask))
+	}
+	return netip.PrefixFrom(addr4(id&mask), ones), nil
+}
+
+func maskOf(ones int) uint32 {
+	if ones == 0 {
+		return 0
+	}
+	return ^uint32(0) << (32 - ones)
+}
+
+func addr4(u uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(u >> 24), byte(u >> 16), byte(u >> 8), byte(u)})
+}
+
+func u32(a netip.Addr) uint32 {
+	b := a.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// idString formats a router, area or link state ID as a dotted quad.
+func idString(id uint32) string {
+	return addr4(id).String()
+}
@@ -0,0 +1,390 @@
+package ospf
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// nbrState is the state of a neighbor (RFC 2328 section 10.1).
+type nbrState uint8
+
+const (
+	nbrDown nbrState = iota
+	nbrInit
+	nbrTwoWay
+	nbrExStart
+	nbrExchange
+	nbrLoading
+	nbrFull
+)
+
+var nbrStateNames = [...]NeighborState{StateDown, StateInit, StateTwoWay, StateExStart, StateExchange, StateLoading, StateFull}
+
+func (s nbrState) String() string {
+	return string(nbrStateNames[s])
+}
+
+// ddSummary identifies a received Database Description packet for
+// duplicate detection.
+type ddSummary struct {
+	flags   uint8
+	options uint32
+	seq     uint32
+}
+
+// neighbor is a router heard on an interface.
+type neighbor struct {
+	i         *iface
+	id        uint32
+	addr      netip.Addr
+	ifID      uint32 // OSPFv3 Interface ID
+	priority  uint8
+	dr, bdr   uint32
+	options   uint32
+	state     nbrState
+	since     time.Time
+	lastHello uint64
+
+	// Database exchange (RFC 2328 section 10.8)
+	master   bool
+	ddSeq    uint32
+	lastRecv *ddSummary
+	lastSent []byte
+	ddRxmtAt uint64
+	summary  []lsaHeader
+	sentLast bool // The last Database Description packet went out
+
+	requests  []lsaHeader
+	lsrRxmtAt uint64
+	retrans   map[lsaKey]*lsa
+	lsuRxmtAt uint64
+}
+
+func newNeighbor(i *iface, id uint32) *neighbor {
+	return &neighbor{i: i, id: id, since: time.Now(), retrans: make(map[lsaKey]*lsa)}
+}
+
+// ident is the neighbor's identifier for DR election.
+func (n *neighbor) ident() uint32 {
+	if n.i.inst.is4() {
+		return u32(n.addr)
+	}
+	return n.id
+}
+
+// dst is the destination of packets to the neighbor. Point-to-point
+// links use AllSPFRouters, which also works on unnumbered tunnels.
+func (n *neighbor) dst() netip.Addr {
+	if n.i.typ == ifPointToPoint {
+		return n.i.allSPF()
+	}
+	return n.addr
+}
+
+func (n *neighbor) setState(s nbrState) {
+	if n.state == s {
+		return
+	}
+	old := n.state
+	n.state, n.since = s, time.Now()
+	logger := n.i.inst.r.logger
+	switch {
+	case s == nbrFull:
+		logger.Info("OSPF adjacency up", "interface", n.i.name, "neighbor", idString(n.id), "version", n.i.inst.version)
+	case old == nbrFull:
+		logger.Info("OSPF adjacency down", "interface", n.i.name, "neighbor", idString(n.id), "state", s)
+	default:
+		logger.Debug("OSPF neighbor state", "interface", n.i.name, "neighbor", idString(n.id), "state", s)
+	}
+	if old == nbrFull || s == nbrFull {
+		n.i.inst.spfPending = true
+	}
+}
+
+// twoWay handles 2-WayReceived in state Init.
+func (n *neighbor) twoWay() {
+	if n.i.adjacent(n) {
+		n.startExchange()
+	} else {
+		n.setState(nbrTwoWay)
+	}
+}
+
+// reset clears the adjacency and enters state s.
+func (n *neighbor) reset(s nbrState) {
+	n.summary, n.requests, n.lastSent, n.lastRecv = nil, nil, nil, nil
+	clear(n.retrans)
+	n.setState(s)
+}
+
+func (n *neighbor) kill() {
+	n.reset(nbrDown)
+	delete(n.i.nbrs, n.id)
+}
+
+// startExchange enters ExStart and claims mastership (RFC 2328 section
+// 10.8).
+func (n *neighbor) startExchange() {
+	n.reset(nbrExStart)
+	n.master, n.sentLast = true, false
+	if n.ddSeq == 0 {
+		n.ddSeq = rand.Uint32()
+	} else {
+		n.ddSeq++
+	}
+	n.sendDBD(ddInit|ddMore|ddMS, nil)
+}
+
+// mismatch handles SeqNumberMismatch and BadLSReq.
+func (n *neighbor) mismatch(reason string) {
+	n.i.inst.r.logger.Debug("OSPF database exchange restarted", "interface", n.i.name, "neighbor", idString(n.id), "reason", reason)
+	n.startExchange()
+}
+
+func (n *neighbor) sendDBD(flags uint8, headers []lsaHeader) {
+	i := n.i
+	d := &dbd{mtu: uint16(i.mtu), options: i.inst.options(i.area), flags: flags, seq: n.ddSeq, headers: headers}
+	n.lastSent = d.marshal(i.inst.version)
+	n.ddRxmtAt = i.inst.r.now + rxmtInterval
+	i.send(pktDBD, n.lastSent, n.dst())
+}
+
+// sendNextDBD sends the next part of the database summary.
+func (n *neighbor) sendNextDBD() {
+	fixed := 8
+	if !n.i.inst.is4() {
+		fixed = 12
+	}
+	count := min(len(n.summary), (n.i.maxPayload()-fixed)/lsaHeaderLen)
+	headers := n.summary[:count]
+	n.summary = n.summary[count:]
+	var flags uint8
+	if n.master {
+		flags |= ddMS
+	}
+	if len(n.summary) > 0 {
+		flags |= ddMore
+	} else {
+		n.sentLast = true
+	}
+	n.sendDBD(flags, headers)
+}
+
+// recvDBD processes a Database Description packet (RFC 2328 section
+// 10.6).
+func (n *neighbor) recvDBD(d *dbd) {
+	inst := n.i.inst
+	if int(d.mtu) > n.i.mtu {
+		inst.r.logger.Debug("OSPF neighbor MTU too large", "interface", n.i.name, "neighbor", idString(n.id), "mtu", d.mtu)
+		return
+	}
+	if n.state == nbrInit {
+		n.twoWay()
+	}
+	sum := ddSummary{flags: d.flags, options: d.options, seq: d.seq}
+
+	switch n.state {
+	case nbrDown, nbrInit, nbrTwoWay:
+		return
+
+	case nbrExStart:
+		switch {
+		case d.flags == ddInit|ddMore|ddMS && len(d.headers) == 0 && n.id > inst.r.routerID:
+			n.master, n.ddSeq = false, d.seq
+		case d.flags&(ddInit|ddMS) == 0 && d.seq == n.ddSeq && n.id < inst.r.routerID:
+			n.master = true
+		default:
+			return
+		}
+		n.options = d.options
+		n.setState(nbrExchange)
+		n.summary = inst.summaryList(n.i)
+
+	case nbrExchange:
+		if n.lastRecv != nil && *n.lastRecv == sum {
+			if !n.master {
+				n.i.send(pktDBD, n.lastSent, n.dst())
+			}
+			return
+		}
+		switch {
+		case d.flags&ddMS != 0 == n.master:
+			n.mismatch("master/slave bit")
+			return
+		case d.flags&ddInit != 0:
+			n.mismatch("init bit")
+			return
+		case d.options != n.options:
+			n.mismatch("options changed")
+			return
+		case n.master && d.seq != n.ddSeq, !n.master && d.seq != n.ddSeq+1:
+			n.mismatch("sequence number")
+			return
+		}
+
+	default: // Loading, Full
+		if n.lastRecv != nil && *n.lastRecv == sum {
+			if !n.master {
+				n.i.send(pktDBD, n.lastSent, n.dst())
+			}
+			return
+		}
+		n.mismatch("unexpected database description")
+		return
+	}
+
+	n.lastRecv = &sum
+	for k := range d.headers {
+		h := &d.headers[k]
+		sc := scopeOf(inst.version, h.typ)
+		if sc == scopeNone || sc == scopeAS && n.i.area.stub {
+			n.mismatch("unexpected LSA type")
+			return
+		}
+		if cur := inst.lookup(n.i, h.key()); cur == nil || compareLSA(h, ptr(cur.headerAt(inst.r.now))) > 0 {
+			n.requests = append(n.requests, *h)
+		}
+	}
+
+	if n.master {
+		n.ddSeq++
+		if n.sentLast && d.flags&ddMore == 0 {
+			n.exchangeDone()
+		} else {
+			n.sendNextDBD()
+		}
+		return
+	}
+	n.ddSeq = d.seq
+	n.sendNextDBD()
+	if d.flags&ddMore == 0 && n.sentLast {
+		n.exchangeDone()
+	}
+}
+
+func (n *neighbor) exchangeDone() {
+	if len(n.requests) == 0 {
+		n.setState(nbrFull)
+		return
+	}
+	n.setState(nbrLoading)
+	n.sendLSR()
+}
+
+func (n *neighbor) sendLSR() {
+	count := min(len(n.requests), n.i.maxPayload()/12)
+	keys := make([]lsaKey, count)
+	for k := range keys {
+		keys[k] = n.requests[k].key()
+	}
+	n.lsrRxmtAt = n.i.inst.r.now + rxmtInterval
+	n.i.send(pktLSR, marshalLSR(keys), n.dst())
+}
+
+func (n *neighbor) request(key lsaKey) *lsaHeader {
+	for k := range n.requests {
+		if n.requests[k].key() == key {
+			return &n.requests[k]
+		}
+	}
+	return nil
+}
+
+func (n *neighbor) removeRequest(key lsaKey) {
+	n.requests = slices.DeleteFunc(n.requests, func(h lsaHeader) bool { return h.key() == key })
+	if n.state == nbrLoading && len(n.requests) == 0 {
+		n.setState(nbrFull)
+	}
+}
+
+// recvLSR answers a Link State Request (RFC 2328 section 10.7).
+func (n *neighbor) recvLSR(keys []lsaKey) {
+	if n.state < nbrExchange {
+		return
+	}
+	inst := n.i.inst
+	var lsas [][]byte
+	for _, key := range keys {
+		l := inst.lookup(n.i, key)
+		if l == nil {
+			n.mismatch("bad link state request")
+			return
+		}
+		lsas = append(lsas, l.encodeAt(inst.r.now))
+	}
+	n.i.sendUpdate(n.dst(), lsas)
+}
+
+// recvAck removes acknowledged LSAs from the retransmission list.
+func (n *neighbor) recvAck(headers []lsaHeader) {
+	if n.state < nbrExchange {
+		return
+	}
+	now := n.i.inst.r.now
+	for k := range headers {
+		h := &headers[k]
+		if l := n.retrans[h.key()]; l != nil && compareLSA(h, ptr(l.headerAt(now))) == 0 {
+			delete(n.retrans, h.key())
+		}
+	}
+}
+
+func (n *neighbor) addRetrans(l *lsa) {
+	if len(n.retrans) == 0 {
+		n.lsuRxmtAt = n.i.inst.r.now + rxmtInterval
+	}
+	n.retrans[l.key()] = l
+}
+
+// tick retransmits unacknowledged packets.
+func (n *neighbor) tick() {
+	now := n.i.inst.r.now
+	if n.master && (n.state == nbrExStart || n.state == nbrExchange) && now >= n.ddRxmtAt {
+		n.ddRxmtAt = now + rxmtInterval
+		n.i.send(pktDBD, n.lastSent, n.dst())
+	}
+	if n.state == nbrLoading && len(n.requests) > 0 && now >= n.lsrRxmtAt {
+		n.sendLSR()
+	}
+	if len(n.retrans) > 0 && now >= n.lsuRxmtAt {
+		n.lsuRxmtAt = now + rxmtInterval
+		keys := make([]lsaKey, 0, len(n.retrans))
+		for key := range n.retrans {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, compareKeys)
+		lsas := make([][]byte, len(keys))
+		for k, key := range keys {
+			lsas[k] = n.retrans[key].encodeAt(now)
+		}
+		n.i.sendUpdate(n.dst(), lsas)
+	}
+}
+
+func (n *neighbor) status() NeighborStatus {
+	st := NeighborStatus{
+		RouterID:  idString(n.id),
+		Address:   n.addr.String(),
+		Interface: n.i.name,
+		Area:      idString(n.i.area.id),
+		Version:   n.i.inst.version,
+		State:     nbrStateNames[n.state],
+		Priority:  int(n.priority),
+		Since:     n.since,
+	}
+	if n.i.typ == ifBroadcast {
+		switch n.ident() {
+		case n.i.dr:
+			st.Role = "DR"
+		case n.i.bdr:
+			st.Role = "BDR"
+		}
+	}
+	return st
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
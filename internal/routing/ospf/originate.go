@@ -0,0 +1,328 @@
+package ospf
+
+import (
+	"bytes"
+	"net/netip"
+	"slices"
+)
+
+// wantLSA is an LSA the router originates.
+type wantLSA struct {
+	db      *lsdb
+	area    *area  // Flooding scope for area-scoped LSAs
+	link    *iface // Flooding scope for link-scoped LSAs
+	typ     uint16
+	id      uint32
+	options uint8 // OSPFv2 header options
+	body    []byte
+}
+
+// originate reconciles the self-originated LSAs with the router's
+// current state: new or changed LSAs are (re)originated, refreshed every
+// LSRefreshTime, and LSAs no longer wanted are flushed (RFC 2328 section
+// 12.4).
+func (inst *instance) originate() {
+	r := inst.r
+	now := r.now
+	wanted := make(map[dbKey]bool)
+	for _, w := range inst.desired() {
+		key := lsaKey{typ: w.typ, id: w.id, adv: r.routerID}
+		dk := dbKey{db: w.db, key: key}
+		wanted[dk] = true
+
+		cur := w.db.lsas[key]
+		if cur != nil && cur.ageAt(now) < lsRefreshTime && cur.age < maxAge &&
+			cur.options == w.options && bytes.Equal(cur.raw[lsaHeaderLen:], w.body) {
+			continue
+		}
+		if last, ok := inst.lastOrig[dk]; ok && now-last < minLSInterval {
+			continue
+		}
+		seq := initialSeq
+		if cur != nil {
+			if cur.seq == maxSeq {
+				// Wait for the flushed copy to leave the database
+				if cur.age < maxAge {
+					flushed := cur.maxAged()
+					inst.install(w.db, flushed, cur)
+					inst.flood(flushed, w.area, w.link, nil)
+				}
+				continue
+			}
+			seq = cur.seq + 1
+		}
+		l := newLSA(inst.version, lsaHeader{options: w.options, typ: w.typ, id: w.id, adv: r.routerID, seq: seq}, w.body)
+		inst.install(w.db, l, cur)
+		inst.lastOrig[dk] = now
+		inst.flood(l, w.area, w.link, nil)
+	}
+
+	inst.databases(func(db *lsdb, a *area, link *iface) {
+		for _, l := range db.sorted() {
+			if l.adv != r.routerID || l.age == maxAge || wanted[dbKey{db: db, key: l.key()}] {
+				continue
+			}
+			flushed := l.maxAged()
+			inst.install(db, flushed, l)
+			inst.flood(flushed, a, link, nil)
+		}
+	})
+}
+
+// desired computes the LSAs the router should originate.
+func (inst *instance) desired() []wantLSA {
+	var want []wantLSA
+	abr := inst.isABR()
+	for _, a := range inst.active() {
+		if inst.is4() {
+			want = append(want, inst.routerLSA2(a, abr))
+			want = append(want, inst.networkLSAs2(a)...)
+		} else {
+			want = append(want, inst.routerLSA3(a, abr))
+			want = append(want, inst.networkLSAs3(a)...)
+			want = append(want, inst.prefixLSAs3(a)...)
+		}
+		if abr {
+			want = append(want, inst.summaries(a)...)
+		}
+	}
+	return want
+}
+
+func (inst *instance) areaLSA(a *area, k lsaKind, id uint32, body []byte) wantLSA {
+	w := wantLSA{db: a.db, area: a, typ: lsType(inst.version, k), id: id, body: body}
+	if inst.is4() {
+		w.options = uint8(inst.options(a))
+	}
+	return w
+}
+
+// routerLSA2 builds the OSPFv2 router-LSA (RFC 2328 section 12.4.1).
+func (inst *instance) routerLSA2(a *area, abr bool) wantLSA {
+	rl := &routerLSA{}
+	if abr {
+		rl.flags |= flagB
+	}
+	stubs := func(i *iface, prefixes []netip.Prefix) {
+		for _, p := range prefixes {
+			rl.links = append(rl.links, routerLink{typ: linkStub, id: u32(p.Masked().Addr()), data: maskOf(p.Bits()), metric: i.cost})
+		}
+	}
+	for _, i := range a.ifaces {
+		switch {
+		case i.loopback:
+			// Loopback addresses are advertised as host routes
+			for _, p := range i.prefixes {
+				rl.links = append(rl.links, routerLink{typ: linkStub, id: u32(p.Addr()), data: maskOf(32), metric: i.cost})
+			}
+		case i.passive:
+			stubs(i, i.prefixes)
+		case i.typ == ifPointToPoint:
+			for _, n := range i.fullNeighbors() {
+				rl.links = append(rl.links, routerLink{typ: linkPointToPoint, id: n.id, data: u32(i.addr), metric: i.cost})
+			}
+			stubs(i, i.prefixes)
+		case i.transit():
+			rl.links = append(rl.links, routerLink{typ: linkTransit, id: i.dr, data: u32(i.addr), metric: i.cost})
+			stubs(i, i.prefixes[1:])
+		default:
+			stubs(i, i.prefixes)
+		}
+	}
+	return inst.areaLSA(a, kindRouter, inst.r.routerID, rl.marshal(2))
+}
+
+// networkLSAs2 builds the network-LSAs of the networks the router is DR
+// for (RFC 2328 section 12.4.2).
+func (inst *instance) networkLSAs2(a *area) []wantLSA {
+	var want []wantLSA
+	for _, i := range a.ifaces {
+		if i.state != ifDR || !i.transit() {
+			continue
+		}
+		nl := &networkLSA{mask: maskOf(i.prefixes[0].Bits()), routers: []uint32{inst.r.routerID}}
+		for _, n := range i.fullNeighbors() {
+			nl.routers = append(nl.routers, n.id)
+		}
+		want = append(want, inst.areaLSA(a, kindNetwork, u32(i.addr), nl.marshal(2)))
+	}
+	return want
+}
+
+// routerLSA3 builds the OSPFv3 router-LSA, which only describes links;
+// prefixes go into intra-area-prefix-LSAs (RFC 5340 section 4.4.3.2).
+func (inst *instance) routerLSA3(a *area, abr bool) wantLSA {
+	rl := &routerLSA{options: inst.options(a)}
+	if abr {
+		rl.flags |= flagB
+	}
+	for _, i := range a.ifaces {
+		switch {
+		case i.passive:
+		case i.typ == ifPointToPoint:
+			for _, n := range i.fullNeighbors() {
+				rl.links = append(rl.links, routerLink{typ: linkPointToPoint, metric: i.cost, data: i.ifID(), nbrIf: n.ifID, id: n.id})
+			}
+		case i.transit():
+			l := routerLink{typ: linkTransit, metric: i.cost, data: i.ifID(), nbrIf: i.ifID(), id: inst.r.routerID}
+			if i.state != ifDR {
+				dr := i.neighborByIdent(i.dr)
+				l.nbrIf, l.id = dr.ifID, dr.id
+			}
+			rl.links = append(rl.links, l)
+		}
+	}
+	return inst.areaLSA(a, kindRouter, 0, rl.marshal(3))
+}
+
+// networkLSAs3 builds OSPFv3 network-LSAs and their intra-area-prefix-LSAs
+// (RFC 5340 sections 4.4.3.3 and 4.4.3.9).
+func (inst *instance) networkLSAs3(a *area) []wantLSA {
+	r := inst.r
+	var want []wantLSA
+	for _, i := range a.ifaces {
+		if i.state != ifDR || !i.transit() {
+			continue
+		}
+		nl := &networkLSA{options: inst.options(a), routers: []uint32{r.routerID}}
+		prefixes := slices.Clone(i.prefixes)
+		for _, n := range i.fullNeighbors() {
+			nl.routers = append(nl.routers, n.id)
+			key := lsaKey{typ: lsType(3, kindLink), id: n.ifID, adv: n.id}
+			if l, ok := i.db.lsas[key]; ok && l.age < maxAge {
+				for _, p := range l.body.(*linkLSA).prefixes {
+					if p.options&(prefixNU|prefixLA) == 0 {
+						prefixes = append(prefixes, p.prefix)
+					}
+				}
+			}
+		}
+		want = append(want, inst.areaLSA(a, kindNetwork, i.ifID(), nl.marshal(3)))
+
+		ip := &intraPrefixLSA{refType: lsType(3, kindNetwork), refID: i.ifID(), refAdv: r.routerID}
+		for _, p := range uniquePrefixes(prefixes) {
+			ip.prefixes = append(ip.prefixes, lsaPrefix{prefix: p})
+		}
+		want = append(want, inst.areaLSA(a, kindIntraPrefix, i.ifID(), ip.marshal()))
+	}
+	return want
+}
+
+// prefixLSAs3 builds the link-LSAs and the intra-area-prefix-LSA for the
+// router's stub prefixes.
+func (inst *instance) prefixLSAs3(a *area) []wantLSA {
+	r := inst.r
+	var want []wantLSA
+	ip := &intraPrefixLSA{refType: lsType(3, kindRouter), refAdv: r.routerID}
+	for _, i := range a.ifaces {
+		if !i.passive {
+			ll := &linkLSA{priority: i.priority, options: inst.options(a), linkLocal: i.addr}
+			for _, p := range i.prefixes {
+				ll.prefixes = append(ll.prefixes, lsaPrefix{prefix: p.Masked()})
+			}
+			want = append(want, wantLSA{db: i.db, link: i, typ: lsType(3, kindLink), id: i.ifID(), body: ll.marshal()})
+		}
+		switch {
+		case i.loopback:
+			for _, p := range i.prefixes {
+				ip.prefixes = append(ip.prefixes, lsaPrefix{prefix: netip.PrefixFrom(p.Addr(), 128), options: prefixLA, metric: i.cost})
+			}
+		case i.transit():
+			// Advertised by the DR's intra-area-prefix-LSA
+		default:
+			for _, p := range uniquePrefixes(i.prefixes) {
+				ip.prefixes = append(ip.prefixes, lsaPrefix{prefix: p, metric: i.cost})
+			}
+		}
+	}
+	if len(ip.prefixes) > 0 {
+		want = append(want, inst.areaLSA(a, kindIntraPrefix, 0, ip.marshal()))
+	}
+	return want
+}
+
+// summaries builds the summary-LSAs an area border router originates
+// into a (RFC 2328 section 12.4.3). Stub areas get a default route,
+// totally stubby areas nothing else.
+func (inst *instance) summaries(a *area) []wantLSA {
+	var sums []*summaryLSA
+	if a.stub {
+		def := netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+		if !inst.is4() {
+			def = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+		}
+		sums = append(sums, &summaryLSA{prefix: def, metric: a.defaultCost})
+	}
+	if !a.stub || !a.noSummary {
+		for _, prefix := range sortedPrefixes(inst.rt) {
+			e := inst.rt[prefix]
+			if e.area == a || e.cost >= lsInfinity {
+				continue
+			}
+			// Inter-area routes are only passed from the backbone on
+			if e.typ == pathIntra || e.typ == pathInter && a.id != 0 {
+				sums = append(sums, &summaryLSA{prefix: prefix, metric: e.cost})
+			}
+		}
+	}
+	if !a.stub {
+		for _, id := range sortedIDs(inst.asbrs) {
+			e := inst.asbrs[id]
+			if e.area != a && e.typ == pathIntra && e.cost < lsInfinity {
+				sums = append(sums, &summaryLSA{asbr: true, router: id, options: inst.options(a), metric: e.cost})
+			}
+		}
+	}
+
+	var want []wantLSA
+	used := make(map[uint32]bool)
+	for _, s := range sums {
+		var id uint32
+		kind := kindSummaryNet
+		switch {
+		case s.asbr:
+			kind, id = kindSummaryASBR, s.router
+		case inst.is4():
+			// Appendix E: a longer prefix sharing the network address
+			// takes the broadcast address
+			id = u32(s.prefix.Addr())
+			if used[id] {
+				id |= ^maskOf(s.prefix.Bits())
+			}
+			used[id] = true
+		default:
+			id = inst.prefixID(s.prefix)
+		}
+		want = append(want, inst.areaLSA(a, kind, id, s.marshal(inst.version)))
+	}
+	return want
+}
+
+// prefixID allocates a stable link state ID for an OSPFv3
+// inter-area-prefix-LSA.
+func (inst *instance) prefixID(p netip.Prefix) uint32 {
+	id, ok := inst.prefixIDs[p]
+	if !ok {
+		id = uint32(len(inst.prefixIDs) + 1)
+		inst.prefixIDs[p] = id
+	}
+	return id
+}
+
+func uniquePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p = p.Masked(); !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	slices.SortFunc(out, comparePrefixes)
+	return out
+}
+
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
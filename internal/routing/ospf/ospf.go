@@ -0,0 +1,777 @@
+// Package ospf implements OSPFv2 (RFC 2328) and OSPFv3 (RFC 5340) for
+// small multi-site networks.
+//
+// A [Router] runs both protocol versions side by side: OSPFv2 on
+// interfaces with an IPv4 address inside a configured area network and
+// OSPFv3 on interfaces with such an IPv6 address. Point-to-point and
+// broadcast interfaces, stub and totally stubby areas and area border
+// routing are supported; virtual links, NSSAs, authentication and the
+// origination of external routes are not. Learned routes are installed
+// into the main routing table.
+package ospf
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+// Protocol is the IP protocol number of OSPF.
+const Protocol = 89
+
+const (
+	defaultTick          = time.Second
+	defaultCost          = 10
+	defaultHelloInterval = 10
+	defaultPriority      = 1
+
+	// Timers below are in clock ticks, which are seconds outside tests.
+	rxmtInterval = 5
+	pollInterval = 5 // Between interface rescans
+)
+
+// Options bits (RFC 2328 A.2, RFC 5340 A.2).
+const (
+	optV6 = 0x01 // OSPFv3 only
+	optE  = 0x02
+	optR  = 0x10 // OSPFv3 only
+)
+
+var (
+	allSPFRouters4 = netip.MustParseAddr("224.0.0.5")
+	allDRouters4   = netip.MustParseAddr("224.0.0.6")
+	allSPFRouters6 = netip.MustParseAddr("ff02::5")
+	allDRouters6   = netip.MustParseAddr("ff02::6")
+)
+
+// NeighborState is the state of an adjacency (RFC 2328 section 10.1).
+type NeighborState string
+
+const (
+	StateDown     NeighborState = "down"
+	StateInit     NeighborState = "init"
+	StateTwoWay   NeighborState = "2-way"
+	StateExStart  NeighborState = "exstart"
+	StateExchange NeighborState = "exchange"
+	StateLoading  NeighborState = "loading"
+	StateFull     NeighborState = "full"
+)
+
+// InterfaceStatus describes an OSPF interface for status output.
+type InterfaceStatus struct {
+	Name      string `json:"name"`
+	Version   int    `json:"version"`
+	Area      string `json:"area"`
+	Type      string `json:"type"`
+	State     string `json:"state"`
+	Cost      int    `json:"cost"`
+	Address   string `json:"address"`
+	DR        string `json:"dr,omitempty"`
+	BDR       string `json:"bdr,omitempty"`
+	Neighbors int    `json:"neighbors"`
+	Passive   bool   `json:"passive,omitempty"`
+}
+
+// NeighborStatus describes an OSPF neighbor for status output.
+type NeighborStatus struct {
+	RouterID  string        `json:"router_id"`
+	Address   string        `json:"address"`
+	Interface string        `json:"interface"`
+	Area      string        `json:"area"`
+	Version   int           `json:"version"`
+	State     NeighborState `json:"state"`
+	Priority  int           `json:"priority"`
+	Role      string        `json:"role,omitempty"` // "DR" or "BDR"
+	Since     time.Time     `json:"since"`
+}
+
+// NextHop is a next hop of an OSPF route. Address is empty for
+// directly connected networks.
+type NextHop struct {
+	Address   string `json:"address,omitempty"`
+	Interface string `json:"interface"`
+}
+
+// RouteStatus describes a route computed by OSPF.
+type RouteStatus struct {
+	Prefix   string    `json:"prefix"`
+	Type     string    `json:"type"` // intra-area, inter-area, external-1, external-2
+	Area     string    `json:"area,omitempty"`
+	Cost     int       `json:"cost"`
+	NextHops []NextHop `json:"next_hops"`
+}
+
+// Status is a snapshot of the router for status output.
+type Status struct {
+	RouterID   string            `json:"router_id"`
+	Interfaces []InterfaceStatus `json:"interfaces"`
+	Neighbors  []NeighborStatus  `json:"neighbors"`
+	Routes     []RouteStatus     `json:"routes"`
+}
+
+// LSAStatus describes an entry of a link state database. Area-scoped
+// LSAs carry the area, link-scoped ones the interface as well.
+type LSAStatus struct {
+	Version     int    `json:"version"`
+	Area        string `json:"area,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Type        string `json:"type"`
+	LinkStateID string `json:"link_state_id"`
+	AdvRouter   string `json:"adv_router"`
+	Sequence    string `json:"sequence"`
+	Age         int    `json:"age"`
+	Checksum    string `json:"checksum"`
+	Length      int    `json:"length"`
+}
+
+// endpoint sends and receives OSPF packets on one interface. The Linux
+// implementation uses raw IP sockets; tests substitute in-memory links.
+type endpoint interface {
+	Send(pkt []byte, dst netip.Addr) error
+	// Recv returns the next packet with its source and destination.
+	Recv() (pkt []byte, src, dst netip.Addr, err error)
+	Close() error
+}
+
+// nextHop is a gateway of an installed route.
+type nextHop struct {
+	addr    netip.Addr
+	ifIndex int
+}
+
+// routeInstaller programs the kernel routing table. The Linux
+// implementation uses netlink; tests substitute a recorder.
+type routeInstaller interface {
+	Replace(prefix netip.Prefix, nextHops []nextHop) error
+	Delete(prefix netip.Prefix) error
+	// Flush removes OSPF routes left by a previous run.
+	Flush() error
+}
+
+// sysIface is a system network interface as seen by the router.
+type sysIface struct {
+	name     string
+	index    int
+	mtu      int
+	up       bool
+	loopback bool
+	p2p      bool
+	addrs    []netip.Prefix
+}
+
+// areaConfig is a configured area, shared by both protocol versions.
+type areaConfig struct {
+	id          uint32
+	stub        bool
+	noSummary   bool
+	defaultCost uint32
+	networks    []netip.Prefix
+}
+
+// Router runs OSPFv2 and OSPFv3.
+type Router struct {
+	routerID uint32
+	areas    []areaConfig
+	ifCfg    map[string]config.OSPFInterface
+	tick     time.Duration
+	list     func() ([]sysIface, error)
+	open     func(ifc sysIface, version int) (endpoint, error)
+	routes   routeInstaller
+	logger   *logging.Logger
+
+	frrDaemons string // FRR daemons file checked for a leftover ospfd
+	command    func(name string, args ...string) error
+
+	mu    sync.Mutex
+	now   uint64 // Clock ticks since Start
+	insts [2]*instance
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRouter validates and converts the OSPF config. Call Start to bring
+// up the interfaces.
+func NewRouter(cfg *config.OSPF, logger *logging.Logger) (*Router, error) {
+	r := &Router{
+		ifCfg:  make(map[string]config.OSPFInterface),
+		tick:   defaultTick,
+		list:   listInterfaces,
+		open:   openEndpoint,
+		routes: newRouteInstaller(),
+		logger: logger,
+
+		frrDaemons: frrDaemonsFile,
+		command:    runCommand,
+	}
+
+	if cfg.RouterID != "" {
+		id, err := netip.ParseAddr(cfg.RouterID)
+		if err != nil || !id.Is4() || id.IsUnspecified() {
+			return nil, fmt.Errorf("ospf: invalid router ID %q", cfg.RouterID)
+		}
+		r.routerID = u32(id)
+	} else {
+		id, err := defaultRouterID()
+		if err != nil {
+			return nil, err
+		}
+		r.routerID = u32(id)
+	}
+
+	// The top-level networks belong to the backbone
+	backbone := areaConfig{id: 0, defaultCost: 1}
+	if err := addNetworks(&backbone, cfg.Networks); err != nil {
+		return nil, err
+	}
+	r.areas = append(r.areas, backbone)
+	for i := range cfg.Areas {
+		a := &cfg.Areas[i]
+		id, err := a.AreaID()
+		if err != nil {
+			return nil, fmt.Errorf("ospf: %w", err)
+		}
+		if id == 0 {
+			if err := addNetworks(&r.areas[0], a.Networks); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ac := areaConfig{id: id, stub: a.Stub, noSummary: a.NoSummary, defaultCost: 1}
+		if a.DefaultCost > 0 {
+			ac.defaultCost = uint32(a.DefaultCost)
+		}
+		if err := addNetworks(&ac, a.Networks); err != nil {
+			return nil, err
+		}
+		r.areas = append(r.areas, ac)
+	}
+
+	for _, ic := range cfg.Interfaces {
+		r.ifCfg[ic.Name] = ic
+	}
+	r.insts[0] = newInstance(r, 2)
+	r.insts[1] = newInstance(r, 3)
+	return r, nil
+}
+
+func addNetworks(a *areaConfig, networks []string) error {
+	for _, n := range networks {
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			return fmt.Errorf("ospf: area %s network %q: %w", idString(a.id), n, err)
+		}
+		a.networks = append(a.networks, prefix.Masked())
+	}
+	return nil
+}
+
+// defaultRouterID picks the highest IPv4 interface address, as routing
+// daemons conventionally do.
+func defaultRouterID() (netip.Addr, error) {
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("ospf: router ID: %w", err)
+	}
+	var id netip.Addr
+	for _, ifc := range ifaces {
+		for _, p := range ifc.addrs {
+			if addr := p.Addr(); addr.Is4() && !addr.IsLoopback() && (!id.IsValid() || id.Less(addr)) {
+				id = addr
+			}
+		}
+	}
+	if !id.IsValid() {
+		return netip.Addr{}, errors.New("ospf: no IPv4 address for the router ID; set router_id")
+	}
+	return id, nil
+}
+
+func listInterfaces() ([]sysIface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]sysIface, 0, len(ifaces))
+	for _, ifc := range ifaces {
+		s := sysIface{
+			name:     ifc.Name,
+			index:    ifc.Index,
+			mtu:      ifc.MTU,
+			up:       ifc.Flags&net.FlagUp != 0 && ifc.Flags&net.FlagRunning != 0,
+			loopback: ifc.Flags&net.FlagLoopback != 0,
+			p2p:      ifc.Flags&net.FlagPointToPoint != 0,
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ones, _ := ipNet.Mask.Size()
+			s.addrs = append(s.addrs, netip.PrefixFrom(addr.Unmap(), ones))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Start brings up the interfaces and starts the protocol timers.
+func (r *Router) Start() error {
+	if stopped, err := retireFRR(r.frrDaemons, r.command); err != nil {
+		r.logger.Warn("Failed to stop FRR ospfd", "error", err)
+	} else if stopped {
+		r.logger.Info("Stopped and disabled FRR ospfd")
+	}
+	if err := r.routes.Flush(); err != nil {
+		r.logger.Warn("Failed to remove stale OSPF routes", "error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.mu.Lock()
+	r.syncInterfaces()
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(ctx)
+	}()
+	r.logger.Info("OSPF router started", "router_id", idString(r.routerID), "areas", len(r.areas))
+	return nil
+}
+
+// Stop flushes the router's LSAs, says goodbye to the neighbors and
+// removes the installed routes.
+func (r *Router) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+
+	r.mu.Lock()
+	for _, inst := range r.insts {
+		inst.shutdown()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, inst := range r.insts {
+		for prefix := range inst.installed {
+			if err := r.routes.Delete(prefix); err != nil {
+				r.logger.Warn("Failed to remove OSPF route", "prefix", prefix, "error", err)
+			}
+		}
+		clear(inst.installed)
+	}
+}
+
+func (r *Router) run(ctx context.Context) {
+	ticker := time.NewTicker(r.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		// Stop may have shut the instances down while we waited
+		if ctx.Err() != nil {
+			r.mu.Unlock()
+			return
+		}
+		r.now++
+		if r.now%pollInterval == 0 {
+			r.syncInterfaces()
+		}
+		for _, inst := range r.insts {
+			inst.tick()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// receive reads packets from an interface's endpoint until it is closed.
+func (r *Router) receive(i *iface, ep endpoint) {
+	for {
+		pkt, src, dst, err := ep.Recv()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			r.logger.Debug("OSPF receive failed", "interface", i.name, "error", err)
+			time.Sleep(r.tick)
+			continue
+		}
+		r.mu.Lock()
+		if i.conn == ep {
+			i.receive(pkt, src, dst)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// syncInterfaces starts, restarts and stops interfaces to match the
+// system's interfaces and the area networks. Called with r.mu held.
+func (r *Router) syncInterfaces() {
+	sys, err := r.list()
+	if err != nil {
+		r.logger.Warn("Failed to list interfaces", "error", err)
+		return
+	}
+	for _, inst := range r.insts {
+		want := make(map[string]ifaceSpec)
+		for _, s := range sys {
+			if spec, ok := inst.spec(s); ok {
+				want[s.name] = spec
+			}
+		}
+		for _, i := range slices.Clone(inst.ifaces) {
+			if spec, ok := want[i.name]; !ok || spec.signature() != i.sig {
+				inst.removeIface(i)
+			}
+		}
+		for _, s := range sys {
+			spec, ok := want[s.name]
+			if ok && inst.iface(s.name) == nil {
+				inst.addIface(spec)
+			}
+		}
+	}
+}
+
+// Status returns the interfaces, neighbors and routes of both versions.
+func (r *Router) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := Status{
+		RouterID:   idString(r.routerID),
+		Interfaces: []InterfaceStatus{},
+		Neighbors:  []NeighborStatus{},
+		Routes:     []RouteStatus{},
+	}
+	for _, inst := range r.insts {
+		for _, i := range inst.ifaces {
+			st.Interfaces = append(st.Interfaces, i.status())
+			for _, n := range i.sortedNeighbors() {
+				st.Neighbors = append(st.Neighbors, n.status())
+			}
+		}
+		st.Routes = append(st.Routes, inst.routeStatus()...)
+	}
+	return st
+}
+
+// Database returns the link state databases of both versions.
+func (r *Router) Database() []LSAStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := []LSAStatus{}
+	for _, inst := range r.insts {
+		inst.databases(func(db *lsdb, a *area, link *iface) {
+			for _, l := range db.sorted() {
+				st := LSAStatus{
+					Version:     inst.version,
+					Type:        typeName(inst.version, l.typ),
+					LinkStateID: idString(l.id),
+					AdvRouter:   idString(l.adv),
+					Sequence:    fmt.Sprintf("0x%08x", uint32(l.seq)),
+					Age:         int(l.ageAt(r.now)),
+					Checksum:    fmt.Sprintf("0x%04x", l.checksum),
+					Length:      int(l.length),
+				}
+				if a != nil {
+					st.Area = idString(a.id)
+				}
+				if link != nil {
+					st.Area, st.Interface = idString(link.area.id), link.name
+				}
+				out = append(out, st)
+			}
+		})
+	}
+	return out
+}
+
+// instance is one protocol version.
+type instance struct {
+	r       *Router
+	version int
+	areas   []*area
+	asdb    *lsdb // AS-scoped LSAs
+	ifaces  []*iface
+	failed  map[string]string // Signature of interfaces whose socket failed to open
+
+	// Self-origination state
+	lastOrig  map[dbKey]uint64
+	prefixIDs map[netip.Prefix]uint32 // OSPFv3 inter-area-prefix LSIDs
+
+	// SPF results
+	spfPending bool
+	rt         map[netip.Prefix]*rtEntry
+	asbrs      map[uint32]*rtEntry
+	installed  map[netip.Prefix]string // Next hop signature of installed routes
+}
+
+// area is an area of one protocol version.
+type area struct {
+	areaConfig
+	db     *lsdb
+	ifaces []*iface
+}
+
+// dbKey identifies an LSA across the databases of an instance.
+type dbKey struct {
+	db  *lsdb
+	key lsaKey
+}
+
+func newInstance(r *Router, version int) *instance {
+	inst := &instance{
+		r:         r,
+		version:   version,
+		asdb:      newLSDB(),
+		failed:    make(map[string]string),
+		lastOrig:  make(map[dbKey]uint64),
+		prefixIDs: make(map[netip.Prefix]uint32),
+		rt:        make(map[netip.Prefix]*rtEntry),
+		asbrs:     make(map[uint32]*rtEntry),
+		installed: make(map[netip.Prefix]string),
+	}
+	for _, ac := range r.areas {
+		inst.areas = append(inst.areas, &area{areaConfig: ac, db: newLSDB()})
+	}
+	return inst
+}
+
+func (inst *instance) is4() bool {
+	return inst.version == 2
+}
+
+// options returns the options advertised in an area.
+func (inst *instance) options(a *area) uint32 {
+	var opts uint32
+	if !a.stub {
+		opts |= optE
+	}
+	if inst.version == 3 {
+		opts |= optV6 | optR
+	}
+	return opts
+}
+
+func (inst *instance) iface(name string) *iface {
+	for _, i := range inst.ifaces {
+		if i.name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// active reports the areas with at least one interface.
+func (inst *instance) active() []*area {
+	var out []*area
+	for _, a := range inst.areas {
+		if len(a.ifaces) > 0 {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// isABR reports whether the router is attached to several areas.
+func (inst *instance) isABR() bool {
+	return len(inst.active()) > 1
+}
+
+// areaOf returns the area whose networks contain addr.
+func (inst *instance) areaOf(addr netip.Addr) *area {
+	for _, a := range inst.areas {
+		if slices.ContainsFunc(a.networks, func(n netip.Prefix) bool { return n.Contains(addr) }) {
+			return a
+		}
+	}
+	return nil
+}
+
+// hasNetworks reports whether any area has networks of the instance's
+// address family.
+func (inst *instance) hasNetworks() bool {
+	for _, a := range inst.areas {
+		if slices.ContainsFunc(a.networks, func(n netip.Prefix) bool { return n.Addr().Is4() == inst.is4() }) {
+			return true
+		}
+	}
+	return false
+}
+
+// spec derives the interface parameters for a system interface, if the
+// interface runs this version.
+func (inst *instance) spec(s sysIface) (ifaceSpec, bool) {
+	if !s.up {
+		return ifaceSpec{}, false
+	}
+	spec := ifaceSpec{sys: s}
+	for _, p := range s.addrs {
+		addr := p.Addr()
+		if addr.Is4() != inst.is4() || addr.IsLinkLocalUnicast() {
+			continue
+		}
+		if a := inst.areaOf(addr); a != nil && (spec.area == nil || spec.area == a) {
+			spec.area = a
+			spec.prefixes = append(spec.prefixes, p)
+		}
+	}
+	if spec.area == nil && !inst.is4() && inst.hasNetworks() {
+		// Tunnels often carry only IPv4 and link-local addresses. OSPFv3
+		// runs on them in the area their IPv4 address belongs to.
+		for _, p := range s.addrs {
+			if a := inst.areaOf(p.Addr()); a != nil && p.Addr().Is4() {
+				spec.area = a
+				break
+			}
+		}
+	}
+	if spec.area == nil {
+		return ifaceSpec{}, false
+	}
+
+	ic, configured := inst.r.ifCfg[s.name]
+	spec.passive = ic.Passive || s.loopback
+	spec.typ = ifBroadcast
+	if ic.Type == "point-to-point" || ic.Type == "" && s.p2p {
+		spec.typ = ifPointToPoint
+	}
+	spec.cost = uint16(cmp.Or(ic.Cost, defaultCost))
+	if s.loopback && (!configured || ic.Cost == 0) {
+		spec.cost = 0
+	}
+	spec.helloInt = uint32(cmp.Or(ic.HelloInterval, defaultHelloInterval))
+	spec.deadInt = uint32(cmp.Or(ic.DeadInterval, 4*int(spec.helloInt)))
+	spec.priority = defaultPriority
+	if ic.Priority != nil {
+		spec.priority = uint8(*ic.Priority)
+	}
+
+	if inst.is4() {
+		spec.addr = spec.prefixes[0].Addr()
+	} else {
+		for _, p := range s.addrs {
+			if p.Addr().Is6() && p.Addr().IsLinkLocalUnicast() {
+				spec.addr = p.Addr()
+				break
+			}
+		}
+		// OSPFv3 runs over link-local addresses
+		if !spec.addr.IsValid() && !spec.passive {
+			return ifaceSpec{}, false
+		}
+	}
+	return spec, true
+}
+
+func (inst *instance) addIface(spec ifaceSpec) {
+	r := inst.r
+	sig := spec.signature()
+	i := newIface(inst, spec)
+	if !i.passive {
+		if inst.failed[i.name] == sig {
+			return
+		}
+		ep, err := r.open(spec.sys, inst.version)
+		if err != nil {
+			inst.failed[i.name] = sig
+			r.logger.Warn("Failed to open OSPF socket", "interface", i.name, "version", inst.version, "error", err)
+			return
+		}
+		delete(inst.failed, i.name)
+		i.conn = ep
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.receive(i, ep)
+		}()
+	}
+	inst.ifaces = append(inst.ifaces, i)
+	i.area.ifaces = append(i.area.ifaces, i)
+	i.up()
+	r.logger.Info("OSPF interface up", "interface", i.name, "version", inst.version, "area", idString(i.area.id), "type", i.typ, "passive", i.passive)
+}
+
+func (inst *instance) removeIface(i *iface) {
+	i.down()
+	inst.ifaces = slices.DeleteFunc(inst.ifaces, func(x *iface) bool { return x == i })
+	i.area.ifaces = slices.DeleteFunc(i.area.ifaces, func(x *iface) bool { return x == i })
+	inst.spfPending = true
+	inst.r.logger.Info("OSPF interface down", "interface", i.name, "version", inst.version)
+}
+
+// shutdown flushes the self-originated LSAs and brings all interfaces
+// down, telling the neighbors. Called with r.mu held.
+func (inst *instance) shutdown() {
+	now := inst.r.now
+	inst.databases(func(db *lsdb, a *area, link *iface) {
+		for _, l := range db.sorted() {
+			if l.adv == inst.r.routerID && l.ageAt(now) < maxAge {
+				flushed := l.maxAged()
+				inst.install(db, flushed, l)
+				inst.flood(flushed, a, link, nil)
+			}
+		}
+	})
+	for _, i := range slices.Clone(inst.ifaces) {
+		i.goodbye()
+		inst.removeIface(i)
+	}
+}
+
+// databases calls fn for the AS database, each area database and each
+// link database.
+func (inst *instance) databases(fn func(db *lsdb, a *area, link *iface)) {
+	fn(inst.asdb, nil, nil)
+	for _, a := range inst.areas {
+		fn(a.db, a, nil)
+	}
+	for _, i := range inst.ifaces {
+		fn(i.db, nil, i)
+	}
+}
+
+// tick runs the timers. Called with r.mu held.
+func (inst *instance) tick() {
+	for _, i := range inst.ifaces {
+		i.tick()
+	}
+	inst.age()
+	inst.originate()
+	if inst.spfPending {
+		inst.spfPending = false
+		inst.runSPF()
+		// Summaries follow the routing table
+		inst.originate()
+	}
+}
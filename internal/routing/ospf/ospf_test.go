@@ -0,0 +1,462 @@
+package ospf
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+)
+
+type fakePacket struct {
+	pkt      []byte
+	src, dst netip.Addr
+}
+
+// fakeNet connects endpoints on named segments. Multicast reaches every
+// other endpoint on the segment, unicast the endpoint with the address.
+type fakeNet struct {
+	mu       sync.Mutex
+	segments map[string][]*fakeEndpoint
+	cut      map[string]bool
+}
+
+func newFakeNet() *fakeNet {
+	return &fakeNet{segments: make(map[string][]*fakeEndpoint), cut: make(map[string]bool)}
+}
+
+type fakeEndpoint struct {
+	net    *fakeNet
+	seg    string
+	addr   netip.Addr
+	in     chan fakePacket
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (n *fakeNet) attach(seg string, addr netip.Addr) *fakeEndpoint {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ep := &fakeEndpoint{net: n, seg: seg, addr: addr, in: make(chan fakePacket, 4096), closed: make(chan struct{})}
+	n.segments[seg] = append(n.segments[seg], ep)
+	return ep
+}
+
+// setCut drops all traffic on a segment.
+func (n *fakeNet) setCut(seg string, cut bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cut[seg] = cut
+}
+
+func (e *fakeEndpoint) Send(pkt []byte, dst netip.Addr) error {
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+	if e.net.cut[e.seg] {
+		return nil
+	}
+	for _, other := range e.net.segments[e.seg] {
+		if other == e || !dst.IsMulticast() && other.addr != dst {
+			continue
+		}
+		select {
+		case other.in <- fakePacket{pkt: slices.Clone(pkt), src: e.addr, dst: dst}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (e *fakeEndpoint) Recv() ([]byte, netip.Addr, netip.Addr, error) {
+	select {
+	case p := <-e.in:
+		return p.pkt, p.src, p.dst, nil
+	case <-e.closed:
+		return nil, netip.Addr{}, netip.Addr{}, net.ErrClosed
+	}
+}
+
+func (e *fakeEndpoint) Close() error {
+	e.once.Do(func() {
+		e.net.mu.Lock()
+		e.net.segments[e.seg] = slices.DeleteFunc(e.net.segments[e.seg], func(x *fakeEndpoint) bool { return x == e })
+		e.net.mu.Unlock()
+		close(e.closed)
+	})
+	return nil
+}
+
+// fakeRoutes records the routing table instead of programming the kernel.
+type fakeRoutes struct {
+	mu     sync.Mutex
+	routes map[netip.Prefix][]nextHop
+}
+
+func (f *fakeRoutes) Replace(prefix netip.Prefix, nextHops []nextHop) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[prefix] = nextHops
+	return nil
+}
+
+func (f *fakeRoutes) Delete(prefix netip.Prefix) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.routes, prefix)
+	return nil
+}
+
+func (f *fakeRoutes) Flush() error {
+	return nil
+}
+
+// via returns the next hop addresses of prefix, if installed.
+func (f *fakeRoutes) via(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, nh := range f.routes[netip.MustParsePrefix(prefix)] {
+		out = append(out, nh.addr.String())
+	}
+	return out
+}
+
+func (f *fakeRoutes) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.routes)
+}
+
+// testIface describes an interface of a test router and the segment it
+// is plugged into.
+type testIface struct {
+	name  string
+	seg   string
+	p2p   bool
+	addrs []string
+}
+
+type testRouter struct {
+	*Router
+	routes *fakeRoutes
+}
+
+func newTestRouter(t *testing.T, n *fakeNet, cfg *config.OSPF, ifaces ...testIface) *testRouter {
+	t.Helper()
+	r, err := NewRouter(cfg, logging.New(logging.DefaultConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sys []sysIface
+	segs := make(map[string]string)
+	for k, ti := range ifaces {
+		s := sysIface{name: ti.name, index: k + 2, mtu: 1500, up: true, p2p: ti.p2p}
+		for _, a := range ti.addrs {
+			s.addrs = append(s.addrs, netip.MustParsePrefix(a))
+		}
+		sys = append(sys, s)
+		segs[ti.name] = ti.seg
+	}
+	routes := &fakeRoutes{routes: make(map[netip.Prefix][]nextHop)}
+	r.tick = 10 * time.Millisecond
+	r.routes = routes
+	r.frrDaemons = ""
+	r.list = func() ([]sysIface, error) { return sys, nil }
+	r.open = func(ifc sysIface, version int) (endpoint, error) {
+		for _, p := range ifc.addrs {
+			if addr := p.Addr(); version == 2 && addr.Is4() || version == 3 && addr.IsLinkLocalUnicast() {
+				return n.attach(segs[ifc.name], addr), nil
+			}
+		}
+		t.Fatalf("no address for OSPFv%d on %s", version, ifc.name)
+		return nil, nil
+	}
+	t.Cleanup(r.Stop)
+	return &testRouter{Router: r, routes: routes}
+}
+
+func (r *testRouter) neighborState(id string, version int) NeighborState {
+	for _, n := range r.Status().Neighbors {
+		if n.RouterID == id && n.Version == version {
+			return n.State
+		}
+	}
+	return StateDown
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equal(got []string, want ...string) bool {
+	return slices.Equal(got, want)
+}
+
+// TestPointToPoint runs two sites over a tunnel in the backbone with both
+// protocol versions.
+func TestPointToPoint(t *testing.T) {
+	n := newFakeNet()
+	lanPassive := []config.OSPFInterface{{Name: "lan0", Passive: true}}
+	a := newTestRouter(t, n, &config.OSPF{RouterID: "10.255.0.1", Networks: []string{"10.0.0.0/8", "2001:db8::/32"}, Interfaces: lanPassive},
+		testIface{name: "wg0", seg: "tunnel", p2p: true, addrs: []string{"10.254.0.1/30", "fe80::a/64"}},
+		testIface{name: "lan0", seg: "lan-a", addrs: []string{"10.1.0.1/24", "2001:db8:1::1/64"}},
+	)
+	b := newTestRouter(t, n, &config.OSPF{RouterID: "10.255.0.2", Networks: []string{"10.0.0.0/8", "2001:db8::/32"}, Interfaces: lanPassive},
+		testIface{name: "wg0", seg: "tunnel", p2p: true, addrs: []string{"10.254.0.2/30", "fe80::b/64"}},
+		testIface{name: "lan0", seg: "lan-b", addrs: []string{"10.2.0.1/24", "2001:db8:2::1/64"}},
+	)
+	for _, r := range []*testRouter{a, b} {
+		if err := r.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "A to learn B's networks", func() bool {
+		return equal(a.routes.via("10.2.0.0/24"), "10.254.0.2") && equal(a.routes.via("2001:db8:2::/64"), "fe80::b")
+	})
+	waitFor(t, "B to learn A's networks", func() bool {
+		return equal(b.routes.via("10.1.0.0/24"), "10.254.0.1") && equal(b.routes.via("2001:db8:1::/64"), "fe80::a")
+	})
+	for _, v := range []int{2, 3} {
+		if st := a.neighborState("10.255.0.2", v); st != StateFull {
+			t.Errorf("OSPFv%d neighbor state %s", v, st)
+		}
+	}
+	// The tunnel itself is connected on both ends
+	if got := a.routes.via("10.254.0.0/30"); got != nil {
+		t.Errorf("connected network installed via %v", got)
+	}
+
+	st := a.Status()
+	var lan InterfaceStatus
+	for _, i := range st.Interfaces {
+		if i.Name == "lan0" && i.Version == 2 {
+			lan = i
+		}
+	}
+	if !lan.Passive || lan.Neighbors != 0 || lan.Area != "0.0.0.0" {
+		t.Errorf("lan0 status = %+v", lan)
+	}
+	var route RouteStatus
+	for _, rs := range st.Routes {
+		if rs.Prefix == "10.2.0.0/24" {
+			route = rs
+		}
+	}
+	if route.Type != "intra-area" || route.Cost != 20 || len(route.NextHops) != 1 || route.NextHops[0] != (NextHop{Address: "10.254.0.2", Interface: "wg0"}) {
+		t.Errorf("route status = %+v", route)
+	}
+
+	// Losing the tunnel withdraws the routes after the dead interval
+	n.setCut("tunnel", true)
+	waitFor(t, "A to withdraw B's networks", func() bool { return a.routes.count() == 0 })
+	if st := a.neighborState("10.255.0.2", 2); st != StateDown {
+		t.Errorf("neighbor state after the tunnel went down = %s", st)
+	}
+	n.setCut("tunnel", false)
+	waitFor(t, "the adjacency to come back", func() bool { return len(a.routes.via("10.2.0.0/24")) == 1 })
+}
+
+// TestBroadcast_DR runs three routers on one LAN.
+func TestBroadcast_DR(t *testing.T) {
+	n := newFakeNet()
+	prio := func(p int) *int { return &p }
+	var routers []*testRouter
+	for k, p := range []int{1, 0, 5} {
+		id := k + 1
+		r := newTestRouter(t, n, &config.OSPF{
+			RouterID:   netip.AddrFrom4([4]byte{10, 255, 0, byte(id)}).String(),
+			Networks:   []string{"192.0.2.0/24", "10.0.0.0/8", "2001:db8::/32"},
+			Interfaces: []config.OSPFInterface{{Name: "eth0", Priority: prio(p)}, {Name: "lan0", Passive: true}},
+		},
+			testIface{name: "eth0", seg: "lan", addrs: []string{netip.AddrFrom4([4]byte{192, 0, 2, byte(id)}).String() + "/24", "fe80::" + string(rune('0'+id)) + "/64"}},
+			testIface{name: "lan0", seg: "stub", addrs: []string{netip.AddrFrom4([4]byte{10, byte(id), 0, 1}).String() + "/24"}},
+		)
+		if err := r.Start(); err != nil {
+			t.Fatal(err)
+		}
+		routers = append(routers, r)
+	}
+	r1, r2, r3 := routers[0], routers[1], routers[2]
+
+	waitFor(t, "full routing tables", func() bool {
+		for _, r := range routers {
+			if r.routes.count() != 2 {
+				return false
+			}
+		}
+		return true
+	})
+	if got := r2.routes.via("10.3.0.0/24"); !equal(got, "192.0.2.3") {
+		t.Errorf("r2: 10.3.0.0/24 via %v", got)
+	}
+	if got := r1.routes.via("10.2.0.0/24"); !equal(got, "192.0.2.2") {
+		t.Errorf("r1: 10.2.0.0/24 via %v", got)
+	}
+
+	// r3 has the highest priority; r2 is ineligible
+	for _, i := range r1.Status().Interfaces {
+		if i.Name == "eth0" && i.Version == 2 && (i.DR != "192.0.2.3" || i.BDR != "192.0.2.1" || i.State != "backup") {
+			t.Errorf("r1 eth0 = %+v", i)
+		}
+	}
+	for _, nb := range r2.Status().Neighbors {
+		if nb.Version == 2 && nb.State != StateFull {
+			t.Errorf("r2 neighbor %s in %s", nb.RouterID, nb.State)
+		}
+	}
+	var networkLSAs []LSAStatus
+	for _, l := range r1.Database() {
+		if l.Type == "network" && l.Version == 2 {
+			networkLSAs = append(networkLSAs, l)
+		}
+	}
+	if len(networkLSAs) != 1 || networkLSAs[0].AdvRouter != "10.255.0.3" || networkLSAs[0].LinkStateID != "192.0.2.3" {
+		t.Errorf("network-LSAs = %+v", networkLSAs)
+	}
+
+	// The BDR takes over when the DR leaves
+	r3.Stop()
+	waitFor(t, "r1 to become DR", func() bool {
+		for _, i := range r1.Status().Interfaces {
+			if i.Name == "eth0" && i.Version == 2 {
+				return i.State == "dr"
+			}
+		}
+		return false
+	})
+	waitFor(t, "r3's network to be withdrawn", func() bool {
+		return r2.routes.via("10.3.0.0/24") == nil && equal(r2.routes.via("10.1.0.0/24"), "192.0.2.1")
+	})
+}
+
+// TestStubArea runs a backbone router A, an area border router B and a
+// branch router C in stub area 1:
+//
+//	A --(broadcast, area 0)-- B --(point-to-point, area 1)-- C
+func TestStubArea(t *testing.T) {
+	for _, noSummary := range []bool{false, true} {
+		name := "stub"
+		if noSummary {
+			name = "totally stubby"
+		}
+		t.Run(name, func(t *testing.T) {
+			n := newFakeNet()
+			area1 := config.OSPFArea{ID: "0.0.0.1", Networks: []string{"10.1.0.0/16", "2001:db8:1::/48"}, Stub: true, NoSummary: noSummary, DefaultCost: 5}
+			passive := []config.OSPFInterface{{Name: "lan0", Passive: true}}
+			a := newTestRouter(t, n, &config.OSPF{RouterID: "10.255.0.1", Networks: []string{"10.0.0.0/16", "2001:db8::/48"}, Interfaces: passive},
+				testIface{name: "eth0", seg: "backbone", addrs: []string{"10.0.0.1/24", "fe80::a/64"}},
+				testIface{name: "lan0", seg: "lan-a", addrs: []string{"10.0.1.1/24", "2001:db8:0:1::1/64"}},
+			)
+			b := newTestRouter(t, n, &config.OSPF{RouterID: "10.255.0.2", Networks: []string{"10.0.0.0/16", "2001:db8::/48"}, Areas: []config.OSPFArea{area1}},
+				testIface{name: "eth0", seg: "backbone", addrs: []string{"10.0.0.2/24", "fe80::b/64"}},
+				testIface{name: "wg0", seg: "tunnel", p2p: true, addrs: []string{"10.1.255.1/30", "fe80::b/64"}},
+			)
+			c := newTestRouter(t, n, &config.OSPF{RouterID: "10.255.0.3", Areas: []config.OSPFArea{area1}, Interfaces: passive},
+				testIface{name: "wg0", seg: "tunnel", p2p: true, addrs: []string{"10.1.255.2/30", "fe80::c/64"}},
+				testIface{name: "lan0", seg: "lan-c", addrs: []string{"10.1.1.1/24", "2001:db8:1:1::1/64"}},
+			)
+			for _, r := range []*testRouter{a, b, c} {
+				if err := r.Start(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			waitFor(t, "A to learn C's networks", func() bool {
+				return equal(a.routes.via("10.1.1.0/24"), "10.0.0.2") && equal(a.routes.via("2001:db8:1:1::/64"), "fe80::b")
+			})
+			waitFor(t, "C to learn the default routes", func() bool {
+				return equal(c.routes.via("0.0.0.0/0"), "10.1.255.1") && equal(c.routes.via("::/0"), "fe80::b")
+			})
+			for _, rs := range a.Status().Routes {
+				if rs.Prefix == "10.1.1.0/24" && (rs.Type != "inter-area" || rs.Cost != 30) {
+					t.Errorf("A's route to C's LAN = %+v", rs)
+				}
+			}
+			waitFor(t, "C's routing table to settle", func() bool {
+				if noSummary {
+					return c.routes.count() == 2
+				}
+				return c.routes.via("10.0.1.0/24") != nil && c.routes.via("2001:db8:0:1::/64") != nil
+			})
+			if noSummary && c.routes.via("10.0.1.0/24") != nil {
+				t.Error("totally stubby area received a summary")
+			}
+			for _, l := range c.Database() {
+				if l.Type == "external" || l.Type == "asbr-summary" {
+					t.Errorf("stub area database holds %+v", l)
+				}
+			}
+
+			// C shutting down flushes its LSAs, so A forgets its LAN
+			// without waiting for the dead interval
+			c.Stop()
+			waitFor(t, "A to withdraw C's networks", func() bool {
+				return a.routes.via("10.1.1.0/24") == nil && a.routes.via("2001:db8:1:1::/64") == nil
+			})
+		})
+	}
+}
+
+func TestRetireFRR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemons")
+	daemons := "zebra=yes\nbgpd=no\nospfd=yes\nospf6d=no\n"
+	if err := os.WriteFile(path, []byte(daemons), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	run := func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+
+	stopped, err := retireFRR(path, run)
+	if err != nil || !stopped {
+		t.Fatalf("retireFRR() = %v, %v", stopped, err)
+	}
+	if want := []string{"rc-service frr stop", "rc-update del frr"}; !slices.Equal(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "zebra=yes\nbgpd=no\nospfd=no\nospf6d=no\n" {
+		t.Errorf("daemons file = %q", data)
+	}
+
+	// Only once
+	commands = nil
+	if stopped, err := retireFRR(path, run); err != nil || stopped || len(commands) != 0 {
+		t.Errorf("second retireFRR() = %v, %v, ran %q", stopped, err, commands)
+	}
+
+	// Without FRR there is nothing to do
+	if stopped, err := retireFRR(filepath.Join(t.TempDir(), "missing"), run); err != nil || stopped {
+		t.Errorf("retireFRR() without FRR = %v, %v", stopped, err)
+	}
+
+	// A failed stop is retried on the next start
+	if err := os.WriteFile(path, []byte(daemons), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fail := func(string, ...string) error { return errors.New("exit status 1") }
+	if _, err := retireFRR(path, fail); err == nil {
+		t.Error("expected an error when FRR can't be stopped")
+	}
+	if data, _ := os.ReadFile(path); string(data) != daemons {
+		t.Errorf("daemons file changed after a failed stop: %q", data)
+	}
+}
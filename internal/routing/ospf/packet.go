@@ -0,0 +1,304 @@
+package ospf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Packet types (RFC 2328 A.3.1, RFC 5340 A.3.1).
+const (
+	pktHello = 1
+	pktDBD   = 2
+	pktLSR   = 3
+	pktLSU   = 4
+	pktAck   = 5
+)
+
+const (
+	headerLenV2  = 24
+	headerLenV3  = 16
+	lsaHeaderLen = 20
+)
+
+// Database Description flags.
+const (
+	ddMS   = 0x01 // Master
+	ddMore = 0x02
+	ddInit = 0x04
+)
+
+var errShortPacket = errors.New("truncated packet")
+
+// header is the common OSPF packet header.
+type header struct {
+	typ      uint8
+	routerID uint32
+	areaID   uint32
+	instance uint8 // OSPFv3 Instance ID
+}
+
+func headerLen(v int) int {
+	if v == 3 {
+		return headerLenV3
+	}
+	return headerLenV2
+}
+
+// marshalPacket prepends the OSPF header to body. OSPFv2 packets carry
+// the IP checksum with null authentication; the kernel computes the
+// OSPFv3 checksum over the IPv6 pseudo-header (IPV6_CHECKSUM).
+func marshalPacket(v int, h header, body []byte) []byte {
+	hl := headerLen(v)
+	b := make([]byte, hl+len(body))
+	b[0] = byte(v)
+	b[1] = h.typ
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[4:8], h.routerID)
+	binary.BigEndian.PutUint32(b[8:12], h.areaID)
+	if v == 3 {
+		b[14] = h.instance
+	}
+	copy(b[hl:], body)
+	if v == 2 {
+		binary.BigEndian.PutUint16(b[12:14], ipChecksum(b))
+	}
+	return b
+}
+
+// parsePacket validates the header and returns it with the body.
+func parsePacket(b []byte, v int) (header, []byte, error) {
+	hl := headerLen(v)
+	if len(b) < hl {
+		return header{}, nil, errShortPacket
+	}
+	if int(b[0]) != v {
+		return header{}, nil, fmt.Errorf("version %d, want %d", b[0], v)
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < hl || length > len(b) {
+		return header{}, nil, fmt.Errorf("bad packet length %d", length)
+	}
+	b = b[:length]
+	h := header{
+		typ:      b[1],
+		routerID: binary.BigEndian.Uint32(b[4:8]),
+		areaID:   binary.BigEndian.Uint32(b[8:12]),
+	}
+	if v == 2 {
+		if auType := binary.BigEndian.Uint16(b[14:16]); auType != 0 {
+			return header{}, nil, fmt.Errorf("unsupported authentication type %d", auType)
+		}
+		if ipChecksum(b) != 0 {
+			return header{}, nil, errors.New("bad checksum")
+		}
+	} else {
+		h.instance = b[14]
+	}
+	return h, b[hl:], nil
+}
+
+// ipChecksum is the Internet checksum (RFC 1071). OSPFv2 excludes the
+// authentication field, which is zero with null authentication.
+func ipChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// hello is a Hello packet body.
+type hello struct {
+	mask      uint32 // OSPFv2 network mask
+	ifID      uint32 // OSPFv3 Interface ID
+	interval  uint16
+	options   uint32
+	priority  uint8
+	dead      uint32
+	dr, bdr   uint32 // Interface addresses in OSPFv2, router IDs in OSPFv3
+	neighbors []uint32
+}
+
+func (h *hello) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		b = binary.BigEndian.AppendUint32(b, h.mask)
+		b = binary.BigEndian.AppendUint16(b, h.interval)
+		b = append(b, byte(h.options), h.priority)
+		b = binary.BigEndian.AppendUint32(b, h.dead)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, h.ifID)
+		b = binary.BigEndian.AppendUint32(b, uint32(h.priority)<<24|h.options&0xffffff)
+		b = binary.BigEndian.AppendUint16(b, h.interval)
+		b = binary.BigEndian.AppendUint16(b, uint16(h.dead))
+	}
+	b = binary.BigEndian.AppendUint32(b, h.dr)
+	b = binary.BigEndian.AppendUint32(b, h.bdr)
+	for _, n := range h.neighbors {
+		b = binary.BigEndian.AppendUint32(b, n)
+	}
+	return b
+}
+
+func parseHello(b []byte, v int) (*hello, error) {
+	const fixed = 20
+	if len(b) < fixed || (len(b)-fixed)%4 != 0 {
+		return nil, errShortPacket
+	}
+	h := &hello{}
+	if v == 2 {
+		h.mask = binary.BigEndian.Uint32(b[0:4])
+		h.interval = binary.BigEndian.Uint16(b[4:6])
+		h.options = uint32(b[6])
+		h.priority = b[7]
+		h.dead = binary.BigEndian.Uint32(b[8:12])
+	} else {
+		h.ifID = binary.BigEndian.Uint32(b[0:4])
+		h.priority = b[4]
+		h.options = binary.BigEndian.Uint32(b[4:8]) & 0xffffff
+		h.interval = binary.BigEndian.Uint16(b[8:10])
+		h.dead = uint32(binary.BigEndian.Uint16(b[10:12]))
+	}
+	h.dr = binary.BigEndian.Uint32(b[12:16])
+	h.bdr = binary.BigEndian.Uint32(b[16:20])
+	for i := fixed; i < len(b); i += 4 {
+		h.neighbors = append(h.neighbors, binary.BigEndian.Uint32(b[i:i+4]))
+	}
+	return h, nil
+}
+
+// dbd is a Database Description packet body.
+type dbd struct {
+	mtu     uint16
+	options uint32
+	flags   uint8
+	seq     uint32
+	headers []lsaHeader
+}
+
+func (d *dbd) marshal(v int) []byte {
+	var b []byte
+	if v == 2 {
+		b = binary.BigEndian.AppendUint16(b, d.mtu)
+		b = append(b, byte(d.options), d.flags)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, d.options&0xffffff)
+		b = binary.BigEndian.AppendUint16(b, d.mtu)
+		b = append(b, 0, d.flags)
+	}
+	b = binary.BigEndian.AppendUint32(b, d.seq)
+	for i := range d.headers {
+		b = d.headers[i].append(b, v)
+	}
+	return b
+}
+
+func parseDBD(b []byte, v int) (*dbd, error) {
+	fixed := 8
+	if v == 3 {
+		fixed = 12
+	}
+	if len(b) < fixed || (len(b)-fixed)%lsaHeaderLen != 0 {
+		return nil, errShortPacket
+	}
+	d := &dbd{}
+	if v == 2 {
+		d.mtu = binary.BigEndian.Uint16(b[0:2])
+		d.options = uint32(b[2])
+		d.flags = b[3] & (ddInit | ddMore | ddMS)
+		d.seq = binary.BigEndian.Uint32(b[4:8])
+	} else {
+		d.options = binary.BigEndian.Uint32(b[0:4]) & 0xffffff
+		d.mtu = binary.BigEndian.Uint16(b[4:6])
+		d.flags = b[7] & (ddInit | ddMore | ddMS)
+		d.seq = binary.BigEndian.Uint32(b[8:12])
+	}
+	for i := fixed; i < len(b); i += lsaHeaderLen {
+		d.headers = append(d.headers, parseLSAHeader(b[i:], v))
+	}
+	return d, nil
+}
+
+// marshalLSR encodes Link State Request entries. The OSPFv2 32-bit LS
+// type and the OSPFv3 reserved field plus 16-bit LS type share a layout.
+func marshalLSR(keys []lsaKey) []byte {
+	b := make([]byte, 0, 12*len(keys))
+	for _, k := range keys {
+		b = binary.BigEndian.AppendUint32(b, uint32(k.typ))
+		b = binary.BigEndian.AppendUint32(b, k.id)
+		b = binary.BigEndian.AppendUint32(b, k.adv)
+	}
+	return b
+}
+
+func parseLSR(b []byte) ([]lsaKey, error) {
+	if len(b)%12 != 0 {
+		return nil, errShortPacket
+	}
+	keys := make([]lsaKey, 0, len(b)/12)
+	for i := 0; i < len(b); i += 12 {
+		keys = append(keys, lsaKey{
+			typ: uint16(binary.BigEndian.Uint32(b[i : i+4])),
+			id:  binary.BigEndian.Uint32(b[i+4 : i+8]),
+			adv: binary.BigEndian.Uint32(b[i+8 : i+12]),
+		})
+	}
+	return keys, nil
+}
+
+// marshalLSU encodes a Link State Update carrying the given encoded LSAs.
+func marshalLSU(lsas [][]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(lsas)))
+	for _, l := range lsas {
+		b = append(b, l...)
+	}
+	return b
+}
+
+// parseLSU splits a Link State Update into its encoded LSAs.
+func parseLSU(b []byte) ([][]byte, error) {
+	if len(b) < 4 {
+		return nil, errShortPacket
+	}
+	n := binary.BigEndian.Uint32(b[0:4])
+	b = b[4:]
+	var lsas [][]byte
+	for i := uint32(0); i < n; i++ {
+		if len(b) < lsaHeaderLen {
+			return nil, errShortPacket
+		}
+		length := int(binary.BigEndian.Uint16(b[18:20]))
+		if length < lsaHeaderLen || length > len(b) {
+			return nil, fmt.Errorf("bad LSA length %d", length)
+		}
+		lsas = append(lsas, b[:length])
+		b = b[length:]
+	}
+	return lsas, nil
+}
+
+func marshalAck(headers []lsaHeader, v int) []byte {
+	b := make([]byte, 0, lsaHeaderLen*len(headers))
+	for i := range headers {
+		b = headers[i].append(b, v)
+	}
+	return b
+}
+
+func parseAck(b []byte, v int) ([]lsaHeader, error) {
+	if len(b)%lsaHeaderLen != 0 {
+		return nil, errShortPacket
+	}
+	headers := make([]lsaHeader, 0, len(b)/lsaHeaderLen)
+	for i := 0; i < len(b); i += lsaHeaderLen {
+		headers = append(headers, parseLSAHeader(b[i:], v))
+	}
+	return headers, nil
+}
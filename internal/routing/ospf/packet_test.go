@@ -0,0 +1,153 @@
+package ospf
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, v := range []int{2, 3} {
+		h := &hello{mask: 0xffffff00, interval: 10, options: optE, priority: 1, dead: 40, dr: 0x0a000001, neighbors: []uint32{0x01010101, 0x02020202}}
+		if v == 3 {
+			h.mask, h.ifID, h.options = 0, 7, optV6|optE|optR
+		}
+		pkt := marshalPacket(v, header{typ: pktHello, routerID: 0x03030303, areaID: 1}, h.marshal(v))
+
+		hdr, body, err := parsePacket(pkt, v)
+		if err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		if hdr.typ != pktHello || hdr.routerID != 0x03030303 || hdr.areaID != 1 {
+			t.Errorf("v%d: header = %+v", v, hdr)
+		}
+		got, err := parseHello(body, v)
+		if err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Errorf("v%d: hello = %+v, want %+v", v, got, h)
+		}
+
+		d := &dbd{mtu: 1420, options: h.options, flags: ddMore | ddMS, seq: 42, headers: []lsaHeader{
+			{age: 3, typ: lsType(v, kindRouter), id: 1, adv: 1, seq: initialSeq, checksum: 0xabcd, length: 36},
+		}}
+		if v == 2 {
+			d.headers[0].options = optE
+		}
+		gotD, err := parseDBD(d.marshal(v), v)
+		if err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		if !reflect.DeepEqual(gotD, d) {
+			t.Errorf("v%d: dbd = %+v, want %+v", v, gotD, d)
+		}
+	}
+
+	// OSPFv2 packets carry a checksum
+	pkt := marshalPacket(2, header{typ: pktLSR, routerID: 1}, marshalLSR([]lsaKey{{typ: 1, id: 2, adv: 3}}))
+	pkt[len(pkt)-1] ^= 0xff
+	if _, _, err := parsePacket(pkt, 2); err == nil {
+		t.Error("corrupted packet accepted")
+	}
+	if _, _, err := parsePacket(pkt, 3); err == nil {
+		t.Error("OSPFv2 packet accepted as OSPFv3")
+	}
+}
+
+func TestLSARoundTrip(t *testing.T) {
+	p := netip.MustParsePrefix
+	check := func(t *testing.T, v int, kind lsaKind, id uint32, body []byte, want any) {
+		t.Helper()
+		l := newLSA(v, lsaHeader{typ: lsType(v, kind), id: id, adv: 0x0a000001, seq: initialSeq}, body)
+		got, err := parseLSA(l.raw, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.checksum != l.checksum || got.length != uint16(len(l.raw)) {
+			t.Errorf("header = %+v", got.lsaHeader)
+		}
+		if !reflect.DeepEqual(got.body, want) {
+			t.Errorf("body = %+v, want %+v", got.body, want)
+		}
+	}
+
+	t.Run("v2", func(t *testing.T) {
+		rl := &routerLSA{flags: flagB, links: []routerLink{
+			{typ: linkPointToPoint, id: 0x0a000002, data: 0xc0000201, metric: 10},
+			{typ: linkStub, id: 0xc0000200, data: 0xffffff00, metric: 10},
+		}}
+		check(t, 2, kindRouter, 0x0a000001, rl.marshal(2), rl)
+		nl := &networkLSA{mask: 0xffffff00, routers: []uint32{1, 2, 3}}
+		check(t, 2, kindNetwork, 0xc0000201, nl.marshal(2), nl)
+		sl := &summaryLSA{prefix: p("10.1.0.0/16"), metric: 20}
+		check(t, 2, kindSummaryNet, 0x0a010000, sl.marshal(2), sl)
+		al := &summaryLSA{asbr: true, router: 0x0a000009, metric: 30}
+		check(t, 2, kindSummaryASBR, 0x0a000009, al.marshal(2), al)
+		el := &externalLSA{prefix: p("203.0.113.0/24"), type2: true, metric: 100, forward: netip.MustParseAddr("192.0.2.9"), tag: 7}
+		check(t, 2, kindExternal, 0xcb007100, el.marshal(2), el)
+	})
+
+	t.Run("v3", func(t *testing.T) {
+		rl := &routerLSA{flags: flagE, options: optV6 | optE | optR, links: []routerLink{
+			{typ: linkTransit, metric: 10, data: 3, nbrIf: 4, id: 0x0a000002},
+		}}
+		check(t, 3, kindRouter, 0, rl.marshal(3), rl)
+		nl := &networkLSA{options: optV6 | optR, routers: []uint32{1, 2}}
+		check(t, 3, kindNetwork, 4, nl.marshal(3), nl)
+		sl := &summaryLSA{prefix: p("2001:db8:1::/48"), metric: 20}
+		check(t, 3, kindSummaryNet, 1, sl.marshal(3), sl)
+		al := &summaryLSA{asbr: true, router: 9, options: optV6 | optE | optR, metric: 30}
+		check(t, 3, kindSummaryASBR, 9, al.marshal(3), al)
+		el := &externalLSA{prefix: p("2001:db8:ff::/48"), metric: 5, forward: netip.MustParseAddr("2001:db8::9"), tag: 1}
+		check(t, 3, kindExternal, 2, el.marshal(3), el)
+		ll := &linkLSA{priority: 1, options: optV6 | optR, linkLocal: netip.MustParseAddr("fe80::1"), prefixes: []lsaPrefix{{prefix: p("2001:db8:1:2::/64")}, {prefix: p("2001:db8:4::/47")}}}
+		check(t, 3, kindLink, 3, ll.marshal(), ll)
+		ip := &intraPrefixLSA{refType: lsType(3, kindRouter), refAdv: 0x0a000001, prefixes: []lsaPrefix{{prefix: p("2001:db8::1/128"), options: prefixLA}, {prefix: p("2001:db8:1::/64"), metric: 10}}}
+		check(t, 3, kindIntraPrefix, 0, ip.marshal(), ip)
+	})
+}
+
+func TestLSAChecksum(t *testing.T) {
+	l := newLSA(2, lsaHeader{typ: 1, id: 1, adv: 1, seq: initialSeq}, (&routerLSA{}).marshal(2))
+	if !validLSAChecksum(l.raw) {
+		t.Fatal("checksum of a fresh LSA does not verify")
+	}
+	// The age is not covered, so LSAs can be aged in flight
+	if aged := l.encodeAt(100); !validLSAChecksum(aged) {
+		t.Error("checksum broken by aging")
+	}
+	l.raw[lsaHeaderLen-1] ^= 1
+	if _, err := parseLSA(l.raw, 2); err == nil {
+		t.Error("corrupted LSA accepted")
+	}
+}
+
+func TestCompareLSA(t *testing.T) {
+	base := lsaHeader{seq: initialSeq, checksum: 0x1000, age: 100}
+	with := func(modify func(h *lsaHeader)) lsaHeader {
+		h := base
+		modify(&h)
+		return h
+	}
+	tests := []struct {
+		name string
+		a, b lsaHeader
+		want int
+	}{
+		{"same", base, base, 0},
+		{"higher sequence", with(func(h *lsaHeader) { h.seq++ }), base, 1},
+		{"lower sequence", base, with(func(h *lsaHeader) { h.seq++ }), -1},
+		{"higher checksum", with(func(h *lsaHeader) { h.checksum++ }), base, 1},
+		{"max age", with(func(h *lsaHeader) { h.age = maxAge }), base, 1},
+		{"much younger", with(func(h *lsaHeader) { h.age = 0 }), with(func(h *lsaHeader) { h.age = maxAgeDiff + 1 }), 1},
+		{"slightly younger", with(func(h *lsaHeader) { h.age = 50 }), base, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareLSA(&tt.a, &tt.b); got != tt.want {
+				t.Errorf("compareLSA = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
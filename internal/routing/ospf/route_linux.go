@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package ospf
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkRoutes installs routes tagged "proto ospf", so they can be told
+// apart from static and kernel routes. Equal-cost paths become multipath
+// routes.
+type netlinkRoutes struct{}
+
+func newRouteInstaller() routeInstaller {
+	return netlinkRoutes{}
+}
+
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
+
+func (netlinkRoutes) Replace(prefix netip.Prefix, nextHops []nextHop) error {
+	route := &netlink.Route{
+		Dst:      ipNet(prefix),
+		Protocol: unix.RTPROT_OSPF,
+	}
+	if len(nextHops) == 1 {
+		route.Gw = nextHops[0].addr.AsSlice()
+		route.LinkIndex = nextHops[0].ifIndex
+	} else {
+		for _, nh := range nextHops {
+			route.MultiPath = append(route.MultiPath, &netlink.NexthopInfo{Gw: nh.addr.AsSlice(), LinkIndex: nh.ifIndex})
+		}
+	}
+	return netlink.RouteReplace(route)
+}
+
+func (netlinkRoutes) Delete(prefix netip.Prefix) error {
+	return netlink.RouteDel(&netlink.Route{
+		Dst:      ipNet(prefix),
+		Protocol: unix.RTPROT_OSPF,
+	})
+}
+
+func (netlinkRoutes) Flush() error {
+	filter := &netlink.Route{Table: unix.RT_TABLE_MAIN, Protocol: unix.RTPROT_OSPF}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, filter, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return err
+	}
+	for i := range routes {
+		if err := netlink.RouteDel(&routes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
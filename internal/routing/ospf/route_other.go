@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package ospf
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errUnsupported = errors.New("OSPF route installation is only supported on Linux")
+
+// unsupportedRoutes is used on non-Linux platforms.
+type unsupportedRoutes struct{}
+
+func newRouteInstaller() routeInstaller {
+	return unsupportedRoutes{}
+}
+
+func (unsupportedRoutes) Replace(prefix netip.Prefix, nextHops []nextHop) error {
+	return errUnsupported
+}
+
+func (unsupportedRoutes) Delete(prefix netip.Prefix) error {
+	return errUnsupported
+}
+
+func (unsupportedRoutes) Flush() error {
+	return nil
+}
@@ -0,0 +1,601 @@
+package ospf
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type pathType uint8
+
+const (
+	pathIntra pathType = iota + 1
+	pathInter
+	pathExt1
+	pathExt2
+)
+
+var pathTypeNames = map[pathType]string{pathIntra: "intra-area", pathInter: "inter-area", pathExt1: "external-1", pathExt2: "external-2"}
+
+// hop is a next hop. An invalid address means directly connected.
+type hop struct {
+	i    *iface
+	addr netip.Addr
+}
+
+// rtEntry is a routing table entry (RFC 2328 section 11).
+type rtEntry struct {
+	typ   pathType
+	area  *area
+	cost  uint32
+	cost2 uint32 // Type 2 external metric
+	hops  []hop
+}
+
+func (e *rtEntry) direct() bool {
+	return slices.ContainsFunc(e.hops, func(h hop) bool { return !h.addr.IsValid() })
+}
+
+// better reports whether e is preferred over o, and whether they are
+// equal-cost alternatives.
+func (e *rtEntry) better(o *rtEntry) (better, equal bool) {
+	if e.typ != o.typ {
+		return e.typ < o.typ, false
+	}
+	if e.typ == pathExt2 && e.cost2 != o.cost2 {
+		return e.cost2 < o.cost2, false
+	}
+	if e.cost != o.cost {
+		return e.cost < o.cost, false
+	}
+	return false, true
+}
+
+func mergeHops(a, b []hop) []hop {
+	out := slices.Clone(a)
+	for _, h := range b {
+		if !slices.Contains(out, h) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// add merges e into the table.
+func addRoute(rt map[netip.Prefix]*rtEntry, prefix netip.Prefix, e *rtEntry) {
+	cur := rt[prefix]
+	if cur == nil {
+		rt[prefix] = e
+		return
+	}
+	better, equal := e.better(cur)
+	switch {
+	case better:
+		rt[prefix] = e
+	case equal && cur.area == e.area:
+		cur.hops = mergeHops(cur.hops, e.hops)
+	}
+}
+
+// vertex is a node of the shortest path tree: a router, or a transit
+// network identified by its network-LSA.
+type vertex struct {
+	router bool
+	id     uint32 // Router ID, or network-LSA link state ID
+	adv    uint32 // Network-LSA advertising router
+	lsas   []*lsa
+	dist   uint32
+	hops   []hop
+	parent *vertex
+}
+
+type vertexKey struct {
+	router  bool
+	id, adv uint32
+}
+
+func (v *vertex) key() vertexKey {
+	return vertexKey{router: v.router, id: v.id, adv: v.adv}
+}
+
+func (v *vertex) flags() uint8 {
+	if len(v.lsas) == 0 {
+		return 0
+	}
+	return v.lsas[0].body.(*routerLSA).flags
+}
+
+func (v *vertex) links() []routerLink {
+	var out []routerLink
+	for _, l := range v.lsas {
+		out = append(out, l.body.(*routerLSA).links...)
+	}
+	return out
+}
+
+// routerVertex finds the router-LSAs of id in a.
+func (inst *instance) routerVertex(a *area, id uint32) *vertex {
+	v := &vertex{router: true, id: id}
+	typ := lsType(inst.version, kindRouter)
+	for _, l := range a.db.sorted() {
+		if l.typ == typ && l.adv == id && l.age < maxAge && l.ageAt(inst.r.now) < maxAge {
+			v.lsas = append(v.lsas, l)
+		}
+	}
+	if len(v.lsas) == 0 {
+		return nil
+	}
+	return v
+}
+
+// networkVertex finds a network-LSA. OSPFv2 transit links only name the
+// DR's address, so adv is unknown and the LSA is looked up by ID.
+func (inst *instance) networkVertex(a *area, id, adv uint32) *vertex {
+	typ := lsType(inst.version, kindNetwork)
+	now := inst.r.now
+	if inst.is4() {
+		for _, l := range a.db.sorted() {
+			if l.typ == typ && l.id == id && l.age < maxAge && l.ageAt(now) < maxAge {
+				return &vertex{id: id, adv: l.adv, lsas: []*lsa{l}}
+			}
+		}
+		return nil
+	}
+	l := a.db.lsas[lsaKey{typ: typ, id: id, adv: adv}]
+	if l == nil || l.age == maxAge || l.ageAt(now) == maxAge {
+		return nil
+	}
+	return &vertex{id: id, adv: adv, lsas: []*lsa{l}}
+}
+
+// linkBack finds w's link back to v, which both ends of an edge must
+// advertise (RFC 2328 section 16.1 step 2b).
+func (inst *instance) linkBack(w, v *vertex) (routerLink, bool) {
+	if !w.router {
+		return routerLink{}, slices.Contains(w.lsas[0].body.(*networkLSA).routers, v.id)
+	}
+	for _, l := range w.links() {
+		switch {
+		case v.router && l.typ == linkPointToPoint && l.id == v.id:
+			return l, true
+		case !v.router && l.typ == linkTransit && inst.is4() && l.id == v.id:
+			return l, true
+		case !v.router && l.typ == linkTransit && !inst.is4() && l.id == v.adv && l.nbrIf == v.id:
+			return l, true
+		}
+	}
+	return routerLink{}, false
+}
+
+// areaSPF is the result of the shortest path calculation for an area.
+type areaSPF struct {
+	routes  map[netip.Prefix]*rtEntry
+	routers map[uint32]*rtEntry // Reachable routers, for inter-area and external routes
+	asbrs   map[uint32]*rtEntry
+}
+
+// spf runs Dijkstra's algorithm over an area (RFC 2328 section 16.1).
+func (inst *instance) spf(a *area) *areaSPF {
+	res := &areaSPF{
+		routes:  make(map[netip.Prefix]*rtEntry),
+		routers: make(map[uint32]*rtEntry),
+		asbrs:   make(map[uint32]*rtEntry),
+	}
+	root := inst.routerVertex(a, inst.r.routerID)
+	if root == nil {
+		return res
+	}
+	tree := make(map[vertexKey]*vertex)
+	cands := make(map[vertexKey]*vertex)
+	for v := root; v != nil; v = nextCandidate(cands) {
+		delete(cands, v.key())
+		tree[v.key()] = v
+		type edge struct {
+			w    *vertex
+			cost uint32
+			link routerLink // v's link to w
+		}
+		var edges []edge
+		if v.router {
+			for _, l := range v.links() {
+				switch l.typ {
+				case linkPointToPoint:
+					if w := inst.routerVertex(a, l.id); w != nil {
+						edges = append(edges, edge{w, uint32(l.metric), l})
+					}
+				case linkTransit:
+					var w *vertex
+					if inst.is4() {
+						w = inst.networkVertex(a, l.id, 0)
+					} else {
+						w = inst.networkVertex(a, l.nbrIf, l.id)
+					}
+					if w != nil {
+						edges = append(edges, edge{w, uint32(l.metric), l})
+					}
+				}
+			}
+		} else {
+			for _, id := range v.lsas[0].body.(*networkLSA).routers {
+				if w := inst.routerVertex(a, id); w != nil {
+					edges = append(edges, edge{w, 0, routerLink{}})
+				}
+			}
+		}
+
+		for _, e := range edges {
+			w := e.w
+			if tree[w.key()] != nil {
+				continue
+			}
+			back, ok := inst.linkBack(w, v)
+			if !ok {
+				continue
+			}
+			w.dist, w.parent = v.dist+e.cost, v
+			w.hops = inst.nextHops(v, w, e.link, back)
+			if len(w.hops) == 0 {
+				continue
+			}
+			switch c := cands[w.key()]; {
+			case c == nil || w.dist < c.dist:
+				cands[w.key()] = w
+			case w.dist == c.dist:
+				c.hops = mergeHops(c.hops, w.hops)
+			}
+		}
+	}
+
+	// Routers, and the networks attached to the tree
+	for _, v := range tree {
+		if !v.router {
+			if inst.is4() {
+				nl := v.lsas[0].body.(*networkLSA)
+				prefix, err := prefixFromMask(v.id, nl.mask)
+				if err == nil {
+					addRoute(res.routes, prefix, &rtEntry{typ: pathIntra, area: a, cost: v.dist, hops: v.hops})
+				}
+			}
+			continue
+		}
+		if v != root {
+			e := &rtEntry{typ: pathIntra, area: a, cost: v.dist, hops: v.hops}
+			res.routers[v.id] = e
+			if v.flags()&flagE != 0 {
+				res.asbrs[v.id] = e
+			}
+		}
+		if inst.is4() {
+			for _, l := range v.links() {
+				if l.typ != linkStub {
+					continue
+				}
+				prefix, err := prefixFromMask(l.id, l.data)
+				if err != nil {
+					continue
+				}
+				addRoute(res.routes, prefix, &rtEntry{typ: pathIntra, area: a, cost: v.dist + uint32(l.metric), hops: inst.stubHops(v, root, prefix)})
+			}
+		}
+	}
+
+	// OSPFv3 prefixes hang off router and network vertices
+	if !inst.is4() {
+		typ := lsType(3, kindIntraPrefix)
+		for _, l := range a.db.sorted() {
+			if l.typ != typ || l.age == maxAge || l.ageAt(inst.r.now) == maxAge {
+				continue
+			}
+			ip := l.body.(*intraPrefixLSA)
+			key := vertexKey{router: true, id: ip.refAdv}
+			if ip.refType == lsType(3, kindNetwork) {
+				key = vertexKey{id: ip.refID, adv: ip.refAdv}
+			} else if ip.refType != lsType(3, kindRouter) {
+				continue
+			}
+			v := tree[key]
+			if v == nil || l.adv != ip.refAdv {
+				continue
+			}
+			for _, p := range ip.prefixes {
+				if p.options&prefixNU != 0 {
+					continue
+				}
+				addRoute(res.routes, p.prefix, &rtEntry{typ: pathIntra, area: a, cost: v.dist + uint32(p.metric), hops: inst.stubHops(v, root, p.prefix)})
+			}
+		}
+	}
+	return res
+}
+
+// stubHops returns the next hops to a network advertised by v, which is
+// directly connected when v is the root.
+func (inst *instance) stubHops(v, root *vertex, prefix netip.Prefix) []hop {
+	if v != root {
+		return v.hops
+	}
+	for _, i := range inst.ifaces {
+		for _, p := range i.prefixes {
+			if p.Masked() == prefix || p.Addr() == prefix.Addr() {
+				return []hop{{i: i}}
+			}
+		}
+	}
+	return []hop{{}}
+}
+
+// nextCandidate picks the closest candidate, preferring networks
+// (RFC 2328 section 16.1 step 3).
+func nextCandidate(cands map[vertexKey]*vertex) *vertex {
+	var best *vertex
+	for _, c := range cands {
+		switch {
+		case best == nil, c.dist < best.dist:
+			best = c
+		case c.dist > best.dist:
+		case !c.router && best.router:
+			best = c
+		case c.router == best.router && (c.id < best.id || c.id == best.id && c.adv < best.adv):
+			best = c
+		}
+	}
+	return best
+}
+
+// nextHops calculates the next hops to w through its parent v (RFC 2328
+// section 16.1.1). link is v's link to w; back is w's link to v.
+func (inst *instance) nextHops(v, w *vertex, link, back routerLink) []hop {
+	root := v.parent == nil && v.router && v.id == inst.r.routerID
+	var i *iface
+	if root {
+		i = inst.ifaceByLinkData(link.data)
+		if i == nil {
+			return nil
+		}
+		if !w.router {
+			// Directly attached network
+			return []hop{{i: i}}
+		}
+		n := i.nbrs[w.id]
+		if n == nil {
+			return nil
+		}
+		return []hop{{i: i, addr: n.addr}}
+	}
+	if !v.router && v.parent != nil && v.parent.parent == nil {
+		// Routers on a directly attached network: the next hop is the
+		// router's address on that network
+		var out []hop
+		for _, h := range v.hops {
+			if addr, ok := inst.neighborAddr(h.i, w.id, back); ok {
+				out = append(out, hop{i: h.i, addr: addr})
+			}
+		}
+		return out
+	}
+	return v.hops
+}
+
+// ifaceByLinkData finds the interface a router-LSA link of the router
+// describes: by address in OSPFv2, by Interface ID in OSPFv3.
+func (inst *instance) ifaceByLinkData(data uint32) *iface {
+	for _, i := range inst.ifaces {
+		if inst.is4() && i.addr.Is4() && u32(i.addr) == data || !inst.is4() && i.ifID() == data {
+			return i
+		}
+	}
+	return nil
+}
+
+// neighborAddr finds the address of router id on the network i attaches
+// to. OSPFv2 takes it from the router's transit link; OSPFv3 from the
+// router's link-LSA.
+func (inst *instance) neighborAddr(i *iface, id uint32, back routerLink) (netip.Addr, bool) {
+	if inst.is4() {
+		return addr4(back.data), true
+	}
+	l := i.db.lsas[lsaKey{typ: lsType(3, kindLink), id: back.data, adv: id}]
+	if l == nil || l.age == maxAge {
+		if n := i.nbrs[id]; n != nil {
+			return n.addr, true
+		}
+		return netip.Addr{}, false
+	}
+	return l.body.(*linkLSA).linkLocal, true
+}
+
+// runSPF recalculates the routing table and updates the kernel (RFC 2328
+// section 16).
+func (inst *instance) runSPF() {
+	rt := make(map[netip.Prefix]*rtEntry)
+	routers := make(map[*area]map[uint32]*rtEntry)
+	asbrs := make(map[uint32]*rtEntry)
+	active := inst.active()
+	for _, a := range active {
+		res := inst.spf(a)
+		for prefix, e := range res.routes {
+			addRoute(rt, prefix, e)
+		}
+		routers[a] = res.routers
+		for id, e := range res.asbrs {
+			if cur := asbrs[id]; cur == nil || e.cost < cur.cost {
+				asbrs[id] = e
+			}
+		}
+	}
+
+	// Inter-area routes: area border routers only look at the backbone
+	// (RFC 2328 section 16.2)
+	scan := active
+	if len(active) > 1 {
+		scan = nil
+		for _, a := range active {
+			if a.id == 0 {
+				scan = []*area{a}
+			}
+		}
+	}
+	inter := make(map[netip.Prefix]*rtEntry)
+	now := inst.r.now
+	for _, a := range scan {
+		for _, l := range a.db.sorted() {
+			k := kindOf(inst.version, l.typ)
+			if k != kindSummaryNet && k != kindSummaryASBR || l.adv == inst.r.routerID || l.ageAt(now) == maxAge {
+				continue
+			}
+			s := l.body.(*summaryLSA)
+			br := routers[a][l.adv]
+			if br == nil || s.metric >= lsInfinity {
+				continue
+			}
+			e := &rtEntry{typ: pathInter, area: a, cost: br.cost + s.metric, hops: br.hops}
+			if k == kindSummaryASBR {
+				if cur := asbrs[s.router]; cur == nil || cur.typ == pathInter && e.cost < cur.cost {
+					asbrs[s.router] = e
+				}
+				continue
+			}
+			if cur := rt[s.prefix]; cur != nil && cur.typ == pathIntra {
+				continue
+			}
+			addRoute(inter, s.prefix, e)
+		}
+	}
+	for prefix, e := range inter {
+		rt[prefix] = e
+	}
+
+	// AS-external routes (RFC 2328 section 16.4)
+	for _, l := range inst.asdb.sorted() {
+		if kindOf(inst.version, l.typ) != kindExternal || l.adv == inst.r.routerID || l.ageAt(now) == maxAge {
+			continue
+		}
+		x := l.body.(*externalLSA)
+		asbr := asbrs[l.adv]
+		if asbr == nil || x.metric >= lsInfinity {
+			continue
+		}
+		base := asbr
+		if x.forward.IsValid() && !x.forward.IsUnspecified() {
+			if base = lookupRoute(rt, x.forward); base == nil || base.typ > pathInter {
+				continue
+			}
+		}
+		e := &rtEntry{typ: pathExt1, cost: base.cost + x.metric, hops: base.hops}
+		if x.type2 {
+			e = &rtEntry{typ: pathExt2, cost: base.cost, cost2: x.metric, hops: base.hops}
+		}
+		if cur := rt[x.prefix]; cur != nil && cur.typ <= pathInter {
+			continue
+		}
+		addRoute(rt, x.prefix, e)
+	}
+
+	inst.rt = rt
+	inst.asbrs = asbrs
+	inst.installRoutes()
+}
+
+// lookupRoute finds the longest intra- or inter-area match for addr.
+func lookupRoute(rt map[netip.Prefix]*rtEntry, addr netip.Addr) *rtEntry {
+	var best *rtEntry
+	bits := -1
+	for prefix, e := range rt {
+		if prefix.Contains(addr) && prefix.Bits() > bits {
+			best, bits = e, prefix.Bits()
+		}
+	}
+	return best
+}
+
+// installRoutes programs the kernel with the routing table. Directly
+// connected networks are left to the kernel's own routes.
+func (inst *instance) installRoutes() {
+	r := inst.r
+	want := make(map[netip.Prefix][]nextHop)
+	for prefix, e := range inst.rt {
+		if e.direct() || len(e.hops) == 0 {
+			continue
+		}
+		var nhs []nextHop
+		for _, h := range e.hops {
+			nhs = append(nhs, nextHop{addr: h.addr, ifIndex: h.i.index})
+		}
+		slices.SortFunc(nhs, func(a, b nextHop) int {
+			if c := a.addr.Compare(b.addr); c != 0 {
+				return c
+			}
+			return a.ifIndex - b.ifIndex
+		})
+		want[prefix] = nhs
+	}
+
+	for prefix := range inst.installed {
+		if _, ok := want[prefix]; !ok {
+			delete(inst.installed, prefix)
+			if err := r.routes.Delete(prefix); err != nil {
+				r.logger.Warn("Failed to remove OSPF route", "prefix", prefix, "error", err)
+			}
+		}
+	}
+	for _, prefix := range sortedPrefixes(want) {
+		nhs := want[prefix]
+		sig := fmt.Sprint(nhs)
+		if inst.installed[prefix] == sig {
+			continue
+		}
+		if err := r.routes.Replace(prefix, nhs); err != nil {
+			r.logger.Warn("Failed to install OSPF route", "prefix", prefix, "error", err)
+			continue
+		}
+		inst.installed[prefix] = sig
+	}
+}
+
+func (inst *instance) routeStatus() []RouteStatus {
+	var out []RouteStatus
+	for _, prefix := range sortedPrefixes(inst.rt) {
+		e := inst.rt[prefix]
+		st := RouteStatus{Prefix: prefix.String(), Type: pathTypeNames[e.typ], Cost: int(e.cost)}
+		if e.typ == pathExt2 {
+			st.Cost = int(e.cost2)
+		}
+		if e.area != nil {
+			st.Area = idString(e.area.id)
+		}
+		for _, h := range e.hops {
+			var nh NextHop
+			if h.i != nil {
+				nh.Interface = h.i.name
+			}
+			if h.addr.IsValid() {
+				nh.Address = h.addr.String()
+			}
+			st.NextHops = append(st.NextHops, nh)
+		}
+		slices.SortFunc(st.NextHops, func(a, b NextHop) int {
+			return strings.Compare(a.Interface+a.Address, b.Interface+b.Address)
+		})
+		out = append(out, st)
+	}
+	return out
+}
+
+func sortedPrefixes[V any](m map[netip.Prefix]V) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(m))
+	for p := range m {
+		out = append(out, p)
+	}
+	slices.SortFunc(out, comparePrefixes)
+	return out
+}
+
+func sortedIDs[V any](m map[uint32]V) []uint32 {
+	out := make([]uint32, 0, len(m))
+	for id := range m {
+		out = append(out, id)
+	}
+	slices.Sort(out)
+	return out
+}
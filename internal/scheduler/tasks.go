@@ -22,6 +22,7 @@ type TaskRegistry struct {
 	ApplyConfig   func(*config.Config) error
 	RefreshIPSets func() error
 	RefreshDNS    func() error
+	RefreshGeoIP  func() error
 }
 
 // NewIPSetUpdateTask creates a task to update IPSets from external sources.
@@ -62,6 +63,26 @@ func NewDNSBlocklistUpdateTask(registry *TaskRegistry, interval time.Duration) *
 	}
 }
 
+// NewGeoIPUpdateTask creates a task to reload the GeoIP database when it is
+// updated on disk and refresh the firewall's country sets.
+func NewGeoIPUpdateTask(registry *TaskRegistry, interval time.Duration) *Task {
+	return &Task{
+		ID:          "geoip-update",
+		Name:        "GeoIP Update",
+		Description: "Reload the GeoIP database and refresh country sets",
+		Schedule:    Every(interval),
+		Enabled:     true,
+		RunOnStart:  false,
+		Timeout:     5 * time.Minute,
+		Func: func(ctx context.Context) error {
+			if registry.RefreshGeoIP == nil {
+				return fmt.Errorf("GeoIP refresh function not configured")
+			}
+			return registry.RefreshGeoIP()
+		},
+	}
+}
+
 // NewConfigBackupTask creates a task to backup the configuration.
 func NewConfigBackupTask(registry *TaskRegistry, schedule Schedule, keepCount int) *Task {
 	if keepCount <= 0 {
@@ -148,6 +148,7 @@ func TestTaskConstructors(t *testing.T) {
 	reg := &TaskRegistry{
 		RefreshIPSets: func() error { return nil },
 		RefreshDNS:    func() error { return nil },
+		RefreshGeoIP:  func() error { return nil },
 	}
 
 	// Just verify they return non-nil and have correct metadata
@@ -198,4 +199,12 @@ func TestTaskConstructors(t *testing.T) {
 	if err := t6.Func(context.Background()); err != nil {
 		t.Error(err)
 	}
+
+	t7 := NewGeoIPUpdateTask(reg, time.Hour)
+	if t7.ID != "geoip-update" {
+		t.Error("Wrong ID for GeoIP task")
+	}
+	if err := t7.Func(context.Background()); err != nil {
+		t.Error(err)
+	}
 }
@@ -1,6 +1,8 @@
 package ddns
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -36,12 +38,10 @@ func NewProvider(cfg Config) (Provider, error) {
 	case "duckdns":
 		return &DuckDNS{token: cfg.Token, hostname: cfg.Hostname}, nil
 	case "cloudflare":
-		return &Cloudflare{
-			token:    cfg.Token,
-			zoneID:   cfg.ZoneID,
-			recordID: cfg.RecordID,
-			hostname: cfg.Hostname,
-		}, nil
+		cf := NewCloudflare(cfg.Token, cfg.ZoneID)
+		cf.recordID = cfg.RecordID
+		cf.hostname = cfg.Hostname
+		return cf, nil
 	case "noip", "no-ip":
 		return &NoIP{
 			username: cfg.Username,
@@ -82,42 +82,112 @@ func (d *DuckDNS) Update(hostname, ip string) error {
 
 // --- Cloudflare Provider ---
 
+// cloudflareAPI is the base URL of the Cloudflare v4 API.
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
 type Cloudflare struct {
 	token    string
 	zoneID   string
 	recordID string
 	hostname string
+	apiURL   string
+}
+
+// NewCloudflare creates a Cloudflare client for the given zone. Besides DDNS
+// updates it can manage TXT records for ACME dns-01 challenges.
+func NewCloudflare(token, zoneID string) *Cloudflare {
+	return &Cloudflare{token: token, zoneID: zoneID, apiURL: cloudflareAPI}
 }
 
 func (c *Cloudflare) Name() string { return "Cloudflare" }
 
 func (c *Cloudflare) Update(hostname, ip string) error {
 	// Cloudflare API: PATCH /zones/{zone_id}/dns_records/{record_id}
-	u := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s",
-		c.zoneID, c.recordID)
-
 	payload := map[string]interface{}{
 		"type":    "A",
 		"name":    hostname,
 		"content": ip,
 		"ttl":     300,
 	}
-	body, _ := json.Marshal(payload)
+	if err := c.do(context.Background(), "PATCH", "/dns_records/"+c.recordID, payload, nil); err != nil {
+		return fmt.Errorf("cloudflare update failed: %w", err)
+	}
+	return nil
+}
+
+// SetTXT creates a TXT record with the given fully qualified name.
+func (c *Cloudflare) SetTXT(ctx context.Context, name, value string) error {
+	payload := map[string]interface{}{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(name, "."),
+		"content": value,
+		"ttl":     120,
+	}
+	if err := c.do(ctx, "POST", "/dns_records", payload, nil); err != nil {
+		return fmt.Errorf("cloudflare TXT create failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteTXT removes the TXT records with the given name and value.
+func (c *Cloudflare) DeleteTXT(ctx context.Context, name, value string) error {
+	query := url.Values{
+		"type":    {"TXT"},
+		"name":    {strings.TrimSuffix(name, ".")},
+		"content": {value},
+	}
+	var list struct {
+		Result []struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}
+	if err := c.do(ctx, "GET", "/dns_records?"+query.Encode(), nil, &list); err != nil {
+		return fmt.Errorf("cloudflare TXT lookup failed: %w", err)
+	}
+	for _, record := range list.Result {
+		if err := c.do(ctx, "DELETE", "/dns_records/"+record.ID, nil, nil); err != nil {
+			return fmt.Errorf("cloudflare TXT delete failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// do sends a request for a path below the zone and decodes the response
+// into out, if given.
+func (c *Cloudflare) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
 
-	req, _ := http.NewRequest("PATCH", u, strings.NewReader(string(body)))
+	apiURL := c.apiURL
+	if apiURL == "" {
+		apiURL = cloudflareAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL+"/zones/"+c.zoneID+path, body)
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Authorization", "Bearer "+c.token)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("cloudflare update failed: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("cloudflare update failed: %s", string(respBody))
+		return fmt.Errorf("%s", string(respBody))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
 	}
 	return nil
 }
@@ -1,6 +1,10 @@
 package ddns
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -120,3 +124,76 @@ func TestConfig_Struct(t *testing.T) {
 
 // Note: Actual Update() methods require network access and real credentials
 // Integration tests should be done manually or with mocked HTTP clients
+
+func TestCloudflare_TXTRecords(t *testing.T) {
+	records := map[string]map[string]string{}
+	nextID := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cf-token" {
+			http.Error(w, `{"success":false}`, http.StatusForbidden)
+			return
+		}
+		const base = "/zones/zone123/dns_records"
+		switch {
+		case r.Method == "POST" && r.URL.Path == base:
+			var rec map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&rec)
+			nextID++
+			id := string(rune('a' + nextID))
+			records[id] = map[string]string{"id": id, "type": rec["type"].(string), "name": rec["name"].(string), "content": rec["content"].(string)}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": records[id]})
+		case r.Method == "GET" && r.URL.Path == base:
+			q := r.URL.Query()
+			var result []map[string]string
+			for _, rec := range records {
+				if rec["type"] == q.Get("type") && rec["name"] == q.Get("name") && rec["content"] == q.Get("content") {
+					result = append(result, rec)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+		case r.Method == "DELETE":
+			delete(records, r.URL.Path[len(base)+1:])
+			w.Write([]byte(`{"success":true}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cf := NewCloudflare("cf-token", "zone123")
+	cf.apiURL = srv.URL
+	ctx := context.Background()
+
+	if err := cf.SetTXT(ctx, "_acme-challenge.example.com.", "token-a"); err != nil {
+		t.Fatalf("SetTXT failed: %v", err)
+	}
+	if err := cf.SetTXT(ctx, "_acme-challenge.example.com.", "token-b"); err != nil {
+		t.Fatalf("SetTXT failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	for _, rec := range records {
+		if rec["name"] != "_acme-challenge.example.com" || rec["type"] != "TXT" {
+			t.Errorf("Unexpected record %v", rec)
+		}
+	}
+
+	// Only the matching value is removed
+	if err := cf.DeleteTXT(ctx, "_acme-challenge.example.com.", "token-a"); err != nil {
+		t.Fatalf("DeleteTXT failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record after delete, got %d", len(records))
+	}
+	for _, rec := range records {
+		if rec["content"] != "token-b" {
+			t.Errorf("Wrong record deleted, %v remains", rec)
+		}
+	}
+
+	cf.token = "wrong"
+	if err := cf.SetTXT(ctx, "_acme-challenge.example.com", "x"); err == nil {
+		t.Error("Expected error for rejected token")
+	}
+}
@@ -0,0 +1,102 @@
+package dhcp
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// clientOptions returns the configured options for the client that sent m:
+// scope options, then those of matching classes in order, then per-host
+// options, so that the most specific setting wins.
+func clientOptions(m *dhcpv4.DHCPv4, scope config.DHCPScope, res config.DHCPReservation, hasRes bool) []dhcpv4.Modifier {
+	var opts []dhcpv4.Modifier
+	add := func(options map[string]string, what string) {
+		for k, v := range options {
+			opt, err := parseOption(k, v)
+			if err != nil {
+				log.Printf("[DHCP] Warning: failed to parse %s option %s=%s: %v", what, k, v, err)
+				continue
+			}
+			opts = append(opts, dhcpv4.WithOption(opt))
+		}
+	}
+
+	add(scope.Options, "scope")
+	for _, class := range scope.Classes {
+		if classMatches(class, m) {
+			add(class.Options, "class "+class.Name)
+		}
+	}
+	if hasRes {
+		add(res.Options, "host")
+	}
+	return append(opts, withBootHeader())
+}
+
+// classMatches reports whether the client that sent m meets every
+// condition set on class.
+func classMatches(class config.DHCPClass, m *dhcpv4.DHCPv4) bool {
+	if class.VendorClass != "" && !strings.HasPrefix(m.ClassIdentifier(), class.VendorClass) {
+		return false
+	}
+	if class.UserClass != "" && !hasUserClass(m, class.UserClass) {
+		return false
+	}
+	if class.MACPrefix != "" {
+		prefix := strings.ToLower(strings.ReplaceAll(class.MACPrefix, "-", ":"))
+		if !strings.HasPrefix(m.ClientHWAddr.String(), prefix) {
+			return false
+		}
+	}
+	if len(class.Architectures) > 0 && !hasArch(m.ClientArch(), class.Architectures) {
+		return false
+	}
+	return true
+}
+
+// hasUserClass reports whether m carries user class uc. iPXE sends option
+// 77 as a bare string rather than the RFC 3004 list; both are accepted.
+func hasUserClass(m *dhcpv4.DHCPv4, uc string) bool {
+	for _, c := range m.UserClass() {
+		if c == uc {
+			return true
+		}
+	}
+	return false
+}
+
+func hasArch(archs []iana.Arch, want []int) bool {
+	for _, a := range archs {
+		for _, w := range want {
+			if int(a) == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withBootHeader copies the boot file name (option 67) and an IPv4 TFTP
+// server (option 66 or 150) into the BOOTP file and siaddr fields, which
+// many PXE ROMs read instead of the options.
+func withBootHeader() dhcpv4.Modifier {
+	return func(d *dhcpv4.DHCPv4) {
+		if file := d.Options.Get(dhcpv4.OptionBootfileName); len(file) > 0 {
+			d.BootFileName = string(file)
+		}
+		if server := d.Options.Get(dhcpv4.OptionTFTPServerName); len(server) > 0 {
+			if ip := net.ParseIP(string(server)).To4(); ip != nil {
+				d.ServerIPAddr = ip
+			}
+		}
+		if server := d.Options.Get(dhcpv4.GenericOptionCode(150)); len(server) >= net.IPv4len {
+			d.ServerIPAddr = net.IP(server[:net.IPv4len])
+		}
+	}
+}
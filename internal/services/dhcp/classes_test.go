@@ -0,0 +1,116 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func netbootScope() config.DHCPScope {
+	return config.DHCPScope{
+		Name:    "lan",
+		Options: map[string]string{"tftp_server": "10.0.0.1", "domain_name": "lan"},
+		Classes: []config.DHCPClass{
+			{Name: "bios", VendorClass: "PXEClient", Architectures: []int{0},
+				Options: map[string]string{"bootfile_name": "undionly.kpxe"}},
+			{Name: "uefi", VendorClass: "PXEClient", Architectures: []int{7, 9},
+				Options: map[string]string{"bootfile_name": "ipxe.efi"}},
+			{Name: "ipxe", UserClass: "iPXE",
+				Options: map[string]string{"bootfile_name": "http://10.0.0.1/boot.ipxe"}},
+			{Name: "pi", MACPrefix: "B8-27-EB",
+				Options: map[string]string{"domain_name": "pi.lan"}},
+		},
+	}
+}
+
+// offerFor builds the reply clientOptions produces for a discover with mods.
+func offerFor(t *testing.T, mac net.HardwareAddr, scope config.DHCPScope, res config.DHCPReservation, hasRes bool, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	discover, err := dhcpv4.NewDiscovery(mac, mods...)
+	if err != nil {
+		t.Fatalf("NewDiscovery failed: %v", err)
+	}
+	offer, err := dhcpv4.NewReplyFromRequest(discover, clientOptions(discover, scope, res, hasRes)...)
+	if err != nil {
+		t.Fatalf("NewReplyFromRequest failed: %v", err)
+	}
+	return offer
+}
+
+func TestClientOptions_Netboot(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	scope := netbootScope()
+
+	tests := []struct {
+		name string
+		mods []dhcpv4.Modifier
+		want string
+	}{
+		{"no netboot", nil, ""},
+		{"bios", []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC)),
+		}, "undionly.kpxe"},
+		{"uefi", []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		}, "ipxe.efi"},
+		{"uefi arch without vendor class", []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		}, ""},
+		{"chainloaded ipxe", []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE")),
+		}, "http://10.0.0.1/boot.ipxe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offer := offerFor(t, mac, scope, config.DHCPReservation{}, false, tt.mods...)
+			if got := offer.BootFileNameOption(); got != tt.want {
+				t.Errorf("option 67 = %q, want %q", got, tt.want)
+			}
+			if offer.BootFileName != tt.want {
+				t.Errorf("file = %q, want %q", offer.BootFileName, tt.want)
+			}
+			if !offer.ServerIPAddr.Equal(net.ParseIP("10.0.0.1")) {
+				t.Errorf("siaddr = %s, want the TFTP server", offer.ServerIPAddr)
+			}
+		})
+	}
+}
+
+func TestClientOptions_Precedence(t *testing.T) {
+	pi := net.HardwareAddr{0xb8, 0x27, 0xeb, 0x01, 0x02, 0x03}
+	scope := netbootScope()
+
+	if got := offerFor(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, scope, config.DHCPReservation{}, false).DomainName(); got != "lan" {
+		t.Errorf("scope domain = %q", got)
+	}
+	if got := offerFor(t, pi, scope, config.DHCPReservation{}, false).DomainName(); got != "pi.lan" {
+		t.Errorf("class domain = %q, want the class to override the scope", got)
+	}
+	res := config.DHCPReservation{MAC: pi.String(), IP: "10.0.0.50", Options: map[string]string{"domain_name": "kitchen.lan"}}
+	if got := offerFor(t, pi, scope, res, true).DomainName(); got != "kitchen.lan" {
+		t.Errorf("host domain = %q, want the reservation to override the class", got)
+	}
+}
+
+func TestWithBootHeader_Option150(t *testing.T) {
+	scope := config.DHCPScope{Options: map[string]string{"150": "ip:10.0.0.9", "bootfile_name": "pxelinux.0"}}
+	offer := offerFor(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, scope, config.DHCPReservation{}, false)
+	if !offer.ServerIPAddr.Equal(net.ParseIP("10.0.0.9")) || offer.BootFileName != "pxelinux.0" {
+		t.Errorf("siaddr = %s, file = %q", offer.ServerIPAddr, offer.BootFileName)
+	}
+
+	// A TFTP server given by name stays in option 66 only
+	scope.Options = map[string]string{"tftp_server": "boot.lan"}
+	offer = offerFor(t, net.HardwareAddr{0, 1, 2, 3, 4, 5}, scope, config.DHCPReservation{}, false)
+	if !offer.ServerIPAddr.IsUnspecified() || offer.TFTPServerName() != "boot.lan" {
+		t.Errorf("siaddr = %s, option 66 = %q", offer.ServerIPAddr, offer.TFTPServerName())
+	}
+}
@@ -0,0 +1,221 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/mdlayher/packet"
+)
+
+const (
+	arpProbeTimeout       = 500 * time.Millisecond
+	defaultQuarantineTime = 24 * time.Hour
+	etherTypeARP          = 0x0806
+)
+
+// NotificationDispatcher abstracts the notification system
+type NotificationDispatcher interface {
+	SendSimple(title, message, level string)
+}
+
+// prober looks for a host other than client using ip on iface and returns
+// its hardware address, or nil.
+type prober func(iface string, ip net.IP, client net.HardwareAddr) net.HardwareAddr
+
+// prober returns the probe to run before leasing an address, and whether
+// the conflicts it finds are reported. Failover probes even without
+// conflict detection, as replication can lag the partner's allocations.
+// Called while holding the store lock.
+func (s *LeaseStore) prober() (prober, bool) {
+	if s.probe != nil {
+		return s.probe, true
+	}
+	if s.failover != nil && s.failover.probe != nil {
+		return s.failover.probe, false
+	}
+	return nil, false
+}
+
+// conflict withholds ip after claimant answered a probe for it. Conflicts
+// found by conflict detection are quarantined and reported; those found
+// only by the failover check are likely the partner's leases and are
+// withheld for a lease time.
+// Called while holding the store lock.
+func (s *LeaseStore) conflict(ip net.IP, claimant net.HardwareAddr, report bool) {
+	if !report {
+		log.Printf("[DHCP] Conflict: %s answered an ARP probe, skipping it", ip)
+		s.quarantine(ip, s.getLeaseTime())
+		return
+	}
+	log.Printf("[DHCP] Conflict: %s is in use by %s, quarantining it", ip, claimant)
+	s.quarantine(ip, s.quarantineTime)
+	if s.onConflict != nil {
+		s.onConflict(fmt.Sprintf("device %s is using %s inside the dynamic range without a lease; the address is quarantined", claimant, ip))
+	}
+}
+
+// quarantine withholds ip from allocation for d, or the default
+// quarantine time when d is zero.
+// Called while holding the store lock.
+func (s *LeaseStore) quarantine(ip net.IP, d time.Duration) {
+	if s.quarantined == nil {
+		s.quarantined = make(map[string]time.Time)
+	}
+	if d <= 0 {
+		d = defaultQuarantineTime
+	}
+	s.quarantined[ip.String()] = s.getNow().Add(d)
+}
+
+// Decline handles a DHCPDECLINE: the client found ip already in use, so
+// its lease is dropped and the address quarantined. Returns false when mac
+// was not offered ip.
+func (s *LeaseStore) Decline(mac string, ip net.IP) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	// A reservation cannot move; the operator has to resolve the conflict
+	if res, ok := s.Reservations[mac]; ok && net.ParseIP(res.IP).Equal(ip) {
+		log.Printf("[DHCP] Client %s declined its reserved address %s", mac, ip)
+		if s.onConflict != nil {
+			s.onConflict(fmt.Sprintf("client %s declined its reserved address %s because another device is using it", mac, ip))
+		}
+		return true
+	}
+
+	leased, ok := s.Leases[mac]
+	if !ok || !leased.Equal(ip) {
+		return false
+	}
+	delete(s.Leases, mac)
+	delete(s.TakenIPs, ip.String())
+	delete(s.leaseExpiry, mac)
+	delete(s.hostnames, mac)
+	if s.bucket != nil {
+		if err := s.bucket.Delete(mac); err != nil {
+			log.Printf("[DHCP] Failed to delete declined lease for %s: %v", mac, err)
+		}
+	}
+	s.quarantine(ip, s.quarantineTime)
+	log.Printf("[DHCP] Client %s declined %s, quarantining it", mac, ip)
+	if s.onConflict != nil {
+		s.onConflict(fmt.Sprintf("client %s declined %s because another device is using it; the address is quarantined", mac, ip))
+	}
+	return true
+}
+
+// Quarantined returns the addresses withheld after conflicts, with the
+// time each is released.
+func (s *LeaseStore) Quarantined() map[string]time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.getNow()
+	ret := make(map[string]time.Time, len(s.quarantined))
+	for ip, until := range s.quarantined {
+		if now.Before(until) {
+			ret[ip] = until
+		}
+	}
+	return ret
+}
+
+// configureConflictDetection enables ARP probing and quarantine on the
+// lease store of a scope.
+func (s *Service) configureConflictDetection(ls *LeaseStore, cfg *config.DHCPServer, scope string) {
+	if cfg.ConflictDetection == nil || *cfg.ConflictDetection {
+		ls.probe = arpProbe
+	}
+	if d, err := time.ParseDuration(cfg.QuarantineTime); err == nil && d > 0 {
+		ls.quarantineTime = d
+	}
+	// Reported asynchronously: the store lock is held
+	ls.onConflict = func(message string) { go s.notifyConflict(scope, message) }
+}
+
+// notifyConflict reports an address conflict in a scope to the operator.
+func (s *Service) notifyConflict(scope, message string) {
+	s.mu.RLock()
+	d := s.dispatcher
+	s.mu.RUnlock()
+	if d != nil {
+		d.SendSimple("DHCP Address Conflict", fmt.Sprintf("Scope %s: %s", scope, message), "warning")
+	}
+}
+
+// arpProbe sends an RFC 5227 ARP probe for ip on iface and returns the
+// hardware address of the host claiming it, or nil. It returns as soon as
+// a claim arrives. ARP is used rather than ICMP echo because on-link hosts
+// must answer it even when they firewall pings. The client being offered
+// ip may already use it and is ignored. Failures count as no answer, so a
+// broken link never blocks allocation.
+func arpProbe(iface string, ip net.IP, client net.HardwareAddr) net.HardwareAddr {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil
+	}
+	conn, err := packet.Listen(ifi, packet.Raw, etherTypeARP, nil)
+	if err != nil {
+		log.Printf("[DHCP] ARP probe on %s failed: %v", iface, err)
+		return nil
+	}
+	defer conn.Close()
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, err := conn.WriteTo(arpProbeFrame(ifi.HardwareAddr, ip), &packet.Addr{HardwareAddr: broadcast}); err != nil {
+		log.Printf("[DHCP] ARP probe on %s failed: %v", iface, err)
+		return nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(arpProbeTimeout))
+	buf := make([]byte, 128)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		if mac := arpClaimant(buf[:n], ifi.HardwareAddr, client, ip); mac != nil {
+			return mac
+		}
+	}
+}
+
+// arpProbeFrame builds a broadcast ARP probe for ip: a request with an
+// all-zero sender address, so no neighbour cache is updated.
+func arpProbeFrame(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 14+28)
+	copy(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], etherTypeARP)
+
+	arp := b[14:]
+	binary.BigEndian.PutUint16(arp[0:2], 1)      // Ethernet
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800) // IPv4
+	arp[4], arp[5] = 6, 4
+	binary.BigEndian.PutUint16(arp[6:8], 1) // Request
+	copy(arp[8:14], mac)
+	// Sender protocol and target hardware addresses stay zero
+	copy(arp[24:28], ip.To4())
+	return b
+}
+
+// arpClaimant returns the sender of frame if it is an ARP packet using ip
+// as its address, from a host other than us and the client: a reply to
+// our probe, or a host announcing it.
+func arpClaimant(frame []byte, self, client net.HardwareAddr, ip net.IP) net.HardwareAddr {
+	if len(frame) < 14+28 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return nil
+	}
+	arp := frame[14:]
+	sender := net.HardwareAddr(bytes.Clone(arp[8:14]))
+	if bytes.Equal(sender, self) || bytes.Equal(sender, client) || !net.IP(arp[14:18]).Equal(ip.To4()) {
+		return nil
+	}
+	return sender
+}
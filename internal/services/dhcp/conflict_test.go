@@ -0,0 +1,233 @@
+package dhcp
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+)
+
+type testDispatcher struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (d *testDispatcher) SendSimple(title, message, level string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages = append(d.messages, title+": "+message)
+}
+
+func (d *testDispatcher) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.messages)
+}
+
+func newConflictStore(clk *clock.MockClock) *LeaseStore {
+	return &LeaseStore{
+		Leases:       make(map[string]net.IP),
+		TakenIPs:     make(map[string]string),
+		Reservations: make(map[string]config.DHCPReservation),
+		ReservedIPs:  make(map[string]string),
+		RangeStart:   net.ParseIP("10.0.0.10").To4(),
+		RangeEnd:     net.ParseIP("10.0.0.19").To4(),
+		Interface:    "eth1",
+		clock:        clk,
+	}
+}
+
+func TestLeaseStore_ConflictDetection(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	ls := newConflictStore(clk)
+	squatter := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x99}
+
+	var probed []string
+	ls.probe = func(iface string, ip net.IP, client net.HardwareAddr) net.HardwareAddr {
+		probed = append(probed, iface+" "+ip.String())
+		if ip.Equal(net.ParseIP("10.0.0.10")) {
+			return squatter
+		}
+		return nil
+	}
+	var conflicts []string
+	ls.onConflict = func(message string) { conflicts = append(conflicts, message) }
+
+	ip, err := ls.Allocate("00:11:22:33:44:55")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.11")) {
+		t.Errorf("got %s, want the address after the conflict", ip)
+	}
+	if len(probed) != 2 || probed[0] != "eth1 10.0.0.10" {
+		t.Errorf("probes = %v", probed)
+	}
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0], squatter.String()) {
+		t.Errorf("conflicts = %v, want the squatter reported", conflicts)
+	}
+
+	// The quarantined address is not probed again until it is released
+	probed = nil
+	ls.Allocate("00:11:22:33:44:56")
+	if len(probed) != 1 || probed[0] != "eth1 10.0.0.12" {
+		t.Errorf("probes = %v", probed)
+	}
+	if q := ls.Quarantined(); len(q) != 1 || q["10.0.0.10"].IsZero() {
+		t.Errorf("quarantined = %v", q)
+	}
+	clk.Advance(defaultQuarantineTime)
+	if ls.isTaken(net.ParseIP("10.0.0.10")) {
+		t.Error("quarantine did not end")
+	}
+}
+
+func TestLeaseStore_ProbeWithoutLock(t *testing.T) {
+	ls := newConflictStore(clock.NewMockClock(time.Now()))
+	mac := "00:11:22:33:44:55"
+
+	ls.probe = func(iface string, ip net.IP, client net.HardwareAddr) net.HardwareAddr {
+		if client.String() != mac {
+			return nil
+		}
+		// The store stays usable while a probe waits for replies
+		done := make(chan net.IP)
+		go func() {
+			ip, _ := ls.Allocate("00:11:22:33:44:66")
+			done <- ip
+		}()
+		select {
+		case other := <-done:
+			if other.Equal(ip) {
+				t.Errorf("address %s under probe allocated to another client", ip)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("store locked during probe")
+		}
+		return nil
+	}
+
+	ip, err := ls.Allocate(mac)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if ls.Leases[mac] == nil || !ls.Leases[mac].Equal(ip) {
+		t.Errorf("lease for %s = %v, want %s", mac, ls.Leases[mac], ip)
+	}
+}
+
+func TestLeaseStore_Decline(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	ls := newConflictStore(clk)
+	ls.quarantineTime = time.Hour
+	var conflicts []string
+	ls.onConflict = func(message string) { conflicts = append(conflicts, message) }
+
+	mac := "00:11:22:33:44:55"
+	ip, _ := ls.Allocate(mac)
+
+	// Only the client that was offered an address can decline it
+	if ls.Decline("00:11:22:33:44:66", ip) {
+		t.Error("decline from another client accepted")
+	}
+	if !ls.Decline(mac, ip) {
+		t.Fatal("decline rejected")
+	}
+	if _, ok := ls.Leases[mac]; ok {
+		t.Error("declined lease kept")
+	}
+	if len(conflicts) != 1 {
+		t.Errorf("conflicts = %v", conflicts)
+	}
+
+	// The client is offered another address while the first is quarantined
+	next, err := ls.Allocate(mac)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if next.Equal(ip) {
+		t.Errorf("declined address %s offered again", ip)
+	}
+	clk.Advance(time.Hour)
+	if ls.isTaken(ip) {
+		t.Error("quarantine did not end after quarantine_time")
+	}
+
+	// Reserved addresses cannot move, but the operator hears about it
+	ls.Reservations["00:11:22:33:44:77"] = config.DHCPReservation{MAC: "00:11:22:33:44:77", IP: "10.0.0.50"}
+	if !ls.Decline("00:11:22:33:44:77", net.ParseIP("10.0.0.50")) || len(conflicts) != 2 {
+		t.Errorf("reserved decline not reported: %v", conflicts)
+	}
+}
+
+func TestService_NotifyConflict(t *testing.T) {
+	d := &testDispatcher{}
+	s := NewService(nil, nil)
+	s.SetDispatcher(d)
+
+	ls := newConflictStore(clock.NewMockClock(time.Now()))
+	disabled := false
+	s.configureConflictDetection(ls, &config.DHCPServer{ConflictDetection: &disabled, QuarantineTime: "2h"}, "lan")
+	if ls.probe != nil {
+		t.Error("probing enabled despite conflict_detection = false")
+	}
+	if ls.quarantineTime != 2*time.Hour {
+		t.Errorf("quarantine time = %v", ls.quarantineTime)
+	}
+
+	mac := "00:11:22:33:44:55"
+	ip, _ := ls.Allocate(mac)
+	ls.Decline(mac, ip)
+
+	deadline := time.Now().Add(time.Second)
+	for d.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.messages) != 1 || !strings.Contains(d.messages[0], "Scope lan") {
+		t.Errorf("notifications = %v", d.messages)
+	}
+}
+
+func TestARPProbeFrame(t *testing.T) {
+	self := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ip := net.ParseIP("10.0.0.10")
+
+	frame := arpProbeFrame(self, ip)
+	if len(frame) != 42 {
+		t.Fatalf("frame length = %d", len(frame))
+	}
+	if !net.IP(frame[28:32]).Equal(net.IPv4zero) {
+		t.Errorf("sender address = %s, want 0.0.0.0", net.IP(frame[28:32]))
+	}
+	if !net.IP(frame[38:42]).Equal(ip) {
+		t.Errorf("target address = %s", net.IP(frame[38:42]))
+	}
+
+	// Our own probe is not a claim; a reply from another host is
+	client := net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+	if arpClaimant(frame, self, client, ip) != nil {
+		t.Error("own probe counted as a conflict")
+	}
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	reply := arpProbeFrame(other, net.ParseIP("10.0.0.1"))
+	copy(reply[28:32], ip.To4())
+	if got := arpClaimant(reply, self, client, ip); got.String() != other.String() {
+		t.Errorf("claimant = %s, want %s", got, other)
+	}
+	if arpClaimant(reply, self, client, net.ParseIP("10.0.0.11")) != nil {
+		t.Error("claim for another address counted as a conflict")
+	}
+
+	// Neither is the client answering for the address it is offered
+	own := arpProbeFrame(client, net.ParseIP("10.0.0.1"))
+	copy(own[28:32], ip.To4())
+	if arpClaimant(own, self, client, ip) != nil {
+		t.Error("client's own claim counted as a conflict")
+	}
+}
@@ -0,0 +1,722 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/state"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"github.com/insomniacslk/dhcp/iana"
+	"golang.org/x/net/ipv6"
+)
+
+type dhcpv6Instance struct {
+	conn    net.PacketConn
+	handler func(conn net.PacketConn, peer net.Addr, m dhcpv6.DHCPv6)
+}
+
+// LeaseStoreV6 tracks stateful DHCPv6 (IA_NA) bindings for a scope.
+// Clients are identified by DUID, stored as lowercase hex.
+type LeaseStoreV6 struct {
+	sync.Mutex
+	Leases       map[string]net.IP                 // DUID -> IP
+	TakenIPs     map[string]string                 // IP (string) -> DUID
+	Reservations map[string]config.DHCPReservation // DUID or MAC -> Reservation
+	ReservedIPs  map[string]string                 // IP (string) -> DUID or MAC
+	RangeStart   net.IP                            // nil for stateless scopes
+	RangeEnd     net.IP
+	Interface    string
+	bucket       *state.DHCPBucket // Persistent storage
+
+	next net.IP // Where the search for a free address resumes
+
+	clock       clock.Clock          // Injectable clock for testing
+	leaseTime   time.Duration        // Valid lifetime of assigned addresses
+	macs        map[string]string    // DUID -> MAC, when the DUID contains one
+	hostnames   map[string]string    // DUID -> FQDN registered in DNS
+	leaseExpiry map[string]time.Time // DUID -> expiration time
+}
+
+// normalizeDUID converts a configured DUID ("00:01:00:01:...") to the
+// hex form used as the store key.
+func normalizeDUID(duid string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(duid))
+}
+
+// newLeaseStoreV6 creates the v6 lease store for a scope.
+func newLeaseStoreV6(scope config.DHCPScope) (*LeaseStoreV6, error) {
+	ls := &LeaseStoreV6{
+		Leases:       make(map[string]net.IP),
+		TakenIPs:     make(map[string]string),
+		Reservations: make(map[string]config.DHCPReservation),
+		ReservedIPs:  make(map[string]string),
+		Interface:    scope.Interface,
+		macs:         make(map[string]string),
+		hostnames:    make(map[string]string),
+		leaseExpiry:  make(map[string]time.Time),
+	}
+
+	if scope.RangeStartV6 != "" {
+		ls.RangeStart = net.ParseIP(scope.RangeStartV6)
+		ls.RangeEnd = net.ParseIP(scope.RangeEndV6)
+		if ls.RangeStart == nil || ls.RangeStart.To4() != nil || ls.RangeEnd == nil || ls.RangeEnd.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 range %s-%s", scope.RangeStartV6, scope.RangeEndV6)
+		}
+	}
+	if scope.LeaseTime != "" {
+		if d, err := time.ParseDuration(scope.LeaseTime); err == nil && d > 0 {
+			ls.leaseTime = d
+		}
+	}
+
+	for _, res := range scope.Reservations {
+		ip := net.ParseIP(res.IPv6)
+		if ip == nil || ip.To4() != nil {
+			continue
+		}
+		key := normalizeDUID(res.DUID)
+		if key == "" {
+			hw, err := net.ParseMAC(res.MAC)
+			if err != nil {
+				continue
+			}
+			key = hw.String()
+		}
+		ls.Reservations[key] = res
+		ls.ReservedIPs[ip.String()] = key
+	}
+
+	return ls, nil
+}
+
+// load restores this interface's bindings from the state store.
+func (s *LeaseStoreV6) load() {
+	if s.bucket == nil {
+		return
+	}
+	leases, err := s.bucket.List()
+	if err != nil {
+		log.Printf("[DHCPv6] Warning: Failed to list existing leases: %v", err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	loaded := 0
+	for _, l := range leases {
+		ip := net.ParseIP(l.IP)
+		if ip == nil || ip.To4() != nil || l.ClientID == "" || l.Interface != s.Interface {
+			continue
+		}
+		s.Leases[l.ClientID] = ip
+		s.TakenIPs[ip.String()] = l.ClientID
+		s.leaseExpiry[l.ClientID] = l.LeaseEnd
+		if l.MAC != "" {
+			s.macs[l.ClientID] = l.MAC
+		}
+		if l.Hostname != "" {
+			s.hostnames[l.ClientID] = l.Hostname
+		}
+		loaded++
+	}
+	log.Printf("[DHCPv6] Loaded %d leases for %s from state store", loaded, s.Interface)
+}
+
+// reservation finds the reservation for a client, matching the DUID first
+// and then the MAC address embedded in it.
+func (s *LeaseStoreV6) reservation(duid, mac string) (config.DHCPReservation, bool) {
+	if res, ok := s.Reservations[duid]; ok {
+		return res, true
+	}
+	if mac != "" {
+		if res, ok := s.Reservations[mac]; ok {
+			return res, true
+		}
+	}
+	return config.DHCPReservation{}, false
+}
+
+// Reservation returns the reservation for a client, if any.
+func (s *LeaseStoreV6) Reservation(duid, mac string) (config.DHCPReservation, bool) {
+	s.Lock()
+	defer s.Unlock()
+	return s.reservation(duid, mac)
+}
+
+// Offer returns the address Allocate would give a client without binding
+// it, to answer a Solicit.
+func (s *LeaseStoreV6) Offer(duid, mac string) (net.IP, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if res, ok := s.reservation(duid, mac); ok {
+		return net.ParseIP(res.IPv6), nil
+	}
+	if ip, ok := s.Leases[duid]; ok {
+		return ip, nil
+	}
+	return s.free(nil)
+}
+
+// Allocate returns the address for a client: its reservation, its current
+// binding, or a free address from the range, preferring the one it asked
+// for. New bindings are persisted.
+func (s *LeaseStoreV6) Allocate(duid, mac string, requested net.IP) (net.IP, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if res, ok := s.reservation(duid, mac); ok {
+		ip := net.ParseIP(res.IPv6)
+		if current, ok := s.Leases[duid]; ok && current.Equal(ip) {
+			return current, nil
+		}
+		// A dynamic lease handed out before the reservation was added
+		// gives way to the reserved client
+		if other, ok := s.TakenIPs[ip.String()]; ok && other != duid {
+			s.remove(other)
+		}
+		return ip, s.bind(duid, mac, ip)
+	}
+
+	if ip, ok := s.Leases[duid]; ok {
+		return ip, nil
+	}
+
+	ip, err := s.free(requested)
+	if err != nil {
+		return nil, err
+	}
+	s.next = s.after(ip)
+	return ip, s.bind(duid, mac, ip)
+}
+
+// free returns an unused address from the range: the requested one if
+// it qualifies, or the first one from where the last allocation left off.
+// Caller must hold the lock.
+func (s *LeaseStoreV6) free(requested net.IP) (net.IP, error) {
+	if s.RangeStart == nil {
+		return nil, fmt.Errorf("no IPv6 range configured")
+	}
+	if requested != nil && s.inRange(requested) && s.available(requested) {
+		return requested, nil
+	}
+
+	start := s.next
+	if start == nil {
+		start = s.RangeStart
+	}
+	for ip := start; ; {
+		if s.available(ip) {
+			return ip, nil
+		}
+		if ip = s.after(ip); ipMatches(ip, start) {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no IPv6 addresses available")
+}
+
+// available reports whether an address is neither reserved nor bound.
+// Caller must hold the lock.
+func (s *LeaseStoreV6) available(ip net.IP) bool {
+	ipStr := ip.String()
+	_, reserved := s.ReservedIPs[ipStr]
+	_, taken := s.TakenIPs[ipStr]
+	return !reserved && !taken
+}
+
+// after returns the address following ip in the range, wrapping around.
+func (s *LeaseStoreV6) after(ip net.IP) net.IP {
+	if ipMatches(ip, s.RangeEnd) {
+		return s.RangeStart
+	}
+	return incIP(ip)
+}
+
+// inRange reports whether ip lies within the dynamic range.
+func (s *LeaseStoreV6) inRange(ip net.IP) bool {
+	return s.RangeStart != nil && ip.To4() == nil && ip.To16() != nil &&
+		bytes.Compare(ip.To16(), s.RangeStart.To16()) >= 0 && bytes.Compare(ip.To16(), s.RangeEnd.To16()) <= 0
+}
+
+// bind records and persists a binding. Caller must hold the lock.
+func (s *LeaseStoreV6) bind(duid, mac string, ip net.IP) error {
+	if old, ok := s.Leases[duid]; ok {
+		delete(s.TakenIPs, old.String())
+	}
+	s.Leases[duid] = ip
+	s.TakenIPs[ip.String()] = duid
+	if mac != "" {
+		s.macs[duid] = mac
+	}
+	s.leaseExpiry[duid] = s.getNow().Add(s.getLeaseTime())
+	if err := s.persist(duid); err != nil {
+		return fmt.Errorf("failed to persist lease: %w", err)
+	}
+	return nil
+}
+
+// Renew extends a client's binding and records the hostname registered
+// for it in DNS.
+func (s *LeaseStoreV6) Renew(duid, hostname string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.Leases[duid]; !ok {
+		return fmt.Errorf("no active lease for DUID %s", duid)
+	}
+	if hostname != "" {
+		s.hostnames[duid] = hostname
+	}
+	s.leaseExpiry[duid] = s.getNow().Add(s.getLeaseTime())
+	return s.persist(duid)
+}
+
+// Release removes a client's binding, returning its address and hostname.
+func (s *LeaseStoreV6) Release(duid string) (net.IP, string) {
+	s.Lock()
+	defer s.Unlock()
+
+	ip, ok := s.Leases[duid]
+	if !ok {
+		return nil, ""
+	}
+	hostname := s.hostnames[duid]
+	s.remove(duid)
+	return ip, hostname
+}
+
+// remove deletes a binding. Caller must hold the lock.
+func (s *LeaseStoreV6) remove(duid string) {
+	if ip, ok := s.Leases[duid]; ok {
+		delete(s.TakenIPs, ip.String())
+	}
+	delete(s.Leases, duid)
+	delete(s.macs, duid)
+	delete(s.hostnames, duid)
+	delete(s.leaseExpiry, duid)
+	if s.bucket != nil {
+		if err := s.bucket.DeleteV6(duid); err != nil {
+			log.Printf("[DHCPv6] Failed to delete lease for %s: %v", duid, err)
+		}
+	}
+}
+
+// OnLink reports whether an address belongs to this scope.
+func (s *LeaseStoreV6) OnLink(ip net.IP) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.ReservedIPs[ip.String()]; ok {
+		return true
+	}
+	return s.inRange(ip)
+}
+
+// persist writes a binding to the state store. Caller must hold the lock.
+func (s *LeaseStoreV6) persist(duid string) error {
+	if s.bucket == nil {
+		return nil
+	}
+	expiry := s.leaseExpiry[duid]
+	lease := &state.DHCPLease{
+		MAC:        s.macs[duid],
+		IP:         s.Leases[duid].String(),
+		Hostname:   s.hostnames[duid],
+		Interface:  s.Interface,
+		LeaseStart: expiry.Add(-s.getLeaseTime()),
+		LeaseEnd:   expiry,
+		ClientID:   duid,
+	}
+	return s.bucket.Set(lease)
+}
+
+// getNow returns the current time using injected clock or real time
+func (s *LeaseStoreV6) getNow() time.Time {
+	if s.clock != nil {
+		return s.clock.Now()
+	}
+	return clock.Now()
+}
+
+// getLeaseTime returns the configured lease time or default 24 hours
+func (s *LeaseStoreV6) getLeaseTime() time.Duration {
+	if s.leaseTime > 0 {
+		return s.leaseTime
+	}
+	return 24 * time.Hour
+}
+
+// ExpireLeases removes expired bindings and their DNS records.
+// Returns the number of leases expired
+func (s *LeaseStoreV6) ExpireLeases(dnsUpdater DNSUpdater, listener ExpirationListener) int {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.getNow()
+	expired := 0
+	for duid, expiry := range s.leaseExpiry {
+		if !now.After(expiry) {
+			continue
+		}
+		ip := s.Leases[duid]
+		mac := s.macs[duid]
+		hostname := s.hostnames[duid]
+		s.remove(duid)
+
+		if dnsUpdater != nil && hostname != "" {
+			dnsUpdater.RemoveRecord(hostname)
+		}
+		if listener != nil && mac != "" {
+			listener.OnLeaseExpired(mac, ip, hostname)
+		}
+
+		log.Printf("[DHCPv6] Expired lease for %s (%s)", duid, ip)
+		expired++
+	}
+	return expired
+}
+
+// handleDHCPv6 answers a client message. It returns nil when no reply is
+// due, e.g. for messages addressed to another server.
+func handleDHCPv6(msg *dhcpv6.Message, store *LeaseStoreV6, scope config.DHCPScope, serverID dhcpv6.DUID, dnsUpdater DNSUpdater, listener LeaseListener) (*dhcpv6.Message, error) {
+	cid := msg.Options.ClientID()
+	if cid == nil {
+		if msg.Type() == dhcpv6.MessageTypeInformationRequest {
+			// Client ID is optional here, which NewReplyFromMessage rejects
+			reply := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply, TransactionID: msg.TransactionID}
+			for _, mod := range dhcpv6WithConfig(scope, serverID) {
+				mod(reply)
+			}
+			return reply, nil
+		}
+		return nil, fmt.Errorf("%s without client ID", msg.Type())
+	}
+	if sid := msg.Options.ServerID(); sid != nil && !sid.Equal(serverID) {
+		return nil, nil
+	}
+
+	duid := hex.EncodeToString(cid.ToBytes())
+	mac := ""
+	if hw, err := dhcpv6.ExtractMAC(msg); err == nil {
+		mac = hw.String()
+	}
+	opts := dhcpv6WithConfig(scope, serverID)
+	ia := msg.Options.OneIANA()
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit:
+		rapidCommit := msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil
+		if ia == nil {
+			opts = append(opts, dhcpv6.WithOption(&dhcpv6.OptStatusCode{
+				StatusCode:    iana.StatusNoAddrsAvail,
+				StatusMessage: "only IA_NA is supported",
+			}))
+			return dhcpv6.NewAdvertiseFromSolicit(msg, opts...)
+		}
+		// Only a Rapid Commit binds the address; otherwise it is just
+		// advertised and bound when the client requests it
+		var ip net.IP
+		var err error
+		if rapidCommit {
+			ip, err = store.Allocate(duid, mac, requestedAddress(ia))
+		} else {
+			ip, err = store.Offer(duid, mac)
+		}
+		if err != nil {
+			log.Printf("[DHCPv6] Solicit from %s: %v", duid, err)
+			opts = append(opts, dhcpv6.WithOption(&dhcpv6.OptStatusCode{
+				StatusCode:    iana.StatusNoAddrsAvail,
+				StatusMessage: err.Error(),
+			}))
+			return dhcpv6.NewAdvertiseFromSolicit(msg, opts...)
+		}
+		opts = append(opts, dhcpv6WithIANA(ia, ip, store.getLeaseTime()))
+		if rapidCommit {
+			commitDHCPv6(msg, store, scope, duid, mac, ip, dnsUpdater, listener)
+			return dhcpv6.NewReplyFromMessage(msg, opts...)
+		}
+		return dhcpv6.NewAdvertiseFromSolicit(msg, opts...)
+
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+		if msg.Type() != dhcpv6.MessageTypeRebind && msg.Options.ServerID() == nil {
+			return nil, nil
+		}
+		if ia == nil {
+			return dhcpv6.NewReplyFromMessage(msg, opts...)
+		}
+		ip, err := store.Allocate(duid, mac, requestedAddress(ia))
+		if err != nil {
+			log.Printf("[DHCPv6] %s from %s: %v", msg.Type(), duid, err)
+			failed := &dhcpv6.OptIANA{IaId: ia.IaId}
+			failed.Options.Add(&dhcpv6.OptStatusCode{
+				StatusCode:    iana.StatusNoAddrsAvail,
+				StatusMessage: err.Error(),
+			})
+			opts = append(opts, dhcpv6.WithOption(failed))
+			return dhcpv6.NewReplyFromMessage(msg, opts...)
+		}
+		commitDHCPv6(msg, store, scope, duid, mac, ip, dnsUpdater, listener)
+		opts = append(opts, dhcpv6WithIANA(ia, ip, store.getLeaseTime()))
+		return dhcpv6.NewReplyFromMessage(msg, opts...)
+
+	case dhcpv6.MessageTypeConfirm:
+		status := &dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess, StatusMessage: "all addresses on link"}
+		for _, ia := range msg.Options.IANA() {
+			for _, addr := range ia.Options.Addresses() {
+				if !store.OnLink(addr.IPv6Addr) {
+					status = &dhcpv6.OptStatusCode{StatusCode: iana.StatusNotOnLink, StatusMessage: addr.IPv6Addr.String() + " is not on link"}
+				}
+			}
+		}
+		return dhcpv6.NewReplyFromMessage(msg, append(opts, dhcpv6.WithOption(status))...)
+
+	case dhcpv6.MessageTypeRelease:
+		if msg.Options.ServerID() == nil {
+			return nil, nil
+		}
+		ip, hostname := store.Release(duid)
+		if ip != nil {
+			log.Printf("[DHCPv6] Released %s from %s", ip, duid)
+		}
+		if hostname != "" && dnsUpdater != nil {
+			dnsUpdater.RemoveRecord(hostname)
+		}
+		return dhcpv6.NewReplyFromMessage(msg, append(opts, dhcpv6.WithOption(&dhcpv6.OptStatusCode{
+			StatusCode:    iana.StatusSuccess,
+			StatusMessage: "released",
+		}))...)
+
+	case dhcpv6.MessageTypeInformationRequest:
+		return dhcpv6.NewReplyFromMessage(msg, opts...)
+	}
+
+	return nil, nil
+}
+
+// requestedAddress returns the address a client asked for in an IA_NA.
+func requestedAddress(ia *dhcpv6.OptIANA) net.IP {
+	if addr := ia.Options.OneAddress(); addr != nil {
+		return addr.IPv6Addr
+	}
+	return nil
+}
+
+// answerDHCPv6 answers a packet from a client or, through Relay-Forw, from
+// a relay agent. Relayed messages are served from the scope that received
+// them and answered with a Relay-Repl (RFC 8415 section 19).
+func answerDHCPv6(m dhcpv6.DHCPv6, store *LeaseStoreV6, scope config.DHCPScope, serverID dhcpv6.DUID, dnsUpdater DNSUpdater, listener LeaseListener) (dhcpv6.DHCPv6, error) {
+	var relay *dhcpv6.RelayMessage
+	msg, ok := m.(*dhcpv6.Message)
+	if !ok {
+		relay, ok = m.(*dhcpv6.RelayMessage)
+		if !ok || relay.Type() != dhcpv6.MessageTypeRelayForward {
+			return nil, fmt.Errorf("unexpected %s", m.Type())
+		}
+		inner, err := relay.GetInnerMessage()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Type(), err)
+		}
+		msg = inner
+	}
+
+	reply, err := handleDHCPv6(msg, store, scope, serverID, dnsUpdater, listener)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	if relay != nil {
+		return dhcpv6.NewRelayReplFromRelayForw(relay, reply)
+	}
+	return reply, nil
+}
+
+// commitDHCPv6 extends a confirmed binding and registers it in DNS.
+func commitDHCPv6(msg *dhcpv6.Message, store *LeaseStoreV6, scope config.DHCPScope, duid, mac string, ip net.IP, dnsUpdater DNSUpdater, listener LeaseListener) {
+	// Prefer the name the client sent, as DHCPv4 does with option 12
+	hostname := ""
+	if fqdn := msg.Options.FQDN(); fqdn != nil && fqdn.DomainName != nil && len(fqdn.DomainName.Labels) > 0 {
+		hostname = strings.SplitN(fqdn.DomainName.Labels[0], ".", 2)[0]
+	}
+	if hostname == "" {
+		if res, ok := store.Reservation(duid, mac); ok {
+			hostname = res.Hostname
+		}
+	}
+	if hostname != "" && scope.Domain != "" {
+		hostname = hostname + "." + scope.Domain
+	}
+
+	if err := store.Renew(duid, hostname); err != nil {
+		log.Printf("[DHCPv6] Failed to persist lease for %s: %v", duid, err)
+	}
+	if hostname != "" && dnsUpdater != nil {
+		dnsUpdater.AddRecord(hostname, ip)
+	}
+	if listener != nil && mac != "" {
+		go listener.OnLease(mac, ip, hostname)
+	}
+}
+
+// dhcpv6WithConfig returns the options included in every reply.
+func dhcpv6WithConfig(scope config.DHCPScope, serverID dhcpv6.DUID) []dhcpv6.Modifier {
+	opts := []dhcpv6.Modifier{dhcpv6.WithServerID(serverID)}
+	if dns := parseIPs(scope.DNSServersV6); len(dns) > 0 {
+		opts = append(opts, dhcpv6.WithDNS(dns...))
+	}
+	if scope.Domain != "" {
+		opts = append(opts, dhcpv6.WithDomainSearchList(scope.Domain))
+	}
+	return opts
+}
+
+// dhcpv6WithIANA answers an IA_NA with the assigned address. Other
+// addresses the client asked for are returned with zero lifetimes so it
+// stops using them (RFC 8415 section 18.3.4).
+func dhcpv6WithIANA(ia *dhcpv6.OptIANA, ip net.IP, lifetime time.Duration) dhcpv6.Modifier {
+	reply := &dhcpv6.OptIANA{
+		IaId: ia.IaId,
+		T1:   lifetime / 2,
+		T2:   lifetime * 4 / 5,
+	}
+	reply.Options.Add(&dhcpv6.OptIAAddress{
+		IPv6Addr:          ip,
+		PreferredLifetime: lifetime,
+		ValidLifetime:     lifetime,
+	})
+	for _, addr := range ia.Options.Addresses() {
+		if !addr.IPv6Addr.Equal(ip) {
+			reply.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: addr.IPv6Addr})
+		}
+	}
+	return dhcpv6.WithOption(reply)
+}
+
+func (s *Service) createServerV6(scope config.DHCPScope) (*dhcpv6Instance, *LeaseStoreV6, error) {
+	ls, err := newLeaseStoreV6(scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.store != nil {
+		bucket, err := state.NewDHCPBucket(s.store)
+		if err != nil {
+			log.Printf("[DHCPv6] Warning: Failed to create/open DHCP bucket: %v", err)
+		} else {
+			ls.bucket = bucket
+			ls.load()
+		}
+	}
+
+	ifi, err := net.InterfaceByName(scope.Interface)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Server DUID-LL from the serving interface, stable across restarts
+	var serverID dhcpv6.DUID
+	if len(ifi.HardwareAddr) > 0 {
+		serverID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: ifi.HardwareAddr}
+	} else {
+		duid, err := dhcpv6.GetDUIDLL()
+		if err != nil {
+			return nil, nil, err
+		}
+		serverID = duid
+	}
+
+	fo := s.failover
+	handler := func(conn net.PacketConn, peer net.Addr, m dhcpv6.DHCPv6) {
+		if fo != nil && !fo.answersV6(fo.state()) {
+			return // The failover partner serves DHCPv6
+		}
+
+		s.mu.RLock()
+		dnsUpdater := s.dnsUpdater
+		listener := s.leaseListener
+		s.mu.RUnlock()
+
+		reply, err := answerDHCPv6(m, ls, scope, serverID, dnsUpdater, listener)
+		if err != nil {
+			log.Printf("[DHCPv6] %s error: %v", m.Type(), err)
+			return
+		}
+		if reply == nil {
+			return
+		}
+		if _, err := conn.WriteTo(reply.ToBytes(), peer); err != nil {
+			log.Printf("[DHCPv6] Write%s error: %v (dest=%v)", reply.Type(), err, peer)
+		}
+	}
+
+	linkName := "dhcp-v6-" + scope.Interface
+
+	var conn net.PacketConn
+	if s.upgradeMgr != nil {
+		if existing, ok := s.upgradeMgr.GetPacketConn(linkName); ok {
+			conn = existing
+			log.Printf("[DHCPv6] Inherited socket %s", linkName)
+		}
+	}
+
+	if conn == nil {
+		udpConn, err := listenDHCPv6(ifi)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn = udpConn
+
+		if s.upgradeMgr != nil {
+			s.upgradeMgr.RegisterPacketConn(linkName, conn)
+		}
+	}
+
+	return &dhcpv6Instance{conn: conn, handler: handler}, ls, nil
+}
+
+// listenDHCPv6 opens the server port on an interface, joined to the group
+// clients solicit: All_DHCP_Relay_Agents_and_Servers (ff02::1:2).
+func listenDHCPv6(ifi *net.Interface) (*net.UDPConn, error) {
+	udpConn, err := server6.NewIPv6UDPConn(ifi.Name, &net.UDPAddr{
+		IP:   net.IPv6unspecified,
+		Port: dhcpv6.DefaultServerPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	group := &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort}
+	if err := ipv6.NewPacketConn(udpConn).JoinGroup(ifi, group); err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("failed to join %s on %s: %w", group.IP, ifi.Name, err)
+	}
+	return udpConn, nil
+}
+
+// serveDHCPv6 runs the read loop for a DHCPv6 server instance
+func (s *Service) serveDHCPv6(conn net.PacketConn, handler func(conn net.PacketConn, peer net.Addr, m dhcpv6.DHCPv6)) {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.IsRunning() {
+				log.Printf("[DHCPv6] Read error: %v", err)
+			}
+			return
+		}
+
+		pkt, err := dhcpv6.FromBytes(buf[:n])
+		if err != nil {
+			continue
+		}
+		handler(conn, addr, pkt)
+	}
+}
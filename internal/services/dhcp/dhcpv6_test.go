@@ -0,0 +1,337 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/state"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+var testServerDUID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+
+func newTestStoreV6(t *testing.T, scope config.DHCPScope) *LeaseStoreV6 {
+	t.Helper()
+	ls, err := newLeaseStoreV6(scope)
+	if err != nil {
+		t.Fatalf("newLeaseStoreV6 failed: %v", err)
+	}
+	return ls
+}
+
+// exchange runs a Solicit/Advertise/Request/Reply exchange for a client.
+func exchange(t *testing.T, ls *LeaseStoreV6, scope config.DHCPScope, dns DNSUpdater, mac net.HardwareAddr, mods ...dhcpv6.Modifier) net.IP {
+	t.Helper()
+	sol, err := dhcpv6.NewSolicit(mac, mods...)
+	if err != nil {
+		t.Fatalf("NewSolicit failed: %v", err)
+	}
+	adv, err := handleDHCPv6(sol, ls, scope, testServerDUID, dns, nil)
+	if err != nil || adv == nil {
+		t.Fatalf("Solicit not answered: %v", err)
+	}
+	if adv.Type() != dhcpv6.MessageTypeAdvertise {
+		t.Fatalf("expected Advertise, got %s", adv.Type())
+	}
+
+	req, err := dhcpv6.NewRequestFromAdvertise(adv, mods...)
+	if err != nil {
+		t.Fatalf("NewRequestFromAdvertise failed: %v", err)
+	}
+	reply, err := handleDHCPv6(req, ls, scope, testServerDUID, dns, nil)
+	if err != nil || reply == nil {
+		t.Fatalf("Request not answered: %v", err)
+	}
+	if reply.Type() != dhcpv6.MessageTypeReply {
+		t.Fatalf("expected Reply, got %s", reply.Type())
+	}
+	ia := reply.Options.OneIANA()
+	if ia == nil || ia.Options.OneAddress() == nil {
+		t.Fatalf("Reply has no IA_NA address: %s", reply.Summary())
+	}
+	return ia.Options.OneAddress().IPv6Addr
+}
+
+func TestDHCPv6_AllocateFromRange(t *testing.T) {
+	scope := config.DHCPScope{
+		Interface:    "eth0",
+		RangeStartV6: "2001:db8::100",
+		RangeEndV6:   "2001:db8::101",
+		DNSServersV6: []string{"2001:db8::1"},
+		Domain:       "lan",
+	}
+	ls := newTestStoreV6(t, scope)
+
+	mac1, _ := net.ParseMAC("00:11:22:33:44:01")
+	mac2, _ := net.ParseMAC("00:11:22:33:44:02")
+	mac3, _ := net.ParseMAC("00:11:22:33:44:03")
+
+	ip1 := exchange(t, ls, scope, nil, mac1)
+	ip2 := exchange(t, ls, scope, nil, mac2)
+	if !ip1.Equal(net.ParseIP("2001:db8::100")) || !ip2.Equal(net.ParseIP("2001:db8::101")) {
+		t.Errorf("unexpected allocations %s, %s", ip1, ip2)
+	}
+
+	// Same client gets the same address again
+	if ip := exchange(t, ls, scope, nil, mac1); !ip.Equal(ip1) {
+		t.Errorf("expected %s on rebinding, got %s", ip1, ip)
+	}
+
+	// Range exhausted
+	sol, _ := dhcpv6.NewSolicit(mac3)
+	adv, err := handleDHCPv6(sol, ls, scope, testServerDUID, nil, nil)
+	if err != nil {
+		t.Fatalf("handleDHCPv6 failed: %v", err)
+	}
+	if status := adv.Options.Status(); status == nil || status.StatusCode != iana.StatusNoAddrsAvail {
+		t.Errorf("expected NoAddrsAvail, got %v", status)
+	}
+	if dns := adv.Options.DNS(); len(dns) != 1 || !dns[0].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("expected dns_v6 in reply, got %v", dns)
+	}
+}
+
+func TestDHCPv6_DUIDReservation(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	duid := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}
+
+	scope := config.DHCPScope{
+		Interface:    "eth0",
+		RangeStartV6: "2001:db8::100",
+		RangeEndV6:   "2001:db8::1ff",
+		Domain:       "lan",
+		Reservations: []config.DHCPReservation{
+			{MAC: "aa:aa:aa:aa:aa:aa", DUID: "00:03:00:01:00:11:22:33:44:55", IPv6: "2001:db8::50", Hostname: "nas"},
+			{MAC: "00:11:22:33:44:66", IPv6: "2001:db8::51"},
+		},
+	}
+	ls := newTestStoreV6(t, scope)
+	dns := &MockDNSUpdater{}
+
+	ip := exchange(t, ls, scope, dns, mac, dhcpv6.WithClientID(duid))
+	if !ip.Equal(net.ParseIP("2001:db8::50")) {
+		t.Errorf("expected reserved address, got %s", ip)
+	}
+	if got, ok := dns.records["nas.lan"]; !ok || !got.Equal(ip) {
+		t.Errorf("expected nas.lan -> %s, got %v", ip, dns.records)
+	}
+
+	// Reservation by the MAC in a DUID-LLT
+	mac2, _ := net.ParseMAC("00:11:22:33:44:66")
+	if ip := exchange(t, ls, scope, dns, mac2); !ip.Equal(net.ParseIP("2001:db8::51")) {
+		t.Errorf("expected MAC reservation, got %s", ip)
+	}
+}
+
+func TestDHCPv6_FQDNAndRelease(t *testing.T) {
+	store, _ := state.NewSQLiteStore(state.DefaultOptions(":memory:"))
+	defer store.Close()
+	bucket, _ := state.NewDHCPBucket(store)
+
+	scope := config.DHCPScope{
+		Interface:    "eth0",
+		RangeStartV6: "2001:db8::100",
+		RangeEndV6:   "2001:db8::1ff",
+		Domain:       "lan",
+	}
+	ls := newTestStoreV6(t, scope)
+	ls.bucket = bucket
+	dns := &MockDNSUpdater{}
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:77")
+	ip := exchange(t, ls, scope, dns, mac, dhcpv6.WithFQDN(0, "laptop"))
+	if got, ok := dns.records["laptop.lan"]; !ok || !got.Equal(ip) {
+		t.Fatalf("expected laptop.lan -> %s, got %v", ip, dns.records)
+	}
+
+	// Binding is persisted by DUID
+	leases, _ := bucket.List()
+	if len(leases) != 1 || leases[0].IP != ip.String() || leases[0].ClientID == "" || leases[0].MAC != mac.String() {
+		t.Fatalf("unexpected persisted leases: %+v", leases)
+	}
+	duid := leases[0].ClientID
+
+	// A restarted server recovers the binding
+	restored := newTestStoreV6(t, scope)
+	restored.bucket = bucket
+	restored.load()
+	if got := restored.Leases[duid]; !got.Equal(ip) {
+		t.Errorf("expected restored lease %s, got %s", ip, got)
+	}
+
+	// Release drops the lease and the DNS record
+	sol, _ := dhcpv6.NewSolicit(mac)
+	rel, _ := dhcpv6.NewMessage()
+	rel.MessageType = dhcpv6.MessageTypeRelease
+	rel.AddOption(sol.GetOneOption(dhcpv6.OptionClientID))
+	rel.AddOption(dhcpv6.OptServerID(testServerDUID))
+	reply, err := handleDHCPv6(rel, ls, scope, testServerDUID, dns, nil)
+	if err != nil || reply == nil {
+		t.Fatalf("Release not answered: %v", err)
+	}
+	if _, ok := dns.records["laptop.lan"]; ok {
+		t.Error("DNS record should be removed on release")
+	}
+	if leases, _ := bucket.List(); len(leases) != 0 {
+		t.Errorf("expected no persisted leases, got %d", len(leases))
+	}
+}
+
+func TestDHCPv6_IgnoresOtherServers(t *testing.T) {
+	scope := config.DHCPScope{Interface: "eth0", RangeStartV6: "2001:db8::100", RangeEndV6: "2001:db8::1ff"}
+	ls := newTestStoreV6(t, scope)
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:88")
+	sol, _ := dhcpv6.NewSolicit(mac)
+	req, _ := dhcpv6.NewMessage()
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(sol.GetOneOption(dhcpv6.OptionClientID))
+	req.AddOption(dhcpv6.OptServerID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 2}}))
+	req.AddOption(sol.Options.OneIANA())
+
+	reply, err := handleDHCPv6(req, ls, scope, testServerDUID, nil, nil)
+	if err != nil || reply != nil {
+		t.Errorf("expected no reply for another server's Request, got %v, %v", reply, err)
+	}
+	if len(ls.Leases) != 0 {
+		t.Error("no lease should be allocated")
+	}
+}
+
+func TestDHCPv6_ExpireLeases(t *testing.T) {
+	mockClock := clock.NewMockClock(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	scope := config.DHCPScope{Interface: "eth0", RangeStartV6: "2001:db8::100", RangeEndV6: "2001:db8::1ff", Domain: "lan"}
+	ls := newTestStoreV6(t, scope)
+	ls.clock = mockClock
+	ls.leaseTime = time.Hour
+	dns := &MockDNSUpdater{}
+	listener := &TestLeaseListener{}
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:99")
+	ip := exchange(t, ls, scope, dns, mac, dhcpv6.WithFQDN(0, "phone"))
+
+	if n := ls.ExpireLeases(dns, listener); n != 0 {
+		t.Fatalf("expected no expirations yet, got %d", n)
+	}
+	mockClock.Advance(2 * time.Hour)
+	if n := ls.ExpireLeases(dns, listener); n != 1 {
+		t.Fatalf("expected 1 expiration, got %d", n)
+	}
+	if _, ok := dns.records["phone.lan"]; ok {
+		t.Error("DNS record should be removed on expiry")
+	}
+	if listener.ExpirationCount() != 1 || !listener.expirations[0].IP.Equal(ip) {
+		t.Errorf("expected expiration callback for %s", ip)
+	}
+}
+
+func TestDHCPv6_SolicitDoesNotBind(t *testing.T) {
+	store, _ := state.NewSQLiteStore(state.DefaultOptions(":memory:"))
+	defer store.Close()
+	bucket, _ := state.NewDHCPBucket(store)
+
+	scope := config.DHCPScope{Interface: "eth0", RangeStartV6: "2001:db8::100", RangeEndV6: "2001:db8::1ff"}
+	ls := newTestStoreV6(t, scope)
+	ls.bucket = bucket
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:aa")
+	sol, _ := dhcpv6.NewSolicit(mac)
+	adv, err := handleDHCPv6(sol, ls, scope, testServerDUID, nil, nil)
+	if err != nil || adv == nil || adv.Type() != dhcpv6.MessageTypeAdvertise {
+		t.Fatalf("Solicit not advertised: %v, %v", adv, err)
+	}
+	if leases, _ := bucket.List(); len(ls.Leases) != 0 || len(leases) != 0 {
+		t.Errorf("Solicit bound %v (persisted %d)", ls.Leases, len(leases))
+	}
+
+	// Rapid Commit binds straight away
+	rapid, _ := dhcpv6.NewSolicit(mac, dhcpv6.WithRapidCommit)
+	reply, err := handleDHCPv6(rapid, ls, scope, testServerDUID, nil, nil)
+	if err != nil || reply == nil || reply.Type() != dhcpv6.MessageTypeReply {
+		t.Fatalf("Rapid Commit not replied: %v, %v", reply, err)
+	}
+	ip := reply.Options.OneIANA().Options.OneAddress().IPv6Addr
+	if leases, _ := bucket.List(); len(leases) != 1 || leases[0].IP != ip.String() {
+		t.Errorf("expected %s to be bound, got %+v", ip, leases)
+	}
+}
+
+func TestDHCPv6_NextFreeAddress(t *testing.T) {
+	scope := config.DHCPScope{Interface: "eth0", RangeStartV6: "2001:db8::100", RangeEndV6: "2001:db8::103"}
+	ls := newTestStoreV6(t, scope)
+
+	allocate := func(duid string, requested net.IP) string {
+		t.Helper()
+		ip, err := ls.Allocate(duid, "", requested)
+		if err != nil {
+			t.Fatalf("Allocate(%s) failed: %v", duid, err)
+		}
+		return ip.String()
+	}
+
+	for i, want := range []string{"2001:db8::100", "2001:db8::101", "2001:db8::102"} {
+		if got := allocate(string(rune('a'+i)), nil); got != want {
+			t.Errorf("allocation %d = %s, want %s", i, got, want)
+		}
+	}
+	ls.Release("a")
+
+	// The search resumes after the last allocation and wraps around
+	if got := allocate("d", nil); got != "2001:db8::103" {
+		t.Errorf("expected the next address, got %s", got)
+	}
+	if got := allocate("e", nil); got != "2001:db8::100" {
+		t.Errorf("expected the released address after wrapping, got %s", got)
+	}
+	if _, err := ls.Allocate("f", "", nil); err == nil {
+		t.Error("expected an exhausted range")
+	}
+
+	// A free address the client asks for is preferred
+	ls.Release("c")
+	ls.Release("b")
+	if got := allocate("g", net.ParseIP("2001:db8::102")); got != "2001:db8::102" {
+		t.Errorf("expected the requested address, got %s", got)
+	}
+}
+
+func TestDHCPv6_Relayed(t *testing.T) {
+	scope := config.DHCPScope{Interface: "eth0", RangeStartV6: "2001:db8::100", RangeEndV6: "2001:db8::1ff"}
+	ls := newTestStoreV6(t, scope)
+
+	mac, _ := net.ParseMAC("00:11:22:33:44:bb")
+	sol, _ := dhcpv6.NewSolicit(mac)
+	inner, _ := dhcpv6.EncapsulateRelay(sol, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	inner.AddOption(dhcpv6.OptInterfaceID([]byte("eth3")))
+	outer, _ := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8:1::1"))
+
+	reply, err := answerDHCPv6(outer, ls, scope, testServerDUID, nil, nil)
+	if err != nil || reply == nil {
+		t.Fatalf("relayed Solicit not answered: %v", err)
+	}
+	repl, ok := reply.(*dhcpv6.RelayMessage)
+	if !ok || repl.Type() != dhcpv6.MessageTypeRelayReply || !repl.PeerAddr.Equal(net.ParseIP("2001:db8:1::1")) {
+		t.Fatalf("expected Relay-Repl to the outer relay, got %s", reply.Summary())
+	}
+	hop, err := dhcpv6.DecapsulateRelay(repl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := hop.(*dhcpv6.RelayMessage); !ok || string(r.Options.InterfaceID()) != "eth3" {
+		t.Errorf("inner Relay-Repl lost the interface ID: %s", hop.Summary())
+	}
+	adv, err := repl.GetInnerMessage()
+	if err != nil || adv.Type() != dhcpv6.MessageTypeAdvertise || adv.TransactionID != sol.TransactionID {
+		t.Errorf("expected the Advertise inside, got %v, %v", adv, err)
+	}
+
+	// Only Relay-Forw is served
+	if _, err := answerDHCPv6(repl, ls, scope, testServerDUID, nil, nil); err == nil {
+		t.Error("expected a Relay-Repl to be rejected")
+	}
+}
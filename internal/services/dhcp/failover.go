@@ -0,0 +1,191 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/state"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	failoverActiveStandby = "active_standby"
+	failoverSplit         = "split"
+
+	defaultMCLT = time.Hour
+)
+
+// FailoverPeer reports this node's role in the HA pair.
+// *state.Replicator implements it.
+type FailoverPeer interface {
+	Role() (mode state.ReplicationMode, partnerUp bool)
+}
+
+// failover coordinates the DHCPv4 servers of an HA pair. Replication is
+// one-way, so the secondary sees the primary's leases but not the reverse;
+// the pools are split so that neither node allocates an address the other
+// may hold, and ARP probing catches what replication cannot.
+type failover struct {
+	mode  string
+	mclt  time.Duration
+	split int // Percentage of each pool, and of clients, owned by the primary
+	clock clock.Clock
+	probe prober // Checks addresses are unused, with or without conflict detection
+
+	mu        sync.Mutex
+	peer      FailoverPeer
+	downSince time.Time // When the partner was first seen unreachable
+}
+
+// failoverState is this node's view of the pair at one point in time.
+type failoverState struct {
+	mode        state.ReplicationMode
+	partnerUp   bool
+	partnerDown bool // Unreachable for longer than the MCLT
+}
+
+func (st failoverState) primary() bool {
+	return st.mode == state.ModePrimary
+}
+
+// newFailover returns the failover settings for cfg, or nil when failover
+// is not configured.
+func newFailover(cfg *config.DHCPFailover) *failover {
+	if cfg == nil {
+		return nil
+	}
+	f := &failover{
+		mode:  cfg.Mode,
+		mclt:  defaultMCLT,
+		split: cfg.Split,
+		probe: arpProbe,
+	}
+	if f.mode == "" {
+		f.mode = failoverActiveStandby
+	}
+	if cfg.MCLT != "" {
+		if d, err := time.ParseDuration(cfg.MCLT); err == nil && d > 0 {
+			f.mclt = d
+		}
+	}
+	if f.split <= 0 || f.split >= 100 {
+		f.split = 90
+		if f.mode == failoverSplit {
+			f.split = 50
+		}
+	}
+	return f
+}
+
+func (f *failover) setPeer(peer FailoverPeer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.peer = peer
+}
+
+func (f *failover) now() time.Time {
+	if f.clock != nil {
+		return f.clock.Now()
+	}
+	return clock.Now()
+}
+
+// state samples the partner's reachability. Without a peer the node acts
+// as a primary whose partner is unreachable.
+func (f *failover) state() failoverState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st := failoverState{mode: state.ModePrimary}
+	if f.peer != nil {
+		st.mode, st.partnerUp = f.peer.Role()
+	}
+
+	if st.partnerUp {
+		f.downSince = time.Time{}
+		return st
+	}
+	now := f.now()
+	if f.downSince.IsZero() {
+		f.downSince = now
+		log.Printf("[DHCP] Failover partner unreachable, capping leases at %v", f.mclt)
+	}
+	st.partnerDown = now.Sub(f.downSince) >= f.mclt
+	return st
+}
+
+// answers reports whether this node should answer m. While the partner is
+// up, the standby stays silent (active_standby) or clients are balanced by
+// a hash of their MAC (split). Renewals are always answered by a node that
+// holds the lease, since they are unicast to whichever node granted it.
+func (f *failover) answers(st failoverState, m *dhcpv4.DHCPv4, holds func(mac string, ip net.IP) bool) bool {
+	if st.mode == state.ModeStandby {
+		return false // Role not decided yet
+	}
+	if !st.partnerUp {
+		return true
+	}
+	if f.mode == failoverActiveStandby {
+		return st.primary()
+	}
+	if f.clientOwner(m.ClientHWAddr) == st.primary() {
+		return true
+	}
+	return m.MessageType() == dhcpv4.MessageTypeRequest &&
+		!m.ClientIPAddr.IsUnspecified() && holds(m.ClientHWAddr.String(), m.ClientIPAddr)
+}
+
+// answersV6 reports whether this node serves DHCPv6. IA_NA bindings are
+// not split, so only one node serves at a time.
+func (f *failover) answersV6(st failoverState) bool {
+	return st.primary() || (st.mode == state.ModeReplica && !st.partnerUp)
+}
+
+// clientOwner reports whether the primary serves the client with this MAC
+// in split mode.
+func (f *failover) clientOwner(mac net.HardwareAddr) bool {
+	h := fnv.New32a()
+	h.Write(mac)
+	return int(h.Sum32()%100) < f.split
+}
+
+// owns reports whether this node may allocate ip from the pool start-end.
+// The primary owns the first split percent of every pool, the secondary
+// the rest; a node whose partner is down uses the whole pool.
+func (f *failover) owns(st failoverState, start, end, ip net.IP) bool {
+	if st.partnerDown {
+		return true
+	}
+	first := binary.BigEndian.Uint32(start.To4())
+	size := uint64(binary.BigEndian.Uint32(end.To4())-first) + 1
+	offset := uint64(binary.BigEndian.Uint32(ip.To4()) - first)
+	return (offset < size*uint64(f.split)/100) == st.primary()
+}
+
+// leaseTime caps lease times at the MCLT while the partner cannot learn
+// about new leases.
+func (f *failover) leaseTime(st failoverState, d time.Duration) time.Duration {
+	if !st.partnerUp && d > f.mclt {
+		return f.mclt
+	}
+	return d
+}
+
+// capLeaseTime shortens the lease time in reply to the MCLT when needed.
+// NAKs carry no lease time and are left alone.
+func (f *failover) capLeaseTime(st failoverState, reply *dhcpv4.DHCPv4) {
+	d := reply.IPAddressLeaseTime(0)
+	if d == 0 {
+		return
+	}
+	if capped := f.leaseTime(st, d); capped < d {
+		reply.UpdateOption(dhcpv4.OptIPAddressLeaseTime(capped))
+	}
+}
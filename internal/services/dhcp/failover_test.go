@@ -0,0 +1,235 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/state"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+type fakeFailoverPeer struct {
+	mode state.ReplicationMode
+	up   bool
+}
+
+func (p *fakeFailoverPeer) Role() (state.ReplicationMode, bool) {
+	return p.mode, p.up
+}
+
+// newFailoverStore returns a lease store for 10.0.0.10-10.0.0.19 with
+// failover enabled and no ARP probing.
+func newFailoverStore(t *testing.T, cfg config.DHCPFailover, peer *fakeFailoverPeer, clk *clock.MockClock) *LeaseStore {
+	t.Helper()
+	store, err := state.NewSQLiteStore(state.DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	bucket, err := state.NewDHCPBucket(store)
+	if err != nil {
+		t.Fatalf("NewDHCPBucket failed: %v", err)
+	}
+
+	f := newFailover(&cfg)
+	f.clock = clk
+	f.probe = nil
+	f.setPeer(peer)
+
+	return &LeaseStore{
+		Leases:       make(map[string]net.IP),
+		TakenIPs:     make(map[string]string),
+		Reservations: make(map[string]config.DHCPReservation),
+		ReservedIPs:  make(map[string]string),
+		RangeStart:   net.ParseIP("10.0.0.10").To4(),
+		RangeEnd:     net.ParseIP("10.0.0.19").To4(),
+		Interface:    "eth1",
+		bucket:       bucket,
+		clock:        clk,
+		failover:     f,
+	}
+}
+
+func TestFailover_SplitPools(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	cfg := config.DHCPFailover{Mode: "split", Split: 50}
+
+	primary := newFailoverStore(t, cfg, &fakeFailoverPeer{mode: state.ModePrimary, up: true}, clk)
+	secondary := newFailoverStore(t, cfg, &fakeFailoverPeer{mode: state.ModeReplica, up: true}, clk)
+
+	for i := 0; i < 5; i++ {
+		ip, err := primary.Allocate(net.HardwareAddr{0, 0, 0, 0, 1, byte(i)}.String())
+		if err != nil {
+			t.Fatalf("primary allocation %d failed: %v", i, err)
+		}
+		if ip[3] > 14 {
+			t.Errorf("primary allocated %s outside its half of the pool", ip)
+		}
+		ip, err = secondary.Allocate(net.HardwareAddr{0, 0, 0, 0, 2, byte(i)}.String())
+		if err != nil {
+			t.Fatalf("secondary allocation %d failed: %v", i, err)
+		}
+		if ip[3] < 15 {
+			t.Errorf("secondary allocated %s outside its half of the pool", ip)
+		}
+	}
+	if _, err := primary.Allocate("00:00:00:00:01:ff"); err == nil {
+		t.Error("expected the primary's share to be exhausted")
+	}
+
+	// Once the partner has been gone for the MCLT, the whole pool is usable
+	secondary.failover.setPeer(&fakeFailoverPeer{mode: state.ModePrimary})
+	secondary.failover.state()
+	clk.Advance(time.Hour)
+	ip, err := secondary.Allocate("00:00:00:00:02:ff")
+	if err != nil {
+		t.Fatalf("allocation after partner down failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("got %s, want the start of the pool", ip)
+	}
+}
+
+func TestFailover_ReplicatedLeases(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	peer := &fakeFailoverPeer{mode: state.ModeReplica, up: true}
+	ls := newFailoverStore(t, config.DHCPFailover{Split: 50}, peer, clk)
+
+	// The primary leased addresses in the secondary's half of the pool
+	// before the split was configured
+	replicated := []*state.DHCPLease{
+		{MAC: "aa:aa:aa:aa:aa:01", IP: "10.0.0.15", LeaseEnd: clk.Now().Add(time.Hour)},
+		{MAC: "aa:aa:aa:aa:aa:02", IP: "10.0.0.16", LeaseEnd: clk.Now().Add(time.Hour)},
+	}
+	for _, l := range replicated {
+		if err := ls.bucket.Set(l); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// A new client skips the partner's leases
+	ip, err := ls.Allocate("bb:bb:bb:bb:bb:01")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.17")) {
+		t.Errorf("got %s, want the first address not leased by the partner", ip)
+	}
+
+	// The partner's client renews here and keeps its address
+	ip, err = ls.Allocate("aa:aa:aa:aa:aa:01")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.15")) {
+		t.Errorf("replicated lease not adopted: got %s", ip)
+	}
+	if !ls.holds("aa:aa:aa:aa:aa:01", ip) {
+		t.Error("adopted lease not held")
+	}
+
+	// Expired replicated leases are ignored; they carry no TTL on a replica
+	clk.Advance(2 * time.Hour)
+	if ip := ls.adoptReplicated("aa:aa:aa:aa:aa:02"); ip != nil {
+		t.Errorf("adopted expired lease %s", ip)
+	}
+	if held := ls.replicatedLeases(); held["10.0.0.16"] != "" {
+		t.Errorf("expired lease still held: %v", held)
+	}
+}
+
+func TestFailover_ARPConflict(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	ls := newFailoverStore(t, config.DHCPFailover{}, &fakeFailoverPeer{mode: state.ModePrimary, up: true}, clk)
+
+	// Conflict detection is off (ls.probe is nil), but failover still probes
+	var probed []string
+	ls.failover.probe = func(iface string, ip net.IP, client net.HardwareAddr) net.HardwareAddr {
+		probed = append(probed, iface+" "+ip.String())
+		if ip.Equal(net.ParseIP("10.0.0.10")) {
+			return net.HardwareAddr{0x02, 0, 0, 0, 0, 0x99}
+		}
+		return nil
+	}
+
+	ip, err := ls.Allocate("00:11:22:33:44:55")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.11")) {
+		t.Errorf("got %s, want the address after the conflict", ip)
+	}
+	if len(probed) != 2 || probed[0] != "eth1 10.0.0.10" {
+		t.Errorf("probes = %v", probed)
+	}
+
+	// The conflicting address is not probed again until it times out
+	probed = nil
+	ls.Allocate("00:11:22:33:44:56")
+	if len(probed) != 1 || probed[0] != "eth1 10.0.0.12" {
+		t.Errorf("probes = %v", probed)
+	}
+	clk.Advance(25 * time.Hour)
+	if ls.isTaken(net.ParseIP("10.0.0.10")) {
+		t.Error("conflict did not time out")
+	}
+}
+
+func TestFailover_Answers(t *testing.T) {
+	clk := clock.NewMockClock(time.Now())
+	peer := &fakeFailoverPeer{mode: state.ModeReplica, up: true}
+	f := newFailover(&config.DHCPFailover{MCLT: "30m"})
+	f.clock = clk
+	f.probe = nil
+	f.setPeer(peer)
+
+	discover, _ := dhcpv4.NewDiscovery(net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+	holds := func(string, net.IP) bool { return false }
+
+	// The standby is silent while the primary is up
+	st := f.state()
+	if f.answers(st, discover, holds) || f.answersV6(st) {
+		t.Error("standby answered while the primary is up")
+	}
+	if got := f.leaseTime(st, 24*time.Hour); got != 24*time.Hour {
+		t.Errorf("lease time = %v with partner up", got)
+	}
+
+	// It takes over when the primary is lost, with leases capped at the MCLT
+	peer.up = false
+	st = f.state()
+	if !f.answers(st, discover, holds) || !f.answersV6(st) {
+		t.Error("standby did not take over")
+	}
+	offer, _ := dhcpv4.NewReplyFromRequest(discover, dhcpv4.WithLeaseTime(uint32((24 * time.Hour).Seconds())))
+	f.capLeaseTime(st, offer)
+	if got := offer.IPAddressLeaseTime(0); got != 30*time.Minute {
+		t.Errorf("lease time = %v, want the MCLT", got)
+	}
+
+	// Nodes whose role is not decided stay silent
+	peer.mode = state.ModeStandby
+	if f.answers(f.state(), discover, holds) {
+		t.Error("undecided node answered")
+	}
+
+	// In split mode each client has one server, but renewals are answered
+	// by whichever node holds the lease
+	split := newFailover(&config.DHCPFailover{Mode: "split"})
+	split.setPeer(&fakeFailoverPeer{mode: state.ModePrimary, up: true})
+	primarySt := split.state()
+	secondarySt := failoverState{mode: state.ModeReplica, partnerUp: true}
+	if split.answers(primarySt, discover, holds) == split.answers(secondarySt, discover, holds) {
+		t.Error("expected exactly one node to answer the client")
+	}
+	renew, _ := dhcpv4.NewRequestFromOffer(offer)
+	renew.ClientIPAddr = net.ParseIP("10.0.0.20")
+	holdsAll := func(string, net.IP) bool { return true }
+	if !split.answers(primarySt, renew, holdsAll) || !split.answers(secondarySt, renew, holdsAll) {
+		t.Error("renewal not answered by the lease holder")
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 // parseOption parses a DHCP option from key-value configuration.
 //
-// Named options (~40 supported):
+// Named options (~70 supported):
 //
 //	dns_server, tftp_server, ntp_server, router, bootfile, domain_name, mtu, etc.
 //	Type auto-detected, prefix optional: dns_server = "8.8.8.8"
@@ -31,12 +31,16 @@ import (
 //	u16:   16-bit unsigned integer (0-65535)
 //	u32:   32-bit unsigned integer
 //	bool:  Boolean (true/false, 1/0)
+//	domains: Comma-separated domain list, RFC 1035 encoded with compression
+//	routes:  Comma-separated "prefix router" pairs, RFC 3442 encoded
 //
 // Examples:
 //
 //	dns_server = "8.8.8.8"              // Named option, auto-typed
 //	"150" = "ip:192.168.1.10"           // Numeric code, prefix required
 //	vendor_specific = "010203"          // Named option, auto-typed as hex
+//	domain_search = "lan, example.com"  // Option 119
+//	classless_static_route = "10.0.0.0/8 192.168.1.254, 0.0.0.0/0 192.168.1.1"
 func parseOption(key, value string) (dhcpv4.Option, error) {
 	// Parse option code (numeric or named)
 	var code dhcpv4.OptionCode
@@ -88,6 +92,17 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 	case "broadcast_address":
 		code = dhcpv4.OptionBroadcastAddress
 
+	case "default_ip_ttl":
+		code = dhcpv4.GenericOptionCode(23)
+	case "path_mtu_aging_timeout":
+		code = dhcpv4.GenericOptionCode(24)
+	case "perform_router_discovery", "router_discovery":
+		code = dhcpv4.GenericOptionCode(31)
+	case "router_solicitation_address":
+		code = dhcpv4.GenericOptionCode(32)
+	case "static_routes", "classful_static_route":
+		code = dhcpv4.GenericOptionCode(33)
+
 	// ARP and TCP (27-40)
 	case "arp_cache_timeout":
 		code = dhcpv4.GenericOptionCode(35)
@@ -96,6 +111,16 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 	case "tcp_keepalive_interval":
 		code = dhcpv4.GenericOptionCode(38)
 
+	// NIS (40-41, 64-65)
+	case "nis_domain":
+		code = dhcpv4.GenericOptionCode(40)
+	case "nis_servers", "nis_server":
+		code = dhcpv4.GenericOptionCode(41)
+	case "nisplus_domain":
+		code = dhcpv4.GenericOptionCode(64)
+	case "nisplus_servers", "nisplus_server":
+		code = dhcpv4.GenericOptionCode(65)
+
 	// Application Services (42-76)
 	case "ntp_server", "ntp_servers", "ntp":
 		code = dhcpv4.OptionNTPServers
@@ -113,6 +138,20 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 		code = dhcpv4.GenericOptionCode(48)
 	case "x_window_display_manager":
 		code = dhcpv4.GenericOptionCode(49)
+	case "mobile_ip_home_agent":
+		code = dhcpv4.GenericOptionCode(68)
+	case "smtp_server":
+		code = dhcpv4.GenericOptionCode(69)
+	case "pop3_server":
+		code = dhcpv4.GenericOptionCode(70)
+	case "nntp_server":
+		code = dhcpv4.GenericOptionCode(71)
+	case "www_server":
+		code = dhcpv4.GenericOptionCode(72)
+	case "finger_server":
+		code = dhcpv4.GenericOptionCode(73)
+	case "irc_server":
+		code = dhcpv4.GenericOptionCode(74)
 
 	// DHCP Extensions (50-61)
 	case "requested_ip", "requested_ip_address":
@@ -139,6 +178,24 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 		code = dhcpv4.GenericOptionCode(60)
 	case "client_identifier", "client_id":
 		code = dhcpv4.OptionClientIdentifier
+	case "user_class":
+		code = dhcpv4.GenericOptionCode(77)
+
+	// Time Zone (100-101, RFC 4833)
+	case "posix_timezone", "tz_posix":
+		code = dhcpv4.GenericOptionCode(100)
+	case "tzdb_timezone", "tz_database", "timezone":
+		code = dhcpv4.GenericOptionCode(101)
+
+	// Modern Network Hints (108, 114)
+	case "ipv6_only_preferred", "v6_only_wait":
+		code = dhcpv4.GenericOptionCode(108)
+	case "captive_portal", "captive_portal_url":
+		code = dhcpv4.GenericOptionCode(114)
+
+	// Domain Search (119, RFC 3397)
+	case "domain_search", "search_domains":
+		code = dhcpv4.GenericOptionCode(119)
 
 	// Boot/PXE (66-67, 150)
 	case "tftp_server", "tftp_server_name":
@@ -148,6 +205,14 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 	case "tftp_server_ip", "tftp_server_address":
 		code = dhcpv4.GenericOptionCode(150)
 
+	// PXELINUX (209-211, RFC 5071)
+	case "pxelinux_config_file", "pxelinux_configfile":
+		code = dhcpv4.GenericOptionCode(209)
+	case "pxelinux_path_prefix", "pxelinux_pathprefix":
+		code = dhcpv4.GenericOptionCode(210)
+	case "pxelinux_reboot_time", "pxelinux_reboottime":
+		code = dhcpv4.GenericOptionCode(211)
+
 	// Advanced Routing (121, 249)
 	case "classless_static_route", "static_route":
 		code = dhcpv4.GenericOptionCode(121)
@@ -158,11 +223,6 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 	case "wpad", "proxy_autodiscovery", "auto_proxy_config":
 		code = dhcpv4.GenericOptionCode(252)
 
-	// Legacy/Unsupported
-	case "domain_search":
-		// Domain search list (option 119) requires RFC 1035 label encoding.
-		// Not implemented due to encoding complexity; use domain option instead.
-		return dhcpv4.Option{}, fmt.Errorf("domain_search option not implemented; use domain option instead")
 	default:
 		// Try to parse numeric code
 		if c, err := strconv.Atoi(key); err == nil && c > 0 && c <= 255 {
@@ -178,7 +238,7 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 	if idx := strings.Index(val, ":"); idx > 0 {
 		prefix := strings.ToLower(val[:idx])
 		switch prefix {
-		case "ip", "str", "text", "hex", "u8", "u16", "u32", "bool":
+		case "ip", "str", "text", "hex", "u8", "u16", "u32", "bool", "domains", "routes":
 			typePrefix = prefix
 			val = val[idx+1:]
 		}
@@ -205,6 +265,17 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 			dhcpv4.GenericOptionCode(45),  // NetBIOS DD Server
 			dhcpv4.GenericOptionCode(48),  // X Window Font Server
 			dhcpv4.GenericOptionCode(49),  // X Window Display Manager
+			dhcpv4.GenericOptionCode(32),  // Router Solicitation Address
+			dhcpv4.GenericOptionCode(33),  // Classful Static Routes (destination/router pairs)
+			dhcpv4.GenericOptionCode(41),  // NIS Servers
+			dhcpv4.GenericOptionCode(65),  // NIS+ Servers
+			dhcpv4.GenericOptionCode(68),  // Mobile IP Home Agent
+			dhcpv4.GenericOptionCode(69),  // SMTP Server
+			dhcpv4.GenericOptionCode(70),  // POP3 Server
+			dhcpv4.GenericOptionCode(71),  // NNTP Server
+			dhcpv4.GenericOptionCode(72),  // WWW Server
+			dhcpv4.GenericOptionCode(73),  // Finger Server
+			dhcpv4.GenericOptionCode(74),  // IRC Server
 			dhcpv4.GenericOptionCode(150): // TFTP Server IP
 			typePrefix = "ip"
 
@@ -216,7 +287,15 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 			dhcpv4.OptionMessage,
 			dhcpv4.GenericOptionCode(18),  // Extensions Path
 			dhcpv4.GenericOptionCode(47),  // NetBIOS Scope
+			dhcpv4.GenericOptionCode(40),  // NIS Domain
+			dhcpv4.GenericOptionCode(64),  // NIS+ Domain
 			dhcpv4.GenericOptionCode(66),  // TFTP Server Name
+			dhcpv4.GenericOptionCode(77),  // User Class
+			dhcpv4.GenericOptionCode(100), // POSIX Time Zone
+			dhcpv4.GenericOptionCode(101), // TZ Database Time Zone
+			dhcpv4.GenericOptionCode(114), // Captive Portal URI
+			dhcpv4.GenericOptionCode(209), // PXELINUX Config File
+			dhcpv4.GenericOptionCode(210), // PXELINUX Path Prefix
 			dhcpv4.GenericOptionCode(252): // WPAD
 			typePrefix = "str"
 
@@ -227,25 +306,39 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 
 		case dhcpv4.OptionTimeOffset,
 			dhcpv4.OptionIPAddressLeaseTime,
-			dhcpv4.GenericOptionCode(35), // ARP Cache Timeout
-			dhcpv4.GenericOptionCode(38), // TCP Keepalive Interval
-			dhcpv4.GenericOptionCode(58), // Renewal Time (T1)
-			dhcpv4.GenericOptionCode(59): // Rebinding Time (T2)
+			dhcpv4.GenericOptionCode(35),  // ARP Cache Timeout
+			dhcpv4.GenericOptionCode(24),  // Path MTU Aging Timeout
+			dhcpv4.GenericOptionCode(38),  // TCP Keepalive Interval
+			dhcpv4.GenericOptionCode(58),  // Renewal Time (T1)
+			dhcpv4.GenericOptionCode(59),  // Rebinding Time (T2)
+			dhcpv4.GenericOptionCode(108), // IPv6-Only Preferred
+			dhcpv4.GenericOptionCode(211): // PXELINUX Reboot Time
 			typePrefix = "u32"
 
 		case dhcpv4.GenericOptionCode(19), // IP Forwarding
 			dhcpv4.GenericOptionCode(20), // Non-local Source Routing
+			dhcpv4.GenericOptionCode(23), // Default IP TTL
+			dhcpv4.GenericOptionCode(31), // Perform Router Discovery
 			dhcpv4.GenericOptionCode(37), // TCP TTL
 			dhcpv4.GenericOptionCode(46), // NetBIOS Node Type
 			dhcpv4.GenericOptionCode(52), // Option Overload
 			dhcpv4.OptionDHCPMessageType:
 			typePrefix = "u8"
 
-		// Vendor-specific and complex options default to hex
-		case dhcpv4.GenericOptionCode(43), // Vendor-Specific
-			dhcpv4.GenericOptionCode(121), // Classless Static Route
+		case dhcpv4.GenericOptionCode(119): // Domain Search
+			typePrefix = "domains"
+
+		// Classless routes, also accepted pre-encoded as hex
+		case dhcpv4.GenericOptionCode(121), // Classless Static Route
 			dhcpv4.GenericOptionCode(249): // MS Classless Static Route
 			typePrefix = "hex"
+			if strings.Contains(val, "/") {
+				typePrefix = "routes"
+			}
+
+		// Vendor-specific options default to hex
+		case dhcpv4.GenericOptionCode(43): // Vendor-Specific
+			typePrefix = "hex"
 
 		// Client Identifier can be various formats, default to hex
 		case dhcpv4.OptionClientIdentifier,
@@ -319,6 +412,20 @@ func parseOption(key, value string) (dhcpv4.Option, error) {
 		binary.BigEndian.PutUint32(b, uint32(i))
 		return dhcpv4.OptGeneric(code, b), nil
 
+	case "domains":
+		b, err := encodeDomainSearch(strings.Split(val, ","))
+		if err != nil {
+			return dhcpv4.Option{}, fmt.Errorf("invalid domain list: %w", err)
+		}
+		return dhcpv4.OptGeneric(code, b), nil
+
+	case "routes":
+		routes, err := parseRoutes(val)
+		if err != nil {
+			return dhcpv4.Option{}, fmt.Errorf("invalid routes: %w", err)
+		}
+		return dhcpv4.OptGeneric(code, routes.ToBytes()), nil
+
 	case "bool":
 		bVal, err := strconv.ParseBool(val)
 		if err != nil {
@@ -340,6 +447,74 @@ func hexDecode(s string) ([]byte, error) {
 	s = strings.ReplaceAll(s, " ", "")
 	return hex.DecodeString(s)
 }
+
+// encodeDomainSearch encodes a domain search list (option 119, RFC 3397)
+// as RFC 1035 names. A name ending in a suffix already written is
+// compressed into a pointer to it; offsets are relative to the start of
+// the option data, which may span several option instances.
+func encodeDomainSearch(domains []string) ([]byte, error) {
+	var b []byte
+	offsets := make(map[string]int) // Suffix -> offset of its first label
+
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" {
+			continue
+		}
+		if len(domain) > 253 {
+			return nil, fmt.Errorf("domain name too long: %s", domain)
+		}
+		labels := strings.Split(domain, ".")
+		for i := range labels {
+			suffix := strings.Join(labels[i:], ".")
+			if off, ok := offsets[suffix]; ok {
+				b = append(b, 0xc0|byte(off>>8), byte(off))
+				break
+			}
+			if len(labels[i]) == 0 || len(labels[i]) > 63 {
+				return nil, fmt.Errorf("invalid label in %s", domain)
+			}
+			if len(b) <= 0x3fff {
+				offsets[suffix] = len(b)
+			}
+			b = append(b, byte(len(labels[i])))
+			b = append(b, labels[i]...)
+			if i == len(labels)-1 {
+				b = append(b, 0)
+			}
+		}
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("no domains")
+	}
+	return b, nil
+}
+
+// parseRoutes parses "prefix router" pairs, e.g.
+// "10.0.0.0/8 192.168.1.254, 0.0.0.0/0 192.168.1.1" ("via" is optional).
+func parseRoutes(s string) (dhcpv4.Routes, error) {
+	var routes dhcpv4.Routes
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 3 && fields[1] == "via" {
+			fields = []string{fields[0], fields[2]}
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected \"prefix router\": %q", strings.TrimSpace(entry))
+		}
+		_, dest, err := net.ParseCIDR(fields[0])
+		if err != nil || dest.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 prefix: %s", fields[0])
+		}
+		router := net.ParseIP(fields[1]).To4()
+		if router == nil {
+			return nil, fmt.Errorf("invalid IPv4 router: %s", fields[1])
+		}
+		routes = append(routes, &dhcpv4.Route{Dest: dest, Router: router})
+	}
+	return routes, nil
+}
+
 func parseIPList(s string) []net.IP {
 	var ips []net.IP
 	parts := strings.Split(s, ",")
@@ -1,9 +1,15 @@
 package dhcp
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+
+	"grimm.is/glacic/internal/config"
 )
 
 func TestParseOption(t *testing.T) {
@@ -102,10 +108,110 @@ func TestParseOption_MTU_Invalid(t *testing.T) {
 	}
 }
 
-func TestParseOption_DomainSearch_NotSupported(t *testing.T) {
-	_, err := parseOption("domain-search", "example.com,test.local")
-	if err == nil {
-		t.Error("expected error for unsupported domain-search")
+func TestParseOption_DomainSearch(t *testing.T) {
+	opt, err := parseOption("domain-search", "eng.example.com, example.com,test.local")
+	if err != nil {
+		t.Fatalf("parseOption() error = %v", err)
+	}
+	if opt.Code != dhcpv4.GenericOptionCode(119) {
+		t.Errorf("code = %v, want 119", opt.Code)
+	}
+
+	// example.com is a pointer into eng.example.com
+	data := opt.Value.ToBytes()
+	want := []byte{
+		3, 'e', 'n', 'g', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0xc0, 4,
+		4, 't', 'e', 's', 't', 5, 'l', 'o', 'c', 'a', 'l', 0,
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("encoded = %v, want %v", data, want)
+	}
+
+	labels, err := rfc1035label.FromBytes(data)
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if got := strings.Join(labels.Labels, ","); got != "eng.example.com,example.com,test.local" {
+		t.Errorf("decoded = %s", got)
+	}
+
+	for _, bad := range []string{"", "a..b", strings.Repeat("x", 64) + ".com"} {
+		if _, err := parseOption("domain_search", bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestEncodeDomainSearch_Long(t *testing.T) {
+	// Lists longer than one option instance keep pointers valid across the
+	// split, since offsets are relative to the concatenated data
+	var domains []string
+	for i := 0; i < 40; i++ {
+		domains = append(domains, fmt.Sprintf("site%02d.region.example.com", i))
+	}
+	data, err := encodeDomainSearch(domains)
+	if err != nil {
+		t.Fatalf("encodeDomainSearch() error = %v", err)
+	}
+	if len(data) <= 255 {
+		t.Fatalf("expected more than one option instance, got %d bytes", len(data))
+	}
+
+	reply, _ := dhcpv4.New(dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionDNSDomainSearchList, data)))
+	parsed, err := dhcpv4.FromBytes(reply.ToBytes())
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+	if got := parsed.DomainSearch(); got == nil || len(got.Labels) != 40 || got.Labels[39] != "site39.region.example.com" {
+		t.Errorf("decoded = %v", got)
+	}
+}
+
+func TestParseOption_ClasslessStaticRoute(t *testing.T) {
+	opt, err := parseOption("classless_static_route", "10.0.0.0/8 192.168.1.254, 0.0.0.0/0 via 192.168.1.1")
+	if err != nil {
+		t.Fatalf("parseOption() error = %v", err)
+	}
+	want := []byte{8, 10, 192, 168, 1, 254, 0, 192, 168, 1, 1}
+	if got := opt.Value.ToBytes(); !bytes.Equal(got, want) {
+		t.Errorf("encoded = %v, want %v", got, want)
+	}
+
+	// Pre-encoded hex is still accepted
+	if opt, err := parseOption("121", "hex:080a c0a801fe"); err != nil || len(opt.Value.ToBytes()) != 6 {
+		t.Errorf("hex routes: %v, %v", opt, err)
+	}
+	for _, bad := range []string{"10.0.0.0/8", "10.0.0.0/8 nope", "2001:db8::/32 192.168.1.1"} {
+		if _, err := parseOption("static_route", bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestParseOption_Catalogue(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  []byte
+	}{
+		{"smtp_server", "10.0.0.25", []byte{10, 0, 0, 25}},
+		{"nis_domain", "corp", []byte("corp")},
+		{"tzdb_timezone", "Europe/Zurich", []byte("Europe/Zurich")},
+		{"captive_portal", "https://portal.example.com/api", []byte("https://portal.example.com/api")},
+		{"ipv6_only_preferred", "1800", []byte{0, 0, 7, 8}},
+		{"pxelinux_reboot_time", "30", []byte{0, 0, 0, 30}},
+		{"default_ip_ttl", "64", []byte{64}},
+	}
+	for _, tt := range tests {
+		opt, err := parseOption(tt.key, tt.value)
+		if err != nil {
+			t.Errorf("%s: %v", tt.key, err)
+			continue
+		}
+		if got := opt.Value.ToBytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.key, got, tt.want)
+		}
 	}
 }
 
@@ -136,3 +242,31 @@ func TestParseIPList_Empty(t *testing.T) {
 		t.Errorf("expected 0 IPs for empty string, got %d", len(ips))
 	}
 }
+
+func TestWithCaptivePortal(t *testing.T) {
+	scope := config.DHCPScope{Name: "guest", Interface: "eth2", Router: "192.168.50.1", Options: map[string]string{"mtu": "1500"}}
+
+	// RFC 8908 requires HTTPS: nothing is advertised without portal TLS
+	if got := withCaptivePortal(scope, nil); len(got.Options) != 1 {
+		t.Errorf("option 114 advertised without TLS: %v", got.Options)
+	}
+
+	portal := &config.CaptivePortalConfig{TLSCert: "/etc/portal.crt", TLSKey: "/etc/portal.key"}
+	got := withCaptivePortal(scope, portal)
+	if got.Options["captive_portal"] != "https://192.168.50.1:2051/api/captive" || got.Options["mtu"] != "1500" {
+		t.Errorf("unexpected options %v", got.Options)
+	}
+	if _, ok := scope.Options["captive_portal"]; ok {
+		t.Error("scope options modified in place")
+	}
+	opt, err := parseOption("captive_portal", got.Options["captive_portal"])
+	if err != nil || opt.Code.Code() != 114 || string(opt.Value.ToBytes()) != "https://192.168.50.1:2051/api/captive" {
+		t.Errorf("option 114 = %v, %v", opt, err)
+	}
+
+	// An explicit option wins
+	scope.Options = map[string]string{"114": "str:https://portal.example.com/api"}
+	if got := withCaptivePortal(scope, portal); len(got.Options) != 1 {
+		t.Errorf("explicit option overridden: %v", got.Options)
+	}
+}
@@ -0,0 +1,379 @@
+package dhcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+)
+
+const (
+	maxRelayHops   = 16 // RFC 1542 hops limit
+	maxRelayHopsV6 = 8  // RFC 8415 HOP_COUNT_LIMIT
+)
+
+// relayAgent forwards DHCP between clients on one interface and central
+// servers. The interface is identified to the servers by option 82
+// (DHCPv4) and the Interface-ID option (DHCPv6), and replies are sent back
+// out of the interface they were relayed from.
+type relayAgent struct {
+	iface     string
+	circuitID []byte
+	remoteID  []byte
+	giaddr    net.IP // Our IPv4 address on the interface
+	linkAddr  net.IP // Our global IPv6 address on the interface, or ::
+	servers   []*net.UDPAddr
+	servers6  []*net.UDPAddr
+}
+
+func newRelayAgent(cfg config.DHCPRelay, ifi *net.Interface, addrs []net.Addr) (*relayAgent, error) {
+	r := &relayAgent{
+		iface:     ifi.Name,
+		circuitID: []byte(cfg.CircuitID),
+		remoteID:  []byte(cfg.RemoteID),
+		linkAddr:  net.IPv6unspecified,
+	}
+	if len(r.circuitID) == 0 {
+		r.circuitID = []byte(ifi.Name)
+	}
+	if len(r.remoteID) == 0 {
+		r.remoteID = []byte(ifi.HardwareAddr)
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			if r.giaddr == nil {
+				r.giaddr = ip4
+			}
+		} else if ipnet.IP.IsGlobalUnicast() && r.linkAddr.IsUnspecified() {
+			r.linkAddr = ipnet.IP
+		}
+	}
+
+	for _, s := range cfg.Servers {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid DHCPv4 server: %s", s)
+		}
+		r.servers = append(r.servers, &net.UDPAddr{IP: ip, Port: dhcpv4.ServerPort})
+	}
+	for _, s := range cfg.ServersV6 {
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid DHCPv6 server: %s", s)
+		}
+		r.servers6 = append(r.servers6, &net.UDPAddr{IP: ip, Port: dhcpv6.DefaultServerPort})
+	}
+	if len(r.servers) > 0 && r.giaddr == nil {
+		return nil, fmt.Errorf("no IPv4 address on %s to relay from", ifi.Name)
+	}
+	return r, nil
+}
+
+// relayRequest returns a copy of a client request to forward to the servers,
+// with our address as giaddr and option 82 identifying the interface.
+// Requests already relayed by a downstream agent keep its giaddr and option 82.
+func (r *relayAgent) relayRequest(m *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if m.OpCode != dhcpv4.OpcodeBootRequest {
+		return nil, fmt.Errorf("not a request: %s", m.OpCode)
+	}
+	if m.HopCount >= maxRelayHops {
+		return nil, fmt.Errorf("hop count %d exceeds limit", m.HopCount)
+	}
+
+	fwd, err := dhcpv4.FromBytes(m.ToBytes())
+	if err != nil {
+		return nil, err
+	}
+	fwd.HopCount++
+	if fwd.GatewayIPAddr == nil || fwd.GatewayIPAddr.IsUnspecified() {
+		// RFC 3046 2.1.1: a client cannot insert option 82 itself
+		if fwd.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+			return nil, errors.New("option 82 from untrusted client")
+		}
+		fwd.GatewayIPAddr = r.giaddr
+		subOpts := []dhcpv4.Option{dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, r.circuitID)}
+		if len(r.remoteID) > 0 {
+			subOpts = append(subOpts, dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, r.remoteID))
+		}
+		fwd.UpdateOption(dhcpv4.OptRelayAgentInfo(subOpts...))
+	}
+	return fwd, nil
+}
+
+// relayReply strips option 82 from a server reply and returns where to send
+// it on the relayed interface.
+func (r *relayAgent) relayReply(m *dhcpv4.DHCPv4) (*net.UDPAddr, error) {
+	if m.OpCode != dhcpv4.OpcodeBootReply {
+		return nil, fmt.Errorf("not a reply: %s", m.OpCode)
+	}
+	if !m.GatewayIPAddr.Equal(r.giaddr) {
+		return nil, fmt.Errorf("reply for giaddr %s", m.GatewayIPAddr)
+	}
+	if rai := m.RelayAgentInfo(); rai != nil {
+		if circuit := rai.Get(dhcpv4.AgentCircuitIDSubOption); circuit != nil && !bytes.Equal(circuit, r.circuitID) {
+			return nil, fmt.Errorf("reply for circuit %q", circuit)
+		}
+	}
+	m.Options.Del(dhcpv4.OptionRelayAgentInformation)
+
+	// Clients without an address yet can only receive a broadcast
+	dest := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	if m.MessageType() != dhcpv4.MessageTypeNak && m.ClientIPAddr != nil && !m.ClientIPAddr.IsUnspecified() {
+		dest.IP = m.ClientIPAddr
+	}
+	return dest, nil
+}
+
+// relayRequestV6 wraps a client message (or a downstream relay's
+// Relay-Forw) in a Relay-Forw carrying the interface ID.
+func (r *relayAgent) relayRequestV6(m dhcpv6.DHCPv6, peer net.IP) (*dhcpv6.RelayMessage, error) {
+	switch m.Type() {
+	case dhcpv6.MessageTypeAdvertise, dhcpv6.MessageTypeReply, dhcpv6.MessageTypeReconfigure, dhcpv6.MessageTypeRelayReply:
+		return nil, fmt.Errorf("not a client message: %s", m.Type())
+	}
+	if relay, ok := m.(*dhcpv6.RelayMessage); ok && relay.HopCount >= maxRelayHopsV6 {
+		return nil, fmt.Errorf("hop count %d exceeds limit", relay.HopCount)
+	}
+
+	fwd, err := dhcpv6.EncapsulateRelay(m, dhcpv6.MessageTypeRelayForward, r.linkAddr, peer)
+	if err != nil {
+		return nil, err
+	}
+	fwd.AddOption(dhcpv6.OptInterfaceID(r.circuitID))
+	return fwd, nil
+}
+
+// relayReplyV6 unwraps a Relay-Repl and returns the inner message and where
+// to send it on the relayed interface.
+func (r *relayAgent) relayReplyV6(m dhcpv6.DHCPv6) (dhcpv6.DHCPv6, *net.UDPAddr, error) {
+	relay, ok := m.(*dhcpv6.RelayMessage)
+	if !ok || relay.Type() != dhcpv6.MessageTypeRelayReply {
+		return nil, nil, fmt.Errorf("not a Relay-Repl: %s", m.Type())
+	}
+	if id := relay.Options.InterfaceID(); id != nil && !bytes.Equal(id, r.circuitID) {
+		return nil, nil, fmt.Errorf("reply for interface %q", id)
+	}
+	inner := relay.Options.RelayMessage()
+	if inner == nil {
+		return nil, nil, errors.New("Relay-Repl without relay message")
+	}
+
+	dest := &net.UDPAddr{IP: relay.PeerAddr, Port: dhcpv6.DefaultClientPort}
+	if inner.IsRelay() {
+		dest.Port = dhcpv6.DefaultServerPort
+	}
+	if dest.IP.IsLinkLocalUnicast() {
+		dest.Zone = r.iface
+	}
+	return inner, dest, nil
+}
+
+// createRelay opens the sockets for a relay. They are returned as server
+// instances so relays share the scope servers' lifecycle.
+func (s *Service) createRelay(cfg config.DHCPRelay) ([]*dhcpInstance, []*dhcpv6Instance, error) {
+	ifi, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := newRelayAgent(cfg, ifi, addrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		instances  []*dhcpInstance
+		instances6 []*dhcpv6Instance
+		opened     []net.PacketConn
+	)
+	fail := func(err error) ([]*dhcpInstance, []*dhcpv6Instance, error) {
+		for _, conn := range opened {
+			conn.Close()
+		}
+		return nil, nil, err
+	}
+
+	if len(r.servers) > 0 {
+		// Clients broadcast on the relayed interface; servers answer giaddr
+		down, err := s.packetConn("dhcp-relay-v4-"+r.iface, func() (net.PacketConn, error) {
+			return server4.NewIPv4UDPConn(r.iface, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort})
+		})
+		if err != nil {
+			return fail(err)
+		}
+		opened = append(opened, down)
+		up, err := s.packetConn("dhcp-relay-v4-up-"+r.iface, func() (net.PacketConn, error) {
+			return server4.NewIPv4UDPConn("", &net.UDPAddr{IP: r.giaddr, Port: dhcpv4.ServerPort})
+		})
+		if err != nil {
+			return fail(err)
+		}
+		opened = append(opened, up)
+
+		handler := s.relayHandler(r, down, up)
+		instances = append(instances, &dhcpInstance{conn: down, handler: handler}, &dhcpInstance{conn: up, handler: handler})
+	}
+
+	if len(r.servers6) > 0 {
+		down, err := s.packetConn("dhcp-relay-v6-"+r.iface, func() (net.PacketConn, error) {
+			return listenDHCPv6(ifi)
+		})
+		if err != nil {
+			return fail(err)
+		}
+		opened = append(opened, down)
+		up, err := s.packetConn("dhcp-relay-v6-up-"+r.iface, func() (net.PacketConn, error) {
+			return server6.NewIPv6UDPConn("", &net.UDPAddr{IP: r.linkAddr, Port: dhcpv6.DefaultServerPort})
+		})
+		if err != nil {
+			return fail(err)
+		}
+		opened = append(opened, up)
+
+		handler := s.relayHandlerV6(r, down, up)
+		instances6 = append(instances6, &dhcpv6Instance{conn: down, handler: handler}, &dhcpv6Instance{conn: up, handler: handler})
+	}
+
+	log.Printf("[DHCP] Relaying %s to %v %v", r.iface, cfg.Servers, cfg.ServersV6)
+	return instances, instances6, nil
+}
+
+// relayHandler forwards client requests arriving on down to the servers and
+// server replies back to the clients.
+func (s *Service) relayHandler(r *relayAgent, down, up net.PacketConn) func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	return func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+		s.mu.RLock()
+		pl := s.packetListener
+		listener := s.leaseListener
+		s.mu.RUnlock()
+
+		switch m.OpCode {
+		case dhcpv4.OpcodeBootRequest:
+			// Broadcasts on the relayed interface also reach the upstream socket
+			if conn != down {
+				return
+			}
+			if pl != nil {
+				go pl(m, r.iface, peer)
+			}
+			fwd, err := r.relayRequest(m)
+			if err != nil {
+				log.Printf("[DHCP] Relay on %s dropped %s: %v", r.iface, m.MessageType(), err)
+				return
+			}
+			for _, server := range r.servers {
+				if _, err := up.WriteTo(fwd.ToBytes(), server); err != nil {
+					log.Printf("[DHCP] Relay to %s failed: %v", server, err)
+				}
+			}
+
+		case dhcpv4.OpcodeBootReply:
+			dest, err := r.relayReply(m)
+			if err != nil {
+				log.Printf("[DHCP] Relay on %s dropped %s: %v", r.iface, m.MessageType(), err)
+				return
+			}
+			if m.MessageType() == dhcpv4.MessageTypeAck && listener != nil && !m.YourIPAddr.IsUnspecified() {
+				go listener.OnLease(m.ClientHWAddr.String(), m.YourIPAddr, m.HostName())
+			}
+			if _, err := down.WriteTo(m.ToBytes(), dest); err != nil {
+				log.Printf("[DHCP] Relay reply on %s failed: %v (dest=%v)", r.iface, err, dest)
+			}
+		}
+	}
+}
+
+// relayHandlerV6 forwards client messages arriving on down to the servers in
+// Relay-Forw, and unwraps Relay-Repl from the servers back to the clients.
+func (s *Service) relayHandlerV6(r *relayAgent, down, up net.PacketConn) func(conn net.PacketConn, peer net.Addr, m dhcpv6.DHCPv6) {
+	return func(conn net.PacketConn, peer net.Addr, m dhcpv6.DHCPv6) {
+		if m.Type() == dhcpv6.MessageTypeRelayReply {
+			inner, dest, err := r.relayReplyV6(m)
+			if err != nil {
+				log.Printf("[DHCPv6] Relay on %s dropped reply: %v", r.iface, err)
+				return
+			}
+			s.notifyRelayedLeaseV6(inner)
+			if _, err := down.WriteTo(inner.ToBytes(), dest); err != nil {
+				log.Printf("[DHCPv6] Relay reply on %s failed: %v (dest=%v)", r.iface, err, dest)
+			}
+			return
+		}
+
+		udpAddr, ok := peer.(*net.UDPAddr)
+		if conn != down || !ok {
+			return
+		}
+		fwd, err := r.relayRequestV6(m, udpAddr.IP)
+		if err != nil {
+			log.Printf("[DHCPv6] Relay on %s dropped %s: %v", r.iface, m.Type(), err)
+			return
+		}
+		for _, server := range r.servers6 {
+			if _, err := up.WriteTo(fwd.ToBytes(), server); err != nil {
+				log.Printf("[DHCPv6] Relay to %s failed: %v", server, err)
+			}
+		}
+	}
+}
+
+// notifyRelayedLeaseV6 reports addresses assigned in a relayed Reply.
+func (s *Service) notifyRelayedLeaseV6(m dhcpv6.DHCPv6) {
+	msg, ok := m.(*dhcpv6.Message)
+	if !ok || msg.Type() != dhcpv6.MessageTypeReply {
+		return
+	}
+	s.mu.RLock()
+	listener := s.leaseListener
+	s.mu.RUnlock()
+	if listener == nil {
+		return
+	}
+	hw, err := dhcpv6.ExtractMAC(msg)
+	if err != nil {
+		return
+	}
+	for _, ia := range msg.Options.IANA() {
+		for _, addr := range ia.Options.Addresses() {
+			if addr.ValidLifetime > 0 {
+				go listener.OnLease(hw.String(), addr.IPv6Addr, "")
+			}
+		}
+	}
+}
+
+// packetConn returns the socket inherited across an upgrade under linkName,
+// or opens a new one and registers it for the next upgrade.
+func (s *Service) packetConn(linkName string, open func() (net.PacketConn, error)) (net.PacketConn, error) {
+	if s.upgradeMgr != nil {
+		if existing, ok := s.upgradeMgr.GetPacketConn(linkName); ok {
+			log.Printf("[DHCP] Inherited socket %s", linkName)
+			return existing, nil
+		}
+	}
+
+	conn, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if s.upgradeMgr != nil {
+		s.upgradeMgr.RegisterPacketConn(linkName, conn)
+	}
+	return conn, nil
+}
@@ -0,0 +1,202 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func newTestRelay(t *testing.T, cfg config.DHCPRelay) *relayAgent {
+	t.Helper()
+	ifi := &net.Interface{Name: "eth1.20", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0x20, 1}}
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("192.168.20.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("2001:db8:20::1"), Mask: net.CIDRMask(64, 128)},
+	}
+	r, err := newRelayAgent(cfg, ifi, addrs)
+	if err != nil {
+		t.Fatalf("newRelayAgent failed: %v", err)
+	}
+	return r
+}
+
+func TestRelayAgent_Defaults(t *testing.T) {
+	r := newTestRelay(t, config.DHCPRelay{Interface: "eth1.20", Servers: []string{"10.0.0.5"}, ServersV6: []string{"2001:db8::5"}})
+
+	if !r.giaddr.Equal(net.ParseIP("192.168.20.1")) {
+		t.Errorf("giaddr = %s, want 192.168.20.1", r.giaddr)
+	}
+	if !r.linkAddr.Equal(net.ParseIP("2001:db8:20::1")) {
+		t.Errorf("link address = %s, want the global address", r.linkAddr)
+	}
+	if string(r.circuitID) != "eth1.20" {
+		t.Errorf("circuit ID = %q, want the interface name", r.circuitID)
+	}
+	if net.HardwareAddr(r.remoteID).String() != "02:00:00:00:20:01" {
+		t.Errorf("remote ID = %x, want the interface MAC", r.remoteID)
+	}
+
+	ifi := &net.Interface{Name: "eth2"}
+	if _, err := newRelayAgent(config.DHCPRelay{Servers: []string{"10.0.0.5"}}, ifi, nil); err == nil {
+		t.Error("expected an error relaying DHCPv4 from an interface without an IPv4 address")
+	}
+}
+
+func TestRelayAgent_DHCPv4(t *testing.T) {
+	r := newTestRelay(t, config.DHCPRelay{Interface: "eth1.20", Servers: []string{"10.0.0.5"}, CircuitID: "vlan20", RemoteID: "router1"})
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	discover, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	if err != nil {
+		t.Fatalf("NewDiscovery failed: %v", err)
+	}
+
+	fwd, err := r.relayRequest(discover)
+	if err != nil {
+		t.Fatalf("relayRequest failed: %v", err)
+	}
+	if !fwd.GatewayIPAddr.Equal(r.giaddr) {
+		t.Errorf("giaddr = %s, want %s", fwd.GatewayIPAddr, r.giaddr)
+	}
+	if fwd.HopCount != 1 {
+		t.Errorf("hops = %d, want 1", fwd.HopCount)
+	}
+	rai := fwd.RelayAgentInfo()
+	if rai == nil {
+		t.Fatal("option 82 not inserted")
+	}
+	if got := string(rai.Get(dhcpv4.AgentCircuitIDSubOption)); got != "vlan20" {
+		t.Errorf("circuit-id = %q, want vlan20", got)
+	}
+	if got := string(rai.Get(dhcpv4.AgentRemoteIDSubOption)); got != "router1" {
+		t.Errorf("remote-id = %q, want router1", got)
+	}
+	if discover.GatewayIPAddr != nil && !discover.GatewayIPAddr.IsUnspecified() {
+		t.Error("relayRequest modified the original packet")
+	}
+
+	// A client cannot insert option 82 itself
+	spoofed, _ := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("vlan1")))))
+	if _, err := r.relayRequest(spoofed); err == nil {
+		t.Error("expected option 82 from a client to be rejected")
+	}
+
+	// Requests from a downstream relay keep its giaddr
+	downstream, _ := dhcpv4.NewDiscovery(mac, dhcpv4.WithGatewayIP(net.ParseIP("10.9.9.1")))
+	downstream.HopCount = 2
+	fwd2, err := r.relayRequest(downstream)
+	if err != nil {
+		t.Fatalf("relayRequest failed: %v", err)
+	}
+	if !fwd2.GatewayIPAddr.Equal(net.ParseIP("10.9.9.1")) || fwd2.HopCount != 3 {
+		t.Errorf("downstream relay: giaddr %s hops %d", fwd2.GatewayIPAddr, fwd2.HopCount)
+	}
+
+	looping, _ := dhcpv4.NewDiscovery(mac)
+	looping.HopCount = maxRelayHops
+	if _, err := r.relayRequest(looping); err == nil {
+		t.Error("expected hop limit to be enforced")
+	}
+
+	// The server's offer comes back to giaddr with option 82 echoed
+	offer, err := dhcpv4.NewReplyFromRequest(fwd,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.ParseIP("192.168.20.50")))
+	if err != nil {
+		t.Fatalf("NewReplyFromRequest failed: %v", err)
+	}
+	offer.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionRelayAgentInformation, Value: rai})
+
+	dest, err := r.relayReply(offer)
+	if err != nil {
+		t.Fatalf("relayReply failed: %v", err)
+	}
+	if !dest.IP.Equal(net.IPv4bcast) || dest.Port != dhcpv4.ClientPort {
+		t.Errorf("offer sent to %s, want broadcast", dest)
+	}
+	if offer.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+		t.Error("option 82 not stripped from the reply")
+	}
+
+	// Renewing clients are answered by unicast
+	renew, _ := dhcpv4.NewReplyFromRequest(fwd,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithClientIP(net.ParseIP("192.168.20.50")))
+	dest, err = r.relayReply(renew)
+	if err != nil {
+		t.Fatalf("relayReply failed: %v", err)
+	}
+	if !dest.IP.Equal(net.ParseIP("192.168.20.50")) {
+		t.Errorf("ack sent to %s, want the client address", dest)
+	}
+
+	// Replies for another relay or circuit are dropped
+	other, _ := dhcpv4.NewReplyFromRequest(fwd, dhcpv4.WithGatewayIP(net.ParseIP("10.9.9.1")))
+	if _, err := r.relayReply(other); err == nil {
+		t.Error("expected reply for another giaddr to be dropped")
+	}
+	wrongCircuit, _ := dhcpv4.NewReplyFromRequest(fwd)
+	wrongCircuit.UpdateOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("vlan30"))))
+	if _, err := r.relayReply(wrongCircuit); err == nil {
+		t.Error("expected reply for another circuit to be dropped")
+	}
+}
+
+func TestRelayAgent_DHCPv6(t *testing.T) {
+	r := newTestRelay(t, config.DHCPRelay{Interface: "eth1.20", ServersV6: []string{"2001:db8::5"}})
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	peer := net.ParseIP("fe80::211:22ff:fe33:4455")
+
+	sol, err := dhcpv6.NewSolicit(mac)
+	if err != nil {
+		t.Fatalf("NewSolicit failed: %v", err)
+	}
+	fwd, err := r.relayRequestV6(sol, peer)
+	if err != nil {
+		t.Fatalf("relayRequestV6 failed: %v", err)
+	}
+	if fwd.Type() != dhcpv6.MessageTypeRelayForward {
+		t.Fatalf("got %s, want Relay-Forw", fwd.Type())
+	}
+	if !fwd.LinkAddr.Equal(r.linkAddr) || !fwd.PeerAddr.Equal(peer) {
+		t.Errorf("link %s peer %s", fwd.LinkAddr, fwd.PeerAddr)
+	}
+	if got := string(fwd.Options.InterfaceID()); got != "eth1.20" {
+		t.Errorf("interface-id = %q, want eth1.20", got)
+	}
+
+	// Server messages are never relayed towards the servers
+	adv, _ := dhcpv6.NewAdvertiseFromSolicit(sol)
+	if _, err := r.relayRequestV6(adv, peer); err == nil {
+		t.Error("expected Advertise from the client side to be dropped")
+	}
+
+	// The server answers with a Relay-Repl wrapping the Advertise
+	repl, err := dhcpv6.NewRelayReplFromRelayForw(fwd, adv)
+	if err != nil {
+		t.Fatalf("NewRelayReplFromRelayForw failed: %v", err)
+	}
+	inner, dest, err := r.relayReplyV6(repl)
+	if err != nil {
+		t.Fatalf("relayReplyV6 failed: %v", err)
+	}
+	if inner.Type() != dhcpv6.MessageTypeAdvertise {
+		t.Errorf("unwrapped %s, want Advertise", inner.Type())
+	}
+	if !dest.IP.Equal(peer) || dest.Port != dhcpv6.DefaultClientPort || dest.Zone != "eth1.20" {
+		t.Errorf("reply sent to %s", dest)
+	}
+
+	// Relay-Repl for another interface is dropped
+	other, _ := dhcpv6.EncapsulateRelay(adv, dhcpv6.MessageTypeRelayReply, r.linkAddr, peer)
+	other.AddOption(dhcpv6.OptInterfaceID([]byte("eth1.30")))
+	if _, _, err := r.relayReplyV6(other); err == nil {
+		t.Error("expected Relay-Repl for another interface to be dropped")
+	}
+}
@@ -1,10 +1,13 @@
 package dhcp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -51,6 +54,8 @@ type Service struct {
 	mu             sync.RWMutex
 	servers        []*dhcpInstance
 	leaseStores    []*LeaseStore // Track stores for expiration reaper
+	servers6       []*dhcpv6Instance
+	leaseStoresV6  []*LeaseStoreV6
 	dnsUpdater     DNSUpdater
 	leaseListener  LeaseListener
 	packetListener PacketListener // Passive sniffing listener
@@ -58,6 +63,9 @@ type Service struct {
 	running        bool
 	stopReaper     chan struct{} // Signal to stop expiration reaper
 	upgradeMgr     *upgrade.Manager
+	failover       *failover              // Pool sharing with an HA partner
+	failoverPeer   FailoverPeer           // Source of this node's HA role
+	dispatcher     NotificationDispatcher // Address conflict notifications
 }
 
 // SetUpgradeManager sets the upgrade manager for socket handoff.
@@ -67,6 +75,23 @@ func (s *Service) SetUpgradeManager(mgr *upgrade.Manager) {
 	s.upgradeMgr = mgr
 }
 
+// SetFailoverPeer sets the source of this node's HA role for DHCP failover
+func (s *Service) SetFailoverPeer(peer FailoverPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failoverPeer = peer
+	if s.failover != nil {
+		s.failover.setPeer(peer)
+	}
+}
+
+// SetDispatcher sets the notification dispatcher for address conflicts
+func (s *Service) SetDispatcher(d NotificationDispatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dispatcher = d
+}
+
 // SetLeaseListener sets the lease listener
 func (s *Service) SetLeaseListener(l LeaseListener) {
 	s.mu.Lock()
@@ -109,6 +134,9 @@ func (s *Service) Start(ctx context.Context) error {
 			s.serveDHCP(inst.conn, inst.handler)
 		}(srv)
 	}
+	for _, srv := range s.servers6 {
+		go s.serveDHCPv6(srv.conn, srv.handler)
+	}
 	s.running = true
 	return nil
 }
@@ -129,6 +157,11 @@ func (s *Service) Stop(ctx context.Context) error {
 			log.Printf("[DHCP] Failed to stop server: %v", err)
 		}
 	}
+	for _, srv := range s.servers6 {
+		if err := srv.conn.Close(); err != nil {
+			log.Printf("[DHCPv6] Failed to stop server: %v", err)
+		}
+	}
 	s.running = false
 	return nil
 }
@@ -149,10 +182,16 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 		for _, srv := range s.servers {
 			srv.conn.Close()
 		}
+		for _, srv := range s.servers6 {
+			srv.conn.Close()
+		}
 		s.running = false
 	}
 	s.servers = nil     // Clear old servers
 	s.leaseStores = nil // Clear old lease stores
+	s.servers6 = nil
+	s.leaseStoresV6 = nil
+	s.failover = nil
 
 	if cfg.DHCP == nil || !cfg.DHCP.Enabled {
 		return true, nil
@@ -164,14 +203,45 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 		return true, nil
 	}
 
+	// Share the pools with the HA partner
+	if s.failover = newFailover(cfg.DHCP.Failover); s.failover != nil {
+		s.failover.setPeer(s.failoverPeer)
+	}
+
 	// Parse scopes (only if built-in)
+	portalIfaces := config.CaptivePortalInterfaces(cfg.Zones, cfg.Interfaces)
 	for _, scope := range cfg.DHCP.Scopes {
+		if slices.Contains(portalIfaces, scope.Interface) {
+			scope = withCaptivePortal(scope, cfg.CaptivePortal)
+		}
 		srv, ls, err := s.createServer(scope)
 		if err != nil {
 			return true, fmt.Errorf("failed to create DHCP server for scope %s: %w", scope.Name, err)
 		}
+		s.configureConflictDetection(ls, cfg.DHCP, scope.Name)
 		s.servers = append(s.servers, srv)
 		s.leaseStores = append(s.leaseStores, ls)
+
+		// DHCPv6 serves addresses from the v6 range, or only options
+		// (stateless) when just dns_v6 is set
+		if scope.RangeStartV6 != "" || len(scope.DNSServersV6) > 0 {
+			srv6, ls6, err := s.createServerV6(scope)
+			if err != nil {
+				return true, fmt.Errorf("failed to create DHCPv6 server for scope %s: %w", scope.Name, err)
+			}
+			s.servers6 = append(s.servers6, srv6)
+			s.leaseStoresV6 = append(s.leaseStoresV6, ls6)
+		}
+	}
+
+	// Relay interfaces without a local scope to central servers
+	for _, relay := range cfg.DHCP.Relays {
+		relays, relays6, err := s.createRelay(relay)
+		if err != nil {
+			return true, fmt.Errorf("failed to create DHCP relay on %s: %w", relay.Interface, err)
+		}
+		s.servers = append(s.servers, relays...)
+		s.servers6 = append(s.servers6, relays6...)
 	}
 
 	// Restart servers
@@ -181,6 +251,9 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 			s.serveDHCP(inst.conn, inst.handler)
 		}(srv)
 	}
+	for _, srv := range s.servers6 {
+		go s.serveDHCPv6(srv.conn, srv.handler)
+	}
 
 	// Start expiration reaper
 	s.stopReaper = make(chan struct{})
@@ -191,6 +264,29 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 	return true, nil
 }
 
+// withCaptivePortal advertises the captive portal API (RFC 8910 option 114)
+// to clients of a guest scope, unless the scope sets the option itself.
+// Without portal TLS there is no API to advertise.
+func withCaptivePortal(scope config.DHCPScope, portal *config.CaptivePortalConfig) config.DHCPScope {
+	apiURL := portal.APIURL(scope.Router)
+	if scope.Router == "" || apiURL == "" {
+		return scope
+	}
+	for key := range scope.Options {
+		switch strings.ToLower(strings.ReplaceAll(key, "-", "_")) {
+		case "captive_portal", "captive_portal_url", "114":
+			return scope
+		}
+	}
+	options := make(map[string]string, len(scope.Options)+1)
+	for k, v := range scope.Options {
+		options[k] = v
+	}
+	options["captive_portal"] = apiURL
+	scope.Options = options
+	return scope
+}
+
 // runExpirationReaper periodically checks for and removes expired leases
 func (s *Service) runExpirationReaper(stop <-chan struct{}) {
 	ticker := time.NewTicker(1 * time.Minute)
@@ -213,6 +309,7 @@ func (s *Service) runExpirationReaper(stop <-chan struct{}) {
 func (s *Service) expireLeases() {
 	s.mu.RLock()
 	stores := s.leaseStores
+	stores6 := s.leaseStoresV6
 	dnsUpdater := s.dnsUpdater
 	listener := s.leaseListener
 	s.mu.RUnlock()
@@ -228,6 +325,9 @@ func (s *Service) expireLeases() {
 	for _, store := range stores {
 		totalExpired += store.ExpireLeases(dnsUpdater, expListener)
 	}
+	for _, store := range stores6 {
+		totalExpired += store.ExpireLeases(dnsUpdater, expListener)
+	}
 
 	if totalExpired > 0 {
 		log.Printf("[DHCP] Expired %d leases", totalExpired)
@@ -265,8 +365,19 @@ type LeaseStore struct {
 	ReservedIPs  map[string]string                 // IP (string) -> MAC
 	RangeStart   net.IP
 	RangeEnd     net.IP
+	Interface    string
 	bucket       *state.DHCPBucket // Persistent storage
 
+	// Failover support
+	failover *failover // nil unless failover is configured
+
+	// Conflict detection
+	probe          prober               // Finds hosts using an address; nil disables conflict detection
+	onConflict     func(message string) // Reports a squatter to the operator
+	quarantineTime time.Duration        // How long conflicting addresses are withheld
+	quarantined    map[string]time.Time // IP -> end of quarantine
+	probing        map[string]string    // IP -> MAC it is being probed for
+
 	// Expiration support
 	clock       clock.Clock          // Injectable clock for testing
 	leaseTime   time.Duration        // Default lease duration
@@ -274,7 +385,43 @@ type LeaseStore struct {
 	leaseExpiry map[string]time.Time // MAC -> expiration time
 }
 
+// Allocate returns the address for mac, leasing it a free one if it has
+// none. Free addresses are probed first when probing is enabled; the probe
+// runs without the store lock, as an unanswered one takes arpProbeTimeout.
 func (s *LeaseStore) Allocate(mac string) (net.IP, error) {
+	client, _ := net.ParseMAC(mac)
+	for {
+		ip, probe, report, err := s.allocate(mac)
+		if err != nil || probe == nil {
+			return ip, err
+		}
+		claimant := probe(s.Interface, ip, client)
+
+		s.Lock()
+		delete(s.probing, ip.String())
+		if claimant != nil {
+			s.conflict(ip, claimant, report)
+			s.Unlock()
+			continue
+		}
+		// A retransmitted request may have got an address meanwhile
+		if leased, ok := s.Leases[mac]; ok {
+			s.Unlock()
+			return leased, nil
+		}
+		err = s.lease(mac, ip)
+		s.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return ip, nil
+	}
+}
+
+// allocate returns the address for mac, or picks a free one. A picked
+// address is leased at once unless it must be probed first, in which case
+// it is returned with the probe to run and held until Allocate finishes.
+func (s *LeaseStore) allocate(mac string) (net.IP, prober, bool, error) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -283,13 +430,24 @@ func (s *LeaseStore) Allocate(mac string) (net.IP, error) {
 		// Parse IP from reservation
 		ip := net.ParseIP(res.IP).To4()
 		if ip != nil {
-			return ip, nil
+			return ip, nil, false, nil
 		}
 	}
 
 	// 2. Check existing dynamic lease
 	if ip, ok := s.Leases[mac]; ok {
-		return ip, nil
+		return ip, nil, false, nil
+	}
+
+	// With failover, the partner may have granted a lease and replicated it
+	var st failoverState
+	var held map[string]string
+	if s.failover != nil {
+		if ip := s.adoptReplicated(mac); ip != nil {
+			return ip, nil, false, nil
+		}
+		st = s.failover.state()
+		held = s.replicatedLeases()
 	}
 
 	// 3. Allocate new dynamic IP (Naive linear scan)
@@ -302,47 +460,151 @@ func (s *LeaseStore) Allocate(mac string) (net.IP, error) {
 		}
 
 		// Skip if currently leased
-		if !s.isTaken(ip) {
-			newIP := make(net.IP, len(ip))
-			copy(newIP, ip)
-
-			// Persist first
-			if err := s.persistLease(mac, newIP, "hostname-unknown"); err != nil {
-				log.Printf("[DHCP] Failed to persist lease: %v", err)
-				// Continue anyway or fail? Fail to ensure safety.
-				return nil, fmt.Errorf("failed to persist lease: %w", err)
-			}
-
-			s.Leases[mac] = newIP
-			s.TakenIPs[newIP.String()] = mac // Maintain reverse lookup
-			s.setLeaseExpiry(mac)
-			return newIP, nil
+		if !s.isTaken(ip) && s.failoverAllows(st, held, mac, ip) {
+			return s.take(mac, ip)
 		}
 	}
 
 	// Check the last one (RangeEnd)
-	if _, reserved := s.ReservedIPs[s.RangeEnd.String()]; !reserved && !s.isTaken(s.RangeEnd) {
-		newIP := make(net.IP, len(s.RangeEnd))
-		copy(newIP, s.RangeEnd)
+	if _, reserved := s.ReservedIPs[s.RangeEnd.String()]; !reserved && !s.isTaken(s.RangeEnd) && s.failoverAllows(st, held, mac, s.RangeEnd) {
+		return s.take(mac, s.RangeEnd)
+	}
+
+	return nil, nil, false, fmt.Errorf("no IPs available")
+}
 
-		// Persist
-		if err := s.persistLease(mac, newIP, "hostname-unknown"); err != nil {
-			return nil, fmt.Errorf("failed to persist lease: %w", err)
+// take leases a free address to mac, or holds it for probing.
+// Called while holding the store lock.
+func (s *LeaseStore) take(mac string, ip net.IP) (net.IP, prober, bool, error) {
+	newIP := make(net.IP, len(ip))
+	copy(newIP, ip)
+
+	if probe, report := s.prober(); probe != nil {
+		if s.probing == nil {
+			s.probing = make(map[string]string)
 		}
+		s.probing[newIP.String()] = mac
+		return newIP, probe, report, nil
+	}
+	if err := s.lease(mac, newIP); err != nil {
+		return nil, nil, false, err
+	}
+	return newIP, nil, false, nil
+}
 
-		s.Leases[mac] = newIP
-		s.TakenIPs[newIP.String()] = mac // Maintain reverse lookup
-		s.setLeaseExpiry(mac)
-		return newIP, nil
+// lease records a new dynamic lease. Called while holding the store lock.
+func (s *LeaseStore) lease(mac string, ip net.IP) error {
+	// Persist first
+	if err := s.persistLease(mac, ip, "hostname-unknown"); err != nil {
+		log.Printf("[DHCP] Failed to persist lease: %v", err)
+		// Fail to ensure safety
+		return fmt.Errorf("failed to persist lease: %w", err)
 	}
 
-	return nil, fmt.Errorf("no IPs available")
+	s.Leases[mac] = ip
+	s.TakenIPs[ip.String()] = mac // Maintain reverse lookup
+	s.setLeaseExpiry(mac)
+	return nil
 }
 
 func (s *LeaseStore) isTaken(ip net.IP) bool {
 	// O(1) lookup using TakenIPs reverse map
-	_, exists := s.TakenIPs[ip.String()]
-	return exists
+	if _, exists := s.TakenIPs[ip.String()]; exists {
+		return true
+	}
+	if _, probing := s.probing[ip.String()]; probing {
+		return true
+	}
+	if until, ok := s.quarantined[ip.String()]; ok {
+		if s.getNow().Before(until) {
+			return true
+		}
+		delete(s.quarantined, ip.String())
+	}
+	return false
+}
+
+// inRange reports whether ip lies in the scope's pool.
+func (s *LeaseStore) inRange(ip net.IP) bool {
+	ip = ip.To4()
+	return ip != nil && bytes.Compare(ip, s.RangeStart.To4()) >= 0 && bytes.Compare(ip, s.RangeEnd.To4()) <= 0
+}
+
+// holds reports whether mac has a lease on ip from this node.
+func (s *LeaseStore) holds(mac string, ip net.IP) bool {
+	s.Lock()
+	defer s.Unlock()
+	leased, ok := s.Leases[mac]
+	return ok && leased.Equal(ip)
+}
+
+// adoptReplicated takes over an unexpired lease on this pool that the
+// failover partner granted to mac, so the client keeps its address when
+// it renews here. Replicated entries carry no TTL, hence the LeaseEnd check.
+func (s *LeaseStore) adoptReplicated(mac string) net.IP {
+	if s.bucket == nil {
+		return nil
+	}
+	lease, err := s.bucket.Get(mac)
+	if err != nil || !s.getNow().Before(lease.LeaseEnd) {
+		return nil
+	}
+	ip := net.ParseIP(lease.IP).To4()
+	if ip == nil || !s.inRange(ip) {
+		return nil
+	}
+	if owner, ok := s.TakenIPs[ip.String()]; ok && owner != mac {
+		return nil
+	}
+	if owner, ok := s.ReservedIPs[ip.String()]; ok && owner != mac {
+		return nil
+	}
+
+	s.Leases[mac] = ip
+	s.TakenIPs[ip.String()] = mac
+	if s.leaseExpiry == nil {
+		s.leaseExpiry = make(map[string]time.Time)
+	}
+	s.leaseExpiry[mac] = lease.LeaseEnd
+	log.Printf("[DHCP] Adopted replicated lease for %s: %s", mac, ip)
+	return ip
+}
+
+// replicatedLeases returns the unexpired IPv4 leases in the state store,
+// including those replicated from the failover partner, as IP -> MAC.
+func (s *LeaseStore) replicatedLeases() map[string]string {
+	held := make(map[string]string)
+	if s.bucket == nil {
+		return held
+	}
+	leases, err := s.bucket.List()
+	if err != nil {
+		log.Printf("[DHCP] Warning: Failed to list replicated leases: %v", err)
+		return held
+	}
+	now := s.getNow()
+	for _, l := range leases {
+		if ip := net.ParseIP(l.IP).To4(); ip != nil && now.Before(l.LeaseEnd) {
+			held[ip.String()] = strings.ToLower(l.MAC)
+		}
+	}
+	return held
+}
+
+// failoverAllows reports whether ip may be newly allocated to mac under
+// failover: it is in this node's share of the pool and not leased to
+// another client by the partner.
+func (s *LeaseStore) failoverAllows(st failoverState, held map[string]string, mac string, ip net.IP) bool {
+	if s.failover == nil {
+		return true
+	}
+	if !s.failover.owns(st, s.RangeStart, s.RangeEnd, ip) {
+		return false
+	}
+	if owner, ok := held[ip.String()]; ok && owner != strings.ToLower(mac) {
+		return false
+	}
+	return true
 }
 
 func incIP(ip net.IP) net.IP {
@@ -502,6 +764,8 @@ func (s *Service) createServer(scope config.DHCPScope) (*dhcpInstance, *LeaseSto
 		ReservedIPs:  make(map[string]string),
 		RangeStart:   startIP,
 		RangeEnd:     endIP,
+		Interface:    scope.Interface,
+		failover:     s.failover,
 	}
 
 	// Initialize bucket and load existing leases
@@ -552,6 +816,15 @@ func (s *Service) createServer(scope config.DHCPScope) (*dhcpInstance, *LeaseSto
 			go pl(m, scope.Interface, peer)
 		}
 
+		// With failover, only one node of the pair answers each client
+		var st failoverState
+		if ls.failover != nil {
+			st = ls.failover.state()
+			if !ls.failover.answers(st, m, ls.holds) {
+				return
+			}
+		}
+
 		// Helper to determine destination address
 		// If peer is 0.0.0.0 (DHCP Discover), we MUST reply to Broadcast.
 		dest := peer
@@ -567,23 +840,35 @@ func (s *Service) createServer(scope config.DHCPScope) (*dhcpInstance, *LeaseSto
 
 		switch m.MessageType() {
 		case dhcpv4.MessageTypeDiscover:
-			offer, err := handleDiscover(m, ls, scope, routerIP)
-			if err != nil {
-				log.Printf("[DHCP] Discover error: %v", err)
-				return
-			}
-			if _, err := conn.WriteTo(offer.ToBytes(), dest); err != nil {
-				log.Printf("[DHCP] WriteOffer error: %v (dest=%v)", err, dest)
-			}
+			// An offer may wait on an address probe; don't hold up other clients
+			go func() {
+				offer, err := handleDiscover(m, ls, scope, routerIP)
+				if err != nil {
+					log.Printf("[DHCP] Discover error: %v", err)
+					return
+				}
+				if ls.failover != nil {
+					ls.failover.capLeaseTime(st, offer)
+				}
+				if _, err := conn.WriteTo(offer.ToBytes(), dest); err != nil {
+					log.Printf("[DHCP] WriteOffer error: %v (dest=%v)", err, dest)
+				}
+			}()
 		case dhcpv4.MessageTypeRequest:
 			ack, err := handleRequest(m, ls, scope, routerIP, s.dnsUpdater, s.leaseListener)
 			if err != nil {
 				log.Printf("[DHCP] Request error: %v", err)
 				return
 			}
+			if ls.failover != nil {
+				ls.failover.capLeaseTime(st, ack)
+			}
 			if _, err := conn.WriteTo(ack.ToBytes(), dest); err != nil {
 				log.Printf("[DHCP] WriteAck error: %v (dest=%v)", err, dest)
 			}
+		case dhcpv4.MessageTypeDecline:
+			// The client found the address in use; no reply is sent
+			ls.Decline(m.ClientHWAddr.String(), m.RequestedIPAddress())
 		}
 	}
 
@@ -690,31 +975,11 @@ func handleDiscover(m *dhcpv4.DHCPv4, store *LeaseStore, scope config.DHCPScope,
 		dhcpv4.WithLeaseTime(uint32(24 * time.Hour.Seconds())),
 	}
 
-	// Add scope custom options
-	for k, v := range scope.Options {
-		opt, err := parseOption(k, v)
-		if err != nil {
-			log.Printf("[DHCP] Warning: failed to parse option %s=%s: %v", k, v, err)
-			continue
-		}
-		opts = append(opts, dhcpv4.WithOption(opt))
-	}
-
-	// Add per-host custom options
+	// Add scope, class and per-host custom options
 	store.Lock()
 	res, hasRes := store.Reservations[mac]
 	store.Unlock()
-
-	if hasRes {
-		for k, v := range res.Options {
-			opt, err := parseOption(k, v)
-			if err != nil {
-				log.Printf("[DHCP] Warning: failed to parse host option %s=%s: %v", k, v, err)
-				continue
-			}
-			opts = append(opts, dhcpv4.WithOption(opt))
-		}
-	}
+	opts = append(opts, clientOptions(m, scope, res, hasRes)...)
 
 	return dhcpv4.NewReplyFromRequest(m, opts...)
 }
@@ -774,27 +1039,8 @@ func handleRequest(m *dhcpv4.DHCPv4, store *LeaseStore, scope config.DHCPScope,
 		dhcpv4.WithLeaseTime(uint32(24 * time.Hour.Seconds())),
 	}
 
-	// Add scope custom options
-	for k, v := range scope.Options {
-		opt, err := parseOption(k, v)
-		if err != nil {
-			log.Printf("[DHCP] Warning: failed to parse option %s=%s: %v", k, v, err)
-			continue
-		}
-		opts = append(opts, dhcpv4.WithOption(opt))
-	}
-
-	// Add per-host custom options
-	if hasRes {
-		for k, v := range res.Options {
-			opt, err := parseOption(k, v)
-			if err != nil {
-				log.Printf("[DHCP] Warning: failed to parse host option %s=%s: %v", k, v, err)
-				continue
-			}
-			opts = append(opts, dhcpv4.WithOption(opt))
-		}
-	}
+	// Add scope, class and per-host custom options
+	opts = append(opts, clientOptions(m, scope, res, hasRes)...)
 
 	// Trigger listener
 	if listener != nil {
@@ -810,12 +1056,14 @@ type Lease struct {
 	IP         net.IP
 	Hostname   string
 	Expiration time.Time
+	DUID       string // DHCPv6 client DUID (hex)
 }
 
 // GetLeases returns all active leases across all scopes
 func (s *Service) GetLeases() []Lease {
 	s.mu.RLock()
 	stores := s.leaseStores
+	stores6 := s.leaseStoresV6
 	s.mu.RUnlock()
 
 	var leases []Lease
@@ -841,5 +1089,19 @@ func (s *Service) GetLeases() []Lease {
 		}
 		store.Unlock()
 	}
+
+	for _, store := range stores6 {
+		store.Lock()
+		for duid, ip := range store.Leases {
+			leases = append(leases, Lease{
+				MAC:        store.macs[duid],
+				IP:         ip,
+				Hostname:   store.hostnames[duid],
+				Expiration: store.leaseExpiry[duid],
+				DUID:       duid,
+			})
+		}
+		store.Unlock()
+	}
 	return leases
 }
@@ -0,0 +1,346 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/poly1305"
+)
+
+// DNSCrypt v2 wire format, see https://dnscrypt.info/protocol
+
+const (
+	esXSalsa20Poly1305  = 1
+	esXChacha20Poly1305 = 2
+
+	dnscryptCertSize   = 124
+	dnscryptMagicLen   = 8
+	dnscryptHalfNonce  = 12
+	dnscryptNonceSize  = 24
+	dnscryptTagSize    = 16
+	dnscryptQueryHdr   = dnscryptMagicLen + 32 + dnscryptHalfNonce
+	dnscryptRespHdr    = dnscryptMagicLen + dnscryptNonceSize
+	dnscryptMinQuery   = 256
+	dnscryptPadBlock   = 64
+	dnscryptStampProto = 0x01
+)
+
+var (
+	dnscryptCertMagic     = []byte{'D', 'N', 'S', 'C'}
+	dnscryptResolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+
+	errDNSCryptCert    = errors.New("invalid dnscrypt certificate")
+	errDNSCryptDecrypt = errors.New("dnscrypt decryption failed")
+	errDNSCryptPadding = errors.New("invalid dnscrypt padding")
+)
+
+// dnscryptCert is a resolver certificate, published as a TXT record
+// under the provider name and signed with the provider's Ed25519 key.
+type dnscryptCert struct {
+	esVersion   uint16
+	signature   [64]byte
+	resolverPK  [32]byte
+	clientMagic [8]byte
+	serial      uint32
+	notBefore   uint32
+	notAfter    uint32
+}
+
+// newDNSCryptCert creates and signs a certificate for a resolver key.
+func newDNSCryptCert(es uint16, providerSK ed25519.PrivateKey, resolverPK [32]byte, serial uint32, notBefore, notAfter time.Time) *dnscryptCert {
+	c := &dnscryptCert{
+		esVersion:  es,
+		resolverPK: resolverPK,
+		serial:     serial,
+		notBefore:  uint32(notBefore.Unix()),
+		notAfter:   uint32(notAfter.Unix()),
+	}
+	copy(c.clientMagic[:], resolverPK[:dnscryptMagicLen])
+	copy(c.signature[:], ed25519.Sign(providerSK, c.signedBytes()))
+	return c
+}
+
+func (c *dnscryptCert) signedBytes() []byte {
+	b := make([]byte, 0, 52)
+	b = append(b, c.resolverPK[:]...)
+	b = append(b, c.clientMagic[:]...)
+	b = binary.BigEndian.AppendUint32(b, c.serial)
+	b = binary.BigEndian.AppendUint32(b, c.notBefore)
+	b = binary.BigEndian.AppendUint32(b, c.notAfter)
+	return b
+}
+
+func (c *dnscryptCert) marshal() []byte {
+	b := make([]byte, 0, dnscryptCertSize)
+	b = append(b, dnscryptCertMagic...)
+	b = binary.BigEndian.AppendUint16(b, c.esVersion)
+	b = binary.BigEndian.AppendUint16(b, 0) // protocol minor version
+	b = append(b, c.signature[:]...)
+	return append(b, c.signedBytes()...)
+}
+
+// validAt reports whether now falls within the certificate's validity window.
+func (c *dnscryptCert) validAt(now time.Time) bool {
+	ts := uint32(now.Unix())
+	return ts >= c.notBefore && ts <= c.notAfter
+}
+
+// parseDNSCryptCert decodes a certificate and verifies its signature.
+func parseDNSCryptCert(b []byte, providerPK ed25519.PublicKey) (*dnscryptCert, error) {
+	if len(b) < dnscryptCertSize || !bytes.Equal(b[:4], dnscryptCertMagic) {
+		return nil, errDNSCryptCert
+	}
+	c := &dnscryptCert{esVersion: binary.BigEndian.Uint16(b[4:6])}
+	copy(c.signature[:], b[8:72])
+	copy(c.resolverPK[:], b[72:104])
+	copy(c.clientMagic[:], b[104:112])
+	c.serial = binary.BigEndian.Uint32(b[112:116])
+	c.notBefore = binary.BigEndian.Uint32(b[116:120])
+	c.notAfter = binary.BigEndian.Uint32(b[120:124])
+
+	if c.esVersion != esXSalsa20Poly1305 && c.esVersion != esXChacha20Poly1305 {
+		return nil, fmt.Errorf("%w: unsupported es-version %d", errDNSCryptCert, c.esVersion)
+	}
+	// Signature covers everything after it, including any extensions
+	if !ed25519.Verify(providerPK, b[72:], c.signature[:]) {
+		return nil, fmt.Errorf("%w: bad signature", errDNSCryptCert)
+	}
+	return c, nil
+}
+
+// dnscryptSharedKey derives the symmetric key for a client/resolver pair.
+func dnscryptSharedKey(es uint16, secretKey, publicKey *[32]byte) ([32]byte, error) {
+	var key [32]byte
+	switch es {
+	case esXSalsa20Poly1305:
+		box.Precompute(&key, publicKey, secretKey)
+		return key, nil
+	case esXChacha20Poly1305:
+		dh, err := curve25519.X25519(secretKey[:], publicKey[:])
+		if err != nil {
+			return key, err
+		}
+		sub, err := chacha20.HChaCha20(dh, make([]byte, 16))
+		if err != nil {
+			return key, err
+		}
+		copy(key[:], sub)
+		return key, nil
+	}
+	return key, fmt.Errorf("unsupported es-version %d", es)
+}
+
+// dnscryptSeal encrypts msg in NaCl secretbox layout (tag, then ciphertext).
+func dnscryptSeal(es uint16, key *[32]byte, nonce *[24]byte, msg []byte) []byte {
+	if es == esXSalsa20Poly1305 {
+		return box.SealAfterPrecomputation(nil, msg, nonce, key)
+	}
+	return xsecretboxSeal(key, nonce, msg)
+}
+
+// dnscryptOpen authenticates and decrypts a sealed box.
+func dnscryptOpen(es uint16, key *[32]byte, nonce *[24]byte, sealed []byte) ([]byte, error) {
+	if es == esXSalsa20Poly1305 {
+		out, ok := box.OpenAfterPrecomputation(nil, sealed, nonce, key)
+		if !ok {
+			return nil, errDNSCryptDecrypt
+		}
+		return out, nil
+	}
+	return xsecretboxOpen(key, nonce, sealed)
+}
+
+// xsecretboxSeal is the secretbox construction with XChaCha20 in place of
+// XSalsa20, as used by DNSCrypt es-version 2.
+func xsecretboxSeal(key *[32]byte, nonce *[24]byte, msg []byte) []byte {
+	out := make([]byte, dnscryptTagSize+len(msg))
+	ciphertext := out[dnscryptTagSize:]
+
+	polyKey := xchachaKeystream(key, nonce, ciphertext, msg)
+	var tag [16]byte
+	poly1305.Sum(&tag, ciphertext, &polyKey)
+	copy(out, tag[:])
+	return out
+}
+
+func xsecretboxOpen(key *[32]byte, nonce *[24]byte, sealed []byte) ([]byte, error) {
+	if len(sealed) < dnscryptTagSize {
+		return nil, errDNSCryptDecrypt
+	}
+	var tag [16]byte
+	copy(tag[:], sealed[:dnscryptTagSize])
+	ciphertext := sealed[dnscryptTagSize:]
+
+	// Derive the Poly1305 key without decrypting, then verify before use
+	var firstBlock [64]byte
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	c.XORKeyStream(firstBlock[:], firstBlock[:])
+	var polyKey [32]byte
+	copy(polyKey[:], firstBlock[:32])
+	if !poly1305.Verify(&tag, ciphertext, &polyKey) {
+		return nil, errDNSCryptDecrypt
+	}
+
+	out := make([]byte, len(ciphertext))
+	xchachaKeystream(key, nonce, out, ciphertext)
+	return out, nil
+}
+
+// xchachaKeystream XORs src into dst, using the first 32 bytes of block 0
+// as the Poly1305 key and the rest of the stream for data.
+func xchachaKeystream(key *[32]byte, nonce *[24]byte, dst, src []byte) [32]byte {
+	var firstBlock [64]byte
+	c, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	c.XORKeyStream(firstBlock[:], firstBlock[:])
+
+	var polyKey [32]byte
+	copy(polyKey[:], firstBlock[:32])
+
+	n := min(len(src), 32)
+	for i := 0; i < n; i++ {
+		dst[i] = firstBlock[32+i] ^ src[i]
+	}
+	c.SetCounter(1)
+	c.XORKeyStream(dst[n:], src[n:])
+	return polyKey
+}
+
+// dnscryptPad applies ISO/IEC 7816-4 padding up to a multiple of 64 bytes,
+// and at least minLen bytes.
+func dnscryptPad(msg []byte, minLen int) []byte {
+	size := max(len(msg)+1, minLen)
+	size = (size + dnscryptPadBlock - 1) / dnscryptPadBlock * dnscryptPadBlock
+	out := make([]byte, size)
+	copy(out, msg)
+	out[len(msg)] = 0x80
+	return out
+}
+
+func dnscryptUnpad(msg []byte) ([]byte, error) {
+	i := bytes.LastIndexByte(msg, 0x80)
+	if i < 0 {
+		return nil, errDNSCryptPadding
+	}
+	for _, b := range msg[i+1:] {
+		if b != 0 {
+			return nil, errDNSCryptPadding
+		}
+	}
+	return msg[:i], nil
+}
+
+// dnscryptStamp holds the fields of an sdns:// DNSCrypt stamp.
+type dnscryptStamp struct {
+	props        uint64
+	serverAddr   string
+	providerPK   ed25519.PublicKey
+	providerName string
+}
+
+// parseDNSCryptStamp decodes an sdns:// stamp of the DNSCrypt type.
+func parseDNSCryptStamp(stamp string) (*dnscryptStamp, error) {
+	raw, ok := strings.CutPrefix(stamp, "sdns://")
+	if !ok {
+		return nil, fmt.Errorf("stamp must start with sdns://")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stamp encoding: %w", err)
+	}
+	if len(b) < 9 || b[0] != dnscryptStampProto {
+		return nil, fmt.Errorf("not a DNSCrypt stamp")
+	}
+	st := &dnscryptStamp{props: binary.LittleEndian.Uint64(b[1:9])}
+	b = b[9:]
+
+	var fields [3][]byte
+	for i := range fields {
+		if len(b) == 0 || int(b[0]) > len(b)-1 {
+			return nil, fmt.Errorf("truncated stamp")
+		}
+		fields[i] = b[1 : 1+int(b[0])]
+		b = b[1+int(b[0]):]
+	}
+	if len(fields[1]) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid provider public key length %d", len(fields[1]))
+	}
+
+	st.serverAddr = string(fields[0])
+	if _, _, err := net.SplitHostPort(st.serverAddr); err != nil {
+		st.serverAddr = net.JoinHostPort(strings.Trim(st.serverAddr, "[]"), "443")
+	}
+	st.providerPK = ed25519.PublicKey(fields[1])
+	st.providerName = string(fields[2])
+	return st, nil
+}
+
+// String encodes the stamp as sdns://.
+func (st *dnscryptStamp) String() string {
+	b := []byte{dnscryptStampProto}
+	b = binary.LittleEndian.AppendUint64(b, st.props)
+	for _, f := range [][]byte{[]byte(st.serverAddr), st.providerPK, []byte(st.providerName)} {
+		b = append(b, byte(len(f)))
+		b = append(b, f...)
+	}
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// txtToBytes reverses the presentation escaping miekg/dns applies to TXT
+// strings (\DDD and \X), recovering binary certificate data.
+func txtToBytes(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			out = append(out, byte(n))
+			i += 3
+			continue
+		}
+		out = append(out, s[i+1])
+		i++
+	}
+	return out
+}
+
+// bytesToTXT escapes binary data for a miekg/dns TXT string.
+func bytesToTXT(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// constantTimeMagic compares client magic values without early exit.
+func constantTimeMagic(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
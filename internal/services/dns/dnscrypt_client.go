@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/curve25519"
+)
+
+// dnscryptCertRefresh bounds how long a fetched certificate is used before
+// the client checks for a newer one.
+const dnscryptCertRefresh = time.Hour
+
+// dnscryptClient is a DNSCrypt v2 upstream transport.
+type dnscryptClient struct {
+	serverAddr   string
+	providerName string
+	providerPK   ed25519.PublicKey
+	timeout      time.Duration
+
+	mu        sync.Mutex
+	cert      *dnscryptCert
+	refreshAt time.Time
+	publicKey [32]byte
+	sharedKey [32]byte
+}
+
+// newDNSCryptClient builds a client from a stamp or from explicit
+// provider name, server address and hex public key.
+func newDNSCryptClient(cfg config.DNSCryptUpstream) (*dnscryptClient, error) {
+	c := &dnscryptClient{timeout: 2 * time.Second}
+
+	if cfg.Stamp != "" {
+		st, err := parseDNSCryptStamp(cfg.Stamp)
+		if err != nil {
+			return nil, err
+		}
+		c.serverAddr, c.providerName, c.providerPK = st.serverAddr, st.providerName, st.providerPK
+	} else {
+		pk, err := hex.DecodeString(strings.ReplaceAll(cfg.PublicKey, ":", ""))
+		if err != nil || len(pk) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid provider public key")
+		}
+		if cfg.ProviderName == "" || cfg.ServerAddr == "" {
+			return nil, fmt.Errorf("provider_name and server_addr are required without a stamp")
+		}
+		c.serverAddr, c.providerName, c.providerPK = cfg.ServerAddr, cfg.ProviderName, pk
+		if _, _, err := net.SplitHostPort(c.serverAddr); err != nil {
+			c.serverAddr = net.JoinHostPort(c.serverAddr, "443")
+		}
+	}
+	c.providerName = dns.Fqdn(c.providerName)
+	return c, nil
+}
+
+// buildDNSCryptUpstreams converts enabled upstream_dnscrypt blocks.
+func buildDNSCryptUpstreams(list []config.DNSCryptUpstream) []upstream {
+	var upstreams []upstream
+	for _, cfg := range list {
+		if !cfg.Enabled {
+			continue
+		}
+		client, err := newDNSCryptClient(cfg)
+		if err != nil {
+			log.Printf("[DNS] Skipping DNSCrypt upstream %s: %v", cfg.Name, err)
+			continue
+		}
+		upstreams = append(upstreams, upstream{
+			Addr:     client.serverAddr,
+			Protocol: "dnscrypt",
+			DNSCrypt: client,
+		})
+	}
+	return upstreams
+}
+
+// Exchange sends an encrypted query, refetching the certificate once if
+// the resolver has rotated its keys.
+func (c *dnscryptClient) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	resp, err := c.exchange(r)
+	if err == errDNSCryptDecrypt {
+		c.invalidate()
+		resp, err = c.exchange(r)
+	}
+	return resp, err
+}
+
+func (c *dnscryptClient) exchange(r *dns.Msg) (*dns.Msg, error) {
+	cert, pk, key, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := r.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.roundTrip("udp", cert, pk, key, query)
+	if err == nil && resp.Truncated {
+		resp, err = c.roundTrip("tcp", cert, pk, key, query)
+	}
+	return resp, err
+}
+
+func (c *dnscryptClient) roundTrip(network string, cert *dnscryptCert, pk, key *[32]byte, query []byte) (*dns.Msg, error) {
+	var nonce [dnscryptNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:dnscryptHalfNonce]); err != nil {
+		return nil, err
+	}
+
+	minLen := dnscryptMinQuery
+	if network == "tcp" {
+		minLen = 0
+	}
+	packet := make([]byte, 0, dnscryptQueryHdr+dnscryptTagSize+len(query)+dnscryptPadBlock+minLen)
+	packet = append(packet, cert.clientMagic[:]...)
+	packet = append(packet, pk[:]...)
+	packet = append(packet, nonce[:dnscryptHalfNonce]...)
+	packet = append(packet, dnscryptSeal(cert.esVersion, key, &nonce, dnscryptPad(query, minLen))...)
+
+	conn, err := net.DialTimeout(network, c.serverAddr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	raw, err := writeReadPacket(conn, network, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < dnscryptRespHdr+dnscryptTagSize || !constantTimeMagic(raw[:dnscryptMagicLen], dnscryptResolverMagic) {
+		return nil, fmt.Errorf("unexpected dnscrypt response")
+	}
+	var respNonce [dnscryptNonceSize]byte
+	copy(respNonce[:], raw[dnscryptMagicLen:dnscryptRespHdr])
+	if !constantTimeMagic(respNonce[:dnscryptHalfNonce], nonce[:dnscryptHalfNonce]) {
+		return nil, fmt.Errorf("dnscrypt nonce mismatch")
+	}
+
+	plain, err := dnscryptOpen(cert.esVersion, key, &respNonce, raw[dnscryptRespHdr:])
+	if err != nil {
+		return nil, err
+	}
+	if plain, err = dnscryptUnpad(plain); err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(plain); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// writeReadPacket performs one request/response, using two-byte length
+// framing on TCP.
+func writeReadPacket(conn net.Conn, network string, packet []byte) ([]byte, error) {
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+		if _, err := conn.Write(append(framed, packet...)); err != nil {
+			return nil, err
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		_, err := io.ReadFull(conn, buf)
+		return buf, err
+	}
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dns.MaxMsgSize)
+	n, err := conn.Read(buf)
+	return buf[:n], err
+}
+
+// session returns the active certificate and keys, fetching a new
+// certificate when none is cached or the refresh interval has passed.
+func (c *dnscryptClient) session() (*dnscryptCert, *[32]byte, *[32]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clock.Now()
+	if c.cert == nil || !now.Before(c.refreshAt) || !c.cert.validAt(now) {
+		if err := c.fetchCertLocked(now); err != nil {
+			if c.cert == nil || !c.cert.validAt(now) {
+				return nil, nil, nil, err
+			}
+			log.Printf("[DNS] DNSCrypt certificate refresh for %s failed, keeping current: %v", c.providerName, err)
+		}
+	}
+	pk, key := c.publicKey, c.sharedKey
+	return c.cert, &pk, &key, nil
+}
+
+func (c *dnscryptClient) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshAt = time.Time{}
+}
+
+// fetchCertLocked queries the provider's TXT certificates and selects the
+// highest-serial valid one. A fresh client key pair is generated with
+// every certificate so rotations also rotate the client key.
+func (c *dnscryptClient) fetchCertLocked(now time.Time) error {
+	m := new(dns.Msg)
+	m.SetQuestion(c.providerName, dns.TypeTXT)
+	client := &dns.Client{Net: "udp", Timeout: c.timeout}
+	resp, _, err := client.Exchange(m, c.serverAddr)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.Exchange(m, c.serverAddr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch certificate: %w", err)
+	}
+
+	var best *dnscryptCert
+	for _, rr := range resp.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert(txtToBytes(strings.Join(txt.Txt, "")), c.providerPK)
+		if err != nil || !cert.validAt(now) {
+			continue
+		}
+		if best == nil || cert.serial > best.serial ||
+			(cert.serial == best.serial && cert.esVersion > best.esVersion) {
+			best = cert
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("no valid certificate for %s", c.providerName)
+	}
+
+	var sk [32]byte
+	if _, err := io.ReadFull(rand.Reader, sk[:]); err != nil {
+		return err
+	}
+	pub, err := curve25519.X25519(sk[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	copy(c.publicKey[:], pub)
+	if c.sharedKey, err = dnscryptSharedKey(best.esVersion, &sk, &best.resolverPK); err != nil {
+		return err
+	}
+
+	if c.cert == nil || c.cert.serial != best.serial {
+		log.Printf("[DNS] Using DNSCrypt certificate serial %d for %s", best.serial, c.providerName)
+	}
+	c.cert = best
+	c.refreshAt = now.Add(dnscryptCertRefresh)
+	if expiry := time.Unix(int64(best.notAfter), 0); expiry.Before(c.refreshAt) {
+		c.refreshAt = expiry
+	}
+	return nil
+}
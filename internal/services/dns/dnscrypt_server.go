@@ -0,0 +1,449 @@
+package dns
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	defaultDNSCryptPort    = "5443"
+	defaultDNSCryptCertTTL = 24 * time.Hour
+	dnscryptTCPTimeout     = 10 * time.Second
+)
+
+// dnscryptListener describes a DNSCrypt listener and its configuration.
+type dnscryptListener struct {
+	addr string
+	cfg  *config.DNSCryptServerConfig
+}
+
+// dnscryptListeners expands a dnscrypt_server block, binding to each zone
+// address on port 5443 when no listen_addr is given.
+func dnscryptListeners(cfg *config.DNSCryptServerConfig, zoneAddrs []string) []dnscryptListener {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	var out []dnscryptListener
+	for _, addr := range listenAddrsFor(cfg.ListenAddr, zoneAddrs, defaultDNSCryptPort) {
+		out = append(out, dnscryptListener{addr: addr, cfg: cfg})
+	}
+	return out
+}
+
+// resolverCert is a signed certificate and the X25519 secret key behind it.
+type resolverCert struct {
+	cert      *dnscryptCert
+	secretKey [32]byte
+}
+
+// dnscryptServer answers DNSCrypt v2 queries on UDP and TCP, passing the
+// decrypted messages through ServeDNS.
+type dnscryptServer struct {
+	svc          *Service
+	providerName string
+	providerSK   ed25519.PrivateKey
+	esVersion    uint16
+	certTTL      time.Duration
+	certFile     string
+
+	pc   net.PacketConn
+	ln   net.Listener
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu    sync.RWMutex
+	certs []*resolverCert
+}
+
+// buildDNSCryptServers loads provider keys and binds DNSCrypt listeners.
+func (s *Service) buildDNSCryptServers(listeners []dnscryptListener) []*dnscryptServer {
+	var out []*dnscryptServer
+	for _, l := range listeners {
+		srv, err := newDNSCryptServer(s, l.cfg)
+		if err != nil {
+			log.Printf("[DNS] DNSCrypt %s disabled: %v", l.addr, err)
+			continue
+		}
+		srv.pc = s.listenUDP("dns-dnscrypt-udp-"+l.addr, l.addr)
+		tcpAddr := l.addr
+		if srv.pc != nil {
+			// Share the UDP port when an ephemeral port was requested
+			tcpAddr = srv.pc.LocalAddr().String()
+		}
+		srv.ln = s.listenTCP("dns-dnscrypt-tcp-"+l.addr, tcpAddr)
+		if srv.pc == nil && srv.ln == nil {
+			continue
+		}
+		if srv.pc != nil {
+			stamp := &dnscryptStamp{
+				serverAddr:   srv.pc.LocalAddr().String(),
+				providerPK:   srv.providerSK.Public().(ed25519.PublicKey),
+				providerName: strings.TrimSuffix(srv.providerName, "."),
+			}
+			log.Printf("[DNS] DNSCrypt listening on %s, stamp %s", l.addr, stamp)
+		}
+		out = append(out, srv)
+	}
+	return out
+}
+
+func newDNSCryptServer(svc *Service, cfg *config.DNSCryptServerConfig) (*dnscryptServer, error) {
+	if cfg.ProviderName == "" {
+		return nil, fmt.Errorf("provider_name is required")
+	}
+	sk, err := loadOrCreateProviderKey(cfg.PublicKeyFile, cfg.SecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	es := uint16(esXChacha20Poly1305)
+	if cfg.ESVersion == esXSalsa20Poly1305 {
+		es = esXSalsa20Poly1305
+	}
+	ttl := defaultDNSCryptCertTTL
+	if cfg.CertTTL > 0 {
+		ttl = time.Duration(cfg.CertTTL) * time.Hour
+	}
+
+	srv := &dnscryptServer{
+		svc:          svc,
+		providerName: dns.Fqdn(cfg.ProviderName),
+		providerSK:   sk,
+		esVersion:    es,
+		certTTL:      ttl,
+		certFile:     cfg.CertFile,
+		stop:         make(chan struct{}),
+	}
+	if err := srv.rotate(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// loadOrCreateProviderKey reads the provider's Ed25519 key pair (raw or
+// hex encoded), generating and saving one if the files do not exist.
+func loadOrCreateProviderKey(publicFile, secretFile string) (ed25519.PrivateKey, error) {
+	if secretFile == "" {
+		return nil, fmt.Errorf("secret_key_file is required")
+	}
+
+	data, err := os.ReadFile(secretFile)
+	if os.IsNotExist(err) {
+		pub, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(secretFile, sk, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save provider secret key: %w", err)
+		}
+		if publicFile != "" {
+			if err := os.WriteFile(publicFile, pub, 0644); err != nil {
+				return nil, fmt.Errorf("failed to save provider public key: %w", err)
+			}
+		}
+		log.Printf("[DNS] Generated DNSCrypt provider key, public key %x", []byte(pub))
+		return sk, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := decodeKeyFile(data)
+	switch len(key) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	}
+	return nil, fmt.Errorf("invalid provider secret key length %d", len(key))
+}
+
+func decodeKeyFile(data []byte) []byte {
+	text := strings.ReplaceAll(strings.TrimSpace(string(data)), ":", "")
+	if decoded, err := hex.DecodeString(text); err == nil {
+		return decoded
+	}
+	return data
+}
+
+// rotate generates a new resolver key and certificate. The previous
+// certificates stay usable until they expire, so clients holding an older
+// certificate are not cut off mid-rotation.
+func (d *dnscryptServer) rotate() error {
+	var sk [32]byte
+	if _, err := io.ReadFull(rand.Reader, sk[:]); err != nil {
+		return err
+	}
+	pub, err := curve25519.X25519(sk[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	var pk [32]byte
+	copy(pk[:], pub)
+
+	now := clock.Now()
+	d.mu.Lock()
+	serial := uint32(now.Unix())
+	if len(d.certs) > 0 && d.certs[0].cert.serial >= serial {
+		serial = d.certs[0].cert.serial + 1
+	}
+	cert := newDNSCryptCert(d.esVersion, d.providerSK, pk, serial, now.Add(-time.Hour), now.Add(d.certTTL))
+	live := []*resolverCert{{cert: cert, secretKey: sk}}
+	for _, rc := range d.certs {
+		if rc.cert.validAt(now) {
+			live = append(live, rc)
+		}
+	}
+	d.certs = live
+	d.mu.Unlock()
+
+	if d.certFile != "" {
+		if err := os.WriteFile(d.certFile, cert.marshal(), 0644); err != nil {
+			log.Printf("[DNS] Failed to write DNSCrypt certificate %s: %v", d.certFile, err)
+		}
+	}
+	return nil
+}
+
+func (d *dnscryptServer) start() {
+	if d.pc != nil {
+		d.wg.Add(1)
+		go d.serveUDP()
+	}
+	if d.ln != nil {
+		d.wg.Add(1)
+		go d.serveTCP()
+	}
+	d.wg.Add(1)
+	go d.rotateLoop()
+}
+
+func (d *dnscryptServer) shutdown() {
+	close(d.stop)
+	if d.pc != nil {
+		d.pc.Close()
+	}
+	if d.ln != nil {
+		d.ln.Close()
+	}
+	d.wg.Wait()
+}
+
+func (d *dnscryptServer) rotateLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.certTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.rotate(); err != nil {
+				log.Printf("[DNS] DNSCrypt certificate rotation failed: %v", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *dnscryptServer) serveUDP() {
+	defer d.wg.Done()
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, addr, err := d.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			log.Printf("[DNS] DNSCrypt UDP read error: %v", err)
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := d.handle(packet, d.pc.LocalAddr(), addr, true); resp != nil {
+				d.pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (d *dnscryptServer) serveTCP() {
+	defer d.wg.Done()
+	for {
+		conn, err := d.ln.Accept()
+		if err != nil {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			log.Printf("[DNS] DNSCrypt TCP accept error: %v", err)
+			return
+		}
+		go d.serveConn(conn)
+	}
+}
+
+func (d *dnscryptServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(dnscryptTCPTimeout))
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+			return
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		resp := d.handle(packet, conn.LocalAddr(), conn.RemoteAddr(), false)
+		if resp == nil {
+			return
+		}
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle processes one packet: either a plain certificate request or an
+// encrypted query. It returns the bytes to send back, or nil to drop.
+func (d *dnscryptServer) handle(packet []byte, local, remote net.Addr, udp bool) []byte {
+	if rc := d.certForMagic(packet); rc != nil {
+		return d.handleEncrypted(rc, packet, local, remote, udp)
+	}
+	return d.handleCertQuery(packet)
+}
+
+func (d *dnscryptServer) certForMagic(packet []byte) *resolverCert {
+	if len(packet) < dnscryptQueryHdr+dnscryptTagSize {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, rc := range d.certs {
+		if constantTimeMagic(packet[:dnscryptMagicLen], rc.cert.clientMagic[:]) {
+			return rc
+		}
+	}
+	return nil
+}
+
+// handleCertQuery answers unencrypted TXT queries for the provider name
+// with the current certificates.
+func (d *dnscryptServer) handleCertQuery(packet []byte) []byte {
+	req := new(dns.Msg)
+	if err := req.Unpack(packet); err != nil || len(req.Question) != 1 {
+		return nil
+	}
+	q := req.Question[0]
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	if q.Qtype != dns.TypeTXT || !strings.EqualFold(q.Name, d.providerName) {
+		resp.Rcode = dns.RcodeRefused
+	} else {
+		now := clock.Now()
+		d.mu.RLock()
+		for _, rc := range d.certs {
+			if !rc.cert.validAt(now) {
+				continue
+			}
+			ttl := uint32(time.Unix(int64(rc.cert.notAfter), 0).Sub(now).Seconds())
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: min(ttl, 86400)},
+				Txt: []string{bytesToTXT(rc.cert.marshal())},
+			})
+		}
+		d.mu.RUnlock()
+	}
+	out, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (d *dnscryptServer) handleEncrypted(rc *resolverCert, packet []byte, local, remote net.Addr, udp bool) []byte {
+	var clientPK [32]byte
+	copy(clientPK[:], packet[dnscryptMagicLen:dnscryptMagicLen+32])
+	var nonce [dnscryptNonceSize]byte
+	copy(nonce[:], packet[dnscryptMagicLen+32:dnscryptQueryHdr])
+
+	key, err := dnscryptSharedKey(rc.cert.esVersion, &rc.secretKey, &clientPK)
+	if err != nil {
+		return nil
+	}
+	plain, err := dnscryptOpen(rc.cert.esVersion, &key, &nonce, packet[dnscryptQueryHdr:])
+	if err != nil {
+		return nil
+	}
+	if plain, err = dnscryptUnpad(plain); err != nil {
+		return nil
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(plain); err != nil {
+		return nil
+	}
+
+	rw := &captureWriter{local: local, remote: remote}
+	d.svc.ServeDNS(rw, req)
+	if rw.msg == nil {
+		return nil
+	}
+	reply, err := rw.msg.Pack()
+	if err != nil {
+		return nil
+	}
+
+	// A UDP response may not be larger than the query that triggered it
+	overhead := dnscryptRespHdr + dnscryptTagSize
+	if udp && overhead+len(dnscryptPad(reply, 0)) > len(packet) {
+		tc := new(dns.Msg)
+		tc.SetReply(req)
+		tc.Truncated = true
+		if reply, err = tc.Pack(); err != nil {
+			return nil
+		}
+	}
+
+	if _, err := io.ReadFull(rand.Reader, nonce[dnscryptHalfNonce:]); err != nil {
+		return nil
+	}
+	out := make([]byte, 0, overhead+len(reply)+dnscryptPadBlock)
+	out = append(out, dnscryptResolverMagic...)
+	out = append(out, nonce[:]...)
+	return append(out, dnscryptSeal(rc.cert.esVersion, &key, &nonce, dnscryptPad(reply, 0))...)
+}
+
+func startDNSCrypt(servers []*dnscryptServer) {
+	for _, d := range servers {
+		d.start()
+	}
+}
+
+func stopDNSCrypt(servers []*dnscryptServer) {
+	for _, d := range servers {
+		d.shutdown()
+	}
+}
@@ -0,0 +1,265 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestDNSCryptStamp_RoundTrip(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	st := &dnscryptStamp{props: 1, serverAddr: "192.0.2.1:5443", providerPK: pub, providerName: "2.dnscrypt-cert.example.com"}
+
+	parsed, err := parseDNSCryptStamp(st.String())
+	if err != nil {
+		t.Fatalf("parseDNSCryptStamp failed: %v", err)
+	}
+	if parsed.serverAddr != st.serverAddr || parsed.providerName != st.providerName || !bytes.Equal(parsed.providerPK, pub) || parsed.props != 1 {
+		t.Errorf("stamp mismatch: %+v", parsed)
+	}
+
+	// Addresses without a port default to 443
+	st.serverAddr = "192.0.2.1"
+	parsed, _ = parseDNSCryptStamp(st.String())
+	if parsed.serverAddr != "192.0.2.1:443" {
+		t.Errorf("expected default port, got %s", parsed.serverAddr)
+	}
+
+	if _, err := parseDNSCryptStamp("sdns://AgcAAAAAAAAA"); err == nil {
+		t.Error("expected error for non-DNSCrypt stamp")
+	}
+}
+
+func TestDNSCryptCert_SignVerify(t *testing.T) {
+	pub, sk, _ := ed25519.GenerateKey(rand.Reader)
+	var resolverPK [32]byte
+	rand.Read(resolverPK[:])
+
+	now := time.Now()
+	cert := newDNSCryptCert(esXChacha20Poly1305, sk, resolverPK, 42, now.Add(-time.Minute), now.Add(time.Hour))
+	raw := cert.marshal()
+	if len(raw) != dnscryptCertSize {
+		t.Fatalf("expected %d byte certificate, got %d", dnscryptCertSize, len(raw))
+	}
+
+	parsed, err := parseDNSCryptCert(raw, pub)
+	if err != nil {
+		t.Fatalf("parseDNSCryptCert failed: %v", err)
+	}
+	if parsed.serial != 42 || parsed.resolverPK != resolverPK || !parsed.validAt(now) {
+		t.Errorf("unexpected certificate %+v", parsed)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := parseDNSCryptCert(raw, otherPub); err == nil {
+		t.Error("expected signature failure with wrong provider key")
+	}
+
+	// TXT escaping survives a DNS round trip
+	if !bytes.Equal(txtToBytes(bytesToTXT(raw)), raw) {
+		t.Error("TXT escaping is not reversible")
+	}
+}
+
+func TestDNSCryptBox(t *testing.T) {
+	for _, es := range []uint16{esXSalsa20Poly1305, esXChacha20Poly1305} {
+		var clientSK, resolverSK [32]byte
+		rand.Read(clientSK[:])
+		rand.Read(resolverSK[:])
+		clientPK, resolverPK := x25519Public(t, clientSK), x25519Public(t, resolverSK)
+
+		k1, err := dnscryptSharedKey(es, &clientSK, &resolverPK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, _ := dnscryptSharedKey(es, &resolverSK, &clientPK)
+		if k1 != k2 {
+			t.Fatalf("es %d: shared keys differ", es)
+		}
+
+		var nonce [24]byte
+		rand.Read(nonce[:])
+		msg := dnscryptPad([]byte("hello dnscrypt"), dnscryptMinQuery)
+		if len(msg) != dnscryptMinQuery {
+			t.Errorf("expected padding to %d bytes, got %d", dnscryptMinQuery, len(msg))
+		}
+
+		sealed := dnscryptSeal(es, &k1, &nonce, msg)
+		opened, err := dnscryptOpen(es, &k2, &nonce, sealed)
+		if err != nil {
+			t.Fatalf("es %d: open failed: %v", es, err)
+		}
+		plain, err := dnscryptUnpad(opened)
+		if err != nil || string(plain) != "hello dnscrypt" {
+			t.Errorf("es %d: round trip mismatch: %q, %v", es, plain, err)
+		}
+
+		sealed[len(sealed)-1] ^= 1
+		if _, err := dnscryptOpen(es, &k2, &nonce, sealed); err == nil {
+			t.Errorf("es %d: expected tampered box to fail", es)
+		}
+	}
+}
+
+func x25519Public(t *testing.T, sk [32]byte) [32]byte {
+	t.Helper()
+	pub, err := curve25519.X25519(sk[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk [32]byte
+	copy(pk[:], pub)
+	return pk
+}
+
+func newDNSCryptTestService(t *testing.T, es int) (*Service, *dnscryptServer) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.DNSServer{
+		Enabled: true,
+		Hosts:   []config.DNSHostEntry{{IP: "192.168.1.20", Hostnames: []string{"printer.lan"}}},
+		DNSCryptServer: &config.DNSCryptServerConfig{
+			Enabled:       true,
+			ListenAddr:    "127.0.0.1:0",
+			ProviderName:  "2.dnscrypt-cert.glacic.test",
+			PublicKeyFile: filepath.Join(dir, "public.key"),
+			SecretKeyFile: filepath.Join(dir, "secret.key"),
+			CertFile:      filepath.Join(dir, "current.cert"),
+			ESVersion:     es,
+		},
+	}
+	s, err := newTestService(cfg)
+	if err != nil {
+		t.Fatalf("newTestService failed: %v", err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	if len(s.dnscryptServers) != 1 {
+		t.Fatalf("expected 1 DNSCrypt server, got %d", len(s.dnscryptServers))
+	}
+	return s, s.dnscryptServers[0]
+}
+
+func stampFor(srv *dnscryptServer) string {
+	return (&dnscryptStamp{
+		serverAddr:   srv.pc.LocalAddr().String(),
+		providerPK:   srv.providerSK.Public().(ed25519.PublicKey),
+		providerName: strings.TrimSuffix(srv.providerName, "."),
+	}).String()
+}
+
+func TestDNSCrypt_Loopback(t *testing.T) {
+	for _, es := range []int{esXSalsa20Poly1305, esXChacha20Poly1305} {
+		_, srv := newDNSCryptTestService(t, es)
+
+		client, err := newDNSCryptClient(config.DNSCryptUpstream{Name: "local", Stamp: stampFor(srv), Enabled: true})
+		if err != nil {
+			t.Fatalf("newDNSCryptClient failed: %v", err)
+		}
+
+		m := new(dns.Msg)
+		m.SetQuestion("printer.lan.", dns.TypeA)
+		resp, err := client.Exchange(m)
+		if err != nil {
+			t.Fatalf("es %d: exchange failed: %v", es, err)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.168.1.20" {
+			t.Errorf("es %d: unexpected answer %v", es, resp.Answer)
+		}
+		if client.cert.esVersion != uint16(es) {
+			t.Errorf("expected es-version %d, got %d", es, client.cert.esVersion)
+		}
+
+		// TCP transport
+		cert, pk, key, _ := client.session()
+		query, _ := m.Pack()
+		resp, err = client.roundTrip("tcp", cert, pk, key, query)
+		if err != nil {
+			t.Fatalf("es %d: TCP exchange failed: %v", es, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Errorf("es %d: unexpected TCP answer %v", es, resp.Answer)
+		}
+	}
+}
+
+func TestDNSCrypt_ManualConfig(t *testing.T) {
+	_, srv := newDNSCryptTestService(t, esXChacha20Poly1305)
+	pub := srv.providerSK.Public().(ed25519.PublicKey)
+
+	ups := buildDNSCryptUpstreams([]config.DNSCryptUpstream{{
+		Name:         "manual",
+		ProviderName: "2.dnscrypt-cert.glacic.test",
+		ServerAddr:   srv.pc.LocalAddr().String(),
+		PublicKey:    strings.ToUpper(hex.EncodeToString(pub)),
+		Enabled:      true,
+	}})
+	if len(ups) != 1 || ups[0].Protocol != "dnscrypt" {
+		t.Fatalf("expected one dnscrypt upstream, got %+v", ups)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("printer.lan.", dns.TypeA)
+	if _, err := ups[0].DNSCrypt.Exchange(m); err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+}
+
+func TestDNSCrypt_CertificateRotation(t *testing.T) {
+	_, srv := newDNSCryptTestService(t, esXChacha20Poly1305)
+	client, err := newDNSCryptClient(config.DNSCryptUpstream{Stamp: stampFor(srv), Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("printer.lan.", dns.TypeA)
+	if _, err := client.Exchange(m); err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	oldSerial := client.cert.serial
+
+	if err := srv.rotate(); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+
+	// Old certificate keeps working during the grace period
+	if _, err := client.Exchange(m); err != nil {
+		t.Fatalf("exchange with previous certificate failed: %v", err)
+	}
+
+	// Once the client refreshes it moves to the newest certificate
+	client.invalidate()
+	if _, err := client.Exchange(m); err != nil {
+		t.Fatalf("exchange after refresh failed: %v", err)
+	}
+	if client.cert.serial <= oldSerial {
+		t.Errorf("expected newer serial than %d, got %d", oldSerial, client.cert.serial)
+	}
+}
+
+func TestDNSCrypt_RejectsUnknownProvider(t *testing.T) {
+	_, srv := newDNSCryptTestService(t, esXChacha20Poly1305)
+	m := new(dns.Msg)
+	m.SetQuestion("other.example.", dns.TypeTXT)
+	packed, _ := m.Pack()
+
+	out := srv.handle(packed, nil, nil, true)
+	resp := new(dns.Msg)
+	if err := resp.Unpack(out); err != nil {
+		t.Fatalf("unpack failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Errorf("expected REFUSED, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -1,9 +1,44 @@
 package dns
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+
 	"github.com/miekg/dns"
 )
 
+// defaultTrustAnchors are the IANA root zone KSK DS records (KSK-2017 and
+// KSK-2024), used when no trust anchors are configured.
+var defaultTrustAnchors = []string{
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	// maxNSEC3Iterations follows RFC 9276: zones using more iterations are
+	// treated as insecure rather than spending CPU on hashing.
+	maxNSEC3Iterations = 150
+
+	// validatorMaxTTL caps how long validated keys and delegations are cached.
+	validatorMaxTTL = time.Hour
+	validatorMinTTL = time.Minute
+)
+
+var (
+	errNoSignature  = errors.New("missing signature")
+	errBadSignature = errors.New("no valid signature")
+	errNoTrustedKey = errors.New("no DNSKEY matches the trusted DS set")
+	errNoDenial     = errors.New("missing or invalid proof of non-existence")
+)
+
 // setDO sets the DNSSEC OK (DO) bit in the OPT record (EDNS0)
 func setDO(r *dns.Msg) {
 	o := r.IsEdns0()
@@ -15,12 +50,811 @@ func setDO(r *dns.Msg) {
 	r.SetEdns0(4096, true)
 }
 
-// validateResponse checks if the response is secure (AD bit) if requested
-func validateResponse(r *dns.Msg) bool {
-	// Simple check: if Authenticated Data (AD) bit is set, we trust the upstream
-	return r.AuthenticatedData
+// setValidatorLocked replaces the DNSSEC validator to match the
+// configuration. Caller must hold s.mu.
+func (s *Service) setValidatorLocked(cfg *config.DNSServer) {
+	s.validator = nil
+	if !cfg.DNSSEC {
+		return
+	}
+	v, err := newValidator(cfg.TrustAnchors, cfg.TrustAnchorFile, s.validatorQuery)
+	if err != nil {
+		log.Printf("[DNSSEC] Validation disabled: %v", err)
+		return
+	}
+	s.validator = v
+	log.Printf("[DNSSEC] Validating answers from %d trust anchor zone(s)", len(v.anchors))
+}
+
+// validatorQuery fetches DS and DNSKEY records through the forwarders with
+// the CD bit set so the upstream does not filter what we validate.
+func (s *Service) validatorQuery(name string, qtype uint16) (*dns.Msg, error) {
+	s.mu.RLock()
+	upstreams := append(append([]upstream(nil), s.upstreams...), s.dynamicUpstreams...)
+	s.mu.RUnlock()
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.CheckingDisabled = true
+	setDO(m)
+
+	err := errNoServers
+	for _, up := range upstreams {
+		var resp *dns.Msg
+		if resp, err = s.exchange(m, up); err == nil && resp != nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+// dnssecFailure logs a bogus answer and publishes it on the event hub.
+func (s *Service) dnssecFailure(r *dns.Msg, up upstream, err error) {
+	q := r.Question[0]
+	log.Printf("[DNSSEC] Bogus answer for %s %s from %s: %v", q.Name, dns.TypeToString[q.Qtype], upstreamName(up), err)
+
+	s.mu.RLock()
+	hub := s.events
+	s.mu.RUnlock()
+	if hub != nil {
+		hub.EmitDNSSECFailure(strings.TrimSuffix(q.Name, "."), dns.TypeToString[q.Qtype], upstreamName(up), err.Error())
+	}
+}
+
+// zoneKeys is the validated DNSKEY set of a zone. A nil key set means the
+// zone is provably insecure.
+type zoneKeys struct {
+	zone string
+	keys []*dns.DNSKEY
 }
 
-// Note: Full local validation requires recursive logic and walking the chain of trust
-// which is beyond the scope of a stub resolver/forwarder without heavier dependencies.
-// We rely on the upstream resolver (Google, Cloudflare, Quad9) to do validation.
+func (z *zoneKeys) secure() bool { return len(z.keys) > 0 }
+
+// delegationEntry caches the result of checking one name for a zone cut.
+type delegationEntry struct {
+	keys      *zoneKeys // Non-nil when name is a zone cut
+	stop      bool      // Name does not exist; nothing below it can be a cut
+	expiresAt time.Time
+}
+
+// validator walks the chain of trust from a configured anchor down to the
+// answer's zone, verifying each DS and DNSKEY RRset along the way.
+type validator struct {
+	anchors map[string][]dns.RR // Zone -> trusted DS or DNSKEY records
+	query   func(name string, qtype uint16) (*dns.Msg, error)
+
+	mu          sync.Mutex
+	delegations map[string]delegationEntry
+}
+
+// newValidator loads trust anchors from inline records and an optional
+// zone-format file, falling back to the root KSKs.
+func newValidator(records []string, file string, query func(string, uint16) (*dns.Msg, error)) (*validator, error) {
+	v := &validator{
+		anchors:     make(map[string][]dns.RR),
+		query:       query,
+		delegations: make(map[string]delegationEntry),
+	}
+
+	if len(records) == 0 && file == "" {
+		records = defaultTrustAnchors
+	}
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %q: %w", s, err)
+		}
+		if err := v.addAnchor(rr); err != nil {
+			return nil, err
+		}
+	}
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trust anchor file: %w", err)
+		}
+		defer f.Close()
+		zp := dns.NewZoneParser(f, ".", file)
+		for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+			if err := v.addAnchor(rr); err != nil {
+				return nil, err
+			}
+		}
+		if err := zp.Err(); err != nil {
+			return nil, fmt.Errorf("failed to parse trust anchor file: %w", err)
+		}
+	}
+
+	if len(v.anchors) == 0 {
+		return nil, fmt.Errorf("no usable trust anchors")
+	}
+	return v, nil
+}
+
+func (v *validator) addAnchor(rr dns.RR) error {
+	switch rr.(type) {
+	case *dns.DS, *dns.DNSKEY:
+		zone := strings.ToLower(rr.Header().Name)
+		v.anchors[zone] = append(v.anchors[zone], rr)
+		return nil
+	}
+	return fmt.Errorf("trust anchor must be DS or DNSKEY, got %s", dns.TypeToString[rr.Header().Rrtype])
+}
+
+// validate checks a response fetched with the CD bit set. It returns true
+// when every RRset is secure, false when some part is provably insecure,
+// and an error when the response is bogus.
+func (v *validator) validate(resp *dns.Msg) (bool, error) {
+	if len(resp.Question) == 0 {
+		return false, nil
+	}
+	q := resp.Question[0]
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return false, nil
+	}
+
+	secure := true
+	authority := splitRRsets(resp.Ns)
+
+	for _, set := range splitRRsets(resp.Answer) {
+		zk, err := v.signerChain(set.name, set.rrtype)
+		if err != nil {
+			return false, err
+		}
+		if !zk.secure() {
+			secure = false
+			continue
+		}
+		sig, err := verifyRRset(set, zk)
+		if err != nil {
+			return false, fmt.Errorf("%s %s: %w", set.name, dns.TypeToString[set.rrtype], err)
+		}
+		// Wildcard expansion: prove the exact name does not exist
+		if int(sig.Labels) < dns.CountLabel(set.name) {
+			if err := v.proveWildcard(set.name, sig, authority, zk); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	target := finalTarget(q.Name, resp.Answer)
+	if !answered(target, q.Qtype, resp.Answer) {
+		ok, err := v.proveDenial(target, q.Qtype, resp.Rcode == dns.RcodeNameError, authority)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", target, err)
+		}
+		secure = secure && ok
+	}
+	return secure, nil
+}
+
+// proveDenial validates a NODATA or NXDOMAIN response for name.
+func (v *validator) proveDenial(name string, qtype uint16, nxdomain bool, authority []rrset) (bool, error) {
+	zk, err := v.signerChain(name, qtype)
+	if err != nil || !zk.secure() {
+		return false, err
+	}
+
+	nsec, nsec3, err := verifiedDenial(authority, zk)
+	if err != nil {
+		return false, err
+	}
+	if len(nsec3) > 0 && !nsec3Usable(nsec3) {
+		return false, nil
+	}
+
+	switch {
+	case len(nsec) > 0 && nxdomain:
+		if nsecNXDomain(name, nsec) {
+			return true, nil
+		}
+	case len(nsec) > 0:
+		if nsecNoData(name, qtype, nsec) {
+			return true, nil
+		}
+	case len(nsec3) > 0 && nxdomain:
+		if ce, nc, _ := nsec3ClosestEncloser(name, nsec3); ce != "" && nc != "" && nsec3Covers(nsec3, wildcardAt(ce)) {
+			return true, nil
+		}
+	case len(nsec3) > 0:
+		// Empty non-terminals have their own NSEC3 with an empty bitmap
+		if n := nsec3Matching(nsec3, name); n != nil {
+			if !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME) {
+				return true, nil
+			}
+			break
+		}
+		ce, nc, optOut := nsec3ClosestEncloser(name, nsec3)
+		if nc == "" {
+			break
+		}
+		// Wildcard NODATA: the wildcard at the closest encloser exists
+		// but lacks the type (RFC 5155 section 8.7)
+		if w := nsec3Matching(nsec3, wildcardAt(ce)); w != nil {
+			if !hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+				return true, nil
+			}
+			break
+		}
+		// DS queries may fall in an opt-out span (RFC 5155 section 8.6)
+		if optOut && qtype == dns.TypeDS {
+			return false, nil
+		}
+	}
+	return false, errNoDenial
+}
+
+// proveWildcard checks that a wildcard-expanded answer comes with proof
+// that the query name itself does not exist (RFC 4035 section 5.3.4).
+func (v *validator) proveWildcard(name string, sig *dns.RRSIG, authority []rrset, zk *zoneKeys) error {
+	nsec, nsec3, err := verifiedDenial(authority, zk)
+	if err != nil {
+		return err
+	}
+	for _, n := range nsec {
+		if nsecCovers(n, name) {
+			return nil
+		}
+	}
+	if len(nsec3) > 0 {
+		// The next closer name sits one label below the wildcard's parent
+		labels := dns.SplitDomainName(name)
+		nc := dns.Fqdn(strings.Join(labels[len(labels)-int(sig.Labels)-1:], "."))
+		if nsec3Covers(nsec3, nc) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: wildcard answer: %w", name, errNoDenial)
+}
+
+// chain returns the validated keys of the deepest zone enclosing name,
+// walking down one label at a time from the closest trust anchor.
+func (v *validator) chain(name string) (*zoneKeys, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	anchor, depth := "", -1
+	for zone := range v.anchors {
+		if dns.IsSubDomain(zone, name) && dns.CountLabel(zone) > depth {
+			anchor, depth = zone, dns.CountLabel(zone)
+		}
+	}
+	if anchor == "" {
+		return &zoneKeys{zone: name}, nil
+	}
+
+	zk, err := v.anchorKeys(anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - depth - 1; i >= 0 && zk.secure(); i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+		entry, err := v.delegation(zk, child)
+		if err != nil {
+			return nil, err
+		}
+		if entry.keys != nil {
+			zk = entry.keys
+		}
+		if entry.stop {
+			break
+		}
+	}
+	return zk, nil
+}
+
+// signerChain returns the keys that sign an RRset of rrtype at name.
+func (v *validator) signerChain(name string, rrtype uint16) (*zoneKeys, error) {
+	return v.chain(servingName(name, rrtype))
+}
+
+// servingName returns the name whose enclosing zone serves an RRset of
+// rrtype at name. DS records belong to the parent side of a zone cut, so
+// they are served by the zone enclosing name's parent.
+func servingName(name string, rrtype uint16) string {
+	if rrtype != dns.TypeDS || name == "." {
+		return name
+	}
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// anchorKeys returns the trusted keys of an anchor zone.
+func (v *validator) anchorKeys(anchor string) (*zoneKeys, error) {
+	v.mu.Lock()
+	entry, ok := v.delegations[anchor]
+	v.mu.Unlock()
+	if ok && clock.Now().Before(entry.expiresAt) {
+		return entry.keys, nil
+	}
+
+	keys, ttl, err := v.fetchKeys(anchor, v.anchors[anchor])
+	if err != nil {
+		return nil, fmt.Errorf("trust anchor %s: %w", anchor, err)
+	}
+	v.store(anchor, delegationEntry{keys: keys}, ttl)
+	return keys, nil
+}
+
+// delegation determines whether child is a zone cut below parent by
+// asking for its DS RRset, and validates the child's keys when it is.
+func (v *validator) delegation(parent *zoneKeys, child string) (delegationEntry, error) {
+	v.mu.Lock()
+	entry, ok := v.delegations[child]
+	v.mu.Unlock()
+	if ok && clock.Now().Before(entry.expiresAt) {
+		return entry, nil
+	}
+
+	resp, err := v.query(child, dns.TypeDS)
+	if err != nil {
+		return entry, fmt.Errorf("DS lookup for %s: %w", child, err)
+	}
+
+	sets := splitRRsets(resp.Answer)
+	for _, set := range sets {
+		if set.rrtype == dns.TypeCNAME {
+			// An alias is a leaf in the parent zone, never a cut
+			if _, err := verifyRRset(set, parent); err != nil {
+				return entry, fmt.Errorf("%s CNAME: %w", child, err)
+			}
+			entry = delegationEntry{stop: true}
+			v.store(child, entry, set.ttl)
+			return entry, nil
+		}
+		if set.rrtype != dns.TypeDS || !strings.EqualFold(set.name, child) {
+			continue
+		}
+		if _, err := verifyRRset(set, parent); err != nil {
+			return entry, fmt.Errorf("%s DS: %w", child, err)
+		}
+		keys, ttl, err := v.fetchKeys(child, set.rrs)
+		if err != nil {
+			return entry, fmt.Errorf("%s: %w", child, err)
+		}
+		entry = delegationEntry{keys: keys}
+		v.store(child, entry, min(ttl, set.ttl))
+		return entry, nil
+	}
+
+	// No DS: the authority section must prove why
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return entry, fmt.Errorf("DS lookup for %s: %s", child, dns.RcodeToString[resp.Rcode])
+	}
+	authority := splitRRsets(resp.Ns)
+	nsec, nsec3, err := verifiedDenial(authority, parent)
+	if err != nil {
+		return entry, fmt.Errorf("%s DS denial: %w", child, err)
+	}
+	ttl := minTTL(authority)
+	insecure := &zoneKeys{zone: child}
+
+	for _, n := range nsec {
+		if strings.EqualFold(n.Hdr.Name, child) {
+			if hasType(n.TypeBitMap, dns.TypeDS) {
+				return entry, fmt.Errorf("%s: %w", child, errNoDenial)
+			}
+			if hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA) {
+				entry = delegationEntry{keys: insecure}
+			}
+			v.store(child, entry, ttl)
+			return entry, nil
+		}
+	}
+	if resp.Rcode == dns.RcodeNameError && len(nsec) > 0 && nsecNXDomain(child, nsec) {
+		entry = delegationEntry{stop: true}
+		v.store(child, entry, ttl)
+		return entry, nil
+	}
+	for _, n := range nsec {
+		if !nsecCovers(n, child) {
+			continue
+		}
+		// An empty non-terminal has names below it; otherwise child only
+		// exists through a wildcard and cannot contain a cut
+		entry.stop = !dns.IsSubDomain(child, n.NextDomain)
+		v.store(child, entry, ttl)
+		return entry, nil
+	}
+
+	if len(nsec3) > 0 {
+		if !nsec3Usable(nsec3) {
+			entry = delegationEntry{keys: insecure}
+			v.store(child, entry, ttl)
+			return entry, nil
+		}
+		if n := nsec3Matching(nsec3, child); n != nil {
+			if hasType(n.TypeBitMap, dns.TypeDS) {
+				return entry, fmt.Errorf("%s: %w", child, errNoDenial)
+			}
+			if hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA) {
+				entry = delegationEntry{keys: insecure}
+			}
+			v.store(child, entry, ttl)
+			return entry, nil
+		}
+		if _, nc, optOut := nsec3ClosestEncloser(child, nsec3); nc != "" {
+			// An opt-out span may hide an unsigned delegation
+			entry = delegationEntry{stop: !optOut}
+			if optOut {
+				entry.keys = insecure
+			}
+			v.store(child, entry, ttl)
+			return entry, nil
+		}
+	}
+
+	return entry, fmt.Errorf("%s DS: %w", child, errNoDenial)
+}
+
+// fetchKeys retrieves a zone's DNSKEY RRset and validates it against the
+// trusted DS or DNSKEY records. A DS set using only unsupported algorithms
+// makes the zone insecure (RFC 4035 section 5.2).
+func (v *validator) fetchKeys(zone string, trusted []dns.RR) (*zoneKeys, uint32, error) {
+	supported := false
+	for _, rr := range trusted {
+		switch t := rr.(type) {
+		case *dns.DS:
+			supported = supported || (algorithmSupported(t.Algorithm) && digestSupported(t.DigestType))
+		case *dns.DNSKEY:
+			supported = supported || algorithmSupported(t.Algorithm)
+		}
+	}
+	if !supported {
+		return &zoneKeys{zone: zone}, uint32(validatorMaxTTL.Seconds()), nil
+	}
+
+	resp, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DNSKEY lookup: %w", err)
+	}
+
+	var set rrset
+	for _, s := range splitRRsets(resp.Answer) {
+		if s.rrtype == dns.TypeDNSKEY && strings.EqualFold(s.name, zone) {
+			set = s
+		}
+	}
+	if len(set.rrs) == 0 {
+		return nil, 0, fmt.Errorf("DNSKEY lookup: %w", errNoTrustedKey)
+	}
+
+	var keys, entry []*dns.DNSKEY
+	for _, rr := range set.rrs {
+		key := rr.(*dns.DNSKEY)
+		if key.Flags&dns.ZONE == 0 || key.Protocol != 3 {
+			continue
+		}
+		keys = append(keys, key)
+		if keyTrusted(key, trusted) {
+			entry = append(entry, key)
+		}
+	}
+	if len(entry) == 0 {
+		return nil, 0, errNoTrustedKey
+	}
+
+	// The DNSKEY RRset must be self-signed by a trusted key
+	if _, err := verifyRRset(set, &zoneKeys{zone: zone, keys: entry}); err != nil {
+		return nil, 0, fmt.Errorf("DNSKEY: %w", err)
+	}
+	return &zoneKeys{zone: zone, keys: keys}, set.ttl, nil
+}
+
+func (v *validator) store(name string, entry delegationEntry, ttl uint32) {
+	d := time.Duration(ttl) * time.Second
+	d = max(min(d, validatorMaxTTL), validatorMinTTL)
+	entry.expiresAt = clock.Now().Add(d)
+
+	v.mu.Lock()
+	v.delegations[name] = entry
+	v.mu.Unlock()
+}
+
+// keyTrusted matches a DNSKEY against DS digests or anchor keys.
+func keyTrusted(key *dns.DNSKEY, trusted []dns.RR) bool {
+	for _, rr := range trusted {
+		switch t := rr.(type) {
+		case *dns.DS:
+			if t.KeyTag != key.KeyTag() || t.Algorithm != key.Algorithm || !digestSupported(t.DigestType) {
+				continue
+			}
+			if ds := key.ToDS(t.DigestType); ds != nil && strings.EqualFold(ds.Digest, t.Digest) {
+				return true
+			}
+		case *dns.DNSKEY:
+			if t.Algorithm == key.Algorithm && t.PublicKey == key.PublicKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func algorithmSupported(alg uint8) bool {
+	switch alg {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		return true
+	}
+	return false
+}
+
+func digestSupported(digest uint8) bool {
+	return digest == dns.SHA1 || digest == dns.SHA256 || digest == dns.SHA384
+}
+
+// rrset groups records sharing an owner name and type with the RRSIGs
+// covering them.
+type rrset struct {
+	name   string
+	rrtype uint16
+	ttl    uint32
+	rrs    []dns.RR
+	sigs   []*dns.RRSIG
+}
+
+func splitRRsets(rrs []dns.RR) []rrset {
+	var sets []rrset
+	index := make(map[string]int)
+	key := func(name string, t uint16) string { return strings.ToLower(name) + "/" + dns.TypeToString[t] }
+
+	for _, rr := range rrs {
+		h := rr.Header()
+		t := h.Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			t = sig.TypeCovered
+		}
+		if t == dns.TypeOPT {
+			continue
+		}
+		k := key(h.Name, t)
+		i, ok := index[k]
+		if !ok {
+			i = len(sets)
+			index[k] = i
+			sets = append(sets, rrset{name: h.Name, rrtype: t, ttl: h.Ttl})
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sets[i].sigs = append(sets[i].sigs, sig)
+			continue
+		}
+		sets[i].rrs = append(sets[i].rrs, rr)
+		sets[i].ttl = min(sets[i].ttl, h.Ttl)
+	}
+
+	// Drop signature-only groups
+	out := sets[:0]
+	for _, s := range sets {
+		if len(s.rrs) > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// verifyRRset checks that one of the RRset's signatures is currently
+// valid and made by a key of the zone. It returns the verifying RRSIG.
+func verifyRRset(set rrset, zk *zoneKeys) (*dns.RRSIG, error) {
+	if len(set.sigs) == 0 {
+		return nil, errNoSignature
+	}
+	now := clock.Now()
+	for _, sig := range set.sigs {
+		if !strings.EqualFold(sig.SignerName, zk.zone) || !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, key := range zk.keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if sig.Verify(key, set.rrs) == nil {
+				return sig, nil
+			}
+		}
+	}
+	return nil, errBadSignature
+}
+
+// verifiedDenial validates the NSEC and NSEC3 RRsets of an authority
+// section against the zone's keys.
+func verifiedDenial(authority []rrset, zk *zoneKeys) ([]*dns.NSEC, []*dns.NSEC3, error) {
+	var nsec []*dns.NSEC
+	var nsec3 []*dns.NSEC3
+	for _, set := range authority {
+		if set.rrtype != dns.TypeNSEC && set.rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if _, err := verifyRRset(set, zk); err != nil {
+			return nil, nil, fmt.Errorf("%s %s: %w", set.name, dns.TypeToString[set.rrtype], err)
+		}
+		for _, rr := range set.rrs {
+			switch t := rr.(type) {
+			case *dns.NSEC:
+				nsec = append(nsec, t)
+			case *dns.NSEC3:
+				nsec3 = append(nsec3, t)
+			}
+		}
+	}
+	if len(nsec) == 0 && len(nsec3) == 0 {
+		return nil, nil, errNoDenial
+	}
+	return nsec, nsec3, nil
+}
+
+// nsecNXDomain checks RFC 4035 section 5.4: an NSEC covers the name and
+// another covers the wildcard at its closest encloser.
+func nsecNXDomain(name string, nsec []*dns.NSEC) bool {
+	for _, n := range nsec {
+		if !nsecCovers(n, name) {
+			continue
+		}
+		wildcard := nsecWildcard(name, n)
+		for _, w := range nsec {
+			if nsecCovers(w, wildcard) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nsecNoData checks RFC 4035 section 3.1.3: an NSEC at name whose bitmap
+// lacks the type, an NSEC showing name is an empty non-terminal, or an NSEC
+// covering name together with one at the matching wildcard lacking the type.
+func nsecNoData(name string, qtype uint16, nsec []*dns.NSEC) bool {
+	for _, n := range nsec {
+		if strings.EqualFold(n.Hdr.Name, name) {
+			return !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+	// Empty non-terminal: the next name after name lies below it
+	for _, n := range nsec {
+		if canonicalCompare(n.Hdr.Name, name) < 0 && canonicalCompare(name, n.NextDomain) < 0 && dns.IsSubDomain(name, n.NextDomain) {
+			return true
+		}
+	}
+	for _, n := range nsec {
+		if !nsecCovers(n, name) {
+			continue
+		}
+		wildcard := nsecWildcard(name, n)
+		for _, w := range nsec {
+			if strings.EqualFold(w.Hdr.Name, wildcard) {
+				return !hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, dns.TypeCNAME)
+			}
+		}
+	}
+	return false
+}
+
+// nsecWildcard returns the wildcard at the closest encloser of name, given
+// the NSEC covering it.
+func nsecWildcard(name string, n *dns.NSEC) string {
+	return wildcardAt(deeperName(commonAncestor(name, n.Hdr.Name), commonAncestor(name, n.NextDomain)))
+}
+
+func wildcardAt(ce string) string {
+	if ce == "." {
+		return "*."
+	}
+	return "*." + ce
+}
+
+// nsec3Usable reports whether the NSEC3 parameters can be validated; hash
+// algorithms other than SHA-1 and excessive iterations make the answer
+// insecure rather than bogus.
+func nsec3Usable(nsec3 []*dns.NSEC3) bool {
+	for _, n := range nsec3 {
+		if n.Hash != dns.SHA1 || n.Iterations > maxNSEC3Iterations {
+			return false
+		}
+	}
+	return true
+}
+
+func nsec3Matching(nsec3 []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, n := range nsec3 {
+		if n.Match(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+func nsec3Covers(nsec3 []*dns.NSEC3, name string) bool {
+	for _, n := range nsec3 {
+		if n.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsec3ClosestEncloser implements the closest encloser proof of RFC 5155
+// section 8.3. It returns the closest encloser, the next closer name when
+// it is covered, and whether the covering NSEC3 has the opt-out flag.
+func nsec3ClosestEncloser(name string, nsec3 []*dns.NSEC3) (string, string, bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 0; i <= len(labels); i++ {
+		ce := dns.Fqdn(strings.Join(labels[i:], "."))
+		if nsec3Matching(nsec3, ce) == nil {
+			continue
+		}
+		if i == 0 {
+			return ce, "", false
+		}
+		nc := dns.Fqdn(strings.Join(labels[i-1:], "."))
+		for _, n := range nsec3 {
+			if n.Cover(nc) {
+				return ce, nc, n.Flags&1 == 1
+			}
+		}
+		return ce, "", false
+	}
+	return "", "", false
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// finalTarget follows CNAMEs in the answer section starting from name.
+func finalTarget(name string, answer []dns.RR) string {
+	for i := 0; i < 16; i++ {
+		next := ""
+		for _, rr := range answer {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, name) {
+				next = c.Target
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	return name
+}
+
+func answered(name string, qtype uint16, answer []dns.RR) bool {
+	for _, rr := range answer {
+		h := rr.Header()
+		if strings.EqualFold(h.Name, name) && (h.Rrtype == qtype || qtype == dns.TypeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+func commonAncestor(a, b string) string {
+	n := dns.CompareDomainName(a, b)
+	if n == 0 {
+		return "."
+	}
+	return lastLabels(strings.ToLower(dns.Fqdn(a)), n)
+}
+
+func deeperName(a, b string) string {
+	if dns.CountLabel(b) > dns.CountLabel(a) {
+		return b
+	}
+	return a
+}
+
+func minTTL(sets []rrset) uint32 {
+	ttl := uint32(validatorMaxTTL.Seconds())
+	for _, s := range sets {
+		ttl = min(ttl, s.ttl)
+	}
+	return ttl
+}
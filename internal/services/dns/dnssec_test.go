@@ -0,0 +1,521 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/events"
+
+	"github.com/miekg/dns"
+)
+
+// signedZone is an in-memory zone that signs answers on the fly and
+// proves non-existence with NSEC or NSEC3.
+type signedZone struct {
+	name    string
+	key     *dns.DNSKEY // nil for unsigned zones
+	signer  crypto.Signer
+	nsec3   bool
+	records []dns.RR
+}
+
+// signedTree answers queries the way a resolver with CD set would.
+type signedTree struct {
+	zones map[string]*signedZone
+
+	mu     sync.Mutex
+	mutate func(*dns.Msg)
+}
+
+func newSignedZone(t *testing.T, name string, signed bool, records ...string) *signedZone {
+	t.Helper()
+	z := &signedZone{name: name}
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("bad record %q: %v", s, err)
+		}
+		z.records = append(z.records, rr)
+	}
+	if signed {
+		z.key = &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := z.key.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z.signer = priv.(crypto.Signer)
+		z.records = append(z.records, z.key)
+	}
+	return z
+}
+
+func (z *signedZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+func (z *signedZone) sign(t *testing.T, rrs []dns.RR) []dns.RR {
+	t.Helper()
+	if z.key == nil || len(rrs) == 0 {
+		return rrs
+	}
+	h := rrs[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		TypeCovered: h.Rrtype,
+		Algorithm:   z.key.Algorithm,
+		Labels:      uint8(dns.CountLabel(h.Name)),
+		OrigTtl:     h.Ttl,
+		Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:      z.key.KeyTag(),
+		SignerName:  z.name,
+	}
+	if strings.HasPrefix(h.Name, "*.") {
+		sig.Labels--
+	}
+	if err := sig.Sign(z.signer, rrs); err != nil {
+		t.Fatalf("sign %s: %v", h.Name, err)
+	}
+	return append(rrs, sig)
+}
+
+// denial builds the zone's NSEC or NSEC3 chain.
+func (z *signedZone) denial() []dns.RR {
+	types := make(map[string][]uint16)
+	for _, rr := range z.records {
+		name := strings.ToLower(rr.Header().Name)
+		types[name] = append(types[name], rr.Header().Rrtype)
+	}
+	var owners []string
+	for name := range types {
+		owners = append(owners, name)
+	}
+
+	var out []dns.RR
+	if z.nsec3 {
+		// Empty non-terminals get an NSEC3 too (RFC 5155 section 7.1)
+		for _, o := range owners {
+			for off, end := dns.NextLabel(o, 0); !end && dns.IsSubDomain(z.name, o[off:]); off, end = dns.NextLabel(o, off) {
+				if _, ok := types[o[off:]]; !ok {
+					types[o[off:]] = nil
+				}
+			}
+		}
+		owners = owners[:0]
+		for name := range types {
+			owners = append(owners, name)
+		}
+
+		hashes := make(map[string]string)
+		for _, o := range owners {
+			hashes[dns.HashName(o, dns.SHA1, 1, "ab")] = o
+		}
+		var sorted []string
+		for h := range hashes {
+			sorted = append(sorted, h)
+		}
+		sort.Strings(sorted)
+		for i, h := range sorted {
+			bitmap := types[hashes[h]]
+			if len(bitmap) > 0 {
+				bitmap = append(bitmap, dns.TypeRRSIG)
+			}
+			sort.Slice(bitmap, func(a, b int) bool { return bitmap[a] < bitmap[b] })
+			out = append(out, &dns.NSEC3{
+				Hdr:        dns.RR_Header{Name: strings.ToLower(h) + "." + z.name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+				Hash:       dns.SHA1,
+				Iterations: 1,
+				SaltLength: 1,
+				Salt:       "ab",
+				HashLength: 20,
+				NextDomain: sorted[(i+1)%len(sorted)],
+				TypeBitMap: bitmap,
+			})
+		}
+		return out
+	}
+
+	sort.Slice(owners, func(a, b int) bool { return canonicalCompare(owners[a], owners[b]) < 0 })
+	for i, o := range owners {
+		bitmap := append(types[o], dns.TypeRRSIG, dns.TypeNSEC)
+		sort.Slice(bitmap, func(a, b int) bool { return bitmap[a] < bitmap[b] })
+		out = append(out, &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: o, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: owners[(i+1)%len(owners)],
+			TypeBitMap: bitmap,
+		})
+	}
+	return out
+}
+
+// exists reports whether the zone has records at or below name.
+func (z *signedZone) exists(name string) bool {
+	for _, rr := range z.records {
+		if dns.IsSubDomain(name, rr.Header().Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard returns the wildcard owner that synthesizes name, if any.
+func (z *signedZone) wildcard(name string) string {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if ce := name[off:]; z.exists(ce) {
+			if w := "*." + ce; z.exists(w) {
+				return w
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func (z *signedZone) signedDenial(t *testing.T) []dns.RR {
+	var out []dns.RR
+	for _, rr := range z.denial() {
+		out = append(out, z.sign(t, []dns.RR{rr})...)
+	}
+	return out
+}
+
+func (tr *signedTree) answer(t *testing.T, q dns.Question) *dns.Msg {
+	name := strings.ToLower(q.Name)
+
+	// Deepest zone holding the name; DS lives in the parent
+	var zone *signedZone
+	for zn, z := range tr.zones {
+		if !dns.IsSubDomain(zn, name) || (q.Qtype == dns.TypeDS && zn == name) {
+			continue
+		}
+		if zone == nil || dns.CountLabel(zn) > dns.CountLabel(zone.name) {
+			zone = z
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.Response = true
+
+	owner := name
+	if !zone.exists(name) {
+		owner = zone.wildcard(name)
+	}
+	exists := owner != ""
+	var rrs []dns.RR
+	var cname *dns.CNAME
+	for _, rr := range zone.records {
+		if !strings.EqualFold(rr.Header().Name, owner) {
+			continue
+		}
+		if rr.Header().Rrtype == q.Qtype {
+			rrs = append(rrs, dns.Copy(rr))
+		}
+		if c, ok := rr.(*dns.CNAME); ok {
+			cname = c
+		}
+	}
+	switch {
+	case len(rrs) > 0:
+		m.Answer = zone.sign(t, rrs)
+		if owner != name {
+			// Expand the wildcard; the RRSIG label count still names it
+			for _, rr := range m.Answer {
+				rr.Header().Name = q.Name
+			}
+			m.Ns = zone.signedDenial(t)
+		}
+	case cname != nil:
+		// Chase the alias like a recursive resolver would
+		m.Answer = zone.sign(t, []dns.RR{dns.Copy(cname)})
+		target := tr.answer(t, dns.Question{Name: cname.Target, Qtype: q.Qtype, Qclass: dns.ClassINET})
+		m.Answer = append(m.Answer, target.Answer...)
+		m.Ns, m.Rcode = target.Ns, target.Rcode
+	default:
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Ns = zone.signedDenial(t)
+	}
+
+	tr.mu.Lock()
+	mutate := tr.mutate
+	tr.mu.Unlock()
+	if mutate != nil {
+		mutate(m)
+	}
+	return m
+}
+
+func newSignedTree(t *testing.T) (*signedTree, string) {
+	t.Helper()
+	example := newSignedZone(t, "example.", true,
+		"example. 3600 IN SOA ns.example. admin.example. 1 3600 600 86400 300",
+		"example. 3600 IN NS ns.example.",
+		"www.example. 3600 IN A 192.0.2.1",
+		"alias.example. 3600 IN CNAME www.example.",
+		"_sip._tcp.example. 3600 IN SRV 0 0 5060 www.example.",
+		"*.wild.example. 3600 IN A 192.0.2.4",
+	)
+	hashed := newSignedZone(t, "hashed.", true,
+		"hashed. 3600 IN SOA ns.hashed. admin.hashed. 1 3600 600 86400 300",
+		"www.hashed. 3600 IN A 192.0.2.3",
+		"_sip._tcp.hashed. 3600 IN SRV 0 0 5060 www.hashed.",
+		"*.wild.hashed. 3600 IN A 192.0.2.5",
+	)
+	hashed.nsec3 = true
+	insecure := newSignedZone(t, "insecure.", false,
+		"host.insecure. 3600 IN A 192.0.2.2",
+	)
+	root := newSignedZone(t, ".", true,
+		". 3600 IN SOA a.root. admin.root. 1 3600 600 86400 300",
+		"example. 3600 IN NS ns.example.",
+		example.ds().String(),
+		"hashed. 3600 IN NS ns.hashed.",
+		hashed.ds().String(),
+		"insecure. 3600 IN NS ns.insecure.",
+	)
+
+	tr := &signedTree{zones: map[string]*signedZone{
+		".": root, "example.": example, "hashed.": hashed, "insecure.": insecure,
+	}}
+	return tr, root.ds().String()
+}
+
+func (tr *signedTree) query(t *testing.T) func(string, uint16) (*dns.Msg, error) {
+	return func(name string, qtype uint16) (*dns.Msg, error) {
+		return tr.answer(t, dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}), nil
+	}
+}
+
+func TestValidator(t *testing.T) {
+	tr, anchor := newSignedTree(t)
+	v, err := newValidator([]string{anchor}, "", tr.query(t))
+	if err != nil {
+		t.Fatalf("newValidator failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		secure bool
+		bogus  bool
+		mutate func(*dns.Msg)
+	}{
+		{name: "www.example.", qtype: dns.TypeA, secure: true},
+		{name: "alias.example.", qtype: dns.TypeA, secure: true},
+		{name: "nx.example.", qtype: dns.TypeA, secure: true},
+		{name: "www.example.", qtype: dns.TypeMX, secure: true},
+		{name: "www.hashed.", qtype: dns.TypeA, secure: true},
+		{name: "nx.hashed.", qtype: dns.TypeA, secure: true},
+		{name: "www.hashed.", qtype: dns.TypeTXT, secure: true},
+		{name: "_tcp.example.", qtype: dns.TypeSRV, secure: true},
+		{name: "_tcp.hashed.", qtype: dns.TypeSRV, secure: true},
+		{name: "host.wild.example.", qtype: dns.TypeA, secure: true},
+		{name: "host.wild.hashed.", qtype: dns.TypeA, secure: true},
+		{name: "host.wild.example.", qtype: dns.TypeTXT, secure: true},
+		{name: "host.wild.hashed.", qtype: dns.TypeTXT, secure: true},
+		{name: "host.insecure.", qtype: dns.TypeA, secure: false},
+		{name: "example.", qtype: dns.TypeDS, secure: true},
+		{name: "hashed.", qtype: dns.TypeDS, secure: true},
+		{name: "insecure.", qtype: dns.TypeDS, secure: true},
+		{name: "www.example.", qtype: dns.TypeDS, secure: true},
+		{name: "example.", qtype: dns.TypeDS, bogus: true, mutate: func(m *dns.Msg) {
+			m.Answer[0].(*dns.DS).Digest = strings.Repeat("00", 32)
+		}},
+		{name: "www.example.", qtype: dns.TypeA, bogus: true, mutate: func(m *dns.Msg) {
+			m.Answer[0].(*dns.A).A = net.ParseIP("203.0.113.66")
+		}},
+		{name: "www.example.", qtype: dns.TypeA, bogus: true, mutate: func(m *dns.Msg) {
+			m.Answer = m.Answer[:1]
+		}},
+		{name: "nx.example.", qtype: dns.TypeA, bogus: true, mutate: func(m *dns.Msg) {
+			m.Ns = nil
+		}},
+		{name: "nx.hashed.", qtype: dns.TypeA, bogus: true, mutate: func(m *dns.Msg) {
+			m.Ns = m.Ns[:2]
+		}},
+		{name: "_tcp.example.", qtype: dns.TypeSRV, bogus: true, mutate: func(m *dns.Msg) {
+			m.Ns = withoutOwner(m.Ns, "example.")
+		}},
+		{name: "host.wild.example.", qtype: dns.TypeTXT, bogus: true, mutate: func(m *dns.Msg) {
+			m.Ns = withoutOwner(m.Ns, "*.wild.example.")
+		}},
+		{name: "host.wild.hashed.", qtype: dns.TypeTXT, bogus: true, mutate: func(m *dns.Msg) {
+			m.Ns = withoutOwner(m.Ns, strings.ToLower(dns.HashName("*.wild.hashed.", dns.SHA1, 1, "ab"))+".hashed.")
+		}},
+	}
+
+	for _, tt := range tests {
+		resp := tr.answer(t, dns.Question{Name: tt.name, Qtype: tt.qtype, Qclass: dns.ClassINET})
+		if tt.mutate != nil {
+			tt.mutate(resp)
+		}
+		secure, err := v.validate(resp)
+		if tt.bogus {
+			if err == nil {
+				t.Errorf("%s %s: expected bogus", tt.name, dns.TypeToString[tt.qtype])
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.name, dns.TypeToString[tt.qtype], err)
+			continue
+		}
+		if secure != tt.secure {
+			t.Errorf("%s %s: secure = %v, want %v", tt.name, dns.TypeToString[tt.qtype], secure, tt.secure)
+		}
+	}
+}
+
+// withoutOwner drops the records and signatures at owner.
+func withoutOwner(rrs []dns.RR, owner string) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, owner) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+func TestValidator_WrongAnchor(t *testing.T) {
+	tr, _ := newSignedTree(t)
+	_, anchor := newSignedTree(t)
+
+	v, err := newValidator([]string{anchor}, "", tr.query(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := tr.answer(t, dns.Question{Name: "www.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if _, err := v.validate(resp); err == nil {
+		t.Error("expected validation to fail against an unrelated trust anchor")
+	}
+}
+
+func TestValidator_DefaultAnchors(t *testing.T) {
+	v, err := newValidator(nil, "", nil)
+	if err != nil {
+		t.Fatalf("newValidator failed: %v", err)
+	}
+	if len(v.anchors["."]) != len(defaultTrustAnchors) {
+		t.Errorf("expected root anchors, got %v", v.anchors)
+	}
+	if _, err := newValidator([]string{"example. 3600 IN A 192.0.2.1"}, "", nil); err == nil {
+		t.Error("expected error for non-DS/DNSKEY anchor")
+	}
+}
+
+func TestForwarder_DNSSEC(t *testing.T) {
+	tr, anchor := newSignedTree(t)
+
+	started := make(chan struct{})
+	srv := &dns.Server{Addr: "127.0.0.1:0", Net: "udp", NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			if !r.CheckingDisabled {
+				t.Errorf("validator queries must set CD")
+			}
+			resp := tr.answer(t, r.Question[0])
+			resp.Id = r.Id
+			w.WriteMsg(resp)
+		}),
+	}
+	go srv.ListenAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	s, err := newTestService(&config.DNSServer{
+		Enabled:      true,
+		Forwarders:   []string{srv.PacketConn.LocalAddr().String()},
+		DNSSEC:       true,
+		TrustAnchors: []string{anchor},
+	})
+	if err != nil {
+		t.Fatalf("newTestService failed: %v", err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	hub := events.NewHub()
+	sub := hub.Subscribe(16, events.EventDNSSEC)
+	s.SetEventHub(hub)
+
+	// Failures also reach the UI through the WebSocket bridge
+	topics := make(chan string, 16)
+	bridge := events.NewWSBridge(hub, func(topic string, _ any) { topics <- topic })
+	bridge.Start()
+	t.Cleanup(bridge.Stop)
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &captureWriter{}
+		s.ServeDNS(w, req)
+		return w.msg
+	}
+
+	if resp := query("www.example.", dns.TypeA); resp.Rcode != dns.RcodeSuccess || !resp.AuthenticatedData {
+		t.Errorf("expected authenticated answer, got rcode %s AD=%v", dns.RcodeToString[resp.Rcode], resp.AuthenticatedData)
+	}
+	if resp := query("example.", dns.TypeDS); resp.Rcode != dns.RcodeSuccess || !resp.AuthenticatedData {
+		t.Errorf("expected authenticated DS answer, got rcode %s AD=%v", dns.RcodeToString[resp.Rcode], resp.AuthenticatedData)
+	}
+	if resp := query("host.insecure.", dns.TypeA); resp.Rcode != dns.RcodeSuccess || resp.AuthenticatedData {
+		t.Errorf("expected insecure answer without AD, got rcode %s AD=%v", dns.RcodeToString[resp.Rcode], resp.AuthenticatedData)
+	}
+
+	tr.mu.Lock()
+	tr.mutate = func(m *dns.Msg) {
+		if len(m.Answer) > 0 && m.Question[0].Name == "alias.example." {
+			m.Answer[0].(*dns.CNAME).Target = "evil.example."
+		}
+	}
+	tr.mu.Unlock()
+
+	if resp := query("alias.example.", dns.TypeA); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("expected SERVFAIL for bogus answer, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	select {
+	case e := <-sub:
+		data := e.Data.(events.DNSSECData)
+		if data.Domain != "alias.example" || data.Type != "A" {
+			t.Errorf("unexpected event payload %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected DNSSEC failure event")
+	}
+	select {
+	case topic := <-topics:
+		if topic != "dns" {
+			t.Errorf("expected DNSSEC failure on the dns topic, got %q", topic)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected DNSSEC failure on the WebSocket bridge")
+	}
+
+	// Clients setting CD get the raw answer
+	req := new(dns.Msg)
+	req.SetQuestion("alias.example.", dns.TypeA)
+	req.CheckingDisabled = true
+	w := &captureWriter{}
+	s.ServeDNS(w, req)
+	if w.msg.Rcode != dns.RcodeSuccess {
+		t.Errorf("expected unvalidated answer with CD set, got %s", dns.RcodeToString[w.msg.Rcode])
+	}
+
+	// ...but that answer must not be served from cache to validating clients
+	if resp := query("alias.example.", dns.TypeA); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("expected SERVFAIL after a CD query, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
@@ -0,0 +1,411 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/acme"
+	"grimm.is/glacic/internal/brand"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/pki"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDoTPort  = "853"
+	defaultDoHPort  = "443"
+	defaultDoHPath  = "/dns-query"
+	dohContentType  = "application/dns-message"
+	maxDoHBodyBytes = 65535
+)
+
+// encryptedListener describes a DoT or DoH listener and its certificate source.
+type encryptedListener struct {
+	addr           string // host:port
+	path           string // DoH only
+	certFile       string
+	keyFile        string
+	useLetsEncrypt bool
+}
+
+// dohServer pairs an HTTP server with its (TLS-less) listener.
+type dohServer struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// dotListeners expands a dot_server block into listeners, binding to each
+// zone address on port 853 when no listen_addr is given.
+func dotListeners(cfg *config.DoTServerConfig, zoneAddrs []string) []encryptedListener {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	var out []encryptedListener
+	for _, addr := range listenAddrsFor(cfg.ListenAddr, zoneAddrs, defaultDoTPort) {
+		out = append(out, encryptedListener{addr: addr, certFile: cfg.CertFile, keyFile: cfg.KeyFile})
+	}
+	return out
+}
+
+// dohListeners expands a doh_server block into listeners, binding to each
+// zone address on port 443 when no listen_addr is given.
+func dohListeners(cfg *config.DoHServerConfig, zoneAddrs []string) []encryptedListener {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	path := cfg.Path
+	if path == "" {
+		path = defaultDoHPath
+	}
+	var out []encryptedListener
+	for _, addr := range listenAddrsFor(cfg.ListenAddr, zoneAddrs, defaultDoHPort) {
+		out = append(out, encryptedListener{
+			addr:           addr,
+			path:           path,
+			certFile:       cfg.CertFile,
+			keyFile:        cfg.KeyFile,
+			useLetsEncrypt: cfg.UseLetsEncrypt,
+		})
+	}
+	return out
+}
+
+func listenAddrsFor(listenAddr string, zoneAddrs []string, port string) []string {
+	if listenAddr != "" {
+		if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+			return []string{net.JoinHostPort(listenAddr, port)}
+		}
+		return []string{listenAddr}
+	}
+	var addrs []string
+	for _, ip := range zoneAddrs {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs
+}
+
+// buildEncryptedServers binds DoT and DoH listeners. DoT is served by the
+// miekg server over a TLS listener; DoH by net/http. Both feed ServeDNS.
+func (s *Service) buildEncryptedServers(dot, doh []encryptedListener) ([]*dns.Server, []*dohServer) {
+	var dotServers []*dns.Server
+	for _, l := range dot {
+		tlsCfg, err := s.tlsConfigFor(l, "dot")
+		if err != nil {
+			log.Printf("[DNS] DoT %s disabled: %v", l.addr, err)
+			continue
+		}
+		ln := s.listenTCP("dns-dot-"+l.addr, l.addr)
+		if ln == nil {
+			continue
+		}
+		dotServers = append(dotServers, &dns.Server{
+			Listener: tls.NewListener(ln, tlsCfg),
+			Addr:     ln.Addr().String(),
+			Net:      "tcp-tls",
+			Handler:  s,
+		})
+	}
+
+	var dohServers []*dohServer
+	for _, l := range doh {
+		tlsCfg, err := s.tlsConfigFor(l, "h2", "http/1.1")
+		if err != nil {
+			log.Printf("[DNS] DoH %s disabled: %v", l.addr, err)
+			continue
+		}
+		ln := s.listenTCP("dns-doh-"+l.addr, l.addr)
+		if ln == nil {
+			continue
+		}
+		mux := http.NewServeMux()
+		mux.Handle(l.path, &dohHandler{svc: s})
+		dohServers = append(dohServers, &dohServer{
+			srv: &http.Server{
+				Handler:           mux,
+				TLSConfig:         tlsCfg,
+				ReadHeaderTimeout: 10 * time.Second,
+				IdleTimeout:       2 * time.Minute,
+			},
+			ln: ln,
+		})
+	}
+	return dotServers, dohServers
+}
+
+// listenTCP binds addr, reusing a socket inherited through an upgrade.
+func (s *Service) listenTCP(name, addr string) net.Listener {
+	if s.upgradeMgr != nil {
+		if existing, ok := s.upgradeMgr.GetListener(name); ok {
+			log.Printf("[DNS] Inherited TCP listener %s", name)
+			return existing
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[DNS] Failed to bind TCP %s: %v", addr, err)
+		return nil
+	}
+	if s.upgradeMgr != nil {
+		s.upgradeMgr.RegisterListener(name, ln)
+	}
+	return ln
+}
+
+// listenUDP binds addr, reusing a socket inherited through an upgrade.
+func (s *Service) listenUDP(name, addr string) net.PacketConn {
+	if s.upgradeMgr != nil {
+		if existing, ok := s.upgradeMgr.GetPacketConn(name); ok {
+			log.Printf("[DNS] Inherited UDP socket %s", name)
+			return existing
+		}
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("[DNS] Failed to bind UDP %s: %v", addr, err)
+		return nil
+	}
+	if s.upgradeMgr != nil {
+		s.upgradeMgr.RegisterPacketConn(name, pc)
+	}
+	return pc
+}
+
+// tlsConfigFor returns a TLS config serving the configured certificate,
+// or the router's PKI certificate when no files are given. Listeners using
+// Let's Encrypt serve the PKI certificate until the ACME one is issued.
+func (s *Service) tlsConfigFor(l encryptedListener, protos ...string) (*tls.Config, error) {
+	certFile, keyFile := l.certFile, l.keyFile
+	var acmeLoader *certReloader
+	if certFile == "" || keyFile == "" {
+		if l.useLetsEncrypt {
+			s.mu.RLock()
+			acmeDir := s.acmeDir
+			s.mu.RUnlock()
+			acmeCert, acmeKey := acme.CertPaths(acmeDir, acme.DoHCertificate)
+			acmeLoader = &certReloader{certFile: acmeCert, keyFile: acmeKey}
+			if _, err := acmeLoader.GetCertificate(nil); err != nil {
+				log.Printf("[DNS] Let's Encrypt certificate for %s not issued yet, using PKI certificate", l.addr)
+			}
+		}
+		certDir := filepath.Join(brand.GetStateDir(), "certs")
+		if err := pki.NewCertManager(certDir).EnsureCert(); err != nil {
+			return nil, fmt.Errorf("failed to ensure PKI certificate: %w", err)
+		}
+		certFile = filepath.Join(certDir, "cert.pem")
+		keyFile = filepath.Join(certDir, "key.pem")
+	}
+
+	loader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := loader.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	getCertificate := loader.GetCertificate
+	if acmeLoader != nil {
+		getCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, err := acmeLoader.GetCertificate(hello); err == nil {
+				return cert, nil
+			}
+			return loader.GetCertificate(hello)
+		}
+	}
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protos,
+	}, nil
+}
+
+// certReloader serves a certificate from disk, reloading it when the file
+// changes so PKI auto-renewal takes effect without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil && !info.ModTime().After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			log.Printf("[DNS] Failed to reload certificate %s: %v", c.certFile, err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return c.cert, nil
+}
+
+// dohHandler implements RFC 8484 DNS-over-HTTPS (GET and POST).
+type dohHandler struct {
+	svc *Service
+}
+
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var wire []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		wire, err = base64.RawURLEncoding.DecodeString(param)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		wire, err = io.ReadAll(io.LimitReader(r.Body, maxDoHBodyBytes))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(wire); err != nil {
+		http.Error(w, "malformed DNS message", http.StatusBadRequest)
+		return
+	}
+
+	rw := &captureWriter{local: localAddrFrom(r), remote: remoteAddrFrom(r)}
+	h.svc.ServeDNS(rw, req)
+	if rw.msg == nil {
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+
+	// RFC 8484 section 4.2.1: the ID should be zero on the wire
+	id := rw.msg.Id
+	rw.msg.Id = 0
+	packed, err := rw.msg.Pack()
+	rw.msg.Id = id
+	if err != nil {
+		http.Error(w, "failed to pack response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minAnswerTTL(rw.msg))))
+	w.Write(packed)
+}
+
+// minAnswerTTL returns the smallest TTL in the response, for HTTP caching.
+func minAnswerTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
+
+func localAddrFrom(r *http.Request) net.Addr {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func remoteAddrFrom(r *http.Request) net.Addr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+// captureWriter captures the reply produced by ServeDNS for transports that
+// frame responses themselves (DoH, DNSCrypt).
+type captureWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *captureWriter) LocalAddr() net.Addr  { return w.local }
+func (w *captureWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *captureWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *captureWriter) Close() error        { return nil }
+func (w *captureWriter) TsigStatus() error   { return nil }
+func (w *captureWriter) TsigTimersOnly(bool) {}
+func (w *captureWriter) Hijack()             {}
+
+// startDoH serves each DoH server in the background.
+func startDoH(servers []*dohServer) {
+	for _, d := range servers {
+		go func(d *dohServer) {
+			if err := d.srv.ServeTLS(d.ln, "", ""); err != nil && err != http.ErrServerClosed {
+				log.Printf("[DNS] DoH server %s error: %v", d.ln.Addr(), err)
+			}
+		}(d)
+	}
+}
+
+// stopDoH gracefully shuts down DoH servers.
+func stopDoH(servers []*dohServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, d := range servers {
+		if err := d.srv.Shutdown(ctx); err != nil {
+			log.Printf("[DNS] Failed to stop DoH server %s: %v", d.ln.Addr(), err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grimm.is/glacic/internal/acme"
+	"grimm.is/glacic/internal/brand"
+	"grimm.is/glacic/internal/config"
+	gtls "grimm.is/glacic/internal/tls"
+
+	"github.com/miekg/dns"
+)
+
+func newEncryptedTestService(t *testing.T) *Service {
+	t.Helper()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := gtls.GenerateSelfSigned(certFile, keyFile, 1); err != nil {
+		t.Fatalf("GenerateSelfSigned failed: %v", err)
+	}
+
+	cfg := &config.DNSServer{
+		Enabled:  true,
+		ListenOn: []string{"127.0.0.1"},
+		Hosts:    []config.DNSHostEntry{{IP: "192.168.1.10", Hostnames: []string{"nas.lan"}}},
+		DoTServer: &config.DoTServerConfig{
+			Enabled: true, ListenAddr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile,
+		},
+		DoHServer: &config.DoHServerConfig{
+			Enabled: true, ListenAddr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile,
+		},
+	}
+	s, err := newTestService(cfg)
+	if err != nil {
+		t.Fatalf("newTestService failed: %v", err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	s.UpdateBlockedDomains([]string{"ads.example.com"})
+	return s
+}
+
+func dotAddr(t *testing.T, s *Service) string {
+	t.Helper()
+	for _, srv := range s.servers {
+		if srv.Net == "tcp-tls" {
+			return srv.Addr
+		}
+	}
+	t.Fatal("no DoT server started")
+	return ""
+}
+
+func TestDoTServer(t *testing.T) {
+	s := newEncryptedTestService(t)
+
+	c := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("nas.lan.", dns.TypeA)
+	resp, _, err := c.Exchange(m, dotAddr(t, s))
+	if err != nil {
+		t.Fatalf("DoT exchange failed: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.168.1.10" {
+		t.Errorf("unexpected DoT answer: %v", resp.Answer)
+	}
+
+	// Blocklists apply to encrypted clients too
+	m.SetQuestion("ads.example.com.", dns.TypeA)
+	resp, _, err = c.Exchange(m, dotAddr(t, s))
+	if err != nil {
+		t.Fatalf("DoT exchange failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN for blocked domain, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDoHServer(t *testing.T) {
+	s := newEncryptedTestService(t)
+	if len(s.dohServers) != 1 {
+		t.Fatalf("expected 1 DoH server, got %d", len(s.dohServers))
+	}
+	url := "https://" + s.dohServers[0].ln.Addr().String() + defaultDoHPath
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("nas.lan.", dns.TypeA)
+	m.Id = 0
+	wire, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(t *testing.T, resp *http.Response) *dns.Msg {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != dohContentType {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		out := new(dns.Msg)
+		if err := out.Unpack(body); err != nil {
+			t.Fatalf("failed to unpack DoH response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("POST", func(t *testing.T) {
+		resp, err := client.Post(url, dohContentType, bytes.NewReader(wire))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		out := decode(t, resp)
+		if len(out.Answer) != 1 {
+			t.Errorf("expected 1 answer, got %v", out.Answer)
+		}
+	})
+
+	t.Run("GET", func(t *testing.T) {
+		resp, err := client.Get(url + "?dns=" + base64.RawURLEncoding.EncodeToString(wire))
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		out := decode(t, resp)
+		if len(out.Answer) != 1 {
+			t.Errorf("expected 1 answer, got %v", out.Answer)
+		}
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestTLSConfigForLetsEncrypt(t *testing.T) {
+	t.Setenv(brand.ConfigEnvPrefix+"_STATE_DIR", t.TempDir())
+	s := &Service{acmeDir: t.TempDir()}
+
+	tlsCfg, err := s.tlsConfigFor(encryptedListener{addr: "127.0.0.1:443", useLetsEncrypt: true})
+	if err != nil {
+		t.Fatalf("tlsConfigFor failed: %v", err)
+	}
+	pkiCert, err := tlsCfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("no fallback certificate: %v", err)
+	}
+
+	// Once the ACME certificate is issued it is served without a restart
+	certFile, keyFile := acme.CertPaths(s.acmeDir, acme.DoHCertificate)
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := gtls.GenerateSelfSigned(certFile, keyFile, 1); err != nil {
+		t.Fatalf("GenerateSelfSigned failed: %v", err)
+	}
+	acmeCert, err := tlsCfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if bytes.Equal(acmeCert.Certificate[0], pkiCert.Certificate[0]) {
+		t.Error("still serving the PKI certificate after issuance")
+	}
+	want, _ := tls.LoadX509KeyPair(certFile, keyFile)
+	if !bytes.Equal(acmeCert.Certificate[0], want.Certificate[0]) {
+		t.Error("not serving the ACME certificate")
+	}
+}
+
+func TestListenAddrsFor(t *testing.T) {
+	got := listenAddrsFor("", []string{"192.168.1.1", "fd00::1"}, defaultDoTPort)
+	want := []string{"192.168.1.1:853", net.JoinHostPort("fd00::1", "853")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := listenAddrsFor("10.0.0.1", nil, defaultDoHPort); got[0] != "10.0.0.1:443" {
+		t.Errorf("expected default port to be appended, got %v", got)
+	}
+}
@@ -0,0 +1,313 @@
+package dns
+
+import (
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// threatIntelSource marks domains pushed by the threat intel service;
+	// they stay blocked whatever profile a client uses.
+	threatIntelSource = "threatintel"
+
+	defaultBlockedTTL = 60
+	safeSearchTTL     = 300
+)
+
+// safeSearchTargets maps search and video hosts to the CNAME that forces
+// their safe mode.
+var safeSearchTargets = map[string]string{
+	"google.com.":               "forcesafesearch.google.com.",
+	"www.google.com.":           "forcesafesearch.google.com.",
+	"bing.com.":                 "strict.bing.com.",
+	"www.bing.com.":             "strict.bing.com.",
+	"duckduckgo.com.":           "safe.duckduckgo.com.",
+	"www.duckduckgo.com.":       "safe.duckduckgo.com.",
+	"youtube.com.":              "restrict.youtube.com.",
+	"www.youtube.com.":          "restrict.youtube.com.",
+	"m.youtube.com.":            "restrict.youtube.com.",
+	"youtubei.googleapis.com.":  "restrict.youtube.com.",
+	"youtube.googleapis.com.":   "restrict.youtube.com.",
+	"www.youtube-nocookie.com.": "restrict.youtube.com.",
+}
+
+// DeviceTagLookup resolves a MAC address to its device identity tags. The
+// device lookup passed to SetDeviceLookup may implement it to enable
+// tag-based profile assignment.
+type DeviceTagLookup interface {
+	LookupTags(mac string) []string
+}
+
+// filterProfile is the runtime form of a config.DNSFilterProfile.
+type filterProfile struct {
+	name        string
+	blocked     map[string]string // FQDN -> blocklist name
+	allow       []string          // FQDNs; subdomains match too
+	safeSearch  bool
+	blockedAddr net.IP
+	blockedTTL  uint32
+}
+
+type cidrProfile struct {
+	net     *net.IPNet
+	profile *filterProfile
+}
+
+// profileSet selects a filtering profile by client identity.
+type profileSet struct {
+	byIP   map[string]*filterProfile // DHCP reservation IP
+	byMAC  map[string]*filterProfile // DHCP reservation MAC
+	byTag  map[string]*filterProfile // Device identity tag
+	bySrc  []cidrProfile             // Profile sources
+	byZone []cidrProfile             // Serve block defaults (nil net = any)
+}
+
+// buildProfileSet loads all profiles and their assignments. It returns
+// nil when no profiles are configured.
+func buildProfileSet(cfg *config.Config) *profileSet {
+	if cfg.DNS == nil || len(cfg.DNS.FilterProfiles) == 0 {
+		return nil
+	}
+
+	profiles := make(map[string]*filterProfile)
+	set := &profileSet{
+		byIP:  make(map[string]*filterProfile),
+		byMAC: make(map[string]*filterProfile),
+		byTag: make(map[string]*filterProfile),
+	}
+
+	for _, pc := range cfg.DNS.FilterProfiles {
+		p := &filterProfile{
+			name:       pc.Name,
+			safeSearch: pc.SafeSearch,
+			blockedTTL: uint32(pc.BlockedTTL),
+		}
+		if p.blockedTTL == 0 {
+			p.blockedTTL = defaultBlockedTTL
+		}
+		if pc.BlockedAddress != "" {
+			p.blockedAddr = net.ParseIP(pc.BlockedAddress)
+		}
+		_, sources, err := loadBlocklistsFromConfig(pc.Blocklists)
+		if err != nil {
+			log.Printf("[DNS] Warning: errors loading blocklists for profile %s: %v", pc.Name, err)
+		}
+		p.blocked = sources
+		for _, d := range pc.Allowlist {
+			p.allow = append(p.allow, strings.ToLower(dns.Fqdn(d)))
+		}
+		profiles[pc.Name] = p
+
+		for _, tag := range pc.DeviceTags {
+			set.byTag[strings.ToLower(tag)] = p
+		}
+		for _, src := range pc.Sources {
+			if n := parseCIDROrIP(src); n != nil {
+				set.bySrc = append(set.bySrc, cidrProfile{net: n, profile: p})
+			}
+		}
+	}
+
+	if cfg.DHCP != nil {
+		for _, scope := range cfg.DHCP.Scopes {
+			for _, res := range scope.Reservations {
+				p := profiles[res.DNSProfile]
+				if p == nil {
+					continue
+				}
+				set.byMAC[strings.ToLower(res.MAC)] = p
+				if res.IP != "" {
+					set.byIP[res.IP] = p
+				}
+			}
+		}
+	}
+
+	for _, serve := range cfg.DNS.Serve {
+		p := profiles[serve.FilterProfile]
+		if p == nil {
+			continue
+		}
+		zone := strings.ToLower(serve.Zone)
+		if zone == "*" || zone == "any" {
+			set.byZone = append(set.byZone, cidrProfile{profile: p})
+			continue
+		}
+		for _, n := range zoneNetworks(cfg, zone) {
+			set.byZone = append(set.byZone, cidrProfile{net: n, profile: p})
+		}
+	}
+
+	return set
+}
+
+// selectProfile returns the profile for a client, or nil to use the
+// serve block's filtering.
+func (ps *profileSet) selectProfile(ip string, devices MACLookup) *filterProfile {
+	if p := ps.byIP[ip]; p != nil {
+		return p
+	}
+
+	if devices != nil && (len(ps.byMAC) > 0 || len(ps.byTag) > 0) {
+		if mac := strings.ToLower(devices.LookupMAC(ip)); mac != "" {
+			if p := ps.byMAC[mac]; p != nil {
+				return p
+			}
+			if tl, ok := devices.(DeviceTagLookup); ok {
+				for _, tag := range tl.LookupTags(mac) {
+					if p := ps.byTag[strings.ToLower(tag)]; p != nil {
+						return p
+					}
+				}
+			}
+		}
+	}
+
+	addr := net.ParseIP(ip)
+	if p := matchCIDR(ps.bySrc, addr); p != nil {
+		return p
+	}
+	return matchCIDR(ps.byZone, addr)
+}
+
+// matchCIDR returns the profile of the most specific network containing
+// addr. An entry without a network matches any address.
+func matchCIDR(entries []cidrProfile, addr net.IP) *filterProfile {
+	var best *filterProfile
+	bestLen := -1
+	for _, e := range entries {
+		if e.net == nil {
+			if bestLen < 0 {
+				best = e.profile
+				bestLen = 0
+			}
+			continue
+		}
+		if addr == nil || !e.net.Contains(addr) {
+			continue
+		}
+		if ones, _ := e.net.Mask.Size(); ones > bestLen {
+			best, bestLen = e.profile, ones
+		}
+	}
+	return best
+}
+
+// allowed reports whether name or one of its parents is allowlisted.
+func (p *filterProfile) allowed(name string) bool {
+	for _, a := range p.allow {
+		if name == a || strings.HasSuffix(name, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedAnswer builds the reply to a query the profile blocks: the
+// configured blocked address for A/AAAA, NXDOMAIN otherwise.
+func (p *filterProfile) blockedAnswer(r *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	q := r.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: p.blockedTTL}
+
+	switch {
+	case p.blockedAddr != nil && q.Qtype == dns.TypeA && p.blockedAddr.To4() != nil:
+		msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: p.blockedAddr.To4()})
+	case p.blockedAddr != nil && q.Qtype == dns.TypeAAAA && p.blockedAddr.To4() == nil:
+		msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: p.blockedAddr})
+	case p.blockedAddr != nil:
+		// NODATA for the other address family or record types
+	default:
+		msg.Rcode = dns.RcodeNameError
+	}
+	return msg
+}
+
+// serveSafeSearch answers with a CNAME to the safe variant of a search
+// host, followed by the resolved target records.
+func (s *Service) serveSafeSearch(w dns.ResponseWriter, r *dns.Msg, target string) {
+	q := r.Question[0]
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Answer = append(msg.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: safeSearchTTL},
+		Target: target,
+	})
+
+	req := new(dns.Msg)
+	req.SetQuestion(target, q.Qtype)
+	req.RecursionDesired = true
+	cw := &captureWriter{local: w.LocalAddr(), remote: w.RemoteAddr()}
+	s.resolve(cw, req)
+	if cw.msg != nil {
+		msg.Rcode = cw.msg.Rcode
+		msg.Answer = append(msg.Answer, cw.msg.Answer...)
+	}
+	noteUpstream(w, "safesearch")
+	w.WriteMsg(msg)
+}
+
+// zoneNetworks returns the client networks of the firewall zones matching
+// a serve block's zone pattern: their interface subnets and source CIDRs.
+func zoneNetworks(cfg *config.Config, pattern string) []*net.IPNet {
+	match := func(zone string) bool {
+		ok, err := filepath.Match(pattern, strings.ToLower(zone))
+		return err == nil && ok
+	}
+
+	var cidrs []string
+	ifaces := make(map[string]bool)
+	for _, z := range cfg.Zones {
+		if !match(z.Name) {
+			continue
+		}
+		ifaces[z.Interface] = true
+		for _, name := range z.Interfaces {
+			ifaces[name] = true
+		}
+		for _, m := range z.Matches {
+			ifaces[m.Interface] = true
+			cidrs = append(cidrs, m.Src)
+		}
+		cidrs = append(cidrs, z.Src)
+		cidrs = append(cidrs, z.Networks...)
+		cidrs = append(cidrs, z.IPv4...)
+		cidrs = append(cidrs, z.IPv6...)
+	}
+	for _, iface := range cfg.Interfaces {
+		if ifaces[iface.Name] || (iface.Zone != "" && match(iface.Zone)) {
+			cidrs = append(cidrs, iface.IPv4...)
+			cidrs = append(cidrs, iface.IPv6...)
+		}
+	}
+
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// parseCIDROrIP parses a CIDR, treating a bare IP as a host network.
+func parseCIDROrIP(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
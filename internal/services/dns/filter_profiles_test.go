@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+
+	"github.com/miekg/dns"
+)
+
+type fakeDevices struct {
+	macs map[string]string
+	tags map[string][]string
+}
+
+func (f *fakeDevices) LookupMAC(ip string) string     { return f.macs[ip] }
+func (f *fakeDevices) LookupTags(mac string) []string { return f.tags[mac] }
+
+func TestFilterProfiles_SelectByIdentity(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{{Name: "eth1", Zone: "lan", IPv4: []string{"192.168.1.1/24"}}},
+		DNS: &config.DNS{
+			FilterProfiles: []config.DNSFilterProfile{
+				{Name: "kids", DeviceTags: []string{"Kids"}},
+				{Name: "iot", Sources: []string{"192.168.1.128/25"}},
+				{Name: "adults"},
+			},
+			Serve: []config.DNSServe{{Zone: "lan", FilterProfile: "adults"}},
+		},
+		DHCP: &config.DHCPServer{Scopes: []config.DHCPScope{{
+			Reservations: []config.DHCPReservation{{MAC: "AA:BB:CC:00:00:01", IP: "192.168.1.200", DNSProfile: "kids"}},
+		}}},
+	}
+	ps := buildProfileSet(cfg)
+	devices := &fakeDevices{
+		macs: map[string]string{"192.168.1.50": "aa:bb:cc:00:00:02", "192.168.1.51": "aa:bb:cc:00:00:01"},
+		tags: map[string][]string{"aa:bb:cc:00:00:02": {"kids"}},
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.168.1.200", "kids"}, // Reservation IP beats source CIDR
+		{"192.168.1.51", "kids"},  // Reservation MAC
+		{"192.168.1.50", "kids"},  // Device tag
+		{"192.168.1.130", "iot"},  // Source CIDR
+		{"192.168.1.10", "adults"},
+		{"10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := ps.selectProfile(tt.ip, devices); p != nil {
+			got = p.name
+		}
+		if got != tt.want {
+			t.Errorf("selectProfile(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestFilterProfiles_Filtering(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "kids.txt")
+	if err := os.WriteFile(list, []byte("games.example\nvideo.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DNSServer: &config.DNSServer{
+			Enabled: true,
+			Hosts: []config.DNSHostEntry{
+				{IP: "192.168.1.10", Hostnames: []string{"games.example", "video.example", "safe.example"}},
+				{IP: "216.239.38.120", Hostnames: []string{"forcesafesearch.google.com"}},
+			},
+		},
+		DNS: &config.DNS{FilterProfiles: []config.DNSFilterProfile{{
+			Name:           "kids",
+			Blocklists:     []config.DNSBlocklist{{Name: "kids-list", File: list, Enabled: true}},
+			Allowlist:      []string{"video.example"},
+			SafeSearch:     true,
+			BlockedAddress: "0.0.0.0",
+			Sources:        []string{"192.168.1.50"},
+		}}},
+	}
+	s := NewService(cfg, logging.New(logging.DefaultConfig()))
+	if _, err := s.Reload(cfg); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	defer s.Stop(context.Background())
+	s.UpdateBlockedDomains([]string{"malware.example"})
+
+	query := func(client, name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &captureWriter{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 5353}}
+		s.ServeDNS(w, req)
+		return w.msg
+	}
+
+	// Blocked domains answer with the blocked address
+	resp := query("192.168.1.50", "games.example.", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "0.0.0.0" || resp.Answer[0].Header().Ttl != defaultBlockedTTL {
+		t.Errorf("expected blocked address answer, got %v", resp)
+	}
+	if resp := query("192.168.1.50", "games.example.", dns.TypeAAAA); resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Errorf("expected NODATA for AAAA with an IPv4 blocked address, got %v", resp)
+	}
+
+	// Allowlisted and unprofiled clients resolve normally
+	if resp := query("192.168.1.50", "video.example.", dns.TypeA); resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.168.1.10" {
+		t.Errorf("allowlisted domain was blocked: %v", resp)
+	}
+	if resp := query("192.168.1.60", "games.example.", dns.TypeA); resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.168.1.10" {
+		t.Errorf("client without a profile was filtered: %v", resp)
+	}
+
+	// Threat intel applies whatever the profile
+	if resp := query("192.168.1.50", "malware.example.", dns.TypeA); resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected threat intel NXDOMAIN, got %v", resp)
+	}
+
+	// Safe search rewrites to the enforced host
+	resp = query("192.168.1.50", "www.google.com.", dns.TypeA)
+	if resp == nil || len(resp.Answer) != 2 {
+		t.Fatalf("expected CNAME and A, got %v", resp)
+	}
+	if cname, ok := resp.Answer[0].(*dns.CNAME); !ok || cname.Target != "forcesafesearch.google.com." {
+		t.Errorf("expected safe search CNAME, got %v", resp.Answer[0])
+	}
+	if a, ok := resp.Answer[1].(*dns.A); !ok || a.A.String() != "216.239.38.120" {
+		t.Errorf("expected resolved safe search target, got %v", resp.Answer[1])
+	}
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"strings"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/services/dns/querylog"
+
+	"github.com/miekg/dns"
+)
+
+// MACLookup resolves a client IP to its hardware address for the query log
+// and filtering profile selection.
+type MACLookup interface {
+	LookupMAC(ip string) string
+}
+
+// SetQueryLog sets the store that receives per-client query records
+func (s *Service) SetQueryLog(store *querylog.Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queryLog = store
+}
+
+// SetDeviceLookup sets the IP to MAC resolver used by the query log and
+// filtering profiles
+func (s *Service) SetDeviceLookup(lookup MACLookup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices = lookup
+}
+
+// setQueryLoggingLocked applies the query_logging flag and retention.
+// Caller must hold s.mu.
+func (s *Service) setQueryLoggingLocked(enabled bool, retentionDays int) {
+	s.queryLogging = enabled
+	if s.queryLog != nil {
+		s.queryLog.SetRetention(time.Duration(retentionDays) * 24 * time.Hour)
+	}
+}
+
+// queryLogWriter wraps a ResponseWriter to record the outcome of a query.
+// ServeDNS and the forwarder annotate it with the answer source.
+type queryLogWriter struct {
+	dns.ResponseWriter
+	store   *querylog.Store
+	devices MACLookup
+	start   time.Time
+	req     *dns.Msg
+	rcode   int
+	written bool
+
+	upstream  string
+	blocked   bool
+	blocklist string
+}
+
+// newQueryLogWriter returns a logging wrapper when query logging is
+// enabled, or nil.
+func (s *Service) newQueryLogWriter(w dns.ResponseWriter, r *dns.Msg) *queryLogWriter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.queryLogging || s.queryLog == nil || len(r.Question) == 0 {
+		return nil
+	}
+	return &queryLogWriter{
+		ResponseWriter: w,
+		store:          s.queryLog,
+		devices:        s.devices,
+		start:          clock.Now(),
+		req:            r,
+	}
+}
+
+func (w *queryLogWriter) WriteMsg(m *dns.Msg) error {
+	w.rcode = m.Rcode
+	w.written = true
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// record stores the entry once the handler has finished.
+func (w *queryLogWriter) record() {
+	q := w.req.Question[0]
+	rcode := "SERVFAIL"
+	if w.written {
+		rcode = dns.RcodeToString[w.rcode]
+	}
+
+	e := querylog.Entry{
+		Timestamp: w.start,
+		Domain:    strings.ToLower(strings.TrimSuffix(q.Name, ".")),
+		Type:      dns.TypeToString[q.Qtype],
+		Rcode:     rcode,
+		Upstream:  w.upstream,
+		LatencyMs: float64(clock.Now().Sub(w.start).Microseconds()) / 1000,
+		Blocked:   w.blocked,
+		Blocklist: w.blocklist,
+	}
+	e.ClientIP = clientIP(w)
+	if w.devices != nil && e.ClientIP != "" {
+		e.ClientMAC = w.devices.LookupMAC(e.ClientIP)
+	}
+	w.store.Record(e)
+}
+
+// noteUpstream records where an answer came from when w is logging.
+func noteUpstream(w dns.ResponseWriter, upstream string) {
+	if lw, ok := w.(*queryLogWriter); ok {
+		lw.upstream = upstream
+	}
+}
+
+// noteBlocked records the blocklist responsible for a blocked query.
+func noteBlocked(w dns.ResponseWriter, blocklist string) {
+	if lw, ok := w.(*queryLogWriter); ok {
+		lw.blocked = true
+		lw.blocklist = blocklist
+	}
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/services/dns/querylog"
+
+	"github.com/miekg/dns"
+)
+
+type fakeMACLookup map[string]string
+
+func (f fakeMACLookup) LookupMAC(ip string) string { return f[ip] }
+
+func TestQueryLog(t *testing.T) {
+	store, err := querylog.NewStore(filepath.Join(t.TempDir(), "queries.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	t.Cleanup(store.Stop)
+
+	s, err := newTestService(&config.DNSServer{
+		Enabled:      true,
+		QueryLogging: true,
+		Hosts:        []config.DNSHostEntry{{IP: "192.168.1.10", Hostnames: []string{"nas.lan"}}},
+	})
+	if err != nil {
+		t.Fatalf("newTestService failed: %v", err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+	s.SetQueryLog(store)
+	s.SetDeviceLookup(fakeMACLookup{"192.168.1.77": "AA:BB:CC:00:11:22"})
+	s.UpdateBlockedDomains([]string{"ads.example.com"})
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.77"), Port: 40000}
+	query := func(name string, qtype uint16) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		s.ServeDNS(&captureWriter{remote: client}, req)
+	}
+	query("nas.lan.", dns.TypeA)
+	query("ads.example.com.", dns.TypeA)
+	store.Flush()
+
+	entries, total, err := store.Query(querylog.Filter{ClientMAC: "aa:bb:cc:00:11:22"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 logged queries, got %d", total)
+	}
+
+	blocked, local := entries[0], entries[1]
+	if !blocked.Blocked || blocked.Blocklist != "threatintel" || blocked.Rcode != "NXDOMAIN" || blocked.Domain != "ads.example.com" {
+		t.Errorf("unexpected blocked entry: %+v", blocked)
+	}
+	if local.Upstream != "local" || local.Rcode != "NOERROR" || local.ClientIP != "192.168.1.77" || local.Type != "A" {
+		t.Errorf("unexpected local entry: %+v", local)
+	}
+
+	// Nothing is recorded once query_logging is turned off
+	s.mu.Lock()
+	s.setQueryLoggingLocked(false, 0)
+	s.mu.Unlock()
+	query("nas.lan.", dns.TypeA)
+	store.Flush()
+	if _, total, _ := store.Query(querylog.Filter{}); total != 2 {
+		t.Errorf("expected no new entries with logging disabled, got %d total", total)
+	}
+}
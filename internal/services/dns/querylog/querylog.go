@@ -0,0 +1,320 @@
+// Package querylog stores per-client DNS query records in SQLite.
+package querylog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// DefaultRetention is how long queries are kept when not configured.
+	DefaultRetention = 7 * 24 * time.Hour
+
+	// maxBuffered bounds memory use when SQLite falls behind; further
+	// entries are dropped until the next flush.
+	maxBuffered = 10000
+
+	flushInterval   = time.Second
+	janitorInterval = time.Hour
+)
+
+// Entry is a single logged DNS query.
+type Entry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	ClientIP  string    `json:"client_ip"`
+	ClientMAC string    `json:"client_mac,omitempty"`
+	Domain    string    `json:"domain"`
+	Type      string    `json:"type"`
+	Rcode     string    `json:"rcode"`
+	Upstream  string    `json:"upstream,omitempty"` // Forwarder address, or "cache", "local", "recursive"
+	LatencyMs float64   `json:"latency_ms"`
+	Blocked   bool      `json:"blocked,omitempty"`
+	Blocklist string    `json:"blocklist,omitempty"`
+}
+
+// Filter selects entries for Query. Zero values match everything.
+type Filter struct {
+	ClientIP  string    `json:"client_ip,omitempty"`
+	ClientMAC string    `json:"client_mac,omitempty"`
+	Domain    string    `json:"domain,omitempty"` // Substring match
+	Type      string    `json:"type,omitempty"`
+	Rcode     string    `json:"rcode,omitempty"`
+	Blocked   *bool     `json:"blocked,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	AfterID   int64     `json:"after_id,omitempty"` // Only entries newer than this ID
+	Limit     int       `json:"limit,omitempty"`
+	Offset    int       `json:"offset,omitempty"`
+}
+
+// Store buffers query entries and writes them to SQLite in batches. A
+// janitor removes entries older than the retention period.
+type Store struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	buffer    []Entry
+	dropped   uint64
+	retention time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewStore opens (or creates) the query log database at dbPath.
+func NewStore(dbPath string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0750); err != nil {
+		return nil, fmt.Errorf("create query log dir: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("open query log db: %w", err)
+	}
+	// SQLite allows a single writer; serialising avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS dns_queries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			client_ip TEXT NOT NULL,
+			client_mac TEXT,
+			domain TEXT NOT NULL,
+			qtype TEXT NOT NULL,
+			rcode TEXT NOT NULL,
+			upstream TEXT,
+			latency_us INTEGER DEFAULT 0,
+			blocked INTEGER DEFAULT 0,
+			blocklist TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_dns_queries_ts ON dns_queries(timestamp);
+		CREATE INDEX IF NOT EXISTS idx_dns_queries_client ON dns_queries(client_ip);
+		CREATE INDEX IF NOT EXISTS idx_dns_queries_domain ON dns_queries(domain);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create query log table: %w", err)
+	}
+
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Store{
+		db:        db,
+		buffer:    make([]Entry, 0, 256),
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Start begins the flush and janitor loops.
+func (s *Store) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		flush := time.NewTicker(flushInterval)
+		defer flush.Stop()
+		janitor := time.NewTicker(janitorInterval)
+		defer janitor.Stop()
+
+		s.runJanitor()
+		for {
+			select {
+			case <-s.ctx.Done():
+				s.Flush() // Final flush on shutdown
+				return
+			case <-flush.C:
+				s.Flush()
+			case <-janitor.C:
+				s.runJanitor()
+			}
+		}
+	}()
+}
+
+// Stop flushes pending entries and closes the database.
+func (s *Store) Stop() {
+	s.cancel()
+	s.wg.Wait()
+	s.db.Close()
+}
+
+// SetRetention changes how long entries are kept.
+func (s *Store) SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = DefaultRetention
+	}
+	s.mu.Lock()
+	s.retention = d
+	s.mu.Unlock()
+}
+
+// Record queues an entry for the next flush. It never blocks on disk.
+func (s *Store) Record(e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buffer) >= maxBuffered {
+		s.dropped++
+		return
+	}
+	e.ClientMAC = strings.ToLower(e.ClientMAC)
+	s.buffer = append(s.buffer, e)
+}
+
+// Flush writes buffered entries to SQLite.
+func (s *Store) Flush() {
+	s.mu.Lock()
+	if len(s.buffer) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	toFlush := s.buffer
+	s.buffer = make([]Entry, 0, 256)
+	if s.dropped > 0 {
+		log.Printf("[DNS] Query log dropped %d entries (buffer full)", s.dropped)
+		s.dropped = 0
+	}
+	s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("[DNS] Query log: failed to begin transaction: %v", err)
+		return
+	}
+	stmt, err := tx.Prepare(`INSERT INTO dns_queries
+		(timestamp, client_ip, client_mac, domain, qtype, rcode, upstream, latency_us, blocked, blocklist)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("[DNS] Query log: failed to prepare statement: %v", err)
+		return
+	}
+	defer stmt.Close()
+
+	for _, e := range toFlush {
+		_, err := stmt.Exec(e.Timestamp.UnixMicro(), e.ClientIP, e.ClientMAC, e.Domain, e.Type, e.Rcode,
+			e.Upstream, int64(e.LatencyMs*1000), e.Blocked, e.Blocklist)
+		if err != nil {
+			log.Printf("[DNS] Query log: failed to insert: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[DNS] Query log: failed to commit: %v", err)
+	}
+}
+
+// runJanitor deletes entries older than the retention period.
+func (s *Store) runJanitor() {
+	s.mu.Lock()
+	cutoff := time.Now().Add(-s.retention).UnixMicro()
+	s.mu.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM dns_queries WHERE timestamp < ?`, cutoff)
+	if err != nil {
+		log.Printf("[DNS] Query log cleanup failed: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[DNS] Query log cleanup removed %d entries", n)
+	}
+}
+
+// Query returns entries matching the filter, newest first, along with
+// the total number of matches for paging.
+func (s *Store) Query(f Filter) ([]Entry, int64, error) {
+	var where []string
+	var args []any
+
+	if f.ClientIP != "" {
+		where = append(where, "client_ip = ?")
+		args = append(args, f.ClientIP)
+	}
+	if f.ClientMAC != "" {
+		where = append(where, "client_mac = ?")
+		args = append(args, strings.ToLower(f.ClientMAC))
+	}
+	if f.Domain != "" {
+		where = append(where, "domain LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(strings.ToLower(f.Domain))+"%")
+	}
+	if f.Type != "" {
+		where = append(where, "qtype = ?")
+		args = append(args, strings.ToUpper(f.Type))
+	}
+	if f.Rcode != "" {
+		where = append(where, "rcode = ?")
+		args = append(args, strings.ToUpper(f.Rcode))
+	}
+	if f.Blocked != nil {
+		where = append(where, "blocked = ?")
+		args = append(args, *f.Blocked)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, f.Since.UnixMicro())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, f.Until.UnixMicro())
+	}
+	if f.AfterID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, f.AfterID)
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int64
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM dns_queries`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count queries: %w", err)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(`SELECT id, timestamp, client_ip, client_mac, domain, qtype, rcode, upstream, latency_us, blocked, blocklist
+		FROM dns_queries`+clause+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query queries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var ts, latency int64
+		var mac, upstream, blocklist sql.NullString
+		if err := rows.Scan(&e.ID, &ts, &e.ClientIP, &mac, &e.Domain, &e.Type, &e.Rcode, &upstream, &latency, &e.Blocked, &blocklist); err != nil {
+			return nil, 0, err
+		}
+		e.Timestamp = time.UnixMicro(ts)
+		e.ClientMAC, e.Upstream, e.Blocklist = mac.String, upstream.String, blocklist.String
+		e.LatencyMs = float64(latency) / 1000
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
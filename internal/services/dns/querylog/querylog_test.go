@@ -0,0 +1,88 @@
+package querylog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "queries.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func TestStore_RecordAndQuery(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	s.Record(Entry{Timestamp: now.Add(-time.Minute), ClientIP: "192.168.1.50", ClientMAC: "aa:bb:cc:dd:ee:ff", Domain: "netflix.com", Type: "A", Rcode: "NOERROR", Upstream: "1.1.1.1:53", LatencyMs: 12.5})
+	s.Record(Entry{Timestamp: now, ClientIP: "192.168.1.50", ClientMAC: "aa:bb:cc:dd:ee:ff", Domain: "ads.tracker.net", Type: "A", Rcode: "NXDOMAIN", Blocked: true, Blocklist: "ads"})
+	s.Record(Entry{Timestamp: now, ClientIP: "192.168.1.60", Domain: "nas.lan", Type: "AAAA", Rcode: "NOERROR", Upstream: "local"})
+	s.Flush()
+
+	entries, total, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if total != 3 || len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d (total %d)", len(entries), total)
+	}
+	if entries[0].Domain != "nas.lan" {
+		t.Errorf("expected newest first, got %s", entries[0].Domain)
+	}
+
+	blocked := true
+	entries, _, _ = s.Query(Filter{ClientIP: "192.168.1.50", Blocked: &blocked})
+	if len(entries) != 1 || entries[0].Blocklist != "ads" {
+		t.Errorf("unexpected blocked entries: %+v", entries)
+	}
+
+	entries, _, _ = s.Query(Filter{Domain: "flix"})
+	if len(entries) != 1 || entries[0].LatencyMs != 12.5 || entries[0].ClientMAC != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("unexpected domain search result: %+v", entries)
+	}
+
+	// LIKE wildcards in the search term are literal
+	if entries, _, _ = s.Query(Filter{Domain: "_"}); len(entries) != 0 {
+		t.Errorf("expected no match for literal underscore, got %d", len(entries))
+	}
+
+	entries, total, _ = s.Query(Filter{Limit: 1, Offset: 1})
+	if len(entries) != 1 || total != 3 {
+		t.Errorf("expected one paged entry of 3, got %d of %d", len(entries), total)
+	}
+
+	all, _, _ := s.Query(Filter{})
+	entries, _, _ = s.Query(Filter{AfterID: all[1].ID})
+	if len(entries) != 1 || entries[0].ID != all[0].ID {
+		t.Errorf("expected only entries after ID %d, got %+v", all[1].ID, entries)
+	}
+}
+
+func TestStore_Retention(t *testing.T) {
+	s := newTestStore(t)
+	s.Record(Entry{Timestamp: time.Now().Add(-2 * time.Hour), ClientIP: "10.0.0.1", Domain: "old.example", Type: "A", Rcode: "NOERROR"})
+	s.Record(Entry{Timestamp: time.Now(), ClientIP: "10.0.0.1", Domain: "new.example", Type: "A", Rcode: "NOERROR"})
+	s.Flush()
+
+	s.runJanitor()
+	entries, _, _ := s.Query(Filter{})
+	if len(entries) != 1 || entries[0].Domain != "new.example" {
+		t.Errorf("expected only recent entry to survive, got %+v", entries)
+	}
+}
+
+func TestStore_BufferLimit(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < maxBuffered+5; i++ {
+		s.Record(Entry{Timestamp: time.Now(), ClientIP: "10.0.0.1", Domain: "x.example", Type: "A", Rcode: "NOERROR"})
+	}
+	if s.dropped != 5 {
+		t.Errorf("expected 5 dropped entries, got %d", s.dropped)
+	}
+}
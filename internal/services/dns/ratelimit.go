@@ -0,0 +1,254 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/ratelimit"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultRRLSlip sends every second limited response truncated so
+	// legitimate clients behind a spoofed netblock can retry over TCP.
+	defaultRRLSlip = 2
+
+	// limitedClientWindow is how long a client counts as rate limited
+	// after its last refused query.
+	limitedClientWindow = time.Minute
+)
+
+// rateLimitConfig is the effective rate limiting for all listeners.
+type rateLimitConfig struct {
+	perSec    int    // Queries/sec per client IP (0 = unlimited)
+	action    string // "refuse", "drop" or "truncate"
+	rrlPerSec int    // Identical responses/sec per client netblock (0 = off)
+	rrlSlip   int    // Every Nth RRL-limited response is sent truncated
+}
+
+// rateLimitFromServe picks the strictest limits across serve blocks.
+func rateLimitFromServe(serves []config.DNSServe) rateLimitConfig {
+	var rl rateLimitConfig
+	for _, serve := range serves {
+		if serve.RateLimitPerSec > 0 && (rl.perSec == 0 || serve.RateLimitPerSec < rl.perSec) {
+			rl.perSec = serve.RateLimitPerSec
+			rl.action = serve.RateLimitAction
+		}
+		if serve.ResponseRateLimit > 0 && (rl.rrlPerSec == 0 || serve.ResponseRateLimit < rl.rrlPerSec) {
+			rl.rrlPerSec = serve.ResponseRateLimit
+			rl.rrlSlip = serve.RRLSlip
+		}
+	}
+	return rl.withDefaults()
+}
+
+// rateLimitFromServer converts the legacy dns_server settings.
+func rateLimitFromServer(cfg *config.DNSServer) rateLimitConfig {
+	return rateLimitConfig{
+		perSec:    cfg.RateLimitPerSec,
+		action:    cfg.RateLimitAction,
+		rrlPerSec: cfg.ResponseRateLimit,
+		rrlSlip:   cfg.RRLSlip,
+	}.withDefaults()
+}
+
+func (rl rateLimitConfig) withDefaults() rateLimitConfig {
+	switch rl.action {
+	case "drop", "truncate":
+	default:
+		rl.action = "refuse"
+	}
+	if rl.rrlSlip <= 0 {
+		rl.rrlSlip = defaultRRLSlip
+	}
+	return rl
+}
+
+// RateLimitStats holds cumulative counters of rate-limited DNS traffic.
+type RateLimitStats struct {
+	LimitedClients int    `json:"limited_clients"` // Clients limited within the last minute
+	Refused        uint64 `json:"refused"`
+	Dropped        uint64 `json:"dropped"`
+	Truncated      uint64 `json:"truncated"`
+	RRLDropped     uint64 `json:"rrl_dropped"`
+	RRLSlipped     uint64 `json:"rrl_slipped"`
+}
+
+// rateLimiter enforces per-client query limits and response rate limiting.
+// It outlives reloads so the counters stay cumulative.
+type rateLimiter struct {
+	clients   *ratelimit.Limiter // Keyed by client IP
+	responses *ratelimit.Limiter // Keyed by netblock + response identity
+	slip      atomic.Uint64
+
+	refused    atomic.Uint64
+	dropped    atomic.Uint64
+	truncated  atomic.Uint64
+	rrlDropped atomic.Uint64
+	rrlSlipped atomic.Uint64
+
+	mu      sync.Mutex
+	limited map[string]time.Time // Client IP -> last limited
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		clients:   ratelimit.NewLimiter(),
+		responses: ratelimit.NewLimiter(),
+		limited:   make(map[string]time.Time),
+	}
+}
+
+// allowQuery reports whether the client may send another query. When it
+// may not, the configured action has already been applied to w.
+func (l *rateLimiter) allowQuery(w dns.ResponseWriter, r *dns.Msg, rl rateLimitConfig) bool {
+	ip := clientIP(w)
+	if ip == "" || l.clients.Allow(ip, rl.perSec, time.Second) {
+		return true
+	}
+
+	l.mu.Lock()
+	l.limited[ip] = clock.Now()
+	l.mu.Unlock()
+
+	if rl.action == "drop" {
+		l.dropped.Add(1)
+		return false
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	if rl.action == "truncate" && isUDP(w) {
+		msg.Truncated = true
+		l.truncated.Add(1)
+	} else {
+		msg.Rcode = dns.RcodeRefused
+		l.refused.Add(1)
+	}
+	w.WriteMsg(msg)
+	return false
+}
+
+// stats returns a snapshot of the counters.
+func (l *rateLimiter) stats() RateLimitStats {
+	cutoff := clock.Now().Add(-limitedClientWindow)
+	l.mu.Lock()
+	n := 0
+	for _, t := range l.limited {
+		if t.After(cutoff) {
+			n++
+		}
+	}
+	l.mu.Unlock()
+
+	return RateLimitStats{
+		LimitedClients: n,
+		Refused:        l.refused.Load(),
+		Dropped:        l.dropped.Load(),
+		Truncated:      l.truncated.Load(),
+		RRLDropped:     l.rrlDropped.Load(),
+		RRLSlipped:     l.rrlSlipped.Load(),
+	}
+}
+
+// cleanup forgets idle buckets and clients that are no longer limited.
+func (l *rateLimiter) cleanup() {
+	l.clients.CleanupExpired(limitedClientWindow)
+	l.responses.CleanupExpired(limitedClientWindow)
+
+	cutoff := clock.Now().Add(-limitedClientWindow)
+	l.mu.Lock()
+	for ip, t := range l.limited {
+		if t.Before(cutoff) {
+			delete(l.limited, ip)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// rrlWriter applies response rate limiting to UDP replies so the server
+// cannot be used to amplify traffic toward a spoofed source.
+type rrlWriter struct {
+	dns.ResponseWriter
+	limiter *rateLimiter
+	cfg     rateLimitConfig
+}
+
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	key := rrlKey(w.RemoteAddr(), m)
+	if key == "" || w.limiter.responses.Allow(key, w.cfg.rrlPerSec, time.Second) {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+
+	if w.limiter.slip.Add(1)%uint64(w.cfg.rrlSlip) != 0 {
+		w.limiter.rrlDropped.Add(1)
+		return nil
+	}
+
+	// Slip: a truncated empty reply makes real clients retry over TCP
+	tc := new(dns.Msg)
+	tc.SetReply(m)
+	tc.Rcode = m.Rcode
+	tc.Truncated = true
+	w.limiter.rrlSlipped.Add(1)
+	return w.ResponseWriter.WriteMsg(tc)
+}
+
+// rrlKey identifies a response for RRL accounting: the client netblock
+// (/24 or /56) plus the answer identity. NXDOMAIN responses are grouped
+// by zone so random subdomains don't get a bucket each.
+func rrlKey(addr net.Addr, m *dns.Msg) string {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok || len(m.Question) == 0 {
+		return ""
+	}
+
+	var block string
+	if ip4 := udp.IP.To4(); ip4 != nil {
+		block = ip4.Mask(net.CIDRMask(24, 32)).String()
+	} else {
+		block = udp.IP.Mask(net.CIDRMask(56, 128)).String()
+	}
+
+	q := m.Question[0]
+	name := strings.ToLower(q.Name)
+	if m.Rcode == dns.RcodeNameError {
+		for _, rr := range m.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				name = strings.ToLower(soa.Hdr.Name)
+				break
+			}
+		}
+	}
+	return block + "|" + name + "|" + strconv.Itoa(int(q.Qtype)) + "|" + strconv.Itoa(m.Rcode)
+}
+
+// clientIP returns the source address of a query without its port.
+func clientIP(w dns.ResponseWriter) string {
+	addr := w.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+func isUDP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.UDPAddr)
+	return ok
+}
+
+// RateLimitStats returns counters of queries refused, dropped or truncated
+// by per-client and response rate limiting.
+func (s *Service) RateLimitStats() RateLimitStats {
+	return s.limiter.stats()
+}
@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestRateLimit_PerClient(t *testing.T) {
+	tests := []struct {
+		action    string
+		remote    net.Addr
+		wantReply bool
+		wantRcode int
+		wantTC    bool
+	}{
+		{"", &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}, true, dns.RcodeRefused, false},
+		{"drop", &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}, false, 0, false},
+		{"truncate", &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}, true, dns.RcodeSuccess, true},
+		// Truncation means nothing over TCP, so it falls back to REFUSED
+		{"truncate", &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 5353}, true, dns.RcodeRefused, false},
+	}
+
+	for _, tt := range tests {
+		s, err := newTestService(&config.DNSServer{
+			Enabled:         true,
+			RateLimitPerSec: 2,
+			RateLimitAction: tt.action,
+			Hosts:           []config.DNSHostEntry{{IP: "192.168.1.10", Hostnames: []string{"nas.lan"}}},
+		})
+		if err != nil {
+			t.Fatalf("newTestService failed: %v", err)
+		}
+
+		query := func(remote net.Addr) *dns.Msg {
+			req := new(dns.Msg)
+			req.SetQuestion("nas.lan.", dns.TypeA)
+			w := &captureWriter{remote: remote}
+			s.ServeDNS(w, req)
+			return w.msg
+		}
+
+		for i := 0; i < 2; i++ {
+			if resp := query(tt.remote); resp == nil || len(resp.Answer) != 1 {
+				t.Fatalf("%s: query %d within limit was not answered: %v", tt.action, i, resp)
+			}
+		}
+
+		resp := query(tt.remote)
+		if !tt.wantReply {
+			if resp != nil {
+				t.Errorf("%s: expected limited query to be dropped, got %v", tt.action, resp)
+			}
+		} else if resp == nil || resp.Rcode != tt.wantRcode || resp.Truncated != tt.wantTC || len(resp.Answer) != 0 {
+			t.Errorf("%s: unexpected limited reply: %v", tt.action, resp)
+		}
+
+		// Other clients keep their own budget
+		if resp := query(&net.UDPAddr{IP: net.ParseIP("192.168.1.21"), Port: 5353}); resp == nil || len(resp.Answer) != 1 {
+			t.Errorf("%s: unrelated client was limited", tt.action)
+		}
+
+		if stats := s.RateLimitStats(); stats.LimitedClients != 1 || stats.Refused+stats.Dropped+stats.Truncated != 1 {
+			t.Errorf("%s: unexpected stats %+v", tt.action, stats)
+		}
+		s.Stop(context.Background())
+	}
+}
+
+func TestRateLimit_ResponseRateLimiting(t *testing.T) {
+	s, err := newTestService(&config.DNSServer{
+		Enabled:           true,
+		ResponseRateLimit: 2,
+		Hosts:             []config.DNSHostEntry{{IP: "192.168.1.10", Hostnames: []string{"nas.lan"}}},
+	})
+	if err != nil {
+		t.Fatalf("newTestService failed: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	query := func(ip string, remote func(net.IP) net.Addr) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("nas.lan.", dns.TypeA)
+		w := &captureWriter{remote: remote(net.ParseIP(ip))}
+		s.ServeDNS(w, req)
+		return w.msg
+	}
+	udp := func(ip net.IP) net.Addr { return &net.UDPAddr{IP: ip, Port: 53} }
+	tcp := func(ip net.IP) net.Addr { return &net.TCPAddr{IP: ip, Port: 53} }
+
+	// Spoofed sources in one /24 share a bucket
+	if query("203.0.113.1", udp) == nil || query("203.0.113.2", udp) == nil {
+		t.Fatal("responses within the limit were suppressed")
+	}
+
+	var dropped, slipped int
+	for i := 0; i < 4; i++ {
+		switch resp := query("203.0.113.3", udp); {
+		case resp == nil:
+			dropped++
+		case resp.Truncated && len(resp.Answer) == 0:
+			slipped++
+		default:
+			t.Errorf("expected limited response to be dropped or slipped, got %v", resp)
+		}
+	}
+	if dropped != 2 || slipped != 2 {
+		t.Errorf("expected 2 dropped and 2 slipped with slip 2, got %d/%d", dropped, slipped)
+	}
+
+	// TCP cannot be spoofed, so it is never limited
+	if resp := query("203.0.113.3", tcp); resp == nil || len(resp.Answer) != 1 {
+		t.Errorf("TCP response was limited: %v", resp)
+	}
+
+	if stats := s.RateLimitStats(); stats.RRLDropped != 2 || stats.RRLSlipped != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimitFromServe_PicksStrictest(t *testing.T) {
+	rl := rateLimitFromServe([]config.DNSServe{
+		{Zone: "lan", RateLimitPerSec: 100},
+		{Zone: "wan", RateLimitPerSec: 10, RateLimitAction: "drop", ResponseRateLimit: 5},
+		{Zone: "guest"},
+	})
+	if rl.perSec != 10 || rl.action != "drop" || rl.rrlPerSec != 5 || rl.rrlSlip != defaultRRLSlip {
+		t.Errorf("unexpected rate limit config: %+v", rl)
+	}
+}
@@ -0,0 +1,834 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/clock"
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// Built-in IPv4 root hints (a.root-servers.net .. m.root-servers.net).
+// Used when no root_hints_file is configured or it cannot be read.
+var defaultRootHints = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+var (
+	errMaxDepth        = errors.New("maximum recursion depth exceeded")
+	errNoServers       = errors.New("no reachable nameservers")
+	errLameReferral    = errors.New("lame or upward referral")
+	errDNSSECStripped  = errors.New("signatures missing from signed zone")
+	errUnconfirmedPath = errors.New("referral not confirmed by child zone")
+)
+
+const (
+	defaultRecursionDepth = 30
+	defaultQueryTimeout   = 1500 * time.Millisecond
+	defaultMaxConcurrent  = 64
+	defaultNegativeTTL    = 300
+	resolveTimeout        = 10 * time.Second
+	rootPrimeInterval     = 24 * time.Hour
+	pruneInterval         = time.Minute
+	maxNegativeEntries    = 10000
+)
+
+// recursor resolves queries iteratively, starting from the root servers.
+// Final answers share the service's response cache; zone cuts and
+// negative answers are tracked locally.
+type recursor struct {
+	cfg      config.RecursiveConfig
+	svc      *Service
+	port     string
+	timeout  time.Duration
+	maxDepth int
+	sem      chan struct{}
+	stop     chan struct{}
+
+	// validator checks NXDOMAIN answers before their NSEC records are
+	// used for aggressive negative caching. Set when aggressive_nsec is on.
+	validator *validator
+
+	mu          sync.RWMutex
+	roots       []string
+	zones       map[string]*delegation
+	nxdomain    map[string]time.Time
+	nsec        map[string]cachedNSEC
+	prefetching map[string]bool
+}
+
+// delegation is a zone cut and the addresses of its nameservers.
+type delegation struct {
+	zone      string
+	servers   []string
+	signed    bool // parent published DS for this zone
+	expiresAt time.Time
+}
+
+type cachedNSEC struct {
+	zone      string
+	rr        *dns.NSEC
+	expiresAt time.Time
+}
+
+// newRecursor creates a recursor for the service from the recursive block.
+func newRecursor(svc *Service, rc *config.RecursiveConfig) *recursor {
+	cfg := config.RecursiveConfig{}
+	if rc != nil {
+		cfg = *rc
+	}
+
+	r := &recursor{
+		cfg:         cfg,
+		svc:         svc,
+		port:        "53",
+		timeout:     defaultQueryTimeout,
+		maxDepth:    defaultRecursionDepth,
+		stop:        make(chan struct{}),
+		roots:       defaultRootHints,
+		zones:       make(map[string]*delegation),
+		nxdomain:    make(map[string]time.Time),
+		nsec:        make(map[string]cachedNSEC),
+		prefetching: make(map[string]bool),
+	}
+	if cfg.QueryTimeout > 0 {
+		r.timeout = time.Duration(cfg.QueryTimeout) * time.Millisecond
+	}
+	if cfg.MaxDepth > 0 {
+		r.maxDepth = cfg.MaxDepth
+	}
+	concurrent := defaultMaxConcurrent
+	if cfg.MaxConcurrent > 0 {
+		concurrent = cfg.MaxConcurrent
+	}
+	r.sem = make(chan struct{}, concurrent)
+
+	if cfg.RootHintsFile != "" {
+		roots, err := loadRootHints(cfg.RootHintsFile)
+		if err != nil {
+			log.Printf("[DNS] Failed to load root hints %s, using built-in hints: %v", cfg.RootHintsFile, err)
+		} else {
+			r.roots = roots
+		}
+	}
+
+	if cfg.AggressiveNSEC {
+		var anchors []string
+		var anchorFile string
+		if svc.config != nil {
+			anchors, anchorFile = svc.config.TrustAnchors, svc.config.TrustAnchorFile
+		}
+		v, err := newValidator(anchors, anchorFile, r.validatorQuery)
+		if err != nil {
+			log.Printf("[DNS] Aggressive NSEC disabled: %v", err)
+		} else {
+			r.validator = v
+		}
+	}
+	return r
+}
+
+// start launches background pruning of expired zone cuts and negative
+// answers, and root priming when auto_update_root_hints is set.
+func (r *recursor) start() {
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.prune()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+
+	if !r.cfg.AutoUpdateRootHints {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(rootPrimeInterval)
+		defer ticker.Stop()
+		for {
+			if err := r.primeRoots(); err != nil {
+				log.Printf("[DNS] Root priming failed: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close stops background work.
+func (r *recursor) close() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+}
+
+// loadRootHints parses a root hints file in zone file format (named.root)
+// and returns the addresses of the root nameservers.
+func loadRootHints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nsNames := make(map[string]bool)
+	addrs := make(map[string][]string)
+	zp := dns.NewZoneParser(f, ".", path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch v := rr.(type) {
+		case *dns.NS:
+			if v.Hdr.Name == "." {
+				nsNames[strings.ToLower(v.Ns)] = true
+			}
+		case *dns.A:
+			name := strings.ToLower(v.Hdr.Name)
+			addrs[name] = append(addrs[name], v.A.String())
+		case *dns.AAAA:
+			name := strings.ToLower(v.Hdr.Name)
+			addrs[name] = append(addrs[name], v.AAAA.String())
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for name := range nsNames {
+		roots = append(roots, addrs[name]...)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no root server addresses in %s", path)
+	}
+	return roots, nil
+}
+
+// primeRoots refreshes the root server list with a ". NS" priming query (RFC 8109).
+func (r *recursor) primeRoots() error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	r.mu.RLock()
+	roots := r.roots
+	r.mu.RUnlock()
+
+	resp, err := r.exchange(ctx, roots, ".", dns.TypeNS)
+	if err != nil {
+		return err
+	}
+
+	nsNames := make(map[string]bool)
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nsNames[strings.ToLower(ns.Ns)] = true
+		}
+	}
+	var primed []string
+	for _, rr := range resp.Extra {
+		if !nsNames[strings.ToLower(rr.Header().Name)] {
+			continue
+		}
+		if ip := rrAddr(rr); ip != "" {
+			primed = append(primed, ip)
+		}
+	}
+	if len(primed) == 0 {
+		return fmt.Errorf("priming response carried no root addresses")
+	}
+
+	r.mu.Lock()
+	r.roots = primed
+	r.mu.Unlock()
+	log.Printf("[DNS] Primed %d root server addresses", len(primed))
+	return nil
+}
+
+// Resolve answers q, consulting the shared cache first.
+func (r *recursor) Resolve(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+	return r.resolve(ctx, q, 0)
+}
+
+func (r *recursor) resolve(ctx context.Context, q dns.Question, depth int) (*dns.Msg, error) {
+	if depth > r.maxDepth {
+		return nil, errMaxDepth
+	}
+	if cached, ok := r.svc.cacheGet(q.Name, q.Qtype); ok {
+		return cached, nil
+	}
+
+	resp, err := r.iterate(ctx, q, depth)
+	if err != nil {
+		return nil, err
+	}
+	r.svc.cacheMsg(resp)
+	return resp, nil
+}
+
+// iterate walks the delegation chain for q, bypassing the answer cache.
+func (r *recursor) iterate(ctx context.Context, q dns.Question, depth int) (*dns.Msg, error) {
+	name := strings.ToLower(dns.Fqdn(q.Name))
+
+	if r.isNXDomain(name) || r.coveredByNSEC(name) {
+		return negativeResponse(q), nil
+	}
+
+	del := r.closestDelegation(servingName(name, q.Qtype))
+	labels := dns.CountLabel(del.zone) + 1
+
+	for step := depth; step <= r.maxDepth; step++ {
+		qname, qtype := name, q.Qtype
+		minimised := false
+		if r.cfg.QNameMinimisation && labels < dns.CountLabel(name) {
+			qname, qtype = lastLabels(name, labels), dns.TypeNS
+			minimised = true
+		}
+
+		resp, err := r.exchange(ctx, del.servers, qname, qtype)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", del.zone, err)
+		}
+
+		if resp.Rcode == dns.RcodeNameError {
+			r.recordNegative(qname, del.zone, resp)
+			if minimised {
+				// RFC 8020: nothing exists below an NXDOMAIN name
+				return negativeResponse(q), nil
+			}
+			return resp, nil
+		}
+
+		if cut, ok := referralCut(resp, del.zone, qname); ok {
+			next, err := r.followReferral(ctx, resp, del, cut, depth)
+			if err != nil {
+				return nil, err
+			}
+			del = next
+			labels = dns.CountLabel(del.zone) + 1
+			continue
+		}
+
+		if minimised {
+			// No zone cut at qname; extend by one label and ask again
+			labels++
+			continue
+		}
+
+		if r.cfg.HardenDNSSECStripped && del.signed && len(resp.Answer) > 0 && !hasRRSIG(resp.Answer) {
+			return nil, fmt.Errorf("%s: %w", del.zone, errDNSSECStripped)
+		}
+
+		return r.chaseCNAME(ctx, q, resp, depth)
+	}
+	return nil, errMaxDepth
+}
+
+// chaseCNAME follows an out-of-zone CNAME chain when the authoritative
+// answer does not already contain the requested type.
+func (r *recursor) chaseCNAME(ctx context.Context, q dns.Question, resp *dns.Msg, depth int) (*dns.Msg, error) {
+	if q.Qtype == dns.TypeCNAME || len(resp.Answer) == 0 {
+		return resp, nil
+	}
+
+	target := ""
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == q.Qtype {
+			return resp, nil
+		}
+		if cname, ok := rr.(*dns.CNAME); ok {
+			target = cname.Target
+		}
+	}
+	if target == "" {
+		return resp, nil
+	}
+
+	next, err := r.resolve(ctx, dns.Question{Name: target, Qtype: q.Qtype, Qclass: q.Qclass}, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	out := resp.Copy()
+	out.Answer = append(out.Answer, next.Answer...)
+	out.Ns = next.Ns
+	out.Rcode = next.Rcode
+	return out, nil
+}
+
+// followReferral builds the delegation for cut from a referral response.
+func (r *recursor) followReferral(ctx context.Context, resp *dns.Msg, parent *delegation, cut string, depth int) (*delegation, error) {
+	var nsNames []string
+	ttl := uint32(defaultNegativeTTL)
+	signed := false
+	for _, rr := range append(resp.Ns, resp.Answer...) {
+		if !strings.EqualFold(rr.Header().Name, cut) {
+			continue
+		}
+		switch v := rr.(type) {
+		case *dns.NS:
+			nsNames = append(nsNames, strings.ToLower(v.Ns))
+			if v.Hdr.Ttl < ttl {
+				ttl = v.Hdr.Ttl
+			}
+		case *dns.DS:
+			signed = true
+		}
+	}
+
+	glue := make(map[string][]string)
+	for _, rr := range resp.Extra {
+		owner := strings.ToLower(rr.Header().Name)
+		// harden-glue: only trust addresses the parent is authoritative for
+		if r.cfg.HardenGlue && !dns.IsSubDomain(parent.zone, owner) {
+			continue
+		}
+		if ip := rrAddr(rr); ip != "" {
+			glue[owner] = append(glue[owner], ip)
+		}
+	}
+
+	var servers []string
+	var unresolved []string
+	for _, ns := range nsNames {
+		if ips, ok := glue[ns]; ok {
+			servers = append(servers, ips...)
+		} else {
+			unresolved = append(unresolved, ns)
+		}
+	}
+	// Glueless delegations: look up the nameserver addresses ourselves
+	if len(servers) == 0 {
+		for _, ns := range unresolved {
+			servers = append(servers, r.lookupAddrs(ctx, ns, depth+1)...)
+			if len(servers) > 0 {
+				break
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s: %w", cut, errNoServers)
+	}
+
+	del := &delegation{
+		zone:      cut,
+		servers:   servers,
+		signed:    signed,
+		expiresAt: clock.Now().Add(time.Duration(ttl) * time.Second),
+	}
+
+	if r.cfg.HardenReferralPath {
+		if err := r.confirmDelegation(ctx, del); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	r.zones[cut] = del
+	r.mu.Unlock()
+
+	if r.cfg.PrefetchKey && signed {
+		go r.prefetchQuestion(dns.Question{Name: cut, Qtype: dns.TypeDNSKEY, Qclass: dns.ClassINET})
+	}
+	return del, nil
+}
+
+// confirmDelegation asks the child servers for their own NS set and
+// requires an authoritative answer (harden-referral-path).
+func (r *recursor) confirmDelegation(ctx context.Context, del *delegation) error {
+	resp, err := r.exchange(ctx, del.servers, del.zone, dns.TypeNS)
+	if err != nil {
+		return fmt.Errorf("%s: %w", del.zone, err)
+	}
+	if !resp.Authoritative {
+		return fmt.Errorf("%s: %w", del.zone, errUnconfirmedPath)
+	}
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, del.zone) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", del.zone, errUnconfirmedPath)
+}
+
+// lookupAddrs resolves the A and AAAA records of a nameserver name.
+func (r *recursor) lookupAddrs(ctx context.Context, name string, depth int) []string {
+	var addrs []string
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, err := r.resolve(ctx, dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}, depth)
+		if err != nil {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if ip := rrAddr(rr); ip != "" {
+				addrs = append(addrs, ip)
+			}
+		}
+		if len(addrs) > 0 {
+			break
+		}
+	}
+	return addrs
+}
+
+// exchange sends a non-recursive query to each server in turn until one answers.
+func (r *recursor) exchange(ctx context.Context, servers []string, qname string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.RecursionDesired = false
+	if r.cfg.HardenDNSSECStripped || r.cfg.PrefetchKey || r.cfg.AggressiveNSEC {
+		setDO(m)
+	}
+
+	lastErr := errNoServers
+	for _, server := range servers {
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		resp, err := r.exchangeOne(ctx, m, net.JoinHostPort(server, r.port))
+		<-r.sem
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("server %s returned %s", server, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (r *recursor) exchangeOne(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	c := &dns.Client{Net: "udp", Timeout: r.timeout}
+	resp, _, err := c.ExchangeContext(ctx, m, addr)
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.ExchangeContext(ctx, m, addr)
+	}
+	return resp, err
+}
+
+// closestDelegation returns the deepest cached zone cut enclosing name,
+// falling back to the root servers.
+func (r *recursor) closestDelegation(name string) *delegation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := clock.Now()
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if del, ok := r.zones[name[off:]]; ok && now.Before(del.expiresAt) {
+			return del
+		}
+	}
+	return &delegation{zone: ".", servers: r.roots}
+}
+
+// recordNegative remembers an NXDOMAIN answer and, when the answer
+// validates as secure, the NSEC records proving it.
+func (r *recursor) recordNegative(name, zone string, resp *dns.Msg) {
+	// RFC 8198 section 5.1: only NSEC records from validated answers may
+	// be used to synthesise negative responses.
+	secure := r.cfg.AggressiveNSEC && r.validated(resp)
+
+	ttl := uint32(defaultNegativeTTL)
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl = soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+		}
+	}
+	expires := clock.Now().Add(time.Duration(ttl) * time.Second)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.nxdomain) >= maxNegativeEntries {
+		r.pruneNegative()
+	}
+	r.nxdomain[strings.ToLower(name)] = expires
+
+	if !secure {
+		return
+	}
+	for _, rr := range resp.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			owner := strings.ToLower(nsec.Hdr.Name)
+			r.nsec[owner] = cachedNSEC{zone: zone, rr: nsec, expiresAt: expires}
+		}
+	}
+}
+
+// validated reports whether resp is provably secure.
+func (r *recursor) validated(resp *dns.Msg) bool {
+	if r.validator == nil {
+		return false
+	}
+	secure, err := r.validator.validate(resp)
+	if err != nil {
+		log.Printf("[DNS] Ignoring NSEC records in bogus answer for %s: %v", resp.Question[0].Name, err)
+		return false
+	}
+	return secure
+}
+
+// validatorQuery fetches DS and DNSKEY records for the validator by
+// iterating from the roots.
+func (r *recursor) validatorQuery(name string, qtype uint16) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	return r.iterate(ctx, dns.Question{Name: dns.Fqdn(name), Qtype: qtype, Qclass: dns.ClassINET}, 0)
+}
+
+// prune drops expired zone cuts and negative entries.
+func (r *recursor) prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := clock.Now()
+	for zone, del := range r.zones {
+		if !now.Before(del.expiresAt) {
+			delete(r.zones, zone)
+		}
+	}
+	r.pruneNegative()
+}
+
+// pruneNegative drops expired negative entries. Caller must hold r.mu.
+func (r *recursor) pruneNegative() {
+	now := clock.Now()
+	for name, exp := range r.nxdomain {
+		if now.After(exp) {
+			delete(r.nxdomain, name)
+		}
+	}
+	for owner, entry := range r.nsec {
+		if now.After(entry.expiresAt) {
+			delete(r.nsec, owner)
+		}
+	}
+}
+
+// isNXDomain reports whether name (or, with harden-below-nxdomain, any of
+// its ancestors) is known not to exist.
+func (r *recursor) isNXDomain(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := clock.Now()
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if exp, ok := r.nxdomain[name[off:]]; ok && now.Before(exp) {
+			return true
+		}
+		if !r.cfg.HardenBelowNXDomain {
+			break
+		}
+	}
+	return false
+}
+
+// coveredByNSEC reports whether a cached NSEC record proves that name
+// does not exist (RFC 8198 aggressive use of the DNSSEC-validated cache).
+func (r *recursor) coveredByNSEC(name string) bool {
+	if !r.cfg.AggressiveNSEC {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := clock.Now()
+	for _, entry := range r.nsec {
+		if now.After(entry.expiresAt) || !dns.IsSubDomain(entry.zone, name) {
+			continue
+		}
+		if nsecCovers(entry.rr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecCovers reports whether name falls strictly between the NSEC owner
+// and its next domain name in canonical order.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// Last NSEC in the zone wraps around to the apex
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// canonicalCompare orders two domain names per RFC 4034 section 6.1.
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// prefetchDue reports whether a cached entry is within the last 10% of
+// its TTL and should be refreshed before it expires.
+func (c cachedResponse) prefetchDue(now time.Time) bool {
+	if c.storedAt.IsZero() {
+		return false
+	}
+	total := c.expiresAt.Sub(c.storedAt)
+	return now.Before(c.expiresAt) && c.expiresAt.Sub(now) < total/10
+}
+
+// prefetchQuestion refreshes a cache entry in the background.
+func (r *recursor) prefetchQuestion(q dns.Question) {
+	key := cacheKey(q.Name, q.Qtype)
+	r.mu.Lock()
+	if r.prefetching[key] {
+		r.mu.Unlock()
+		return
+	}
+	r.prefetching[key] = true
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.prefetching, key)
+		r.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	resp, err := r.iterate(ctx, q, 0)
+	if err != nil {
+		log.Printf("[DNS] Prefetch of %s failed: %v", q.Name, err)
+		return
+	}
+	r.svc.cacheMsg(resp)
+}
+
+// serveRecursive answers a client query by iterating from the roots.
+func (s *Service) serveRecursive(w dns.ResponseWriter, r *dns.Msg, rec *recursor) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	resp, err := rec.Resolve(ctx, r.Question[0])
+	if err != nil {
+		log.Printf("[DNS] Recursive resolution of %s failed: %v", r.Question[0].Name, err)
+		dns.HandleFailed(w, r)
+		return
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.RecursionAvailable = true
+	msg.Rcode = resp.Rcode
+	msg.Answer = resp.Answer
+	msg.Ns = resp.Ns
+	if opt := r.IsEdns0(); opt != nil {
+		msg.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+
+	s.snoopResponse(msg)
+	w.WriteMsg(msg)
+}
+
+// referralCut returns the delegated zone if resp is a referral from zone
+// towards qname. Upward and sideways referrals are rejected.
+func referralCut(resp *dns.Msg, zone, qname string) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess {
+		return "", false
+	}
+	var records []dns.RR
+	switch {
+	case len(resp.Answer) == 0:
+		records = resp.Ns
+	case resp.Authoritative:
+		// Parent and child served by the same server answer NS queries
+		// for the cut directly.
+		records = resp.Answer
+	}
+	for _, rr := range records {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		cut := strings.ToLower(ns.Hdr.Name)
+		if cut == strings.ToLower(zone) || !dns.IsSubDomain(zone, cut) || !dns.IsSubDomain(cut, qname) {
+			continue
+		}
+		return cut, true
+	}
+	return "", false
+}
+
+// negativeResponse synthesises an NXDOMAIN answer for q.
+func negativeResponse(q dns.Question) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.Response = true
+	m.Rcode = dns.RcodeNameError
+	return m
+}
+
+// lastLabels returns the rightmost n labels of name.
+func lastLabels(name string, n int) string {
+	idx := dns.Split(name)
+	if n >= len(idx) {
+		return name
+	}
+	return name[idx[len(idx)-n]:]
+}
+
+func hasRRSIG(rrs []dns.RR) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			return true
+		}
+	}
+	return false
+}
+
+func rrAddr(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	}
+	return ""
+}
@@ -0,0 +1,455 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// fakeHierarchy runs a root, a TLD and a leaf zone on separate loopback
+// addresses sharing one port, mirroring a real delegation chain:
+//
+//	.             127.0.0.1  (delegates test.)
+//	test.         127.0.0.2  (delegates example.test., signed with DS)
+//	example.test. 127.0.0.3
+type fakeHierarchy struct {
+	port    string
+	servers []*dns.Server
+
+	mu         sync.Mutex
+	queries    map[string][]string // server IP -> queried names
+	stripRRSIG bool
+}
+
+func (h *fakeHierarchy) queried(ip string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.queries[ip]...)
+}
+
+func newFakeHierarchy(t *testing.T) *fakeHierarchy {
+	t.Helper()
+
+	// Find a port that is free on 127.0.0.1; the other loopback addresses
+	// are reserved for this test.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	pc.Close()
+
+	h := &fakeHierarchy{port: port, queries: make(map[string][]string)}
+	zones := map[string]dns.HandlerFunc{
+		"127.0.0.1": h.handle("127.0.0.1", []string{
+			". 3600 IN SOA a.root.test. admin.root.test. 1 3600 600 86400 300",
+			"test. 3600 IN NS ns.test.",
+			"ns.test. 3600 IN A 127.0.0.2",
+		}),
+		"127.0.0.2": h.handle("127.0.0.2", []string{
+			"test. 3600 IN SOA ns.test. admin.test. 1 3600 600 86400 300",
+			"test. 3600 IN NS ns.test.",
+			"ns.test. 3600 IN A 127.0.0.2",
+			"example.test. 3600 IN NS ns.example.test.",
+			"example.test. 3600 IN DS 12345 8 2 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			"ns.example.test. 3600 IN A 127.0.0.3",
+			"evil.example.org. 3600 IN A 6.6.6.6",
+			"alias.test. 3600 IN CNAME www.example.test.",
+			"apex.test. 3600 IN NSEC zzz.test. A NS",
+		}),
+		"127.0.0.3": h.handle("127.0.0.3", []string{
+			"example.test. 3600 IN SOA ns.example.test. admin.example.test. 1 3600 600 86400 300",
+			"example.test. 3600 IN NS ns.example.test.",
+			"ns.example.test. 3600 IN A 127.0.0.3",
+			"www.example.test. 3600 IN A 192.0.2.10",
+			"www.example.test. 3600 IN RRSIG A 8 3 3600 20300101000000 20200101000000 12345 example.test. AAAA",
+		}),
+	}
+
+	for ip, handler := range zones {
+		started := make(chan struct{})
+		srv := &dns.Server{Addr: net.JoinHostPort(ip, port), Net: "udp", Handler: handler, NotifyStartedFunc: func() { close(started) }}
+		go srv.ListenAndServe()
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Skipf("cannot bind %s (loopback aliases unavailable)", srv.Addr)
+		}
+		h.servers = append(h.servers, srv)
+	}
+	t.Cleanup(func() {
+		for _, srv := range h.servers {
+			srv.Shutdown()
+		}
+	})
+	return h
+}
+
+// handle serves an authoritative zone from a list of records, returning
+// referrals with glue for delegated names.
+func (h *fakeHierarchy) handle(ip string, lines []string) dns.HandlerFunc {
+	var rrs []dns.RR
+	for _, line := range lines {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			panic(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	apex := rrs[0].Header().Name
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		q := r.Question[0]
+		qname := strings.ToLower(q.Name)
+		h.mu.Lock()
+		h.queries[ip] = append(h.queries[ip], qname)
+		strip := h.stripRRSIG
+		h.mu.Unlock()
+		do := r.IsEdns0() != nil && r.IsEdns0().Do()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		// Delegations below the apex
+		for _, rr := range rrs {
+			ns, ok := rr.(*dns.NS)
+			if !ok || ns.Hdr.Name == apex || !dns.IsSubDomain(ns.Hdr.Name, qname) {
+				continue
+			}
+			for _, d := range rrs {
+				if d.Header().Name == ns.Hdr.Name && (d.Header().Rrtype == dns.TypeNS || d.Header().Rrtype == dns.TypeDS) {
+					m.Ns = append(m.Ns, d)
+				}
+				if d.Header().Rrtype == dns.TypeA && strings.EqualFold(d.Header().Name, ns.Ns) {
+					m.Extra = append(m.Extra, d)
+				}
+				// Out-of-bailiwick glue that harden_glue must ignore
+				if d.Header().Name == "evil.example.org." {
+					m.Extra = append(m.Extra, d)
+				}
+			}
+			w.WriteMsg(m)
+			return
+		}
+
+		m.Authoritative = true
+		exists := false
+		for _, rr := range rrs {
+			if !strings.EqualFold(rr.Header().Name, qname) {
+				if dns.IsSubDomain(qname, rr.Header().Name) {
+					exists = true // empty non-terminal
+				}
+				continue
+			}
+			exists = true
+			switch {
+			case rr.Header().Rrtype == q.Qtype, rr.Header().Rrtype == dns.TypeCNAME:
+				m.Answer = append(m.Answer, rr)
+			case rr.Header().Rrtype == dns.TypeRRSIG && do && !strip:
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, rrs[0])
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == dns.TypeNSEC {
+					m.Ns = append(m.Ns, rr)
+				}
+			}
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func (h *fakeHierarchy) recursor(t *testing.T, rc config.RecursiveConfig) (*Service, *recursor) {
+	t.Helper()
+	hints := filepath.Join(t.TempDir(), "root.hints")
+	data := ". 3600000 IN NS a.root.test.\na.root.test. 3600000 IN A 127.0.0.1\n"
+	if err := os.WriteFile(hints, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc.RootHintsFile = hints
+	rc.QueryTimeout = 500
+
+	s, _ := newTestService(&config.DNSServer{Enabled: true})
+	rec := newRecursor(s, &rc)
+	rec.port = h.port
+	return s, rec
+}
+
+func resolveA(t *testing.T, rec *recursor, name string) (*dns.Msg, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return rec.Resolve(ctx, dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+}
+
+func TestRecursor_IteratesFromRoot(t *testing.T) {
+	h := newFakeHierarchy(t)
+	s, rec := h.recursor(t, config.RecursiveConfig{})
+
+	resp, err := resolveA(t, rec, "www.example.test.")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(resp.Answer) == 0 {
+		t.Fatalf("expected answer, got %v", resp)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != "192.0.2.10" {
+		t.Errorf("unexpected answer %v", resp.Answer[0])
+	}
+
+	// Answer lands in the shared cache
+	if _, ok := s.cacheGet("www.example.test.", dns.TypeA); !ok {
+		t.Error("expected answer in shared cache")
+	}
+
+	// Zone cuts are remembered; a second name in the zone goes straight to the leaf
+	rootQueries := len(h.queried("127.0.0.1"))
+	if _, err := resolveA(t, rec, "ns.example.test."); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(h.queried("127.0.0.1")) != rootQueries {
+		t.Error("expected cached delegation to skip the root")
+	}
+}
+
+func TestRecursor_CNAMEChase(t *testing.T) {
+	h := newFakeHierarchy(t)
+	_, rec := h.recursor(t, config.RecursiveConfig{})
+
+	resp, err := resolveA(t, rec, "alias.test.")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(resp.Answer) != 2 {
+		t.Fatalf("expected CNAME + A, got %v", resp.Answer)
+	}
+	if _, ok := resp.Answer[1].(*dns.A); !ok {
+		t.Errorf("expected A record after CNAME, got %v", resp.Answer[1])
+	}
+}
+
+func TestRecursor_QNameMinimisation(t *testing.T) {
+	h := newFakeHierarchy(t)
+	_, rec := h.recursor(t, config.RecursiveConfig{QNameMinimisation: true})
+
+	if _, err := resolveA(t, rec, "www.example.test."); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	for _, q := range h.queried("127.0.0.1") {
+		if q != "test." {
+			t.Errorf("root saw non-minimised query %q", q)
+		}
+	}
+	for _, q := range h.queried("127.0.0.2") {
+		if q == "www.example.test." {
+			t.Errorf("TLD saw full query name")
+		}
+	}
+}
+
+func TestRecursor_BelowNXDomain(t *testing.T) {
+	h := newFakeHierarchy(t)
+	_, rec := h.recursor(t, config.RecursiveConfig{HardenBelowNXDomain: true})
+
+	resp, err := resolveA(t, rec, "missing.test.")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	before := len(h.queried("127.0.0.2"))
+	resp, err = resolveA(t, rec, "deep.below.missing.test.")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected synthesised NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if len(h.queried("127.0.0.2")) != before {
+		t.Error("expected no upstream query below a known NXDOMAIN")
+	}
+}
+
+func TestRecursor_AggressiveNSEC(t *testing.T) {
+	h := newFakeHierarchy(t)
+	_, rec := h.recursor(t, config.RecursiveConfig{AggressiveNSEC: true})
+
+	if _, err := resolveA(t, rec, "missing.test."); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	// "other.test." sorts between apex.test. and zzz.test., but the NSEC
+	// record is unsigned and must not be used to synthesise an answer
+	before := len(h.queried("127.0.0.2"))
+	resp, err := resolveA(t, rec, "other.test.")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if len(h.queried("127.0.0.2")) == before {
+		t.Error("expected a name covered only by an unvalidated NSEC to be queried")
+	}
+}
+
+func TestRecursor_AggressiveNSECValidated(t *testing.T) {
+	tr, anchor := newSignedTree(t)
+	s, _ := newTestService(&config.DNSServer{Enabled: true})
+	rec := newRecursor(s, &config.RecursiveConfig{AggressiveNSEC: true})
+	v, err := newValidator([]string{anchor}, "", tr.query(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.validator = v
+
+	missing := dns.Question{Name: "missing.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	// Signatures stripped in transit: the NSEC chain is bogus
+	tr.mutate = func(m *dns.Msg) {
+		var ns []dns.RR
+		for _, rr := range m.Ns {
+			if rr.Header().Rrtype != dns.TypeRRSIG {
+				ns = append(ns, rr)
+			}
+		}
+		m.Ns = ns
+	}
+	rec.recordNegative(missing.Name, "example.", tr.answer(t, missing))
+	if rec.coveredByNSEC("other.example.") {
+		t.Error("expected unsigned NSEC records to be ignored")
+	}
+
+	// "other.example." sorts between alias.example. and www.example.
+	tr.mutate = nil
+	rec.recordNegative(missing.Name, "example.", tr.answer(t, missing))
+	if !rec.coveredByNSEC("other.example.") {
+		t.Error("expected a validated NSEC record to cover other.example.")
+	}
+	if rec.coveredByNSEC("www.example.") {
+		t.Error("expected an existing name not to be covered")
+	}
+}
+
+func TestRecursor_PruneExpired(t *testing.T) {
+	s, _ := newTestService(&config.DNSServer{Enabled: true})
+	rec := newRecursor(s, &config.RecursiveConfig{})
+
+	now := time.Now()
+	rec.zones["stale.test."] = &delegation{zone: "stale.test.", servers: []string{"192.0.2.1"}, expiresAt: now.Add(-time.Second)}
+	rec.zones["fresh.test."] = &delegation{zone: "fresh.test.", servers: []string{"192.0.2.2"}, expiresAt: now.Add(time.Hour)}
+	rec.nxdomain["gone.test."] = now.Add(-time.Second)
+
+	rec.prune()
+
+	if _, ok := rec.zones["stale.test."]; ok {
+		t.Error("expected expired delegation to be evicted")
+	}
+	if _, ok := rec.zones["fresh.test."]; !ok {
+		t.Error("expected live delegation to be kept")
+	}
+	if _, ok := rec.nxdomain["gone.test."]; ok {
+		t.Error("expected expired NXDOMAIN entry to be evicted")
+	}
+}
+
+func TestRecursor_HardenDNSSECStripped(t *testing.T) {
+	h := newFakeHierarchy(t)
+	h.mu.Lock()
+	h.stripRRSIG = true
+	h.mu.Unlock()
+	_, rec := h.recursor(t, config.RecursiveConfig{HardenDNSSECStripped: true})
+
+	if _, err := resolveA(t, rec, "www.example.test."); err == nil {
+		t.Error("expected failure for stripped signatures under a DS-signed delegation")
+	}
+}
+
+func TestRecursor_HardenGlueAndReferralPath(t *testing.T) {
+	h := newFakeHierarchy(t)
+	_, rec := h.recursor(t, config.RecursiveConfig{HardenGlue: true, HardenReferralPath: true})
+
+	if _, err := resolveA(t, rec, "www.example.test."); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	del := rec.closestDelegation("www.example.test.")
+	if del.zone != "example.test." {
+		t.Fatalf("expected example.test. delegation, got %s", del.zone)
+	}
+	for _, ip := range del.servers {
+		if ip == "6.6.6.6" {
+			t.Error("out-of-bailiwick glue was accepted")
+		}
+	}
+}
+
+func TestRecursor_Prefetch(t *testing.T) {
+	h := newFakeHierarchy(t)
+	s, rec := h.recursor(t, config.RecursiveConfig{Prefetch: true})
+
+	if _, err := resolveA(t, rec, "www.example.test."); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	// Age the entry into its final 10%
+	key := cacheKey("www.example.test.", dns.TypeA)
+	s.mu.Lock()
+	entry := s.cache[key]
+	entry.storedAt = time.Now().Add(-time.Hour)
+	entry.expiresAt = time.Now().Add(time.Minute)
+	s.cache[key] = entry
+	s.mu.Unlock()
+
+	if !entry.prefetchDue(time.Now()) {
+		t.Fatal("expected entry to be due for prefetch")
+	}
+	rec.prefetchQuestion(dns.Question{Name: "www.example.test.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+
+	s.mu.RLock()
+	refreshed := s.cache[key]
+	s.mu.RUnlock()
+	if time.Until(refreshed.expiresAt) < 30*time.Minute {
+		t.Error("expected prefetch to refresh the cache entry")
+	}
+}
+
+func TestLoadRootHints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "named.root")
+	data := `.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	roots, err := loadRootHints(path)
+	if err != nil {
+		t.Fatalf("loadRootHints failed: %v", err)
+	}
+	if len(roots) != 2 {
+		t.Errorf("expected 2 root addresses, got %v", roots)
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "*.z.example."}
+	for i := 0; i < len(ordered)-1; i++ {
+		if canonicalCompare(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -15,9 +16,12 @@ import (
 
 	"context"
 
+	"grimm.is/glacic/internal/acme"
 	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/events"
 	"grimm.is/glacic/internal/logging"
 	"grimm.is/glacic/internal/services"
+	"grimm.is/glacic/internal/services/dns/querylog"
 	"grimm.is/glacic/internal/upgrade"
 
 	"github.com/miekg/dns"
@@ -25,17 +29,30 @@ import (
 
 type Service struct {
 	servers        []*dns.Server
+	dohServers     []*dohServer
+	dnscryptServers []*dnscryptServer
 	config         *config.DNSServer
 	upstreams        []upstream // Unified list of upstreams (UDP, DoT, DoH)
 	dynamicUpstreams []upstream // From DHCP/etc
 	records          map[string]config.DNSRecord // FQDN -> Record
 	blockedDomains   map[string]bool             // Blocked domains
+	blockSources     map[string]string           // Blocked domain -> blocklist name
 	cache            map[string]cachedResponse
 	mu               sync.RWMutex
 	running          bool
 	stopCleanup      chan struct{}
 	upgradeMgr       *upgrade.Manager
 	fw               ValidatingFirewall
+	recursor         *recursor  // Set when mode = "recursive"
+	validator        *validator // Set when dnssec = true
+	events           *events.Hub
+	queryLog         *querylog.Store
+	queryLogging     bool
+	devices          MACLookup
+	limiter          *rateLimiter
+	rateLimit        rateLimitConfig
+	profiles         *profileSet // Per-client filtering profiles
+	acmeDir          string      // Where Let's Encrypt DoH certificates are stored
 	
 	// Egress Filter State
 	egressFilterEnabled bool
@@ -49,13 +66,15 @@ type ValidatingFirewall interface {
 
 type upstream struct {
 	Addr       string
-	Protocol   string // "udp", "tcp", "tcp-tls", "https"
+	Protocol   string // "udp", "tcp", "tcp-tls", "https", "dnscrypt"
 	ServerName string // For TLS/HTTPS verification
 	URL        string // For DoH (full URL)
+	DNSCrypt   *dnscryptClient // For DNSCrypt (certificate and session keys)
 }
 
 type cachedResponse struct {
 	msg       *dns.Msg
+	storedAt  time.Time
 	expiresAt time.Time
 }
 
@@ -65,8 +84,10 @@ func NewService(cfg *config.Config, logger *logging.Logger) *Service {
 		config:         &config.DNSServer{},
 		records:        make(map[string]config.DNSRecord),
 		blockedDomains: make(map[string]bool),
+		blockSources:   make(map[string]string),
 		cache:          make(map[string]cachedResponse),
 		stopCleanup:    make(chan struct{}),
+		limiter:        newRateLimiter(),
 	}
 }
 
@@ -77,6 +98,13 @@ func (s *Service) SetFirewall(fw ValidatingFirewall) {
 	s.fw = fw
 }
 
+// SetEventHub sets the event hub used to report DNSSEC validation failures
+func (s *Service) SetEventHub(hub *events.Hub) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = hub
+}
+
 // SetUpgradeManager sets the upgrade manager for socket handoff.
 func (s *Service) SetUpgradeManager(mgr *upgrade.Manager) {
 	s.mu.Lock()
@@ -98,7 +126,7 @@ func (s *Service) Start(ctx context.Context) error {
 		return nil
 	}
 
-	if len(s.servers) == 0 {
+	if len(s.servers) == 0 && len(s.dohServers) == 0 && len(s.dnscryptServers) == 0 {
 		// Nothing to start
 		return nil
 	}
@@ -118,6 +146,8 @@ func (s *Service) Start(ctx context.Context) error {
 			}
 		}(srv, i+1)
 	}
+	startDoH(s.dohServers)
+	startDNSCrypt(s.dnscryptServers)
 
 	// Start cleanup manually since we manage it
 	go s.startCacheCleanup()
@@ -141,6 +171,8 @@ func (s *Service) Stop(ctx context.Context) error {
 			log.Printf("[DNS] Failed to stop server %d: %v", i+1, err)
 		}
 	}
+	stopDoH(s.dohServers)
+	stopDNSCrypt(s.dnscryptServers)
 
 	// Stop cleanup
 	select {
@@ -148,6 +180,10 @@ func (s *Service) Stop(ctx context.Context) error {
 	default:
 	}
 
+	if s.recursor != nil {
+		s.recursor.close()
+	}
+
 	s.running = false
 	return nil
 }
@@ -174,6 +210,7 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 		s.egressFilterEnabled = false
 		s.egressFilterTTL = 0
 	}
+	s.acmeDir = acme.DirFor(cfg)
 	s.mu.Unlock()
 
 	// -------------------------------------------------------------
@@ -188,21 +225,36 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 		}
 
 		newServers := s.buildServers(state.listenAddrs)
+		dotServers, dohServers := s.buildEncryptedServers(state.dot, state.doh)
+		newServers = append(newServers, dotServers...)
+		dnscryptServers := s.buildDNSCryptServers(state.dnscrypt)
 
 		s.mu.Lock()
 		// Map back to legacy config structure for internal compatibility
 		s.config = &config.DNSServer{
 			ConditionalForwarders: cfg.DNS.ConditionalForwarders,
+			DNSSEC:                cfg.DNS.DNSSEC,
+			TrustAnchors:          cfg.DNS.TrustAnchors,
+			TrustAnchorFile:       cfg.DNS.TrustAnchorFile,
 		}
 		// Convert new mode forwarders (strings) to upstreams (udp)
 		var newUpstreams []upstream
 		for _, fwd := range state.forwarders {
 			newUpstreams = append(newUpstreams, upstream{Addr: fwd, Protocol: "udp"})
 		}
+		newUpstreams = append(newUpstreams, buildDNSCryptUpstreams(cfg.DNS.UpstreamDNSCrypt)...)
 		s.upstreams = newUpstreams
 		s.records = state.records
 		s.blockedDomains = state.blockedDomains
+		s.blockSources = state.blockSources
 		s.servers = newServers
+		s.dohServers = dohServers
+		s.dnscryptServers = dnscryptServers
+		s.setRecursorLocked(cfg.DNS.Mode, cfg.DNS.Recursive)
+		s.setValidatorLocked(s.config)
+		s.setQueryLoggingLocked(state.queryLogging, cfg.DNS.QueryLogRetentionDays)
+		s.rateLimit = state.rateLimit
+		s.profiles = state.profiles
 		s.mu.Unlock()
 
 		return true, s.Start(context.Background())
@@ -237,7 +289,6 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 
 	// 1. Pre-load everything into local variables
 	newRecords := make(map[string]config.DNSRecord)
-	newBlocked := make(map[string]bool)
 	var newServers []*dns.Server
 
 	// Build upstreams (Legacy UDP + DoT + DoH)
@@ -274,8 +325,7 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 	}
 
 	// Load blocklists
-	var err error
-	newBlocked, err = loadBlocklistsFromConfig(dnsCfg.Blocklists)
+	newBlocked, newSources, err := loadBlocklistsFromConfig(dnsCfg.Blocklists)
 	if err != nil {
 		log.Printf("[DNS] Warning: errors occurred loading blocklists: %v", err)
 	}
@@ -343,7 +393,9 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 					break
 				}
 			}
-			if match {
+			if match && reflect.DeepEqual(oldConfig.DoTServer, dnsCfg.DoTServer) &&
+				reflect.DeepEqual(oldConfig.DoHServer, dnsCfg.DoHServer) &&
+				reflect.DeepEqual(oldConfig.DNSCryptServer, dnsCfg.DNSCryptServer) {
 				listenersChanged = false
 			}
 		}
@@ -361,13 +413,27 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 			listeners = []string{"0.0.0.0"}
 		}
 		newServers = s.buildServers(listeners)
+		dotServers, dohServers := s.buildEncryptedServers(
+			dotListeners(dnsCfg.DoTServer, listeners),
+			dohListeners(dnsCfg.DoHServer, listeners),
+		)
+		newServers = append(newServers, dotServers...)
+		dnscryptServers := s.buildDNSCryptServers(dnscryptListeners(dnsCfg.DNSCryptServer, listeners))
 
 		s.mu.Lock()
 		s.config = dnsCfg
 		s.upstreams = newUpstreams
 		s.records = newRecords
 		s.blockedDomains = newBlocked
+		s.blockSources = newSources
 		s.servers = newServers
+		s.dohServers = dohServers
+		s.dnscryptServers = dnscryptServers
+		s.setRecursorLocked(dnsCfg.Mode, dnsCfg.Recursive)
+		s.setValidatorLocked(dnsCfg)
+		s.setQueryLoggingLocked(dnsCfg.QueryLogging, dnsCfg.QueryLogRetentionDays)
+		s.rateLimit = rateLimitFromServer(dnsCfg)
+		s.profiles = buildProfileSet(cfg)
 		s.mu.Unlock()
 
 		return true, s.Start(context.Background())
@@ -378,12 +444,33 @@ func (s *Service) Reload(cfg *config.Config) (bool, error) {
 		s.upstreams = newUpstreams
 		s.records = newRecords
 		s.blockedDomains = newBlocked
+		s.blockSources = newSources
+		s.setRecursorLocked(dnsCfg.Mode, dnsCfg.Recursive)
+		s.setValidatorLocked(dnsCfg)
+		s.setQueryLoggingLocked(dnsCfg.QueryLogging, dnsCfg.QueryLogRetentionDays)
+		s.rateLimit = rateLimitFromServer(dnsCfg)
+		s.profiles = buildProfileSet(cfg)
 		s.mu.Unlock()
 		log.Printf("[DNS] Hot-reloaded configuration (no restart)")
 		return true, nil
 	}
 }
 
+// setRecursorLocked replaces the recursive resolver to match the configured
+// mode. Caller must hold s.mu.
+func (s *Service) setRecursorLocked(mode string, rc *config.RecursiveConfig) {
+	if s.recursor != nil {
+		s.recursor.close()
+		s.recursor = nil
+	}
+	if mode != "recursive" {
+		return
+	}
+	s.recursor = newRecursor(s, rc)
+	s.recursor.start()
+	log.Printf("[DNS] Recursive resolution enabled")
+}
+
 func uniqueStrings(input []string) []string {
 	u := make([]string, 0, len(input))
 	m := make(map[string]bool)
@@ -525,8 +612,11 @@ func (s *Service) loadHostsFile(path string) {
 	log.Printf("[DNS] Loaded %d records from %s", count, path)
 }
 
-func loadBlocklistsFromConfig(blocklists []config.DNSBlocklist) (map[string]bool, error) {
+// loadBlocklistsFromConfig returns the blocked domain set and, for the
+// query log, the name of the list that blocked each domain.
+func loadBlocklistsFromConfig(blocklists []config.DNSBlocklist) (map[string]bool, map[string]string, error) {
 	blocked := make(map[string]bool)
+	sources := make(map[string]string)
 	cachePath := "/var/lib/glacic/blocklist_cache"
 
 	for _, bl := range blocklists {
@@ -574,12 +664,13 @@ func loadBlocklistsFromConfig(blocklists []config.DNSBlocklist) (map[string]bool
 		for _, d := range domains {
 			domain := strings.ToLower(dns.Fqdn(d))
 			blocked[domain] = true
+			sources[domain] = bl.Name
 			count++
 		}
 		log.Printf("[DNS] Loaded %d domains from blocklist %s (%s)", count, bl.Name, source)
 	}
 
-	return blocked, nil
+	return blocked, sources, nil
 }
 
 // AddRecord adds or updates a DNS record dynamically
@@ -623,6 +714,7 @@ func (s *Service) UpdateBlockedDomains(domains []string) {
 		domain := strings.ToLower(dns.Fqdn(d))
 		if !s.blockedDomains[domain] {
 			s.blockedDomains[domain] = true
+			s.blockSources[domain] = threatIntelSource
 			count++
 		}
 	}
@@ -707,6 +799,7 @@ func (s *Service) RemoveBlockedDomains(domains []string) {
 	for _, d := range domains {
 		domain := strings.ToLower(dns.Fqdn(d))
 		delete(s.blockedDomains, domain)
+		delete(s.blockSources, domain)
 	}
 }
 
@@ -725,41 +818,96 @@ func (s *Service) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
+	s.mu.RLock()
+	rl := s.rateLimit
+	s.mu.RUnlock()
+	if rl.perSec > 0 && !s.limiter.allowQuery(w, r, rl) {
+		return
+	}
+	if rl.rrlPerSec > 0 && isUDP(w) {
+		w = &rrlWriter{ResponseWriter: w, limiter: s.limiter, cfg: rl}
+	}
+
+	if lw := s.newQueryLogWriter(w, r); lw != nil {
+		w = lw
+		defer lw.record()
+	}
+
+	s.resolve(w, r)
+}
+
+// resolve answers a query that has passed rate limiting: filtering first,
+// then cache, local records, conditional forwarders and upstreams.
+func (s *Service) resolve(w dns.ResponseWriter, r *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Compress = false
+
 	q := r.Question[0]
 	name := strings.ToLower(q.Name)
 
-	// Check Blocklists
+	// Pick the client's filtering profile, if any
 	s.mu.RLock()
+	var profile *filterProfile
+	if s.profiles != nil {
+		profile = s.profiles.selectProfile(clientIP(w), s.devices)
+	}
+
+	// Check Blocklists
 	blocked := s.blockedDomains[name]
+	source := s.blockSources[name]
 	// Also check without trailing dot
 	if !blocked && strings.HasSuffix(name, ".") {
 		blocked = s.blockedDomains[name[:len(name)-1]]
+		source = s.blockSources[name[:len(name)-1]]
 	}
 	s.mu.RUnlock()
 
+	if profile != nil {
+		// The profile replaces the serve blocklists; threat intel still applies
+		if source != threatIntelSource {
+			blocked = false
+			if list, ok := profile.blocked[name]; ok && !profile.allowed(name) {
+				log.Printf("[DNS] Blocked query for %s (profile %s)", name, profile.name)
+				noteBlocked(w, list)
+				w.WriteMsg(profile.blockedAnswer(r))
+				return
+			}
+		}
+		if target, ok := safeSearchTargets[name]; ok && profile.safeSearch && !blocked {
+			s.serveSafeSearch(w, r, target)
+			return
+		}
+	}
+
 	if blocked {
 		log.Printf("[DNS] Blocked query for %s", name)
+		noteBlocked(w, source)
 		msg.Rcode = dns.RcodeNameError // NXDOMAIN
 		w.WriteMsg(msg)
 		return
 	}
 
 	// Check Cache
-	cacheKey := fmt.Sprintf("%s:%d", name, q.Qtype)
 	s.mu.RLock()
-	cached, found := s.cache[cacheKey]
+	cached, found := s.cache[cacheKey(name, q.Qtype)]
+	rec := s.recursor
 	s.mu.RUnlock()
 
-	if found && clock.Now().Before(cached.expiresAt) {
+	if now := clock.Now(); found && now.Before(cached.expiresAt) {
+		if rec != nil && rec.cfg.Prefetch && cached.prefetchDue(now) {
+			go rec.prefetchQuestion(q)
+		}
 		resp := cached.msg.Copy()
 		resp.SetReply(r)
+		noteUpstream(w, "cache")
 		w.WriteMsg(resp)
 		return
 	}
 
 	// Local Lookup
 	s.mu.RLock()
-	rec, ok := s.records[name]
+	local, ok := s.records[name]
 	s.mu.RUnlock()
 
 	if ok {
@@ -769,9 +917,10 @@ func (s *Service) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		// If q.Qtype is AAAA and we have A, empty response (NODATA) or next logic?
 		// Simplified: only return if type matches.
 
-		rr := s.createRR(q, rec)
+		rr := s.createRR(q, local)
 		if rr != nil {
 			msg.Answer = append(msg.Answer, rr)
+			noteUpstream(w, "local")
 			w.WriteMsg(msg)
 			return
 		}
@@ -786,11 +935,19 @@ func (s *Service) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			for _, srv := range cf.Servers {
 				cfUpstreams = append(cfUpstreams, upstream{Addr: srv, Protocol: "udp"})
 			}
-			s.forwardTo(w, r, cfUpstreams)
+			// Private zones have no chain of trust from the public root
+			s.forwardTo(w, r, cfUpstreams, false)
 			return
 		}
 	}
 
+	// Recursive resolution from the root servers
+	if rec != nil {
+		noteUpstream(w, "recursive")
+		s.serveRecursive(w, r, rec)
+		return
+	}
+
 	// Forwarding
 	// Merge static and dynamic upstreams
 	var allUpstreams []upstream
@@ -804,7 +961,7 @@ func (s *Service) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	s.mu.RUnlock()
 
 	if len(allUpstreams) > 0 {
-		s.forwardTo(w, r, allUpstreams)
+		s.forwardTo(w, r, allUpstreams, true)
 		return
 	}
 
@@ -859,70 +1016,58 @@ func (s *Service) createRR(q dns.Question, rec config.DNSRecord) dns.RR {
 	return nil
 }
 
-func (s *Service) forwardTo(w dns.ResponseWriter, r *dns.Msg, upstreams []upstream) {
+func (s *Service) forwardTo(w dns.ResponseWriter, r *dns.Msg, upstreams []upstream, validate bool) {
 	if r == nil || len(r.Question) == 0 {
 		return
 	}
 
-	c := new(dns.Client)
-	c.Timeout = 2 * time.Second
+	s.mu.RLock()
+	v := s.validator
+	s.mu.RUnlock()
+	// Clients setting CD want the unvalidated answer (RFC 4035 section 3.2.2).
+	// It must not be cached, or clients that expect validation would get it.
+	cache := true
+	if !validate || r.CheckingDisabled {
+		cache = !validate || v == nil
+		v = nil
+	}
 
-	for _, up := range upstreams {
-		var resp *dns.Msg
-		var err error
+	// Enable DNSSEC if configured
+	if s.config.DNSSEC {
+		setDO(r)
+	}
+	req := r
+	if v != nil {
+		// Validate locally: ask upstreams to return bogus data instead of SERVFAIL
+		req = r.Copy()
+		req.CheckingDisabled = true
+	}
 
-		// Enable DNSSEC if configured
-		if s.config.DNSSEC {
-			setDO(r)
+	for _, up := range upstreams {
+		resp, err := s.exchange(req, up)
+		if err != nil || resp == nil {
+			continue
 		}
+		noteUpstream(w, upstreamName(up))
 
-		switch up.Protocol {
-		case "tcp-tls": // DNS-over-TLS
-			c.Net = "tcp-tls"
-			c.TLSConfig = &tls.Config{
-				ServerName: up.ServerName,
-				MinVersion: tls.VersionTLS12,
-			}
-			addr := up.Addr
-			if !strings.Contains(addr, ":") {
-				addr = addr + ":853"
-			}
-			resp, _, err = c.Exchange(r, addr)
-
-		case "https": // DNS-over-HTTPS
-			c.Net = "https"
-			// For DoH, Address is the URL
-			resp, _, err = c.Exchange(r, up.URL)
-
-		case "tcp":
-			c.Net = "tcp"
-			addr := up.Addr
-			if !strings.Contains(addr, ":") {
-				addr = addr + ":53"
-			}
-			resp, _, err = c.Exchange(r, addr)
-
-		default: // udp
-			c.Net = "udp"
-			addr := up.Addr
-			if !strings.Contains(addr, ":") {
-				addr = addr + ":53"
+		if v != nil {
+			secure, err := v.validate(resp)
+			if err != nil {
+				s.dnssecFailure(r, up, err)
+				dns.HandleFailed(w, r)
+				return
 			}
-			resp, _, err = c.Exchange(r, addr)
+			resp.AuthenticatedData = secure
+			resp.CheckingDisabled = r.CheckingDisabled
 		}
 
-		if err == nil && resp != nil {
-			// DNSSEC Validation Check
-			if s.config.DNSSEC && !validateResponse(resp) {
-				// Only warn for now, don't drop (Soft Fail)
-				// Real validation would require local trust anchors or hard fail mode
-				log.Printf("[DNSSEC] Warning: Response from %s not authenticated (AD bit unset)", up.Addr)
-			}
-
+		if cache {
 			s.cacheResponse(r, resp)
-			w.WriteMsg(resp)
-			return
+		} else {
+			s.snoopResponse(resp)
 		}
+		w.WriteMsg(resp)
+		return
 	}
 
 	// Failed to forward
@@ -930,6 +1075,62 @@ func (s *Service) forwardTo(w dns.ResponseWriter, r *dns.Msg, upstreams []upstre
 	dns.HandleFailed(w, r)
 }
 
+// upstreamName identifies an upstream in logs and the query log.
+func upstreamName(up upstream) string {
+	if up.Protocol == "https" {
+		return up.URL
+	}
+	return up.Addr
+}
+
+// exchange sends a query to a single upstream over its transport.
+func (s *Service) exchange(r *dns.Msg, up upstream) (*dns.Msg, error) {
+	c := new(dns.Client)
+	c.Timeout = 2 * time.Second
+
+	var resp *dns.Msg
+	var err error
+
+	switch up.Protocol {
+	case "tcp-tls": // DNS-over-TLS
+		c.Net = "tcp-tls"
+		c.TLSConfig = &tls.Config{
+			ServerName: up.ServerName,
+			MinVersion: tls.VersionTLS12,
+		}
+		addr := up.Addr
+		if !strings.Contains(addr, ":") {
+			addr = addr + ":853"
+		}
+		resp, _, err = c.Exchange(r, addr)
+
+	case "https": // DNS-over-HTTPS
+		c.Net = "https"
+		// For DoH, Address is the URL
+		resp, _, err = c.Exchange(r, up.URL)
+
+	case "dnscrypt": // DNSCrypt v2
+		resp, err = up.DNSCrypt.Exchange(r)
+
+	case "tcp":
+		c.Net = "tcp"
+		addr := up.Addr
+		if !strings.Contains(addr, ":") {
+			addr = addr + ":53"
+		}
+		resp, _, err = c.Exchange(r, addr)
+
+	default: // udp
+		c.Net = "udp"
+		addr := up.Addr
+		if !strings.Contains(addr, ":") {
+			addr = addr + ":53"
+		}
+		resp, _, err = c.Exchange(r, addr)
+	}
+	return resp, err
+}
+
 // GetCache returns the current DNS cache entries for upgrade state preservation
 func (s *Service) GetCache() []upgrade.DNSCacheEntry {
 	s.mu.Lock()
@@ -958,11 +1159,15 @@ func (s *Service) GetCache() []upgrade.DNSCacheEntry {
 func (s *Service) startCacheCleanup() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
+	limits := time.NewTicker(limitedClientWindow)
+	defer limits.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			s.cleanupCache()
+		case <-limits.C:
+			s.limiter.cleanup()
 		case <-s.stopCleanup:
 			return
 		}
@@ -987,7 +1192,32 @@ func (s *Service) cleanupCache() {
 }
 
 func (s *Service) cacheResponse(req, resp *dns.Msg) {
-	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+	s.cacheMsg(resp)
+
+	// Snoop response for firewall authorization
+	s.snoopResponse(resp)
+}
+
+// cacheKey returns the cache map key for a question.
+func cacheKey(name string, qtype uint16) string {
+	return fmt.Sprintf("%s:%d", strings.ToLower(name), qtype)
+}
+
+// cacheGet returns a copy of an unexpired cached response.
+func (s *Service) cacheGet(name string, qtype uint16) (*dns.Msg, bool) {
+	s.mu.RLock()
+	cached, found := s.cache[cacheKey(name, qtype)]
+	s.mu.RUnlock()
+
+	if !found || !clock.Now().Before(cached.expiresAt) {
+		return nil, false
+	}
+	return cached.msg.Copy(), true
+}
+
+// cacheMsg stores a positive response in the cache, keyed by its question.
+func (s *Service) cacheMsg(resp *dns.Msg) {
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 || len(resp.Question) == 0 {
 		return
 	}
 
@@ -1000,7 +1230,7 @@ func (s *Service) cacheResponse(req, resp *dns.Msg) {
 
 	// Don't cache very short TTLs
 	if minTTL > 5 {
-		cacheKey := fmt.Sprintf("%s:%d", strings.ToLower(req.Question[0].Name), req.Question[0].Qtype)
+		key := cacheKey(resp.Question[0].Name, resp.Question[0].Qtype)
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
@@ -1013,15 +1243,13 @@ func (s *Service) cacheResponse(req, resp *dns.Msg) {
 			}
 		}
 
-		s.cache[cacheKey] = cachedResponse{
+		now := clock.Now()
+		s.cache[key] = cachedResponse{
 			msg:       resp,
-			expiresAt: clock.Now().Add(time.Duration(minTTL) * time.Second),
+			storedAt:  now,
+			expiresAt: now.Add(time.Duration(minTTL) * time.Second),
 		}
 	}
-
-	
-	// Snoop response for firewall authorization
-	s.snoopResponse(resp)
 }
 
 func (s *Service) snoopResponse(resp *dns.Msg) {
@@ -1084,6 +1312,9 @@ func (s *Service) buildStaticUpstreams(cfg *config.DNSServer) []upstream {
 		}
 	}
 
+	// DNSCrypt
+	upstreams = append(upstreams, buildDNSCryptUpstreams(cfg.UpstreamDNSCrypt)...)
+
 	// UDP Forwarders (Legacy)
 	for _, fwd := range cfg.Forwarders {
 		upstreams = append(upstreams, upstream{
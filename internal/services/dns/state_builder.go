@@ -13,7 +13,14 @@ type serverState struct {
 	listenAddrs    []string
 	records        map[string]config.DNSRecord
 	blockedDomains map[string]bool
+	blockSources   map[string]string
 	forwarders     []string
+	dot            []encryptedListener
+	doh            []encryptedListener
+	dnscrypt       []dnscryptListener
+	queryLogging   bool
+	rateLimit      rateLimitConfig
+	profiles       *profileSet
 }
 
 // buildServerState constructs the DNS server state from the new config format.
@@ -22,7 +29,10 @@ func (s *Service) buildServerState(cfg *config.Config) *serverState {
 	state := &serverState{
 		records:        make(map[string]config.DNSRecord),
 		blockedDomains: make(map[string]bool),
+		blockSources:   make(map[string]string),
 		forwarders:     dnsCfg.Forwarders,
+		rateLimit:      rateLimitFromServe(dnsCfg.Serve),
+		profiles:       buildProfileSet(cfg),
 	}
 
 	// 1. Gather all listeners and records
@@ -75,17 +85,25 @@ func (s *Service) buildServerState(cfg *config.Config) *serverState {
 	// Process Serve blocks
 	for _, serve := range dnsCfg.Serve {
 		// Determine Listen IPs
+		var zoneAddrs []string
 		zoneName := strings.ToLower(serve.Zone)
 		if zoneName == "*" || zoneName == "any" {
 			// Listen on both IPv4 and IPv6 wildcards for full dual-stack support
-			listenAddrs = append(listenAddrs, "0.0.0.0", "::")
+			zoneAddrs = []string{"0.0.0.0", "::"}
 		} else {
 			if ips, ok := zoneIfaceMap[zoneName]; ok {
-				listenAddrs = append(listenAddrs, ips...)
+				zoneAddrs = ips
 			} else {
 				log.Printf("[DNS] Warning: serve zone %q has no associated interfaces/IPs", serve.Zone)
 			}
 		}
+		listenAddrs = append(listenAddrs, zoneAddrs...)
+
+		// Encrypted listeners (DoT/DoH/DNSCrypt) for this zone
+		state.dot = append(state.dot, dotListeners(serve.DoTServer, zoneAddrs)...)
+		state.doh = append(state.doh, dohListeners(serve.DoHServer, zoneAddrs)...)
+		state.dnscrypt = append(state.dnscrypt, dnscryptListeners(serve.DNSCryptServer, zoneAddrs)...)
+		state.queryLogging = state.queryLogging || serve.QueryLogging
 
 		// Hosts
 		for _, host := range serve.Hosts {
@@ -106,9 +124,10 @@ func (s *Service) buildServerState(cfg *config.Config) *serverState {
 
 		// Blocklists
 		if len(serve.Blocklists) > 0 {
-			bl, _ := loadBlocklistsFromConfig(serve.Blocklists)
+			bl, sources, _ := loadBlocklistsFromConfig(serve.Blocklists)
 			for k, v := range bl {
 				state.blockedDomains[k] = v
+				state.blockSources[k] = sources[k]
 			}
 		}
 	}
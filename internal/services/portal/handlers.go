@@ -0,0 +1,221 @@
+package portal
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 28em; margin: 2em auto; padding: 0 1em; }
+.terms { white-space: pre-wrap; border: 1px solid #ccc; padding: 0.5em; max-height: 15em; overflow: auto; }
+.error { color: #b00; }
+input, button { font-size: 1em; margin: 0.25em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Session}}
+<p>You are connected until {{.Session.Expires.Format "Jan 2 15:04"}}.</p>
+{{else}}
+{{if .Error}}<p class="error">Login failed: {{.Error}}.</p>{{end}}
+<form method="post" action="/login">
+<input type="hidden" name="url" value="{{.URL}}">
+{{if eq .Auth "voucher"}}<p><input name="code" placeholder="Voucher code" autocomplete="off" required></p>{{end}}
+{{if eq .Auth "password"}}<p><input type="password" name="password" placeholder="Password" required></p>{{end}}
+{{if .Terms}}<div class="terms">{{.Terms}}</div>
+<p><label><input type="checkbox" name="accept" value="1" required> I accept the terms of use</label></p>{{end}}
+<p><button type="submit">Connect</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type pageData struct {
+	Title   string
+	Terms   string
+	Auth    string
+	URL     string
+	Error   string
+	Session *Session
+}
+
+// Connectivity checks of common operating systems and the answers they
+// expect when the network is open.
+var probes = map[string]struct {
+	status int
+	body   string
+}{
+	"/generate_204":              {http.StatusNoContent, ""},                                                              // Android, ChromeOS
+	"/gen_204":                   {http.StatusNoContent, ""},                                                              // Android
+	"/hotspot-detect.html":       {http.StatusOK, "<HTML><HEAD><TITLE>Success</TITLE></HEAD><BODY>Success</BODY></HTML>"}, // Apple
+	"/library/test/success.html": {http.StatusOK, "<HTML><HEAD><TITLE>Success</TITLE></HEAD><BODY>Success</BODY></HTML>"}, // Apple
+	"/connecttest.txt":           {http.StatusOK, "Microsoft Connect Test"},                                               // Windows 10+
+	"/ncsi.txt":                  {http.StatusOK, "Microsoft NCSI"},                                                       // Windows
+	"/success.txt":               {http.StatusOK, "success\n"},                                                            // Firefox
+	"/canonical.html":            {http.StatusOK, ""},                                                                     // Firefox
+	"/check_network_status.txt":  {http.StatusOK, "NetworkManager is online\n"},                                           // GNOME
+}
+
+// handleRedirected answers requests the firewall redirected here, which were
+// meant for another site. Unauthenticated clients, including OS connectivity
+// checks, are sent to the login page; that is what makes devices open it.
+// Clients that logged in on a connection opened before then get the
+// expected probe answers or their original page.
+func (s *Service) handleRedirected(w http.ResponseWriter, r *http.Request) {
+	if host := hostOnly(r.Host); host == localHost(r) || host == s.cfg.Hostname {
+		// Opened directly rather than redirected
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	original := "http://" + r.Host + r.URL.RequestURI()
+
+	if _, ok := s.session(clientIP(r)); ok {
+		if probe, ok := probes[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(probe.status)
+			w.Write([]byte(probe.body))
+			return
+		}
+		http.Redirect(w, r, original, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, s.portalURL(r)+"?url="+url.QueryEscape(original), http.StatusFound)
+}
+
+func (s *Service) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, http.StatusOK, "")
+}
+
+func (s *Service) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if ip == nil {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	voucher, err := s.authenticate(r)
+	if err != nil {
+		log.Printf("[Portal] Login from %s rejected: %v", ip, err)
+		s.render(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	session, err := s.authorize(ip, voucher)
+	if err != nil {
+		log.Printf("[Portal] Failed to authorize %s: %v", ip, err)
+		http.Error(w, "login failed, please try again", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[Portal] Client %s (%s) logged in until %s", session.IP, session.MAC, session.Expires.Format(time.RFC3339))
+
+	if next := r.PostFormValue("url"); safeRedirect(next) {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	s.render(w, r, http.StatusOK, "")
+}
+
+// apiResponse is the RFC 8908 captive portal API state.
+type apiResponse struct {
+	Captive          bool   `json:"captive"`
+	UserPortalURL    string `json:"user-portal-url,omitempty"`
+	SecondsRemaining int64  `json:"seconds-remaining,omitempty"`
+	CanExtendSession bool   `json:"can-extend-session,omitempty"`
+}
+
+// handleAPI serves the RFC 8908 API advertised in DHCP option 114.
+func (s *Service) handleAPI(w http.ResponseWriter, r *http.Request) {
+	resp := apiResponse{Captive: true, UserPortalURL: s.portalURL(r)}
+	if session, ok := s.session(clientIP(r)); ok {
+		resp.Captive = false
+		resp.SecondsRemaining = int64(session.Expires.Sub(s.now()).Seconds())
+		// Vouchers are single use; other logins can simply be repeated
+		resp.CanExtendSession = s.cfg.Auth != "voucher"
+	}
+	w.Header().Set("Content-Type", "application/captive+json")
+	w.Header().Set("Cache-Control", "private")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Service) render(w http.ResponseWriter, r *http.Request, status int, errMsg string) {
+	data := pageData{
+		Title: s.cfg.Title,
+		Terms: s.cfg.Terms,
+		Auth:  s.cfg.Auth,
+		URL:   r.FormValue("url"),
+		Error: errMsg,
+	}
+	if data.Title == "" {
+		data.Title = defaultTitle
+	}
+	if session, ok := s.session(clientIP(r)); ok {
+		data.Session = &session
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		log.Printf("[Portal] Failed to render login page: %v", err)
+	}
+}
+
+// portalURL returns the login page URL. It uses the HTTPS listener when
+// there is one, as RFC 8908 requires, and otherwise the router address the
+// client reached.
+func (s *Service) portalURL(r *http.Request) string {
+	host := localHost(r)
+	if port := s.cfg.HTTPSPort(); port != 0 {
+		if s.cfg.Hostname != "" {
+			host = s.cfg.Hostname
+		}
+		return "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/login"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(s.cfg.HTTPPort())) + "/login"
+}
+
+// localHost returns the address the client connected to. For redirected
+// connections this is the router's address on the client's interface.
+func localHost(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			return host
+		}
+	}
+	return hostOnly(r.Host)
+}
+
+// hostOnly strips the port from a Host header.
+func hostOnly(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// safeRedirect reports whether a post-login destination is a plain web URL.
+func safeRedirect(next string) bool {
+	u, err := url.Parse(next)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package portal
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// lookupNeighbor returns the MAC address of a directly connected client.
+func lookupNeighbor(ip net.IP) net.HardwareAddr {
+	family := netlink.FAMILY_V6
+	if ip.To4() != nil {
+		family = netlink.FAMILY_V4
+	}
+	neighs, err := netlink.NeighList(0, family)
+	if err != nil {
+		return nil
+	}
+	for _, n := range neighs {
+		if n.IP.Equal(ip) && len(n.HardwareAddr) > 0 {
+			return n.HardwareAddr
+		}
+	}
+	return nil
+}
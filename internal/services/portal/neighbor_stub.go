@@ -0,0 +1,11 @@
+//go:build !linux
+
+package portal
+
+import "net"
+
+// lookupNeighbor is not supported on this platform; clients are admitted by
+// address only.
+func lookupNeighbor(ip net.IP) net.HardwareAddr {
+	return nil
+}
@@ -0,0 +1,310 @@
+// Package portal serves the captive portal shown to clients of guest zones.
+//
+// The firewall redirects unauthenticated HTTP from those zones here. After
+// login the client's MAC and address are added to timed nftables sets, so
+// access lapses on its own when the session ends.
+package portal
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+const (
+	// DefaultSessionTimeout is how long a login lasts without session_timeout.
+	DefaultSessionTimeout = 24 * time.Hour
+
+	defaultTitle = "Guest Network"
+)
+
+// Firewall admits authenticated clients.
+type Firewall interface {
+	AuthorizePortalClient(mac net.HardwareAddr, ip net.IP, ttl time.Duration) error
+}
+
+// Session is a logged in client.
+type Session struct {
+	IP      string    `json:"ip"`
+	MAC     string    `json:"mac,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// state is what survives restarts.
+type state struct {
+	Sessions []Session `json:"sessions"`
+	Redeemed []string  `json:"redeemed,omitempty"`
+}
+
+// Service is the captive portal web server.
+type Service struct {
+	cfg       *config.CaptivePortalConfig
+	timeout   time.Duration
+	fw        Firewall
+	statePath string
+
+	// lookupMAC resolves a client address from the neighbour table.
+	lookupMAC func(net.IP) net.HardwareAddr
+	now       func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]Session // by IP
+	redeemed map[string]bool
+
+	servers []*http.Server
+	wg      sync.WaitGroup
+}
+
+// NewService creates the portal. A nil cfg is a click-through portal with
+// the defaults. Sessions are persisted to statePath when set.
+func NewService(cfg *config.CaptivePortalConfig, fw Firewall, statePath string) *Service {
+	if cfg == nil {
+		cfg = &config.CaptivePortalConfig{}
+	}
+	timeout := DefaultSessionTimeout
+	if cfg.SessionTimeout != "" {
+		if d, err := time.ParseDuration(cfg.SessionTimeout); err == nil {
+			timeout = d
+		}
+	}
+	return &Service{
+		cfg:       cfg,
+		timeout:   timeout,
+		fw:        fw,
+		statePath: statePath,
+		lookupMAC: lookupNeighbor,
+		now:       time.Now,
+		sessions:  make(map[string]Session),
+		redeemed:  make(map[string]bool),
+	}
+}
+
+// Start restores saved sessions and starts the HTTP and, when configured,
+// HTTPS listeners.
+func (s *Service) Start(ctx context.Context) error {
+	if err := s.load(); err != nil {
+		log.Printf("[Portal] Failed to load sessions: %v", err)
+	}
+	s.restoreSessions()
+
+	handler := s.Handler()
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.HTTPPort()))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	s.serve(&http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}, ln)
+	log.Printf("[Portal] Listening on :%d", s.cfg.HTTPPort())
+
+	if port := s.cfg.HTTPSPort(); port != 0 {
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCert, s.cfg.TLSKey)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		s.serve(&http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}, tls.NewListener(tln, &tls.Config{Certificates: []tls.Certificate{cert}}))
+		log.Printf("[Portal] Listening on :%d (TLS)", port)
+	}
+	return nil
+}
+
+func (s *Service) serve(srv *http.Server, ln net.Listener) {
+	s.servers = append(s.servers, srv)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[Portal] Server error: %v", err)
+		}
+	}()
+}
+
+// Stop shuts down the listeners and saves the sessions.
+func (s *Service) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, srv := range s.servers {
+		srv.Shutdown(ctx)
+	}
+	s.wg.Wait()
+	s.servers = nil
+
+	if err := s.save(); err != nil {
+		log.Printf("[Portal] Failed to save sessions: %v", err)
+	}
+}
+
+// Handler returns the portal's HTTP handler.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /login", s.handleLoginPage)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("GET /api/captive", s.handleAPI)
+	mux.HandleFunc("/", s.handleRedirected)
+	return mux
+}
+
+// authenticate checks a login form against the configured method. It
+// redeems and returns the voucher used, if any, so that concurrent logins
+// cannot both claim it.
+func (s *Service) authenticate(r *http.Request) (string, error) {
+	if s.cfg.Terms != "" && r.PostFormValue("accept") == "" {
+		return "", errors.New("the terms of use were not accepted")
+	}
+	switch s.cfg.Auth {
+	case "voucher":
+		code := strings.TrimSpace(r.PostFormValue("code"))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, v := range s.cfg.Vouchers {
+			if subtle.ConstantTimeCompare([]byte(v), []byte(code)) == 1 && !s.redeemed[v] {
+				s.redeemed[v] = true
+				return v, nil
+			}
+		}
+		return "", errors.New("invalid or already used voucher")
+	case "password":
+		if subtle.ConstantTimeCompare([]byte(s.cfg.Password), []byte(r.PostFormValue("password"))) != 1 {
+			return "", errors.New("incorrect password")
+		}
+	}
+	return "", nil
+}
+
+// authorize admits a client until its session expires. The voucher
+// redeemed by authenticate is released again if the client can't be
+// admitted.
+func (s *Service) authorize(ip net.IP, voucher string) (Session, error) {
+	mac := s.lookupMAC(ip)
+	if err := s.fw.AuthorizePortalClient(mac, ip, s.timeout); err != nil {
+		if voucher != "" {
+			s.mu.Lock()
+			delete(s.redeemed, voucher)
+			s.mu.Unlock()
+		}
+		return Session{}, err
+	}
+
+	session := Session{IP: ip.String(), Expires: s.now().Add(s.timeout)}
+	if mac != nil {
+		session.MAC = mac.String()
+	}
+	s.mu.Lock()
+	s.sessions[session.IP] = session
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		log.Printf("[Portal] Failed to save sessions: %v", err)
+	}
+	return session, nil
+}
+
+// session returns the active session of a client.
+func (s *Service) session(ip net.IP) (Session, bool) {
+	if ip == nil {
+		return Session{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[ip.String()]
+	if !ok || !session.Expires.After(s.now()) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// Sessions returns the active sessions.
+func (s *Service) Sessions() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var out []Session
+	for ip, session := range s.sessions {
+		if !session.Expires.After(now) {
+			delete(s.sessions, ip)
+			continue
+		}
+		out = append(out, session)
+	}
+	return out
+}
+
+// restoreSessions re-admits clients whose sessions outlived a restart, in
+// case the firewall sets were lost with it.
+func (s *Service) restoreSessions() {
+	now := s.now()
+	for _, session := range s.Sessions() {
+		mac, _ := net.ParseMAC(session.MAC)
+		if err := s.fw.AuthorizePortalClient(mac, net.ParseIP(session.IP), session.Expires.Sub(now)); err != nil {
+			log.Printf("[Portal] Failed to restore session for %s: %v", session.IP, err)
+		}
+	}
+}
+
+func (s *Service) load() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range st.Sessions {
+		s.sessions[session.IP] = session
+	}
+	for _, v := range st.Redeemed {
+		s.redeemed[v] = true
+	}
+	return nil
+}
+
+func (s *Service) save() error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	st := state{Sessions: s.Sessions()}
+	s.mu.Lock()
+	for v := range s.redeemed {
+		st.Redeemed = append(st.Redeemed, v)
+	}
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Atomic write
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath)
+}
@@ -0,0 +1,264 @@
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+type authorized struct {
+	mac net.HardwareAddr
+	ip  net.IP
+	ttl time.Duration
+}
+
+type fakeFirewall struct {
+	mu    sync.Mutex
+	calls []authorized
+	err   error
+	delay time.Duration
+}
+
+func (f *fakeFirewall) AuthorizePortalClient(mac net.HardwareAddr, ip net.IP, ttl time.Duration) error {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.calls = append(f.calls, authorized{mac, ip, ttl})
+	return nil
+}
+
+var clientMAC, _ = net.ParseMAC("02:00:00:00:00:01")
+
+func newTestService(t *testing.T, cfg *config.CaptivePortalConfig, statePath string) (*Service, *fakeFirewall) {
+	t.Helper()
+	fw := &fakeFirewall{}
+	s := NewService(cfg, fw, statePath)
+	s.lookupMAC = func(net.IP) net.HardwareAddr { return clientMAC }
+	return s, fw
+}
+
+// request builds a request from a guest client that reached the portal on
+// the router's guest address, as a redirected connection would.
+func request(method, target string, form url.Values) *http.Request {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	r.RemoteAddr = "192.168.50.10:40000"
+	local := &net.TCPAddr{IP: net.ParseIP("192.168.50.1"), Port: config.DefaultCaptivePortalPort}
+	return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+}
+
+func serve(s *Service, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	return rec
+}
+
+func TestRedirectAndClickThrough(t *testing.T) {
+	s, fw := newTestService(t, &config.CaptivePortalConfig{SessionTimeout: "2h"}, "")
+
+	// Connectivity checks are sent to the login page
+	rec := serve(s, request("GET", "http://connectivitycheck.gstatic.com/generate_204", nil))
+	want := "http://192.168.50.1:2050/login?url=" + url.QueryEscape("http://connectivitycheck.gstatic.com/generate_204")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+		t.Fatalf("redirect %d %q, want %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+
+	rec = serve(s, request("GET", "/login?url=http%3A%2F%2Fexample.com%2F", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `value="http://example.com/"`) {
+		t.Fatalf("login page %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(s, request("POST", "/login", url.Values{"url": {"http://example.com/"}}))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "http://example.com/" {
+		t.Fatalf("login %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(fw.calls) != 1 || !fw.calls[0].ip.Equal(net.ParseIP("192.168.50.10")) ||
+		fw.calls[0].mac.String() != clientMAC.String() || fw.calls[0].ttl != 2*time.Hour {
+		t.Fatalf("firewall calls %+v", fw.calls)
+	}
+
+	// Probes on a connection still redirected to the portal now succeed
+	rec = serve(s, request("GET", "http://connectivitycheck.gstatic.com/generate_204", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("probe after login %d", rec.Code)
+	}
+	rec = serve(s, request("GET", "http://captive.apple.com/hotspot-detect.html", nil))
+	if !strings.Contains(rec.Body.String(), "Success") {
+		t.Errorf("apple probe after login %q", rec.Body.String())
+	}
+}
+
+func TestLoginMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    *config.CaptivePortalConfig
+		form   url.Values
+		wantOK bool
+	}{
+		{"password", &config.CaptivePortalConfig{Auth: "password", Password: "s3cret"}, url.Values{"password": {"s3cret"}}, true},
+		{"wrong password", &config.CaptivePortalConfig{Auth: "password", Password: "s3cret"}, url.Values{"password": {"guess"}}, false},
+		{"voucher", &config.CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABC123"}}, url.Values{"code": {" ABC123 "}}, true},
+		{"unknown voucher", &config.CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABC123"}}, url.Values{"code": {"XYZ"}}, false},
+		{"terms accepted", &config.CaptivePortalConfig{Terms: "Be nice."}, url.Values{"accept": {"1"}}, true},
+		{"terms not accepted", &config.CaptivePortalConfig{Terms: "Be nice."}, url.Values{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fw := newTestService(t, tt.cfg, "")
+			rec := serve(s, request("POST", "/login", tt.form))
+			if ok := rec.Code == http.StatusOK && len(fw.calls) == 1; ok != tt.wantOK {
+				t.Errorf("login ok = %v (status %d), want %v", ok, rec.Code, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestVoucherSingleUse(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "captive_portal.json")
+	cfg := &config.CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABC123"}}
+	s, _ := newTestService(t, cfg, statePath)
+
+	if rec := serve(s, request("POST", "/login", url.Values{"code": {"ABC123"}})); rec.Code != http.StatusOK {
+		t.Fatalf("first redemption %d", rec.Code)
+	}
+
+	// Redeemed vouchers and sessions survive a restart
+	s, fw := newTestService(t, cfg, statePath)
+	if err := s.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	s.restoreSessions()
+	if len(fw.calls) != 1 || fw.calls[0].mac.String() != clientMAC.String() {
+		t.Errorf("session not restored: %+v", fw.calls)
+	}
+	if rec := serve(s, request("POST", "/login", url.Values{"code": {"ABC123"}})); rec.Code != http.StatusForbidden {
+		t.Errorf("second redemption %d", rec.Code)
+	}
+}
+
+func TestVoucherConcurrentRedemption(t *testing.T) {
+	cfg := &config.CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABC123"}}
+	s, fw := newTestService(t, cfg, "")
+	fw.delay = 10 * time.Millisecond // widen the window between check and redemption
+
+	const logins = 20
+	codes := make(chan int, logins)
+	var wg sync.WaitGroup
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(s, request("POST", "/login", url.Values{"code": {"ABC123"}})).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			accepted++
+		}
+	}
+	if accepted != 1 || len(fw.calls) != 1 {
+		t.Errorf("voucher redeemed %d times (%d firewall calls), want once", accepted, len(fw.calls))
+	}
+}
+
+func TestVoucherReleasedOnFailure(t *testing.T) {
+	cfg := &config.CaptivePortalConfig{Auth: "voucher", Vouchers: []string{"ABC123"}, Terms: "Be nice."}
+	s, fw := newTestService(t, cfg, "")
+
+	// Terms not accepted: the voucher is not consumed
+	if rec := serve(s, request("POST", "/login", url.Values{"code": {"ABC123"}})); rec.Code != http.StatusForbidden {
+		t.Fatalf("login without terms %d", rec.Code)
+	}
+
+	// Firewall failure: the voucher is returned
+	fw.err = errors.New("nft failed")
+	form := url.Values{"code": {"ABC123"}, "accept": {"1"}}
+	if rec := serve(s, request("POST", "/login", form)); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("login with failing firewall %d", rec.Code)
+	}
+
+	fw.err = nil
+	if rec := serve(s, request("POST", "/login", form)); rec.Code != http.StatusOK {
+		t.Errorf("retry after failure %d", rec.Code)
+	}
+}
+
+func TestCaptiveAPI(t *testing.T) {
+	s, _ := newTestService(t, &config.CaptivePortalConfig{
+		TLSCert:  "/etc/portal.crt",
+		TLSKey:   "/etc/portal.key",
+		Hostname: "portal.example.com",
+	}, "")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	get := func() apiResponse {
+		t.Helper()
+		rec := serve(s, request("GET", "https://portal.example.com:2051/api/captive", nil))
+		if ct := rec.Header().Get("Content-Type"); ct != "application/captive+json" {
+			t.Errorf("content type %q", ct)
+		}
+		var resp apiResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+		return resp
+	}
+
+	resp := get()
+	if !resp.Captive || resp.UserPortalURL != "https://portal.example.com:2051/login" {
+		t.Errorf("before login: %+v", resp)
+	}
+
+	if _, err := s.authorize(net.ParseIP("192.168.50.10"), ""); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	resp = get()
+	if resp.Captive || resp.SecondsRemaining != int64(23*time.Hour/time.Second) || !resp.CanExtendSession {
+		t.Errorf("after login: %+v", resp)
+	}
+
+	// Expired sessions are captive again
+	now = now.Add(DefaultSessionTimeout)
+	if resp := get(); !resp.Captive {
+		t.Errorf("after expiry: %+v", resp)
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	for next, want := range map[string]bool{
+		"http://example.com/":  true,
+		"https://example.com/": true,
+		"javascript:alert(1)":  false,
+		"//example.com":        false,
+		"":                     false,
+	} {
+		if got := safeRedirect(next); got != want {
+			t.Errorf("safeRedirect(%q) = %v, want %v", next, got, want)
+		}
+	}
+}
@@ -13,9 +13,26 @@ import (
 	"github.com/mdlayher/ndp"
 )
 
+// Default lifetimes for advertised prefixes
+const (
+	defaultValidLifetime     = 86400 * time.Second // 1 day
+	defaultPreferredLifetime = 14400 * time.Second // 4 hours
+
+	// staleAdvertiseTime is how long a rotated-out delegated prefix keeps
+	// being advertised with zero lifetimes so hosts drop its addresses.
+	staleAdvertiseTime = 2 * time.Hour
+)
+
 // Service provides IPv6 Router Advertisements.
 type Service struct {
 	config []config.Interface
+	flags  map[string]raFlags // DHCPv6 flags by interface name
+
+	// Prefixes delegated at runtime (DHCPv6-PD), by interface name.
+	// Guarded by pdMu rather than mu, which Stop holds while runRA exits.
+	pdMu      sync.Mutex
+	delegated map[string]*delegatedPrefixes
+	updates   map[string]chan struct{} // Wakes runRA to advertise a change at once
 
 	ctx     context.Context
 	cancel  context.CancelFunc
@@ -24,20 +41,147 @@ type Service struct {
 	mu      sync.Mutex
 }
 
+// raFlags are the RA flags that point hosts at the DHCPv6 server.
+type raFlags struct {
+	managed bool // M: addresses are assigned by DHCPv6
+	other   bool // O: other configuration (DNS) is available via DHCPv6
+}
+
+// delegatedPrefixes tracks the delegated prefix on an interface and the ones
+// it replaced, which are advertised as stale until the deadline.
+type delegatedPrefixes struct {
+	current   netip.Prefix
+	preferred time.Duration
+	valid     time.Duration
+	learned   time.Time
+	stale     map[netip.Prefix]time.Time
+}
+
 func NewService(cfg *config.Config) *Service {
-	// Filter for interfaces with RA enabled
+	// Filter for interfaces with RA enabled and a static or delegated prefix
 	var raIfaces []config.Interface
+	updates := make(map[string]chan struct{})
 	for _, iface := range cfg.Interfaces {
-		if iface.RA && len(iface.IPv6) > 0 {
+		if iface.RA && (len(iface.IPv6) > 0 || iface.PDFrom != "") {
 			raIfaces = append(raIfaces, iface)
+			updates[iface.Name] = make(chan struct{}, 1)
 		}
 	}
 
 	return &Service{
-		config: raIfaces,
+		config:    raIfaces,
+		flags:     dhcpv6Flags(cfg),
+		delegated: make(map[string]*delegatedPrefixes),
+		updates:   updates,
+	}
+}
+
+// SetDelegatedPrefix advertises a prefix delegated to an interface at runtime.
+// A previous prefix replaced by an ISP rotation is deprecated with zero
+// lifetimes; an invalid prefix withdraws the delegation.
+func (s *Service) SetDelegatedPrefix(ifaceName string, prefix, previous netip.Prefix, preferred, valid time.Duration) {
+	s.pdMu.Lock()
+	update, ok := s.updates[ifaceName]
+	if !ok {
+		s.pdMu.Unlock()
+		return
+	}
+	d := s.delegated[ifaceName]
+	if d == nil {
+		d = &delegatedPrefixes{stale: make(map[netip.Prefix]time.Time)}
+		s.delegated[ifaceName] = d
+	}
+	if previous.IsValid() {
+		d.stale[previous] = time.Now().Add(staleAdvertiseTime)
+	}
+	if d.current.IsValid() && d.current != prefix {
+		d.stale[d.current] = time.Now().Add(staleAdvertiseTime)
+	}
+	delete(d.stale, prefix)
+	d.current = prefix
+	d.preferred = preferred
+	d.valid = valid
+	d.learned = time.Now()
+	s.pdMu.Unlock()
+
+	if prefix.IsValid() {
+		log.Printf("[RA] Advertising delegated prefix %s on %s", prefix, ifaceName)
+	}
+	select {
+	case update <- struct{}{}:
+	default:
+	}
+}
+
+// delegatedPrefixOptions returns the prefix information for the prefixes
+// delegated to an interface, including stale ones being deprecated.
+func (s *Service) delegatedPrefixOptions(ifaceName string) []ndp.Option {
+	s.pdMu.Lock()
+	defer s.pdMu.Unlock()
+
+	d := s.delegated[ifaceName]
+	if d == nil {
+		return nil
+	}
+
+	var opts []ndp.Option
+	now := time.Now()
+	if d.current.IsValid() {
+		// Never advertise past what the ISP delegated
+		elapsed := now.Sub(d.learned)
+		clamp := func(limit, lifetime time.Duration) time.Duration {
+			if lifetime -= elapsed; lifetime < 0 {
+				lifetime = 0
+			}
+			if lifetime > limit {
+				return limit
+			}
+			return lifetime.Truncate(time.Second)
+		}
+		opts = append(opts, prefixInformation(d.current, clamp(defaultPreferredLifetime, d.preferred), clamp(defaultValidLifetime, d.valid)))
+	}
+	for prefix, until := range d.stale {
+		if now.After(until) {
+			delete(d.stale, prefix)
+			continue
+		}
+		opts = append(opts, prefixInformation(prefix, 0, 0))
+	}
+	return opts
+}
+
+func prefixInformation(prefix netip.Prefix, preferred, valid time.Duration) *ndp.PrefixInformation {
+	return &ndp.PrefixInformation{
+		PrefixLength:                   uint8(prefix.Bits()),
+		OnLink:                         true,
+		AutonomousAddressConfiguration: true, // Enable SLAAC
+		ValidLifetime:                  valid,
+		PreferredLifetime:              preferred,
+		Prefix:                         prefix.Masked().Addr(),
 	}
 }
 
+// dhcpv6Flags derives the M and O flags from the DHCP scopes served by the
+// built-in server, matching when it starts DHCPv6 on an interface.
+func dhcpv6Flags(cfg *config.Config) map[string]raFlags {
+	flags := make(map[string]raFlags)
+	if cfg.DHCP == nil || !cfg.DHCP.Enabled || cfg.DHCP.Mode == "external" || cfg.DHCP.Mode == "import" {
+		return flags
+	}
+	for _, scope := range cfg.DHCP.Scopes {
+		f := flags[scope.Interface]
+		if scope.RangeStartV6 != "" {
+			f.managed = true
+			f.other = true
+		}
+		if len(scope.DNSServersV6) > 0 {
+			f.other = true
+		}
+		flags[scope.Interface] = f
+	}
+	return flags
+}
+
 func (s *Service) Start() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -95,17 +239,10 @@ func (s *Service) runRA(cfg config.Interface) {
 		if prefix.Addr().IsLinkLocalUnicast() {
 			continue
 		}
-		prefixOpts = append(prefixOpts, &ndp.PrefixInformation{
-			PrefixLength:                   uint8(prefix.Bits()),
-			OnLink:                         true,
-			AutonomousAddressConfiguration: true,                // Enable SLAAC
-			ValidLifetime:                  86400 * time.Second, // 1 day
-			PreferredLifetime:              14400 * time.Second, // 4 hours
-			Prefix:                         prefix.Addr(),
-		})
+		prefixOpts = append(prefixOpts, prefixInformation(prefix, defaultPreferredLifetime, defaultValidLifetime))
 	}
 
-	if len(prefixOpts) == 0 {
+	if len(prefixOpts) == 0 && cfg.PDFrom == "" {
 		log.Printf("[RA] No valid prefixes for %s, RA will not advertise SLAAC", cfg.Name)
 	}
 
@@ -119,8 +256,18 @@ func (s *Service) runRA(cfg config.Interface) {
 	// Start RS listener goroutine
 	go s.listenForRS(conn, cfg.Name, rsChan)
 
+	flags := s.flags[cfg.Name]
+	if flags.managed || flags.other {
+		log.Printf("[RA] Advertising DHCPv6 on %s (managed=%v, other=%v)", cfg.Name, flags.managed, flags.other)
+	}
+
+	// Static prefixes plus whatever is currently delegated
+	options := func() []ndp.Option {
+		return append(append([]ndp.Option(nil), prefixOpts...), s.delegatedPrefixOptions(cfg.Name)...)
+	}
+
 	// Send initial RA immediately
-	s.sendRA(conn, cfg.Name, prefixOpts)
+	s.sendRA(conn, cfg.Name, options(), flags)
 
 	for {
 		select {
@@ -128,10 +275,13 @@ func (s *Service) runRA(cfg config.Interface) {
 			return
 		case <-ticker.C:
 			// Periodic unsolicited RA
-			s.sendRA(conn, cfg.Name, prefixOpts)
+			s.sendRA(conn, cfg.Name, options(), flags)
+		case <-s.updates[cfg.Name]:
+			// Delegated prefix changed
+			s.sendRA(conn, cfg.Name, options(), flags)
 		case srcAddr := <-rsChan:
 			// Respond to Router Solicitation with unicast RA
-			s.sendRATo(conn, cfg.Name, prefixOpts, srcAddr)
+			s.sendRATo(conn, cfg.Name, options(), flags, srcAddr)
 		}
 	}
 }
@@ -166,13 +316,8 @@ func (s *Service) listenForRS(conn *ndp.Conn, ifaceName string, rsChan chan<- ne
 	}
 }
 
-func (s *Service) sendRA(conn *ndp.Conn, ifaceName string, prefixOpts []ndp.Option) {
-	ra := &ndp.RouterAdvertisement{
-		CurrentHopLimit:           64,
-		RouterSelectionPreference: ndp.Medium,
-		RouterLifetime:            1800 * time.Second, // 30 mins
-		Options:                   prefixOpts,
-	}
+func (s *Service) sendRA(conn *ndp.Conn, ifaceName string, prefixOpts []ndp.Option, flags raFlags) {
+	ra := newRA(prefixOpts, flags)
 
 	dst, _ := netip.ParseAddr("ff02::1")
 	if err := conn.WriteTo(ra, nil, dst); err != nil {
@@ -181,15 +326,22 @@ func (s *Service) sendRA(conn *ndp.Conn, ifaceName string, prefixOpts []ndp.Opti
 }
 
 // sendRATo sends a Router Advertisement to a specific address (unicast response to RS).
-func (s *Service) sendRATo(conn *ndp.Conn, ifaceName string, prefixOpts []ndp.Option, dst netip.Addr) {
-	ra := &ndp.RouterAdvertisement{
+func (s *Service) sendRATo(conn *ndp.Conn, ifaceName string, prefixOpts []ndp.Option, flags raFlags, dst netip.Addr) {
+	ra := newRA(prefixOpts, flags)
+
+	if err := conn.WriteTo(ra, nil, dst); err != nil {
+		log.Printf("[RA] Failed to send RA to %s on %s: %v", dst, ifaceName, err)
+	}
+}
+
+// newRA builds the Router Advertisement sent on an interface.
+func newRA(prefixOpts []ndp.Option, flags raFlags) *ndp.RouterAdvertisement {
+	return &ndp.RouterAdvertisement{
 		CurrentHopLimit:           64,
+		ManagedConfiguration:      flags.managed,
+		OtherConfiguration:        flags.other,
 		RouterSelectionPreference: ndp.Medium,
 		RouterLifetime:            1800 * time.Second, // 30 mins
 		Options:                   prefixOpts,
 	}
-
-	if err := conn.WriteTo(ra, nil, dst); err != nil {
-		log.Printf("[RA] Failed to send RA to %s on %s: %v", dst, ifaceName, err)
-	}
 }
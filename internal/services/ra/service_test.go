@@ -2,10 +2,13 @@ package ra
 
 import (
 	"context"
+	"net/netip"
 	"testing"
 	"time"
 
 	"grimm.is/glacic/internal/config"
+
+	"github.com/mdlayher/ndp"
 )
 
 func TestNewService(t *testing.T) {
@@ -114,3 +117,96 @@ func TestService_StartStop_Idempotency(t *testing.T) {
 		t.Error("Context should be canceled")
 	}
 }
+
+func TestNewService_DHCPv6Flags(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", RA: true, IPv6: []string{"2001:db8:0:1::1/64"}},
+			{Name: "eth1", RA: true, IPv6: []string{"2001:db8:0:2::1/64"}},
+			{Name: "eth2", RA: true, IPv6: []string{"2001:db8:0:3::1/64"}},
+		},
+		DHCP: &config.DHCPServer{
+			Enabled: true,
+			Scopes: []config.DHCPScope{
+				{Name: "stateful", Interface: "eth0", RangeStartV6: "2001:db8:0:1::100", RangeEndV6: "2001:db8:0:1::1ff"},
+				{Name: "stateless", Interface: "eth1", DNSServersV6: []string{"2001:db8:0:2::1"}},
+			},
+		},
+	}
+
+	svc := NewService(cfg)
+	if f := svc.flags["eth0"]; !f.managed || !f.other {
+		t.Errorf("eth0: expected M and O flags, got %+v", f)
+	}
+	if f := svc.flags["eth1"]; f.managed || !f.other {
+		t.Errorf("eth1: expected only O flag, got %+v", f)
+	}
+	if f := svc.flags["eth2"]; f.managed || f.other {
+		t.Errorf("eth2: expected no flags, got %+v", f)
+	}
+
+	ra := newRA(nil, svc.flags["eth0"])
+	if !ra.ManagedConfiguration || !ra.OtherConfiguration {
+		t.Error("RA should carry the M and O flags")
+	}
+
+	// External DHCP servers are not ours to advertise
+	cfg.DHCP.Mode = "external"
+	if f := NewService(cfg).flags["eth0"]; f.managed || f.other {
+		t.Errorf("external mode: expected no flags, got %+v", f)
+	}
+}
+
+func TestService_DelegatedPrefix(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.Interface{
+			{Name: "eth0", DHCPv6: true, PrefixDelegation: true},
+			{Name: "eth1", RA: true, PDFrom: "eth0", PDSubnetID: 1},
+		},
+	}
+	svc := NewService(cfg)
+	if len(svc.config) != 1 || svc.config[0].Name != "eth1" {
+		t.Fatalf("Expected eth1 to be configured from its delegated prefix, got %+v", svc.config)
+	}
+	if opts := svc.delegatedPrefixOptions("eth1"); len(opts) != 0 {
+		t.Errorf("Expected no prefixes before delegation, got %d", len(opts))
+	}
+
+	first := netip.MustParsePrefix("2001:db8:0:11::/64")
+	svc.SetDelegatedPrefix("eth1", first, netip.Prefix{}, time.Hour, 2*time.Hour)
+	select {
+	case <-svc.updates["eth1"]:
+	default:
+		t.Error("Expected an immediate RA to be requested")
+	}
+	opts := svc.delegatedPrefixOptions("eth1")
+	if len(opts) != 1 {
+		t.Fatalf("Expected 1 prefix, got %d", len(opts))
+	}
+	pi := opts[0].(*ndp.PrefixInformation)
+	if pi.Prefix != first.Addr() || pi.PrefixLength != 64 {
+		t.Errorf("Unexpected prefix %s/%d", pi.Prefix, pi.PrefixLength)
+	}
+	// Lifetimes never exceed the delegation
+	if pi.PreferredLifetime > time.Hour || pi.ValidLifetime > 2*time.Hour || pi.ValidLifetime < time.Hour {
+		t.Errorf("Unexpected lifetimes preferred=%s valid=%s", pi.PreferredLifetime, pi.ValidLifetime)
+	}
+
+	// ISP rotates the prefix: the old one is deprecated with zero lifetimes
+	second := netip.MustParsePrefix("2001:db8:0:21::/64")
+	svc.SetDelegatedPrefix("eth1", second, first, time.Hour, 2*time.Hour)
+	lifetimes := make(map[netip.Addr]time.Duration)
+	for _, opt := range svc.delegatedPrefixOptions("eth1") {
+		pi := opt.(*ndp.PrefixInformation)
+		lifetimes[pi.Prefix] = pi.ValidLifetime
+	}
+	if len(lifetimes) != 2 || lifetimes[first.Addr()] != 0 || lifetimes[second.Addr()] == 0 {
+		t.Errorf("Expected %s deprecated and %s advertised, got %v", first, second, lifetimes)
+	}
+
+	// Interfaces without RA are ignored
+	svc.SetDelegatedPrefix("eth0", first, netip.Prefix{}, time.Hour, 2*time.Hour)
+	if _, ok := svc.delegated["eth0"]; ok {
+		t.Error("eth0 does not send RAs and should be ignored")
+	}
+}
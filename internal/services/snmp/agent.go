@@ -0,0 +1,346 @@
+// Package snmp implements a read-only SNMPv2c/v3 agent.
+//
+// It serves the system, IF-MIB, IP-MIB and HOST-RESOURCES-MIB basics and an
+// enterprise MIB with firewall rule counters, DHCP lease counts and uplink
+// status, and sends traps on uplink failover. SNMPv3 uses the User-based
+// Security Model (RFC 3414) with MD5, SHA or SHA-256 authentication and DES
+// or AES privacy.
+package snmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// DefaultEnterpriseOID roots the enterprise MIB when enterprise_oid is not
+// set. No Private Enterprise Number is registered for the project, so
+// deployments that need a stable MIB should configure their own.
+const DefaultEnterpriseOID = "1.3.6.1.4.1.99999"
+
+const (
+	// maxMessageSize is the largest message sent or accepted.
+	maxMessageSize = 65507
+
+	// snapshotTTL is how long a table snapshot answers requests, so that
+	// walks see a consistent view without rebuilding it for every PDU.
+	snapshotTTL = 2 * time.Second
+)
+
+// Usm statistics counters (usmStats).
+const (
+	usmUnsupportedSecLevels = 1
+	usmNotInTimeWindows     = 2
+	usmUnknownUserNames     = 3
+	usmUnknownEngineIDs     = 4
+	usmWrongDigests         = 5
+	usmDecryptionErrors     = 6
+)
+
+// engineState is persisted so engine boots increase across restarts, as
+// RFC 3414 requires for replay protection.
+type engineState struct {
+	EngineID string `json:"engine_id"`
+	Boots    int32  `json:"boots"`
+}
+
+// Agent is the SNMP agent.
+type Agent struct {
+	cfg        *config.SNMPConfig
+	src        Sources
+	enterprise OID
+
+	engineID []byte
+	boots    int32
+	start    time.Time
+	users    map[string]*user
+
+	usmStats [usmDecryptionErrors + 1]atomic.Uint32
+
+	mu       sync.Mutex
+	cached   *table
+	cachedAt time.Time
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+// NewAgent creates an agent. The engine ID and boot counter are kept in
+// statePath.
+func NewAgent(cfg *config.SNMPConfig, src Sources, statePath string) (*Agent, error) {
+	enterprise := cfg.EnterpriseOID
+	if enterprise == "" {
+		enterprise = DefaultEnterpriseOID
+	}
+	oid, err := ParseOID(enterprise)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Agent{
+		cfg:        cfg,
+		src:        src,
+		enterprise: oid,
+		start:      time.Now(),
+		users:      make(map[string]*user),
+	}
+	if err := a.loadEngine(statePath); err != nil {
+		return nil, err
+	}
+	for _, u := range cfg.Users {
+		a.users[u.Name] = newUser(u, a.engineID)
+	}
+	return a, nil
+}
+
+// loadEngine restores the engine ID and counts this boot.
+func (a *Agent) loadEngine(path string) error {
+	var st engineState
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &st); err != nil {
+			log.Printf("[SNMP] Ignoring invalid engine state: %v", err)
+		}
+	}
+
+	if a.cfg.EngineID != "" {
+		if st.EngineID != a.cfg.EngineID {
+			st.Boots = 0
+		}
+		st.EngineID = a.cfg.EngineID
+	}
+	if st.EngineID == "" {
+		st.EngineID = hex.EncodeToString(a.newEngineID())
+		st.Boots = 0
+	}
+	id, err := hex.DecodeString(st.EngineID)
+	if err != nil {
+		return fmt.Errorf("invalid engine ID: %w", err)
+	}
+	// snmpEngineBoots latches at its maximum (RFC 3414 section 2.2.2)
+	if st.Boots < 2147483647 {
+		st.Boots++
+	}
+	a.engineID, a.boots = id, st.Boots
+
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save engine state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// newEngineID returns a random RFC 3411 engine ID in the enterprise's
+// space, using the octets format.
+func (a *Agent) newEngineID() []byte {
+	id := make([]byte, 13)
+	var pen uint32
+	if len(a.enterprise) > 6 {
+		pen = a.enterprise[6]
+	}
+	binary.BigEndian.PutUint32(id, 0x80000000|pen)
+	id[4] = 5
+	rand.Read(id[5:])
+	return id
+}
+
+// EngineID returns the agent's snmpEngineID.
+func (a *Agent) EngineID() []byte {
+	return a.engineID
+}
+
+// engineTime returns snmpEngineTime in seconds.
+func (a *Agent) engineTime() int32 {
+	return int32(time.Since(a.start) / time.Second)
+}
+
+// uptimeTicks returns sysUpTime in hundredths of a second.
+func (a *Agent) uptimeTicks() uint64 {
+	return uint64(time.Since(a.start) / (10 * time.Millisecond))
+}
+
+// table returns a recent snapshot of the agent's objects.
+func (a *Agent) table() *table {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cached == nil || time.Since(a.cachedAt) > snapshotTTL {
+		a.cached = a.snapshot()
+		a.cachedAt = time.Now()
+	}
+	return a.cached
+}
+
+// Start listens for requests.
+func (a *Agent) Start(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", a.cfg.ListenAddr())
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	a.conn = conn
+	log.Printf("[SNMP] Agent listening on %s", conn.LocalAddr())
+
+	a.wg.Add(1)
+	go a.serve(ctx)
+	return nil
+}
+
+// Stop closes the listener.
+func (a *Agent) Stop() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+	a.wg.Wait()
+}
+
+func (a *Agent) serve(ctx context.Context) {
+	defer a.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, peer, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[SNMP] Read error: %v", err)
+			continue
+		}
+		if resp := a.handle(bytes.Clone(buf[:n])); resp != nil {
+			if _, err := a.conn.WriteTo(resp, peer); err != nil {
+				log.Printf("[SNMP] Failed to reply to %s: %v", peer, err)
+			}
+		}
+	}
+}
+
+// handle answers one request. Malformed and unauthorized requests are
+// dropped.
+func (a *Agent) handle(pkt []byte) []byte {
+	m, err := decodeMessage(pkt)
+	if err != nil {
+		return nil
+	}
+	switch m.Version {
+	case version2c:
+		if a.cfg.Community == "" || subtle.ConstantTimeCompare(m.Community, []byte(a.cfg.Community)) != 1 {
+			return nil
+		}
+		resp := a.table().process(m.PDU, maxMessageSize-len(m.Community)-16)
+		if resp == nil {
+			return nil
+		}
+		return encodeV2c(m.Community, resp)
+	case version3:
+		return a.handleV3(m)
+	}
+	return nil
+}
+
+func (a *Agent) handleV3(m *message) []byte {
+	if m.Flags&flagPriv != 0 && m.Flags&flagAuth == 0 {
+		return nil
+	}
+	level := m.Flags & (flagAuth | flagPriv)
+
+	// Discovery: managers learn the engine ID from the report
+	if !bytes.Equal(m.Security.EngineID, a.engineID) {
+		return a.report(m, usmUnknownEngineIDs, nil)
+	}
+	u, ok := a.users[string(m.Security.UserName)]
+	if !ok {
+		return a.report(m, usmUnknownUserNames, nil)
+	}
+	if level&^u.flags() != 0 {
+		return a.report(m, usmUnsupportedSecLevels, nil)
+	}
+
+	if level&flagAuth != 0 {
+		if !u.verify(m) {
+			return a.report(m, usmWrongDigests, nil)
+		}
+		// Time synchronization reports are authenticated (RFC 3414 3.2.7)
+		diff := m.Security.EngineTime - a.engineTime()
+		if m.Security.EngineBoots != a.boots || diff > timeWindow || diff < -timeWindow {
+			return a.report(m, usmNotInTimeWindows, u)
+		}
+	}
+	if level&flagPriv != 0 {
+		plain, err := u.decrypt(m.scopedPDU, m.Security.PrivParams, m.Security.EngineBoots, m.Security.EngineTime)
+		if err == nil {
+			var sd *decoder
+			if sd, err = (&decoder{buf: plain}).sequence(); err == nil {
+				err = m.decodeScopedPDU(sd.buf)
+			}
+		}
+		if err != nil {
+			return a.report(m, usmDecryptionErrors, nil)
+		}
+	}
+
+	resp := &PDU{Type: pduResponse, RequestID: m.PDU.RequestID, ErrorStatus: errAuthorizationError}
+	// Users are only readable at their configured security level
+	if level == u.flags() {
+		if resp = a.table().process(m.PDU, min(m.MaxSize, maxMessageSize)-200); resp == nil {
+			return nil
+		}
+	}
+	out, err := u.wrap(m.MsgID, level, a.usmParams(), a.engineID, m.ContextName, resp)
+	if err != nil {
+		log.Printf("[SNMP] Failed to encode response: %v", err)
+		return nil
+	}
+	return out
+}
+
+// usmParams returns the agent's authoritative security parameters.
+func (a *Agent) usmParams() usmParams {
+	return usmParams{EngineID: a.engineID, EngineBoots: a.boots, EngineTime: a.engineTime()}
+}
+
+// report counts a USM error and, if the request asked for it, returns a
+// Report. Reports are unauthenticated unless auth is given.
+func (a *Agent) report(m *message, stat int, auth *user) []byte {
+	count := a.usmStats[stat].Add(1)
+	if m.Flags&flagReportable == 0 {
+		return nil
+	}
+
+	var reqID int32
+	if m.PDU != nil {
+		reqID = m.PDU.RequestID
+	}
+	pdu := &PDU{
+		Type:      pduReport,
+		RequestID: reqID,
+		VarBinds:  []VarBind{{OID: oidUsmStats.Append(uint32(stat), 0), Value: Counter32(uint64(count))}},
+	}
+
+	u, flags := &user{name: string(m.Security.UserName)}, byte(0)
+	if auth != nil {
+		u, flags = auth, flagAuth
+	}
+	out, err := u.wrap(m.MsgID, flags, a.usmParams(), a.engineID, m.ContextName, pdu)
+	if err != nil {
+		return nil
+	}
+	return out
+}
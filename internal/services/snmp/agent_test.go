@@ -0,0 +1,290 @@
+package snmp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+	"grimm.is/glacic/internal/logging"
+	"grimm.is/glacic/internal/metrics"
+)
+
+// TestLocalizeKey checks the key localization vectors of RFC 3414
+// appendix A.3.
+func TestLocalizeKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := map[string]string{
+		"md5": "526f5eed9fcce26f8964c2930787d82b",
+		"sha": "6695febc9288e36282235fc7151f128497b38f3f",
+	}
+	for name, want := range tests {
+		got := hex.EncodeToString(localizeKey(authProtocols[name].hash, "maplesyrup", engineID))
+		if got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+}
+
+func newTestAgent(t *testing.T, cfg *config.SNMPConfig) *Agent {
+	t.Helper()
+	collector := metrics.NewCollector(logging.New(logging.DefaultConfig()), time.Minute)
+	collector.UpdateDHCPStats(true, 7, 9)
+	a, err := NewAgent(cfg, Sources{Metrics: collector}, filepath.Join(t.TempDir(), "snmp_engine.json"))
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	return a
+}
+
+func v2cRequest(community string, typ byte, oids ...string) []byte {
+	pdu := &PDU{Type: typ, RequestID: 42}
+	for _, oid := range oids {
+		pdu.VarBinds = append(pdu.VarBinds, VarBind{OID: MustParseOID(oid), Value: null})
+	}
+	return encodeV2c([]byte(community), pdu)
+}
+
+func decodeResponse(t *testing.T, resp []byte) *PDU {
+	t.Helper()
+	if resp == nil {
+		t.Fatal("no response")
+	}
+	m, err := decodeMessage(resp)
+	if err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return m.PDU
+}
+
+func TestAgentV2c(t *testing.T) {
+	a := newTestAgent(t, &config.SNMPConfig{Enabled: true, Community: "public", Location: "rack 4"})
+	dhcpActive := a.enterprise.Append(1, 2, 1, 0).String()
+
+	pdu := decodeResponse(t, a.handle(v2cRequest("public", pduGetRequest, "1.3.6.1.2.1.1.6.0", dhcpActive, "1.3.6.1.2.1.1.6.1", "1.3.6.1.2.1.99.0")))
+	if pdu.RequestID != 42 || len(pdu.VarBinds) != 4 {
+		t.Fatalf("unexpected response %+v", pdu)
+	}
+	if got := pdu.VarBinds[0].Value; string(got.Bytes) != "rack 4" {
+		t.Errorf("sysLocation = %v", got)
+	}
+	if got := pdu.VarBinds[1].Value; got.Tag != tagGauge32 || got.Uint != 7 {
+		t.Errorf("DHCP active leases = %v", got)
+	}
+	if pdu.VarBinds[2].Value.Tag != tagNoSuchInstance || pdu.VarBinds[3].Value.Tag != tagNoSuchObject {
+		t.Errorf("missing objects: %v, %v", pdu.VarBinds[2].Value, pdu.VarBinds[3].Value)
+	}
+
+	// Walk the system group
+	pdu = decodeResponse(t, a.handle(v2cRequest("public", pduGetNextRequest, "1.3.6.1.2.1.1")))
+	if got := pdu.VarBinds[0].OID.String(); got != "1.3.6.1.2.1.1.1.0" {
+		t.Errorf("GetNext returned %s", got)
+	}
+
+	// GetBulk of the interface table; the loopback interface always exists
+	bulk := &PDU{Type: pduGetBulkRequest, RequestID: 1, ErrorStatus: 1, ErrorIndex: 3, VarBinds: []VarBind{
+		{OID: MustParseOID("1.3.6.1.2.1.2.1"), Value: null},
+		{OID: MustParseOID("1.3.6.1.2.1.2.2.1.2"), Value: null},
+	}}
+	pdu = decodeResponse(t, a.handle(encodeV2c([]byte("public"), bulk)))
+	if len(pdu.VarBinds) < 2 || pdu.VarBinds[0].OID.String() != "1.3.6.1.2.1.2.1.0" {
+		t.Fatalf("unexpected bulk response %v", pdu.VarBinds)
+	}
+	if !pdu.VarBinds[1].OID.HasPrefix(MustParseOID("1.3.6.1.2.1.2.2.1.2")) || pdu.VarBinds[1].Value.Tag != tagOctetString {
+		t.Errorf("ifDescr not returned: %v", pdu.VarBinds[1])
+	}
+
+	pdu = decodeResponse(t, a.handle(v2cRequest("public", pduSetRequest, "1.3.6.1.2.1.1.6.0")))
+	if pdu.ErrorStatus != errNotWritable {
+		t.Errorf("Set answered with status %d", pdu.ErrorStatus)
+	}
+
+	if a.handle(v2cRequest("private", pduGetRequest, "1.3.6.1.2.1.1.6.0")) != nil {
+		t.Error("wrong community answered")
+	}
+}
+
+func TestAgentV3(t *testing.T) {
+	users := []config.SNMPUser{
+		{Name: "sha-aes", AuthPassword: "authpass1", PrivPassword: "privpass1"},
+		{Name: "md5-des", AuthProtocol: "md5", AuthPassword: "authpass2", PrivProtocol: "des", PrivPassword: "privpass2"},
+		{Name: "sha256", AuthProtocol: "sha256", AuthPassword: "authpass3"},
+	}
+	a := newTestAgent(t, &config.SNMPConfig{Enabled: true, Contact: "noc@example.com", Users: users})
+	sysContact := MustParseOID("1.3.6.1.2.1.1.4.0")
+	get := &PDU{Type: pduGetRequest, RequestID: 7, VarBinds: []VarBind{{OID: sysContact, Value: null}}}
+
+	// Discovery with an empty engine ID
+	discovery, _ := (&user{}).wrap(1, flagReportable, usmParams{}, nil, nil, get)
+	m, err := decodeMessage(a.handle(discovery))
+	if err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if m.PDU.Type != pduReport || !m.PDU.VarBinds[0].OID.HasPrefix(oidUsmStats.Append(usmUnknownEngineIDs)) {
+		t.Fatalf("unexpected discovery response %+v", m.PDU)
+	}
+	if !bytes.Equal(m.Security.EngineID, a.EngineID()) || m.Security.EngineBoots != 1 {
+		t.Errorf("report carries engine %x boots %d", m.Security.EngineID, m.Security.EngineBoots)
+	}
+
+	for _, cfg := range users {
+		t.Run(cfg.Name, func(t *testing.T) {
+			u := newUser(cfg, a.EngineID())
+			sec := usmParams{EngineID: a.EngineID(), EngineBoots: a.boots, EngineTime: a.engineTime()}
+			req, err := u.wrap(2, u.flags()|flagReportable, sec, a.EngineID(), nil, get)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := decodeMessage(a.handle(req))
+			if err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.Flags != u.flags() || !u.verify(resp) {
+				t.Fatalf("response not authenticated (flags %x)", resp.Flags)
+			}
+			if resp.Flags&flagPriv != 0 {
+				plain, err := u.decrypt(resp.scopedPDU, resp.Security.PrivParams, resp.Security.EngineBoots, resp.Security.EngineTime)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sd, err := (&decoder{buf: plain}).sequence()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := resp.decodeScopedPDU(sd.buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if resp.PDU.Type != pduResponse || string(resp.PDU.VarBinds[0].Value.Bytes) != "noc@example.com" {
+				t.Errorf("unexpected response %+v", resp.PDU)
+			}
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		bad := users[0]
+		bad.AuthPassword = "wrongpass"
+		u := newUser(bad, a.EngineID())
+		sec := usmParams{EngineID: a.EngineID(), EngineBoots: a.boots, EngineTime: a.engineTime()}
+		req, _ := u.wrap(3, u.flags()|flagReportable, sec, a.EngineID(), nil, get)
+		m, err := decodeMessage(a.handle(req))
+		if err != nil || m.PDU == nil || !m.PDU.VarBinds[0].OID.HasPrefix(oidUsmStats.Append(usmWrongDigests)) {
+			t.Errorf("expected wrongDigests report, got %+v, %v", m, err)
+		}
+	})
+
+	t.Run("not in time window", func(t *testing.T) {
+		u := newUser(users[2], a.EngineID())
+		sec := usmParams{EngineID: a.EngineID(), EngineBoots: a.boots, EngineTime: a.engineTime() + 1000}
+		req, _ := u.wrap(4, u.flags()|flagReportable, sec, a.EngineID(), nil, get)
+		m, err := decodeMessage(a.handle(req))
+		if err != nil || !m.PDU.VarBinds[0].OID.HasPrefix(oidUsmStats.Append(usmNotInTimeWindows)) {
+			t.Fatalf("expected notInTimeWindows report, got %+v, %v", m, err)
+		}
+		if m.Flags != flagAuth || !u.verify(m) {
+			t.Error("time window report not authenticated")
+		}
+	})
+
+	t.Run("lower security level", func(t *testing.T) {
+		u := newUser(config.SNMPUser{Name: "sha-aes", AuthPassword: "authpass1"}, a.EngineID())
+		sec := usmParams{EngineID: a.EngineID(), EngineBoots: a.boots, EngineTime: a.engineTime()}
+		req, _ := u.wrap(5, flagAuth, sec, a.EngineID(), nil, get)
+		m, err := decodeMessage(a.handle(req))
+		if err != nil || m.PDU.ErrorStatus != errAuthorizationError {
+			t.Errorf("expected authorizationError, got %+v, %v", m, err)
+		}
+	})
+}
+
+func TestEngineBoots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snmp_engine.json")
+	cfg := &config.SNMPConfig{Enabled: true, Community: "public"}
+
+	first, err := NewAgent(cfg, Sources{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewAgent(cfg, Sources{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.EngineID(), second.EngineID()) || second.boots != first.boots+1 {
+		t.Errorf("engine %x/%d then %x/%d", first.EngineID(), first.boots, second.EngineID(), second.boots)
+	}
+	if first.EngineID()[0]&0x80 == 0 || first.EngineID()[4] != 5 {
+		t.Errorf("engine ID %x is not in RFC 3411 format", first.EngineID())
+	}
+
+	cfg.EngineID = "8000000001020304"
+	third, err := NewAgent(cfg, Sources{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(third.EngineID()) != cfg.EngineID || third.boots != 1 {
+		t.Errorf("configured engine %x/%d", third.EngineID(), third.boots)
+	}
+}
+
+func TestUplinkTraps(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	a := newTestAgent(t, &config.SNMPConfig{
+		Enabled:   true,
+		Community: "public",
+		Traps:     []config.SNMPTrapTarget{{Address: receiver.LocalAddr().String(), Community: "traps"}},
+	})
+	a.UplinkSwitched("multi_wan", "wan1", "wan2")
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := receiver.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no trap received: %v", err)
+	}
+	m, err := decodeMessage(buf[:n])
+	if err != nil {
+		t.Fatalf("invalid trap: %v", err)
+	}
+	if string(m.Community) != "traps" || m.PDU.Type != pduTrap || len(m.PDU.VarBinds) != 5 {
+		t.Fatalf("unexpected trap %+v", m.PDU)
+	}
+	if got := m.PDU.VarBinds[1].Value.OID; got.Compare(a.enterprise.Append(2, 0, trapUplinkFailover)) != 0 {
+		t.Errorf("snmpTrapOID = %s", got)
+	}
+	if got := string(m.PDU.VarBinds[4].Value.Bytes); got != "wan2" {
+		t.Errorf("new uplink = %q", got)
+	}
+}
+
+func TestAgentUDP(t *testing.T) {
+	a := newTestAgent(t, &config.SNMPConfig{Enabled: true, Listen: "127.0.0.1:0", Community: "public"})
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	conn, err := net.Dial("udp", a.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(v2cRequest("public", pduGetRequest, "1.3.6.1.2.1.1.3.0"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if pdu := decodeResponse(t, buf[:n]); pdu.VarBinds[0].Value.Tag != tagTimeTicks {
+		t.Errorf("sysUpTime = %v", pdu.VarBinds[0].Value)
+	}
+}
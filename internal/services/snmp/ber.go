@@ -0,0 +1,319 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// BER tags used by SNMP (RFC 3416).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagIPAddress = 0x40
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagOpaque    = 0x44
+	tagCounter64 = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+)
+
+var errTruncated = errors.New("truncated BER encoding")
+
+// Value is a variable binding value.
+type Value struct {
+	Tag   byte
+	Int   int64  // Integer
+	Uint  uint64 // Counter32, Gauge32, TimeTicks, Counter64
+	Bytes []byte // OctetString, IPAddress, Opaque
+	OID   OID    // OID
+}
+
+// Value constructors.
+func Integer(n int64) Value      { return Value{Tag: tagInteger, Int: n} }
+func OctetString(s string) Value { return Value{Tag: tagOctetString, Bytes: []byte(s)} }
+func Bytes(b []byte) Value       { return Value{Tag: tagOctetString, Bytes: b} }
+func ObjectID(oid OID) Value     { return Value{Tag: tagOID, OID: oid} }
+func Counter32(n uint64) Value   { return Value{Tag: tagCounter32, Uint: uint64(uint32(n))} }
+func Gauge32(n uint64) Value     { return Value{Tag: tagGauge32, Uint: min(n, 1<<32-1)} }
+func TimeTicks(n uint64) Value   { return Value{Tag: tagTimeTicks, Uint: uint64(uint32(n))} }
+func Counter64(n uint64) Value   { return Value{Tag: tagCounter64, Uint: n} }
+
+// IPAddress returns an IPv4 address value; other addresses are 0.0.0.0.
+func IPAddress(ip net.IP) Value {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		ip4 = net.IPv4zero.To4()
+	}
+	return Value{Tag: tagIPAddress, Bytes: []byte(ip4)}
+}
+
+var (
+	null           = Value{Tag: tagNull}
+	noSuchObject   = Value{Tag: tagNoSuchObject}
+	noSuchInstance = Value{Tag: tagNoSuchInstance}
+	endOfMibView   = Value{Tag: tagEndOfMibView}
+)
+
+func (v Value) String() string {
+	switch v.Tag {
+	case tagInteger:
+		return fmt.Sprint(v.Int)
+	case tagOctetString:
+		return fmt.Sprintf("%q", v.Bytes)
+	case tagOID:
+		return v.OID.String()
+	case tagIPAddress:
+		return net.IP(v.Bytes).String()
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return fmt.Sprint(v.Uint)
+	case tagNull:
+		return "NULL"
+	case tagNoSuchObject:
+		return "noSuchObject"
+	case tagNoSuchInstance:
+		return "noSuchInstance"
+	case tagEndOfMibView:
+		return "endOfMibView"
+	}
+	return fmt.Sprintf("tag 0x%02x", v.Tag)
+}
+
+// encode returns the value's BER encoding.
+func (v Value) encode() []byte {
+	switch v.Tag {
+	case tagInteger:
+		return tlv(tagInteger, encodeInt(v.Int))
+	case tagOID:
+		return tlv(tagOID, encodeOID(v.OID))
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return tlv(v.Tag, encodeUint(v.Uint))
+	case tagOctetString, tagIPAddress, tagOpaque:
+		return tlv(v.Tag, v.Bytes)
+	}
+	return tlv(v.Tag, nil)
+}
+
+// tlv encodes a tag, definite length and contents.
+func tlv(tag byte, content []byte) []byte {
+	out := make([]byte, 0, len(content)+6)
+	out = append(out, tag)
+	out = appendLength(out, len(content))
+	return append(out, content...)
+}
+
+func appendLength(out []byte, n int) []byte {
+	if n < 0x80 {
+		return append(out, byte(n))
+	}
+	var buf []byte
+	for ; n > 0; n >>= 8 {
+		buf = append([]byte{byte(n)}, buf...)
+	}
+	out = append(out, 0x80|byte(len(buf)))
+	return append(out, buf...)
+}
+
+func sequence(parts ...[]byte) []byte {
+	return tlv(tagSequence, concat(parts...))
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func encodeInt(n int64) []byte {
+	out := []byte{byte(n)}
+	for {
+		rest := n >> 8
+		// Stop once the remaining bytes are sign extension
+		if (rest == 0 && out[0]&0x80 == 0) || (rest == -1 && out[0]&0x80 != 0) {
+			return out
+		}
+		n = rest
+		out = append([]byte{byte(n)}, out...)
+	}
+}
+
+func encodeUint(n uint64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(n)}, out...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+func encodeOID(oid OID) []byte {
+	if len(oid) < 2 {
+		oid = append(oid, make(OID, 2-len(oid))...)
+	}
+	out := appendBase128(nil, oid[0]*40+oid[1])
+	for _, arc := range oid[2:] {
+		out = appendBase128(out, arc)
+	}
+	return out
+}
+
+func appendBase128(out []byte, n uint32) []byte {
+	var buf []byte
+	buf = append(buf, byte(n&0x7f))
+	for n >>= 7; n > 0; n >>= 7 {
+		buf = append([]byte{byte(n&0x7f) | 0x80}, buf...)
+	}
+	return append(out, buf...)
+}
+
+// decoder reads BER elements. Contents are slices of the input, which the
+// USM relies on to clear the authentication parameters in place.
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) empty() bool { return len(d.buf) == 0 }
+
+// next reads one element.
+func (d *decoder) next() (byte, []byte, error) {
+	if len(d.buf) < 2 {
+		return 0, nil, errTruncated
+	}
+	tag := d.buf[0]
+	n := int(d.buf[1])
+	off := 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(d.buf) < off+size {
+			return 0, nil, errTruncated
+		}
+		n = 0
+		for _, b := range d.buf[off : off+size] {
+			n = n<<8 | int(b)
+		}
+		off += size
+	}
+	if n < 0 || len(d.buf) < off+n {
+		return 0, nil, errTruncated
+	}
+	content := d.buf[off : off+n]
+	d.buf = d.buf[off+n:]
+	return tag, content, nil
+}
+
+// expect reads one element with the given tag.
+func (d *decoder) expect(tag byte) ([]byte, error) {
+	got, content, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	if got != tag {
+		return nil, fmt.Errorf("expected tag 0x%02x, got 0x%02x", tag, got)
+	}
+	return content, nil
+}
+
+func (d *decoder) sequence() (*decoder, error) {
+	content, err := d.expect(tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{buf: content}, nil
+}
+
+func (d *decoder) integer() (int64, error) {
+	content, err := d.expect(tagInteger)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt(content)
+}
+
+func (d *decoder) octets() ([]byte, error) {
+	return d.expect(tagOctetString)
+}
+
+func (d *decoder) value() (Value, error) {
+	tag, content, err := d.next()
+	if err != nil {
+		return Value{}, err
+	}
+	v := Value{Tag: tag}
+	switch tag {
+	case tagInteger:
+		v.Int, err = decodeInt(content)
+	case tagOID:
+		v.OID, err = decodeOID(content)
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		v.Uint, err = decodeUint(content)
+	default:
+		v.Bytes = content
+	}
+	return v, err
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, fmt.Errorf("invalid integer length %d", len(b))
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeUint(b []byte) (uint64, error) {
+	if len(b) == 0 || len(b) > 9 || (len(b) == 9 && b[0] != 0) {
+		return 0, fmt.Errorf("invalid unsigned length %d", len(b))
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	var arcs OID
+	var n uint64
+	for i, c := range b {
+		n = n<<7 | uint64(c&0x7f)
+		if n > 1<<32-1 {
+			return nil, errors.New("OID arc overflow")
+		}
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errTruncated
+			}
+			continue
+		}
+		if len(arcs) == 0 {
+			first := min(n/40, 2)
+			arcs = append(arcs, uint32(first), uint32(n-first*40))
+		} else {
+			arcs = append(arcs, uint32(n))
+		}
+		n = 0
+	}
+	return arcs, nil
+}
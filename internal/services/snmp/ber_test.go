@@ -0,0 +1,72 @@
+package snmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBERRoundTrip(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 2147483647, -2147483648} {
+		enc := tlv(tagInteger, encodeInt(n))
+		got, err := (&decoder{buf: enc}).integer()
+		if err != nil || got != n {
+			t.Errorf("integer %d: got %d, %v (encoding % x)", n, got, err, enc)
+		}
+	}
+
+	values := []Value{
+		Counter32(1<<32 - 1),
+		Counter64(1<<64 - 1),
+		Gauge32(1 << 40), // saturates
+		TimeTicks(12345),
+		OctetString("eth0"),
+		ObjectID(MustParseOID("1.3.6.1.4.1.99999.1.3.1.1.2.1")),
+		IPAddress([]byte{192, 168, 1, 1}),
+	}
+	for _, v := range values {
+		got, err := (&decoder{buf: v.encode()}).value()
+		if err != nil {
+			t.Errorf("%v: %v", v, err)
+			continue
+		}
+		if got.String() != v.String() || got.Tag != v.Tag {
+			t.Errorf("round trip of %v gave %v", v, got)
+		}
+	}
+	if Gauge32(1<<40).Uint != 1<<32-1 {
+		t.Error("Gauge32 does not saturate")
+	}
+
+	// Known encodings
+	if got := encodeOID(MustParseOID("1.3.6.1.2.1.1.1.0")); !bytes.Equal(got, []byte{0x2b, 6, 1, 2, 1, 1, 1, 0}) {
+		t.Errorf("sysDescr OID encoded as % x", got)
+	}
+	if got := encodeOID(OID{1, 3, 6, 1, 4, 1, 99999}); !bytes.Equal(got, []byte{0x2b, 6, 1, 4, 1, 0x86, 0x8d, 0x1f}) {
+		t.Errorf("multi-byte arc encoded as % x", got)
+	}
+	if got := encodeUint(0x80); !bytes.Equal(got, []byte{0, 0x80}) {
+		t.Errorf("unsigned 0x80 encoded as % x", got)
+	}
+	if got := tlv(tagOctetString, make([]byte, 300))[:4]; !bytes.Equal(got, []byte{0x04, 0x82, 0x01, 0x2c}) {
+		t.Errorf("long length encoded as % x", got)
+	}
+
+	if _, err := decodeMessage([]byte{0x30, 0x05, 0x02, 0x01}); err == nil {
+		t.Error("truncated message accepted")
+	}
+}
+
+func TestOIDOrdering(t *testing.T) {
+	a := MustParseOID("1.3.6.1.2.1.2")
+	b := MustParseOID("1.3.6.1.2.1.2.1.0")
+	c := MustParseOID("1.3.6.1.2.1.10")
+	if a.Compare(b) >= 0 || b.Compare(c) >= 0 || c.Compare(a) <= 0 {
+		t.Error("OIDs not ordered lexicographically")
+	}
+	if !b.HasPrefix(a) || c.HasPrefix(a) {
+		t.Error("HasPrefix mismatch")
+	}
+	if _, err := ParseOID("1.3.x"); err == nil {
+		t.Error("invalid OID parsed")
+	}
+}
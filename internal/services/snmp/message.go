@@ -0,0 +1,312 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Protocol versions.
+const (
+	version2c = 1
+	version3  = 3
+)
+
+// PDU types.
+const (
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduResponse       = 0xa2
+	pduSetRequest     = 0xa3
+	pduGetBulkRequest = 0xa5
+	pduInformRequest  = 0xa6
+	pduTrap           = 0xa7
+	pduReport         = 0xa8
+)
+
+// Error statuses.
+const (
+	errNoError            = 0
+	errTooBig             = 1
+	errAuthorizationError = 16
+	errNotWritable        = 17
+)
+
+// VarBind is a variable binding.
+type VarBind struct {
+	OID   OID
+	Value Value
+}
+
+// PDU is a protocol data unit. For GetBulk requests ErrorStatus and
+// ErrorIndex hold non-repeaters and max-repetitions.
+type PDU struct {
+	Type        byte
+	RequestID   int32
+	ErrorStatus int
+	ErrorIndex  int
+	VarBinds    []VarBind
+}
+
+func (p *PDU) encode() []byte {
+	var vbs []byte
+	for _, vb := range p.VarBinds {
+		vbs = append(vbs, sequence(tlv(tagOID, encodeOID(vb.OID)), vb.Value.encode())...)
+	}
+	return tlv(p.Type, concat(
+		tlv(tagInteger, encodeInt(int64(p.RequestID))),
+		tlv(tagInteger, encodeInt(int64(p.ErrorStatus))),
+		tlv(tagInteger, encodeInt(int64(p.ErrorIndex))),
+		tlv(tagSequence, vbs),
+	))
+}
+
+func decodePDU(d *decoder) (*PDU, error) {
+	tag, content, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	if tag < pduGetRequest || tag > pduReport {
+		return nil, fmt.Errorf("unknown PDU type 0x%02x", tag)
+	}
+	p := &PDU{Type: tag}
+	body := &decoder{buf: content}
+
+	reqID, err := body.integer()
+	if err != nil {
+		return nil, err
+	}
+	p.RequestID = int32(reqID)
+	status, err := body.integer()
+	if err != nil {
+		return nil, err
+	}
+	index, err := body.integer()
+	if err != nil {
+		return nil, err
+	}
+	p.ErrorStatus, p.ErrorIndex = int(status), int(index)
+
+	vbs, err := body.sequence()
+	if err != nil {
+		return nil, err
+	}
+	for !vbs.empty() {
+		vb, err := vbs.sequence()
+		if err != nil {
+			return nil, err
+		}
+		oidBytes, err := vb.expect(tagOID)
+		if err != nil {
+			return nil, err
+		}
+		oid, err := decodeOID(oidBytes)
+		if err != nil {
+			return nil, err
+		}
+		value, err := vb.value()
+		if err != nil {
+			return nil, err
+		}
+		p.VarBinds = append(p.VarBinds, VarBind{OID: oid, Value: value})
+	}
+	return p, nil
+}
+
+// Message flags (RFC 3412).
+const (
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+)
+
+const securityModelUSM = 3
+
+// message is a decoded SNMP message. For SNMPv3 the PDU is only available
+// after the USM has processed the message.
+type message struct {
+	Version   int
+	Community []byte
+	PDU       *PDU
+
+	// SNMPv3
+	MsgID       int32
+	MaxSize     int
+	Flags       byte
+	Security    usmParams
+	ContextID   []byte
+	ContextName []byte
+	scopedPDU   []byte // plaintext or encrypted msgData
+	raw         []byte
+}
+
+// usmParams are the USM security parameters (RFC 3414 section 2.4).
+type usmParams struct {
+	EngineID    []byte
+	EngineBoots int32
+	EngineTime  int32
+	UserName    []byte
+	AuthParams  []byte // a slice of message.raw
+	PrivParams  []byte
+}
+
+func (u *usmParams) encode() []byte {
+	return sequence(
+		tlv(tagOctetString, u.EngineID),
+		tlv(tagInteger, encodeInt(int64(u.EngineBoots))),
+		tlv(tagInteger, encodeInt(int64(u.EngineTime))),
+		tlv(tagOctetString, u.UserName),
+		tlv(tagOctetString, u.AuthParams),
+		tlv(tagOctetString, u.PrivParams),
+	)
+}
+
+var errUnsupportedVersion = errors.New("unsupported SNMP version")
+
+// decodeMessage parses a message. SNMPv3 messages keep a reference to buf.
+func decodeMessage(buf []byte) (*message, error) {
+	outer := &decoder{buf: buf}
+	d, err := outer.sequence()
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.integer()
+	if err != nil {
+		return nil, err
+	}
+	m := &message{Version: int(version), raw: buf}
+
+	switch m.Version {
+	case version2c:
+		if m.Community, err = d.octets(); err != nil {
+			return nil, err
+		}
+		if m.PDU, err = decodePDU(d); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case version3:
+	default:
+		return nil, errUnsupportedVersion
+	}
+
+	header, err := d.sequence()
+	if err != nil {
+		return nil, err
+	}
+	msgID, err := header.integer()
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := header.integer()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := header.octets()
+	if err != nil || len(flags) != 1 {
+		return nil, errors.New("invalid msgFlags")
+	}
+	model, err := header.integer()
+	if err != nil {
+		return nil, err
+	}
+	if model != securityModelUSM {
+		return nil, fmt.Errorf("unsupported security model %d", model)
+	}
+	m.MsgID, m.MaxSize, m.Flags = int32(msgID), int(maxSize), flags[0]
+
+	secParams, err := d.octets()
+	if err != nil {
+		return nil, err
+	}
+	sec, err := (&decoder{buf: secParams}).sequence()
+	if err != nil {
+		return nil, err
+	}
+	if m.Security.EngineID, err = sec.octets(); err != nil {
+		return nil, err
+	}
+	boots, err := sec.integer()
+	if err != nil {
+		return nil, err
+	}
+	engineTime, err := sec.integer()
+	if err != nil {
+		return nil, err
+	}
+	m.Security.EngineBoots, m.Security.EngineTime = int32(boots), int32(engineTime)
+	if m.Security.UserName, err = sec.octets(); err != nil {
+		return nil, err
+	}
+	if m.Security.AuthParams, err = sec.octets(); err != nil {
+		return nil, err
+	}
+	if m.Security.PrivParams, err = sec.octets(); err != nil {
+		return nil, err
+	}
+
+	// msgData is a ScopedPDU, or an OCTET STRING when encrypted
+	tag, content, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case m.Flags&flagPriv != 0 && tag == tagOctetString:
+		m.scopedPDU = content
+	case m.Flags&flagPriv == 0 && tag == tagSequence:
+		m.scopedPDU = content
+		if err := m.decodeScopedPDU(content); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("msgData does not match msgFlags")
+	}
+	return m, nil
+}
+
+// decodeScopedPDU parses the contents of a ScopedPDU.
+func (m *message) decodeScopedPDU(content []byte) error {
+	d := &decoder{buf: content}
+	var err error
+	if m.ContextID, err = d.octets(); err != nil {
+		return err
+	}
+	if m.ContextName, err = d.octets(); err != nil {
+		return err
+	}
+	m.PDU, err = decodePDU(d)
+	return err
+}
+
+// encodeV2c encodes a community based message.
+func encodeV2c(community []byte, pdu *PDU) []byte {
+	return sequence(
+		tlv(tagInteger, encodeInt(version2c)),
+		tlv(tagOctetString, community),
+		pdu.encode(),
+	)
+}
+
+// encodeV3 encodes an SNMPv3 message with msgData already scoped and, if
+// needed, encrypted. Authentication is applied afterwards by the USM.
+func encodeV3(msgID int32, maxSize int, flags byte, sec *usmParams, msgData []byte) []byte {
+	return sequence(
+		tlv(tagInteger, encodeInt(version3)),
+		sequence(
+			tlv(tagInteger, encodeInt(int64(msgID))),
+			tlv(tagInteger, encodeInt(int64(maxSize))),
+			tlv(tagOctetString, []byte{flags}),
+			tlv(tagInteger, encodeInt(securityModelUSM)),
+		),
+		tlv(tagOctetString, sec.encode()),
+		msgData,
+	)
+}
+
+// scopedPDU encodes a plaintext ScopedPDU.
+func scopedPDU(contextID, contextName []byte, pdu *PDU) []byte {
+	return sequence(
+		tlv(tagOctetString, contextID),
+		tlv(tagOctetString, contextName),
+		pdu.encode(),
+	)
+}
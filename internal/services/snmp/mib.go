@@ -0,0 +1,120 @@
+package snmp
+
+import (
+	"slices"
+	"sort"
+)
+
+// table is a snapshot of the agent's objects, sorted by OID.
+type table struct {
+	entries []VarBind
+}
+
+// add appends an object. Tables are sorted by build.
+func (t *table) add(oid OID, v Value) {
+	t.entries = append(t.entries, VarBind{OID: oid, Value: v})
+}
+
+// column adds one column of a conceptual table.
+func (t *table) column(entry OID, col uint32, index OID, v Value) {
+	t.add(entry.Append(col).Append(index...), v)
+}
+
+func (t *table) build() *table {
+	slices.SortFunc(t.entries, func(a, b VarBind) int { return a.OID.Compare(b.OID) })
+	return t
+}
+
+// get answers a Get for one OID.
+func (t *table) get(oid OID) Value {
+	i := sort.Search(len(t.entries), func(i int) bool { return t.entries[i].OID.Compare(oid) >= 0 })
+	if i < len(t.entries) && t.entries[i].OID.Compare(oid) == 0 {
+		return t.entries[i].Value
+	}
+	// An object exists when an instance below its parent does
+	if len(oid) > 1 && i < len(t.entries) && t.entries[i].OID.HasPrefix(oid[:len(oid)-1]) {
+		return noSuchInstance
+	}
+	if len(oid) > 1 && i > 0 && t.entries[i-1].OID.HasPrefix(oid[:len(oid)-1]) {
+		return noSuchInstance
+	}
+	return noSuchObject
+}
+
+// next answers a GetNext for one OID.
+func (t *table) next(oid OID) VarBind {
+	i := sort.Search(len(t.entries), func(i int) bool { return t.entries[i].OID.Compare(oid) > 0 })
+	if i == len(t.entries) {
+		return VarBind{OID: oid, Value: endOfMibView}
+	}
+	return t.entries[i]
+}
+
+// process answers a request PDU. It returns nil for PDUs that get no
+// response. The response is kept within maxSize bytes where possible.
+func (t *table) process(req *PDU, maxSize int) *PDU {
+	resp := &PDU{Type: pduResponse, RequestID: req.RequestID}
+
+	switch req.Type {
+	case pduGetRequest:
+		for _, vb := range req.VarBinds {
+			resp.VarBinds = append(resp.VarBinds, VarBind{OID: vb.OID, Value: t.get(vb.OID)})
+		}
+	case pduGetNextRequest:
+		for _, vb := range req.VarBinds {
+			resp.VarBinds = append(resp.VarBinds, t.next(vb.OID))
+		}
+	case pduGetBulkRequest:
+		resp.VarBinds = t.bulk(req)
+		// Bulk responses are cut short rather than failing
+		for len(resp.VarBinds) > 1 && len(resp.encode()) > maxSize {
+			resp.VarBinds = resp.VarBinds[:len(resp.VarBinds)-1]
+		}
+	case pduSetRequest:
+		// The agent is read-only
+		resp.VarBinds = req.VarBinds
+		resp.ErrorStatus = errNotWritable
+		resp.ErrorIndex = 1
+		if len(req.VarBinds) == 0 {
+			resp.ErrorIndex = 0
+		}
+	default:
+		return nil
+	}
+
+	if len(resp.encode()) > maxSize {
+		return &PDU{Type: pduResponse, RequestID: req.RequestID, ErrorStatus: errTooBig}
+	}
+	return resp
+}
+
+// bulk answers a GetBulk (RFC 3416 section 4.2.3).
+func (t *table) bulk(req *PDU) []VarBind {
+	nonRepeaters := min(max(req.ErrorStatus, 0), len(req.VarBinds))
+	maxRepetitions := min(max(req.ErrorIndex, 0), 1000)
+
+	var out []VarBind
+	for _, vb := range req.VarBinds[:nonRepeaters] {
+		out = append(out, t.next(vb.OID))
+	}
+
+	cursors := make([]OID, 0, len(req.VarBinds)-nonRepeaters)
+	for _, vb := range req.VarBinds[nonRepeaters:] {
+		cursors = append(cursors, vb.OID)
+	}
+	for r := 0; r < maxRepetitions && len(cursors) > 0; r++ {
+		done := true
+		for i, oid := range cursors {
+			vb := t.next(oid)
+			out = append(out, vb)
+			if vb.Value.Tag != tagEndOfMibView {
+				cursors[i] = vb.OID
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,275 @@
+package snmp
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"grimm.is/glacic/internal/brand"
+	"grimm.is/glacic/internal/metrics"
+	"grimm.is/glacic/internal/network"
+	"grimm.is/glacic/internal/stats"
+)
+
+// Standard MIB roots.
+var (
+	oidSystem       = MustParseOID("1.3.6.1.2.1.1")
+	oidSysUpTime    = MustParseOID("1.3.6.1.2.1.1.3.0")
+	oidIfNumber     = MustParseOID("1.3.6.1.2.1.2.1.0")
+	oidIfEntry      = MustParseOID("1.3.6.1.2.1.2.2.1")
+	oidIfXEntry     = MustParseOID("1.3.6.1.2.1.31.1.1.1")
+	oidIPForwarding = MustParseOID("1.3.6.1.2.1.4.1.0")
+	oidIPDefaultTTL = MustParseOID("1.3.6.1.2.1.4.2.0")
+	oidIPAddrEntry  = MustParseOID("1.3.6.1.2.1.4.20.1")
+	oidHRSystem     = MustParseOID("1.3.6.1.2.1.25.1")
+	oidHRMemorySize = MustParseOID("1.3.6.1.2.1.25.2.2.0")
+	oidHRStorage    = MustParseOID("1.3.6.1.2.1.25.2.3.1")
+	oidHRStorageRAM = MustParseOID("1.3.6.1.2.1.25.2.1.2")
+	oidSnmpTrapOID  = MustParseOID("1.3.6.1.6.3.1.1.4.1.0")
+
+	// usmStats counters, reported to managers (RFC 3414 section 5)
+	oidUsmStats = MustParseOID("1.3.6.1.6.3.15.1.1")
+)
+
+// Sources provide the agent's data. Any of them may be nil.
+type Sources struct {
+	Metrics *metrics.Collector     // interface counters, system and DHCP stats
+	Stats   *stats.Collector       // per-rule byte counters
+	Uplinks *network.UplinkManager // uplink status
+}
+
+// snapshot builds the object table.
+func (a *Agent) snapshot() *table {
+	t := &table{}
+	a.addSystem(t)
+	a.addInterfaces(t)
+	addIP(t)
+	a.addHostResources(t)
+	a.addEnterprise(t)
+	return t.build()
+}
+
+func (a *Agent) addSystem(t *table) {
+	hostname, _ := os.Hostname()
+	descr := strings.TrimSpace(strings.Join([]string{brand.Name, brand.Version, runtime.GOOS, runtime.GOARCH}, " "))
+
+	t.add(oidSystem.Append(1, 0), OctetString(descr))               // sysDescr
+	t.add(oidSystem.Append(2, 0), ObjectID(a.enterprise.Append(3))) // sysObjectID
+	t.add(oidSysUpTime, TimeTicks(a.uptimeTicks()))                 // sysUpTime
+	t.add(oidSystem.Append(4, 0), OctetString(a.cfg.Contact))       // sysContact
+	t.add(oidSystem.Append(5, 0), OctetString(hostname))            // sysName
+	t.add(oidSystem.Append(6, 0), OctetString(a.cfg.Location))      // sysLocation
+	t.add(oidSystem.Append(7, 0), Integer(78))                      // sysServices: layers 2, 3, 4 and 7
+}
+
+// ifType returns the IANAifType of an interface.
+func ifType(iface net.Interface) int64 {
+	switch {
+	case iface.Flags&net.FlagLoopback != 0:
+		return 24 // softwareLoopback
+	case strings.HasPrefix(iface.Name, "ppp"):
+		return 23 // ppp
+	case strings.HasPrefix(iface.Name, "br"):
+		return 209 // bridge
+	case strings.Contains(iface.Name, "."):
+		return 135 // l2vlan
+	case strings.HasPrefix(iface.Name, "wg"), strings.HasPrefix(iface.Name, "tun"),
+		strings.HasPrefix(iface.Name, "gre"), strings.HasPrefix(iface.Name, "vxlan"):
+		return 131 // tunnel
+	}
+	return 6 // ethernetCsmacd
+}
+
+func (a *Agent) addInterfaces(t *table) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	var counters map[string]*metrics.InterfaceStats
+	if a.src.Metrics != nil {
+		counters = a.src.Metrics.GetInterfaceStats()
+	}
+
+	t.add(oidIfNumber, Integer(int64(len(ifaces))))
+	for _, iface := range ifaces {
+		idx := OID{uint32(iface.Index)}
+		st := counters[iface.Name]
+		if st == nil {
+			st = &metrics.InterfaceStats{LinkUp: iface.Flags&net.FlagRunning != 0}
+		}
+
+		admin, oper := int64(2), int64(2) // down
+		if iface.Flags&net.FlagUp != 0 {
+			admin = 1
+		}
+		if st.LinkUp || iface.Flags&net.FlagLoopback != 0 {
+			oper = 1
+		}
+
+		t.column(oidIfEntry, 1, idx, Integer(int64(iface.Index)))
+		t.column(oidIfEntry, 2, idx, OctetString(iface.Name))
+		t.column(oidIfEntry, 3, idx, Integer(ifType(iface)))
+		t.column(oidIfEntry, 4, idx, Integer(int64(iface.MTU)))
+		t.column(oidIfEntry, 5, idx, Gauge32(st.Speed*1000000))
+		t.column(oidIfEntry, 6, idx, Bytes(iface.HardwareAddr))
+		t.column(oidIfEntry, 7, idx, Integer(admin))
+		t.column(oidIfEntry, 8, idx, Integer(oper))
+		t.column(oidIfEntry, 9, idx, TimeTicks(0))
+		t.column(oidIfEntry, 10, idx, Counter32(st.RxBytes))
+		t.column(oidIfEntry, 11, idx, Counter32(st.RxPackets))
+		t.column(oidIfEntry, 13, idx, Counter32(st.RxDropped))
+		t.column(oidIfEntry, 14, idx, Counter32(st.RxErrors))
+		t.column(oidIfEntry, 16, idx, Counter32(st.TxBytes))
+		t.column(oidIfEntry, 17, idx, Counter32(st.TxPackets))
+		t.column(oidIfEntry, 19, idx, Counter32(st.TxDropped))
+		t.column(oidIfEntry, 20, idx, Counter32(st.TxErrors))
+
+		t.column(oidIfXEntry, 1, idx, OctetString(iface.Name))
+		t.column(oidIfXEntry, 6, idx, Counter64(st.RxBytes))
+		t.column(oidIfXEntry, 7, idx, Counter64(st.RxPackets))
+		t.column(oidIfXEntry, 10, idx, Counter64(st.TxBytes))
+		t.column(oidIfXEntry, 11, idx, Counter64(st.TxPackets))
+		t.column(oidIfXEntry, 15, idx, Gauge32(st.Speed))
+		t.column(oidIfXEntry, 18, idx, OctetString(st.Zone))
+	}
+}
+
+func addIP(t *table) {
+	forwarding := int64(2) // notForwarding
+	if data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward"); err == nil && strings.TrimSpace(string(data)) == "1" {
+		forwarding = 1
+	}
+	t.add(oidIPForwarding, Integer(forwarding))
+	t.add(oidIPDefaultTTL, Integer(64))
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			ip := ipnet.IP.To4()
+			idx := OID{uint32(ip[0]), uint32(ip[1]), uint32(ip[2]), uint32(ip[3])}
+			t.column(oidIPAddrEntry, 1, idx, IPAddress(ip))
+			t.column(oidIPAddrEntry, 2, idx, Integer(int64(iface.Index)))
+			t.column(oidIPAddrEntry, 3, idx, IPAddress(net.IP(ipnet.Mask)))
+			t.column(oidIPAddrEntry, 4, idx, Integer(1))
+		}
+	}
+}
+
+func (a *Agent) addHostResources(t *table) {
+	if a.src.Metrics == nil {
+		return
+	}
+	sys := a.src.Metrics.GetSystemStats()
+
+	t.add(oidHRSystem.Append(1, 0), TimeTicks(uint64(sys.Uptime)*100)) // hrSystemUptime
+	if entries, err := os.ReadDir("/proc"); err == nil {
+		procs := 0
+		for _, e := range entries {
+			if e.IsDir() && strings.Trim(e.Name(), "0123456789") == "" {
+				procs++
+			}
+		}
+		t.add(oidHRSystem.Append(6, 0), Gauge32(uint64(procs))) // hrSystemProcesses
+	}
+
+	if sys.MemTotal == 0 {
+		return
+	}
+	t.add(oidHRMemorySize, Integer(int64(sys.MemTotal/1024)))
+	idx := OID{1}
+	t.column(oidHRStorage, 1, idx, Integer(1))
+	t.column(oidHRStorage, 2, idx, ObjectID(oidHRStorageRAM))
+	t.column(oidHRStorage, 3, idx, OctetString("Physical memory"))
+	t.column(oidHRStorage, 4, idx, Integer(1024))
+	t.column(oidHRStorage, 5, idx, Integer(int64(sys.MemTotal/1024)))
+	t.column(oidHRStorage, 6, idx, Integer(int64((sys.MemTotal-sys.MemAvailable)/1024)))
+}
+
+// Enterprise MIB layout, below the configured enterprise OID:
+//
+//	.1.1.1.1.<col>.<n>  ruleTable: 1 index, 2 id, 3 bytes, 4 bytes/s
+//	.1.2.1.0            DHCP active leases
+//	.1.2.2.0            DHCP total leases
+//	.1.3.1.1.<col>.<n>  uplinkTable: 1 index, 2 group, 3 name, 4 interface,
+//	                    5 status (1 up, 2 down), 6 active (TruthValue),
+//	                    7 latency (ms), 8 packet loss (%)
+//	.2.0.<n>            notifications (see trap.go)
+//	.2.1.<n>.0          notification objects
+//	.3                  sysObjectID
+func (a *Agent) addEnterprise(t *table) {
+	objects := a.enterprise.Append(1)
+
+	if a.src.Stats != nil {
+		all := a.src.Stats.GetAllStats()
+		ids := make([]string, 0, len(all))
+		for id := range all {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		entry := objects.Append(1, 1, 1)
+		for i, id := range ids {
+			idx := OID{uint32(i + 1)}
+			var rate float64
+			if samples := all[id]; len(samples) > 0 {
+				rate = samples[len(samples)-1]
+			}
+			t.column(entry, 1, idx, Integer(int64(i+1)))
+			t.column(entry, 2, idx, OctetString(id))
+			t.column(entry, 3, idx, Counter64(a.src.Stats.GetTotalBytes(id)))
+			t.column(entry, 4, idx, Gauge32(uint64(rate)))
+		}
+	}
+
+	if a.src.Metrics != nil {
+		dhcp := a.src.Metrics.GetServiceStats().DHCP
+		t.add(objects.Append(2, 1, 0), Gauge32(uint64(dhcp.ActiveLeases)))
+		t.add(objects.Append(2, 2, 0), Gauge32(uint64(dhcp.TotalLeases)))
+	}
+
+	if a.src.Uplinks != nil {
+		groups := a.src.Uplinks.GetAllGroups()
+		sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+		entry := objects.Append(3, 1, 1)
+		n := uint32(0)
+		for _, g := range groups {
+			active := make(map[*network.Uplink]bool)
+			for _, u := range g.GetActiveUplinks() {
+				active[u] = true
+			}
+			for _, u := range g.GetUplinks() {
+				n++
+				idx := OID{n}
+				t.column(entry, 1, idx, Integer(int64(n)))
+				t.column(entry, 2, idx, OctetString(g.Name))
+				t.column(entry, 3, idx, OctetString(u.Name))
+				t.column(entry, 4, idx, OctetString(u.Interface))
+				t.column(entry, 5, idx, Integer(truthValue(u.Healthy)))
+				t.column(entry, 6, idx, Integer(truthValue(active[u])))
+				t.column(entry, 7, idx, Gauge32(uint64(u.Latency/time.Millisecond)))
+				t.column(entry, 8, idx, Gauge32(uint64(u.PacketLoss)))
+			}
+		}
+	}
+}
+
+// truthValue encodes a boolean as SNMPv2-TC TruthValue (and up/down).
+func truthValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,119 @@
+package snmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// Notifications, below the enterprise OID:
+//
+//	.2.0.1  uplinkFailover: group, from, to
+//	.2.0.2  uplinkDown: uplink, interface
+//	.2.0.3  uplinkUp: uplink, interface
+const (
+	trapUplinkFailover = 1
+	trapUplinkDown     = 2
+	trapUplinkUp       = 3
+
+	trapObjGroup     = 1
+	trapObjFrom      = 2
+	trapObjTo        = 3
+	trapObjUplink    = 4
+	trapObjInterface = 5
+)
+
+// UplinkSwitched sends an uplinkFailover trap when a group fails over from
+// one uplink to another.
+func (a *Agent) UplinkSwitched(group, from, to string) {
+	a.notify(trapUplinkFailover,
+		a.trapObject(trapObjGroup, group),
+		a.trapObject(trapObjFrom, from),
+		a.trapObject(trapObjTo, to),
+	)
+}
+
+// UplinkHealthChanged sends an uplinkUp or uplinkDown trap.
+func (a *Agent) UplinkHealthChanged(uplink, iface string, healthy bool) {
+	trap := trapUplinkDown
+	if healthy {
+		trap = trapUplinkUp
+	}
+	a.notify(uint32(trap),
+		a.trapObject(trapObjUplink, uplink),
+		a.trapObject(trapObjInterface, iface),
+	)
+}
+
+func (a *Agent) trapObject(obj uint32, value string) VarBind {
+	return VarBind{OID: a.enterprise.Append(2, 1, obj, 0), Value: OctetString(value)}
+}
+
+// notify sends an SNMPv2-Trap to every target without blocking the caller.
+func (a *Agent) notify(trap uint32, objects ...VarBind) {
+	if len(a.cfg.Traps) == 0 {
+		return
+	}
+	pdu := &PDU{
+		Type:      pduTrap,
+		RequestID: randomID(),
+		VarBinds: append([]VarBind{
+			{OID: oidSysUpTime, Value: TimeTicks(a.uptimeTicks())},
+			{OID: oidSnmpTrapOID, Value: ObjectID(a.enterprise.Append(2, 0, trap))},
+		}, objects...),
+	}
+	for _, target := range a.cfg.Traps {
+		msg, err := a.trapMessage(target, pdu)
+		if err != nil {
+			log.Printf("[SNMP] Failed to encode trap for %s: %v", target.Address, err)
+			continue
+		}
+		go func(addr string) {
+			if err := sendTrap(addr, msg); err != nil {
+				log.Printf("[SNMP] Failed to send trap to %s: %v", addr, err)
+			}
+		}(trapAddr(target.Address))
+	}
+}
+
+// trapMessage encodes a trap for a target. SNMPv3 traps are sent with the
+// agent as the authoritative engine, so no discovery is needed.
+func (a *Agent) trapMessage(target config.SNMPTrapTarget, pdu *PDU) ([]byte, error) {
+	if target.User == "" {
+		return encodeV2c([]byte(target.Community), pdu), nil
+	}
+	u, ok := a.users[target.User]
+	if !ok {
+		u = &user{name: target.User}
+	}
+	return u.wrap(randomID(), u.flags(), a.usmParams(), a.engineID, nil, pdu)
+}
+
+// trapAddr adds the default trap port to an address without one.
+func trapAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(config.DefaultSNMPTrapPort))
+}
+
+func sendTrap(addr string, msg []byte) error {
+	conn, err := net.DialTimeout("udp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	return err
+}
+
+func randomID() int32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return int32(binary.BigEndian.Uint32(b[:]) & 0x7fffffff)
+}
@@ -0,0 +1,233 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync/atomic"
+
+	"grimm.is/glacic/internal/config"
+)
+
+// timeWindow is the USM time window in seconds (RFC 3414 section 3.2).
+const timeWindow = 150
+
+// authProtocol is a USM authentication protocol.
+type authProtocol struct {
+	hash   func() hash.Hash
+	macLen int
+}
+
+var authProtocols = map[string]authProtocol{
+	"md5":    {md5.New, 12},    // usmHMACMD5AuthProtocol, RFC 3414
+	"sha":    {sha1.New, 12},   // usmHMACSHAAuthProtocol, RFC 3414
+	"sha256": {sha256.New, 24}, // usmHMAC192SHA256AuthProtocol, RFC 7860
+}
+
+// user is a USM user with keys localized to the agent's engine ID.
+type user struct {
+	name    string
+	auth    *authProtocol
+	authKey []byte
+	priv    string // "des" or "aes"
+	privKey []byte
+}
+
+func newUser(cfg config.SNMPUser, engineID []byte) *user {
+	u := &user{name: cfg.Name}
+	if cfg.AuthPassword == "" {
+		return u
+	}
+	name := cfg.AuthProtocol
+	if name == "" {
+		name = "sha"
+	}
+	auth := authProtocols[name]
+	u.auth = &auth
+	u.authKey = localizeKey(auth.hash, cfg.AuthPassword, engineID)
+
+	if cfg.PrivPassword != "" {
+		u.priv = cfg.PrivProtocol
+		if u.priv == "" {
+			u.priv = "aes"
+		}
+		u.privKey = localizeKey(auth.hash, cfg.PrivPassword, engineID)
+	}
+	return u
+}
+
+// flags returns the msgFlags of the user's security level.
+func (u *user) flags() byte {
+	var flags byte
+	if u.auth != nil {
+		flags |= flagAuth
+	}
+	if u.privKey != nil {
+		flags |= flagPriv
+	}
+	return flags
+}
+
+// localizeKey implements the password to key algorithm of RFC 3414
+// appendix A.2: the password repeated to 1MB is hashed, and the result is
+// bound to the engine ID.
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	pw := []byte(password)
+	for i, n := 0, 0; n < 1048576; n += len(buf) {
+		for j := range buf {
+			buf[j] = pw[i%len(pw)]
+			i++
+		}
+		h.Write(buf)
+	}
+	ku := h.Sum(nil)
+
+	h = newHash()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func (u *user) mac(msg []byte) []byte {
+	m := hmac.New(u.auth.hash, u.authKey)
+	m.Write(msg)
+	return m.Sum(nil)[:u.auth.macLen]
+}
+
+// verify checks the digest of a received message. The authentication
+// parameters are zeroed in place, as the digest covers the message with
+// them cleared.
+func (u *user) verify(m *message) bool {
+	got := append([]byte(nil), m.Security.AuthParams...)
+	if len(got) != u.auth.macLen {
+		return false
+	}
+	clear(m.Security.AuthParams)
+	return hmac.Equal(got, u.mac(m.raw))
+}
+
+// sign fills in the digest of an encoded message whose authentication
+// parameters are zero.
+func (u *user) sign(msg []byte) error {
+	m, err := decodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	copy(m.Security.AuthParams, u.mac(msg))
+	return nil
+}
+
+var saltCounter atomic.Uint64
+
+// encrypt encrypts a ScopedPDU and returns it with the salt to send as
+// msgPrivacyParameters.
+func (u *user) encrypt(plain []byte, boots, engineTime int32) ([]byte, []byte, error) {
+	salt := make([]byte, 8)
+	switch u.priv {
+	case "des":
+		// RFC 3414 section 8.1.1.1: engine boots and a local counter
+		binary.BigEndian.PutUint32(salt, uint32(boots))
+		binary.BigEndian.PutUint32(salt[4:], uint32(saltCounter.Add(1)))
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		padded := make([]byte, (len(plain)+7)/8*8)
+		copy(padded, plain)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+		return padded, salt, nil
+	case "aes":
+		// RFC 3826 section 3.1.2.1: a 64-bit counter
+		binary.BigEndian.PutUint64(salt, saltCounter.Add(1))
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, plain)
+		return out, salt, nil
+	}
+	return nil, nil, fmt.Errorf("unknown privacy protocol %q", u.priv)
+}
+
+// decrypt decrypts a ScopedPDU using the sender's salt and, for AES, the
+// engine boots and time it sent.
+func (u *user) decrypt(data, salt []byte, boots, engineTime int32) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, errors.New("invalid privacy parameters")
+	}
+	switch u.priv {
+	case "des":
+		if len(data)%8 != 0 {
+			return nil, errors.New("ciphertext is not a multiple of the block size")
+		}
+		block, err := des.NewCipher(u.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = u.privKey[8+i] ^ salt[i]
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		return out, nil
+	case "aes":
+		block, err := aes.NewCipher(u.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, data)
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown privacy protocol %q", u.priv)
+}
+
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// wrap encodes an SNMPv3 message for u at the given security level,
+// encrypting and signing it as needed.
+func (u *user) wrap(msgID int32, flags byte, sec usmParams, contextID, contextName []byte, pdu *PDU) ([]byte, error) {
+	sec.UserName = []byte(u.name)
+	msgData := scopedPDU(contextID, contextName, pdu)
+	if flags&flagPriv != 0 {
+		encrypted, salt, err := u.encrypt(msgData, sec.EngineBoots, sec.EngineTime)
+		if err != nil {
+			return nil, err
+		}
+		msgData = tlv(tagOctetString, encrypted)
+		sec.PrivParams = salt
+	}
+	if flags&flagAuth != 0 {
+		sec.AuthParams = make([]byte, u.auth.macLen)
+	}
+	msg := encodeV3(msgID, maxMessageSize, flags, &sec, msgData)
+	if flags&flagAuth != 0 {
+		if err := u.sign(msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
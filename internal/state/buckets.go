@@ -65,7 +65,7 @@ func (b *DHCPBucket) Set(lease *DHCPLease) error {
 		// Lease already expired, don't store
 		return nil
 	}
-	return b.store.SetJSONWithTTL(b.bucket, normalizeMAC(lease.MAC), lease, ttl)
+	return b.store.SetJSONWithTTL(b.bucket, leaseKey(lease), lease, ttl)
 }
 
 // Delete removes a lease.
@@ -73,6 +73,20 @@ func (b *DHCPBucket) Delete(mac string) error {
 	return b.store.Delete(b.bucket, normalizeMAC(mac))
 }
 
+// GetV6 retrieves a DHCPv6 lease by client DUID.
+func (b *DHCPBucket) GetV6(duid string) (*DHCPLease, error) {
+	var lease DHCPLease
+	if err := b.store.GetJSON(b.bucket, dhcpv6KeyPrefix+duid, &lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// DeleteV6 removes a DHCPv6 lease by client DUID.
+func (b *DHCPBucket) DeleteV6(duid string) error {
+	return b.store.Delete(b.bucket, dhcpv6KeyPrefix+duid)
+}
+
 // List returns all active leases.
 func (b *DHCPBucket) List() ([]*DHCPLease, error) {
 	data, err := b.store.List(b.bucket)
@@ -105,6 +119,19 @@ func (b *DHCPBucket) GetByIP(ip string) (*DHCPLease, error) {
 	return nil, ErrNotFound
 }
 
+// dhcpv6KeyPrefix marks DHCPv6 leases, which are keyed by DUID.
+const dhcpv6KeyPrefix = "duid:"
+
+// leaseKey returns the storage key for a lease. DHCPv4 leases are keyed by
+// MAC; DHCPv6 leases by client DUID (ClientID), since a DUID need not
+// contain a MAC and one host may hold both kinds of lease.
+func leaseKey(lease *DHCPLease) string {
+	if ip := net.ParseIP(lease.IP); ip != nil && ip.To4() == nil && lease.ClientID != "" {
+		return dhcpv6KeyPrefix + lease.ClientID
+	}
+	return normalizeMAC(lease.MAC)
+}
+
 // normalizeMAC normalizes a MAC address to lowercase with colons.
 func normalizeMAC(mac string) string {
 	hw, err := net.ParseMAC(mac)
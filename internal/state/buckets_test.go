@@ -97,6 +97,56 @@ func TestDHCPBucket_NormalizeMAC(t *testing.T) {
 	}
 }
 
+// TestDHCPBucket_V6 tests that DHCPv6 leases are keyed by DUID
+func TestDHCPBucket_V6(t *testing.T) {
+	store, _ := NewSQLiteStore(DefaultOptions(":memory:"))
+	defer store.Close()
+
+	bucket, _ := NewDHCPBucket(store)
+
+	v4 := &DHCPLease{
+		MAC:        "aa:bb:cc:dd:ee:ff",
+		IP:         "192.168.1.100",
+		LeaseStart: time.Now(),
+		LeaseEnd:   time.Now().Add(time.Hour),
+	}
+	v6 := &DHCPLease{
+		MAC:        "aa:bb:cc:dd:ee:ff",
+		IP:         "2001:db8::100",
+		ClientID:   "00030001aabbccddeeff",
+		LeaseStart: time.Now(),
+		LeaseEnd:   time.Now().Add(time.Hour),
+	}
+	if err := bucket.Set(v4); err != nil {
+		t.Fatalf("failed to set v4 lease: %v", err)
+	}
+	if err := bucket.Set(v6); err != nil {
+		t.Fatalf("failed to set v6 lease: %v", err)
+	}
+
+	// Same MAC must not overwrite the other family
+	leases, _ := bucket.List()
+	if len(leases) != 2 {
+		t.Fatalf("expected 2 leases, got %d", len(leases))
+	}
+	if l, err := bucket.Get("aa:bb:cc:dd:ee:ff"); err != nil || l.IP != "192.168.1.100" {
+		t.Errorf("v4 lease lookup: %v, %v", l, err)
+	}
+	if l, err := bucket.GetV6("00030001aabbccddeeff"); err != nil || l.IP != "2001:db8::100" {
+		t.Errorf("v6 lease lookup: %v, %v", l, err)
+	}
+
+	if err := bucket.DeleteV6("00030001aabbccddeeff"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := bucket.GetV6("00030001aabbccddeeff"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := bucket.Get("aa:bb:cc:dd:ee:ff"); err != nil {
+		t.Errorf("v4 lease should remain: %v", err)
+	}
+}
+
 // TestDNSBucket tests DNS cache bucket operations
 func TestDNSBucket(t *testing.T) {
 	store, _ := NewSQLiteStore(DefaultOptions(":memory:"))
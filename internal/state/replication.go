@@ -2,11 +2,13 @@ package state
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"grimm.is/glacic/internal/clock"
 	"io"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -29,8 +31,20 @@ type ReplicationConfig struct {
 	PrimaryAddr    string        // For replica: where to connect to primary
 	ReconnectDelay time.Duration // How long to wait before reconnecting
 	SyncTimeout    time.Duration // Timeout for initial sync
+
+	// Authentication: mutual TLS when all three files are set, otherwise
+	// a TLS identity derived from SecretKey. One of them is required.
+	SecretKey   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
 }
 
+// heartbeatInterval is how often the primary announces its version so
+// replicas can report lag while idle. Peers silent for three intervals
+// are disconnected.
+const heartbeatInterval = 10 * time.Second
+
 // DefaultReplicationConfig returns sensible defaults.
 func DefaultReplicationConfig() ReplicationConfig {
 	return ReplicationConfig{
@@ -47,9 +61,23 @@ type Replicator struct {
 	config ReplicationConfig
 	logger *logging.Logger
 
-	mu       sync.RWMutex
-	replicas map[string]*replicaConn
-	primary  *primaryConn
+	tlsConf *tls.Config
+
+	mu         sync.RWMutex
+	replicas   map[string]*replicaConn
+	primary    *primaryConn
+	listener   net.Listener
+	roleCancel context.CancelFunc // Stops the current role's goroutines
+	resync     bool               // Request a full snapshot on next connect
+
+	// Conntrack sync; the cache holds the primary's flows on a replica
+	flows     FlowSyncer
+	flowCache map[string]FlowEvent
+
+	// Replica progress, in primary versions
+	primaryVersion uint64
+	lastApplied    uint64
+	lastAppliedAt  time.Time
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -57,15 +85,25 @@ type Replicator struct {
 
 // replicaConn represents a connection to a replica.
 type replicaConn struct {
-	conn    net.Conn
-	encoder *json.Encoder
-	version uint64
+	conn     net.Conn
+	sendMu   sync.Mutex // Serializes writes from the broadcasters
+	encoder  *json.Encoder
+	version  uint64 // Last version acknowledged by the replica
+	lastSeen time.Time
+}
+
+// send writes a message to the replica.
+func (c *replicaConn) send(msg replicationMessage) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.encoder.Encode(msg)
 }
 
 // primaryConn represents a connection to the primary.
 type primaryConn struct {
 	conn    net.Conn
 	decoder *json.Decoder
+	encoder *json.Encoder
 }
 
 // NewReplicator creates a new replicator.
@@ -81,27 +119,89 @@ func NewReplicator(store *SQLiteStore, config ReplicationConfig, logger *logging
 	}
 }
 
-// Start begins replication based on mode.
+// Start begins replication based on mode. It fails if no authentication
+// is configured.
 func (r *Replicator) Start() error {
-	switch r.config.Mode {
+	tlsConf, err := r.config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	r.tlsConf = tlsConf
+
+	return r.startRole()
+}
+
+// startRole starts the goroutines for the configured mode.
+func (r *Replicator) startRole() error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.mu.Lock()
+	r.roleCancel = cancel
+	mode := r.config.Mode
+	r.mu.Unlock()
+
+	switch mode {
 	case ModePrimary:
-		return r.startPrimary()
+		return r.startPrimary(ctx)
 	case ModeReplica:
-		return r.startReplica()
+		return r.startReplica(ctx)
 	case ModeStandby:
 		// Standby mode waits for explicit sync
 		return nil
 	default:
-		return fmt.Errorf("unknown replication mode: %s", r.config.Mode)
+		return fmt.Errorf("unknown replication mode: %s", mode)
 	}
 }
 
+// SwitchMode changes the replication role at runtime, e.g. when VRRP
+// elects a new master. primaryAddr is the node to replicate from in
+// replica mode. A node that becomes a replica resyncs from a full
+// snapshot, since versions are only comparable within one primary.
+func (r *Replicator) SwitchMode(mode ReplicationMode, primaryAddr string) error {
+	r.mu.Lock()
+	if r.config.Mode == mode && (mode != ModeReplica || r.config.PrimaryAddr == primaryAddr) {
+		r.mu.Unlock()
+		return nil
+	}
+	r.stopRoleLocked()
+	r.config.Mode = mode
+	if mode == ModeReplica {
+		r.config.PrimaryAddr = primaryAddr
+		r.resync = true
+		r.lastApplied = 0
+		r.primaryVersion = 0
+	}
+	r.mu.Unlock()
+
+	r.logger.Info("Replication role changed", "mode", mode, "primary", primaryAddr)
+	if mode == ModePrimary {
+		// Take over the old primary's sessions before serving replicas
+		r.commitFlows()
+	}
+	return r.startRole()
+}
+
 // Stop stops replication.
 func (r *Replicator) Stop() {
 	r.cancel()
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.stopRoleLocked()
+}
+
+// stopRoleLocked stops the current role and closes its connections.
+// Caller must hold r.mu.
+func (r *Replicator) stopRoleLocked() {
+	if r.roleCancel != nil {
+		r.roleCancel()
+		r.roleCancel = nil
+	}
+
+	// Close the listener now so a new primary can bind the same address
+	if r.listener != nil {
+		r.listener.Close()
+		r.listener = nil
+	}
 
 	// Close replica connections
 	for addr, replica := range r.replicas {
@@ -117,13 +217,16 @@ func (r *Replicator) Stop() {
 }
 
 // startPrimary starts the primary replication server.
-func (r *Replicator) startPrimary() error {
-	listener, err := net.Listen("tcp", r.config.ListenAddr)
+func (r *Replicator) startPrimary(ctx context.Context) error {
+	listener, err := tls.Listen("tcp", r.config.ListenAddr, r.tlsConf)
 	if err != nil {
 		return fmt.Errorf("failed to start replication listener: %w", err)
 	}
+	r.mu.Lock()
+	r.listener = listener
+	r.mu.Unlock()
 
-	r.logger.Info("Replication primary started", "addr", r.config.ListenAddr)
+	r.logger.Info("Replication primary started", "addr", r.config.ListenAddr, "auth", r.config.authMode())
 
 	// Accept replica connections
 	go func() {
@@ -131,7 +234,7 @@ func (r *Replicator) startPrimary() error {
 			conn, err := listener.Accept()
 			if err != nil {
 				select {
-				case <-r.ctx.Done():
+				case <-ctx.Done():
 					listener.Close()
 					return
 				default:
@@ -139,19 +242,31 @@ func (r *Replicator) startPrimary() error {
 					continue
 				}
 			}
-			go r.handleReplica(conn)
+			go r.handleReplica(ctx, conn)
 		}
 	}()
 
 	// Subscribe to changes and broadcast to replicas
-	go r.broadcastChanges()
+	go r.broadcastChanges(ctx)
+	if r.flows != nil {
+		go r.broadcastFlows(ctx)
+	}
 
 	return nil
 }
 
 // handleReplica handles a new replica connection.
-func (r *Replicator) handleReplica(conn net.Conn) {
+func (r *Replicator) handleReplica(ctx context.Context, conn net.Conn) {
 	addr := conn.RemoteAddr().String()
+
+	// Authenticate before reading anything from the peer
+	conn.SetDeadline(clock.Now().Add(r.config.SyncTimeout))
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		r.logger.Warn("Rejected replica", "addr", addr, "error", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
 	r.logger.Info("Replica connected", "addr", addr)
 
 	decoder := json.NewDecoder(conn)
@@ -166,6 +281,7 @@ func (r *Replicator) handleReplica(conn net.Conn) {
 	}
 
 	// Send snapshot or changes since version
+	sent := req.Version
 	if req.Version == 0 {
 		// Full sync
 		snapshot, err := r.store.CreateSnapshot()
@@ -185,6 +301,7 @@ func (r *Replicator) handleReplica(conn net.Conn) {
 			return
 		}
 		r.logger.Info("Sent full snapshot to replica", "addr", addr, "version", snapshot.Version)
+		sent = snapshot.Version
 	} else {
 		// Incremental sync
 		changes, err := r.store.GetChangesSince(req.Version)
@@ -204,47 +321,92 @@ func (r *Replicator) handleReplica(conn net.Conn) {
 			return
 		}
 		r.logger.Info("Sent incremental changes to replica", "addr", addr, "count", len(changes))
+		if n := len(changes); n > 0 {
+			sent = changes[n-1].Version
+		}
 	}
 
 	// Register replica for ongoing updates
+	replica := &replicaConn{
+		conn:     conn,
+		encoder:  encoder,
+		version:  sent,
+		lastSeen: clock.Now(),
+	}
+	// Hold the send lock until the flow dump is out, so flow events
+	// broadcast after registration are applied on top of it
+	replica.sendMu.Lock()
 	r.mu.Lock()
-	r.replicas[addr] = &replicaConn{
-		conn:    conn,
-		encoder: encoder,
-		version: r.store.CurrentVersion(),
+	if ctx.Err() != nil {
+		// No longer primary
+		r.mu.Unlock()
+		replica.sendMu.Unlock()
+		conn.Close()
+		return
 	}
+	r.replicas[addr] = replica
 	r.mu.Unlock()
+	if r.flows != nil {
+		if err := r.sendFlowDump(replica, sent); err != nil {
+			r.logger.Warn("Failed to send flow table", "addr", addr, "error", err)
+		}
+	}
+	replica.sendMu.Unlock()
 
-	// Keep connection alive and handle disconnects
+	// Track acknowledgements and handle disconnects
 	go func() {
-		buf := make([]byte, 1)
 		for {
-			conn.SetReadDeadline(clock.Now().Add(30 * time.Second))
-			_, err := conn.Read(buf)
-			if err != nil {
+			conn.SetReadDeadline(clock.Now().Add(3 * heartbeatInterval))
+			var ack replicaAck
+			if err := decoder.Decode(&ack); err != nil {
 				r.mu.Lock()
-				delete(r.replicas, addr)
+				if r.replicas[addr] == replica {
+					delete(r.replicas, addr)
+				}
 				r.mu.Unlock()
 				conn.Close()
 				r.logger.Info("Replica disconnected", "addr", addr)
 				return
 			}
+			r.mu.Lock()
+			replica.version = ack.Version
+			replica.lastSeen = clock.Now()
+			r.mu.Unlock()
 		}
 	}()
 }
 
-// broadcastChanges subscribes to store changes and sends to all replicas.
-func (r *Replicator) broadcastChanges() {
-	changes := r.store.Subscribe(r.ctx)
+// broadcastChanges subscribes to store changes and sends them, and
+// periodic heartbeats, to all replicas.
+func (r *Replicator) broadcastChanges(ctx context.Context) {
+	changes := r.store.Subscribe(ctx)
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		var msg replicationMessage
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			msg = replicationMessage{
+				Type:    "change",
+				Change:  &change,
+				Version: change.Version,
+			}
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			msg = replicationMessage{
+				Type:    "heartbeat",
+				Version: r.store.CurrentVersion(),
+			}
+		}
 
-	for change := range changes {
 		r.mu.RLock()
 		for addr, replica := range r.replicas {
-			msg := replicationMessage{
-				Type:   "change",
-				Change: &change,
-			}
-			if err := replica.encoder.Encode(msg); err != nil {
+			if err := replica.send(msg); err != nil {
 				r.logger.Warn("Failed to send change to replica", "addr", addr, "error", err)
 				// Will be cleaned up by the read goroutine
 			}
@@ -254,23 +416,23 @@ func (r *Replicator) broadcastChanges() {
 }
 
 // startReplica connects to the primary and receives updates.
-func (r *Replicator) startReplica() error {
-	go r.replicaLoop()
+func (r *Replicator) startReplica(ctx context.Context) error {
+	go r.replicaLoop(ctx)
 	return nil
 }
 
 // replicaLoop maintains connection to primary.
-func (r *Replicator) replicaLoop() {
+func (r *Replicator) replicaLoop(ctx context.Context) {
 	for {
 		select {
-		case <-r.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
 		}
 
-		if err := r.connectToPrimary(); err != nil {
+		if err := r.connectToPrimary(ctx); err != nil {
 			r.logger.Warn("Failed to connect to primary", "error", err)
-			time.Sleep(r.config.ReconnectDelay)
+			r.sleep(ctx, r.config.ReconnectDelay)
 			continue
 		}
 
@@ -283,14 +445,28 @@ func (r *Replicator) replicaLoop() {
 				r.primary = nil
 			}
 			r.mu.Unlock()
-			time.Sleep(r.config.ReconnectDelay)
+			r.sleep(ctx, r.config.ReconnectDelay)
 		}
 	}
 }
 
+// sleep waits for d or until ctx is cancelled.
+func (r *Replicator) sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
 // connectToPrimary establishes connection and performs initial sync.
-func (r *Replicator) connectToPrimary() error {
-	conn, err := net.DialTimeout("tcp", r.config.PrimaryAddr, r.config.SyncTimeout)
+func (r *Replicator) connectToPrimary(ctx context.Context) error {
+	r.mu.RLock()
+	addr := r.config.PrimaryAddr
+	r.mu.RUnlock()
+
+	conn, err := r.dialPeer(addr)
 	if err != nil {
 		return err
 	}
@@ -302,6 +478,13 @@ func (r *Replicator) connectToPrimary() error {
 	req := syncRequest{
 		Version: r.store.CurrentVersion(),
 	}
+	r.mu.RLock()
+	if r.resync {
+		req.Version = 0
+	} else if r.lastApplied > 0 {
+		req.Version = r.lastApplied
+	}
+	r.mu.RUnlock()
 	if err := encoder.Encode(req); err != nil {
 		conn.Close()
 		return err
@@ -322,6 +505,10 @@ func (r *Replicator) connectToPrimary() error {
 			return fmt.Errorf("failed to restore snapshot: %w", err)
 		}
 		r.logger.Info("Restored snapshot from primary", "version", resp.Snapshot.Version)
+		r.noteApplied(resp.Snapshot.Version)
+		r.mu.Lock()
+		r.resync = false
+		r.mu.Unlock()
 
 	case "changes":
 		for _, change := range resp.Changes {
@@ -329,17 +516,27 @@ func (r *Replicator) connectToPrimary() error {
 				r.logger.Warn("Failed to apply change", "error", err)
 			}
 		}
+		if n := len(resp.Changes); n > 0 {
+			r.noteApplied(resp.Changes[n-1].Version)
+		}
 		r.logger.Info("Applied incremental changes", "count", len(resp.Changes))
 	}
 
 	r.mu.Lock()
+	if ctx.Err() != nil {
+		// Role changed while syncing
+		r.mu.Unlock()
+		conn.Close()
+		return ctx.Err()
+	}
 	r.primary = &primaryConn{
 		conn:    conn,
 		decoder: decoder,
+		encoder: encoder,
 	}
 	r.mu.Unlock()
 
-	r.logger.Info("Connected to primary", "addr", r.config.PrimaryAddr)
+	r.logger.Info("Connected to primary", "addr", addr)
 	return nil
 }
 
@@ -354,6 +551,7 @@ func (r *Replicator) receiveUpdates() error {
 	}
 
 	for {
+		primary.conn.SetReadDeadline(clock.Now().Add(3 * heartbeatInterval))
 		var msg replicationMessage
 		if err := primary.decoder.Decode(&msg); err != nil {
 			if err == io.EOF {
@@ -363,13 +561,40 @@ func (r *Replicator) receiveUpdates() error {
 		}
 
 		switch msg.Type {
+		case "flows":
+			r.cacheFlows(msg.Flows, msg.Resync)
 		case "change":
 			if msg.Change != nil {
 				if err := r.applyChange(*msg.Change); err != nil {
 					r.logger.Warn("Failed to apply change", "error", err)
+				} else {
+					r.noteApplied(msg.Change.Version)
 				}
 			}
 		}
+
+		r.mu.Lock()
+		if msg.Version > r.primaryVersion {
+			r.primaryVersion = msg.Version
+		}
+		ack := replicaAck{Version: r.lastApplied}
+		r.mu.Unlock()
+
+		// Acknowledge progress so the primary can report our lag
+		if err := primary.encoder.Encode(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// noteApplied records the primary version a replica has caught up to.
+func (r *Replicator) noteApplied(version uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastApplied = version
+	r.lastAppliedAt = clock.Now()
+	if version > r.primaryVersion {
+		r.primaryVersion = version
 	}
 }
 
@@ -389,10 +614,107 @@ func (r *Replicator) applyChange(change Change) error {
 	}
 }
 
+// ReplicationStatus reports replication health. Versions are the
+// primary's store versions; lag is how many versions a node is behind.
+type ReplicationStatus struct {
+	Mode ReplicationMode `json:"mode"`
+	Auth string          `json:"auth"` // "tls" or "psk"
+
+	// Primary
+	CurrentVersion uint64          `json:"current_version,omitempty"`
+	Replicas       []ReplicaStatus `json:"replicas,omitempty"`
+
+	// Replica
+	Connected          bool      `json:"connected"`
+	PrimaryAddr        string    `json:"primary_addr,omitempty"`
+	PrimaryVersion     uint64    `json:"primary_version,omitempty"`
+	LastAppliedVersion uint64    `json:"last_applied_version,omitempty"`
+	LastAppliedAt      time.Time `json:"last_applied_at,omitempty"`
+	Lag                uint64    `json:"lag"`
+	SyncedFlows        int       `json:"synced_flows,omitempty"` // Conntrack entries cached for takeover
+}
+
+// ReplicaStatus describes a replica connected to the primary.
+type ReplicaStatus struct {
+	Addr     string    `json:"addr"`
+	Version  uint64    `json:"version"` // Last acknowledged version
+	Lag      uint64    `json:"lag"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Status returns the current replication state.
+func (r *Replicator) Status() ReplicationStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status := ReplicationStatus{
+		Mode: r.config.Mode,
+		Auth: r.config.authMode(),
+	}
+
+	switch r.config.Mode {
+	case ModePrimary:
+		status.CurrentVersion = r.store.CurrentVersion()
+		status.Connected = len(r.replicas) > 0
+		for addr, replica := range r.replicas {
+			rs := ReplicaStatus{
+				Addr:     addr,
+				Version:  replica.version,
+				LastSeen: replica.lastSeen,
+			}
+			if status.CurrentVersion > replica.version {
+				rs.Lag = status.CurrentVersion - replica.version
+			}
+			if rs.Lag > status.Lag {
+				status.Lag = rs.Lag
+			}
+			status.Replicas = append(status.Replicas, rs)
+		}
+		sort.Slice(status.Replicas, func(i, j int) bool {
+			return status.Replicas[i].Addr < status.Replicas[j].Addr
+		})
+	case ModeReplica:
+		status.Connected = r.primary != nil
+		status.PrimaryAddr = r.config.PrimaryAddr
+		status.PrimaryVersion = r.primaryVersion
+		status.LastAppliedVersion = r.lastApplied
+		status.LastAppliedAt = r.lastAppliedAt
+		if r.primaryVersion > r.lastApplied {
+			status.Lag = r.primaryVersion - r.lastApplied
+		}
+		status.SyncedFlows = len(r.flowCache)
+	}
+	return status
+}
+
+// Role returns the current replication mode and whether the partner is
+// connected: any replica for a primary, the primary for a replica.
+func (r *Replicator) Role() (ReplicationMode, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	switch r.config.Mode {
+	case ModePrimary:
+		return ModePrimary, len(r.replicas) > 0
+	case ModeReplica:
+		return ModeReplica, r.primary != nil
+	default:
+		return r.config.Mode, false
+	}
+}
+
 // SyncFromPeer performs a one-time sync from another node.
 // Used for upgrades and initial HA setup.
 func (r *Replicator) SyncFromPeer(addr string) error {
-	conn, err := net.DialTimeout("tcp", addr, r.config.SyncTimeout)
+	if r.tlsConf == nil {
+		tlsConf, err := r.config.tlsConfig()
+		if err != nil {
+			return err
+		}
+		r.tlsConf = tlsConf
+	}
+
+	conn, err := r.dialPeer(addr)
 	if err != nil {
 		return err
 	}
@@ -442,6 +764,14 @@ type syncResponse struct {
 }
 
 type replicationMessage struct {
-	Type   string  `json:"type"` // "change"
-	Change *Change `json:"change,omitempty"`
+	Type    string      `json:"type"` // "change", "flows" or "heartbeat"
+	Change  *Change     `json:"change,omitempty"`
+	Flows   []FlowEvent `json:"flows,omitempty"`
+	Resync  bool        `json:"resync,omitempty"` // Flows replace the replica's cache
+	Version uint64      `json:"version"`          // Primary version after this message
+}
+
+// replicaAck reports the last primary version a replica applied.
+type replicaAck struct {
+	Version uint64 `json:"version"`
 }
@@ -0,0 +1,188 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// FlowEventType is the kind of connection-tracking event.
+type FlowEventType string
+
+const (
+	FlowNew     FlowEventType = "new"
+	FlowUpdate  FlowEventType = "update"
+	FlowDestroy FlowEventType = "destroy"
+)
+
+// FlowTuple is one direction of a tracked connection.
+type FlowTuple struct {
+	Src     netip.Addr `json:"src"`
+	Dst     netip.Addr `json:"dst"`
+	SrcPort uint16     `json:"sport"`
+	DstPort uint16     `json:"dport"`
+}
+
+// FlowTCPInfo carries the TCP tracking state of a flow.
+type FlowTCPInfo struct {
+	State       uint8 `json:"state"`
+	WScaleOrig  uint8 `json:"wscale_orig,omitempty"`
+	WScaleReply uint8 `json:"wscale_reply,omitempty"`
+	FlagsOrig   uint8 `json:"flags_orig,omitempty"`
+	FlagsReply  uint8 `json:"flags_reply,omitempty"`
+}
+
+// FlowEvent is a conntrack entry streamed from the primary. The reply
+// tuple differs from the inverted original when the flow is NATed, which
+// lets the standby recreate the mapping on takeover.
+type FlowEvent struct {
+	Type     FlowEventType `json:"type"`
+	Protocol uint8         `json:"proto"`
+	Orig     FlowTuple     `json:"orig"`
+	Reply    FlowTuple     `json:"reply"`
+	Status   uint32        `json:"status,omitempty"`
+	Timeout  uint32        `json:"timeout,omitempty"` // Seconds
+	Mark     uint32        `json:"mark,omitempty"`
+	Zone     uint16        `json:"zone,omitempty"`
+	TCP      *FlowTCPInfo  `json:"tcp,omitempty"`
+}
+
+// key identifies a flow by its original tuple.
+func (e FlowEvent) key() string {
+	return fmt.Sprintf("%d/%d/%s/%d/%s/%d", e.Protocol, e.Zone, e.Orig.Src, e.Orig.SrcPort, e.Orig.Dst, e.Orig.DstPort)
+}
+
+// FlowSyncer connects replication to the kernel connection tracker,
+// following conntrackd's FTFW model: the primary streams its events, the
+// standby keeps them in an external cache and commits the cache to its
+// kernel when it is promoted. Implemented by firewall.ConntrackSync.
+type FlowSyncer interface {
+	// DumpFlows returns the current table, sent to replicas on connect.
+	DumpFlows() ([]FlowEvent, error)
+	// WatchFlows streams local events until ctx is cancelled.
+	WatchFlows(ctx context.Context) (<-chan FlowEvent, error)
+	// CommitFlows installs cached flows into the local kernel.
+	CommitFlows(flows []FlowEvent) error
+}
+
+const (
+	// flowBatchSize caps the flows sent in one message.
+	flowBatchSize = 512
+	// flowBatchDelay is how long events are batched before sending.
+	flowBatchDelay = 100 * time.Millisecond
+)
+
+// SetFlowSyncer enables conntrack synchronisation. Call before Start.
+func (r *Replicator) SetFlowSyncer(fs FlowSyncer) {
+	r.flows = fs
+}
+
+// sendFlowDump sends the full flow table to a newly connected replica,
+// which replaces its cache with it. Caller must hold replica.sendMu.
+func (r *Replicator) sendFlowDump(replica *replicaConn, version uint64) error {
+	flows, err := r.flows.DumpFlows()
+	if err != nil {
+		return err
+	}
+	for start := 0; start == 0 || start < len(flows); start += flowBatchSize {
+		end := min(start+flowBatchSize, len(flows))
+		msg := replicationMessage{
+			Type:    "flows",
+			Flows:   flows[start:end],
+			Resync:  start == 0,
+			Version: version,
+		}
+		if err := replica.encoder.Encode(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// broadcastFlows batches local conntrack events and sends them to all
+// replicas.
+func (r *Replicator) broadcastFlows(ctx context.Context) {
+	events, err := r.flows.WatchFlows(ctx)
+	if err != nil {
+		r.logger.Warn("Conntrack sync disabled", "error", err)
+		return
+	}
+
+	var batch []FlowEvent
+	timer := time.NewTimer(flowBatchDelay)
+	timer.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		msg := replicationMessage{Type: "flows", Flows: batch, Version: r.store.CurrentVersion()}
+		r.mu.RLock()
+		for addr, replica := range r.replicas {
+			if err := replica.send(msg); err != nil {
+				r.logger.Warn("Failed to send flows to replica", "addr", addr, "error", err)
+			}
+		}
+		r.mu.RUnlock()
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(flowBatchDelay)
+			}
+			batch = append(batch, ev)
+			if len(batch) >= flowBatchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// cacheFlows applies flows received from the primary to the external
+// cache. A resync message starts a new cache.
+func (r *Replicator) cacheFlows(flows []FlowEvent, resync bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if resync || r.flowCache == nil {
+		r.flowCache = make(map[string]FlowEvent)
+	}
+	for _, f := range flows {
+		if f.Type == FlowDestroy {
+			delete(r.flowCache, f.key())
+		} else {
+			r.flowCache[f.key()] = f
+		}
+	}
+}
+
+// commitFlows installs the cached flows into the kernel so sessions that
+// went through the old primary keep working.
+func (r *Replicator) commitFlows() {
+	r.mu.Lock()
+	flows := make([]FlowEvent, 0, len(r.flowCache))
+	for _, f := range r.flowCache {
+		flows = append(flows, f)
+	}
+	r.flowCache = nil
+	r.mu.Unlock()
+
+	if r.flows == nil || len(flows) == 0 {
+		return
+	}
+	if err := r.flows.CommitFlows(flows); err != nil {
+		r.logger.Warn("Failed to commit some synced flows", "error", err)
+	}
+	r.logger.Info("Committed synced flows", "count", len(flows))
+}
@@ -0,0 +1,389 @@
+package state
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"grimm.is/glacic/internal/logging"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func newReplicationPair(t *testing.T, primaryCfg, replicaCfg ReplicationConfig) (*SQLiteStore, *SQLiteStore, *Replicator, *Replicator) {
+	t.Helper()
+	logger := logging.New(logging.DefaultConfig())
+
+	primaryStore, err := NewSQLiteStore(DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { primaryStore.Close() })
+	replicaStore, err := NewSQLiteStore(DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { replicaStore.Close() })
+
+	addr := freeAddr(t)
+	primaryCfg.Mode = ModePrimary
+	primaryCfg.ListenAddr = addr
+	primaryCfg.SyncTimeout = 5 * time.Second
+	replicaCfg.Mode = ModeReplica
+	replicaCfg.PrimaryAddr = addr
+	replicaCfg.SyncTimeout = 5 * time.Second
+	replicaCfg.ReconnectDelay = 100 * time.Millisecond
+
+	primary := NewReplicator(primaryStore, primaryCfg, logger)
+	if err := primary.Start(); err != nil {
+		t.Fatalf("primary Start failed: %v", err)
+	}
+	t.Cleanup(primary.Stop)
+
+	return primaryStore, replicaStore, primary, NewReplicator(replicaStore, replicaCfg, logger)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReplication_PSK(t *testing.T) {
+	psk := ReplicationConfig{SecretKey: "correct horse battery staple"}
+	primaryStore, replicaStore, primary, replica := newReplicationPair(t, psk, psk)
+
+	primaryStore.CreateBucket("leases")
+	primaryStore.Set("leases", "a", []byte("1"))
+
+	if err := replica.Start(); err != nil {
+		t.Fatalf("replica Start failed: %v", err)
+	}
+	t.Cleanup(replica.Stop)
+
+	waitFor(t, "replica to connect", func() bool { return replica.Status().Connected })
+	primaryStore.Set("leases", "b", []byte("2"))
+
+	waitFor(t, "change to replicate", func() bool {
+		v, err := replicaStore.Get("leases", "b")
+		return err == nil && string(v) == "2"
+	})
+	if v, err := replicaStore.Get("leases", "a"); err != nil || string(v) != "1" {
+		t.Errorf("snapshot not restored: %q, %v", v, err)
+	}
+
+	rs := replica.Status()
+	if rs.Auth != AuthPSK || rs.LastAppliedVersion != primaryStore.CurrentVersion() || rs.Lag != 0 || rs.LastAppliedAt.IsZero() {
+		t.Errorf("unexpected replica status: %+v", rs)
+	}
+
+	// The primary learns the replica's progress from its acknowledgements
+	waitFor(t, "replica acknowledgement", func() bool {
+		ps := primary.Status()
+		return len(ps.Replicas) == 1 && ps.Replicas[0].Version == primaryStore.CurrentVersion()
+	})
+}
+
+func TestReplication_RejectsWrongSecret(t *testing.T) {
+	_, _, primary, replica := newReplicationPair(t,
+		ReplicationConfig{SecretKey: "correct horse battery staple"},
+		ReplicationConfig{SecretKey: "incorrect horse battery staple"},
+	)
+
+	if err := replica.SyncFromPeer(replica.config.PrimaryAddr); err == nil {
+		t.Fatal("expected sync with a mismatched secret to fail")
+	}
+	if n := len(primary.Status().Replicas); n != 0 {
+		t.Errorf("expected no registered replicas, got %d", n)
+	}
+}
+
+func TestReplication_RequiresAuth(t *testing.T) {
+	store, err := NewSQLiteStore(DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	r := NewReplicator(store, ReplicationConfig{Mode: ModePrimary, ListenAddr: "127.0.0.1:0"}, logging.New(logging.DefaultConfig()))
+	if err := r.Start(); !errors.Is(err, ErrReplicationAuth) {
+		t.Errorf("expected ErrReplicationAuth, got %v", err)
+	}
+}
+
+func TestReplication_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCA(t, dir, "ca")
+	primaryCfg := writeTestNodeCert(t, dir, "primary", ca, caKey)
+	replicaCfg := writeTestNodeCert(t, dir, "replica", ca, caKey)
+	_, replicaStore, _, replica := newReplicationPair(t, primaryCfg, replicaCfg)
+
+	if err := replica.SyncFromPeer(replica.config.PrimaryAddr); err != nil {
+		t.Fatalf("sync with a CA-signed certificate failed: %v", err)
+	}
+	if replica.Status().Auth != AuthTLS {
+		t.Errorf("expected tls auth, got %s", replica.Status().Auth)
+	}
+
+	// A certificate from another CA is rejected
+	rogueCA, rogueKey := writeTestCA(t, dir, "rogue")
+	rogueCfg := writeTestNodeCert(t, dir, "rogue-node", rogueCA, rogueKey)
+	rogueCfg.TLSCAFile = primaryCfg.TLSCAFile
+	rogue := NewReplicator(replicaStore, rogueCfg, logging.New(logging.DefaultConfig()))
+	rogue.config.SyncTimeout = 5 * time.Second
+	if err := rogue.SyncFromPeer(replica.config.PrimaryAddr); err == nil {
+		t.Error("expected sync with a foreign certificate to fail")
+	}
+}
+
+func TestReplication_SwitchMode(t *testing.T) {
+	psk := ReplicationConfig{SecretKey: "correct horse battery staple"}
+	replicaCfg := psk
+	replicaCfg.ListenAddr = freeAddr(t)
+	primaryStore, replicaStore, primary, replica := newReplicationPair(t, psk, replicaCfg)
+	primaryStore.CreateBucket("leases")
+	primaryStore.Set("leases", "a", []byte("1"))
+
+	if err := replica.Start(); err != nil {
+		t.Fatalf("replica Start failed: %v", err)
+	}
+	t.Cleanup(replica.Stop)
+	waitFor(t, "initial sync", func() bool {
+		_, err := replicaStore.Get("leases", "a")
+		return err == nil
+	})
+
+	// Fail over: the replica takes over and the old primary follows it
+	if err := replica.SwitchMode(ModePrimary, ""); err != nil {
+		t.Fatalf("promote failed: %v", err)
+	}
+	if err := primary.SwitchMode(ModeReplica, replicaCfg.ListenAddr); err != nil {
+		t.Fatalf("demote failed: %v", err)
+	}
+	waitFor(t, "old primary to connect", func() bool { return primary.Status().Connected })
+
+	replicaStore.Set("leases", "b", []byte("2"))
+	waitFor(t, "change to replicate back", func() bool {
+		v, err := primaryStore.Get("leases", "b")
+		return err == nil && string(v) == "2"
+	})
+	if s := replica.Status(); s.Mode != ModePrimary || len(s.Replicas) != 1 {
+		t.Errorf("unexpected promoted status: %+v", s)
+	}
+	if mode, up := replica.Role(); mode != ModePrimary || !up {
+		t.Errorf("promoted role = %s (partner up %v)", mode, up)
+	}
+	if mode, up := primary.Role(); mode != ModeReplica || !up {
+		t.Errorf("demoted role = %s (partner up %v)", mode, up)
+	}
+
+	// Switching to the current role is a no-op
+	if err := replica.SwitchMode(ModePrimary, ""); err != nil {
+		t.Errorf("repeated promote failed: %v", err)
+	}
+}
+
+func writeTestCA(t *testing.T, dir, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func writeTestNodeCert(t *testing.T, dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ReplicationConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ReplicationConfig{
+		TLSCertFile: filepath.Join(dir, name+"-cert.pem"),
+		TLSKeyFile:  filepath.Join(dir, name+"-key.pem"),
+		TLSCAFile:   filepath.Join(dir, ca.Subject.CommonName+".pem"),
+	}
+	writePEM(t, cfg.TLSCertFile, "CERTIFICATE", der)
+	writePEM(t, cfg.TLSKeyFile, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeFlows is an in-memory FlowSyncer.
+type fakeFlows struct {
+	dump      []FlowEvent
+	events    chan FlowEvent
+	mu        sync.Mutex
+	committed []FlowEvent
+}
+
+func (f *fakeFlows) DumpFlows() ([]FlowEvent, error) { return f.dump, nil }
+
+func (f *fakeFlows) WatchFlows(ctx context.Context) (<-chan FlowEvent, error) {
+	return f.events, nil
+}
+
+func (f *fakeFlows) CommitFlows(flows []FlowEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, flows...)
+	return nil
+}
+
+func testFlow(typ FlowEventType, sport uint16) FlowEvent {
+	orig := FlowTuple{
+		Src:     netip.MustParseAddr("192.168.1.10"),
+		Dst:     netip.MustParseAddr("203.0.113.5"),
+		SrcPort: sport,
+		DstPort: 443,
+	}
+	return FlowEvent{
+		Type:     typ,
+		Protocol: 6,
+		Orig:     orig,
+		Reply: FlowTuple{
+			Src:     orig.Dst,
+			Dst:     netip.MustParseAddr("198.51.100.1"),
+			SrcPort: 443,
+			DstPort: sport,
+		},
+		TCP: &FlowTCPInfo{State: 3},
+	}
+}
+
+func TestReplication_FlowSync(t *testing.T) {
+	logger := logging.New(logging.DefaultConfig())
+	primaryStore, err := NewSQLiteStore(DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { primaryStore.Close() })
+	replicaStore, err := NewSQLiteStore(DefaultOptions(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { replicaStore.Close() })
+
+	addr := freeAddr(t)
+	primary := NewReplicator(primaryStore, ReplicationConfig{
+		Mode:        ModePrimary,
+		ListenAddr:  addr,
+		SecretKey:   "correct horse battery staple",
+		SyncTimeout: 5 * time.Second,
+	}, logger)
+	primaryFlows := &fakeFlows{
+		dump:   []FlowEvent{testFlow(FlowNew, 1000), testFlow(FlowNew, 1001)},
+		events: make(chan FlowEvent, 10),
+	}
+	primary.SetFlowSyncer(primaryFlows)
+	if err := primary.Start(); err != nil {
+		t.Fatalf("primary Start failed: %v", err)
+	}
+	t.Cleanup(primary.Stop)
+
+	replica := NewReplicator(replicaStore, ReplicationConfig{
+		Mode:           ModeReplica,
+		PrimaryAddr:    addr,
+		ListenAddr:     freeAddr(t),
+		SecretKey:      "correct horse battery staple",
+		SyncTimeout:    5 * time.Second,
+		ReconnectDelay: 100 * time.Millisecond,
+	}, logger)
+	replicaFlows := &fakeFlows{events: make(chan FlowEvent)}
+	replica.SetFlowSyncer(replicaFlows)
+	if err := replica.Start(); err != nil {
+		t.Fatalf("replica Start failed: %v", err)
+	}
+	t.Cleanup(replica.Stop)
+
+	waitFor(t, "flow table dump", func() bool { return replica.Status().SyncedFlows == 2 })
+
+	// Stream a new flow and the end of an existing one
+	primaryFlows.events <- testFlow(FlowNew, 1002)
+	primaryFlows.events <- testFlow(FlowDestroy, 1000)
+	waitFor(t, "flow events", func() bool {
+		replica.mu.RLock()
+		defer replica.mu.RUnlock()
+		_, gone := replica.flowCache[testFlow(FlowNew, 1000).key()]
+		return len(replica.flowCache) == 2 && !gone
+	})
+
+	// Takeover commits the cache to the kernel
+	if err := replica.SwitchMode(ModePrimary, ""); err != nil {
+		t.Fatalf("promote failed: %v", err)
+	}
+	replicaFlows.mu.Lock()
+	defer replicaFlows.mu.Unlock()
+	got := map[uint16]bool{}
+	for _, f := range replicaFlows.committed {
+		got[f.Orig.SrcPort] = true
+	}
+	if len(replicaFlows.committed) != 2 || !got[1001] || !got[1002] {
+		t.Errorf("committed flows = %+v, want ports 1001 and 1002", replicaFlows.committed)
+	}
+}
@@ -33,16 +33,21 @@ type CertificateManager struct {
 	certificates map[string]*tls.Certificate // interface name -> certificate
 	defaultCert  *tls.Certificate            // fallback certificate
 	mu           sync.RWMutex
+
+	// interfaceFor maps a local address to the interface holding it
+	interfaceFor func(ip net.IP) string
 }
 
 // NewCertificateManager creates a new certificate manager
 func NewCertificateManager() *CertificateManager {
 	return &CertificateManager{
 		certificates: make(map[string]*tls.Certificate),
+		interfaceFor: interfaceForAddr,
 	}
 }
 
-// SetCertificate sets a certificate for a specific interface
+// SetCertificate sets a certificate for a specific interface. It can be
+// called while serving; new handshakes pick up the replacement.
 func (cm *CertificateManager) SetCertificate(interfaceName string, cert *tls.Certificate) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
@@ -56,13 +61,21 @@ func (cm *CertificateManager) SetDefaultCertificate(cert *tls.Certificate) {
 	cm.defaultCert = cert
 }
 
-// GetCertificate returns the appropriate certificate for a client connection
+// GetCertificate returns the appropriate certificate for a client connection.
+// The interface is identified by the local address the client connected to;
+// connections through interfaces without their own certificate get the default.
 func (cm *CertificateManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	// Future enhancement: Interface detection based on local address for multi-cert scenarios.
-	// Current design uses a single default certificate which is sufficient for most deployments.
+	if len(cm.certificates) > 0 && clientHello != nil && clientHello.Conn != nil {
+		if addr, ok := clientHello.Conn.LocalAddr().(*net.TCPAddr); ok {
+			if cert := cm.certificates[cm.interfaceFor(addr.IP)]; cert != nil {
+				return cert, nil
+			}
+		}
+	}
+
 	if cm.defaultCert != nil {
 		return cm.defaultCert, nil
 	}
@@ -70,6 +83,27 @@ func (cm *CertificateManager) GetCertificate(clientHello *tls.ClientHelloInfo) (
 	return nil, fmt.Errorf("no certificate available")
 }
 
+// interfaceForAddr returns the name of the interface holding ip, or "" if
+// no interface does.
+func interfaceForAddr(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
 // GenerateSelfSigned generates a self-signed certificate
 func GenerateSelfSigned(certFile, keyFile string, validDays int) error {
 	// Generate private key
@@ -2,6 +2,7 @@ package tls
 
 import (
 	"crypto/tls"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -83,6 +84,58 @@ func TestCertificateManager_SetInterfaceCertificate(t *testing.T) {
 	cm.mu.RUnlock()
 }
 
+// localConn reports a fixed local address, as an accepted TCP connection would.
+type localConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c localConn) LocalAddr() net.Addr { return c.local }
+
+func TestCertificateManager_SelectsByInterface(t *testing.T) {
+	cm := NewCertificateManager()
+	cm.interfaceFor = func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return "wan"
+		}
+		return "lan"
+	}
+
+	tmpDir := t.TempDir()
+	load := func(name string) *tls.Certificate {
+		certFile := filepath.Join(tmpDir, name+".crt")
+		keyFile := filepath.Join(tmpDir, name+".key")
+		if err := GenerateSelfSigned(certFile, keyFile, 1); err != nil {
+			t.Fatalf("Failed to generate test cert: %v", err)
+		}
+		cert, err := LoadCertificate(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("Failed to load test cert: %v", err)
+		}
+		return cert
+	}
+	defaultCert, wanCert := load("default"), load("wan")
+	cm.SetDefaultCertificate(defaultCert)
+	cm.SetCertificate("wan", wanCert)
+
+	hello := func(ip string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{Conn: localConn{local: &net.TCPAddr{IP: net.ParseIP(ip), Port: 443}}}
+	}
+	if got, _ := cm.GetCertificate(hello("192.0.2.1")); got != wanCert {
+		t.Error("connection through wan did not get the wan certificate")
+	}
+	if got, _ := cm.GetCertificate(hello("10.0.0.1")); got != defaultCert {
+		t.Error("connection through lan did not get the default certificate")
+	}
+
+	// Replacing the certificate takes effect for the next handshake
+	renewed := load("renewed")
+	cm.SetCertificate("wan", renewed)
+	if got, _ := cm.GetCertificate(hello("192.0.2.1")); got != renewed {
+		t.Error("renewed wan certificate not served")
+	}
+}
+
 func TestGenerateSelfSigned(t *testing.T) {
 	tmpDir := t.TempDir()
 	certFile := filepath.Join(tmpDir, "gen.crt")
@@ -163,6 +163,18 @@ func main() {
 		}
 
 	case "show":
+		if len(os.Args) > 2 && os.Args[2] == "ospf" {
+			section := ""
+			if len(os.Args) > 3 {
+				section = os.Args[3]
+			}
+			if err := cmd.RunShowOSPF(section); err != nil {
+				printer.Fprintf(os.Stderr, "Show failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		showFlags := flag.NewFlagSet("show", flag.ExitOnError)
 		summary := showFlags.Bool("summary", false, "Show configuration summary")
 		showFlags.BoolVar(summary, "s", false, "Show configuration summary (short)")
@@ -485,6 +497,7 @@ Utility Commands:
             Options: --verbose (-v)
   show      Display firewall rules
             Options: --summary (-s), --remote (-r) <url>, --api-key (-k) <key>
+            show ospf [neighbors|interfaces|routes|database]: OSPF state
   log       View and stream system logs
             Options: -f (follow), -n (lines), --remote <url>
   diff      Compare two configuration files